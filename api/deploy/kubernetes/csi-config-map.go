@@ -21,6 +21,10 @@ type ClusterInfo struct {
 	ClusterID string `json:"clusterID"`
 	// Monitors is monitor list for corresponding cluster ID
 	Monitors []string `json:"monitors"`
+	// MonDNSSRVName is the name of the DNS SRV records of the monitors,
+	// like the mon_dns_srv_name Ceph option. It is used when the Monitors
+	// are empty, the records are looked up again periodically.
+	MonDNSSRVName string `json:"monDNSSRVName"`
 	// CephFS contains CephFS specific options
 	CephFS CephFS `json:"cephFS"`
 	// RBD Contains RBD specific options
@@ -29,6 +33,12 @@ type ClusterInfo struct {
 	NFS NFS `json:"nfs"`
 	// Read affinity map options
 	ReadAffinity ReadAffinity `json:"readAffinity"`
+	// CephOptions are Ceph client options (like "rados_osd_op_timeout")
+	// that are set on the connections to the cluster
+	CephOptions map[string]string `json:"cephOptions"`
+	// SnapshotHooks is a webhook that is called before and after the
+	// snapshots of volumes are taken
+	SnapshotHooks SnapshotHooks `json:"snapshotHooks"`
 }
 
 type CephFS struct {
@@ -36,6 +46,9 @@ type CephFS struct {
 	NetNamespaceFilePath string `json:"netNamespaceFilePath"`
 	// SubvolumeGroup contains the name of the SubvolumeGroup for CSI volumes
 	SubvolumeGroup string `json:"subvolumeGroup"`
+	// SubvolumeGroupCount spreads new subvolumes over this number of
+	// subvolumegroups, named <SubvolumeGroup>-<index>
+	SubvolumeGroupCount int `json:"subvolumeGroupCount"`
 	// RadosNamespace is a rados namespace in the filesystem metadata pool
 	RadosNamespace string `json:"radosNamespace"`
 	// KernelMountOptions contains the kernel mount options for CephFS volumes
@@ -50,6 +63,31 @@ type RBD struct {
 	RadosNamespace string `json:"radosNamespace"`
 	// RBD mirror daemons running in the ceph cluster.
 	MirrorDaemonCount int `json:"mirrorDaemonCount"`
+	// AutoCreateRadosNamespace creates the RadosNamespace in the pool when
+	// it does not exist yet.
+	AutoCreateRadosNamespace bool `json:"autoCreateRadosNamespace"`
+	// RadosNamespaceQuota is the capacity (like "100Gi") that the images in
+	// the RadosNamespace of a pool may provision together.
+	RadosNamespaceQuota string `json:"radosNamespaceQuota"`
+	// Tenants maps Kubernetes namespaces to the clusterID and Ceph user
+	// that are used for the volumes of PVCs in those namespaces.
+	Tenants []Tenant `json:"tenants"`
+}
+
+// Tenant confines the volumes of PVCs in a set of Kubernetes namespaces to
+// the RADOS namespace of another clusterID, and provisions them with a Ceph
+// user that only has access to that RADOS namespace.
+type Tenant struct {
+	// Namespaces are the Kubernetes namespaces of the PVCs of the tenant
+	Namespaces []string `json:"namespaces"`
+	// ClusterID is the configuration that is used for the volumes of the
+	// tenant, it normally sets the RadosNamespace of the tenant
+	ClusterID string `json:"clusterID"`
+	// SecretName is the name of the Secret with the userID and userKey of
+	// the Ceph user of the tenant
+	SecretName string `json:"secretName"`
+	// SecretNamespace is the namespace of the Secret
+	SecretNamespace string `json:"secretNamespace"`
 }
 
 type NFS struct {
@@ -57,6 +95,19 @@ type NFS struct {
 	NetNamespaceFilePath string `json:"netNamespaceFilePath"`
 }
 
+// SnapshotHooks configures the webhook that is called to quiesce the
+// applications before a snapshot is taken, and to resume them afterwards.
+type SnapshotHooks struct {
+	// URL of the webhook, no webhook is called when it is empty
+	URL string `json:"url"`
+	// Timeout of a call to the webhook (like "30s"), defaults to 30 seconds
+	Timeout string `json:"timeout"`
+	// FailurePolicy is "Fail" to abort the snapshot when the webhook fails
+	// before the snapshot is taken (the default), or "Ignore" to take the
+	// snapshot anyway
+	FailurePolicy string `json:"failurePolicy"`
+}
+
 type ReadAffinity struct {
 	Enabled             bool     `json:"enabled"`
 	CrushLocationLabels []string `json:"crushLocationLabels"`
@@ -17,21 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/ceph/ceph-csi/internal/admin"
 	"github.com/ceph/ceph-csi/internal/cephfs"
 	"github.com/ceph/ceph-csi/internal/controller"
+	"github.com/ceph/ceph-csi/internal/controller/mirrorpeer"
 	"github.com/ceph/ceph-csi/internal/controller/persistentvolume"
 	"github.com/ceph/ceph-csi/internal/controller/volumegroup"
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/liveness"
 	nfsdriver "github.com/ceph/ceph-csi/internal/nfs/driver"
 	rbddriver "github.com/ceph/ceph-csi/internal/rbd/driver"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/featuregate"
 	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/webhook"
 
 	"k8s.io/klog/v2"
 )
@@ -42,6 +49,8 @@ const (
 	nfsType        = "nfs"
 	livenessType   = "liveness"
 	controllerType = "controller"
+	webhookType    = "webhook"
+	adminType      = "admin"
 
 	rbdDefaultName      = "rbd.csi.ceph.com"
 	cephFSDefaultName   = "cephfs.csi.ceph.com"
@@ -62,7 +71,7 @@ var conf util.Config
 
 func init() {
 	// common flags
-	flag.StringVar(&conf.Vtype, "type", "", "driver type [rbd|cephfs|nfs|liveness|controller]")
+	flag.StringVar(&conf.Vtype, "type", "", "driver type [rbd|cephfs|nfs|liveness|controller|webhook|admin]")
 	flag.StringVar(&conf.Endpoint, "endpoint", "unix:///tmp/csi.sock", "CSI endpoint")
 	flag.StringVar(&conf.DriverName, "drivername", "", "name of the driver")
 	flag.StringVar(&conf.DriverNamespace, "drivernamespace", defaultNS, "namespace in which driver is deployed")
@@ -82,6 +91,158 @@ func init() {
 		"",
 		"list of Kubernetes node labels, that determines the topology"+
 			" domain the node belongs to, separated by ','")
+	flag.StringVar(
+		&conf.DomainLabelAliases,
+		"domainlabel-aliases",
+		"",
+		"list of renamed Kubernetes node labels of the topology domains, in the format"+
+			" '<old-label>=<new-label>' separated by ','")
+	flag.Var(
+		featuregate.DefaultGate,
+		"feature-gates",
+		"comma separated list of <feature>=true|false pairs to enable experimental features: "+
+			strings.Join(featuregate.DefaultGate.KnownFeatures(), ", "))
+	flag.DurationVar(
+		&conf.UsageReportInterval,
+		"usage-report-interval",
+		0,
+		"interval to aggregate the provisioned and used capacity per namespace as metrics, 0 disables it")
+	flag.StringVar(
+		&conf.UsageReportConfigMap,
+		"usage-report-configmap",
+		"",
+		"name of the ConfigMap in the driver namespace to write the usage report to")
+	flag.DurationVar(
+		&conf.JournalStatsInterval,
+		"journal-stats-interval",
+		0,
+		"interval to count the volumes, snapshots and groups in the journal of every pool as metrics, 0 disables it")
+	flag.DurationVar(
+		&conf.StatusReportInterval,
+		"status-report-interval",
+		0,
+		"interval to write the version, features, cluster readiness and volume counts to a ConfigMap, 0 disables it")
+	flag.StringVar(
+		&conf.StatusReportConfigMap,
+		"status-report-configmap",
+		"",
+		"name of the ConfigMap in the driver namespace to write the status to, defaults to <drivername>-status")
+	flag.Float64Var(
+		&conf.SnapshotPoolUsageThreshold,
+		"snapshot-pool-usage-threshold",
+		0,
+		"reject snapshots when the projected pool usage exceeds this fraction (e.g. 0.85), 0 disables the check")
+	flag.UintVar(
+		&conf.MaxSnapshotsPerVolume,
+		"max-snapshots-per-volume",
+		0,
+		"maximum number of snapshots of a single volume, 0 means unlimited")
+	flag.IntVar(
+		&conf.GRPCMaxMessageSize,
+		"grpc-max-message-size",
+		0,
+		"maximum size in bytes of the gRPC messages of the CSI endpoint, 0 keeps the default of 4MiB")
+	flag.Int64Var(
+		&conf.ListMaxEntries,
+		"list-max-entries",
+		0,
+		"maximum number of entries in a ListVolumes response, 0 means unlimited")
+	flag.DurationVar(
+		&conf.ClusterReadinessInterval,
+		"cluster-readiness-interval",
+		0,
+		"interval to check the connectivity to the clusters of the StorageClasses for the /readyz endpoint, 0 disables it")
+	flag.BoolVar(
+		&conf.ValidateClusters,
+		"validate-clusters",
+		false,
+		"check the clusters, pools and journals of the StorageClasses once at start for the /readyz endpoint")
+	flag.StringVar(
+		&conf.ClusterReadinessSelector,
+		"cluster-readiness-selector",
+		"",
+		"label selector for the StorageClasses that are checked for the /readyz endpoint")
+	flag.DurationVar(
+		&conf.KMSHealthInterval,
+		"kms-health-interval",
+		0,
+		"interval to check the connectivity to the configured KMS, CreateVolume fails fast for an unavailable KMS")
+	flag.BoolVar(
+		&conf.CephFSClientMetrics,
+		"cephfs-client-metrics",
+		false,
+		"publish the latency and cap counters of the CephFS kernel client of the staged volumes as metrics")
+	flag.DurationVar(
+		&conf.RBDIOStatsInterval,
+		"rbd-iostats-interval",
+		0,
+		"interval to sample the IO rates of the RBD images from `rbd perf image stats` as metrics, 0 disables it")
+	flag.DurationVar(
+		&conf.RBDImageReconcileInterval,
+		"rbd-image-reconcile-interval",
+		0,
+		"interval to re-apply the imageConfig of the StorageClasses to the RBD images of the volumes, 0 disables it")
+	flag.DurationVar(
+		&conf.RBDTempCloneReapInterval,
+		"rbd-temp-clone-reap-interval",
+		0,
+		"interval to delete temporary clone images that were left behind by failed clone operations, 0 disables it")
+	flag.DurationVar(
+		&conf.RBDTempCloneTTL,
+		"rbd-temp-clone-ttl",
+		time.Hour,
+		"age of a temporary clone image of a volume without reservation after which it is deleted")
+	flag.BoolVar(
+		&conf.NFSVerifyExports,
+		"nfs-verify-exports",
+		false,
+		"verify the NFS-export of a volume in ControllerPublishVolume, and create it again when it is missing")
+	flag.BoolVar(
+		&conf.NFSMigrateExports,
+		"nfs-migrate-exports",
+		false,
+		"add an NFS-export with the current path in ControllerPublishVolume for volumes of older releases")
+	flag.DurationVar(
+		&conf.StuckLockThreshold,
+		"stuck-lock-threshold",
+		0,
+		"report locks of volumes, snapshots and volume groups that are held longer than this as stuck, 0 disables it")
+	flag.DurationVar(
+		&conf.ReclaimSpaceMinInterval,
+		"reclaimspace-min-interval",
+		0,
+		"skip ReclaimSpace operations on volumes that reclaimed space less than this long ago (RBD only), 0 disables it")
+	flag.UintVar(
+		&conf.ReclaimSpaceBatchConcurrency,
+		"reclaimspace-batch-concurrency",
+		0,
+		"number of staged volumes to fstrim at a time with the BatchReclaimSpace service of CSI-Addons (RBD only), "+
+			"0 disables it")
+	flag.DurationVar(
+		&conf.PauseIOMaxTTL,
+		"pauseio-max-ttl",
+		0,
+		"maximum time to pause the IO of a volume with the PauseIO service of the nodeplugin (RBD only), 0 disables it")
+	flag.BoolVar(
+		&conf.EnableFailoverDrill,
+		"enable-failover-drill",
+		false,
+		"clone secondary images for failover tests with the FailoverDrill service of the admin endpoint (RBD only)")
+	flag.DurationVar(
+		&conf.PassphraseCacheTTL,
+		"passphrase-cache-ttl",
+		0,
+		"keep the passphrases of encrypted volumes in locked memory of the nodeplugin for this long, 0 disables it")
+	flag.UintVar(
+		&conf.ReadAheadKB,
+		"read-ahead-kb",
+		0,
+		"readahead in KiB of staged volumes without readAheadKB StorageClass parameter, 0 keeps the kernel default")
+	flag.DurationVar(
+		&conf.VolumeStatsCacheMaxAge,
+		"volume-stats-cache-max-age",
+		0,
+		"return cached volume stats for this long, and refresh them in the background afterwards, 0 disables the cache")
 	flag.BoolVar(&conf.EnableReadAffinity, "enable-read-affinity", false, "enable read affinity")
 	flag.StringVar(
 		&conf.CrushLocationLabels,
@@ -111,6 +272,12 @@ func init() {
 		"fusemountoptions",
 		"",
 		"Comma separated string of mount options accepted by ceph-fuse mounter")
+	flag.DurationVar(
+		&conf.MountProbeTimeout,
+		"mount-probe-timeout",
+		0,
+		"probe a staged CephFS volume with a write and read, or a statfs for read-only volumes, "+
+			"and fail NodeStageVolume when it does not succeed within this time, 0 disables the probe")
 
 	// liveness/profile metrics related flags
 	flag.IntVar(&conf.MetricsPort, "metricsport", 8080, "TCP port for liveness/profile metrics requests")
@@ -126,6 +293,22 @@ func init() {
 		"logslowopinterval",
 		time.Second*30,
 		"how often to inform about slow gRPC calls")
+	flag.StringVar(
+		&conf.SlowOpThresholds,
+		"slowop-thresholds",
+		"",
+		"log completed gRPC calls that took longer than the threshold of their method as slow, in the format"+
+			" '<method>=<duration>' separated by ',', the method '*' sets the threshold of all other methods")
+	flag.UintVar(
+		&conf.NodeStageConcurrency,
+		"node-stage-concurrency",
+		0,
+		"number of NodeStageVolume, NodeUnstageVolume and NodeExpandVolume calls processed at a time, 0 is unlimited")
+	flag.UintVar(
+		&conf.NodePublishConcurrency,
+		"node-publish-concurrency",
+		0,
+		"number of NodePublishVolume and NodeUnpublishVolume calls processed at a time, 0 is unlimited")
 
 	flag.UintVar(
 		&conf.RbdHardMaxCloneDepth,
@@ -153,8 +336,39 @@ func init() {
 	flag.BoolVar(&conf.Version, "version", false, "Print cephcsi version information")
 	flag.BoolVar(&conf.EnableProfiling, "enableprofiling", false, "enable go profiling")
 
+	// admission webhook related flags
+	flag.IntVar(&conf.WebhookPort, "webhookport", 9443, "TCP port for the admission webhook server")
+	flag.StringVar(
+		&conf.WebhookCertDir,
+		"webhookcertdir",
+		"",
+		"directory containing tls.crt and tls.key for the admission webhook server")
+
 	// CSI-Addons configuration
 	flag.StringVar(&conf.CSIAddonsEndpoint, "csi-addons-endpoint", "unix:///tmp/csi-addons.sock", "CSI-Addons endpoint")
+	flag.StringVar(
+		&conf.CSIAddonsTCPEndpoint,
+		"csi-addons-tcp-endpoint",
+		"",
+		"optional CSI-Addons TCP endpoint with mutual TLS (e.g. tcp://0.0.0.0:9070)")
+	flag.StringVar(&conf.CSIAddonsTLSCertFile, "csi-addons-tls-cert-file", "",
+		"certificate of the CSI-Addons TCP endpoint")
+	flag.StringVar(&conf.CSIAddonsTLSKeyFile, "csi-addons-tls-key-file", "",
+		"private key of the CSI-Addons TCP endpoint")
+	flag.StringVar(&conf.CSIAddonsTLSCAFile, "csi-addons-tls-ca-file", "",
+		"CA certificates to verify the clients of the CSI-Addons TCP endpoint")
+
+	// admin service
+	flag.StringVar(
+		&conf.AdminEndpoint,
+		"admin-endpoint",
+		"",
+		"UNIX domain socket of the admin services of the provisioner and nodeplugin, empty disables it (RBD and NFS)")
+	flag.StringVar(&conf.AdminCall, "admin-call", "", "method of the admin service to call with --type=admin")
+	flag.StringVar(&conf.AdminRequest, "admin-request", "{}", "JSON request of the --admin-call method")
+
+	flag.StringVar(&conf.InstancesFile, "instances", "",
+		"JSON file with additional instances of the driver that are served by the process (RBD only)")
 
 	klog.InitFlags(nil)
 	if err := flag.Set("logtostderr", "true"); err != nil {
@@ -205,6 +419,11 @@ func main() {
 		logAndExit("driver type not specified")
 	}
 
+	if conf.Vtype == adminType {
+		callAdmin(&conf)
+		os.Exit(0)
+	}
+
 	dname := getDriverName()
 	err := util.ValidateDriverName(dname)
 	if err != nil {
@@ -213,7 +432,10 @@ func main() {
 
 	setPIDLimit(&conf)
 
-	if conf.EnableProfiling || conf.Vtype == livenessType {
+	if conf.EnableProfiling || conf.UsageReportInterval != 0 || conf.JournalStatsInterval != 0 ||
+		conf.ClusterReadinessInterval != 0 || conf.ValidateClusters || conf.KMSHealthInterval != 0 ||
+		conf.CephFSClientMetrics || conf.RBDIOStatsInterval != 0 || conf.RBDImageReconcileInterval != 0 ||
+		conf.RBDTempCloneReapInterval != 0 || conf.Vtype == livenessType {
 		// validate metrics endpoint
 		conf.MetricsIP = os.Getenv("POD_IP")
 
@@ -231,6 +453,40 @@ func main() {
 		log.FatalLogMsg("failed to write ceph configuration file (%v)", err)
 	}
 
+	if conf.GRPCMaxMessageSize < 0 || conf.ListMaxEntries < 0 {
+		logAndExit("grpc-max-message-size and list-max-entries flag values should not be negative")
+	}
+
+	if conf.PassphraseCacheTTL < 0 {
+		logAndExit("passphrase-cache-ttl flag value should not be negative")
+	}
+
+	if err = util.SetTopologyAliases(conf.DomainLabelAliases); err != nil {
+		logAndExit(err.Error())
+	}
+
+	if _, err = csicommon.ParseSlowOpThresholds(conf.SlowOpThresholds); err != nil {
+		logAndExit(err.Error())
+	}
+
+	if conf.SnapshotPoolUsageThreshold < 0 || conf.SnapshotPoolUsageThreshold > 1 {
+		logAndExit("snapshot-pool-usage-threshold flag value should be between 0 and 1")
+	}
+
+	if conf.InstancesFile != "" {
+		if conf.Vtype != rbdType {
+			logAndExit("instances flag is only supported by the rbd driver")
+		}
+		conf.Instances, err = util.ReadDriverInstances(conf.InstancesFile, &conf)
+		if err != nil {
+			logAndExit(err.Error())
+		}
+	}
+
+	if conf.StatusReportConfigMap == "" {
+		conf.StatusReportConfigMap = dname + "-status"
+	}
+
 	log.DefaultLog("Starting driver type: %v with name: %v", conf.Vtype, dname)
 	switch conf.Vtype {
 	case rbdType:
@@ -264,6 +520,9 @@ func main() {
 		if err != nil {
 			logAndExit(err.Error())
 		}
+
+	case webhookType:
+		webhook.Run(&conf)
 	}
 
 	os.Exit(0)
@@ -296,6 +555,7 @@ func initControllers() {
 	// Add list of controller here.
 	persistentvolume.Init()
 	volumegroup.Init()
+	mirrorpeer.Init()
 }
 
 func validateCloneDepthFlag(conf *util.Config) {
@@ -323,6 +583,23 @@ func validateMaxSnapshotFlag(conf *util.Config) {
 	}
 }
 
+// callAdmin sends the request to the method of the admin service, and prints
+// the response.
+func callAdmin(conf *util.Config) {
+	if conf.AdminEndpoint == "" || conf.AdminCall == "" {
+		logAndExit("admin-endpoint and admin-call are required for the admin type")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	resp, err := admin.Call(ctx, conf.AdminEndpoint, conf.AdminCall, conf.AdminRequest)
+	cancel()
+	if err != nil {
+		logAndExit(err.Error())
+	}
+
+	fmt.Println(resp)
+}
+
 func logAndExit(msg string) {
 	klog.Errorln(msg)
 	os.Exit(1)
@@ -0,0 +1,132 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin contains the gRPC service for support engineers, that repairs
+// the journal of volumes without restarting the driver or editing omaps by
+// hand. The service is only served on a UNIX domain socket in the container
+// of the provisioner. The messages are encoded as JSON, there are no protobuf
+// definitions for this service.
+package admin
+
+import (
+	"context"
+
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+
+	"google.golang.org/grpc"
+)
+
+// serviceName is the full name of the gRPC service.
+const serviceName = "cephcsi.admin.v1.Admin"
+
+const (
+	// MethodRevalidateVolume checks the journal and the image of a volume.
+	MethodRevalidateVolume = "RevalidateVolume"
+	// MethodRebuildJournalEntry recreates the journal entry of a volume
+	// from its image.
+	MethodRebuildJournalEntry = "RebuildJournalEntry"
+	// MethodListOrphanedReservations lists the reservations of a journal
+	// pool that do not have an image.
+	MethodListOrphanedReservations = "ListOrphanedReservations"
+)
+
+// VolumeRequest selects a volume, the Secret contains the credentials to
+// access its journal and image.
+type VolumeRequest struct {
+	VolumeID        string `json:"volumeID"`
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+}
+
+// RebuildJournalEntryRequest is the request of RebuildJournalEntry.
+type RebuildJournalEntryRequest struct {
+	VolumeRequest
+	// RequestName is the name of the CreateVolume request of the volume,
+	// it defaults to the PersistentVolume name in the metadata of the
+	// image
+	RequestName string `json:"requestName,omitempty"`
+	// JournalPool is the pool of the journal of the StorageClass, it
+	// defaults to the pool of the image
+	JournalPool string `json:"journalPool,omitempty"`
+}
+
+// VolumeReport is the state of the journal and the image of a volume. A
+// volume without Problems is healthy.
+type VolumeReport struct {
+	VolumeID    string   `json:"volumeID"`
+	RequestName string   `json:"requestName,omitempty"`
+	Pool        string   `json:"pool,omitempty"`
+	ImageName   string   `json:"imageName,omitempty"`
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// ListOrphanedReservationsRequest is the request of
+// ListOrphanedReservations.
+type ListOrphanedReservationsRequest struct {
+	ClusterID       string `json:"clusterID"`
+	JournalPool     string `json:"journalPool"`
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+}
+
+// OrphanedReservation is a reservation in the journal that does not belong
+// to an image, with the Reason why.
+type OrphanedReservation struct {
+	RequestName string `json:"requestName"`
+	ImageUUID   string `json:"imageUUID"`
+	ImagePool   string `json:"imagePool,omitempty"`
+	ImageName   string `json:"imageName,omitempty"`
+	Reason      string `json:"reason"`
+}
+
+// ListOrphanedReservationsResponse is the response of
+// ListOrphanedReservations.
+type ListOrphanedReservationsResponse struct {
+	Reservations []OrphanedReservation `json:"reservations"`
+}
+
+// Service is implemented by the drivers that serve the admin service.
+type Service interface {
+	// RevalidateVolume checks that the journal entry and the image of the
+	// volume exist and point to each other.
+	RevalidateVolume(ctx context.Context, req *VolumeRequest) (*VolumeReport, error)
+	// RebuildJournalEntry recreates the missing journal entry of a volume
+	// with an existing image.
+	RebuildJournalEntry(ctx context.Context, req *RebuildJournalEntryRequest) (*VolumeReport, error)
+	// ListOrphanedReservations lists the reservations of the journal pool
+	// that do not have an image, and are not being created.
+	ListOrphanedReservations(
+		ctx context.Context,
+		req *ListOrphanedReservationsRequest,
+	) (*ListOrphanedReservationsResponse, error)
+}
+
+// serviceDesc describes the Service for the gRPC server.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Service)(nil),
+	Methods: []grpc.MethodDesc{
+		jsongrpc.UnaryMethod(serviceName, MethodRevalidateVolume, Service.RevalidateVolume),
+		jsongrpc.UnaryMethod(serviceName, MethodRebuildJournalEntry, Service.RebuildJournalEntry),
+		jsongrpc.UnaryMethod(serviceName, MethodListOrphanedReservations, Service.ListOrphanedReservations),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// Register registers the Service on the server.
+func Register(server grpc.ServiceRegistrar, svc Service) {
+	server.RegisterService(&serviceDesc, svc)
+}
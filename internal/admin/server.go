@@ -0,0 +1,197 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ErrNoUDS is returned when the endpoint is not a UNIX domain socket.
+var ErrNoUDS = errors.New("no UNIX domain socket")
+
+// Server serves the admin Service, and other services with JSON messages, on
+// a UNIX domain socket. The socket can only be used by the user of the
+// driver.
+type Server struct {
+	path   string
+	server *grpc.Server
+}
+
+// socketPath returns the path of the UNIX domain socket of the endpoint URL.
+func socketPath(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "unix" || u.Path == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoUDS, endpoint)
+	}
+
+	return u.Path, nil
+}
+
+// NewServer returns a Server that listens on the endpoint once it is started.
+// Only "unix://" endpoints are supported. The services are registered with
+// RegisterService before the Server is started.
+func NewServer(endpoint string) (*Server, error) {
+	path, err := socketPath(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		path: path,
+		server: grpc.NewServer(
+			grpc.ForceServerCodec(jsongrpc.Codec()),
+			grpc.UnaryInterceptor(logCalls)),
+	}
+
+	return s, nil
+}
+
+// RegisterService registers a service with JSON messages on the Server, the
+// Server is a grpc.ServiceRegistrar.
+func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	s.server.RegisterService(desc, impl)
+}
+
+// logCalls logs every call of the services, as they modify the journal or
+// the volumes.
+func logCalls(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	log.DefaultLog("admin call %s: %+v", info.FullMethod, req)
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.ErrorLogMsg("admin call %s failed: %v", info.FullMethod, err)
+	}
+
+	return resp, err
+}
+
+// Start listens on the socket, and serves the requests in a go-routine. The
+// socket is created in a private directory, and only moved to its path once
+// only the user of the driver can connect to it.
+func (s *Server) Start() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %q: %w", s.path, err)
+	}
+
+	// MkdirTemp creates the directory with mode 0700
+	dir, err := os.MkdirTemp(filepath.Dir(s.path), ".admin-")
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %q: %w", s.path, err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, filepath.Base(s.path))
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", s.path, err)
+	}
+	// the socket is removed by Stop, with the path it was moved to
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, 0o600)
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		listener.Close()
+
+		return fmt.Errorf("failed to restrict access to %q: %w", s.path, err)
+	}
+
+	go func() {
+		log.DefaultLog("listening for admin requests on address: %#v", listener.Addr())
+		sErr := s.server.Serve(listener)
+		if sErr != nil && !errors.Is(sErr, grpc.ErrServerStopped) {
+			log.ErrorLogMsg("failed to serve admin requests: %v", sErr)
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops the Server gracefully, and removes the socket.
+func (s *Server) Stop() {
+	s.server.GracefulStop()
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		log.ErrorLogMsg("failed to remove %q: %v", s.path, err)
+	}
+}
+
+// Call calls the method of the admin service on the endpoint with the JSON
+// request, and returns the JSON response. A method in the form
+// "<service>/<method>" calls another service that is registered on the
+// Server.
+func Call(ctx context.Context, endpoint, method, request string) (string, error) {
+	path, err := socketPath(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := grpc.NewClient("unix://"+path,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsongrpc.Codec())))
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %q: %w", endpoint, err)
+	}
+	defer conn.Close()
+
+	req := json.RawMessage(request)
+	if !json.Valid(req) {
+		return "", fmt.Errorf("request %q is not valid JSON", request)
+	}
+	service := serviceName
+	if svc, m, found := strings.Cut(method, "/"); found {
+		service, method = svc, m
+	}
+	var resp json.RawMessage
+	err = jsongrpc.Invoke(ctx, conn, service, method, &req, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	err = json.Indent(&out, resp, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("invalid response %q: %w", string(resp), err)
+	}
+
+	return out.String(), nil
+}
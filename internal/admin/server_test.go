@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeService reports every volume as healthy, and has no reservations.
+type fakeService struct{}
+
+func (fakeService) RevalidateVolume(_ context.Context, req *VolumeRequest) (*VolumeReport, error) {
+	if req.VolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID")
+	}
+
+	return &VolumeReport{VolumeID: req.VolumeID, Pool: "rbd"}, nil
+}
+
+func (fakeService) RebuildJournalEntry(_ context.Context, req *RebuildJournalEntryRequest) (*VolumeReport, error) {
+	return &VolumeReport{VolumeID: req.VolumeID, RequestName: req.RequestName}, nil
+}
+
+func (fakeService) ListOrphanedReservations(
+	context.Context,
+	*ListOrphanedReservationsRequest,
+) (*ListOrphanedReservationsResponse, error) {
+	return &ListOrphanedReservationsResponse{Reservations: []OrphanedReservation{}}, nil
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer("tcp://127.0.0.1:9000")
+	require.ErrorIs(t, err, ErrNoUDS)
+
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	endpoint := "unix://" + socket
+	s, err := NewServer(endpoint)
+	require.NoError(t, err)
+	Register(s, fakeService{})
+	require.NoError(t, s.Start())
+	defer s.Stop()
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	// the private directory the socket was created in is removed
+	entries, err := os.ReadDir(filepath.Dir(socket))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	ctx := context.TODO()
+	out, err := Call(ctx, endpoint, MethodRevalidateVolume, `{"volumeID":"vol-1"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"volumeID":"vol-1","pool":"rbd"}`, out)
+
+	out, err = Call(ctx, endpoint, MethodRebuildJournalEntry, `{"volumeID":"vol-1","requestName":"pvc-1"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"volumeID":"vol-1","requestName":"pvc-1"}`, out)
+
+	out, err = Call(ctx, endpoint, MethodListOrphanedReservations, `{"clusterID":"c","journalPool":"rbd"}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"reservations":[]}`, out)
+
+	_, err = Call(ctx, endpoint, MethodRevalidateVolume, `{}`)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = Call(ctx, endpoint, "Unknown", `{}`)
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// methods of other services are called by their full name
+	_, err = Call(ctx, endpoint, serviceName+"/"+MethodRevalidateVolume, `{}`)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = Call(ctx, endpoint, "cephcsi.test.v1.Unknown/"+MethodRevalidateVolume, `{}`)
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = Call(ctx, endpoint, MethodRevalidateVolume, `not json`)
+	require.Error(t, err)
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clientmetrics exposes the performance counters of the CephFS
+// kernel client for the staged volumes. The kernel client publishes the
+// counters of every client instance in debugfs, a volume is assigned the
+// client instance that was created when it was mounted.
+package clientmetrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DebugfsRoot is the directory with a subdirectory "<fsid>.client<id>" per
+// kernel client instance.
+const DebugfsRoot = "/sys/kernel/debug/ceph"
+
+// latencyStats are the counters of an operation type in the latency table.
+type latencyStats struct {
+	total      float64
+	avgSeconds float64
+	maxSeconds float64
+}
+
+// capStats are the counters of a row of the caps table.
+type capStats struct {
+	hits   float64
+	misses float64
+}
+
+// clientStats are the counters of a client instance.
+type clientStats struct {
+	latency map[string]latencyStats
+	caps    map[string]capStats
+}
+
+// Collector is a prometheus.Collector for the counters of the client
+// instances of the tracked volumes.
+type Collector struct {
+	root string
+
+	mu      sync.RWMutex
+	volumes map[string]string
+
+	operations  *prometheus.Desc
+	avgLatency  *prometheus.Desc
+	maxLatency  *prometheus.Desc
+	capHits     *prometheus.Desc
+	capMisses   *prometheus.Desc
+	scrapeError *prometheus.Desc
+}
+
+// NewCollector returns a Collector for the client instances in root.
+func NewCollector(root string) *Collector {
+	name := func(n string) string {
+		return prometheus.BuildFQName("csi", "cephfs_client", n)
+	}
+
+	return &Collector{
+		root:    root,
+		volumes: map[string]string{},
+		operations: prometheus.NewDesc(name("operations_total"),
+			"Number of operations of the CephFS kernel client of the volume.",
+			[]string{"volume_id", "operation"}, nil),
+		avgLatency: prometheus.NewDesc(name("latency_avg_seconds"),
+			"Average latency of the operations of the CephFS kernel client of the volume.",
+			[]string{"volume_id", "operation"}, nil),
+		maxLatency: prometheus.NewDesc(name("latency_max_seconds"),
+			"Maximum latency of the operations of the CephFS kernel client of the volume.",
+			[]string{"volume_id", "operation"}, nil),
+		capHits: prometheus.NewDesc(name("cap_hits_total"),
+			"Number of cap and dentry lease hits of the CephFS kernel client of the volume.",
+			[]string{"volume_id", "type"}, nil),
+		capMisses: prometheus.NewDesc(name("cap_misses_total"),
+			"Number of cap and dentry lease misses of the CephFS kernel client of the volume.",
+			[]string{"volume_id", "type"}, nil),
+		scrapeError: prometheus.NewDesc(name("scrape_error"),
+			"Whether the counters of the CephFS kernel client of the volume could not be read (1) or not (0).",
+			[]string{"volume_id"}, nil),
+	}
+}
+
+// Register returns a Collector for the client instances in DebugfsRoot, that
+// is registered with the default prometheus registry.
+func Register() (*Collector, error) {
+	c := NewCollector(DebugfsRoot)
+
+	err := prometheus.Register(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register CephFS client metrics: %w", err)
+	}
+
+	return c, nil
+}
+
+// ListClients returns the client instances in the root of the Collector. No
+// client instances are returned when debugfs is not mounted.
+func (c *Collector) ListClients() ([]string, error) {
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to list CephFS clients in %q: %w", c.root, err)
+	}
+
+	clients := []string{}
+	for _, e := range entries {
+		if e.IsDir() && strings.Contains(e.Name(), ".client") {
+			clients = append(clients, e.Name())
+		}
+	}
+
+	return clients, nil
+}
+
+// Track assigns the client instance that is not part of before to the
+// volume. Mounts that reuse an existing client instance share it with other
+// volumes, the volume is not tracked then, and false is returned.
+func (c *Collector) Track(volumeID string, before []string) (bool, error) {
+	after, err := c.ListClients()
+	if err != nil {
+		return false, err
+	}
+
+	added := slices.DeleteFunc(after, func(client string) bool {
+		return slices.Contains(before, client)
+	})
+	if len(added) != 1 {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	c.volumes[volumeID] = added[0]
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// Untrack stops reporting the counters of the volume.
+func (c *Collector) Untrack(volumeID string) {
+	c.mu.Lock()
+	delete(c.volumes, volumeID)
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.operations
+	ch <- c.avgLatency
+	ch <- c.maxLatency
+	ch <- c.capHits
+	ch <- c.capMisses
+	ch <- c.scrapeError
+}
+
+// Collect implements prometheus.Collector, the counters are read from
+// debugfs on every scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	volumes := make(map[string]string, len(c.volumes))
+	for volumeID, client := range c.volumes {
+		volumes[volumeID] = client
+	}
+	c.mu.RUnlock()
+
+	for volumeID, client := range volumes {
+		stats, err := readClientStats(filepath.Join(c.root, client))
+		if err != nil {
+			ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 1, volumeID)
+
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 0, volumeID)
+
+		for op, l := range stats.latency {
+			ch <- prometheus.MustNewConstMetric(c.operations, prometheus.CounterValue, l.total, volumeID, op)
+			ch <- prometheus.MustNewConstMetric(c.avgLatency, prometheus.GaugeValue, l.avgSeconds, volumeID, op)
+			ch <- prometheus.MustNewConstMetric(c.maxLatency, prometheus.GaugeValue, l.maxSeconds, volumeID, op)
+		}
+		for t, s := range stats.caps {
+			ch <- prometheus.MustNewConstMetric(c.capHits, prometheus.CounterValue, s.hits, volumeID, t)
+			ch <- prometheus.MustNewConstMetric(c.capMisses, prometheus.CounterValue, s.misses, volumeID, t)
+		}
+	}
+}
+
+// readClientStats reads the counters of the client instance in dir. Newer
+// kernels have a "metrics" directory with a file per table, older kernels
+// have a single "metrics" file with all tables.
+func readClientStats(dir string) (*clientStats, error) {
+	var data []byte
+	for _, name := range []string{"metrics/latency", "metrics/caps"} {
+		b, err := os.ReadFile(filepath.Join(dir, name)) // #nosec:G304, path of a client instance.
+		if err != nil {
+			data = nil
+
+			break
+		}
+		data = append(data, b...)
+	}
+
+	if data == nil {
+		b, err := os.ReadFile(filepath.Join(dir, "metrics")) // #nosec:G304, path of a client instance.
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metrics of CephFS client %q: %w", dir, err)
+		}
+		data = b
+	}
+
+	return parseClientStats(data), nil
+}
+
+// parseClientStats parses the latency and caps tables of the metrics in
+// debugfs. A table starts with a header line "item total ...", followed by a
+// line of dashes and a row per item. Rows of unknown tables are ignored.
+func parseClientStats(data []byte) *clientStats {
+	stats := &clientStats{
+		latency: map[string]latencyStats{},
+		caps:    map[string]capStats{},
+	}
+
+	var header []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		if fields[0] == "item" {
+			header = fields
+
+			continue
+		}
+		if len(fields) != len(header) {
+			continue
+		}
+
+		row := map[string]float64{}
+		for i := 1; i < len(fields); i++ {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			row[header[i]] = value
+		}
+
+		switch {
+		case slices.Contains(header, "avg_lat(us)"):
+			stats.latency[fields[0]] = latencyStats{
+				total:      row["total"],
+				avgSeconds: row["avg_lat(us)"] / 1e6,
+				maxSeconds: row["max_lat(us)"] / 1e6,
+			}
+		case slices.Contains(header, "hit"):
+			stats.caps[fields[0]] = capStats{
+				hits:   row["hit"],
+				misses: row["miss"],
+			}
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clientmetrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	latencyTable = `item          total       avg_lat(us)     min_lat(us)     max_lat(us)     stdev(us)
+-----------------------------------------------------------------------------------
+read          10          1500            200             4000            800
+write         4           2500            1000            6000            1200
+metadata      3           1191            263             2376            1044
+`
+	capsTable = `item          total           miss            hit
+-------------------------------------------------
+d_lease       11              1               28
+caps          11              2               1466
+`
+)
+
+func TestParseClientStats(t *testing.T) {
+	t.Parallel()
+
+	stats := parseClientStats([]byte(latencyTable + "\n" + capsTable))
+	require.Len(t, stats.latency, 3)
+	require.Equal(t, latencyStats{total: 10, avgSeconds: 0.0015, maxSeconds: 0.004}, stats.latency["read"])
+	require.Equal(t, capStats{hits: 1466, misses: 2}, stats.caps["caps"])
+	require.Equal(t, capStats{hits: 28, misses: 1}, stats.caps["d_lease"])
+
+	stats = parseClientStats([]byte("item total\n---\nunknown abc\n"))
+	require.Empty(t, stats.latency)
+	require.Empty(t, stats.caps)
+}
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	c := NewCollector(root)
+
+	before, err := c.ListClients()
+	require.NoError(t, err)
+	require.Empty(t, before)
+
+	client := filepath.Join(root, "0fd1c7a4-2cb8-4d1a-a3f6-0e9b7f2e0d3a.client4235")
+	require.NoError(t, os.MkdirAll(filepath.Join(client, "metrics"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(client, "metrics", "latency"), []byte(latencyTable), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(client, "metrics", "caps"), []byte(capsTable), 0o600))
+
+	tracked, err := c.Track("volume-1", before)
+	require.NoError(t, err)
+	require.True(t, tracked)
+
+	// the mount of volume-2 reused the client instance of volume-1
+	tracked, err = c.Track("volume-2", []string{filepath.Base(client)})
+	require.NoError(t, err)
+	require.False(t, tracked)
+
+	// 3 operations with 3 metrics, 2 cap types with 2 metrics, scrape_error
+	require.Equal(t, 3*3+2*2+1, testutil.CollectAndCount(c))
+
+	c.Untrack("volume-1")
+	require.Equal(t, 0, testutil.CollectAndCount(c))
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"syscall"
 
 	"github.com/ceph/ceph-csi/internal/cephfs/core"
@@ -29,6 +30,7 @@ import (
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/kms"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/fscrypt"
 	"github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
 	rterrors "github.com/ceph/ceph-csi/internal/util/reftracker/errors"
@@ -45,6 +47,10 @@ import (
 // controller server spec.
 type ControllerServer struct {
 	*csicommon.DefaultControllerServer
+	// LockManager keeps the VolumeLocks, SnapshotLocks and VolumeGroupLocks,
+	// and acquires locks of multiple levels in a fixed order.
+	LockManager *util.LockManager
+
 	// A map storing all volumes with ongoing operations so that additional operations
 	// for that same volume (as defined by VolumeID/volume name) return an Aborted error
 	VolumeLocks *util.VolumeLocks
@@ -65,6 +71,14 @@ type ControllerServer struct {
 
 	// Set metadata on volume
 	SetMetadata bool
+
+	// SnapshotPoolUsageThreshold is the fraction of the pool capacity that
+	// may be in use after creating a snapshot, 0 disables the check.
+	SnapshotPoolUsageThreshold float64
+
+	// MaxSnapshotsPerVolume is the default limit of snapshots of a volume,
+	// 0 means unlimited.
+	MaxSnapshotsPerVolume uint
 }
 
 // createBackingVolume creates the backing subvolume and on any error cleans up any created entities.
@@ -80,8 +94,15 @@ func (cs *ControllerServer) createBackingVolume(
 	volClient := core.NewSubVolume(volOptions.GetConnection(),
 		&volOptions.SubVolume, volOptions.ClusterID, cs.ClusterName, cs.SetMetadata)
 
+	// the subvolumegroups that volumes are spread over are created on demand
+	if volOptions.SubvolumeGroupCount > 1 && !volOptions.BackingSnapshot {
+		if err = volClient.CreateSubvolumeGroup(ctx); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	if sID != nil {
-		err = parentVolOpt.CopyEncryptionConfig(ctx, volOptions, sID.SnapshotID, vID.VolumeID)
+		err = parentVolOpt.InheritEncryptionKey(ctx, volOptions, sID.SnapshotID, vID.VolumeID)
 		if err != nil {
 			return status.Error(codes.Internal, err.Error())
 		}
@@ -90,7 +111,7 @@ func (cs *ControllerServer) createBackingVolume(
 	}
 
 	if parentVolOpt != nil {
-		err = parentVolOpt.CopyEncryptionConfig(ctx, volOptions, pvID.VolumeID, vID.VolumeID)
+		err = parentVolOpt.InheritEncryptionKey(ctx, volOptions, pvID.VolumeID, vID.VolumeID)
 		if err != nil {
 			return status.Error(codes.Internal, err.Error())
 		}
@@ -256,6 +277,12 @@ func checkValidCreateVolumeRequest(
 	return nil
 }
 
+// isRestoreTooSmall returns true when a clone can not get the requested size,
+// because the source or its data is larger.
+func isRestoreTooSmall(err error) bool {
+	return errors.Is(err, cerrors.ErrCloneSmallerThanSource) || errors.Is(err, cerrors.ErrShrinkBelowUsage)
+}
+
 func buildCreateVolumeResponse(
 	req *csi.CreateVolumeRequest,
 	volOptions *store.VolumeOptions,
@@ -263,7 +290,18 @@ func buildCreateVolumeResponse(
 ) *csi.CreateVolumeResponse {
 	volumeContext := util.GetVolumeContext(req.GetParameters())
 	volumeContext["subvolumeName"] = vID.FsSubvolName
+	if _, ok := volumeContext[k8s.VolumeNameTemplateParam]; ok {
+		delete(volumeContext, k8s.VolumeNameTemplateParam)
+		volumeContext["volumeNamePrefix"] = volOptions.NamePrefix
+	}
 	volumeContext["subvolumePath"] = volOptions.RootPath
+	// the encryption may be inherited from the source of the volume, while
+	// the nodeplugin sets it up with the parameters of the VolumeContext
+	if volOptions.IsEncrypted() {
+		volumeContext["encrypted"] = "true"
+		volumeContext["encryptionKMSID"] = volOptions.Encryption.GetID()
+		volumeContext["encryptionType"] = util.EncryptionTypeFile.String()
+	}
 	volume := &csi.Volume{
 		VolumeId:      vID.VolumeID,
 		CapacityBytes: volOptions.Size,
@@ -322,6 +360,15 @@ func (cs *ControllerServer) CreateVolume(
 	}
 	defer volOptions.Destroy()
 
+	if volOptions.IsEncrypted() {
+		err = kms.CheckHealth(volOptions.Encryption.GetID())
+		if err != nil {
+			log.ErrorLog(ctx, "KMS of volume %q is unavailable: %v", requestName, err)
+
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+
 	if req.GetCapacityRange() != nil {
 		volOptions.Size = util.RoundOffCephFSVolSize(req.GetCapacityRange().GetRequiredBytes())
 	}
@@ -356,6 +403,20 @@ func (cs *ControllerServer) CreateVolume(
 		}
 	}
 
+	if parentVol != nil {
+		err = parentVol.InheritEncryptionConfig(ctx, volOptions, req.GetSecrets())
+		if errors.Is(err, cerrors.ErrIncompatibleEncryption) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		} else if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	err = checkNFSMounter(volOptions, req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	vID, err := store.CheckVolExists(ctx, volOptions, parentVol, pvID, sID, cr, cs.ClusterName, cs.SetMetadata)
 	if err != nil {
 		if cerrors.IsCloneRetryError(err) {
@@ -368,12 +429,22 @@ func (cs *ControllerServer) CreateVolume(
 	// TODO return error message if requested vol size greater than found volume return error
 
 	metadata := k8s.GetVolumeMetadata(req.GetParameters())
+	if cs.SetMetadata {
+		maps.Copy(metadata, k8s.GetVolumeLineageMetadata(ctx, req.GetParameters(), req.GetVolumeContentSource()))
+	}
 	if vID != nil {
 		volClient := core.NewSubVolume(volOptions.GetConnection(), &volOptions.SubVolume,
 			volOptions.ClusterID, cs.ClusterName, cs.SetMetadata)
 		if (sID != nil || pvID != nil) && !volOptions.BackingSnapshot {
 			err = volClient.ExpandVolume(ctx, volOptions.Size)
-			if err != nil {
+			switch {
+			case err != nil && !isRestoreTooSmall(err):
+				// keep the completed clone, the size is set again when
+				// the request is retried
+				log.ErrorLog(ctx, "failed to expand volume %s: %v", fsutil.VolumeID(vID.FsSubvolName), err)
+
+				return nil, status.Error(codes.Internal, err.Error())
+			case err != nil:
 				purgeErr := volClient.PurgeVolume(ctx, false)
 				if purgeErr != nil {
 					log.ErrorLog(ctx, "failed to delete volume %s: %v", requestName, purgeErr)
@@ -387,9 +458,10 @@ func (cs *ControllerServer) CreateVolume(
 					log.WarningLog(ctx, "failed undoing reservation of volume: %s (%s)",
 						requestName, errUndo)
 				}
-				log.ErrorLog(ctx, "failed to expand volume %s: %v", fsutil.VolumeID(vID.FsSubvolName), err)
+				log.ErrorLog(ctx, "failed to expand volume %s to the requested size: %v",
+					fsutil.VolumeID(vID.FsSubvolName), err)
 
-				return nil, status.Error(codes.Internal, err.Error())
+				return nil, status.Error(codes.InvalidArgument, err.Error())
 			}
 		}
 
@@ -401,7 +473,13 @@ func (cs *ControllerServer) CreateVolume(
 			}
 		}
 
-		return buildCreateVolumeResponse(req, volOptions, vID), nil
+		res := buildCreateVolumeResponse(req, volOptions, vID)
+		err = exportVolume(ctx, volOptions, res.GetVolume(), cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		return res, nil
 	}
 
 	// Reservation
@@ -428,6 +506,9 @@ func (cs *ControllerServer) CreateVolume(
 		if cerrors.IsCloneRetryError(err) {
 			return nil, status.Error(codes.Aborted, err.Error())
 		}
+		if isRestoreTooSmall(err) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 
 		return nil, err
 	}
@@ -475,7 +556,15 @@ func (cs *ControllerServer) CreateVolume(
 	log.DebugLog(ctx, "cephfs: successfully created backing volume named %s for request name %s",
 		vID.FsSubvolName, requestName)
 
-	return buildCreateVolumeResponse(req, volOptions, vID), nil
+	res := buildCreateVolumeResponse(req, volOptions, vID)
+	// keep the reservation when exporting fails, the export is created
+	// again when the request is retried
+	exportErr := exportVolume(ctx, volOptions, res.GetVolume(), cr)
+	if exportErr != nil {
+		return nil, status.Error(codes.Internal, exportErr.Error())
+	}
+
+	return res, nil
 }
 
 // DeleteVolume deletes the volume in backend and its reservation.
@@ -594,10 +683,21 @@ func (cs *ControllerServer) cleanUpBackingVolume(
 			log.WarningLog(ctx, "failed to clean the passphrase for volume %q (file encryption): %s",
 				volOptions.VolID, err)
 		}
+		// the passphrase of the source of a restored volume is kept until
+		// the volume is staged the first time
+		if err := volOptions.Encryption.RemoveDEK(ctx, fscrypt.InheritedKeyID(volID.VolumeID)); err != nil {
+			log.DebugLog(ctx, "no inherited passphrase removed for volume %q: %s", volOptions.VolID, err)
+		}
 	}
 
 	if !volOptions.BackingSnapshot {
-		// Regular volumes need to be purged.
+		// Regular volumes need to be purged, after removing the
+		// NFS-export of volumes with the "nfs" mounter.
+		if err := unexportVolume(ctx, volID.VolumeID, cr); err != nil {
+			log.ErrorLog(ctx, "failed to remove NFS-export of volume %s: %v", volID, err)
+
+			return status.Error(codes.Internal, err.Error())
+		}
 
 		volClient := core.NewSubVolume(volOptions.GetConnection(),
 			&volOptions.SubVolume, volOptions.ClusterID, cs.ClusterName, cs.SetMetadata)
@@ -734,8 +834,35 @@ func (cs *ControllerServer) ControllerExpandVolume(
 
 	volClient := core.NewSubVolume(volOptions.GetConnection(),
 		&volOptions.SubVolume, volOptions.ClusterID, cs.ClusterName, cs.SetMetadata)
+	if volOptions.Size > 0 && RoundOffSize < volOptions.Size {
+		if !volOptions.AllowShrink {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"cannot shrink volume %s from %d to %d bytes, allowShrink is not enabled in the StorageClass",
+				volID, volOptions.Size, RoundOffSize)
+		}
+
+		err = volClient.ShrinkVolume(ctx, RoundOffSize)
+		if errors.Is(err, cerrors.ErrShrinkBelowUsage) {
+			log.ErrorLog(ctx, "failed to shrink volume %s: %v", fsutil.VolumeID(volIdentifier.FsSubvolName), err)
+
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		} else if err != nil {
+			log.ErrorLog(ctx, "failed to shrink volume %s: %v", fsutil.VolumeID(volIdentifier.FsSubvolName), err)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         RoundOffSize,
+			NodeExpansionRequired: false,
+		}, nil
+	}
+
 	if err = volClient.ResizeVolume(ctx, RoundOffSize); err != nil {
 		log.ErrorLog(ctx, "failed to expand volume %s: %v", fsutil.VolumeID(volIdentifier.FsSubvolName), err)
+		if errors.Is(err, cerrors.ErrShrinkBelowUsage) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -770,13 +897,17 @@ func (cs *ControllerServer) CreateSnapshot(
 
 	requestName := req.GetName()
 	sourceVolID := req.GetSourceVolumeId()
-	// Existence and conflict checks
-	if acquired := cs.SnapshotLocks.TryAcquire(requestName); !acquired {
-		log.ErrorLog(ctx, util.SnapshotOperationAlreadyExistsFmt, requestName)
+	// Existence and conflict checks, the source volume is locked to lock
+	// out parallel snapshot create operations
+	release, err := cs.LockManager.TryAcquireAll(
+		util.LockRequest{Level: util.SnapshotLock, ID: requestName},
+		util.LockRequest{Level: util.VolumeLock, ID: sourceVolID})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to lock snapshot %s of volume %s: %v", requestName, sourceVolID, err)
 
-		return nil, status.Errorf(codes.Aborted, util.SnapshotOperationAlreadyExistsFmt, requestName)
+		return nil, status.Error(codes.Aborted, err.Error())
 	}
-	defer cs.SnapshotLocks.Release(requestName)
+	defer release()
 
 	if err = cs.OperationLocks.GetSnapshotCreateLock(sourceVolID); err != nil {
 		log.ErrorLog(ctx, err.Error())
@@ -821,13 +952,6 @@ func (cs *ControllerServer) CreateSnapshot(
 		return nil, status.Error(codes.Internal, genSnapErr.Error())
 	}
 
-	// lock out parallel snapshot create operations
-	if acquired := cs.VolumeLocks.TryAcquire(sourceVolID); !acquired {
-		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, sourceVolID)
-
-		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, sourceVolID)
-	}
-	defer cs.VolumeLocks.Release(sourceVolID)
 	snapName := req.GetName()
 	sid, err := store.CheckSnapExists(ctx, parentVolOptions, cephfsSnap, cs.ClusterName, cs.SetMetadata, cr)
 	if err != nil {
@@ -873,6 +997,16 @@ func (cs *ControllerServer) CreateSnapshot(
 		}, nil
 	}
 
+	err = cs.checkSnapshotCount(ctx, parentVolOptions, volClient, req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	err = cs.checkSnapshotPoolUsage(ctx, parentVolOptions, info)
+	if err != nil {
+		return nil, err
+	}
+
 	// Reservation
 	sID, err := store.ReserveSnap(ctx, parentVolOptions, vid.FsSubvolName, cephfsSnap, cr)
 	if err != nil {
@@ -887,11 +1021,29 @@ func (cs *ControllerServer) CreateSnapshot(
 			}
 		}
 	}()
-	snap, err := cs.doSnapshot(ctx, parentVolOptions, sID.FsSnapshotName, metadata)
+	hook, err := util.NewSnapshotHook(util.CsiConfigFile, parentVolOptions.ClusterID)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	var snap core.SnapshotInfo
+	err = hook.Run(ctx, &util.SnapshotHookRequest{
+		ClusterID:       parentVolOptions.ClusterID,
+		Name:            requestName,
+		SourceVolumeIDs: []string{sourceVolID},
+		Parameters:      req.GetParameters(),
+	}, func() error {
+		var sErr error
+		snap, sErr = cs.doSnapshot(ctx, parentVolOptions, sID.FsSnapshotName, metadata)
+
+		return sErr
+	})
+	if errors.Is(err, util.ErrSnapshotHookFailed) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	// Use same encryption KMS than source volume and copy the passphrase. The passphrase becomes
 	// available under the snapshot id for CreateVolume to use this snap as a backing volume
 	snapVolOptions := store.VolumeOptions{}
@@ -1091,6 +1243,14 @@ func (cs *ControllerServer) DeleteSnapshot(
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+
+		// the passphrase was stored for the snapshot by CreateSnapshot
+		if volOpt.IsEncrypted() && volOpt.Encryption.KMS.RequiresDEKStore() == kms.DEKStoreIntegrated {
+			if err = volOpt.Encryption.RemoveDEK(ctx, sid.SnapshotID); err != nil {
+				log.WarningLog(ctx, "failed to clean the passphrase for snapshot %q (file encryption): %s",
+					sid.SnapshotID, err)
+			}
+		}
 	}
 
 	return &csi.DeleteSnapshotResponse{}, nil
@@ -0,0 +1,51 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	kmsapi "github.com/ceph/ceph-csi/internal/kms"
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+func TestBuildCreateVolumeResponseEncryption(t *testing.T) {
+	t.Parallel()
+
+	req := &csi.CreateVolumeRequest{
+		Parameters: map[string]string{"clusterID": "cluster-1"},
+	}
+	vID := &store.VolumeIdentifier{VolumeID: "vol-1", FsSubvolName: "csi-vol-1"}
+
+	res := buildCreateVolumeResponse(req, &store.VolumeOptions{}, vID)
+	require.NotContains(t, res.GetVolume().GetVolumeContext(), "encrypted")
+
+	// the encryption that is inherited from the source is passed to the
+	// nodeplugin, the parameters of the request do not enable it
+	ve, err := util.NewVolumeEncryption("secrets-kms", kmsapi.GetKMSTestDummy(kmsapi.DefaultKMSType))
+	require.NoError(t, err)
+	res = buildCreateVolumeResponse(req, &store.VolumeOptions{Encryption: ve}, vID)
+	volCtx := res.GetVolume().GetVolumeContext()
+	require.Equal(t, "true", volCtx["encrypted"])
+	require.Equal(t, "secrets-kms", volCtx["encryptionKMSID"])
+	require.Equal(t, "file", volCtx["encryptionType"])
+	require.Equal(t, "cluster-1", volCtx["clusterID"])
+}
@@ -204,7 +204,9 @@ func (s *subVolumeClient) GetCloneState(ctx context.Context) (*cephFSCloneState,
 		return CephFSCloneError, err
 	}
 
+	done := s.conn.TrackCall("clone_status")
 	cs, err := fsa.CloneStatus(s.FsName, s.SubvolumeGroup, s.VolID)
+	done(err)
 	if err != nil {
 		log.ErrorLog(ctx, "could not get clone state for volume %s with ID %s: %v", s.FsName, s.VolID, err)
 
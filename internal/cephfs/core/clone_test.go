@@ -38,3 +38,28 @@ func TestCloneStateToError(t *testing.T) {
 		require.ErrorIs(t, state.ToError(), err)
 	}
 }
+
+func TestNeedsExpansion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		quota     int64
+		requested int64
+		want      bool
+		wantErr   error
+	}{
+		{"no requested size", 1024, 0, false, nil},
+		{"unlimited quota", 0, 1024, true, nil},
+		{"same size", 1024, 1024, false, nil},
+		{"larger size", 1024, 2048, true, nil},
+		{"smaller size", 2048, 1024, false, cerrors.ErrCloneSmallerThanSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := needsExpansion(tt.quota, tt.requested)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"syscall"
 
 	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
 	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
@@ -49,7 +50,9 @@ var (
 // from fsAdmin.SubVolumeInfo.
 type Subvolume struct {
 	BytesQuota int64
+	BytesUsed  int64
 	Path       string
+	DataPool   string
 	Features   []string
 }
 
@@ -62,13 +65,20 @@ type SubVolumeClient interface {
 	GetVolumeRootPathCeph(ctx context.Context) (string, error)
 	// CreateVolume creates a subvolume.
 	CreateVolume(ctx context.Context) error
+	// CreateSubvolumeGroup creates the subvolumegroup of the subvolume, if
+	// it does not exist.
+	CreateSubvolumeGroup(ctx context.Context) error
 	// GetSubVolumeInfo returns the subvolume information.
 	GetSubVolumeInfo(ctx context.Context) (*Subvolume, error)
 	// ExpandVolume expands the volume if the requested size is greater than
-	// the subvolume size.
+	// the subvolume size, and returns ErrCloneSmallerThanSource when the
+	// requested size is smaller.
 	ExpandVolume(ctx context.Context, bytesQuota int64) error
 	// ResizeVolume resizes the volume.
 	ResizeVolume(ctx context.Context, bytesQuota int64) error
+	// ShrinkVolume reduces the quota of the volume, if the used size is
+	// smaller than the requested size.
+	ShrinkVolume(ctx context.Context, bytesQuota int64) error
 	// PurgSubVolume removes the subvolume.
 	PurgeVolume(ctx context.Context, force bool) error
 
@@ -80,6 +90,8 @@ type SubVolumeClient interface {
 	CreateCloneFromSnapshot(ctx context.Context, snap Snapshot) error
 	// CleanupSnapshotFromSubvolume removes the snapshot from the subvolume.
 	CleanupSnapshotFromSubvolume(ctx context.Context, parentVol *SubVolume) error
+	// ListSnapshots returns the names of the snapshots of the subvolume.
+	ListSnapshots(ctx context.Context) ([]string, error)
 
 	// SetAllMetadata set all the metadata from arg parameters on Ssubvolume.
 	SetAllMetadata(parameters map[string]string) error
@@ -176,8 +188,10 @@ func (s *subVolumeClient) GetSubVolumeInfo(ctx context.Context) (*Subvolume, err
 
 	subvol := Subvolume{
 		// only set BytesQuota when it is of type ByteCount
-		Path:     info.Path,
-		Features: make([]string, len(info.Features)),
+		Path:      info.Path,
+		BytesUsed: int64(info.BytesUsed),
+		DataPool:  info.DataPool,
+		Features:  make([]string, len(info.Features)),
 	}
 	bc, ok := info.BytesQuota.(fsAdmin.ByteCount)
 	if !ok {
@@ -209,6 +223,9 @@ type localClusterState struct {
 	// unsupported as per the state of the cluster.
 	subVolMetadataState         operationState
 	subVolSnapshotMetadataState operationState
+	// subVolumeGroupsCreated records the "<fsName>/<group>" subvolumegroups
+	// that were created by CreateSubvolumeGroup.
+	subVolumeGroupsCreated map[string]bool
 }
 
 func newLocalClusterState(clusterID string) {
@@ -217,7 +234,9 @@ func newLocalClusterState(clusterID string) {
 	clusterAdditionalInfoMutex.Lock()
 	defer clusterAdditionalInfoMutex.Unlock()
 	if _, keyPresent := clusterAdditionalInfo[clusterID]; !keyPresent {
-		clusterAdditionalInfo[clusterID] = &localClusterState{}
+		clusterAdditionalInfo[clusterID] = &localClusterState{
+			subVolumeGroupsCreated: make(map[string]bool),
+		}
 	}
 }
 
@@ -240,7 +259,9 @@ func (s *subVolumeClient) CreateVolume(ctx context.Context) error {
 	}
 
 	// FIXME: check if the right credentials are used ("-n", cephEntityClientPrefix + cr.ID)
+	done := s.conn.TrackCall("create_subvolume")
 	err = ca.CreateSubVolume(s.FsName, s.SubvolumeGroup, s.VolID, &opts)
+	done(err)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to create subvolume %s in fs %s: %s", s.VolID, s.FsName, err)
 
@@ -250,25 +271,87 @@ func (s *subVolumeClient) CreateVolume(ctx context.Context) error {
 	return nil
 }
 
+// CreateSubvolumeGroup creates the subvolumegroup of the subvolume, if it
+// does not exist. Creating a subvolumegroup that exists succeeds, so only the
+// groups that were created before are skipped.
+func (s *subVolumeClient) CreateSubvolumeGroup(ctx context.Context) error {
+	newLocalClusterState(s.clusterID)
+
+	key := s.FsName + "/" + s.SubvolumeGroup
+	clusterAdditionalInfoMutex.Lock()
+	created := clusterAdditionalInfo[s.clusterID].subVolumeGroupsCreated[key]
+	clusterAdditionalInfoMutex.Unlock()
+	if created {
+		return nil
+	}
+
+	ca, err := s.conn.GetFSAdmin()
+	if err != nil {
+		log.ErrorLog(ctx, "could not get FSAdmin, can not create subvolumegroup %s: %s", s.SubvolumeGroup, err)
+
+		return err
+	}
+
+	done := s.conn.TrackCall("create_subvolumegroup")
+	err = ca.CreateSubVolumeGroup(s.FsName, s.SubvolumeGroup, nil)
+	done(err)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to create subvolumegroup %s in fs %s: %s", s.SubvolumeGroup, s.FsName, err)
+
+		return err
+	}
+	log.DebugLog(ctx, "cephfs: created subvolumegroup %s in fs %s", s.SubvolumeGroup, s.FsName)
+
+	clusterAdditionalInfoMutex.Lock()
+	clusterAdditionalInfo[s.clusterID].subVolumeGroupsCreated[key] = true
+	clusterAdditionalInfoMutex.Unlock()
+
+	return nil
+}
+
 // ExpandVolume will expand the volume if the requested size is greater than
-// the subvolume size.
+// the subvolume size. ErrCloneSmallerThanSource is returned when the
+// requested size is smaller.
 func (s *subVolumeClient) ExpandVolume(ctx context.Context, bytesQuota int64) error {
 	// get the subvolume size for comparison with the requested size.
 	info, err := s.GetSubVolumeInfo(ctx)
 	if err != nil {
 		return err
 	}
-	// resize if the requested size is greater than the current size.
-	if s.Size > info.BytesQuota {
-		log.DebugLog(ctx, "clone %s size %d is greater than requested size %d", s.VolID, info.BytesQuota, bytesQuota)
+	expand, err := needsExpansion(info.BytesQuota, bytesQuota)
+	if err != nil {
+		return fmt.Errorf("failed to expand clone %s of size %d to requested size %d: %w",
+			s.VolID, info.BytesQuota, bytesQuota, err)
+	}
+	if expand {
+		log.DebugLog(ctx, "cephfs: expanding clone %s of size %d to requested size %d",
+			s.VolID, info.BytesQuota, bytesQuota)
 		err = s.ResizeVolume(ctx, bytesQuota)
 	}
 
 	return err
 }
 
+// needsExpansion returns true when the quota of a clone needs to be raised to
+// the requested size. A clone has the quota of its source, a requested size
+// that is smaller can not be met without shrinking the clone. A quota or a
+// requested size of 0 is unlimited.
+func needsExpansion(quota, requested int64) (bool, error) {
+	switch {
+	case requested == 0:
+		return false, nil
+	case quota == 0:
+		return true, nil
+	case requested < quota:
+		return false, cerrors.ErrCloneSmallerThanSource
+	}
+
+	return requested > quota, nil
+}
+
 // ResizeVolume will use the ceph fs subvolume resize command to resize the
-// subvolume.
+// subvolume. The quota is not reduced below the used size of the subvolume,
+// ErrShrinkBelowUsage is returned in that case.
 func (s *subVolumeClient) ResizeVolume(ctx context.Context, bytesQuota int64) error {
 	fsa, err := s.conn.GetFSAdmin()
 	if err != nil {
@@ -279,11 +362,79 @@ func (s *subVolumeClient) ResizeVolume(ctx context.Context, bytesQuota int64) er
 	_, err = fsa.ResizeSubVolume(s.FsName, s.SubvolumeGroup, s.VolID, fsAdmin.ByteCount(bytesQuota), true)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to resize subvolume %s in fs %s: %s", s.VolID, s.FsName, err)
+		if isResizeBelowUsage(err) {
+			return fmt.Errorf("%w: %w", cerrors.ErrShrinkBelowUsage, err)
+		}
 	}
 
 	return err
 }
 
+// cephErrorCode is implemented by the errors of go-ceph that carry an errno.
+type cephErrorCode interface {
+	ErrorCode() int
+}
+
+// isResizeBelowUsage returns true when "ceph fs subvolume resize" with the
+// no_shrink flag rejected a size that is smaller than the used size of the
+// subvolume. The mgr/volumes module reports this with EINVAL, the status
+// message is the only way to distinguish it from other invalid arguments.
+func isResizeBelowUsage(err error) bool {
+	var ce cephErrorCode
+	if !errors.As(err, &ce) || ce.ErrorCode() != -int(syscall.EINVAL) {
+		return false
+	}
+
+	return strings.Contains(err.Error(), "lesser than the current used size")
+}
+
+// ShrinkVolume reduces the quota of the subvolume to bytesQuota. CephFS
+// accepts a quota below the used size, so the usage is checked beforehand and
+// ErrShrinkBelowUsage is returned when the data does not fit.
+func (s *subVolumeClient) ShrinkVolume(ctx context.Context, bytesQuota int64) error {
+	info, err := s.GetSubVolumeInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if info.BytesUsed > bytesQuota {
+		return fmt.Errorf("%w: subvolume %s uses %d bytes, requested size is %d bytes",
+			cerrors.ErrShrinkBelowUsage, s.VolID, info.BytesUsed, bytesQuota)
+	}
+
+	fsa, err := s.conn.GetFSAdmin()
+	if err != nil {
+		log.ErrorLog(ctx, "could not get FSAdmin, can not shrink volume %s: %v", s.FsName, err)
+
+		return err
+	}
+	_, err = fsa.ResizeSubVolume(s.FsName, s.SubvolumeGroup, s.VolID, fsAdmin.ByteCount(bytesQuota), false)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to shrink subvolume %s in fs %s: %s", s.VolID, s.FsName, err)
+	}
+
+	return err
+}
+
+// ListSnapshots returns the names of the snapshots of the subvolume.
+func (s *subVolumeClient) ListSnapshots(ctx context.Context) ([]string, error) {
+	fsa, err := s.conn.GetFSAdmin()
+	if err != nil {
+		log.ErrorLog(ctx, "could not get FSAdmin %s:", err)
+
+		return nil, err
+	}
+
+	snaps, err := fsa.ListSubVolumeSnapshots(s.FsName, s.SubvolumeGroup, s.VolID)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to list snapshots of subvolume %s in fs %s: %s", s.VolID, s.FsName, err)
+
+		return nil, err
+	}
+
+	return snaps, nil
+}
+
 // PurgSubVolume removes the subvolume.
 func (s *subVolumeClient) PurgeVolume(ctx context.Context, force bool) error {
 	fsa, err := s.conn.GetFSAdmin()
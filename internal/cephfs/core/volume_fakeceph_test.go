@@ -0,0 +1,72 @@
+//go:build fakeceph
+
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"testing"
+
+	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/fakeceph"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubVolumeClientSimulated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster := fakeceph.NewCluster()
+	cluster.AddFileSystem("myfs", "myfs-metadata", "myfs-data0")
+	fakeceph.Register(t.Name(), cluster)
+
+	conn := &util.ClusterConnection{}
+	require.NoError(t, conn.Connect(t.Name(), &util.Credentials{}))
+	defer conn.Destroy()
+
+	sv := NewSubVolume(conn, &SubVolume{
+		VolID:          "csi-vol-1",
+		FsName:         "myfs",
+		SubvolumeGroup: "csi",
+		Size:           4096,
+	}, "simulated-cluster", "", false)
+
+	_, err := sv.GetSubVolumeInfo(ctx)
+	require.ErrorIs(t, err, cerrors.ErrVolumeNotFound)
+
+	require.NoError(t, sv.CreateSubvolumeGroup(ctx))
+	require.NoError(t, sv.CreateVolume(ctx))
+
+	info, err := sv.GetSubVolumeInfo(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(4096), info.BytesQuota)
+	require.Equal(t, "myfs-data0", info.DataPool)
+
+	rootPath, err := sv.GetVolumeRootPathCeph(ctx)
+	require.NoError(t, err)
+	require.Equal(t, info.Path, rootPath)
+
+	require.NoError(t, cluster.SetSubVolumeUsage("myfs", "csi", "csi-vol-1", 2048))
+	require.ErrorIs(t, sv.ShrinkVolume(ctx, 1024), cerrors.ErrShrinkBelowUsage)
+	require.NoError(t, sv.ShrinkVolume(ctx, 3072))
+
+	require.NoError(t, sv.PurgeVolume(ctx, false))
+	require.ErrorIs(t, sv.PurgeVolume(ctx, false), cerrors.ErrVolumeNotFound)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// errnoError mimics the errors of go-ceph that carry an errno.
+type errnoError int
+
+func (e errnoError) Error() string {
+	return fmt.Sprintf("errno %d", int(e))
+}
+
+func (e errnoError) ErrorCode() int {
+	return int(e)
+}
+
+func TestIsResizeBelowUsage(t *testing.T) {
+	t.Parallel()
+	einval := errnoError(-int(syscall.EINVAL))
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "below used size",
+			err: fmt.Errorf("%w: %q", einval, "Can't resize the subvolume. The new size '1024' would be "+
+				"lesser than the current used size '4096'"),
+			want: true,
+		},
+		{
+			name: "other invalid argument",
+			err:  fmt.Errorf("%w: %q", einval, "Invalid subvolume size"),
+			want: false,
+		},
+		{
+			name: "other errno",
+			err:  fmt.Errorf("%w: %q", errnoError(-int(syscall.EPERM)), "lesser than the current used size"),
+			want: false,
+		},
+		{
+			name: "no errno",
+			err:  errors.New("lesser than the current used size"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, isResizeBelowUsage(tt.err))
+		})
+	}
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package cephfs
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 
+	"github.com/ceph/ceph-csi/internal/cephfs/clientmetrics"
 	"github.com/ceph/ceph-csi/internal/cephfs/mounter"
 	"github.com/ceph/ceph-csi/internal/cephfs/store"
 	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
@@ -27,9 +30,16 @@ import (
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	hc "github.com/ceph/ceph-csi/internal/health-checker"
 	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/kms"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/clusterconfig"
+	"github.com/ceph/ceph-csi/internal/util/driverstatus"
+	"github.com/ceph/ceph-csi/internal/util/featuregate"
+	"github.com/ceph/ceph-csi/internal/util/journalstats"
 	"github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/readiness"
+	"github.com/ceph/ceph-csi/internal/util/usage"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
@@ -59,11 +69,14 @@ func NewIdentityServer(d *csicommon.CSIDriver) *IdentityServer {
 
 // NewControllerServer initialize a controller server for ceph CSI driver.
 func NewControllerServer(d *csicommon.CSIDriver) *ControllerServer {
+	lm := util.NewLockManager()
+
 	return &ControllerServer{
 		DefaultControllerServer: csicommon.NewDefaultControllerServer(d),
-		VolumeLocks:             util.NewVolumeLocks(),
-		SnapshotLocks:           util.NewVolumeLocks(),
-		VolumeGroupLocks:        util.NewVolumeLocks(),
+		LockManager:             lm,
+		VolumeLocks:             lm.Locks(util.VolumeLock),
+		SnapshotLocks:           lm.Locks(util.SnapshotLock),
+		VolumeGroupLocks:        lm.Locks(util.VolumeGroupLock),
 		OperationLocks:          util.NewOperationLock(),
 	}
 }
@@ -106,6 +119,13 @@ func (fs *Driver) Run(conf *util.Config) {
 		fsutil.RadosNamespace = conf.RadosNamespaceCephFS
 	}
 
+	if featuregate.Enabled(featuregate.SystemdMounts) {
+		err = util.EnableMountsViaSystemd(context.TODO())
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+	}
+
 	if conf.IsNodeServer && k8s.RunsOnKubernetes() {
 		nodeLabels, err = k8s.GetNodeLabels(conf.NodeID)
 		if err != nil {
@@ -117,6 +137,18 @@ func (fs *Driver) Run(conf *util.Config) {
 		crushLocationMap = util.GetCrushLocationMap(conf.CrushLocationLabels, nodeLabels)
 	}
 
+	err = util.RegisterCephCallMetrics()
+	if err != nil {
+		log.FatalLogMsg("%v", err.Error())
+	}
+
+	if featuregate.Enabled(featuregate.ClusterConfigCRD) {
+		err = clusterconfig.Start(context.Background(), conf.DriverNamespace, conf.IsControllerServer)
+		if err != nil {
+			log.FatalLogMsg("failed to read ClusterConfig objects: %v", err)
+		}
+	}
+
 	// Create an instance of the volume journal
 	store.VolJournal = journal.NewCSIVolumeJournalWithNamespace(conf.InstanceID, fsutil.RadosNamespace)
 
@@ -148,9 +180,11 @@ func (fs *Driver) Run(conf *util.Config) {
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
 		})
 
-		fs.cd.AddGroupControllerServiceCapabilities([]csi.GroupControllerServiceCapability_RPC_Type{
-			csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
-		})
+		if featuregate.Enabled(featuregate.GroupSnapshot) {
+			fs.cd.AddGroupControllerServiceCapabilities([]csi.GroupControllerServiceCapability_RPC_Type{
+				csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+			})
+		}
 	}
 	// Create gRPC servers
 
@@ -161,28 +195,132 @@ func (fs *Driver) Run(conf *util.Config) {
 		if err != nil {
 			log.FatalLogMsg("%v", err.Error())
 		}
+		topology = util.AddTopologyAliases(topology, conf.DriverName)
+		if featuregate.Enabled(featuregate.NodeCapabilityLabels) {
+			err = util.ApplyNodeCapabilityLabels(context.Background(), conf.NodeID, conf.DriverName)
+			if err != nil {
+				log.WarningLogMsg("failed to label node %q with its capabilities: %v", conf.NodeID, err)
+			}
+		}
 		fs.ns = NewNodeServer(
 			fs.cd, conf.Vtype,
 			conf.KernelMountOptions, conf.FuseMountOptions,
 			nodeLabels, topology, crushLocationMap,
 		)
+		fs.ns.ForceUnstage = featuregate.Enabled(featuregate.ForceUnstage)
+		fs.ns.ReadAheadKB = conf.ReadAheadKB
+		fs.ns.MountProbeTimeout = conf.MountProbeTimeout
+		fs.ns.StatsCache = csicommon.NewVolumeStatsCache(conf.VolumeStatsCacheMaxAge)
+		if conf.PassphraseCacheTTL != 0 {
+			if err = util.EnablePassphraseCache(conf.PassphraseCacheTTL); err != nil {
+				log.FatalLogMsg("failed to enable the passphrase cache: %v", err)
+			}
+		}
+
+		if conf.CephFSClientMetrics {
+			fs.ns.ClientMetrics, err = clientmetrics.Register()
+			if err != nil {
+				log.FatalLogMsg("%v", err.Error())
+			}
+		}
 	}
 
 	if conf.IsControllerServer {
 		fs.cs = NewControllerServer(fs.cd)
 		fs.cs.ClusterName = conf.ClusterName
 		fs.cs.SetMetadata = conf.SetMetadata
+		fs.cs.SnapshotPoolUsageThreshold = conf.SnapshotPoolUsageThreshold
+		fs.cs.MaxSnapshotsPerVolume = conf.MaxSnapshotsPerVolume
+
+		err = util.RegisterLockMetrics()
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+		if conf.StuckLockThreshold != 0 {
+			go fs.cs.LockManager.DetectStuckLocks(context.Background(), conf.StuckLockThreshold, conf.StuckLockThreshold)
+		}
+
+		if conf.UsageReportInterval != 0 {
+			err = usage.Start(conf.DriverName, conf.UsageReportInterval,
+				conf.DriverNamespace, conf.UsageReportConfigMap, CollectUsage(conf.DriverName))
+			if err != nil {
+				log.FatalLogMsg("failed to start usage reporting: %v", err)
+			}
+		}
+
+		if conf.JournalStatsInterval != 0 {
+			err = journalstats.Start(conf.DriverName, conf.JournalStatsInterval, CountJournalEntries(conf.DriverName))
+			if err != nil {
+				log.FatalLogMsg("failed to start counting of journal entries: %v", err)
+			}
+		}
+
+		dependencies := []readiness.Dependency{}
+		if conf.KMSHealthInterval != 0 {
+			kmsChecker, kErr := kms.StartHealthChecks(conf.KMSHealthInterval)
+			if kErr != nil {
+				log.FatalLogMsg("failed to start KMS health checks: %v", kErr)
+			}
+			dependencies = append(dependencies, kmsChecker)
+		}
+
+		var checker *readiness.Checker
+		if conf.ClusterReadinessInterval != 0 || conf.ValidateClusters {
+			checker, err = readiness.Start(readiness.Options{
+				DriverName:    conf.DriverName,
+				Interval:      conf.ClusterReadinessInterval,
+				Selector:      conf.ClusterReadinessSelector,
+				PoolParameter: "fsName",
+				ClusterIDs:    util.ListClusterIDs,
+				Probe:         ProbeStorageClass,
+				Dependencies:  dependencies,
+			})
+			if err != nil {
+				log.FatalLogMsg("failed to start cluster readiness checks: %v", err)
+			}
+		} else if len(dependencies) != 0 {
+			http.Handle(readiness.Path, readiness.Handler(dependencies...))
+		}
+
+		if conf.StatusReportInterval != 0 {
+			err = driverstatus.Start(driverstatus.Options{
+				DriverName:   conf.DriverName,
+				DriverType:   conf.Vtype,
+				Version:      util.DriverVersion,
+				GitCommit:    util.GitCommit,
+				Interval:     conf.StatusReportInterval,
+				Namespace:    conf.DriverNamespace,
+				ConfigMap:    conf.StatusReportConfigMap,
+				Features:     featuregate.DefaultGate.EnabledFeatures,
+				Checker:      checker,
+				CountVolumes: CountVolumes(conf.DriverName),
+			})
+			if err != nil {
+				log.FatalLogMsg("failed to start status reporting: %v", err)
+			}
+		}
 	}
 	if !conf.IsControllerServer && !conf.IsNodeServer {
 		topology, err = util.GetTopologyFromDomainLabels(conf.DomainLabels, conf.NodeID, conf.DriverName)
 		if err != nil {
 			log.FatalLogMsg("%v", err.Error())
 		}
+		topology = util.AddTopologyAliases(topology, conf.DriverName)
+		if featuregate.Enabled(featuregate.NodeCapabilityLabels) {
+			err = util.ApplyNodeCapabilityLabels(context.Background(), conf.NodeID, conf.DriverName)
+			if err != nil {
+				log.WarningLogMsg("failed to label node %q with its capabilities: %v", conf.NodeID, err)
+			}
+		}
 		fs.ns = NewNodeServer(
 			fs.cd, conf.Vtype,
 			conf.KernelMountOptions, conf.FuseMountOptions,
 			nodeLabels, topology, crushLocationMap,
 		)
+		fs.ns.ForceUnstage = featuregate.Enabled(featuregate.ForceUnstage)
+		fs.ns.ReadAheadKB = conf.ReadAheadKB
+		fs.ns.MountProbeTimeout = conf.MountProbeTimeout
+		fs.ns.StatsCache = csicommon.NewVolumeStatsCache(conf.VolumeStatsCacheMaxAge)
 		fs.cs = NewControllerServer(fs.cd)
 	}
 
@@ -200,11 +338,19 @@ func (fs *Driver) Run(conf *util.Config) {
 		GS: fs.cs,
 	}
 	server.Start(conf.Endpoint, srv, csicommon.MiddlewareServerOptionConfig{
-		LogSlowOpInterval: conf.LogSlowOpInterval,
+		LogSlowOpInterval:   conf.LogSlowOpInterval,
+		SlowOpThresholds:    csicommon.SlowOpThresholds(conf),
+		MaintenanceModeFile: util.MaintenanceModeFile,
+		RPCConcurrency:      csicommon.NodeRPCConcurrency(conf),
+		MaxMessageSize:      conf.GRPCMaxMessageSize,
 	})
 
-	if conf.EnableProfiling {
+	if conf.EnableProfiling || conf.UsageReportInterval != 0 || conf.JournalStatsInterval != 0 ||
+		conf.ClusterReadinessInterval != 0 || conf.ValidateClusters || conf.KMSHealthInterval != 0 ||
+		conf.CephFSClientMetrics {
 		go util.StartMetricsServer(conf)
+	}
+	if conf.EnableProfiling {
 		log.DebugLogMsg("Registering profiling handler")
 		go util.EnableProfiling()
 	}
@@ -222,6 +368,17 @@ func (fs *Driver) setupCSIAddonsServer(conf *util.Config) error {
 		return fmt.Errorf("failed to create CSI-Addons server: %w", err)
 	}
 
+	if conf.CSIAddonsTCPEndpoint != "" {
+		err = fs.cas.EnableTCP(conf.CSIAddonsTCPEndpoint, csiaddons.TLSFiles{
+			CertFile: conf.CSIAddonsTLSCertFile,
+			KeyFile:  conf.CSIAddonsTLSKeyFile,
+			CAFile:   conf.CSIAddonsTLSCAFile,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure CSI-Addons TCP endpoint: %w", err)
+		}
+	}
+
 	// register services
 	is := casceph.NewIdentityServer(conf)
 	fs.cas.RegisterService(is)
@@ -231,9 +388,17 @@ func (fs *Driver) setupCSIAddonsServer(conf *util.Config) error {
 		fs.cas.RegisterService(fcs)
 	}
 
+	if conf.IsNodeServer {
+		ekrs := casceph.NewEncryptionKeyRotationServer(conf.StagingPath, conf.DriverName,
+			fs.ns.VolumeLocks, fs.ns.Mounter)
+		fs.cas.RegisterService(ekrs)
+	}
+
 	// start the server, this does not block, it runs a new go-routine
 	err = fs.cas.Start(csicommon.MiddlewareServerOptionConfig{
-		LogSlowOpInterval: conf.LogSlowOpInterval,
+		LogSlowOpInterval:   conf.LogSlowOpInterval,
+		SlowOpThresholds:    csicommon.SlowOpThresholds(conf),
+		MaintenanceModeFile: util.MaintenanceModeFile,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to start CSI-Addons server: %w", err)
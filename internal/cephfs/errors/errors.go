@@ -64,6 +64,18 @@ var (
 
 	// ErrGroupNotFound is returned when volume group snapshot is not found in the backend.
 	ErrGroupNotFound = coreError.New("volume group snapshot not found")
+
+	// ErrShrinkBelowUsage is returned when the quota of a subvolume can not
+	// be set, because it contains more data than the requested size.
+	ErrShrinkBelowUsage = coreError.New("requested size is smaller than the used size")
+
+	// ErrCloneSmallerThanSource is returned when a clone is requested with a
+	// size that is smaller than the size of the source.
+	ErrCloneSmallerThanSource = coreError.New("requested size is smaller than the size of the source")
+
+	// ErrIncompatibleEncryption is returned when the encryption of a volume
+	// can not be set up from the encryption of its source.
+	ErrIncompatibleEncryption = coreError.New("encryption is incompatible with the source")
 )
 
 // IsCloneRetryError returns true if the clone error is pending,in-progress
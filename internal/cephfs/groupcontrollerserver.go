@@ -155,7 +155,23 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 		return nil, status.Error(codes.Internal, "Quiesce operation is in progress")
 	}
 
-	resp, err := cs.createSnapshotAddToVolumeGroupJournal(ctx, req, vg, vgs, cr, fsMap)
+	hook, err := util.NewSnapshotHook(util.CsiConfigFile, vg.ClusterID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var resp []*csi.CreateSnapshotResponse
+	err = hook.Run(ctx, &util.SnapshotHookRequest{
+		ClusterID:       vg.ClusterID,
+		Name:            requestName,
+		SourceVolumeIDs: req.GetSourceVolumeIds(),
+		Parameters:      req.GetParameters(),
+	}, func() error {
+		var sErr error
+		resp, sErr = cs.createSnapshotAddToVolumeGroupJournal(ctx, req, vg, vgs, cr, fsMap)
+
+		return sErr
+	})
 	if err != nil {
 		log.ErrorLog(ctx, "failed to create snapshot and add to volume group journal: %v", err)
 
@@ -166,6 +182,9 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 				log.ErrorLog(ctx, "failed to delete snapshot and undo reservation: %v", uErr)
 			}
 		}
+		if errors.Is(err, util.ErrSnapshotHookFailed) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
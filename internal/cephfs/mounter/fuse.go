@@ -65,17 +65,10 @@ func mountFuse(ctx context.Context, mountPoint string, cr *util.Credentials, vol
 	if volOptions.FsName != "" {
 		args = append(args, "--client_mds_namespace="+volOptions.FsName)
 	}
-	var (
-		stderr string
-		err    error
-	)
-
-	if volOptions.NetNamespaceFilePath != "" {
-		_, stderr, err = util.ExecuteCommandWithNSEnter(ctx, volOptions.NetNamespaceFilePath, "ceph-fuse", args[:]...)
-	} else {
-		_, stderr, err = util.ExecCommand(ctx, "ceph-fuse", args[:]...)
+	if volOptions.ReadAheadKB != 0 {
+		args = append(args, fmt.Sprintf("--client_readahead_max_bytes=%d", uint64(volOptions.ReadAheadKB)*1024))
 	}
-
+	_, stderr, err := util.ExecMountCommand(ctx, volOptions.NetNamespaceFilePath, "ceph-fuse", args[:]...)
 	if err != nil {
 		return fmt.Errorf("%w stderr: %s", err, stderr)
 	}
@@ -84,19 +84,11 @@ func (m *kernelMounter) mountKernel(
 		mdsNamespace = "mds_namespace=" + volOptions.FsName
 	}
 	optionsStr = util.MountOptionsAdd(optionsStr, mdsNamespace, volOptions.KernelMountOptions, netDev)
+	optionsStr = util.MountOptionsAdd(optionsStr, readAheadOption(volOptions))
 
 	args = append(args, "-o", optionsStr)
 
-	var (
-		stderr string
-		err    error
-	)
-
-	if volOptions.NetNamespaceFilePath != "" {
-		_, stderr, err = util.ExecuteCommandWithNSEnter(ctx, volOptions.NetNamespaceFilePath, "mount", args[:]...)
-	} else {
-		_, stderr, err = util.ExecCommand(ctx, "mount", args[:]...)
-	}
+	_, stderr, err := util.ExecMountCommand(ctx, volOptions.NetNamespaceFilePath, "mount", args[:]...)
 	if err != nil {
 		return fmt.Errorf("%w stderr: %s", err, stderr)
 	}
@@ -137,3 +129,18 @@ func filesystemSupported(fs string) bool {
 	// supports CephFS.
 	return strings.Contains(string(data), "\t"+fs+"\n")
 }
+
+// readAheadOption returns the rasize mount option for the ReadAheadKB of the
+// volume. Nothing is returned when the mount options contain rasize already.
+func readAheadOption(volOptions *store.VolumeOptions) string {
+	if volOptions.ReadAheadKB == 0 {
+		return ""
+	}
+	for _, opt := range strings.Split(volOptions.KernelMountOptions, ",") {
+		if strings.HasPrefix(strings.TrimSpace(opt), "rasize=") {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("rasize=%d", uint64(volOptions.ReadAheadKB)*1024)
+}
@@ -19,6 +19,8 @@ package mounter
 import (
 	"testing"
 
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -36,3 +38,15 @@ func TestFilesystemSupported(t *testing.T) {
 	// "nonefs" is a made-up name, and does not exist
 	require.False(t, filesystemSupported("nonefs"))
 }
+
+func TestReadAheadOption(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, readAheadOption(&store.VolumeOptions{}))
+	require.Equal(t, "rasize=4194304", readAheadOption(&store.VolumeOptions{ReadAheadKB: 4096}))
+	// rasize in the mount options is not overridden
+	require.Empty(t, readAheadOption(&store.VolumeOptions{
+		ReadAheadKB:        4096,
+		KernelMountOptions: "norbytes, rasize=8192",
+	}))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mounter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/util"
+
+	netutil "k8s.io/utils/net"
+)
+
+const volumeMounterNFS = "nfs"
+
+// NFSMounter mounts the NFS-export of a CephFS volume from the Ceph managed
+// NFS-server, instead of connecting to the Ceph cluster directly.
+type NFSMounter struct{}
+
+// nfsSource returns the server:share source to mount the NFS-export of the
+// volume.
+func nfsSource(volOptions *store.VolumeOptions) (string, error) {
+	if volOptions.NFSServer == "" || volOptions.NFSShare == "" {
+		return "", errors.New("server and share of the NFS-export are missing in the volume context")
+	}
+
+	server := volOptions.NFSServer
+	if netutil.IsIPv6String(server) {
+		// if server is IPv6, format to [IPv6].
+		server = fmt.Sprintf("[%s]", server)
+	}
+
+	return fmt.Sprintf("%s:%s", server, volOptions.NFSShare), nil
+}
+
+func (m *NFSMounter) Mount(
+	ctx context.Context,
+	mountPoint string,
+	_ *util.Credentials,
+	volOptions *store.VolumeOptions,
+) error {
+	source, err := nfsSource(volOptions)
+	if err != nil {
+		return err
+	}
+
+	netNamespaceFilePath, err := util.GetNFSNetNamespaceFilePath(util.CsiConfigFile, volOptions.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	if err = util.CreateMountPoint(mountPoint); err != nil {
+		return err
+	}
+
+	args := []string{
+		"-t", "nfs",
+		source,
+		mountPoint,
+	}
+
+	if volOptions.NFSMountOptions != "" {
+		args = append(args, "-o", volOptions.NFSMountOptions)
+	}
+
+	_, stderr, err := util.ExecMountCommand(ctx, netNamespaceFilePath, "mount", args...)
+	if err != nil {
+		return fmt.Errorf("%w stderr: %s", err, stderr)
+	}
+
+	return nil
+}
+
+func (m *NFSMounter) Name() string { return "NFS client" }
@@ -0,0 +1,47 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mounter
+
+import (
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNFSSource(t *testing.T) {
+	t.Parallel()
+
+	source, err := nfsSource(&store.VolumeOptions{
+		NFSServer: "nfs.example.net",
+		NFSShare:  "/0001-0009-rook-ceph-0000000000000001-b0285c97-a0ce-11eb-8c66-0242ac110002",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "nfs.example.net:/0001-0009-rook-ceph-0000000000000001-b0285c97-a0ce-11eb-8c66-0242ac110002",
+		source)
+
+	source, err = nfsSource(&store.VolumeOptions{
+		NFSServer: "fd00::1",
+		NFSShare:  "/share",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "[fd00::1]:/share", source)
+
+	_, err = nfsSource(&store.VolumeOptions{NFSServer: "nfs.example.net"})
+	require.Error(t, err)
+}
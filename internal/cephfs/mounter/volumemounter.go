@@ -65,9 +65,12 @@ func LoadAvailableMounters(conf *util.Config) error {
 	// #nosec
 	kernelMounterProbe := exec.Command("mount.ceph")
 
+	nodeCaps := util.GetNodeCapabilities()
+
 	err := kernelMounterProbe.Run()
 	if err != nil {
 		log.ErrorLogMsg("failed to run mount.ceph %v", err)
+		nodeCaps.Set(util.CephFSKernelCapability, false, "")
 	} else {
 		// fetch the current running kernel info
 		release, kvErr := util.GetKernelVersion()
@@ -75,20 +78,36 @@ func LoadAvailableMounters(conf *util.Config) error {
 			return kvErr
 		}
 
-		if conf.ForceKernelCephFS || util.CheckKernelSupport(release, quotaSupport) {
+		hasQuota := util.CheckKernelSupport(release, quotaSupport)
+		nodeCaps.Set(util.CephFSQuotaCapability, hasQuota, "")
+		if conf.ForceKernelCephFS || hasQuota {
 			log.DefaultLog("loaded mounter: %s", volumeMounterKernel)
 			availableMounters = append(availableMounters, volumeMounterKernel)
+			nodeCaps.Set(util.CephFSKernelCapability, true, "")
 		} else {
 			log.DefaultLog("kernel version < 4.17 might not support quota feature, hence not loading kernel client")
+			nodeCaps.Set(util.CephFSKernelCapability, false, "")
 		}
 	}
 
-	err = fuseMounterProbe.Run()
+	fuseVersion, err := fuseMounterProbe.Output()
 	if err != nil {
 		log.ErrorLogMsg("failed to run ceph-fuse %v", err)
+		nodeCaps.Set(util.CephFSFuseCapability, false, "")
 	} else {
 		log.DefaultLog("loaded mounter: %s", volumeMounterFuse)
 		availableMounters = append(availableMounters, volumeMounterFuse)
+		nodeCaps.Set(util.CephFSFuseCapability, true, parseCephVersion(string(fuseVersion)))
+	}
+
+	// #nosec
+	nfsMounterProbe := exec.Command("mount.nfs", "-V")
+	err = nfsMounterProbe.Run()
+	if err != nil {
+		log.DefaultLog("mount.nfs is not available, not loading the NFS client: %v", err)
+	} else {
+		log.DefaultLog("loaded mounter: %s", volumeMounterNFS)
+		availableMounters = append(availableMounters, volumeMounterNFS)
 	}
 
 	if len(availableMounters) == 0 {
@@ -98,6 +117,20 @@ func LoadAvailableMounters(conf *util.Config) error {
 	return nil
 }
 
+// parseCephVersion returns the version number from the output of
+// "ceph-fuse --version", which looks like
+// "ceph version 18.2.0 (5dd24139a1eada541a3bc16b6941c5dde975e26d) reef (stable)".
+func parseCephVersion(output string) string {
+	const versionField = 2
+
+	fields := strings.Fields(output)
+	if len(fields) <= versionField {
+		return ""
+	}
+
+	return fields[versionField]
+}
+
 type VolumeMounter interface {
 	Mount(ctx context.Context, mountPoint string, cr *util.Credentials, volOptions *store.VolumeOptions) error
 	Name() string
@@ -120,9 +153,24 @@ func New(volOptions *store.VolumeOptions) (VolumeMounter, error) {
 		}
 	}
 
+	if chosenMounter == "" && wantMounter == volumeMounterNFS {
+		// the NFS-export can not be mounted by the Ceph clients
+		return nil, fmt.Errorf("requested mounter '%s' is not available", wantMounter)
+	}
+
 	if chosenMounter == "" {
-		// Otherwise pick whatever is left
-		chosenMounter = availableMounters[0]
+		// Otherwise pick whatever is left, the NFS client can only mount
+		// volumes that have been exported
+		for _, availMounter := range availableMounters {
+			if availMounter != volumeMounterNFS {
+				chosenMounter = availMounter
+
+				break
+			}
+		}
+		if chosenMounter == "" {
+			return nil, fmt.Errorf("no ceph mounters available for requested mounter '%s'", wantMounter)
+		}
 		log.DebugLogMsg("requested mounter: %s, chosen mounter: %s", wantMounter, chosenMounter)
 	}
 
@@ -132,6 +180,8 @@ func New(volOptions *store.VolumeOptions) (VolumeMounter, error) {
 		return &FuseMounter{}, nil
 	case volumeMounterKernel:
 		return NewKernelMounter(), nil
+	case volumeMounterNFS:
+		return &NFSMounter{}, nil
 	}
 
 	return nil, fmt.Errorf("unknown mounter '%s'", chosenMounter)
@@ -139,13 +189,13 @@ func New(volOptions *store.VolumeOptions) (VolumeMounter, error) {
 
 func BindMount(ctx context.Context, from, to string, readOnly bool, mntOptions []string) error {
 	mntOptionSli := strings.Join(mntOptions, ",")
-	if err := execCommandErr(ctx, "mount", "-o", mntOptionSli, from, to); err != nil {
+	if _, _, err := util.ExecMountCommand(ctx, "", "mount", "-o", mntOptionSli, from, to); err != nil {
 		return fmt.Errorf("failed to bind-mount %s to %s: %w", from, to, err)
 	}
 
 	if readOnly {
 		mntOptionSli = util.MountOptionsAdd(mntOptionSli, "remount")
-		if err := execCommandErr(ctx, "mount", "-o", mntOptionSli, to); err != nil {
+		if _, _, err := util.ExecMountCommand(ctx, "", "mount", "-o", mntOptionSli, to); err != nil {
 			return fmt.Errorf("failed read-only remount of %s: %w", to, err)
 		}
 	}
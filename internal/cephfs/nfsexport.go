@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/nfs/export"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+const (
+	// mounterNFS is the mounter of volumes that are consumed through an
+	// NFS-export on the Ceph managed NFS-server.
+	mounterNFS = "nfs"
+
+	paramNFSCluster = "nfsCluster"
+	paramNFSServer  = "server"
+	paramNFSShare   = "share"
+)
+
+// checkNFSMounter validates the parameters of a volume with the "nfs"
+// mounter, the NFS-cluster and server of the export are required.
+func checkNFSMounter(volOptions *store.VolumeOptions, params map[string]string) error {
+	if volOptions.Mounter != mounterNFS {
+		return nil
+	}
+
+	switch {
+	case params[paramNFSCluster] == "":
+		return fmt.Errorf("%q is required for the %q mounter", paramNFSCluster, mounterNFS)
+	case params[paramNFSServer] == "":
+		return fmt.Errorf("%q is required for the %q mounter", paramNFSServer, mounterNFS)
+	case volOptions.IsEncrypted():
+		return fmt.Errorf("encryption is not supported with the %q mounter", mounterNFS)
+	case volOptions.BackingSnapshot:
+		return fmt.Errorf("backingSnapshot is not supported with the %q mounter", mounterNFS)
+	}
+
+	return nil
+}
+
+// exportVolume creates the NFS-export for a volume with the "nfs" mounter,
+// when it does not exist yet, and sets the "share" in the volume context so
+// that the node can mount it.
+func exportVolume(
+	ctx context.Context,
+	volOptions *store.VolumeOptions,
+	volume *csi.Volume,
+	cr *util.Credentials,
+) error {
+	if volOptions.Mounter != mounterNFS {
+		return nil
+	}
+
+	nfsVolume, err := export.NewNFSVolume(ctx, volume.GetVolumeId())
+	if err != nil {
+		return err
+	}
+
+	err = nfsVolume.Connect(cr)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer nfsVolume.Destroy()
+
+	created, err := nfsVolume.EnsureExport(volume)
+	if err != nil {
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+	if created {
+		log.DebugLog(ctx, "published NFS-export: %s", nfsVolume)
+	}
+
+	volume.VolumeContext[paramNFSShare] = nfsVolume.GetExportPath()
+
+	return nil
+}
+
+// unexportVolume removes the NFS-export of a volume, in case it has been
+// exported for the "nfs" mounter.
+func unexportVolume(ctx context.Context, volID string, cr *util.Credentials) error {
+	nfsVolume, err := export.NewNFSVolume(ctx, volID)
+	if err != nil {
+		return err
+	}
+
+	err = nfsVolume.Connect(cr)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer nfsVolume.Destroy()
+
+	err = nfsVolume.DeleteExport()
+	if errors.Is(err, export.ErrNotFound) {
+		// the volume was not exported
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to delete export: %w", err)
+	}
+
+	log.DebugLog(ctx, "NFS-export %q has been deleted", nfsVolume)
+
+	return nil
+}
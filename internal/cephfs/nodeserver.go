@@ -25,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ceph/ceph-csi/internal/cephfs/clientmetrics"
 	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
 	"github.com/ceph/ceph-csi/internal/cephfs/mounter"
 	"github.com/ceph/ceph-csi/internal/cephfs/store"
@@ -33,7 +34,6 @@ import (
 	hc "github.com/ceph/ceph-csi/internal/health-checker"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/fscrypt"
-	iolock "github.com/ceph/ceph-csi/internal/util/lock"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -51,6 +51,27 @@ type NodeServer struct {
 	kernelMountOptions string
 	fuseMountOptions   string
 	healthChecker      hc.Manager
+
+	// ForceUnstage enables the escalation to a forced and a lazy umount,
+	// and the eviction of the client session, in NodeUnstageVolume when the
+	// volume can not be unmounted normally.
+	ForceUnstage bool
+
+	// ReadAheadKB is the readahead of the mounts of volumes without
+	// readAheadKB parameter, 0 keeps the default of the client.
+	ReadAheadKB uint
+
+	// MountProbeTimeout is the time the probe of a freshly staged volume
+	// may take, 0 disables the probe.
+	MountProbeTimeout time.Duration
+
+	// ClientMetrics publishes the counters of the kernel client of the
+	// staged volumes, nil disables the metrics.
+	ClientMetrics *clientmetrics.Collector
+
+	// StatsCache keeps the stats of the published volumes for
+	// NodeGetVolumeStats, nil disables the cache.
+	StatsCache *csicommon.VolumeStatsCache
 }
 
 func getCredentialsForVolume(
@@ -106,6 +127,13 @@ func (ns *NodeServer) getVolumeOptions(
 		}
 	}
 
+	volOptions.ReadAheadKB, err = util.GetReadAheadKB(volContext, ns.ReadAheadKB)
+	if err != nil {
+		volOptions.Destroy()
+
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	return volOptions, nil
 }
 
@@ -133,52 +161,11 @@ func maybeUnlockFileEncryption(
 		return nil
 	}
 
-	// Define Mutex Lock variables
-	volIDStr := string(volID)
-	lockName := volIDStr + "-mutexLock"
-	lockDesc := "Lock for " + volIDStr
-	lockDuration := 150 * time.Second
-	// Generate a consistent lock cookie for the client using hostname and process ID
-	lockCookie := generateLockCookie()
-
-	log.DebugLog(ctx, "Creating lock for the following volume ID %s", volID)
+	return volOptions.WithEncryptionLock(ctx, string(volID), func() error {
+		log.DebugLog(ctx, "cephfs: unlocking fscrypt on volume %q path %s", volID, stagingTargetPath)
 
-	ioctx, err := volOptions.GetConnection().GetIoctx(volOptions.MetadataPool)
-	if err != nil {
-		log.ErrorLog(ctx, "Failed to create ioctx: %s", err)
-
-		return err
-	}
-	defer ioctx.Destroy()
-
-	lock := iolock.NewLock(ioctx, volIDStr, lockName, lockCookie, lockDesc, lockDuration)
-	err = lock.LockExclusive(ctx)
-	if err != nil {
-		log.ErrorLog(ctx, "failed to create lock for volume ID %s: %v", volID, err)
-
-		return err
-	}
-	defer lock.Unlock(ctx)
-	log.DebugLog(ctx, "Lock successfully created for volume ID %s", volID)
-
-	log.DebugLog(ctx, "cephfs: unlocking fscrypt on volume %q path %s", volID, stagingTargetPath)
-	err = fscrypt.Unlock(ctx, volOptions.Encryption, stagingTargetPath, string(volID))
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// generateLockCookie generates a consistent lock cookie for the client.
-func generateLockCookie() string {
-	hostname, err := os.Hostname()
-	if err != nil {
-		hostname = "unknown-host"
-	}
-	pid := os.Getpid()
-
-	return fmt.Sprintf("%s-%d", hostname, pid)
+		return fscrypt.Unlock(ctx, volOptions.Encryption, stagingTargetPath, string(volID))
+	})
 }
 
 // maybeInitializeFileEncryption initializes KMS and node specifics, if volContext enables encryption.
@@ -191,6 +178,9 @@ func maybeInitializeFileEncryption(
 		if _, isFuse := mnt.(*mounter.FuseMounter); isFuse {
 			return errors.New("FUSE mounter does not support encryption")
 		}
+		if _, isNFS := mnt.(*mounter.NFSMounter); isNFS {
+			return errors.New("NFS mounter does not support encryption")
+		}
 
 		return fscrypt.InitializeNode(ctx)
 	}
@@ -301,12 +291,16 @@ func (ns *NodeServer) NodeStageVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if _, isFuse := mnt.(*mounter.FuseMounter); isFuse {
-		// FUSE mount recovery needs NodeStageMountinfo records.
+	if _, isFuse := mnt.(*mounter.FuseMounter); isFuse || ns.ForceUnstage {
+		// FUSE mount recovery and the client eviction of a forced
+		// NodeUnstageVolume need NodeStageMountinfo records.
 
 		if err = fsutil.WriteNodeStageMountinfo(volID, &fsutil.NodeStageMountinfo{
 			VolumeCapability: req.GetVolumeCapability(),
 			Secrets:          req.GetSecrets(),
+			Monitors:         volOptions.Monitors,
+			FsName:           volOptions.FsName,
+			RootPath:         volOptions.RootPath,
 		}); err != nil {
 			log.ErrorLog(ctx, "cephfs: failed to write NodeStageMountinfo for volume %s: %v", volID, err)
 
@@ -364,6 +358,18 @@ func (ns *NodeServer) mount(
 		return status.Error(codes.Internal, err.Error())
 	}
 
+	// only the Ceph kernel client publishes metrics
+	_, isFuse := mnt.(*mounter.FuseMounter)
+	_, isNFS := mnt.(*mounter.NFSMounter)
+	isKernel := !isFuse && !isNFS
+	var clients []string
+	if ns.ClientMetrics != nil && isKernel {
+		clients, err = ns.ClientMetrics.ListClients()
+		if err != nil {
+			log.WarningLog(ctx, "cephfs: failed to list kernel clients for the metrics of volume %s: %v", volID, err)
+		}
+	}
+
 	if err = mnt.Mount(ctx, stagingTargetPath, cr, volOptions); err != nil {
 		log.ErrorLog(ctx,
 			"failed to mount volume %s: %v Check dmesg logs if required.",
@@ -373,6 +379,10 @@ func (ns *NodeServer) mount(
 		return status.Error(codes.Internal, err.Error())
 	}
 
+	if ns.ClientMetrics != nil && isKernel {
+		ns.trackClientMetrics(ctx, string(volID), clients)
+	}
+
 	defer func() {
 		if err == nil {
 			return
@@ -406,9 +416,30 @@ func (ns *NodeServer) mount(
 		}
 	}
 
+	if ns.MountProbeTimeout != 0 {
+		err = hc.ProbeMount(stagingTargetPath, probeReadOnly(volOptions, volCap), ns.MountProbeTimeout)
+		if err != nil {
+			log.ErrorLog(ctx, "probe of volume %s mounted at %s failed: %v", volID, stagingTargetPath, err)
+
+			return status.Errorf(codes.Internal, "mount of volume %s is not usable: %v", volID, err)
+		}
+	}
+
 	return nil
 }
 
+// probeReadOnly returns true when a freshly staged volume can only be probed
+// without writing to it. Encrypted volumes are not unlocked yet, a file in
+// their root would prevent setting up the encryption.
+func probeReadOnly(volOptions *store.VolumeOptions, volCap *csi.VolumeCapability) bool {
+	mode := volCap.GetAccessMode().GetMode()
+
+	return volOptions.BackingSnapshot || volOptions.IsEncrypted() ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		csicommon.MountOptionContains(volCap.GetMount().GetMountFlags(), "ro")
+}
+
 func getBackingSnapshotRoot(
 	ctx context.Context,
 	volOptions *store.VolumeOptions,
@@ -535,7 +566,7 @@ func (ns *NodeServer) NodePublishVolume(
 		mountOptions = append(mountOptions, "ro")
 	}
 
-	mountOptions = csicommon.ConstructMountOptions(mountOptions, req.GetVolumeCapability())
+	mountOptions = csicommon.ConstructBindMountOptions(mountOptions, req.GetVolumeCapability())
 
 	// Ensure staging target path is a mountpoint.
 
@@ -614,6 +645,7 @@ func (ns *NodeServer) NodeUnpublishVolume(
 
 	// stop the health-checker that may have been started in NodeGetVolumeStats()
 	ns.healthChecker.StopChecker(volID, targetPath)
+	ns.StatsCache.Forget(targetPath)
 
 	isMnt, err := util.IsMountPoint(ns.Mounter, targetPath)
 	if err != nil {
@@ -679,8 +711,21 @@ func (ns *NodeServer) NodeUnstageVolume(
 	}
 	defer ns.VolumeLocks.Release(volID)
 
+	// the passphrase is fetched from the KMS again when the volume is staged
+	util.InvalidatePassphrase(volID)
+
 	stagingTargetPath := req.GetStagingTargetPath()
 
+	// the record identifies the client of the mount for the eviction, it is
+	// read before it is removed
+	var mountinfo *fsutil.NodeStageMountinfo
+	if ns.ForceUnstage {
+		mountinfo, err = fsutil.GetNodeStageMountinfo(fsutil.VolumeID(volID))
+		if err != nil {
+			log.WarningLog(ctx, "cephfs: failed to read NodeStageMountinfo for volume %s: %v", volID, err)
+		}
+	}
+
 	if err = fsutil.RemoveNodeStageMountinfo(fsutil.VolumeID(volID)); err != nil {
 		log.ErrorLog(ctx, "cephfs: failed to remove NodeStageMountinfo for volume %s: %v", volID, err)
 
@@ -713,15 +758,84 @@ func (ns *NodeServer) NodeUnstageVolume(
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 	// Unmount the volume
-	if err = mounter.UnmountAll(ctx, stagingTargetPath); err != nil {
+	if ns.ForceUnstage {
+		report := &util.CleanupReport{}
+		err = util.ForceUnmount(ctx, stagingTargetPath, report, "--all-targets")
+		if report.Reached(util.CleanupStageLazyUnmount) {
+			// a detached or stuck mount keeps the caps of its session
+			eErr := evictClient(ctx, mountinfo)
+			report.Add(util.CleanupStageClientEviction, eErr)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "%v (cleanup stages: %s)", err, report)
+		}
+		if report.Escalated() {
+			log.WarningLog(ctx, "cephfs: volume %s was unstaged with forced cleanup: %s", volID, report)
+		}
+	} else if err = mounter.UnmountAll(ctx, stagingTargetPath); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if ns.ClientMetrics != nil {
+		ns.ClientMetrics.Untrack(volID)
+	}
+
 	log.DebugLog(ctx, "cephfs: successfully unmounted volume %s from %s", req.GetVolumeId(), stagingTargetPath)
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// evictClient evicts the session of the client that mounted the volume on
+// this node through the MDS, with the credentials that were recorded by
+// NodeStageVolume. The clients of the other nodes are not evicted, as they
+// report another hostname.
+func evictClient(ctx context.Context, mountinfo *fsutil.NodeStageMountinfo) error {
+	if mountinfo == nil || mountinfo.FsName == "" {
+		return errors.New("client of the mount was not recorded by NodeStageVolume")
+	}
+
+	cr, err := util.NewAdminCredentials(mountinfo.Secrets)
+	if err != nil {
+		cr, err = util.NewUserCredentials(mountinfo.Secrets)
+		if err != nil {
+			return fmt.Errorf("failed to get credentials from node stage secrets: %w", err)
+		}
+	}
+	defer cr.DeleteCredentials()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the hostname: %w", err)
+	}
+
+	_, stderr, err := util.ExecCommandWithTimeout(ctx, time.Minute, "ceph",
+		"tell", fmt.Sprintf("mds.%s:0", mountinfo.FsName), "client", "evict",
+		"client_metadata.hostname="+hostname, "client_metadata.root="+mountinfo.RootPath,
+		"--id", cr.ID, "--keyfile="+cr.KeyFile, "-m", mountinfo.Monitors)
+	if err != nil {
+		return fmt.Errorf("failed to evict client of %s: %w, stderr: %q", mountinfo.RootPath, err, stderr)
+	}
+	log.DebugLog(ctx, "cephfs: evicted the client of %s on %s", mountinfo.RootPath, hostname)
+
+	return nil
+}
+
+// trackClientMetrics publishes the counters of the kernel client that was
+// created by mounting the volume. A mount that shares the kernel client with
+// other mounts has no counters of its own, and is not tracked. The clients are
+// the kernel client instances that existed before the volume was mounted.
+func (ns *NodeServer) trackClientMetrics(ctx context.Context, volID string, clients []string) {
+	tracked, err := ns.ClientMetrics.Track(volID, clients)
+	if err != nil {
+		log.WarningLog(ctx, "cephfs: failed to track the kernel client of volume %s for metrics: %v", volID, err)
+
+		return
+	}
+	if !tracked {
+		log.DebugLog(ctx, "cephfs: no dedicated kernel client for volume %s, it has no client metrics", volID)
+	}
+}
+
 // NodeGetCapabilities returns the supported capabilities of the node server.
 func (ns *NodeServer) NodeGetCapabilities(
 	ctx context.Context,
@@ -820,7 +934,9 @@ func (ns *NodeServer) NodeGetVolumeStats(
 	}
 
 	if stat.Mode().IsDir() {
-		return csicommon.FilesystemNodeGetVolumeStats(ctx, ns.Mounter, targetPath, false)
+		return ns.StatsCache.Get(ctx, targetPath, func(ctx context.Context) (*csi.NodeGetVolumeStatsResponse, error) {
+			return csicommon.FilesystemNodeGetVolumeStats(ctx, ns.Mounter, targetPath, false)
+		})
 	}
 
 	return nil, status.Errorf(codes.InvalidArgument, "targetpath %q is not a directory or device", targetPath)
@@ -870,6 +986,9 @@ func (ns *NodeServer) setMountOptions(
 		}
 		volOptions.FuseMountOptions = util.MountOptionsAdd(volOptions.FuseMountOptions, configuredMountOptions)
 		volOptions.FuseMountOptions = util.MountOptionsAdd(volOptions.FuseMountOptions, mountOptions...)
+	case *mounter.NFSMounter:
+		// the options of the Ceph clients do not apply to NFS
+		volOptions.NFSMountOptions = util.MountOptionsAdd(volOptions.NFSMountOptions, mountOptions...)
 	case mounter.KernelMounter:
 		configuredMountOptions = ns.kernelMountOptions
 		// override of kernelMountOptions are set
@@ -890,6 +1009,10 @@ func (ns *NodeServer) setMountOptions(
 			if !csicommon.MountOptionContains(strings.Split(volOptions.FuseMountOptions, ","), readOnly) {
 				volOptions.FuseMountOptions = util.MountOptionsAdd(volOptions.FuseMountOptions, readOnly)
 			}
+		case *mounter.NFSMounter:
+			if !csicommon.MountOptionContains(strings.Split(volOptions.NFSMountOptions, ","), readOnly) {
+				volOptions.NFSMountOptions = util.MountOptionsAdd(volOptions.NFSMountOptions, readOnly)
+			}
 		case mounter.KernelMounter:
 			if !csicommon.MountOptionContains(strings.Split(volOptions.KernelMountOptions, ","), readOnly) {
 				volOptions.KernelMountOptions = util.MountOptionsAdd(volOptions.KernelMountOptions, readOnly)
@@ -136,6 +136,18 @@ func Test_setMountOptions(t *testing.T) {
 			},
 			want: cliFuseMountOptions,
 		},
+		{
+			name: "KernelMountOptions set in cluster-1 config are not used by the NFS mounter",
+			ns: &NodeServer{
+				kernelMountOptions: cliKernelMountOptions,
+			},
+			mnt: mounter.VolumeMounter(&mounter.NFSMounter{}),
+			volOptions: &store.VolumeOptions{
+				ClusterID:       "cluster-1",
+				NFSMountOptions: "vers=4.1",
+			},
+			want: "vers=4.1",
+		},
 	}
 
 	volCap := &csi.VolumeCapability{
@@ -157,6 +169,10 @@ func Test_setMountOptions(t *testing.T) {
 			}
 
 			switch tt.mnt.(type) {
+			case *mounter.NFSMounter:
+				if tt.volOptions.NFSMountOptions != tt.want || tt.volOptions.KernelMountOptions != "" {
+					t.Errorf("Set NFSMountOptions = %v Required NFSMountOptions = %v", tt.volOptions.NFSMountOptions, tt.want)
+				}
 			case *mounter.FuseMounter:
 				if !strings.Contains(tt.volOptions.FuseMountOptions, tt.want) {
 					t.Errorf("Set FuseMountOptions = %v Required FuseMountOptions = %v", tt.volOptions.FuseMountOptions, tt.want)
@@ -169,3 +185,37 @@ func Test_setMountOptions(t *testing.T) {
 		})
 	}
 }
+
+func Test_probeReadOnly(t *testing.T) {
+	t.Parallel()
+
+	rwCap := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		},
+	}
+	roCap := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		},
+	}
+	roFlagCap := &csi.VolumeCapability{
+		AccessMode: rwCap.GetAccessMode(),
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"noatime", "ro"}},
+		},
+	}
+
+	if probeReadOnly(&store.VolumeOptions{}, rwCap) {
+		t.Error("read-write volume is probed read-only")
+	}
+	if !probeReadOnly(&store.VolumeOptions{}, roCap) {
+		t.Error("read-only access mode is probed read-write")
+	}
+	if !probeReadOnly(&store.VolumeOptions{}, roFlagCap) {
+		t.Error("ro mount flag is probed read-write")
+	}
+	if !probeReadOnly(&store.VolumeOptions{BackingSnapshot: true}, rwCap) {
+		t.Error("snapshot-backed volume is probed read-write")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/readiness"
+)
+
+// ProbeStorageClass checks that the cluster of the StorageClass accepts the
+// credentials in secrets, and that the filesystem and the journal in its
+// metadata pool can be accessed. Only the monitors are checked for a cluster
+// that is not used by a StorageClass.
+func ProbeStorageClass(ctx context.Context, t readiness.Target, secrets map[string]string) error {
+	if secrets == nil {
+		return util.ProbeMonitors(ctx, t.ClusterID)
+	}
+
+	err := util.ProbeCluster(ctx, t.ClusterID, secrets)
+	if err != nil || t.Pool == "" {
+		return err
+	}
+
+	monitors, err := util.Mons(util.CsiConfigFile, t.ClusterID)
+	if err != nil {
+		return err
+	}
+	radosNamespace, err := util.GetCephFSRadosNamespace(util.CsiConfigFile, t.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	cr, err := util.NewAdminCredentials(secrets)
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+
+	conn := &util.ClusterConnection{}
+	err = conn.Connect(monitors, cr)
+	if err != nil {
+		return err
+	}
+	defer conn.Destroy()
+
+	metadataPool, err := core.NewFileSystem(conn).GetMetadataPool(ctx, t.Pool)
+	if err != nil {
+		return fmt.Errorf("failed to get filesystem %q: %w", t.Pool, err)
+	}
+
+	j, err := store.VolJournal.Connect(monitors, radosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	// a missing journal is fine, it is created with the first volume
+	_, err = j.ListReservations(ctx, metadataPool, "", 1)
+	if err != nil {
+		return fmt.Errorf("failed to read the journal in pool %q (RADOS namespace %q): %w",
+			metadataPool, radosNamespace, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkSnapshotPoolUsage returns ResourceExhausted when a snapshot of the
+// subvolume could push the usage of its data pool over the configured
+// threshold. A snapshot keeps the currently used data of the subvolume
+// referenced, once the files are modified that space is not freed anymore.
+func (cs *ControllerServer) checkSnapshotPoolUsage(
+	ctx context.Context,
+	volOptions *store.VolumeOptions,
+	info *core.Subvolume,
+) error {
+	if cs.SnapshotPoolUsageThreshold == 0 {
+		return nil
+	}
+
+	pool := info.DataPool
+	if pool == "" {
+		pool = volOptions.Pool
+	}
+
+	pu, err := volOptions.GetConnection().GetPoolUsage(pool)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	err = pu.CheckThreshold(uint64(info.BytesUsed), cs.SnapshotPoolUsageThreshold)
+	if errors.Is(err, util.ErrPoolUsageExceeded) {
+		log.ErrorLog(ctx, "not creating snapshot of subvolume %q: %v", volOptions.VolID, err)
+
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkSnapshotCount returns ResourceExhausted when the subvolume already has
+// the maximum number of snapshots that is configured with the driver flag or
+// the VolumeSnapshotClass parameters.
+func (cs *ControllerServer) checkSnapshotCount(
+	ctx context.Context,
+	volOptions *store.VolumeOptions,
+	volClient core.SubVolumeClient,
+	parameters map[string]string,
+) error {
+	limit, err := util.GetMaxSnapshotsPerVolume(parameters, cs.MaxSnapshotsPerVolume)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	snaps, err := volClient.ListSnapshots(ctx)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if len(snaps) >= int(limit) {
+		log.ErrorLog(ctx, "subvolume %q has %d snapshots, the limit is %d", volOptions.VolID, len(snaps), limit)
+
+		return status.Errorf(codes.ResourceExhausted,
+			"volume %q has reached the limit of %d snapshots, delete snapshots before creating new ones",
+			volOptions.VolID, limit)
+	}
+
+	return nil
+}
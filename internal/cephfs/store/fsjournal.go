@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 
 	"github.com/ceph/ceph-csi/internal/cephfs/core"
 	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
@@ -31,6 +32,15 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// allowShrinkKey is the journal attribute that records the allowShrink
+// parameter of the StorageClass, ControllerExpandVolume does not receive it.
+const allowShrinkKey = "allowshrink"
+
+// subvolumeGroupKey is the journal attribute that records the subvolumegroup
+// of a volume that is spread over multiple subvolumegroups, and of the parent
+// volume of a snapshot.
+const subvolumeGroupKey = "subvolumegroup"
+
 var (
 	// VolJournal is used to maintain RADOS based journals for CO generated.
 	// VolumeName to backing CephFS subvolumes.
@@ -105,6 +115,11 @@ func CheckVolExists(ctx context.Context,
 	imageUUID := imageData.ImageUUID
 	vid.FsSubvolName = imageData.ImageAttributes.ImageName
 	volOptions.VolID = vid.FsSubvolName
+	volOptions.SubvolumeGroup, err = FetchSubvolumeGroup(ctx, j, volOptions.MetadataPool, imageUUID,
+		volOptions.SubvolumeGroup)
+	if err != nil {
+		return nil, err
+	}
 
 	vol := core.NewSubVolume(volOptions.conn, &volOptions.SubVolume, volOptions.ClusterID, clusterName, setMetadata)
 	if (sID != nil || pvID != nil) && imageData.ImageAttributes.BackingSnapshotID == "" {
@@ -308,6 +323,25 @@ func ReserveVol(ctx context.Context, volOptions *VolumeOptions, secret map[strin
 		return nil, err
 	}
 	volOptions.VolID = vid.FsSubvolName
+
+	if volOptions.AllowShrink {
+		err = j.StoreAttribute(ctx, volOptions.MetadataPool, imageUUID, allowShrinkKey, "true")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// snapshot-backed volumes do not have a subvolume of their own
+	if volOptions.SubvolumeGroupCount > 1 && !volOptions.BackingSnapshot {
+		volOptions.SubvolumeGroup = distributedSubvolumeGroup(volOptions.SubvolumeGroup,
+			volOptions.SubvolumeGroupCount, vid.FsSubvolName)
+		err = j.StoreAttribute(ctx, volOptions.MetadataPool, imageUUID, subvolumeGroupKey,
+			volOptions.SubvolumeGroup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// generate the volume ID to return to the CO system
 	vid.VolumeID, err = util.GenerateVolID(ctx, volOptions.Monitors, cr, volOptions.FscID,
 		"", volOptions.ClusterID, imageUUID)
@@ -321,6 +355,45 @@ func ReserveVol(ctx context.Context, volOptions *VolumeOptions, secret map[strin
 	return &vid, nil
 }
 
+// fetchAllowShrink returns true when the StorageClass of the volume opted in
+// to shrinking. Volumes created without the option have no attribute stored.
+func fetchAllowShrink(ctx context.Context, j *journal.Connection, pool, reservedUUID string) (bool, error) {
+	value, err := j.FetchAttribute(ctx, pool, reservedUUID, allowShrinkKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return value == "true", nil
+}
+
+// distributedSubvolumeGroup returns one of the count subvolumegroups that are
+// named <group>-<index>, picked by the hash of the name of the subvolume.
+func distributedSubvolumeGroup(group string, count int, subvolName string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(subvolName))
+
+	return fmt.Sprintf("%s-%d", group, h.Sum32()%uint32(count))
+}
+
+// FetchSubvolumeGroup returns the subvolumegroup that is stored in the
+// journal for the reservation, or group when none is stored.
+func FetchSubvolumeGroup(
+	ctx context.Context,
+	j *journal.Connection,
+	pool, reservedUUID, group string,
+) (string, error) {
+	value, err := j.FetchAttribute(ctx, pool, reservedUUID, subvolumeGroupKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return group, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
 // ReserveSnap is a helper routine to request a UUID reservation for the CSI SnapName and,
 // to generate the snapshot identifier for the reserved UUID.
 func ReserveSnap(
@@ -353,6 +426,13 @@ func ReserveSnap(
 		return nil, err
 	}
 
+	// the parent may be in another subvolumegroup than the configured one
+	err = j.StoreAttribute(ctx, volOptions.MetadataPool, imageUUID, subvolumeGroupKey,
+		volOptions.SubvolumeGroup)
+	if err != nil {
+		return nil, err
+	}
+
 	// generate the snapshot ID to return to the CO system
 	vid.SnapshotID, err = util.GenerateVolID(ctx, volOptions.Monitors, cr, volOptions.FscID,
 		"", volOptions.ClusterID, imageUUID)
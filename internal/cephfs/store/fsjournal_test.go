@@ -0,0 +1,46 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDistributedSubvolumeGroup(t *testing.T) {
+	t.Parallel()
+
+	const count = 4
+	groups := map[string]int{}
+	for i := range 100 {
+		subvolName := fmt.Sprintf("csi-vol-%08d-0000-0000-0000-000000000000", i)
+		group := distributedSubvolumeGroup("csi", count, subvolName)
+		if again := distributedSubvolumeGroup("csi", count, subvolName); again != group {
+			t.Fatalf("distributedSubvolumeGroup() = %q, then %q for %q", group, again, subvolName)
+		}
+		groups[group]++
+	}
+
+	if len(groups) != count {
+		t.Errorf("distributedSubvolumeGroup() used %d groups, want %d: %v", len(groups), count, groups)
+	}
+	for i := range count {
+		if _, ok := groups[fmt.Sprintf("csi-%d", i)]; !ok {
+			t.Errorf("distributedSubvolumeGroup() did not use group csi-%d: %v", i, groups)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
+	"github.com/ceph/ceph-csi/internal/cephfs/types"
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+var (
+	_ types.Manager  = &fsManager{}
+	_ types.Volume   = &fsVolume{}
+	_ types.Snapshot = &fsSnapshot{}
+)
+
+type fsManager struct {
+	// clusterName is set as metadata on the subvolumes and snapshots.
+	clusterName string
+	// setMetadata enables setting metadata on the subvolumes and snapshots.
+	setMetadata bool
+	// secrets contain the credentials to connect to the Ceph cluster.
+	secrets map[string]string
+
+	// creds are the cached credentials, will be freed on Destroy()
+	creds *util.Credentials
+}
+
+// NewManager returns a Manager that resolves CephFS volumes and snapshots
+// from their CSI IDs, with the credentials in secrets.
+func NewManager(clusterName string, setMetadata bool, secrets map[string]string) types.Manager {
+	return &fsManager{
+		clusterName: clusterName,
+		setMetadata: setMetadata,
+		secrets:     secrets,
+	}
+}
+
+// Destroy frees the resources of the Manager, the Volumes and Snapshots that
+// it returned need to be destroyed separately.
+func (mgr *fsManager) Destroy(ctx context.Context) {
+	if mgr.creds != nil {
+		mgr.creds.DeleteCredentials()
+		mgr.creds = nil
+	}
+}
+
+// getCredentials returns the credentials from the secrets of the Manager.
+func (mgr *fsManager) getCredentials() (*util.Credentials, error) {
+	if mgr.creds != nil {
+		return mgr.creds, nil
+	}
+
+	creds, err := util.NewAdminCredentials(mgr.secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+
+	mgr.creds = creds
+
+	return creds, nil
+}
+
+func (mgr *fsManager) GetVolumeByID(ctx context.Context, id string) (types.Volume, error) {
+	opts, vid, err := NewVolumeOptionsFromVolID(ctx, id, nil, mgr.secrets, mgr.clusterName, mgr.setMetadata)
+	if err != nil {
+		if opts != nil {
+			opts.Destroy()
+		}
+		if errors.Is(err, cerrors.ErrVolumeNotFound) {
+			return nil, fmt.Errorf("volume %s not found: %w", id, err)
+		}
+
+		return nil, fmt.Errorf("failed to get volume from id %q: %w", id, err)
+	}
+
+	return &fsVolume{
+		opts:        opts,
+		vid:         vid,
+		clusterName: mgr.clusterName,
+		setMetadata: mgr.setMetadata,
+	}, nil
+}
+
+func (mgr *fsManager) GetSnapshotByID(ctx context.Context, id string) (types.Snapshot, error) {
+	creds, err := mgr.getCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, info, sid, err := NewSnapshotOptionsFromID(ctx, id, creds, mgr.secrets, mgr.clusterName, mgr.setMetadata)
+	if err != nil {
+		if opts != nil {
+			opts.Destroy()
+		}
+		if errors.Is(err, cerrors.ErrSnapNotFound) {
+			return nil, fmt.Errorf("snapshot %s not found: %w", id, err)
+		}
+
+		return nil, fmt.Errorf("failed to get snapshot from id %q: %w", id, err)
+	}
+
+	return &fsSnapshot{
+		opts:        opts,
+		info:        info,
+		sid:         sid,
+		clusterName: mgr.clusterName,
+		setMetadata: mgr.setMetadata,
+	}, nil
+}
+
+// fsVolume is a subvolume that was resolved by the fsManager.
+type fsVolume struct {
+	opts        *VolumeOptions
+	vid         *VolumeIdentifier
+	clusterName string
+	setMetadata bool
+}
+
+func (v *fsVolume) Destroy(ctx context.Context) {
+	v.opts.Destroy()
+}
+
+func (v *fsVolume) GetID(ctx context.Context) (string, error) {
+	return v.vid.VolumeID, nil
+}
+
+func (v *fsVolume) GetName(ctx context.Context) (string, error) {
+	return v.vid.FsSubvolName, nil
+}
+
+func (v *fsVolume) GetFileSystem(ctx context.Context) (string, error) {
+	return v.opts.FsName, nil
+}
+
+func (v *fsVolume) GetClusterID(ctx context.Context) (string, error) {
+	return v.opts.ClusterID, nil
+}
+
+func (v *fsVolume) GetSubvolumeGroup(ctx context.Context) (string, error) {
+	return v.opts.SubvolumeGroup, nil
+}
+
+func (v *fsVolume) GetRootPath(ctx context.Context) (string, error) {
+	return v.opts.RootPath, nil
+}
+
+func (v *fsVolume) ToSubVolumeClient() core.SubVolumeClient {
+	return core.NewSubVolume(v.opts.conn, &v.opts.SubVolume, v.opts.ClusterID, v.clusterName, v.setMetadata)
+}
+
+// fsSnapshot is a snapshot of a subvolume that was resolved by the fsManager.
+type fsSnapshot struct {
+	// opts describe the subvolume of the snapshot
+	opts        *VolumeOptions
+	info        *core.SnapshotInfo
+	sid         *SnapshotIdentifier
+	clusterName string
+	setMetadata bool
+}
+
+func (s *fsSnapshot) Destroy(ctx context.Context) {
+	s.opts.Destroy()
+}
+
+func (s *fsSnapshot) GetID(ctx context.Context) (string, error) {
+	return s.sid.SnapshotID, nil
+}
+
+func (s *fsSnapshot) GetName(ctx context.Context) (string, error) {
+	return s.sid.FsSnapshotName, nil
+}
+
+func (s *fsSnapshot) GetFileSystem(ctx context.Context) (string, error) {
+	return s.opts.FsName, nil
+}
+
+func (s *fsSnapshot) GetClusterID(ctx context.Context) (string, error) {
+	return s.opts.ClusterID, nil
+}
+
+func (s *fsSnapshot) GetCreationTime(ctx context.Context) (*time.Time, error) {
+	return &s.info.CreatedAt, nil
+}
+
+func (s *fsSnapshot) ToSnapshotClient() core.SnapshotClient {
+	return core.NewSnapshot(s.opts.conn, s.sid.FsSnapshotName, s.opts.ClusterID, s.clusterName, s.setMetadata,
+		&s.opts.SubVolume)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerInvalidIDs(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+	mgr := NewManager("", false, map[string]string{})
+	defer mgr.Destroy(ctx)
+
+	_, err := mgr.GetVolumeByID(ctx, "not-a-volume-id")
+	require.ErrorIs(t, err, cerrors.ErrInvalidVolID)
+
+	// the snapshot can not be resolved without credentials
+	_, err = mgr.GetSnapshotByID(ctx, "not-a-snapshot-id")
+	require.Error(t, err)
+}
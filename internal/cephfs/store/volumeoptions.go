@@ -20,9 +20,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 
@@ -32,7 +34,9 @@ import (
 	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
 	kmsapi "github.com/ceph/ceph-csi/internal/kms"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/fscrypt"
 	"github.com/ceph/ceph-csi/internal/util/k8s"
+	iolock "github.com/ceph/ceph-csi/internal/util/lock"
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
@@ -57,10 +61,15 @@ type VolumeOptions struct {
 	KernelMountOptions   string `json:"kernelMountOptions"`
 	FuseMountOptions     string `json:"fuseMountOptions"`
 	NetNamespaceFilePath string
-	TopologyPools        *[]util.TopologyConstrainedPool
-	TopologyRequirement  *csi.TopologyRequirement
-	Topology             map[string]string
-	FscID                int64
+	// NFSServer and NFSShare locate the NFS-export of the volume, when
+	// it is mounted with the "nfs" mounter.
+	NFSServer           string
+	NFSShare            string
+	NFSMountOptions     string `json:"nfsMountOptions"`
+	TopologyPools       *[]util.TopologyConstrainedPool
+	TopologyRequirement *csi.TopologyRequirement
+	Topology            map[string]string
+	FscID               int64
 
 	// Encryption provides access to optional VolumeEncryption functions
 	Encryption *util.VolumeEncryption
@@ -72,6 +81,15 @@ type VolumeOptions struct {
 
 	ProvisionVolume bool `json:"provisionVolume"`
 	BackingSnapshot bool `json:"backingSnapshot"`
+	// AllowShrink permits ControllerExpandVolume to reduce the quota of the
+	// subvolume, as long as the data still fits.
+	AllowShrink bool `json:"allowShrink"`
+	// ReadAheadKB is the readahead of the mount in KiB, 0 keeps the default
+	// of the client.
+	ReadAheadKB uint
+	// SubvolumeGroupCount is the number of subvolumegroups that new
+	// subvolumes are spread over.
+	SubvolumeGroupCount int
 }
 
 // Connect a CephFS volume to the Ceph cluster.
@@ -81,7 +99,7 @@ func (vo *VolumeOptions) Connect(cr *util.Credentials) error {
 	}
 
 	conn := &util.ClusterConnection{}
-	if err := conn.Connect(vo.Monitors, cr); err != nil {
+	if err := conn.ConnectCluster(vo.ClusterID, vo.Monitors, cr); err != nil {
 		return err
 	}
 
@@ -109,6 +127,23 @@ func validateNonEmptyField(field, fieldName string) error {
 	return nil
 }
 
+// extractNameTemplate renders the volumeNameTemplate parameter into the name
+// prefix of the subvolume.
+func extractNameTemplate(namePrefix *string, options map[string]string) error {
+	var template string
+	err := extractOptionalOption(&template, k8s.VolumeNameTemplateParam, options)
+	if err != nil || template == "" {
+		return err
+	}
+	if _, ok := options["volumeNamePrefix"]; ok {
+		return fmt.Errorf("parameters %s and volumeNamePrefix can not be combined", k8s.VolumeNameTemplateParam)
+	}
+
+	*namePrefix, err = k8s.RenderVolumeNamePrefix(template, options)
+
+	return err
+}
+
 func extractOptionalOption(dest *string, optionLabel string, options map[string]string) error {
 	opt, ok := options[optionLabel]
 	if !ok {
@@ -144,13 +179,32 @@ func validateMounter(m string) error {
 	switch m {
 	case "fuse":
 	case "kernel":
+	case "nfs":
 	default:
-		return fmt.Errorf("unknown mounter '%s'. Valid options are 'fuse' and 'kernel'", m)
+		return fmt.Errorf("unknown mounter '%s'. Valid options are 'fuse', 'kernel' and 'nfs'", m)
 	}
 
 	return nil
 }
 
+// extractNFSOptions sets the location of the NFS-export and the NFS mount
+// options from the volume context, for volumes that use the "nfs" mounter.
+func (vo *VolumeOptions) extractNFSOptions(options map[string]string) error {
+	if vo.Mounter != "nfs" {
+		return nil
+	}
+
+	if err := extractOptionalOption(&vo.NFSServer, "server", options); err != nil {
+		return err
+	}
+
+	if err := extractOptionalOption(&vo.NFSShare, "share", options); err != nil {
+		return err
+	}
+
+	return extractOptionalOption(&vo.NFSMountOptions, "nfsMountOptions", options)
+}
+
 func (v *VolumeOptions) DetectMounter(options map[string]string) error {
 	return extractMounter(&v.Mounter, options)
 }
@@ -239,6 +293,11 @@ func getVolumeOptions(vo map[string]string) (*VolumeOptions, error) {
 	opts.SubvolumeGroup = clusterData.CephFS.SubvolumeGroup
 	opts.RadosNamespace = clusterData.CephFS.RadosNamespace
 
+	opts.SubvolumeGroupCount, err = util.CephFSSubvolumeGroupCount(util.CsiConfigFile, opts.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subvolumegroup count using clusterID (%s): %w", opts.ClusterID, err)
+	}
+
 	if err = extractOption(&opts.FsName, "fsName", vo); err != nil {
 		return nil, err
 	}
@@ -259,6 +318,7 @@ func NewVolumeOptions(
 	var (
 		opts                *VolumeOptions
 		backingSnapshotBool string
+		allowShrinkBool     string
 		err                 error
 	)
 
@@ -291,6 +351,10 @@ func NewVolumeOptions(
 		return nil, err
 	}
 
+	if err = extractNameTemplate(&opts.NamePrefix, volOptions); err != nil {
+		return nil, err
+	}
+
 	if err = extractOptionalOption(&backingSnapshotBool, "backingSnapshot", volOptions); err != nil {
 		return nil, err
 	}
@@ -305,6 +369,16 @@ func NewVolumeOptions(
 		}
 	}
 
+	if err = extractOptionalOption(&allowShrinkBool, "allowShrink", volOptions); err != nil {
+		return nil, err
+	}
+
+	if allowShrinkBool != "" {
+		if opts.AllowShrink, err = strconv.ParseBool(allowShrinkBool); err != nil {
+			return nil, fmt.Errorf("failed to parse allowShrink: %w", err)
+		}
+	}
+
 	opts.RequestName = requestName
 
 	err = opts.Connect(cr)
@@ -462,6 +536,11 @@ func NewVolumeOptionsFromVolID(
 	vid.FsSubvolName = imageAttributes.ImageName
 	volOptions.Owner = imageAttributes.Owner
 
+	volOptions.AllowShrink, err = fetchAllowShrink(ctx, j, volOptions.MetadataPool, vi.ObjectUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if volOpt != nil {
 		if err = extractOptionalOption(&volOptions.Pool, "pool", volOpt); err != nil {
 			return nil, nil, err
@@ -483,11 +562,21 @@ func NewVolumeOptionsFromVolID(
 			return nil, nil, err
 		}
 
+		if err = volOptions.extractNFSOptions(volOpt); err != nil {
+			return nil, nil, err
+		}
+
 		if err = volOptions.InitKMS(ctx, volOpt, secrets); err != nil {
 			return nil, nil, err
 		}
 	}
 
+	volOptions.SubvolumeGroup, err = FetchSubvolumeGroup(ctx, j, volOptions.MetadataPool, vi.ObjectUUID,
+		volOptions.SubvolumeGroup)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if imageAttributes.BackingSnapshotID != "" || volOptions.BackingSnapshotID != "" {
 		volOptions.BackingSnapshot = true
 		volOptions.BackingSnapshotID = imageAttributes.BackingSnapshotID
@@ -587,9 +676,13 @@ func (vo *VolumeOptions) populateVolumeOptionsFromBackingSnapshot(
 		return fmtBackingSnapshotOptionMismatch("fsName", vo.FsName, parentBackingSnapVolOpts.FsName)
 	}
 
-	if vo.SubvolumeGroup != parentBackingSnapVolOpts.SubvolumeGroup {
+	// the parent may be in one of the subvolumegroups that volumes are
+	// spread over, which are named <SubvolumeGroup>-<index>
+	if vo.SubvolumeGroup != parentBackingSnapVolOpts.SubvolumeGroup &&
+		!strings.HasPrefix(parentBackingSnapVolOpts.SubvolumeGroup, vo.SubvolumeGroup+"-") {
 		return fmtBackingSnapshotOptionMismatch("SubvolumeGroup", vo.SubvolumeGroup, parentBackingSnapVolOpts.SubvolumeGroup)
 	}
+	vo.SubvolumeGroup = parentBackingSnapVolOpts.SubvolumeGroup
 
 	vo.Features = parentBackingSnapVolOpts.Features
 	vo.Size = parentBackingSnapVolOpts.Size
@@ -748,6 +841,10 @@ func NewVolumeOptionsFromStaticVolume(
 		return nil, nil, err
 	}
 
+	if err = opts.extractNFSOptions(options); err != nil {
+		return nil, nil, err
+	}
+
 	if err = opts.InitKMS(context.TODO(), options, secrets); err != nil {
 		return nil, nil, err
 	}
@@ -847,6 +944,12 @@ func NewSnapshotOptionsFromID(
 	sid.FsSnapshotName = imageAttributes.ImageName
 	sid.FsSubvolName = imageAttributes.SourceName
 
+	volOptions.SubvolumeGroup, err = FetchSubvolumeGroup(ctx, j, volOptions.MetadataPool, vi.ObjectUUID,
+		volOptions.SubvolumeGroup)
+	if err != nil {
+		return &volOptions, nil, &sid, err
+	}
+
 	volOptions.SubVolume.VolID = sid.FsSubvolName
 	volOptions.Owner = imageAttributes.Owner
 	vol := core.NewSubVolume(volOptions.conn, &volOptions.SubVolume, volOptions.ClusterID, clusterName, setMetadata)
@@ -939,6 +1042,24 @@ func IsEncrypted(ctx context.Context, volOptions map[string]string) (bool, error
 // CopyEncryptionConfig copies passphrases and initializes a fresh
 // Encryption struct if necessary from (vo, vID) to (cp, cpVID).
 func (vo *VolumeOptions) CopyEncryptionConfig(ctx context.Context, cp *VolumeOptions, vID, cpVID string) error {
+	return vo.copyEncryptionConfig(ctx, cp, vID, cpVID, false)
+}
+
+// InheritEncryptionKey sets up the encryption of the volume (cp, cpVID) that
+// is created from the snapshot or volume (vo, vID) like CopyEncryptionConfig.
+// When the KMS stores the passphrases, the volume gets a new passphrase, the
+// passphrase of the source is only kept until the nodeplugin protected the
+// data of the volume with the new one, see fscrypt.StoreInheritedKey.
+func (vo *VolumeOptions) InheritEncryptionKey(ctx context.Context, cp *VolumeOptions, vID, cpVID string) error {
+	return vo.copyEncryptionConfig(ctx, cp, vID, cpVID, true)
+}
+
+func (vo *VolumeOptions) copyEncryptionConfig(
+	ctx context.Context,
+	cp *VolumeOptions,
+	vID, cpVID string,
+	newKey bool,
+) error {
 	var err error
 
 	if !vo.IsEncrypted() {
@@ -960,6 +1081,11 @@ func (vo *VolumeOptions) CopyEncryptionConfig(ctx context.Context, cp *VolumeOpt
 		}
 	}
 
+	if newKey && vo.Encryption.KMS.RequiresDEKStore() == kmsapi.DEKStoreIntegrated &&
+		cp.Encryption.KMS.RequiresDEKStore() == kmsapi.DEKStoreIntegrated {
+		return fscrypt.StoreInheritedKey(ctx, vo.Encryption, vID, cp.Encryption, cpVID)
+	}
+
 	if vo.Encryption.KMS.RequiresDEKStore() == kmsapi.DEKStoreIntegrated {
 		passphrase, err := vo.Encryption.GetCryptoPassphrase(ctx, vID)
 		if err != nil {
@@ -977,6 +1103,38 @@ func (vo *VolumeOptions) CopyEncryptionConfig(ctx context.Context, cp *VolumeOpt
 	return nil
 }
 
+// InheritEncryptionConfig sets up the encryption of the volume cp that is
+// created from the snapshot or volume vo. The data of an encrypted source can
+// only be read with its passphrase, so cp uses the KMS of vo when it does not
+// enable encryption itself. A different KMS can only be used when both KMS
+// store the passphrases of the volumes, so that the passphrase of vo can be
+// stored for cp. cp gets a new passphrase with InheritEncryptionKey.
+func (vo *VolumeOptions) InheritEncryptionConfig(
+	ctx context.Context,
+	cp *VolumeOptions,
+	credentials map[string]string,
+) error {
+	switch {
+	case !vo.IsEncrypted() && !cp.IsEncrypted():
+		return nil
+	case !vo.IsEncrypted():
+		return fmt.Errorf("%w: can not encrypt a volume that is created from an unencrypted source",
+			cerrors.ErrIncompatibleEncryption)
+	case !cp.IsEncrypted():
+		return cp.ConfigureEncryption(ctx, vo.Encryption.GetID(), credentials)
+	case vo.Encryption.GetID() == cp.Encryption.GetID():
+		return nil
+	}
+
+	if vo.Encryption.KMS.RequiresDEKStore() != kmsapi.DEKStoreIntegrated ||
+		cp.Encryption.KMS.RequiresDEKStore() != kmsapi.DEKStoreIntegrated {
+		return fmt.Errorf("%w: the passphrase of the source in KMS %q can not be stored in KMS %q",
+			cerrors.ErrIncompatibleEncryption, vo.Encryption.GetID(), cp.Encryption.GetID())
+	}
+
+	return nil
+}
+
 // ConfigureEncryption initializes the Ceph CSI key management from
 // kmsID and credentials. Sets vo.Encryption on success.
 func (vo *VolumeOptions) ConfigureEncryption(
@@ -1008,6 +1166,50 @@ func (vo *VolumeOptions) ConfigureEncryption(
 	return nil
 }
 
+// WithEncryptionLock runs fn while holding an exclusive lock on the volume in
+// the metadata pool. The lock serializes the changes to the fscrypt metadata
+// of the volume, which may be staged on multiple nodes at the same time.
+func (vo *VolumeOptions) WithEncryptionLock(ctx context.Context, volID string, fn func() error) error {
+	lockName := volID + "-mutexLock"
+	lockDesc := "Lock for " + volID
+	lockDuration := 150 * time.Second
+	// Generate a consistent lock cookie for the client using hostname and process ID
+	lockCookie := generateLockCookie()
+
+	log.DebugLog(ctx, "Creating lock for the following volume ID %s", volID)
+
+	ioctx, err := vo.GetConnection().GetIoctx(vo.MetadataPool)
+	if err != nil {
+		log.ErrorLog(ctx, "Failed to create ioctx: %s", err)
+
+		return err
+	}
+	defer ioctx.Destroy()
+
+	lock := iolock.NewLock(ioctx, volID, lockName, lockCookie, lockDesc, lockDuration)
+	err = lock.LockExclusive(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to create lock for volume ID %s: %v", volID, err)
+
+		return err
+	}
+	defer lock.Unlock(ctx)
+	log.DebugLog(ctx, "Lock successfully created for volume ID %s", volID)
+
+	return fn()
+}
+
+// generateLockCookie generates a consistent lock cookie for the client.
+func generateLockCookie() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown-host"
+	}
+	pid := os.Getpid()
+
+	return fmt.Sprintf("%s-%d", hostname, pid)
+}
+
 // InitKMS initialized the Ceph CSI key management by parsing the
 // configuration from volume options + credentials. Sets vo.Encryption
 // on success.
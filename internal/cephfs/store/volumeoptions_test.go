@@ -17,8 +17,14 @@ limitations under the License.
 package store
 
 import (
+	"context"
+	"errors"
 	"testing"
 
+	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
+	kmsapi "github.com/ceph/ceph-csi/internal/kms"
+	"github.com/ceph/ceph-csi/internal/util"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
 
@@ -218,3 +224,61 @@ func TestIsShallowVolumeSupported(t *testing.T) {
 		})
 	}
 }
+
+func encryptedVolumeOptions(t *testing.T, kmsID, kmsType string) *VolumeOptions {
+	t.Helper()
+
+	ve, err := util.NewVolumeEncryption(kmsID, kmsapi.GetKMSTestDummy(kmsType))
+	if err != nil && !errors.Is(err, util.ErrDEKStoreNeeded) {
+		t.Fatalf("failed to create volume encryption: %v", err)
+	}
+
+	return &VolumeOptions{Encryption: ve}
+}
+
+func TestInheritEncryptionConfig(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		source  *VolumeOptions
+		clone   *VolumeOptions
+		wantErr bool
+	}{
+		{
+			name:   "unencrypted source and clone",
+			source: &VolumeOptions{},
+			clone:  &VolumeOptions{},
+		},
+		{
+			name:    "encrypted clone of unencrypted source",
+			source:  &VolumeOptions{},
+			clone:   encryptedVolumeOptions(t, "integrated", kmsapi.DefaultKMSType),
+			wantErr: true,
+		},
+		{
+			name:   "same KMS without DEK store",
+			source: encryptedVolumeOptions(t, "metadata", "metadata"),
+			clone:  encryptedVolumeOptions(t, "metadata", "metadata"),
+		},
+		{
+			name:   "different KMS with DEK store",
+			source: encryptedVolumeOptions(t, "integrated", kmsapi.DefaultKMSType),
+			clone:  encryptedVolumeOptions(t, "other-integrated", kmsapi.DefaultKMSType),
+		},
+		{
+			name:    "different KMS without DEK store",
+			source:  encryptedVolumeOptions(t, "integrated", kmsapi.DefaultKMSType),
+			clone:   encryptedVolumeOptions(t, "metadata", "metadata"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.source.InheritEncryptionConfig(context.TODO(), tt.clone, nil)
+			if tt.wantErr != errors.Is(err, cerrors.ErrIncompatibleEncryption) {
+				t.Errorf("InheritEncryptionConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+)
+
+type journalledObject interface {
+	// GetID returns the CSI ID of the object.
+	GetID(ctx context.Context) (string, error)
+
+	// GetName returns the name of the object in the filesystem.
+	GetName(ctx context.Context) (string, error)
+
+	// GetFileSystem returns the name of the filesystem that holds the
+	// object.
+	GetFileSystem(ctx context.Context) (string, error)
+
+	// GetClusterID returns the ID of the cluster of the object.
+	GetClusterID(ctx context.Context) (string, error)
+}
+
+// Volume is a CephFS subvolume that was provisioned by the driver.
+type Volume interface {
+	journalledObject
+
+	// Destroy frees the resources used by the Volume.
+	Destroy(ctx context.Context)
+
+	// GetSubvolumeGroup returns the subvolumegroup of the subvolume.
+	GetSubvolumeGroup(ctx context.Context) (string, error)
+
+	// GetRootPath returns the path of the subvolume in the filesystem.
+	GetRootPath(ctx context.Context) (string, error)
+
+	// ToSubVolumeClient returns a client for operations on the subvolume.
+	ToSubVolumeClient() core.SubVolumeClient
+}
+
+// Snapshot is a snapshot of a CephFS subvolume.
+type Snapshot interface {
+	journalledObject
+
+	// Destroy frees the resources used by the Snapshot.
+	Destroy(ctx context.Context)
+
+	// GetCreationTime returns the time the snapshot was taken.
+	GetCreationTime(ctx context.Context) (*time.Time, error)
+
+	// ToSnapshotClient returns a client for operations on the snapshot.
+	ToSnapshotClient() core.SnapshotClient
+}
+
+// Manager resolves the CSI IDs of CephFS volumes and snapshots, like the
+// Manager of RBD does for the CSI-Addons services.
+type Manager interface {
+	// GetVolumeByID uses the CSI VolumeId to resolve the returned Volume.
+	GetVolumeByID(ctx context.Context, id string) (Volume, error)
+
+	// GetSnapshotByID uses the CSI SnapshotId to resolve the returned
+	// Snapshot.
+	GetSnapshotByID(ctx context.Context, id string) (Snapshot, error)
+
+	// Destroy frees all resources that the Manager allocated.
+	Destroy(ctx context.Context)
+}
@@ -0,0 +1,380 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/driverstatus"
+	"github.com/ceph/ceph-csi/internal/util/journalstats"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/usage"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// usageSource is a filesystem that is used by a StorageClass of the driver,
+// with the secret that is used for provisioning.
+type usageSource struct {
+	clusterID       string
+	fsName          string
+	secretName      string
+	secretNamespace string
+}
+
+// CollectUsage returns a usage.Collector that accounts the subvolumes in the
+// journals of the filesystems of the StorageClasses to the namespace of their
+// PVC. A filesystem or a subvolume that can not be read is skipped, so that
+// the other volumes are still reported.
+func CollectUsage(driverName string) usage.Collector {
+	return func(ctx context.Context) (*usage.Report, error) {
+		c, err := kubeclient.NewK8sClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Kubernetes: %w", err)
+		}
+
+		sources, err := getUsageSources(ctx, c, driverName)
+		if err != nil {
+			return nil, err
+		}
+
+		report := usage.NewReport()
+		for _, source := range sources {
+			err = collectFilesystemUsage(ctx, c, source, report)
+			if err != nil {
+				log.ErrorLog(ctx, "failed to collect usage of filesystem %q in cluster %q: %v",
+					source.fsName, source.clusterID, err)
+			}
+		}
+
+		return report, nil
+	}
+}
+
+func getUsageSources(ctx context.Context, c *k8s.Clientset, driverName string) ([]usageSource, error) {
+	scs, err := c.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StorageClasses: %w", err)
+	}
+
+	sources := []usageSource{}
+	for i := range scs.Items {
+		sc := &scs.Items[i]
+		if sc.Provisioner != driverName {
+			continue
+		}
+
+		source := usageSource{
+			clusterID:       sc.Parameters["clusterID"],
+			fsName:          sc.Parameters["fsName"],
+			secretName:      sc.Parameters["csi.storage.k8s.io/provisioner-secret-name"],
+			secretNamespace: sc.Parameters["csi.storage.k8s.io/provisioner-secret-namespace"],
+		}
+		if source.clusterID == "" || source.fsName == "" || source.secretName == "" {
+			log.WarningLog(ctx, "skipping StorageClass %q for usage reporting, it has no clusterID, fsName or secret",
+				sc.Name)
+
+			continue
+		}
+
+		if !slices.ContainsFunc(sources, func(s usageSource) bool {
+			return s.clusterID == source.clusterID && s.fsName == source.fsName
+		}) {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources, nil
+}
+
+// usageConnection is the connection to the cluster and the journal of a
+// usageSource.
+type usageConnection struct {
+	conn           *util.ClusterConnection
+	journal        *journal.Connection
+	cr             *util.Credentials
+	metadataPool   string
+	subvolumeGroup string
+	monitors       string
+	radosNamespace string
+}
+
+// connectUsageSource connects to the cluster and the journal of the source,
+// with the credentials from the secret of the StorageClass.
+func connectUsageSource(ctx context.Context, c *k8s.Clientset, source usageSource) (*usageConnection, error) {
+	secret, err := c.CoreV1().Secrets(source.secretNamespace).Get(ctx, source.secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", source.secretNamespace, source.secretName, err)
+	}
+	secrets := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		secrets[k] = string(v)
+	}
+
+	monitors, err := util.Mons(util.CsiConfigFile, source.clusterID)
+	if err != nil {
+		return nil, err
+	}
+	radosNamespace, err := util.GetCephFSRadosNamespace(util.CsiConfigFile, source.clusterID)
+	if err != nil {
+		return nil, err
+	}
+	subvolumeGroup, err := util.CephFSSubvolumeGroup(util.CsiConfigFile, source.clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	uc := &usageConnection{
+		subvolumeGroup: subvolumeGroup,
+		monitors:       monitors,
+		radosNamespace: radosNamespace,
+	}
+	uc.cr, err = util.NewAdminCredentials(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	uc.conn = &util.ClusterConnection{}
+	err = uc.conn.Connect(monitors, uc.cr)
+	if err != nil {
+		uc.Destroy()
+
+		return nil, err
+	}
+
+	uc.metadataPool, err = core.NewFileSystem(uc.conn).GetMetadataPool(ctx, source.fsName)
+	if err != nil {
+		uc.Destroy()
+
+		return nil, err
+	}
+
+	uc.journal, err = store.VolJournal.Connect(monitors, radosNamespace, uc.cr)
+	if err != nil {
+		uc.Destroy()
+
+		return nil, err
+	}
+
+	return uc, nil
+}
+
+// Destroy releases the connections and the credentials.
+func (uc *usageConnection) Destroy() {
+	if uc.journal != nil {
+		uc.journal.Destroy()
+	}
+	if uc.conn != nil {
+		uc.conn.Destroy()
+	}
+	uc.cr.DeleteCredentials()
+}
+
+func collectFilesystemUsage(ctx context.Context, c *k8s.Clientset, source usageSource, report *usage.Report) error {
+	uc, err := connectUsageSource(ctx, c, source)
+	if err != nil {
+		return err
+	}
+	defer uc.Destroy()
+	reservations, err := uc.journal.ListReservations(ctx, uc.metadataPool, "", 0)
+	if err != nil {
+		return err
+	}
+
+	uuids := make([]string, 0, len(reservations))
+	for _, r := range reservations {
+		uuids = append(uuids, r.ImageUUID)
+	}
+	prefetched, err := uc.journal.GetImageAttributesBatch(ctx, uc.metadataPool, uuids, false)
+	if err != nil {
+		return err
+	}
+	ctx = uc.journal.WithImageAttributes(ctx, uc.metadataPool, prefetched, false)
+
+	for _, r := range reservations {
+		attrs, aErr := uc.journal.GetImageAttributes(ctx, uc.metadataPool, r.ImageUUID, false)
+		if aErr != nil {
+			if !errors.Is(aErr, util.ErrKeyNotFound) {
+				log.ErrorLog(ctx, "skipping usage of volume %q: %v", r.RequestName, aErr)
+			}
+
+			continue
+		}
+		// snapshot-backed volumes do not have a subvolume of their own
+		if attrs.BackingSnapshotID != "" {
+			continue
+		}
+
+		subvolumeGroup, gErr := store.FetchSubvolumeGroup(ctx, uc.journal, uc.metadataPool, r.ImageUUID,
+			uc.subvolumeGroup)
+		if gErr != nil {
+			log.ErrorLog(ctx, "skipping usage of subvolume %q: %v", attrs.ImageName, gErr)
+
+			continue
+		}
+
+		vol := core.NewSubVolume(uc.conn, &core.SubVolume{
+			VolID:          attrs.ImageName,
+			FsName:         source.fsName,
+			SubvolumeGroup: subvolumeGroup,
+		}, source.clusterID, "", false)
+		info, iErr := vol.GetSubVolumeInfo(ctx)
+		if iErr != nil {
+			if !errors.Is(iErr, cerrors.ErrVolumeNotFound) {
+				log.ErrorLog(ctx, "skipping usage of subvolume %q: %v", attrs.ImageName, iErr)
+			}
+
+			continue
+		}
+
+		report.Add(attrs.Owner, info.BytesQuota, info.BytesUsed)
+	}
+
+	return nil
+}
+
+// CountVolumes returns a driverstatus.VolumeCounter that counts the
+// reservations in the journals of the filesystems of the StorageClasses.
+func CountVolumes(driverName string) driverstatus.VolumeCounter {
+	return func(ctx context.Context) []driverstatus.VolumeCount {
+		c, err := kubeclient.NewK8sClient()
+		if err != nil {
+			log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+			return nil
+		}
+
+		sources, err := getUsageSources(ctx, c, driverName)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", driverName, err)
+
+			return nil
+		}
+
+		counts := make([]driverstatus.VolumeCount, 0, len(sources))
+		for _, source := range sources {
+			vc := driverstatus.VolumeCount{ClusterID: source.clusterID, Pool: source.fsName}
+			vc.Volumes, err = countFilesystemVolumes(ctx, c, source)
+			if err != nil {
+				vc.Error = err.Error()
+			}
+			counts = append(counts, vc)
+		}
+
+		return counts
+	}
+}
+
+func countFilesystemVolumes(ctx context.Context, c *k8s.Clientset, source usageSource) (int, error) {
+	uc, err := connectUsageSource(ctx, c, source)
+	if err != nil {
+		return 0, err
+	}
+	defer uc.Destroy()
+
+	reservations, err := uc.journal.ListReservations(ctx, uc.metadataPool, "", 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(reservations), nil
+}
+
+// CountJournalEntries returns a journalstats.Counter that counts the
+// volumes, snapshots and groups in the journals of the filesystems of the
+// StorageClasses.
+func CountJournalEntries(driverName string) journalstats.Counter {
+	return func(ctx context.Context) []journalstats.PoolEntries {
+		c, err := kubeclient.NewK8sClient()
+		if err != nil {
+			log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+			return nil
+		}
+
+		sources, err := getUsageSources(ctx, c, driverName)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", driverName, err)
+
+			return nil
+		}
+
+		pools := make([]journalstats.PoolEntries, 0, len(sources))
+		for _, source := range sources {
+			pe := journalstats.PoolEntries{ClusterID: source.clusterID, Pool: source.fsName}
+			err = countFilesystemJournalEntries(ctx, c, source, &pe)
+			if err != nil {
+				pe.Error = err.Error()
+			}
+			pools = append(pools, pe)
+		}
+
+		return pools
+	}
+}
+
+func countFilesystemJournalEntries(
+	ctx context.Context,
+	c *k8s.Clientset,
+	source usageSource,
+	pe *journalstats.PoolEntries,
+) error {
+	uc, err := connectUsageSource(ctx, c, source)
+	if err != nil {
+		return err
+	}
+	defer uc.Destroy()
+
+	pe.Volumes, err = uc.journal.CountReservations(ctx, uc.metadataPool)
+	if err != nil {
+		return fmt.Errorf("failed to count volumes: %w", err)
+	}
+
+	sj, err := store.SnapJournal.Connect(uc.monitors, uc.radosNamespace, uc.cr)
+	if err != nil {
+		return err
+	}
+	defer sj.Destroy()
+
+	pe.Snapshots, err = sj.CountReservations(ctx, uc.metadataPool)
+	if err != nil {
+		return fmt.Errorf("failed to count snapshots: %w", err)
+	}
+
+	vgj, err := store.VolumeGroupJournal.Connect(uc.monitors, uc.radosNamespace, uc.cr)
+	if err != nil {
+		return err
+	}
+	defer vgj.Destroy()
+
+	pe.Groups, err = vgj.CountReservations(ctx, uc.metadataPool)
+	if err != nil {
+		return fmt.Errorf("failed to count groups: %w", err)
+	}
+
+	return nil
+}
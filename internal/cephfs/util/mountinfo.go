@@ -29,7 +29,8 @@ import (
 )
 
 // This file provides functionality to store various mount information
-// in a file. It's currently used to restore ceph-fuse mounts.
+// in a file. It's currently used to restore ceph-fuse mounts, and to evict the
+// client of a mount that is unstaged by force.
 // Mount info is stored in `/csi/mountinfo`.
 
 const (
@@ -44,6 +45,9 @@ type nodeStageMountinfoRecord struct {
 	VolumeCapabilityProtoJSON string            `json:",omitempty"`
 	MountOptions              []string          `json:",omitempty"`
 	Secrets                   map[string]string `json:",omitempty"`
+	Monitors                  string            `json:",omitempty"`
+	FsName                    string            `json:",omitempty"`
+	RootPath                  string            `json:",omitempty"`
 }
 
 // NodeStageMountinfo describes mountinfo of a volume.
@@ -51,6 +55,11 @@ type NodeStageMountinfo struct {
 	VolumeCapability *csi.VolumeCapability
 	Secrets          map[string]string
 	MountOptions     []string
+	// Monitors, FsName and RootPath identify the session of the client of
+	// the mount, to evict it when the volume is unstaged by force.
+	Monitors string
+	FsName   string
+	RootPath string
 }
 
 func fmtNodeStageMountinfoFilename(volID VolumeID) string {
@@ -67,6 +76,9 @@ func (mi *NodeStageMountinfo) toNodeStageMountinfoRecord() (*nodeStageMountinfoR
 		VolumeCapabilityProtoJSON: string(bs),
 		MountOptions:              mi.MountOptions,
 		Secrets:                   mi.Secrets,
+		Monitors:                  mi.Monitors,
+		FsName:                    mi.FsName,
+		RootPath:                  mi.RootPath,
 	}, nil
 }
 
@@ -80,6 +92,9 @@ func (r *nodeStageMountinfoRecord) toNodeStageMountinfo() (*NodeStageMountinfo,
 		VolumeCapability: volCapability,
 		MountOptions:     r.MountOptions,
 		Secrets:          r.Secrets,
+		Monitors:         r.Monitors,
+		FsName:           r.FsName,
+		RootPath:         r.RootPath,
 	}, nil
 }
 
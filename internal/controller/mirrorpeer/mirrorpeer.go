@@ -0,0 +1,252 @@
+/*
+Copyright 2020 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mirrorpeer exchanges the bootstrap tokens that add the pools of two
+// clusters as RBD mirror peers, through Secrets in the namespace of the
+// driver.
+package mirrorpeer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	ctrl "github.com/ceph/ceph-csi/internal/controller"
+	"github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// bootstrapAnnotation selects the Secrets that are handled, with the
+	// operation as value: bootstrapCreate stores a new token in the Secret,
+	// bootstrapImport imports the token of the Secret.
+	bootstrapAnnotation = "rbd.csi.ceph.com/mirror-peer-bootstrap"
+	bootstrapCreate     = "create"
+	bootstrapImport     = "import"
+
+	// secretNameAnnotation and secretNamespaceAnnotation refer to the Secret
+	// with the Ceph user that creates or imports the token. The namespace
+	// defaults to the namespace of the bootstrap Secret.
+	secretNameAnnotation      = "rbd.csi.ceph.com/mirror-peer-secret-name"
+	secretNamespaceAnnotation = "rbd.csi.ceph.com/mirror-peer-secret-namespace"
+
+	// importedAnnotation is set to the hash of the token once it was
+	// imported, a changed token is imported again.
+	importedAnnotation = "rbd.csi.ceph.com/mirror-peer-imported"
+
+	clusterIDKey = "clusterID"
+	poolKey      = "pool"
+	directionKey = "direction"
+	tokenKey     = "token"
+)
+
+// ReconcileMirrorPeer reconciles the bootstrap Secrets of mirror peers.
+type ReconcileMirrorPeer struct {
+	client client.Client
+	config ctrl.Config
+}
+
+var (
+	_ reconcile.Reconciler = &ReconcileMirrorPeer{}
+	_ ctrl.Manager         = &ReconcileMirrorPeer{}
+)
+
+// Init will add the ReconcileMirrorPeer to the list.
+func Init() {
+	ctrl.ControllerList = append(ctrl.ControllerList, &ReconcileMirrorPeer{})
+}
+
+// Add adds the newMirrorPeerReconciler.
+func (r *ReconcileMirrorPeer) Add(mgr manager.Manager, config ctrl.Config) error {
+	return add(mgr, newMirrorPeerReconciler(mgr, config), config.Namespace)
+}
+
+// newMirrorPeerReconciler returns a ReconcileMirrorPeer.
+func newMirrorPeerReconciler(mgr manager.Manager, config ctrl.Config) reconcile.Reconciler {
+	return &ReconcileMirrorPeer{
+		client: mgr.GetClient(),
+		config: config,
+	}
+}
+
+func add(mgr manager.Manager, r reconcile.Reconciler, namespace string) error {
+	c, err := controller.New(
+		"mirrorpeer-controller",
+		mgr,
+		controller.Options{MaxConcurrentReconciles: 1, Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the bootstrap Secrets in the namespace of the
+	// driver, other Secrets are ignored
+	err = c.Watch(source.Kind(
+		mgr.GetCache(),
+		&corev1.Secret{},
+		&handler.TypedEnqueueRequestForObject[*corev1.Secret]{},
+		predicate.NewTypedPredicateFuncs(func(secret *corev1.Secret) bool {
+			_, ok := secret.Annotations[bootstrapAnnotation]
+
+			return ok && secret.Namespace == namespace
+		})),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to watch the changes: %w", err)
+	}
+
+	return nil
+}
+
+// getCredentials returns the credentials of the Ceph user in the Secret that
+// the annotations of the bootstrap Secret refer to.
+func (r *ReconcileMirrorPeer) getCredentials(ctx context.Context, secret *corev1.Secret) (*util.Credentials, error) {
+	name := secret.Annotations[secretNameAnnotation]
+	namespace := secret.Annotations[secretNamespaceAnnotation]
+	if namespace == "" {
+		namespace = secret.Namespace
+	}
+	if name == "" {
+		return nil, fmt.Errorf("annotation %s of secret %s/%s is not set", secretNameAnnotation,
+			secret.Namespace, secret.Name)
+	}
+
+	userSecret := &corev1.Secret{}
+	err := r.client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, userSecret)
+	if err != nil {
+		return nil, fmt.Errorf("error getting secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	credentials := map[string]string{}
+	for key, value := range userSecret.Data {
+		credentials[key] = string(value)
+	}
+
+	cr, err := util.NewUserCredentials(credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user credentials from secret %s/%s: %w", namespace, name, err)
+	}
+
+	return cr, nil
+}
+
+// reconcileSecret creates or imports the bootstrap token of the Secret.
+func (r *ReconcileMirrorPeer) reconcileSecret(ctx context.Context, secret *corev1.Secret) error {
+	operation := secret.Annotations[bootstrapAnnotation]
+	if operation != bootstrapCreate && operation != bootstrapImport {
+		return fmt.Errorf("invalid value %q of annotation %s, expected %q or %q", operation,
+			bootstrapAnnotation, bootstrapCreate, bootstrapImport)
+	}
+
+	clusterID := string(secret.Data[clusterIDKey])
+	pool := string(secret.Data[poolKey])
+	token := string(secret.Data[tokenKey])
+	if clusterID == "" || pool == "" {
+		return fmt.Errorf("secret %s/%s needs the %q and %q keys", secret.Namespace, secret.Name,
+			clusterIDKey, poolKey)
+	}
+
+	switch {
+	case operation == bootstrapCreate && token != "":
+		return nil
+	case operation == bootstrapImport && token == "":
+		return fmt.Errorf("secret %s/%s has no %q to import", secret.Namespace, secret.Name, tokenKey)
+	case operation == bootstrapImport && secret.Annotations[importedAnnotation] == tokenHash(token):
+		return nil
+	}
+
+	cr, err := r.getCredentials(ctx, secret)
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+
+	orig := secret.DeepCopy()
+	if operation == bootstrapCreate {
+		token, err = rbd.CreateMirrorPeerBootstrapToken(ctx, clusterID, pool, cr)
+		if err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[tokenKey] = []byte(token)
+	} else {
+		err = rbd.ImportMirrorPeerBootstrapToken(ctx, clusterID, pool, string(secret.Data[directionKey]), token, cr)
+		if err != nil {
+			return err
+		}
+		secret.Annotations[importedAnnotation] = tokenHash(token)
+	}
+
+	err = r.client.Patch(ctx, secret, client.MergeFrom(orig))
+	if err != nil {
+		return fmt.Errorf("failed to update secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	log.DebugLog(ctx, "mirror peer bootstrap %s for pool %q in cluster %q is done (secret %s/%s)",
+		operation, pool, clusterID, secret.Namespace, secret.Name)
+
+	return nil
+}
+
+// tokenHash returns a short hash of the token, that is stored in the
+// importedAnnotation to detect a changed token.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// Reconcile creates or imports the mirror peer bootstrap token of a Secret.
+func (r *ReconcileMirrorPeer) Reconcile(ctx context.Context,
+	request reconcile.Request,
+) (reconcile.Result, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, request.NamespacedName, secret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	// Check if the object is under deletion
+	if !secret.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	err = r.reconcileSecret(ctx, secret)
+	if err != nil {
+		log.ErrorLogMsg("failed to reconcile mirror peer bootstrap secret %s: %v", request.NamespacedName, err)
+
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
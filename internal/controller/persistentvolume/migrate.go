@@ -0,0 +1,346 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package persistentvolume
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// migrateToPoolAnnotation on a PersistentVolume requests to move the
+	// RBD image of the volume to the pool in the value of the annotation.
+	migrateToPoolAnnotation = "rbd.csi.ceph.com/migrate-to-pool"
+	// migratedFromPoolAnnotation is set on the PersistentVolume that replaces
+	// the one of a migrated volume, to the pool the image was moved from.
+	migratedFromPoolAnnotation = "rbd.csi.ceph.com/migrated-from-pool"
+	// reclaimPolicyAnnotation keeps the reclaim policy of a PersistentVolume
+	// while the policy is set to Retain during the migration.
+	reclaimPolicyAnnotation = "rbd.csi.ceph.com/migrate-reclaim-policy"
+	// replacementAnnotation is set on the PersistentVolumeClaim while its
+	// PersistentVolume is replaced, to a replacement with the new
+	// PersistentVolume.
+	replacementAnnotation = "rbd.csi.ceph.com/migrate-replacement"
+
+	// replaceTimeout is the time the replaced PersistentVolume may take to
+	// be removed, and the time to create the new PersistentVolume.
+	replaceTimeout = 2 * time.Minute
+)
+
+// migrateToPool moves the image of the volume to the pool of the
+// migrateToPoolAnnotation. The volume handle and the attributes of a
+// PersistentVolume can not be modified, so the PersistentVolume is replaced by
+// one with the same name and claim, which points to the image in the new pool.
+// The reclaim policy is set to Retain while the PersistentVolumes are
+// replaced, so that the volume is not deleted.
+//
+// The volume must not be attached to a node, the migration is retried until
+// the pods using it are stopped.
+func (r *ReconcilePersistentVolume) migrateToPool(
+	ctx context.Context,
+	pv *corev1.PersistentVolume,
+	cr *util.Credentials,
+) error {
+	pool, ok := pv.Annotations[migrateToPoolAnnotation]
+	if !ok {
+		return nil
+	}
+	if pool == "" {
+		return fmt.Errorf("annotation %s of PersistentVolume %s has no pool", migrateToPoolAnnotation, pv.Name)
+	}
+
+	attached, err := r.isAttached(ctx, pv.Name)
+	if err != nil {
+		return err
+	}
+	if attached {
+		return fmt.Errorf("PersistentVolume %s is attached to a node, stop the pods using it to migrate to pool %q",
+			pv.Name, pool)
+	}
+
+	err = r.retainVolume(ctx, pv)
+	if err != nil {
+		return err
+	}
+
+	volumeID := pv.Spec.CSI.VolumeHandle
+	migration, err := rbd.MigrateVolumeToPool(ctx, volumeID, pool, cr)
+	if err != nil {
+		return fmt.Errorf("failed to migrate volume %s to pool %q: %w", volumeID, pool, err)
+	}
+
+	err = r.replacePersistentVolume(ctx, pv, newMigratedPersistentVolume(pv, migration))
+	if err != nil {
+		return fmt.Errorf("failed to replace PersistentVolume %s of volume %s, it needs to be recreated with "+
+			"volume handle %s: %w", pv.Name, volumeID, migration.VolumeID, err)
+	}
+	log.DebugLog(ctx, "migrated volume %s of PersistentVolume %s to pool %q, the volume handle is %s",
+		volumeID, pv.Name, pool, migration.VolumeID)
+
+	return nil
+}
+
+// isAttached returns true when a VolumeAttachment refers to the
+// PersistentVolume.
+func (r *ReconcilePersistentVolume) isAttached(ctx context.Context, pvName string) (bool, error) {
+	vaList := &storagev1.VolumeAttachmentList{}
+	err := r.client.List(ctx, vaList)
+	if err != nil {
+		return false, fmt.Errorf("failed to list VolumeAttachments: %w", err)
+	}
+
+	for i := range vaList.Items {
+		source := vaList.Items[i].Spec.Source.PersistentVolumeName
+		if source != nil && *source == pvName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// retainVolume sets the reclaim policy of the PersistentVolume to Retain,
+// the original policy is stored in the reclaimPolicyAnnotation.
+func (r *ReconcilePersistentVolume) retainVolume(ctx context.Context, pv *corev1.PersistentVolume) error {
+	if _, ok := pv.Annotations[reclaimPolicyAnnotation]; ok {
+		return nil
+	}
+
+	orig := pv.DeepCopy()
+	pv.Annotations[reclaimPolicyAnnotation] = string(pv.Spec.PersistentVolumeReclaimPolicy)
+	pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	err := r.client.Patch(ctx, pv, client.MergeFrom(orig))
+	if err != nil {
+		return fmt.Errorf("failed to retain PersistentVolume %s: %w", pv.Name, err)
+	}
+
+	return nil
+}
+
+// newMigratedPersistentVolume returns the PersistentVolume that replaces pv
+// after the volume was moved to another pool.
+func newMigratedPersistentVolume(pv *corev1.PersistentVolume, migration *rbd.PoolMigration) *corev1.PersistentVolume {
+	annotations := make(map[string]string, len(pv.Annotations))
+	for k, v := range pv.Annotations {
+		annotations[k] = v
+	}
+	delete(annotations, migrateToPoolAnnotation)
+	delete(annotations, reclaimPolicyAnnotation)
+	annotations[migratedFromPoolAnnotation] = pv.Spec.CSI.VolumeAttributes["pool"]
+
+	newPV := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pv.Name,
+			Labels:      pv.Labels,
+			Annotations: annotations,
+		},
+		Spec: *pv.Spec.DeepCopy(),
+	}
+	newPV.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimPolicy(
+		pv.Annotations[reclaimPolicyAnnotation])
+	if newPV.Spec.PersistentVolumeReclaimPolicy == "" {
+		newPV.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	}
+	newPV.Spec.CSI.VolumeHandle = migration.VolumeID
+	if newPV.Spec.CSI.VolumeAttributes == nil {
+		newPV.Spec.CSI.VolumeAttributes = map[string]string{}
+	}
+	newPV.Spec.CSI.VolumeAttributes["pool"] = migration.Pool
+	newPV.Spec.CSI.VolumeAttributes["journalPool"] = migration.JournalPool
+	// the claim is bound again to the PersistentVolume with the same name
+	newPV.Spec.ClaimRef.ResourceVersion = ""
+
+	return newPV
+}
+
+// replacement is stored in the replacementAnnotation of the
+// PersistentVolumeClaim before the PersistentVolume is deleted, so that the
+// new PersistentVolume is created when the provisioner restarts in between.
+type replacement struct {
+	// UID is the UID of the PersistentVolume that is replaced.
+	UID types.UID `json:"uid"`
+	// PersistentVolume is the PersistentVolume that replaces it.
+	PersistentVolume *corev1.PersistentVolume `json:"persistentVolume"`
+}
+
+// replacePersistentVolume deletes pv and creates newPV with the same name.
+// The new PersistentVolume is stored in the PersistentVolumeClaim first,
+// resumeReplacement creates it when the replacement is interrupted.
+func (r *ReconcilePersistentVolume) replacePersistentVolume(
+	ctx context.Context,
+	pv, newPV *corev1.PersistentVolume,
+) error {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.client.Get(ctx, types.NamespacedName{
+		Namespace: pv.Spec.ClaimRef.Namespace,
+		Name:      pv.Spec.ClaimRef.Name,
+	}, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to get PersistentVolumeClaim of PersistentVolume %s: %w", pv.Name, err)
+	}
+
+	repl := &replacement{UID: pv.UID, PersistentVolume: newPV}
+	value, err := json.Marshal(repl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal replacement of PersistentVolume %s: %w", pv.Name, err)
+	}
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	pvc.Annotations[replacementAnnotation] = string(value)
+	err = r.client.Update(ctx, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to store replacement of PersistentVolume %s in PersistentVolumeClaim %s/%s: %w",
+			pv.Name, pvc.Namespace, pvc.Name, err)
+	}
+
+	return r.completeReplacement(ctx, pvc, repl)
+}
+
+// resumeReplacement completes the replacement of the PersistentVolume that is
+// stored in the PersistentVolumeClaim, it is called for the
+// PersistentVolumeClaims with a replacementAnnotation.
+func (r *ReconcilePersistentVolume) resumeReplacement(ctx context.Context, name types.NamespacedName) error {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.client.Get(ctx, name, pvc)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to get PersistentVolumeClaim %s: %w", name, err)
+	}
+
+	value, ok := pvc.Annotations[replacementAnnotation]
+	if !ok {
+		return nil
+	}
+	repl := &replacement{}
+	err = json.Unmarshal([]byte(value), repl)
+	if err != nil {
+		return fmt.Errorf("failed to parse annotation %s of PersistentVolumeClaim %s: %w",
+			replacementAnnotation, name, err)
+	}
+	if repl.PersistentVolume == nil {
+		return fmt.Errorf("annotation %s of PersistentVolumeClaim %s has no PersistentVolume",
+			replacementAnnotation, name)
+	}
+	log.DebugLog(ctx, "resuming the replacement of PersistentVolume %s of PersistentVolumeClaim %s",
+		repl.PersistentVolume.Name, name)
+
+	return r.completeReplacement(ctx, pvc, repl)
+}
+
+// completeReplacement deletes the replaced PersistentVolume, creates the new
+// one and removes the replacementAnnotation of the PersistentVolumeClaim. The
+// pv-protection finalizer is removed, as the PersistentVolumeClaim stays bound
+// to the name of the PersistentVolume.
+func (r *ReconcilePersistentVolume) completeReplacement(
+	ctx context.Context,
+	pvc *corev1.PersistentVolumeClaim,
+	repl *replacement,
+) error {
+	newPV := repl.PersistentVolume.DeepCopy()
+	pv := &corev1.PersistentVolume{}
+	err := r.client.Get(ctx, types.NamespacedName{Name: newPV.Name}, pv)
+	switch {
+	case apierrors.IsNotFound(err):
+		err = r.createReplacement(ctx, repl.UID, newPV)
+	case err != nil:
+		return fmt.Errorf("failed to get PersistentVolume %s: %w", newPV.Name, err)
+	case pv.UID == repl.UID:
+		err = r.deleteReplaced(ctx, pv)
+		if err == nil {
+			err = r.createReplacement(ctx, repl.UID, newPV)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	delete(pvc.Annotations, replacementAnnotation)
+	err = r.client.Update(ctx, pvc)
+	if err != nil {
+		return fmt.Errorf("failed to remove annotation %s of PersistentVolumeClaim %s/%s: %w",
+			replacementAnnotation, pvc.Namespace, pvc.Name, err)
+	}
+
+	return nil
+}
+
+// deleteReplaced removes the finalizers of pv and deletes it.
+func (r *ReconcilePersistentVolume) deleteReplaced(ctx context.Context, pv *corev1.PersistentVolume) error {
+	if len(pv.Finalizers) != 0 {
+		orig := pv.DeepCopy()
+		pv.Finalizers = nil
+		err := r.client.Patch(ctx, pv, client.MergeFrom(orig))
+		if err != nil {
+			return fmt.Errorf("failed to remove finalizers of PersistentVolume %s: %w", pv.Name, err)
+		}
+	}
+
+	err := r.client.Delete(ctx, pv, client.Preconditions{UID: &pv.UID})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete PersistentVolume %s: %w", pv.Name, err)
+	}
+
+	return nil
+}
+
+// createReplacement creates newPV, once the PersistentVolume with the UID of
+// the replaced one is removed.
+func (r *ReconcilePersistentVolume) createReplacement(
+	ctx context.Context,
+	replacedUID types.UID,
+	newPV *corev1.PersistentVolume,
+) error {
+	return wait.PollUntilContextTimeout(ctx, time.Second, replaceTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			cErr := r.client.Create(ctx, newPV)
+			switch {
+			case cErr == nil:
+				return true, nil
+			case apierrors.IsAlreadyExists(cErr):
+				// the old PersistentVolume is not removed yet
+				existing := &corev1.PersistentVolume{}
+				gErr := r.client.Get(ctx, types.NamespacedName{Name: newPV.Name}, existing)
+				if gErr == nil && existing.UID != replacedUID {
+					// created by a previous attempt
+					return true, nil
+				}
+
+				return false, nil
+			default:
+				log.WarningLog(ctx, "failed to create PersistentVolume %s, retrying: %v", newPV.Name, cErr)
+
+				return false, nil
+			}
+		})
+}
@@ -33,10 +33,20 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+const (
+	// renameImageAnnotation on a PersistentVolume requests to rename the RBD
+	// image of the volume to the value of the annotation.
+	renameImageAnnotation = "rbd.csi.ceph.com/rename-image"
+	// imageNameAnnotation is set to the new name of the RBD image once it
+	// was renamed.
+	imageNameAnnotation = "rbd.csi.ceph.com/image-name"
+)
+
 // ReconcilePersistentVolume reconciles a PersistentVolume object.
 type ReconcilePersistentVolume struct {
 	client client.Client
@@ -91,6 +101,22 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return fmt.Errorf("failed to watch the changes: %w", err)
 	}
 
+	// Watch for PersistentVolumeClaims with a PersistentVolume that is being
+	// replaced, the PersistentVolume may not exist anymore
+	err = c.Watch(source.Kind(
+		mgr.GetCache(),
+		&corev1.PersistentVolumeClaim{},
+		&handler.TypedEnqueueRequestForObject[*corev1.PersistentVolumeClaim]{},
+		predicate.NewTypedPredicateFuncs(func(pvc *corev1.PersistentVolumeClaim) bool {
+			_, ok := pvc.Annotations[replacementAnnotation]
+
+			return ok
+		})),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to watch the changes of PersistentVolumeClaims: %w", err)
+	}
+
 	return nil
 }
 
@@ -201,6 +227,48 @@ func (r *ReconcilePersistentVolume) reconcilePV(ctx context.Context, obj runtime
 		log.DebugLog(ctx, "volumeHandler changed from %s to %s", volumeHandler, rbdVolID)
 	}
 
+	err = r.renameImage(ctx, pv, cr)
+	if err != nil {
+		return err
+	}
+
+	return r.migrateToPool(ctx, pv, cr)
+}
+
+// renameImage renames the RBD image of the volume when the PersistentVolume
+// has the renameImageAnnotation. Once the image is renamed, the request is
+// replaced by the imageNameAnnotation.
+func (r *ReconcilePersistentVolume) renameImage(
+	ctx context.Context,
+	pv *corev1.PersistentVolume,
+	cr *util.Credentials,
+) error {
+	newName, ok := pv.Annotations[renameImageAnnotation]
+	if !ok {
+		return nil
+	}
+
+	err := rbd.RenameImage(ctx, pv.Spec.CSI.VolumeHandle, newName, cr)
+	if err != nil {
+		log.ErrorLogMsg("failed to rename image of volume %s to %q: %v", pv.Spec.CSI.VolumeHandle, newName, err)
+		// an invalid name, like one in another RADOS namespace, fails
+		// again until the annotation is changed
+		if errors.Is(err, rbd.ErrInvalidArgument) {
+			return nil
+		}
+
+		return err
+	}
+
+	orig := pv.DeepCopy()
+	delete(pv.Annotations, renameImageAnnotation)
+	pv.Annotations[imageNameAnnotation] = newName
+	err = r.client.Patch(ctx, pv, client.MergeFrom(orig))
+	if err != nil {
+		return fmt.Errorf("failed to update annotations of PersistentVolume %s: %w", pv.Name, err)
+	}
+	log.DebugLog(ctx, "renamed image of volume %s to %q", pv.Spec.CSI.VolumeHandle, newName)
+
 	return nil
 }
 
@@ -209,6 +277,17 @@ func (r *ReconcilePersistentVolume) reconcilePV(ctx context.Context, obj runtime
 func (r *ReconcilePersistentVolume) Reconcile(ctx context.Context,
 	request reconcile.Request,
 ) (reconcile.Result, error) {
+	// PersistentVolumes are cluster scoped, the requests with a namespace
+	// are for PersistentVolumeClaims with a replacementAnnotation
+	if request.Namespace != "" {
+		err := r.resumeReplacement(ctx, request.NamespacedName)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		return reconcile.Result{}, nil
+	}
+
 	pv := &corev1.PersistentVolume{}
 	err := r.client.Get(ctx, request.NamespacedName, pv)
 	if err != nil {
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/fscrypt"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	ekr "github.com/csi-addons/spec/lib/go/encryptionkeyrotation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+// EncryptionKeyRotationServer struct of cephFS CSI driver with supported
+// methods of CSI-Addons encryptionkeyrotation service spec. The keys of
+// fscrypt encrypted volumes are rotated on the node where they are staged.
+type EncryptionKeyRotationServer struct {
+	*ekr.UnimplementedEncryptionKeyRotationControllerServer
+
+	stagingPath string
+	driverName  string
+	volLock     *util.VolumeLocks
+	mounter     mount.Interface
+}
+
+// NewEncryptionKeyRotationServer creates a new EncryptionKeyRotationServer
+// for the volumes of driverName that are staged below stagingPath, mounter
+// detects whether a volume is staged.
+func NewEncryptionKeyRotationServer(
+	stagingPath, driverName string,
+	volLock *util.VolumeLocks,
+	mounter mount.Interface,
+) *EncryptionKeyRotationServer {
+	return &EncryptionKeyRotationServer{
+		stagingPath: stagingPath,
+		driverName:  driverName,
+		volLock:     volLock,
+		mounter:     mounter,
+	}
+}
+
+// RegisterService registers the EncryptionKeyRotationServer's service
+// with the gRPC server.
+func (ekrs *EncryptionKeyRotationServer) RegisterService(svc grpc.ServiceRegistrar) {
+	ekr.RegisterEncryptionKeyRotationControllerServer(svc, ekrs)
+}
+
+// EncryptionKeyRotate replaces the fscrypt protector of the staged volume
+// with a protector for a new passphrase, which is stored in the KMS.
+func (ekrs *EncryptionKeyRotationServer) EncryptionKeyRotate(
+	ctx context.Context,
+	req *ekr.EncryptionKeyRotateRequest,
+) (*ekr.EncryptionKeyRotateResponse, error) {
+	volID := req.GetVolumeId()
+	if volID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
+	}
+
+	if acquired := ekrs.volLock.TryAcquire(volID); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volID)
+	}
+	defer ekrs.volLock.Release(volID)
+
+	stagingTargetPath := ekrs.stagingTargetPath(volID)
+	notMnt, err := ekrs.mounter.IsLikelyNotMountPoint(stagingTargetPath)
+	if err != nil || notMnt {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not staged at %q", volID, stagingTargetPath)
+	}
+
+	volOptions, _, err := store.NewVolumeOptionsFromVolID(ctx, volID, nil, req.GetSecrets(), "", false)
+	if err != nil {
+		switch {
+		case errors.Is(err, cerrors.ErrInvalidVolID):
+			err = status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, cerrors.ErrVolumeNotFound), errors.Is(err, util.ErrKeyNotFound):
+			err = status.Errorf(codes.NotFound, "volume ID %s not found", volID)
+		case errors.Is(err, util.ErrPoolNotFound):
+			log.ErrorLog(ctx, "failed to get backend volume for %s: %v", volID, err)
+			err = status.Error(codes.NotFound, err.Error())
+		default:
+			err = status.Error(codes.Internal, err.Error())
+		}
+
+		return nil, err
+	}
+	defer volOptions.Destroy()
+
+	if !volOptions.IsEncrypted() {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s is not encrypted", volID)
+	}
+
+	err = volOptions.WithEncryptionLock(ctx, volID, func() error {
+		return fscrypt.RotateKey(ctx, volOptions.Encryption, stagingTargetPath, volID)
+	})
+	if errors.Is(err, fscrypt.ErrKeyRotationUnsupported) {
+		return nil, status.Errorf(codes.Unimplemented, "failed to rotate the key for volume with ID %q: %s",
+			volID, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to rotate the key for volume with ID %q: %s",
+			volID, err.Error())
+	}
+
+	return &ekr.EncryptionKeyRotateResponse{}, nil
+}
+
+// stagingTargetPath returns the path where Kubernetes stages the volume, which
+// is <stagingPath>/<driverName>/<sha256 of the volume ID>/globalmount.
+func (ekrs *EncryptionKeyRotationServer) stagingTargetPath(volID string) string {
+	return filepath.Join(ekrs.stagingPath, ekrs.driverName,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(volID))), "globalmount")
+}
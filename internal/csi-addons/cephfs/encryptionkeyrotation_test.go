@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/util"
+
+	ekr "github.com/csi-addons/spec/lib/go/encryptionkeyrotation"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+// TestEncryptionKeyRotate is a minimal test for the EncryptionKeyRotate()
+// procedure. During unit-testing, there is no Ceph cluster available, so
+// actual operations can not be performed.
+func TestEncryptionKeyRotate(t *testing.T) {
+	t.Parallel()
+
+	ekrs := NewEncryptionKeyRotationServer(t.TempDir(), "cephfs.csi.ceph.com",
+		util.NewVolumeLocks(), mount.NewFakeMounter(nil))
+
+	_, err := ekrs.EncryptionKeyRotate(context.TODO(), &ekr.EncryptionKeyRotateRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the volume is not mounted at the staging path
+	_, err = ekrs.EncryptionKeyRotate(context.TODO(), &ekr.EncryptionKeyRotateRequest{
+		VolumeId: "0001-0009-rook-ceph-0000000000000001-" +
+			"b0285c97-a0ce-11eb-8c66-0242ac110002",
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestStagingTargetPath(t *testing.T) {
+	t.Parallel()
+
+	ekrs := NewEncryptionKeyRotationServer("/var/lib/kubelet/plugins/kubernetes.io/csi/",
+		"cephfs.csi.ceph.com", nil, nil)
+
+	require.Equal(t,
+		"/var/lib/kubelet/plugins/kubernetes.io/csi/cephfs.csi.ceph.com/"+
+			"99d14774c29025525738d7cd61dbe77fea590534ed4f9ef9773eb11f9b24763e/globalmount",
+		ekrs.stagingTargetPath("volume-id"))
+}
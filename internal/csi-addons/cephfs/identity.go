@@ -86,6 +86,25 @@ func (is *IdentityServer) GetCapabilities(
 			})
 	}
 
+	if is.config.IsNodeServer {
+		// we're running as a CSI node-plugin service
+		caps = append(caps,
+			&identity.Capability{
+				Type: &identity.Capability_Service_{
+					Service: &identity.Capability_Service{
+						Type: identity.Capability_Service_NODE_SERVICE,
+					},
+				},
+			},
+			&identity.Capability{
+				Type: &identity.Capability_EncryptionKeyRotation_{
+					EncryptionKeyRotation: &identity.Capability_EncryptionKeyRotation{
+						Type: identity.Capability_EncryptionKeyRotation_ENCRYPTIONKEYROTATION,
+					},
+				},
+			})
+	}
+
 	res := &identity.GetCapabilitiesResponse{
 		Capabilities: caps,
 	}
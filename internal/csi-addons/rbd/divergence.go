@@ -0,0 +1,113 @@
+/*
+Copyright 2026 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+
+	"github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DivergenceSnapshotsService is the name of the gRPC service that lists the
+// divergence snapshots of force-promoted volumes. It is served by the
+// ReplicationServer on the CSI-Addons endpoint, the messages are encoded as
+// JSON.
+const DivergenceSnapshotsService = "cephcsi.rbd.v1.DivergenceSnapshots"
+
+// GetDivergenceSnapshotsRequest is the request of GetDivergenceSnapshots. The
+// secrets contain the credentials to open the image of the volume.
+type GetDivergenceSnapshotsRequest struct {
+	VolumeID string            `json:"volumeID"`
+	Secrets  map[string]string `json:"secrets"`
+}
+
+// GetDivergenceSnapshotsResponse is the response of GetDivergenceSnapshots.
+type GetDivergenceSnapshotsResponse struct {
+	Snapshots []types.DivergenceSnapshot `json:"snapshots"`
+}
+
+// divergenceSnapshotsServer is the interface of the
+// DivergenceSnapshotsService.
+type divergenceSnapshotsServer interface {
+	GetDivergenceSnapshots(
+		ctx context.Context,
+		req *GetDivergenceSnapshotsRequest,
+	) (*GetDivergenceSnapshotsResponse, error)
+}
+
+// divergenceSnapshotsServiceDesc describes the DivergenceSnapshotsService
+// for the gRPC server.
+var divergenceSnapshotsServiceDesc = grpc.ServiceDesc{
+	ServiceName: DivergenceSnapshotsService,
+	HandlerType: (*divergenceSnapshotsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		jsongrpc.UnaryMethod(
+			DivergenceSnapshotsService,
+			"GetDivergenceSnapshots",
+			divergenceSnapshotsServer.GetDivergenceSnapshots),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+var _ divergenceSnapshotsServer = &ReplicationServer{}
+
+// GetDivergenceSnapshots lists the snapshots that were taken when the volume
+// was force-promoted with PromoteVolume. The snapshots contain the data of
+// the volume at the time the sites diverged.
+func (rs *ReplicationServer) GetDivergenceSnapshots(
+	ctx context.Context,
+	req *GetDivergenceSnapshotsRequest,
+) (*GetDivergenceSnapshotsResponse, error) {
+	if req.VolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
+	}
+	cr, err := util.NewUserCredentials(req.Secrets)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer cr.DeleteCredentials()
+
+	mgr := rbd.NewManager(rs.driverInstance, nil, req.Secrets)
+	defer mgr.Destroy(ctx)
+
+	rbdVol, err := mgr.GetVolumeByID(ctx, req.VolumeID)
+	if err != nil {
+		return nil, getGRPCError(err)
+	}
+
+	mirror, err := rbdVol.ToMirror()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	snaps, err := mirror.GetDivergenceSnapshots(ctx, cr)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to list divergence snapshots of %s: %v", rbdVol, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &GetDivergenceSnapshotsResponse{Snapshots: snaps}, nil
+}
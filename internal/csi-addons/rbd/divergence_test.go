@@ -0,0 +1,37 @@
+/*
+Copyright 2026 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetDivergenceSnapshots(t *testing.T) {
+	t.Parallel()
+
+	rs := &ReplicationServer{}
+	_, err := rs.GetDivergenceSnapshots(context.TODO(), &GetDivergenceSnapshotsRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.Equal(t, DivergenceSnapshotsService, divergenceSnapshotsServiceDesc.ServiceName)
+	require.Equal(t, "GetDivergenceSnapshots", divergenceSnapshotsServiceDesc.Methods[0].MethodName)
+}
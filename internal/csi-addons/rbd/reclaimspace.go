@@ -20,12 +20,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	rbdutil "github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
+	librbd "github.com/ceph/go-ceph/rbd"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	rs "github.com/csi-addons/spec/lib/go/reclaimspace"
 	"google.golang.org/grpc"
@@ -33,6 +36,16 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// lastSparsifiedKey is the key in the image metadata with the time of
+	// the last completed ControllerReclaimSpace. The key is starting with
+	// `.rbd` so that it will not get replicated to remote cluster.
+	lastSparsifiedKey = ".rbd.reclaimspace.last_sparsified"
+	// lastTrimmedKey is the key in the image metadata with the time of the
+	// last completed NodeReclaimSpace.
+	lastTrimmedKey = ".rbd.reclaimspace.last_trimmed"
+)
+
 // ReclaimSpaceControllerServer struct of rbd CSI driver with supported methods
 // of CSI-addons reclaimspace controller service spec.
 type ReclaimSpaceControllerServer struct {
@@ -40,6 +53,9 @@ type ReclaimSpaceControllerServer struct {
 
 	driverInstance string
 	volumeLocks    *util.VolumeLocks
+	// minInterval is the time that needs to pass after the last sparsify of
+	// a volume, before it is sparsified again
+	minInterval time.Duration
 }
 
 // NewReclaimSpaceControllerServer creates a new ReclaimSpaceControllerServer which handles
@@ -47,10 +63,12 @@ type ReclaimSpaceControllerServer struct {
 func NewReclaimSpaceControllerServer(
 	driverInstance string,
 	volumeLocks *util.VolumeLocks,
+	minInterval time.Duration,
 ) *ReclaimSpaceControllerServer {
 	return &ReclaimSpaceControllerServer{
 		driverInstance: driverInstance,
 		volumeLocks:    volumeLocks,
+		minInterval:    minInterval,
 	}
 }
 
@@ -83,7 +101,11 @@ func (rscs *ReclaimSpaceControllerServer) ControllerReclaimSpace(
 	}
 	defer rbdVol.Destroy(ctx)
 
-	err = rbdVol.Sparsify(ctx)
+	if reclaimedRecently(ctx, rbdVol, lastSparsifiedKey, rscs.minInterval) {
+		return &rs.ControllerReclaimSpaceResponse{}, nil
+	}
+
+	result, err := rbdVol.Sparsify(ctx)
 	if errors.Is(err, rbdutil.ErrImageInUse) {
 		// FIXME: https://github.com/csi-addons/kubernetes-csi-addons/issues/406.
 		// treat sparsify call as no-op if volume is in use.
@@ -91,25 +113,47 @@ func (rscs *ReclaimSpaceControllerServer) ControllerReclaimSpace(
 
 		return &rs.ControllerReclaimSpaceResponse{}, nil
 	}
+	if err != nil && ctx.Err() != nil && result != nil {
+		// the next request starts over, report how far this one got
+		return nil, status.Errorf(status.FromContextError(ctx.Err()).Code(),
+			"sparsify of volume %q was aborted after %d of %d bytes: %v",
+			rbdVol, result.Offset, result.Size, ctx.Err())
+	}
 	if err != nil {
 		// TODO: check for different error codes?
 		return nil, status.Errorf(codes.Internal, "failed to sparsify volume %q: %s", rbdVol, err.Error())
 	}
+	setReclaimed(ctx, rbdVol, lastSparsifiedKey, rscs.minInterval)
 
-	return &rs.ControllerReclaimSpaceResponse{}, nil
+	return &rs.ControllerReclaimSpaceResponse{
+		PreUsage:  &rs.StorageConsumption{UsageBytes: int64(result.PreUsage)},
+		PostUsage: &rs.StorageConsumption{UsageBytes: int64(result.PostUsage)},
+	}, nil
 }
 
 // ReclaimSpaceNodeServer struct of rbd CSI driver with supported methods
 // of CSI-addons reclaimspace controller service spec.
 type ReclaimSpaceNodeServer struct {
 	*rs.UnimplementedReclaimSpaceNodeServer
-	volumeLocks *util.VolumeLocks
+	driverInstance string
+	volumeLocks    *util.VolumeLocks
+	// minInterval is the time that needs to pass after the last fstrim of
+	// a volume, before it is trimmed again
+	minInterval time.Duration
 }
 
 // NewReclaimSpaceNodeServer creates a new IdentityServer which handles the
 // Identity Service requests from the CSI-Addons specification.
-func NewReclaimSpaceNodeServer(volumeLocks *util.VolumeLocks) *ReclaimSpaceNodeServer {
-	return &ReclaimSpaceNodeServer{volumeLocks: volumeLocks}
+func NewReclaimSpaceNodeServer(
+	driverInstance string,
+	volumeLocks *util.VolumeLocks,
+	minInterval time.Duration,
+) *ReclaimSpaceNodeServer {
+	return &ReclaimSpaceNodeServer{
+		driverInstance: driverInstance,
+		volumeLocks:    volumeLocks,
+		minInterval:    minInterval,
+	}
 }
 
 func (rsns *ReclaimSpaceNodeServer) RegisterService(server grpc.ServiceRegistrar) {
@@ -162,6 +206,14 @@ func (rsns *ReclaimSpaceNodeServer) NodeReclaimSpace(
 		return nil, status.Error(codes.Unimplemented, "block-mode space reclaim is not supported")
 	}
 
+	rbdVol := rsns.getVolume(ctx, volumeID, req.GetSecrets())
+	if rbdVol != nil {
+		defer rbdVol.Destroy(ctx)
+		if reclaimedRecently(ctx, rbdVol, lastTrimmedKey, rsns.minInterval) {
+			return &rs.NodeReclaimSpaceResponse{}, nil
+		}
+	}
+
 	cmd := "fstrim"
 	_, stderr, err := util.ExecCommand(ctx, cmd, path)
 	if err != nil {
@@ -173,6 +225,88 @@ func (rsns *ReclaimSpaceNodeServer) NodeReclaimSpace(
 			err.Error(),
 			stderr)
 	}
+	if rbdVol != nil {
+		setReclaimed(ctx, rbdVol, lastTrimmedKey, rsns.minInterval)
+	}
 
 	return &rs.NodeReclaimSpaceResponse{}, nil
 }
+
+// getVolume returns the volume to keep track of the last fstrim in, nil is
+// returned when no minimal interval is configured or the volume can not be
+// resolved with the secrets of the request.
+func (rsns *ReclaimSpaceNodeServer) getVolume(
+	ctx context.Context,
+	volumeID string,
+	secrets map[string]string,
+) types.Volume {
+	if rsns.minInterval == 0 {
+		return nil
+	}
+	if len(secrets) == 0 {
+		log.DebugLog(ctx, "no secrets for volume %q, the time of the last fstrim is not checked", volumeID)
+
+		return nil
+	}
+
+	mgr := rbdutil.NewManager(rsns.driverInstance, nil, secrets)
+	defer mgr.Destroy(ctx)
+
+	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
+	if err != nil {
+		log.WarningLog(ctx, "failed to find volume with ID %q, the time of the last fstrim is not checked: %v",
+			volumeID, err)
+
+		return nil
+	}
+
+	return rbdVol
+}
+
+// reclaimedRecently returns true when the time in the metadata key of the
+// volume is less than minInterval ago. Failures to read the time are logged,
+// space is reclaimed in that case.
+func reclaimedRecently(ctx context.Context, vol types.Volume, key string, minInterval time.Duration) bool {
+	if minInterval == 0 {
+		return false
+	}
+
+	value, err := vol.GetMetadata(key)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		log.WarningLog(ctx, "failed to get %s key from image metadata for %s: %v", key, vol, err)
+
+		return false
+	}
+
+	last, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.WarningLog(ctx, "failed to parse %s key %q of %s: %v", key, value, vol, err)
+
+		return false
+	}
+
+	if time.Since(last) < minInterval {
+		log.DebugLog(ctx, "space of %s was reclaimed at %s, skipping it until %s", vol,
+			last.Format(time.RFC3339), last.Add(minInterval).Format(time.RFC3339))
+
+		return true
+	}
+
+	return false
+}
+
+// setReclaimed stores the current time in the metadata key of the volume.
+// Space has been reclaimed already, a failure is only logged.
+func setReclaimed(ctx context.Context, vol types.Volume, key string, minInterval time.Duration) {
+	if minInterval == 0 {
+		return
+	}
+
+	err := vol.SetMetadata(key, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		log.WarningLog(ctx, "failed to set %s key in image metadata for %s: %v", key, vol, err)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	rbdutil "github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	rs "github.com/csi-addons/spec/lib/go/reclaimspace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+// BatchReclaimSpaceService is the name of the gRPC service that runs
+// NodeReclaimSpace for all volumes that are staged on the node. It is served
+// on the CSI-Addons endpoint, the messages are encoded as JSON.
+const BatchReclaimSpaceService = "cephcsi.rbd.v1.BatchReclaimSpace"
+
+// NodeReclaimSpaceStagedVolumesRequest is the request of
+// NodeReclaimSpaceStagedVolumes.
+type NodeReclaimSpaceStagedVolumesRequest struct{}
+
+// NodeReclaimSpaceStagedVolumesResponse is the response of
+// NodeReclaimSpaceStagedVolumes, with the result of every staged volume.
+type NodeReclaimSpaceStagedVolumesResponse struct {
+	Volumes []BatchReclaimSpaceResult `json:"volumes"`
+}
+
+// BatchReclaimSpaceResult is the outcome of NodeReclaimSpace for a single
+// volume of a BatchReclaimSpace.
+type BatchReclaimSpaceResult struct {
+	VolumeID          string `json:"volumeID"`
+	StagingTargetPath string `json:"stagingTargetPath"`
+	Error             string `json:"error,omitempty"`
+}
+
+// BatchReclaimSpace runs NodeReclaimSpace for all volumes with a filesystem
+// that are staged on the node, so that space can be reclaimed in a
+// maintenance window without a ReclaimSpaceJob for every volume.
+type BatchReclaimSpace struct {
+	rsns        *ReclaimSpaceNodeServer
+	mounter     mount.Interface
+	stagingPath string
+	driverName  string
+	// concurrency is the number of volumes that are trimmed at the same
+	// time
+	concurrency uint
+
+	running atomic.Bool
+}
+
+// batchReclaimSpaceServer is the interface of the BatchReclaimSpaceService.
+type batchReclaimSpaceServer interface {
+	NodeReclaimSpaceStagedVolumes(
+		ctx context.Context,
+		req *NodeReclaimSpaceStagedVolumesRequest,
+	) (*NodeReclaimSpaceStagedVolumesResponse, error)
+}
+
+var _ batchReclaimSpaceServer = &BatchReclaimSpace{}
+
+// batchReclaimSpaceServiceDesc describes the BatchReclaimSpaceService for the
+// gRPC server.
+var batchReclaimSpaceServiceDesc = grpc.ServiceDesc{
+	ServiceName: BatchReclaimSpaceService,
+	HandlerType: (*batchReclaimSpaceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		jsongrpc.UnaryMethod(BatchReclaimSpaceService, "NodeReclaimSpaceStagedVolumes",
+			batchReclaimSpaceServer.NodeReclaimSpaceStagedVolumes),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// NewBatchReclaimSpace returns a BatchReclaimSpace for the volumes of the
+// driver that are staged below stagingPath, mounter detects the staging paths
+// that are mounted.
+func NewBatchReclaimSpace(
+	rsns *ReclaimSpaceNodeServer,
+	mounter mount.Interface,
+	stagingPath, driverName string,
+	concurrency uint,
+) *BatchReclaimSpace {
+	return &BatchReclaimSpace{
+		rsns:        rsns,
+		mounter:     mounter,
+		stagingPath: stagingPath,
+		driverName:  driverName,
+		concurrency: max(concurrency, 1),
+	}
+}
+
+// RegisterService registers the BatchReclaimSpaceService on the CSI-Addons
+// server.
+func (brs *BatchReclaimSpace) RegisterService(server grpc.ServiceRegistrar) {
+	server.RegisterService(&batchReclaimSpaceServiceDesc, brs)
+}
+
+// Run reclaims the space of all staged volumes, and returns the result for
+// every volume. Volumes that are not started once ctx is done, report the
+// error of the context.
+func (brs *BatchReclaimSpace) Run(ctx context.Context) ([]BatchReclaimSpaceResult, error) {
+	volumes, err := rbdutil.ListStagedVolumes(brs.mounter, brs.stagingPath, brs.driverName)
+	if err != nil {
+		return nil, err
+	}
+	log.DebugLog(ctx, "reclaiming space of %d staged volumes, %d at a time", len(volumes), brs.concurrency)
+
+	results := make([]BatchReclaimSpaceResult, len(volumes))
+	sem := make(chan struct{}, brs.concurrency)
+	var wg sync.WaitGroup
+	for i, vol := range volumes {
+		results[i] = BatchReclaimSpaceResult{
+			VolumeID:          vol.VolumeID,
+			StagingTargetPath: vol.StagingTargetPath,
+		}
+
+		select {
+		case <-ctx.Done():
+			results[i].Error = ctx.Err().Error()
+
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(res *BatchReclaimSpaceResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			rErr := brs.reclaimSpace(ctx, res)
+			if rErr != nil {
+				res.Error = rErr.Error()
+				log.ErrorLog(ctx, "failed to reclaim space of volume %q: %v", res.VolumeID, rErr)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// reclaimSpace calls NodeReclaimSpace for the volume. The staged volumes do
+// not come with secrets, so the minimal interval of the ReclaimSpaceNodeServer
+// is not checked.
+func (brs *BatchReclaimSpace) reclaimSpace(ctx context.Context, res *BatchReclaimSpaceResult) error {
+	_, err := brs.rsns.NodeReclaimSpace(ctx, &rs.NodeReclaimSpaceRequest{
+		VolumeId:          res.VolumeID,
+		StagingTargetPath: res.StagingTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	})
+
+	return err
+}
+
+// NodeReclaimSpaceStagedVolumes runs a BatchReclaimSpace, and responds with
+// the results for all volumes once it completed. Only a single
+// BatchReclaimSpace runs at a time, a concurrent request is aborted.
+func (brs *BatchReclaimSpace) NodeReclaimSpaceStagedVolumes(
+	ctx context.Context,
+	_ *NodeReclaimSpaceStagedVolumesRequest,
+) (*NodeReclaimSpaceStagedVolumesResponse, error) {
+	if !brs.running.CompareAndSwap(false, true) {
+		return nil, status.Error(codes.Aborted, "reclaiming space of the staged volumes is in progress already")
+	}
+	defer brs.running.Store(false)
+
+	results, err := brs.Run(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to reclaim space of the staged volumes: %v", err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &NodeReclaimSpaceStagedVolumesResponse{Volumes: results}, nil
+}
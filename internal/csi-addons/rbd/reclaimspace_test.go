@@ -19,11 +19,16 @@ package rbd
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
 
+	librbd "github.com/ceph/go-ceph/rbd"
 	rs "github.com/csi-addons/spec/lib/go/reclaimspace"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TestControllerReclaimSpace is a minimal test for the
@@ -32,7 +37,7 @@ import (
 func TestControllerReclaimSpace(t *testing.T) {
 	t.Parallel()
 
-	controller := NewReclaimSpaceControllerServer("test.driver", util.NewVolumeLocks())
+	controller := NewReclaimSpaceControllerServer("test.driver", util.NewVolumeLocks(), 0)
 
 	req := &rs.ControllerReclaimSpaceRequest{
 		VolumeId: "",
@@ -49,7 +54,7 @@ func TestControllerReclaimSpace(t *testing.T) {
 func TestNodeReclaimSpace(t *testing.T) {
 	t.Parallel()
 
-	node := NewReclaimSpaceNodeServer(&util.VolumeLocks{})
+	node := NewReclaimSpaceNodeServer("test.driver", &util.VolumeLocks{}, 0)
 
 	req := &rs.NodeReclaimSpaceRequest{
 		VolumeId:         "",
@@ -61,3 +66,68 @@ func TestNodeReclaimSpace(t *testing.T) {
 	_, err := node.NodeReclaimSpace(context.TODO(), req)
 	require.Error(t, err)
 }
+
+// metadataVolume stores the metadata of the volume in a map.
+type metadataVolume struct {
+	types.Volume
+
+	metadata map[string]string
+}
+
+func (mv *metadataVolume) GetMetadata(key string) (string, error) {
+	value, ok := mv.metadata[key]
+	if !ok {
+		return "", librbd.ErrNotFound
+	}
+
+	return value, nil
+}
+
+func (mv *metadataVolume) SetMetadata(key, value string) error {
+	mv.metadata[key] = value
+
+	return nil
+}
+
+func (mv *metadataVolume) String() string {
+	return "pool/image"
+}
+
+func TestNodeReclaimSpaceStagedVolumes(t *testing.T) {
+	t.Parallel()
+
+	brs := NewBatchReclaimSpace(nil, nil, "", "test.driver", 1)
+
+	// a concurrent batch is aborted, before the staged volumes are listed
+	brs.running.Store(true)
+	_, err := brs.NodeReclaimSpaceStagedVolumes(context.TODO(), &NodeReclaimSpaceStagedVolumesRequest{})
+	require.Equal(t, codes.Aborted, status.Code(err))
+}
+
+func TestReclaimedRecently(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	vol := &metadataVolume{metadata: map[string]string{}}
+
+	// never reclaimed
+	require.False(t, reclaimedRecently(ctx, vol, lastTrimmedKey, time.Hour))
+
+	setReclaimed(ctx, vol, lastTrimmedKey, time.Hour)
+	require.True(t, reclaimedRecently(ctx, vol, lastTrimmedKey, time.Hour))
+	// the keys of sparsify and fstrim are independent
+	require.False(t, reclaimedRecently(ctx, vol, lastSparsifiedKey, time.Hour))
+	// no interval configured
+	require.False(t, reclaimedRecently(ctx, vol, lastTrimmedKey, 0))
+
+	vol.metadata[lastTrimmedKey] = time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	require.False(t, reclaimedRecently(ctx, vol, lastTrimmedKey, time.Hour))
+
+	vol.metadata[lastTrimmedKey] = "invalid"
+	require.False(t, reclaimedRecently(ctx, vol, lastTrimmedKey, time.Hour))
+
+	// nothing is stored without an interval
+	vol = &metadataVolume{metadata: map[string]string{}}
+	setReclaimed(ctx, vol, lastSparsifiedKey, 0)
+	require.Empty(t, vol.metadata)
+}
@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -83,6 +84,24 @@ const (
 	// (default) If set to "never", the image with parent will not be flattened.
 	// If set to "force", the image with parent will be flattened.
 	flattenModeKey = "flattenMode"
+
+	// mirroringPeersKey to get the mirroringPeers from the parameters.
+	// (optional) comma separated list of the site names of the mirror peers
+	// that need to be configured on the pool before mirroring is enabled.
+	mirroringPeersKey = "mirroringPeers"
+
+	// peerSchedulingIntervalsKey to get the peerSchedulingIntervals from the
+	// parameters.
+	// (optional) comma separated list of <site-name>=<interval> pairs, like
+	// "site-b=5m,site-c=1h". Mirror snapshots are shared by all peers, every
+	// interval is added as a schedule of the image.
+	peerSchedulingIntervalsKey = "peerSchedulingIntervals"
+
+	// configurePoolMirroringKey to get the configurePoolMirroring option from
+	// the parameters.
+	// (optional) If set to "true", mirroring of images is enabled on the
+	// pool when mirroring is disabled for the pool.
+	configurePoolMirroringKey = "configurePoolMirroring"
 )
 
 // ReplicationServer struct of rbd CSI driver with supported methods of Replication
@@ -110,6 +129,7 @@ func NewReplicationServer(instanceID string, c *corerbd.ControllerServer) *Repli
 
 func (rs *ReplicationServer) RegisterService(server grpc.ServiceRegistrar) {
 	replication.RegisterControllerServer(server, rs)
+	server.RegisterService(&divergenceSnapshotsServiceDesc, rs)
 }
 
 // getForceOption extracts the force option from the GRPC request parameters.
@@ -129,6 +149,22 @@ func getForceOption(ctx context.Context, parameters map[string]string) (bool, er
 	return force, nil
 }
 
+// getConfigurePoolMirroring extracts the configurePoolMirroring option from
+// the GRPC request parameters. If not set, the default is false.
+func getConfigurePoolMirroring(parameters map[string]string) (bool, error) {
+	val, ok := parameters[configurePoolMirroringKey]
+	if !ok {
+		return false, nil
+	}
+	configure, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %q value %q: %v",
+			configurePoolMirroringKey, val, err)
+	}
+
+	return configure, nil
+}
+
 // getFlattenMode gets flatten mode from the input GRPC request parameters.
 // flattenMode is the key to check the mode in the parameters.
 func getFlattenMode(ctx context.Context, parameters map[string]string) (types.FlattenMode, error) {
@@ -196,6 +232,10 @@ func validateSchedulingDetails(ctx context.Context, parameters map[string]string
 			log.WarningLog(ctx, "%s parameter cannot be used with %s mirror mode, ignoring it",
 				schedulingStartTimeKey, string(imageMirrorModeJournal))
 		}
+		if _, ok := parameters[peerSchedulingIntervalsKey]; ok {
+			log.WarningLog(ctx, "%s parameter cannot be used with %s mirror mode, ignoring it",
+				peerSchedulingIntervalsKey, string(imageMirrorModeJournal))
+		}
 
 		return nil
 	case imageMirrorModeSnapshot:
@@ -229,6 +269,158 @@ func validateSchedulingDetails(ctx context.Context, parameters map[string]string
 		}
 	}
 
+	_, err = getPeerSchedulingIntervals(parameters)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return nil
+}
+
+// getMirroringPeers returns the site names of the mirroringPeers parameter.
+func getMirroringPeers(parameters map[string]string) []string {
+	var peers []string
+	for _, peer := range strings.Split(parameters[mirroringPeersKey], ",") {
+		peer = strings.TrimSpace(peer)
+		if peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// getPeerSchedulingIntervals parses the peerSchedulingIntervals parameter and
+// returns the interval for each site name.
+func getPeerSchedulingIntervals(parameters map[string]string) (map[string]admin.Interval, error) {
+	intervals := make(map[string]admin.Interval)
+	for _, pair := range strings.Split(parameters[peerSchedulingIntervalsKey], ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		site, interval, found := strings.Cut(pair, "=")
+		site = strings.TrimSpace(site)
+		interval = strings.TrimSpace(interval)
+		if !found || site == "" {
+			return nil, fmt.Errorf("%q in %q is not in the <site-name>=<interval> format",
+				pair, peerSchedulingIntervalsKey)
+		}
+
+		err := validateSchedulingInterval(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval for peer %q: %w", site, err)
+		}
+		intervals[site] = admin.Interval(interval)
+	}
+
+	return intervals, nil
+}
+
+// getRequiredPeers returns the site names of the peers that are requested
+// with the mirroringPeers and peerSchedulingIntervals parameters.
+func getRequiredPeers(parameters map[string]string) ([]string, error) {
+	peers := getMirroringPeers(parameters)
+	intervals, err := getPeerSchedulingIntervals(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	for site := range intervals {
+		if !slices.Contains(peers, site) {
+			peers = append(peers, site)
+		}
+	}
+
+	return peers, nil
+}
+
+// checkPoolMirroring verifies that the pool of the resource is configured for
+// mirroring of individual images, mirroring of the pool is enabled first when
+// configure is set. Mirror peers need to be configured for every site in
+// required.
+func checkPoolMirroring(
+	ctx context.Context,
+	mirror types.Mirror,
+	mode librbd.ImageMirrorMode,
+	required []string,
+	configure bool,
+) error {
+	pm, err := mirror.GetPoolMirroring(ctx)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if pm.Mode == librbd.MirrorModeDisabled && configure {
+		err = mirror.EnablePoolMirroring(ctx)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		pm.Mode = librbd.MirrorModeImage
+	}
+
+	if len(pm.Peers) == 0 {
+		// with one-way mirroring only the secondary cluster has a peer
+		log.DebugLog(ctx, "no mirror peers are configured for pool %q", pm.Pool)
+	}
+
+	return validatePoolMirroring(pm, mode, required)
+}
+
+// validatePoolMirroring returns a FailedPrecondition error that describes how
+// to fix the configuration of the pool, when images can not be mirrored with
+// mode to all sites in required. Pools with the mode pool only support
+// journal based mirroring.
+func validatePoolMirroring(pm *types.PoolMirroring, mode librbd.ImageMirrorMode, required []string) error {
+	// the mirror mode is set per RADOS namespace, "rbd mirror pool" accepts
+	// the pool/namespace spec
+	spec := pm.Pool
+	if pm.Namespace != "" {
+		spec = pm.Pool + "/" + pm.Namespace
+	}
+
+	switch pm.Mode {
+	case librbd.MirrorModeImage:
+	case librbd.MirrorModeDisabled:
+		return status.Errorf(codes.FailedPrecondition,
+			"mirroring is disabled for pool %q, enable it with \"rbd mirror pool enable %s image\" "+
+				"or set %s to true", spec, spec, configurePoolMirroringKey)
+	case librbd.MirrorModePool:
+		// all journaled images of the pool are mirrored already
+		if mode != librbd.ImageMirrorModeJournal {
+			return status.Errorf(codes.FailedPrecondition,
+				"pool %q mirrors all journaled images (mode pool), mirroring of individual images with mode %s "+
+					"requires the pool mode image", spec, mode)
+		}
+	default:
+		return status.Errorf(codes.FailedPrecondition, "pool %q has unsupported mirror mode %s", spec, pm.Mode)
+	}
+
+	for _, peer := range pm.Peers {
+		if peer.SiteName == pm.SiteName {
+			return status.Errorf(codes.FailedPrecondition,
+				"mirror peer of pool %q has the site name %q of the local cluster, the clusters need "+
+					"different site names (\"rbd mirror pool info\")", pm.Pool, pm.SiteName)
+		}
+	}
+
+	for _, site := range required {
+		i := slices.IndexFunc(pm.Peers, func(peer types.MirrorPeer) bool {
+			return peer.SiteName == site
+		})
+		if i == -1 {
+			return status.Errorf(codes.FailedPrecondition, "mirror peer %q is not configured for pool %q", site, pm.Pool)
+		}
+		// the mirror UUID is set once an rbd-mirror daemon connected to
+		// the peer
+		if pm.Peers[i].MirrorUUID == "" {
+			return status.Errorf(codes.FailedPrecondition,
+				"mirror peer %q of pool %q was not contacted by an rbd-mirror daemon yet, check the "+
+					"rbd-mirror daemons and \"rbd mirror pool status %s\"", site, pm.Pool, pm.Pool)
+		}
+	}
+
 	return nil
 }
 
@@ -280,6 +472,15 @@ func (rs *ReplicationServer) EnableVolumeReplication(ctx context.Context,
 	mgr := rbd.NewManager(rs.driverInstance, req.GetParameters(), req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
+	if req.GetReplicationSource().GetVolumegroup() != nil {
+		err = rs.enableVolumeGroupReplication(ctx, mgr, volumeID, req.GetParameters())
+		if err != nil {
+			return nil, err
+		}
+
+		return &replication.EnableVolumeReplicationResponse{}, nil
+	}
+
 	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		return nil, getGRPCError(err)
@@ -300,6 +501,17 @@ func (rs *ReplicationServer) EnableVolumeReplication(ctx context.Context,
 		return nil, err
 	}
 
+	requiredPeers, err := getRequiredPeers(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	configurePool, err := getConfigurePoolMirroring(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	// images that are mirrored already, like the journaled images of a pool
+	// with the mode pool, are not validated against the pool configuration
 	info, err := mirror.GetMirroringInfo(ctx)
 	if err != nil {
 		log.ErrorLog(ctx, err.Error())
@@ -307,6 +519,12 @@ func (rs *ReplicationServer) EnableVolumeReplication(ctx context.Context,
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	if info.GetState() != librbd.MirrorImageEnabled.String() {
+		err = checkPoolMirroring(ctx, mirror, mirroringMode, requiredPeers, configurePool)
+		if err != nil {
+			log.ErrorLog(ctx, err.Error())
+
+			return nil, err
+		}
 		err = rbdVol.HandleParentImageExistence(ctx, flattenMode)
 		if err != nil {
 			log.ErrorLog(ctx, err.Error())
@@ -319,11 +537,47 @@ func (rs *ReplicationServer) EnableVolumeReplication(ctx context.Context,
 
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+
+		return &replication.EnableVolumeReplicationResponse{}, nil
+	}
+
+	err = checkMirroringMode(ctx, mirror, mirroringMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsPrimary() {
+		err = reconcileExpectedSchedules(ctx, rbdVol, mirror, req.GetParameters())
+		if err != nil {
+			log.ErrorLog(ctx, err.Error())
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 	}
 
 	return &replication.EnableVolumeReplicationResponse{}, nil
 }
 
+// checkMirroringMode fails with FailedPrecondition when the image is already
+// mirrored with another mode than the requested mode. librbd can not change
+// the mode of a mirrored image, mirroring needs to be disabled with
+// DisableVolumeReplication first, which removes the image from the peers.
+func checkMirroringMode(ctx context.Context, mirror types.Mirror, mode librbd.ImageMirrorMode) error {
+	currentMode, err := mirror.GetMirroringMode(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
+	}
+	if currentMode != mode {
+		return status.Errorf(codes.FailedPrecondition,
+			"%s is mirrored in %s mode, disable replication before enabling it in %s mode, "+
+				"the peers synchronize a full copy of the image again", mirror, currentMode, mode)
+	}
+
+	return nil
+}
+
 // DisableVolumeReplication extracts the RBD volume information from the
 // volumeID, If the image is present and the mirroring is enabled on the RBD
 // image it will disable the mirroring.
@@ -350,6 +604,21 @@ func (rs *ReplicationServer) DisableVolumeReplication(ctx context.Context,
 	mgr := rbd.NewManager(rs.driverInstance, req.GetParameters(), req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
+	// extract the force option
+	force, err := getForceOption(ctx, req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetReplicationSource().GetVolumegroup() != nil {
+		err = rs.disableVolumeGroupReplication(ctx, mgr, volumeID, force)
+		if err != nil {
+			return nil, err
+		}
+
+		return &replication.DisableVolumeReplicationResponse{}, nil
+	}
+
 	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		return nil, getGRPCError(err)
@@ -359,12 +628,6 @@ func (rs *ReplicationServer) DisableVolumeReplication(ctx context.Context,
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	// extract the force option
-	force, err := getForceOption(ctx, req.GetParameters())
-	if err != nil {
-		return nil, err
-	}
-
 	info, err := mirror.GetMirroringInfo(ctx)
 	if err != nil {
 		log.ErrorLog(ctx, err.Error())
@@ -418,38 +681,103 @@ func (rs *ReplicationServer) PromoteVolume(ctx context.Context,
 	mgr := rbd.NewManager(rs.driverInstance, req.GetParameters(), req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
+	if req.GetReplicationSource().GetVolumegroup() != nil {
+		err = rs.promoteVolumeGroup(ctx, mgr, volumeID, req.GetForce(), cr, req.GetParameters())
+		if err != nil {
+			return nil, err
+		}
+
+		return &replication.PromoteVolumeResponse{}, nil
+	}
+
 	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		return nil, getGRPCError(err)
 	}
+
+	err = promoteVolume(ctx, rbdVol, req.GetForce(), cr, req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	return &replication.PromoteVolumeResponse{}, nil
+}
+
+// promoteVolume promotes the image of the volume to primary, if it is not
+// primary yet, and adds the snapshot schedules from the parameters.
+func promoteVolume(
+	ctx context.Context,
+	rbdVol types.Volume,
+	force bool,
+	cr *util.Credentials,
+	parameters map[string]string,
+) error {
 	mirror, err := rbdVol.ToMirror()
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	err = promoteMirror(ctx, mirror, force, cr, parameters)
+	if err != nil {
+		return err
+	}
+
+	// the schedules are verified again by GetVolumeReplicationInfo, which
+	// does not get the parameters
+	expected, err := getExpectedSchedules(parameters)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = storeExpectedSchedules(rbdVol, expected)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
 	}
 
+	return nil
+}
+
+// promoteMirror promotes the image or group to primary, if it is not primary
+// yet, and adds the snapshot schedules from the parameters.
+func promoteMirror(
+	ctx context.Context,
+	mirror types.Mirror,
+	force bool,
+	cr *util.Credentials,
+	parameters map[string]string,
+) error {
 	info, err := mirror.GetMirroringInfo(ctx)
 	if err != nil {
 		log.ErrorLog(ctx, err.Error())
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
 	}
 
 	if info.GetState() != librbd.MirrorImageEnabled.String() {
-		return nil, status.Errorf(
+		return status.Errorf(
 			codes.InvalidArgument,
 			"mirroring is not enabled on %s, image is in %s Mode",
-			volumeID,
+			mirror,
 			info.GetState())
 	}
 
 	// promote secondary to primary
 	if !info.IsPrimary() {
-		if req.GetForce() {
+		if force {
+			// the divergence snapshot can only be taken once the image is
+			// primary, a failure to take it is retried by the next request
+			err = mirror.MarkDivergenceSnapshotPending(ctx, cr)
+			if err != nil {
+				log.ErrorLog(ctx, err.Error())
+
+				return status.Error(codes.Internal, err.Error())
+			}
 			// workaround for https://github.com/ceph/ceph-csi/issues/2736
 			// TODO: remove this workaround when the issue is fixed
 			err = mirror.ForcePromote(ctx, cr)
 		} else {
-			err = mirror.Promote(ctx, req.GetForce())
+			err = mirror.Promote(ctx, force)
 		}
 		if err != nil {
 			log.ErrorLog(ctx, err.Error())
@@ -460,28 +788,76 @@ func (rs *ReplicationServer) PromoteVolume(ctx context.Context,
 			// Return FailedPrecondition so that replication operator can send
 			// request to force promote the image.
 			if strings.Contains(err.Error(), "Device or resource busy") {
-				return nil, status.Error(codes.FailedPrecondition, err.Error())
+				return status.Error(codes.FailedPrecondition, err.Error())
 			}
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return status.Error(codes.Internal, err.Error())
 		}
 	}
 
-	interval, startTime := getSchedulingDetails(req.GetParameters())
+	// keep the data of the image at the point where the sites diverged,
+	// before the image is used on this site
+	pending, err := mirror.IsDivergenceSnapshotPending(ctx, cr)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
+	}
+	if pending {
+		ds, dsErr := mirror.CreateDivergenceSnapshot(ctx, cr)
+		if dsErr != nil {
+			log.ErrorLog(ctx, "failed to create divergence snapshot of force-promoted %s: %v", mirror, dsErr)
+
+			return status.Errorf(
+				codes.Internal,
+				"%s was promoted, but creating the divergence snapshot failed: %v",
+				mirror,
+				dsErr)
+		}
+		log.UsefulLog(ctx, "created divergence snapshot %q of force-promoted %s", ds.Name, mirror)
+	}
+
+	interval, startTime := getSchedulingDetails(parameters)
 	if interval != admin.NoInterval {
 		err = mirror.AddSnapshotScheduling(interval, startTime)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		log.DebugLog(
 			ctx,
 			"Added scheduling at interval %s, start time %s for volume %s",
 			interval,
 			startTime,
-			rbdVol)
+			mirror)
 	}
 
-	return &replication.PromoteVolumeResponse{}, nil
+	// mirror snapshots are replicated to all peers, adding the interval of
+	// every peer as a schedule makes each peer synchronize at least as often
+	// as requested
+	peerIntervals, err := getPeerSchedulingIntervals(parameters)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for site, peerInterval := range peerIntervals {
+		if imageMirroringMode(parameters[imageMirroringKey]) == imageMirrorModeJournal {
+			break
+		}
+		if peerInterval == interval {
+			continue
+		}
+		err = mirror.AddSnapshotScheduling(peerInterval, startTime)
+		if err != nil {
+			return err
+		}
+		log.DebugLog(
+			ctx,
+			"Added scheduling at interval %s for peer %q of volume %s",
+			peerInterval,
+			site,
+			mirror)
+	}
+
+	return nil
 }
 
 // DemoteVolume extracts the RBD volume information from the
@@ -511,34 +887,55 @@ func (rs *ReplicationServer) DemoteVolume(ctx context.Context,
 	mgr := rbd.NewManager(rs.driverInstance, req.GetParameters(), req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
+	if req.GetReplicationSource().GetVolumegroup() != nil {
+		err = rs.demoteVolumeGroup(ctx, mgr, volumeID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &replication.DemoteVolumeResponse{}, nil
+	}
+
 	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		return nil, getGRPCError(err)
 	}
+
+	err = demoteVolume(ctx, rbdVol)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replication.DemoteVolumeResponse{}, nil
+}
+
+// demoteVolume demotes the image of the volume to secondary, if it is still
+// primary.
+func demoteVolume(ctx context.Context, rbdVol types.Volume) error {
 	mirror, err := rbdVol.ToMirror()
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
 	}
 
 	creationTime, err := rbdVol.GetCreationTime(ctx)
 	if err != nil {
 		log.ErrorLog(ctx, err.Error())
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
 	}
 
 	info, err := mirror.GetMirroringInfo(ctx)
 	if err != nil {
 		log.ErrorLog(ctx, err.Error())
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
 	}
 
 	if info.GetState() != librbd.MirrorImageEnabled.String() {
-		return nil, status.Errorf(
+		return status.Errorf(
 			codes.InvalidArgument,
 			"mirroring is not enabled on %s, image is in %s Mode",
-			volumeID,
+			rbdVol,
 			info.GetState())
 	}
 
@@ -553,18 +950,18 @@ func (rs *ReplicationServer) DemoteVolume(ctx context.Context,
 		if err != nil {
 			log.ErrorLog(ctx, err.Error())
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return status.Error(codes.Internal, err.Error())
 		}
 
 		err = mirror.Demote(ctx)
 		if err != nil {
 			log.ErrorLog(ctx, err.Error())
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return status.Error(codes.Internal, err.Error())
 		}
 	}
 
-	return &replication.DemoteVolumeResponse{}, nil
+	return nil
 }
 
 // checkRemoteSiteStatus checks the state of the remote cluster.
@@ -621,6 +1018,10 @@ func (rs *ReplicationServer) ResyncVolume(ctx context.Context,
 	mgr := rbd.NewManager(rs.driverInstance, req.GetParameters(), req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
+	if req.GetReplicationSource().GetVolumegroup() != nil {
+		return rs.resyncVolumeGroup(ctx, mgr, volumeID, req.GetForce())
+	}
+
 	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		return nil, getGRPCError(err)
@@ -830,6 +1231,10 @@ func (rs *ReplicationServer) GetVolumeReplicationInfo(ctx context.Context,
 	mgr := rbd.NewManager(rs.driverInstance, nil, req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
+	if req.GetReplicationSource().GetVolumegroup() != nil {
+		return rs.getVolumeGroupReplicationInfo(ctx, mgr, volumeID)
+	}
+
 	rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to get volume with id %q: %v", volumeID, err)
@@ -852,6 +1257,22 @@ func (rs *ReplicationServer) GetVolumeReplicationInfo(ctx context.Context,
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// without its snapshot schedules the image is not synchronized anymore,
+	// a failure to restore them is reported by the last sync time
+	err = reconcileVolumeSchedules(ctx, rbdVol, mirror)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to reconcile snapshot schedules of %s: %v", rbdVol, err)
+	}
+
+	return getMirrorReplicationInfo(ctx, mirror)
+}
+
+// getMirrorReplicationInfo returns the last sync info of the image or group,
+// it needs to be primary.
+func getMirrorReplicationInfo(
+	ctx context.Context,
+	mirror types.Mirror,
+) (*replication.GetVolumeReplicationInfoResponse, error) {
 	info, err := mirror.GetMirroringInfo(ctx)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to get info for mirror %q: %v", mirror, err)
@@ -879,21 +1300,28 @@ func (rs *ReplicationServer) GetVolumeReplicationInfo(ctx context.Context,
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	remoteStatus, err := mirrorStatus.GetRemoteSiteStatus(ctx)
-	if err != nil {
-		log.ErrorLog(ctx, "failed to get remote site status for mirror %q: %v", mirror, err)
+	remoteStatuses := mirrorStatus.GetRemoteSitesStatus()
+	if len(remoteStatuses) == 0 {
+		err = fmt.Errorf("no remote site status for mirror %q: %w", mirror, librbd.ErrNotExist)
+		log.ErrorLog(ctx, err.Error())
 
-		if errors.Is(err, librbd.ErrNotExist) {
-			return nil, status.Errorf(codes.NotFound, "failed to get remote status: %v", err)
-		}
+		return nil, status.Errorf(codes.NotFound, "failed to get remote status: %v", err)
+	}
 
-		return nil, status.Errorf(codes.Internal, "failed to get remote status: %v", err)
+	// the site names are only used for reporting, do not fail if the peers
+	// can not be listed
+	siteNames := make(map[string]string)
+	peers, err := mirror.GetMirrorPeers(ctx)
+	if err != nil {
+		log.WarningLog(ctx, "failed to get mirror peers for mirror %q: %v", mirror, err)
+	}
+	for _, peer := range peers {
+		siteNames[peer.MirrorUUID] = peer.SiteName
 	}
 
-	description := remoteStatus.GetDescription()
-	resp, err := getLastSyncInfo(ctx, description)
+	resp, err := getPeersLastSyncInfo(ctx, remoteStatuses, siteNames)
 	if err != nil {
-		log.ErrorLog(ctx, "failed to parse last sync info from %q: %v", description, err)
+		log.ErrorLog(ctx, "failed to get last sync info for mirror %q: %v", mirror, err)
 
 		if errors.Is(err, corerbd.ErrLastSyncTimeNotFound) {
 			return nil, status.Errorf(codes.NotFound, "failed to get last sync info: %v", err)
@@ -905,6 +1333,54 @@ func (rs *ReplicationServer) GetVolumeReplicationInfo(ctx context.Context,
 	return resp, nil
 }
 
+// getPeersLastSyncInfo returns the last sync info of the peer that has been
+// synchronized least recently, so that the reported recovery point is valid
+// for all peers. Peers that did not report a sync yet are logged, an error is
+// only returned when none of the peers has a last sync time.
+func getPeersLastSyncInfo(
+	ctx context.Context,
+	remoteStatuses []types.SiteStatus,
+	siteNames map[string]string,
+) (*replication.GetVolumeReplicationInfoResponse, error) {
+	var (
+		oldest  *replication.GetVolumeReplicationInfoResponse
+		lastErr error
+	)
+
+	for _, remoteStatus := range remoteStatuses {
+		peer := remoteStatus.GetMirrorUUID()
+		if name, ok := siteNames[peer]; ok && name != "" {
+			peer = name
+		}
+
+		resp, err := getLastSyncInfo(ctx, remoteStatus.GetDescription())
+		if err != nil {
+			log.WarningLog(ctx, "failed to parse last sync info of peer %q from %q: %v",
+				peer, remoteStatus.GetDescription(), err)
+			lastErr = fmt.Errorf("failed to get last sync info of peer %q: %w", peer, err)
+
+			continue
+		}
+
+		log.UsefulLog(ctx, "peer %q has state=%q, up=%t, last sync time=%s, last sync bytes=%d",
+			peer,
+			remoteStatus.GetState(),
+			remoteStatus.IsUP(),
+			resp.GetLastSyncTime().AsTime(),
+			resp.GetLastSyncBytes())
+
+		if oldest == nil || resp.GetLastSyncTime().AsTime().Before(oldest.GetLastSyncTime().AsTime()) {
+			oldest = resp
+		}
+	}
+
+	if oldest == nil {
+		return nil, lastErr
+	}
+
+	return oldest, nil
+}
+
 // This function gets the local snapshot time, last sync snapshot seconds
 // and last sync bytes from the description of localStatus and convert
 // it into required types.
@@ -0,0 +1,522 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	corerbd "github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/csi-addons/spec/lib/go/replication"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// failoverIntentPromote is recorded in the FailoverMarker while the
+	// volumes of a group are promoted.
+	failoverIntentPromote = "promote"
+	// failoverIntentDemote is recorded in the FailoverMarker while the
+	// volumes of a group are demoted.
+	failoverIntentDemote = "demote"
+)
+
+// failoverVolumeGroup applies the promote or demote operation to all volumes
+// of a group that is not mirrored as a whole, but has its images mirrored
+// individually. A FailoverMarker is kept in the journal of the group until
+// all volumes are done, so that an interrupted failover is not left with some
+// volumes primary and others secondary:
+//   - a request with the same intent resumes the failover, skipping the
+//     volumes that were completed already,
+//   - a request with the other intent rolls the failover back, starting with
+//     the volumes that were completed, in reverse order.
+func (rs *ReplicationServer) failoverVolumeGroup(
+	ctx context.Context,
+	vg types.VolumeGroup,
+	intent string,
+	force bool,
+	apply func(ctx context.Context, vol types.Volume) error,
+) error {
+	volumes, err := vg.ListVolumes(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list volumes of volume group %q: %v", vg, err)
+	}
+
+	volumeByID := make(map[string]types.Volume, len(volumes))
+	ids := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		id, gErr := vol.GetID(ctx)
+		if gErr != nil {
+			return status.Error(codes.Internal, gErr.Error())
+		}
+		volumeByID[id] = vol
+		ids = append(ids, id)
+	}
+	// a sorted order makes resuming and rolling back deterministic
+	slices.Sort(ids)
+
+	marker, err := vg.GetFailoverMarker(ctx)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	order := ids
+	switch {
+	case marker == nil:
+	case marker.Intent == intent:
+		log.UsefulLog(ctx, "resuming %s of volume group %q started by %q at %s, %d of %d volumes done",
+			marker.Intent, vg, marker.Initiator, marker.StartTime, len(marker.Completed), len(ids))
+	default:
+		log.UsefulLog(ctx, "rolling back %s of volume group %q started by %q at %s, reverting %d volumes",
+			marker.Intent, vg, marker.Initiator, marker.StartTime, len(marker.Completed))
+		order = rollbackOrder(ids, marker.Completed)
+		marker = nil
+	}
+
+	if marker == nil {
+		marker = &journal.FailoverMarker{
+			Intent:    intent,
+			Force:     force,
+			StartTime: time.Now().UTC(),
+			Initiator: rs.failoverInitiator(),
+		}
+		err = vg.SetFailoverMarker(ctx, marker)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	for _, id := range order {
+		if slices.Contains(marker.Completed, id) {
+			continue
+		}
+
+		// the marker stays in the journal on failure, the next request
+		// continues from here
+		err = apply(ctx, volumeByID[id])
+		if err != nil {
+			log.ErrorLog(ctx, "failed to %s volume %q of volume group %q: %v", intent, id, vg, err)
+
+			return err
+		}
+
+		marker.Completed = append(marker.Completed, id)
+		err = vg.SetFailoverMarker(ctx, marker)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	err = vg.ClearFailoverMarker(ctx)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	log.DebugLog(ctx, "%s of all %d volumes in volume group %q completed", intent, len(ids), vg)
+
+	return nil
+}
+
+// rollbackOrder returns the IDs of the volumes in the order they need to be
+// processed when an interrupted failover is rolled back. The completed
+// volumes are reverted first, last completed first, followed by the others.
+func rollbackOrder(ids, completed []string) []string {
+	order := make([]string, 0, len(ids))
+	for i := len(completed) - 1; i >= 0; i-- {
+		if slices.Contains(ids, completed[i]) && !slices.Contains(order, completed[i]) {
+			order = append(order, completed[i])
+		}
+	}
+
+	for _, id := range ids {
+		if !slices.Contains(order, id) {
+			order = append(order, id)
+		}
+	}
+
+	return order
+}
+
+// failoverInitiator identifies this provisioner in the FailoverMarker.
+func (rs *ReplicationServer) failoverInitiator() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s/%s", rs.driverInstance, hostname)
+}
+
+// getGroupMirror returns the VolumeGroup with the given ID, and the Mirror
+// to manage the mirroring of the group as a whole. The VolumeGroup needs to
+// be destroyed by the caller.
+func getGroupMirror(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+) (types.VolumeGroup, types.Mirror, error) {
+	vg, err := mgr.GetVolumeGroupByID(ctx, groupID)
+	if err != nil {
+		return nil, nil, getGRPCError(err)
+	}
+
+	mirror, err := vg.ToMirror()
+	if err != nil {
+		vg.Destroy(ctx)
+
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return vg, mirror, nil
+}
+
+// enableVolumeGroupReplication enables mirroring of the RBD group, so that
+// the images in the group are replicated consistently with each other. The
+// parents of the images are handled like for individual images.
+func (rs *ReplicationServer) enableVolumeGroupReplication(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+	parameters map[string]string,
+) error {
+	vg, mirror, err := getGroupMirror(ctx, mgr, groupID)
+	if err != nil {
+		return err
+	}
+	defer vg.Destroy(ctx)
+
+	mirroringMode, err := getMirroringMode(ctx, parameters)
+	if err != nil {
+		return err
+	}
+	if mirroringMode != librbd.ImageMirrorModeSnapshot {
+		return status.Errorf(codes.InvalidArgument, "volume group %s can only be mirrored in %s mode",
+			vg, imageMirrorModeSnapshot)
+	}
+	flattenMode, err := getFlattenMode(ctx, parameters)
+	if err != nil {
+		return err
+	}
+
+	requiredPeers, err := getRequiredPeers(parameters)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	configurePool, err := getConfigurePoolMirroring(parameters)
+	if err != nil {
+		return err
+	}
+
+	info, err := mirror.GetMirroringInfo(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
+	}
+	if info.GetState() == librbd.MirrorImageEnabled.String() {
+		return nil
+	}
+
+	err = checkPoolMirroring(ctx, mirror, mirroringMode, requiredPeers, configurePool)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return err
+	}
+
+	volumes, err := vg.ListVolumes(ctx)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list volumes of volume group %q: %v", vg, err)
+	}
+	for _, vol := range volumes {
+		err = vol.HandleParentImageExistence(ctx, flattenMode)
+		if err != nil {
+			log.ErrorLog(ctx, err.Error())
+
+			return getGRPCError(err)
+		}
+	}
+
+	err = mirror.EnableMirroring(ctx, mirroringMode)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
+	}
+	log.DebugLog(ctx, "enabled mirroring of volume group %q with %d volumes", vg, len(volumes))
+
+	return nil
+}
+
+// disableVolumeGroupReplication disables mirroring of the RBD group.
+func (rs *ReplicationServer) disableVolumeGroupReplication(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+	force bool,
+) error {
+	vg, mirror, err := getGroupMirror(ctx, mgr, groupID)
+	if err != nil {
+		return err
+	}
+	defer vg.Destroy(ctx)
+
+	info, err := mirror.GetMirroringInfo(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch info.GetState() {
+	// group is already in disabled state
+	case librbd.MirrorImageDisabled.String():
+	// group mirroring is still disabling
+	case librbd.MirrorImageDisabling.String():
+		return status.Errorf(codes.Aborted, "volume group %s is in disabling state", vg)
+	case librbd.MirrorImageEnabled.String():
+		err = corerbd.DisableVolumeReplication(mirror, ctx, info.IsPrimary(), force)
+		if err != nil {
+			return getGRPCError(err)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "volume group is in %s Mode", info.GetState())
+	}
+
+	return nil
+}
+
+// promoteVolumeGroup promotes the RBD group to primary. Groups that are not
+// mirrored as a whole have the images promoted one by one.
+func (rs *ReplicationServer) promoteVolumeGroup(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+	force bool,
+	cr *util.Credentials,
+	parameters map[string]string,
+) error {
+	vg, mirror, err := getGroupMirror(ctx, mgr, groupID)
+	if err != nil {
+		return err
+	}
+	defer vg.Destroy(ctx)
+
+	mirrored, err := isGroupMirrored(ctx, mirror)
+	if err != nil {
+		return err
+	}
+	if !mirrored {
+		return rs.failoverVolumeGroup(ctx, vg, failoverIntentPromote, force,
+			func(ctx context.Context, vol types.Volume) error {
+				return promoteVolume(ctx, vol, force, cr, parameters)
+			})
+	}
+
+	return promoteMirror(ctx, mirror, force, cr, parameters)
+}
+
+// demoteVolumeGroup demotes the RBD group to secondary. Groups that are not
+// mirrored as a whole have the images demoted one by one.
+func (rs *ReplicationServer) demoteVolumeGroup(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+) error {
+	vg, mirror, err := getGroupMirror(ctx, mgr, groupID)
+	if err != nil {
+		return err
+	}
+	defer vg.Destroy(ctx)
+
+	mirrored, err := isGroupMirrored(ctx, mirror)
+	if err != nil {
+		return err
+	}
+	if !mirrored {
+		return rs.failoverVolumeGroup(ctx, vg, failoverIntentDemote, false, demoteVolume)
+	}
+
+	info, err := mirror.GetMirroringInfo(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	// demote group to secondary
+	if info.IsPrimary() {
+		err = mirror.Demote(ctx)
+		if err != nil {
+			log.ErrorLog(ctx, err.Error())
+
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// isGroupMirrored returns true when mirroring is enabled on the RBD group as
+// a whole, instead of on the individual images.
+func isGroupMirrored(ctx context.Context, mirror types.Mirror) (bool, error) {
+	info, err := mirror.GetMirroringInfo(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return false, status.Error(codes.Internal, err.Error())
+	}
+
+	return info.GetState() == librbd.MirrorImageEnabled.String(), nil
+}
+
+// resyncVolumeGroup resyncs a secondary RBD group to correct a split-brain.
+// The resync is requested once, the time of the request is kept in the
+// journal of the group until the group is synchronized again.
+func (rs *ReplicationServer) resyncVolumeGroup(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+	force bool,
+) (*replication.ResyncVolumeResponse, error) {
+	vg, mirror, err := getGroupMirror(ctx, mgr, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer vg.Destroy(ctx)
+
+	info, err := mirror.GetMirroringInfo(ctx)
+	if err != nil {
+		// in case of Resync the images get deleted and recreated, and it
+		// takes time for this operation.
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	if info.GetState() != librbd.MirrorImageEnabled.String() {
+		return nil, status.Error(codes.InvalidArgument, "volume group mirroring is not enabled")
+	}
+
+	// return error if the group is still primary
+	if info.IsPrimary() {
+		return nil, status.Error(codes.InvalidArgument, "volume group is in primary state")
+	}
+
+	sts, err := mirror.GetGlobalMirroringStatus(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	localStatus, err := sts.GetLocalSiteStatus()
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, fmt.Errorf("failed to get local status: %w", err)
+	}
+
+	log.UsefulLog(
+		ctx,
+		"local status: daemon up=%t, group mirroring state=%q, description=%q and lastUpdate=%s",
+		localStatus.IsUP(),
+		localStatus.GetState(),
+		localStatus.GetDescription(),
+		localStatus.GetLastUpdate())
+
+	// the group is in sync when the state on both sites is up+unknown,
+	// like it is for individual images
+	ready := false
+	if localStatus.GetState() == librbd.MirrorImageStatusStateUnknown.String() && localStatus.IsUP() {
+		ready = checkRemoteSiteStatus(ctx, sts.GetAllSitesStatus())
+	}
+
+	state, err := vg.GetMirroringState(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resyncing := state != nil && state.ResyncTime != nil
+	if force && !ready && !resyncing {
+		err = mirror.Resync(ctx)
+		if err != nil {
+			return nil, getGRPCError(err)
+		}
+
+		// the images are recreated from the primary site, the caller
+		// retries until the initial version of the group is synced
+		return nil, getGRPCError(fmt.Errorf("%w: awaiting initial resync of volume group %q due to split brain",
+			corerbd.ErrUnavailable, vg))
+	}
+
+	if !ready {
+		err = checkVolumeResyncStatus(ctx, localStatus)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// the resynced images have new IDs, the journal of each volume needs
+	// to point to the new image
+	volumes, err := vg.ListVolumes(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list volumes of volume group %q: %v", vg, err)
+	}
+	for _, vol := range volumes {
+		err = vol.RepairResyncedImageID(ctx, ready)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resync Image ID of %s: %s", vol, err.Error())
+		}
+	}
+
+	if ready {
+		err = vg.ClearResync(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return &replication.ResyncVolumeResponse{
+		Ready: ready,
+	}, nil
+}
+
+// getVolumeGroupReplicationInfo returns the last sync info of a primary RBD
+// group that is mirrored as a whole.
+func (rs *ReplicationServer) getVolumeGroupReplicationInfo(
+	ctx context.Context,
+	mgr types.Manager,
+	groupID string,
+) (*replication.GetVolumeReplicationInfoResponse, error) {
+	vg, mirror, err := getGroupMirror(ctx, mgr, groupID)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to get volume group with id %q: %v", groupID, err)
+
+		return nil, err
+	}
+	defer vg.Destroy(ctx)
+
+	return getMirrorReplicationInfo(ctx, mirror)
+}
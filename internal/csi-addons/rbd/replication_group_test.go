@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollbackOrder(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		ids       []string
+		completed []string
+		want      []string
+	}{
+		{
+			name:      "nothing completed",
+			ids:       []string{"a", "b", "c"},
+			completed: nil,
+			want:      []string{"a", "b", "c"},
+		},
+		{
+			name:      "last completed is reverted first",
+			ids:       []string{"a", "b", "c", "d"},
+			completed: []string{"a", "c"},
+			want:      []string{"c", "a", "b", "d"},
+		},
+		{
+			name:      "completed volume that left the group",
+			ids:       []string{"a", "b"},
+			completed: []string{"x", "b"},
+			want:      []string{"b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, rollbackOrder(tt.ids, tt.completed))
+		})
+	}
+}
@@ -741,3 +741,192 @@ func Test_getFlattenMode(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPeerSchedulingIntervals(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       map[string]admin.Interval
+		wantErr    bool
+	}{
+		{
+			name:       "no peer intervals",
+			parameters: map[string]string{},
+			want:       map[string]admin.Interval{},
+		},
+		{
+			name: "multiple peers",
+			parameters: map[string]string{
+				peerSchedulingIntervalsKey: "site-b=5m, site-c=1h",
+			},
+			want: map[string]admin.Interval{
+				"site-b": admin.Interval("5m"),
+				"site-c": admin.Interval("1h"),
+			},
+		},
+		{
+			name: "missing interval",
+			parameters: map[string]string{
+				peerSchedulingIntervalsKey: "site-b",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid interval",
+			parameters: map[string]string{
+				peerSchedulingIntervalsKey: "site-b=5m,site-c=1w",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := getPeerSchedulingIntervals(tt.parameters)
+			if tt.wantErr {
+				require.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetPeersLastSyncInfo(t *testing.T) {
+	t.Parallel()
+	//nolint:lll // sample output cannot be split into multiple lines.
+	const (
+		descSiteB = `replaying, {"bytes_per_second":0.0,"last_snapshot_bytes":1024,"local_snapshot_timestamp":1684675261,"replay_state":"idle"}`
+		descSiteC = `replaying, {"bytes_per_second":0.0,"last_snapshot_bytes":2048,"local_snapshot_timestamp":1684670000,"replay_state":"idle"}`
+	)
+
+	siteNames := map[string]string{"uuid-b": "site-b", "uuid-c": "site-c"}
+
+	mirrorStatus := corerbd.GlobalMirrorStatus{
+		GlobalMirrorImageStatus: librbd.GlobalMirrorImageStatus{
+			SiteStatuses: []librbd.SiteMirrorImageStatus{
+				{MirrorUUID: ""},
+				{MirrorUUID: "uuid-b", Description: descSiteB},
+				{MirrorUUID: "uuid-c", Description: descSiteC},
+				{MirrorUUID: "uuid-d", Description: "starting_replay"},
+			},
+		},
+	}
+	resp, err := getPeersLastSyncInfo(context.TODO(), mirrorStatus.GetRemoteSitesStatus(), siteNames)
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1684670000, 0).UTC(), resp.GetLastSyncTime().AsTime())
+	require.Equal(t, int64(2048), resp.GetLastSyncBytes())
+
+	mirrorStatus = corerbd.GlobalMirrorStatus{
+		GlobalMirrorImageStatus: librbd.GlobalMirrorImageStatus{
+			SiteStatuses: []librbd.SiteMirrorImageStatus{
+				{MirrorUUID: "uuid-b", Description: "starting_replay"},
+			},
+		},
+	}
+	_, err = getPeersLastSyncInfo(context.TODO(), mirrorStatus.GetRemoteSitesStatus(), siteNames)
+	require.ErrorIs(t, err, corerbd.ErrLastSyncTimeNotFound)
+	require.ErrorContains(t, err, "site-b")
+}
+
+func TestValidatePoolMirroring(t *testing.T) {
+	t.Parallel()
+	peerB := types.MirrorPeer{SiteName: "site-b", MirrorUUID: "a5bb4ab7-4e4b-4b8e-8b6c-624b2e8a1cb1"}
+	imageMode := func(siteName string, peers ...types.MirrorPeer) types.PoolMirroring {
+		return types.PoolMirroring{Pool: "rbd", Mode: librbd.MirrorModeImage, SiteName: siteName, Peers: peers}
+	}
+	tests := []struct {
+		name     string
+		pm       types.PoolMirroring
+		required []string
+		wantErr  string
+	}{
+		{
+			name:     "image mode with peer",
+			pm:       imageMode("site-a", peerB),
+			required: []string{"site-b"},
+		},
+		{
+			name: "image mode without peers",
+			pm:   imageMode("site-a"),
+		},
+		{
+			name:    "mirroring disabled",
+			pm:      types.PoolMirroring{Pool: "rbd", Mode: librbd.MirrorModeDisabled, SiteName: "site-a"},
+			wantErr: "rbd mirror pool enable rbd image",
+		},
+		{
+			name: "mirroring disabled for namespace",
+			pm: types.PoolMirroring{
+				Pool:      "rbd",
+				Namespace: "ns",
+				Mode:      librbd.MirrorModeDisabled,
+				SiteName:  "site-a",
+			},
+			wantErr: "rbd mirror pool enable rbd/ns image",
+		},
+		{
+			name:    "pool mode",
+			pm:      types.PoolMirroring{Pool: "rbd", Mode: librbd.MirrorModePool, SiteName: "site-a"},
+			wantErr: "requires the pool mode image",
+		},
+		{
+			name:    "same site name",
+			pm:      imageMode("site-b", peerB),
+			wantErr: "different site names",
+		},
+		{
+			name:     "required peer missing",
+			pm:       imageMode("site-a", peerB),
+			required: []string{"site-c"},
+			wantErr:  `mirror peer "site-c" is not configured`,
+		},
+		{
+			name:     "required peer not contacted",
+			pm:       imageMode("site-a", types.MirrorPeer{SiteName: "site-b"}),
+			required: []string{"site-b"},
+			wantErr:  "not contacted by an rbd-mirror daemon",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := validatePoolMirroring(&tt.pm, librbd.ImageMirrorModeSnapshot, tt.required)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, codes.FailedPrecondition, status.Code(err))
+			require.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidatePoolMirroringPoolMode(t *testing.T) {
+	t.Parallel()
+
+	pm := types.PoolMirroring{Pool: "rbd", Mode: librbd.MirrorModePool, SiteName: "site-a"}
+	require.NoError(t, validatePoolMirroring(&pm, librbd.ImageMirrorModeJournal, nil))
+
+	err := validatePoolMirroring(&pm, librbd.ImageMirrorModeSnapshot, nil)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestGetConfigurePoolMirroring(t *testing.T) {
+	t.Parallel()
+	got, err := getConfigurePoolMirroring(map[string]string{})
+	require.NoError(t, err)
+	require.False(t, got)
+
+	got, err = getConfigurePoolMirroring(map[string]string{configurePoolMirroringKey: "true"})
+	require.NoError(t, err)
+	require.True(t, got)
+
+	_, err = getConfigurePoolMirroring(map[string]string{configurePoolMirroringKey: "maybe"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
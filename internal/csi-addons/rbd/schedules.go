@@ -0,0 +1,199 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/ceph/go-ceph/rbd/admin"
+)
+
+// snapshotSchedulesKey is the key to get/set the mirror snapshot schedules
+// that are expected for the image in the image metadata. The key is starting
+// with `.rbd` so that it will not get replicated to remote cluster, the
+// schedules are set again when the image is promoted there.
+const snapshotSchedulesKey = ".rbd.mirror.snapshot_schedules"
+
+// getExpectedSchedules returns the snapshot schedules that are added for the
+// image with the parameters when it is promoted: the schedulingInterval and
+// an additional schedule for every peer interval that differs from it.
+func getExpectedSchedules(parameters map[string]string) ([]admin.ScheduleTerm, error) {
+	schedules := []admin.ScheduleTerm{}
+	if imageMirroringMode(parameters[imageMirroringKey]) == imageMirrorModeJournal {
+		return schedules, nil
+	}
+
+	interval, startTime := getSchedulingDetails(parameters)
+	if interval != admin.NoInterval {
+		schedules = append(schedules, admin.ScheduleTerm{Interval: interval, StartTime: startTime})
+	}
+
+	peerIntervals, err := getPeerSchedulingIntervals(parameters)
+	if err != nil {
+		return nil, err
+	}
+	for _, peerInterval := range peerIntervals {
+		if !hasScheduleInterval(schedules, peerInterval) {
+			schedules = append(schedules, admin.ScheduleTerm{Interval: peerInterval, StartTime: startTime})
+		}
+	}
+
+	// the peer intervals come from a map, sorting keeps the stored value
+	// stable
+	slices.SortFunc(schedules, func(a, b admin.ScheduleTerm) int {
+		return cmp.Compare(a.Interval, b.Interval)
+	})
+
+	return schedules, nil
+}
+
+// hasScheduleInterval returns true when one of the schedules has the
+// interval. The start time is not compared, the mgr module reports it in a
+// normalized format that can differ from the parameters.
+func hasScheduleInterval(schedules []admin.ScheduleTerm, interval admin.Interval) bool {
+	return slices.ContainsFunc(schedules, func(st admin.ScheduleTerm) bool {
+		return st.Interval == interval
+	})
+}
+
+// missingSchedules returns the expected schedules that are not in current.
+func missingSchedules(expected, current []admin.ScheduleTerm) []admin.ScheduleTerm {
+	missing := []admin.ScheduleTerm{}
+	for _, st := range expected {
+		if !hasScheduleInterval(current, st.Interval) {
+			missing = append(missing, st)
+		}
+	}
+
+	return missing
+}
+
+// storeExpectedSchedules records the schedules in the image metadata, so that
+// the schedules can be verified when no parameters are available.
+func storeExpectedSchedules(rbdVol types.Volume, schedules []admin.ScheduleTerm) error {
+	value, err := json.Marshal(schedules)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot schedules: %w", err)
+	}
+
+	err = rbdVol.SetMetadata(snapshotSchedulesKey, string(value))
+	if err != nil {
+		return fmt.Errorf("failed to store snapshot schedules of %s: %w", rbdVol, err)
+	}
+
+	return nil
+}
+
+// getStoredSchedules returns the schedules that were recorded in the image
+// metadata, the image has no expected schedules when the key is missing.
+func getStoredSchedules(rbdVol types.Volume) ([]admin.ScheduleTerm, error) {
+	schedules := []admin.ScheduleTerm{}
+
+	value, err := rbdVol.GetMetadata(snapshotSchedulesKey)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return schedules, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot schedules of %s: %w", rbdVol, err)
+	}
+
+	err = json.Unmarshal([]byte(value), &schedules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot schedules %q of %s: %w", value, rbdVol, err)
+	}
+
+	return schedules, nil
+}
+
+// reconcileSnapshotSchedules adds the expected schedules that are missing on
+// the image again. Ceph drops the schedules of an image after some
+// operations, like migrating the image to another pool, the image would not
+// be synchronized with its peers anymore.
+func reconcileSnapshotSchedules(
+	ctx context.Context,
+	mirror types.Mirror,
+	expected []admin.ScheduleTerm,
+) error {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	current, err := mirror.GetSnapshotSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, st := range missingSchedules(expected, current) {
+		log.WarningLog(ctx, "snapshot schedule at interval %s, start time %q of %s is missing, adding it again",
+			st.Interval, st.StartTime, mirror)
+
+		err = mirror.AddSnapshotScheduling(st.Interval, st.StartTime)
+		if err != nil {
+			return fmt.Errorf("failed to add snapshot schedule at interval %s for %s: %w", st.Interval, mirror, err)
+		}
+	}
+
+	return nil
+}
+
+// reconcileVolumeSchedules verifies the schedules that were recorded for the
+// image when it was promoted, only a primary image has schedules.
+func reconcileVolumeSchedules(ctx context.Context, rbdVol types.Volume, mirror types.Mirror) error {
+	info, err := mirror.GetMirroringInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if info.GetState() != librbd.MirrorImageEnabled.String() || !info.IsPrimary() {
+		return nil
+	}
+
+	expected, err := getStoredSchedules(rbdVol)
+	if err != nil {
+		return err
+	}
+
+	return reconcileSnapshotSchedules(ctx, mirror, expected)
+}
+
+// reconcileExpectedSchedules verifies the schedules from the parameters of a
+// primary image, and records them for later verification.
+func reconcileExpectedSchedules(
+	ctx context.Context,
+	rbdVol types.Volume,
+	mirror types.Mirror,
+	parameters map[string]string,
+) error {
+	expected, err := getExpectedSchedules(parameters)
+	if err != nil {
+		return err
+	}
+
+	err = reconcileSnapshotSchedules(ctx, mirror, expected)
+	if err != nil {
+		return err
+	}
+
+	return storeExpectedSchedules(rbdVol, expected)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/ceph/go-ceph/rbd/admin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetExpectedSchedules(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       []admin.ScheduleTerm
+		wantErr    bool
+	}{
+		{
+			name:       "no schedules",
+			parameters: map[string]string{},
+			want:       []admin.ScheduleTerm{},
+		},
+		{
+			name: "interval with start time",
+			parameters: map[string]string{
+				schedulingIntervalKey:  "1h",
+				schedulingStartTimeKey: "14:00:00-05:00",
+			},
+			want: []admin.ScheduleTerm{{Interval: "1h", StartTime: "14:00:00-05:00"}},
+		},
+		{
+			name: "peer intervals are added once",
+			parameters: map[string]string{
+				schedulingIntervalKey:      "5m",
+				peerSchedulingIntervalsKey: "site-b=5m,site-c=1d,site-d=1d",
+			},
+			want: []admin.ScheduleTerm{{Interval: "1d"}, {Interval: "5m"}},
+		},
+		{
+			name: "journal mirroring has no schedules",
+			parameters: map[string]string{
+				imageMirroringKey:     string(imageMirrorModeJournal),
+				schedulingIntervalKey: "1h",
+			},
+			want: []admin.ScheduleTerm{},
+		},
+		{
+			name: "invalid peer interval",
+			parameters: map[string]string{
+				peerSchedulingIntervalsKey: "site-b",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := getExpectedSchedules(tt.parameters)
+			if tt.wantErr {
+				require.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMissingSchedules(t *testing.T) {
+	t.Parallel()
+
+	expected := []admin.ScheduleTerm{
+		{Interval: "1h", StartTime: "14:00:00-05:00"},
+		{Interval: "5m"},
+	}
+
+	// the start time is reported in another format by the mgr module
+	current := []admin.ScheduleTerm{{Interval: "1h", StartTime: "19:00:00"}}
+	require.Equal(t, []admin.ScheduleTerm{{Interval: "5m"}}, missingSchedules(expected, current))
+	require.Empty(t, missingSchedules(expected, append(current, admin.ScheduleTerm{Interval: "5m"})))
+	require.Equal(t, expected, missingSchedules(expected, nil))
+}
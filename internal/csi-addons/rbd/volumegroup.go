@@ -21,10 +21,13 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"time"
 
+	"github.com/ceph/ceph-csi/internal/journal"
 	"github.com/ceph/ceph-csi/internal/rbd"
 	"github.com/ceph/ceph-csi/internal/rbd/group"
 	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
 	"github.com/csi-addons/spec/lib/go/volumegroup"
@@ -33,6 +36,18 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// modifyMembershipRetry is used to retry ModifyVolumeGroupMembership when
+// the group was modified by a concurrent request.
+var modifyMembershipRetry = util.RetryPolicy{
+	InitialInterval: 100 * time.Millisecond,
+	Multiplier:      2,
+	Jitter:          0.5,
+	MaxAttempts:     5,
+	Retryable: func(err error) bool {
+		return status.Code(err) == codes.Aborted
+	},
+}
+
 // VolumeGroupServer struct of rbd CSI driver with supported methods of
 // VolumeGroup controller server spec.
 type VolumeGroupServer struct {
@@ -43,13 +58,18 @@ type VolumeGroupServer struct {
 
 	// driverInstance is the unique ID for this CSI-driver deployment.
 	driverInstance string
+
+	// volumeGroupLocks prevents concurrent modifications of the membership
+	// of a volume group.
+	volumeGroupLocks *util.VolumeLocks
 }
 
 // NewVolumeGroupServer creates a new VolumeGroupServer which handles the
 // VolumeGroup Service requests from the CSI-Addons specification.
-func NewVolumeGroupServer(instanceID string) *VolumeGroupServer {
+func NewVolumeGroupServer(instanceID string, volumeGroupLocks *util.VolumeLocks) *VolumeGroupServer {
 	return &VolumeGroupServer{
-		driverInstance: instanceID,
+		driverInstance:   instanceID,
+		volumeGroupLocks: volumeGroupLocks,
 	}
 }
 
@@ -114,7 +134,7 @@ func (vs *VolumeGroupServer) CreateVolumeGroup(
 	vg, err := mgr.CreateVolumeGroup(ctx, req.GetName())
 	if err != nil {
 		return nil, status.Errorf(
-			codes.Internal,
+			volumeGroupErrorCode(err),
 			"failed to create volume group %q: %s",
 			req.GetName(),
 			err.Error())
@@ -142,7 +162,7 @@ func (vs *VolumeGroupServer) CreateVolumeGroup(
 		err = vg.AddVolume(ctx, vol)
 		if err != nil {
 			return nil, status.Errorf(
-				codes.Internal,
+				volumeGroupErrorCode(err),
 				"failed to add volume %q to volume group %q: %s",
 				vol,
 				req.GetName(),
@@ -201,7 +221,7 @@ func (vs *VolumeGroupServer) DeleteVolumeGroup(
 		}
 
 		return nil, status.Errorf(
-			codes.Internal,
+			volumeGroupErrorCode(err),
 			"could not fetch volume group %q: %s",
 			req.GetVolumeGroupId(),
 			err.Error())
@@ -232,7 +252,7 @@ func (vs *VolumeGroupServer) DeleteVolumeGroup(
 	// delete the volume group
 	err = vg.Delete(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal,
+		return nil, status.Errorf(volumeGroupErrorCode(err),
 			"failed to delete volume group %q: %s",
 			req.GetVolumeGroupId(),
 			err.Error())
@@ -279,11 +299,43 @@ func (vs *VolumeGroupServer) DeleteVolumeGroup(
 // - remove the volumes from the group
 // - add the volumes to the group
 //
+// When the journal of the group was modified by a concurrent request while
+// the volumes were compared, all steps are repeated with a backoff.
+//
 // Also, MODIFY_VOLUME_GROUP_MEMBERSHIP does not exist, it is called
 // MODIFY_VOLUME_GROUP instead.
 func (vs *VolumeGroupServer) ModifyVolumeGroupMembership(
 	ctx context.Context,
 	req *volumegroup.ModifyVolumeGroupMembershipRequest,
+) (*volumegroup.ModifyVolumeGroupMembershipResponse, error) {
+	volumeGroupID := req.GetVolumeGroupId()
+	if acquired := vs.volumeGroupLocks.TryAcquire(volumeGroupID); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, volumeGroupID)
+
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeGroupID)
+	}
+	defer vs.volumeGroupLocks.Release(volumeGroupID)
+
+	var res *volumegroup.ModifyVolumeGroupMembershipResponse
+	err := util.Retry(ctx, modifyMembershipRetry, func(ctx context.Context) error {
+		var err error
+		res, err = vs.modifyVolumeGroupMembership(ctx, req)
+		if status.Code(err) == codes.Aborted {
+			log.DebugLog(ctx, "modification of volume group %q was aborted: %v", req.GetVolumeGroupId(), err)
+		}
+
+		return err
+	})
+	if ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	return res, err
+}
+
+func (vs *VolumeGroupServer) modifyVolumeGroupMembership(
+	ctx context.Context,
+	req *volumegroup.ModifyVolumeGroupMembershipRequest,
 ) (*volumegroup.ModifyVolumeGroupMembershipResponse, error) {
 	mgr := rbd.NewManager(vs.driverInstance, nil, req.GetSecrets())
 	defer mgr.Destroy(ctx)
@@ -292,13 +344,24 @@ func (vs *VolumeGroupServer) ModifyVolumeGroupMembership(
 	vg, err := mgr.GetVolumeGroupByID(ctx, req.GetVolumeGroupId())
 	if err != nil {
 		return nil, status.Errorf(
-			codes.NotFound,
+			volumeGroupErrorCode(err),
 			"could not find volume group %q: %s",
 			req.GetVolumeGroupId(),
 			err.Error())
 	}
 	defer vg.Destroy(ctx)
 
+	// an interrupted AddVolume or RemoveVolume leaves the RBD group and
+	// the journal out of sync, repair it before comparing the volumes
+	err = vg.RepairMembers(ctx)
+	if err != nil {
+		return nil, status.Errorf(
+			codes.Internal,
+			"failed to repair the members of volume group %q: %v",
+			vg,
+			err)
+	}
+
 	beforeVolumes, err := vg.ListVolumes(ctx)
 	if err != nil {
 		return nil, status.Errorf(
@@ -346,7 +409,7 @@ func (vs *VolumeGroupServer) ModifyVolumeGroupMembership(
 		err = vg.RemoveVolume(ctx, vol)
 		if err != nil {
 			return nil, status.Errorf(
-				codes.Internal,
+				volumeGroupErrorCode(err),
 				"failed to remove volume %q from volume group %q: %v",
 				vol,
 				vg,
@@ -395,7 +458,7 @@ func (vs *VolumeGroupServer) ModifyVolumeGroupMembership(
 		err = vg.AddVolume(ctx, vol)
 		if err != nil {
 			return nil, status.Errorf(
-				codes.Internal,
+				volumeGroupErrorCode(err),
 				"failed to add volume %q to volume group %q: %v",
 				vol,
 				vg,
@@ -417,6 +480,36 @@ func (vs *VolumeGroupServer) ModifyVolumeGroupMembership(
 	}, nil
 }
 
+// volumeGroupErrors maps the errors of the volume group operations to the
+// gRPC code that tells the sidecar if, and how soon, the request should be
+// retried. The first matching error is used.
+var volumeGroupErrors = []struct {
+	err  error
+	code codes.Code
+}{
+	// the journal of the group was modified concurrently, the request can
+	// be retried right away
+	{journal.ErrObjectModified, codes.Aborted},
+	{group.ErrIncompatibleVolume, codes.InvalidArgument},
+	{group.ErrRBDGroupNotFound, codes.NotFound},
+	// retrying does not help until the pool is created again
+	{util.ErrPoolNotFound, codes.FailedPrecondition},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	{context.Canceled, codes.Canceled},
+}
+
+// volumeGroupErrorCode returns the gRPC code for an error of a volume group
+// operation, errors that are not known are Internal.
+func volumeGroupErrorCode(err error) codes.Code {
+	for _, e := range volumeGroupErrors {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	return codes.Internal
+}
+
 // ControllerGetVolumeGroup RPC call to get a volume group.
 //
 // From the spec:
@@ -444,7 +537,7 @@ func (vs *VolumeGroupServer) ControllerGetVolumeGroup(
 		}
 
 		return nil, status.Errorf(
-			codes.Internal,
+			volumeGroupErrorCode(err),
 			"could not fetch volume group %q: %s",
 			req.GetVolumeGroupId(),
 			err.Error())
@@ -0,0 +1,51 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/rbd/group"
+	"github.com/ceph/ceph-csi/internal/util"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestVolumeGroupErrorCode(t *testing.T) {
+	t.Parallel()
+
+	modified := fmt.Errorf("failed to add mapping for volume %q: %w", "vol-1", journal.ErrObjectModified)
+	require.Equal(t, codes.Aborted, volumeGroupErrorCode(modified))
+	require.Equal(t, codes.Internal, volumeGroupErrorCode(errors.New("permission denied")))
+
+	tests := map[error]codes.Code{
+		group.ErrIncompatibleVolume: codes.InvalidArgument,
+		group.ErrRBDGroupNotFound:   codes.NotFound,
+		util.ErrPoolNotFound:        codes.FailedPrecondition,
+		context.DeadlineExceeded:    codes.DeadlineExceeded,
+		context.Canceled:            codes.Canceled,
+	}
+	for err, code := range tests {
+		wrapped := fmt.Errorf("failed to get volume group %q: %w", "vg-1", err)
+		require.Equal(t, code, volumeGroupErrorCode(wrapped), err.Error())
+	}
+}
@@ -24,12 +24,16 @@ import (
 	"os"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
-var ErrNoUDS = errors.New("no UNIX domain socket")
+var (
+	ErrNoUDS = errors.New("no UNIX domain socket")
+	ErrNoTCP = errors.New("no TCP endpoint")
+)
 
 // CSIAddonsService is the interface that is required to be implemented so that
 // the CSIAddonsServer can register the service by calling RegisterService().
@@ -40,15 +44,21 @@ type CSIAddonsService interface {
 }
 
 // CSIAddonsServer is the gRPC server that listens on an endpoint (UNIX domain
-// socket) where the CSI-Addons requests come in.
+// socket) where the CSI-Addons requests come in. Optionally, the requests are
+// served on a TCP endpoint with mutual TLS as well.
 type CSIAddonsServer struct {
 	// URL components to listen on the UNIX domain socket
 	scheme string
 	path   string
 
+	// address and certificates of the optional TCP endpoint
+	tcpAddress string
+	certs      *certReloader
+
 	// state of the CSIAddonsServer
-	server   *grpc.Server
-	services []CSIAddonsService
+	server    *grpc.Server
+	tcpServer *grpc.Server
+	services  []CSIAddonsService
 }
 
 // NewCSIAddonsServer create a new CSIAddonsServer on the given endpoint. The
@@ -75,6 +85,29 @@ func NewCSIAddonsServer(endpoint string) (*CSIAddonsServer, error) {
 	return cas, nil
 }
 
+// EnableTCP configures the CSIAddonsServer to serve the requests on the TCP
+// endpoint too. The clients need to present a certificate that is signed by
+// the CA in the TLSFiles. Modified certificates are loaded for new
+// connections. This function should be called before Start.
+func (cas *CSIAddonsServer) EnableTCP(endpoint string, files TLSFiles) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "tcp" || u.Host == "" {
+		return fmt.Errorf("%w: %s", ErrNoTCP, endpoint)
+	}
+
+	cas.certs, err = newCertReloader(files)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificates for %q: %w", endpoint, err)
+	}
+	cas.tcpAddress = u.Host
+
+	return nil
+}
+
 // RegisterService takes the CSIAddonsService and registers it with the
 // CSIAddonsServer gRPC server. This function should be called before Start,
 // where the services are registered on the internal gRPC server.
@@ -103,18 +136,48 @@ func (cas *CSIAddonsServer) Start(middlewareConfig csicommon.MiddlewareServerOpt
 		return fmt.Errorf("failed to listen on %q: %w", cas.path, err)
 	}
 
-	go cas.serve(listener)
+	go cas.serve(cas.server, listener)
+
+	if cas.tcpAddress != "" {
+		err = cas.startTCP(middlewareConfig)
+		if err != nil {
+			cas.server.Stop()
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startTCP creates the gRPC server with mutual TLS for the TCP endpoint, and
+// serves the requests in a go-routine.
+func (cas *CSIAddonsServer) startTCP(middlewareConfig csicommon.MiddlewareServerOptionConfig) error {
+	cas.tcpServer = grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(cas.certs.tlsConfig())),
+		csicommon.NewMiddlewareServerOption(middlewareConfig))
+
+	for _, svc := range cas.services {
+		svc.RegisterService(cas.tcpServer)
+	}
+
+	listener, err := net.Listen("tcp", cas.tcpAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", cas.tcpAddress, err)
+	}
+
+	go cas.serve(cas.tcpServer, listener)
 
 	return nil
 }
 
 // serve starts the actual process of listening for requests on the gRPC
 // server. This is a blocking call, so it should get executed in a go-routine.
-func (cas *CSIAddonsServer) serve(listener net.Listener) {
+func (cas *CSIAddonsServer) serve(server *grpc.Server, listener net.Listener) {
 	log.DefaultLog("listening for CSI-Addons requests on address: %#v", listener.Addr())
 
 	// start to serve requests
-	err := cas.server.Serve(listener)
+	err := server.Serve(listener)
 	if err != nil && !errors.Is(err, grpc.ErrServerStopped) {
 		log.FatalLogMsg("failed to setup CSI-Addons server: %v", err)
 	}
@@ -124,6 +187,10 @@ func (cas *CSIAddonsServer) serve(listener net.Listener) {
 
 // Stop can be used to stop the internal gRPC server.
 func (cas *CSIAddonsServer) Stop() {
+	if cas.tcpServer != nil {
+		cas.tcpServer.GracefulStop()
+	}
+
 	if cas.server == nil {
 		return
 	}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// TLSFiles are the PEM encoded files that are used for mutual TLS on the TCP
+// endpoint of the CSIAddonsServer.
+type TLSFiles struct {
+	// CertFile and KeyFile contain the certificate of the server.
+	CertFile string
+	KeyFile  string
+	// CAFile contains the CA certificates that sign the certificates of
+	// the clients.
+	CAFile string
+}
+
+// certReloader loads the TLS configuration from the TLSFiles, and loads it
+// again when one of the files was modified, so that rotated certificates are
+// used without a restart.
+type certReloader struct {
+	files TLSFiles
+
+	mux     sync.Mutex
+	modTime time.Time
+	config  *tls.Config
+}
+
+func newCertReloader(files TLSFiles) (*certReloader, error) {
+	if files.CertFile == "" || files.KeyFile == "" || files.CAFile == "" {
+		return nil, errors.New("a certificate, key and CA file are required for mutual TLS")
+	}
+
+	r := &certReloader{files: files}
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	r.config, err = r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.modTime = modTime
+
+	return r, nil
+}
+
+// tlsConfig returns the TLS configuration of the server, which uses the
+// current certificates for every connection.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfigForClient,
+	}
+}
+
+// getConfigForClient returns the configuration for a new connection. When
+// loading modified files fails, the previous configuration is kept.
+func (r *certReloader) getConfigForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	modTime, err := r.lastModified()
+	if err != nil || !modTime.After(r.modTime) {
+		return r.config, nil
+	}
+
+	config, err := r.load()
+	if err != nil {
+		log.WarningLogMsg("failed to reload the TLS certificates of the CSI-Addons server, "+
+			"keeping the previous ones: %v", err)
+
+		return r.config, nil
+	}
+	log.DefaultLog("reloaded the TLS certificates of the CSI-Addons server")
+	r.config = config
+	r.modTime = modTime
+
+	return r.config, nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %q: %w", file, err)
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// load reads the files, and returns a configuration that requires clients to
+// present a certificate that is signed by the CA.
+func (r *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %q: %w", r.files.CertFile, err)
+	}
+
+	ca, err := os.ReadFile(r.files.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file %q: %w", r.files.CAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no CA certificates found in %q", r.files.CAFile)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
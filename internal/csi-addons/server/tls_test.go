@@ -0,0 +1,112 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTLSFiles writes a self-signed certificate that is its own CA to dir.
+func writeTLSFiles(t *testing.T, dir, name string) TLSFiles {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := TLSFiles{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(files.CertFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(files.CAFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return files
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+
+	_, err := newCertReloader(TLSFiles{CertFile: "tls.crt", KeyFile: "tls.key"})
+	require.Error(t, err)
+
+	dir := t.TempDir()
+	files := writeTLSFiles(t, dir, "first")
+	r, err := newCertReloader(files)
+	require.NoError(t, err)
+
+	config, err := r.getConfigForClient(nil)
+	require.NoError(t, err)
+	first := config.Certificates[0].Certificate[0]
+
+	// unmodified files keep the configuration
+	config, err = r.getConfigForClient(nil)
+	require.NoError(t, err)
+	require.Equal(t, first, config.Certificates[0].Certificate[0])
+
+	// rotated certificates are loaded
+	writeTLSFiles(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{files.CertFile, files.KeyFile, files.CAFile} {
+		require.NoError(t, os.Chtimes(file, later, later))
+	}
+	config, err = r.getConfigForClient(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, first, config.Certificates[0].Certificate[0])
+}
+
+func TestEnableTCP(t *testing.T) {
+	t.Parallel()
+
+	files := writeTLSFiles(t, t.TempDir(), "server")
+
+	cas, err := NewCSIAddonsServer("unix:///tmp/csi-addons.sock")
+	require.NoError(t, err)
+
+	require.ErrorIs(t, cas.EnableTCP("unix:///tmp/csi-addons.sock", files), ErrNoTCP)
+	require.Error(t, cas.EnableTCP("tcp://0.0.0.0:9070", TLSFiles{}))
+	require.NoError(t, cas.EnableTCP("tcp://0.0.0.0:9070", files))
+	require.Equal(t, "0.0.0.0:9070", cas.tcpAddress)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultMaxMessageSize is the size of the messages that a gRPC client
+	// accepts by default, the sidecars do not change it.
+	DefaultMaxMessageSize = 4 * 1024 * 1024
+
+	// listResponseReserve is the part of a List response that is kept free
+	// for the next_token and the encoding of the response itself.
+	listResponseReserve = 4 * 1024
+)
+
+// ListLimits bound the responses of the List RPCs of a ControllerServer, so
+// that a response is never larger than a gRPC message. The CO continues with
+// the next_token of a response that is cut short.
+type ListLimits struct {
+	// MaxEntries is the maximum number of entries in a response, also when
+	// the request does not set max_entries. 0 means unlimited.
+	MaxEntries int64
+	// MaxMessageSize is the size of a gRPC message, DefaultMaxMessageSize
+	// is used when it is 0.
+	MaxMessageSize int
+}
+
+// MaxEntriesFor returns the number of entries to return for a request with
+// max_entries set to requested, 0 means unlimited.
+func (l ListLimits) MaxEntriesFor(requested int32) int64 {
+	maxEntries := int64(requested)
+	if l.MaxEntries > 0 && (maxEntries == 0 || maxEntries > l.MaxEntries) {
+		maxEntries = l.MaxEntries
+	}
+
+	return maxEntries
+}
+
+// NewResponseBudget returns a ResponseBudget for a single List response.
+func (l ListLimits) NewResponseBudget() *ResponseBudget {
+	size := l.MaxMessageSize
+	if size <= 0 {
+		size = DefaultMaxMessageSize
+	}
+
+	return &ResponseBudget{limit: max(size-listResponseReserve, 0)}
+}
+
+// ResponseBudget tracks the encoded size of the entries of a List response.
+type ResponseBudget struct {
+	limit   int
+	size    int
+	entries int
+}
+
+// Add accounts for the entry and returns true when it fits in the response.
+// An entry that does not fit is not accounted for, the response should be
+// returned with a next_token then. The first entry always fits, so that
+// every request makes progress.
+func (b *ResponseBudget) Add(entry proto.Message) bool {
+	// a repeated field is encoded with a tag and the length of the entry
+	size := protowire.SizeTag(1) + protowire.SizeBytes(proto.Size(entry))
+	if b.entries != 0 && b.size+size > b.limit {
+		return false
+	}
+
+	b.size += size
+	b.entries++
+
+	return true
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestListLimitsMaxEntriesFor(t *testing.T) {
+	t.Parallel()
+
+	unlimited := ListLimits{}
+	require.Equal(t, int64(0), unlimited.MaxEntriesFor(0))
+	require.Equal(t, int64(50), unlimited.MaxEntriesFor(50))
+
+	limited := ListLimits{MaxEntries: 100}
+	require.Equal(t, int64(100), limited.MaxEntriesFor(0))
+	require.Equal(t, int64(50), limited.MaxEntriesFor(50))
+	require.Equal(t, int64(100), limited.MaxEntriesFor(500))
+}
+
+func TestResponseBudget(t *testing.T) {
+	t.Parallel()
+
+	entry := &csi.ListVolumesResponse_Entry{
+		Volume: &csi.Volume{
+			VolumeId:      "0001-0009-rook-ceph-0000000000000002-" + strings.Repeat("a", 36),
+			CapacityBytes: 1 << 30,
+		},
+	}
+
+	limits := ListLimits{MaxMessageSize: listResponseReserve + 10*proto.Size(entry)}
+	budget := limits.NewResponseBudget()
+	added := 0
+	for budget.Add(entry) {
+		added++
+	}
+	require.Positive(t, added)
+	require.Less(t, added, 10)
+
+	// the entries and a next_token fit in a message
+	resp := &csi.ListVolumesResponse{NextToken: strings.Repeat("t", 256)}
+	for range added {
+		resp.Entries = append(resp.Entries, entry)
+	}
+	require.LessOrEqual(t, proto.Size(resp), limits.MaxMessageSize)
+
+	// the first entry is always added
+	tiny := ListLimits{MaxMessageSize: 1}
+	require.True(t, tiny.NewResponseBudget().Add(entry))
+}
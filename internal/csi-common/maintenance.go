@@ -0,0 +1,143 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"path"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maintenanceModeMutations are the names of the gRPC methods of the CSI and
+// CSI-Addons controller services that modify the storage backend. These are
+// rejected while maintenance mode is enabled. Node operations, and requests
+// that only read the state of volumes, are not affected, except for the
+// batch operations that run on all volumes of a node.
+var maintenanceModeMutations = map[string]bool{
+	// CSI Controller service
+	"CreateVolume":           true,
+	"DeleteVolume":           true,
+	"ControllerExpandVolume": true,
+	"ControllerModifyVolume": true,
+	"CreateSnapshot":         true,
+	"DeleteSnapshot":         true,
+	// ControllerPublishVolume updates the attachments in the image metadata
+	// with AttachTracking.
+	"ControllerPublishVolume": true,
+	// CSI GroupController service
+	"CreateVolumeGroupSnapshot": true,
+	"DeleteVolumeGroupSnapshot": true,
+	// CSI-Addons services
+	"EnableVolumeReplication":     true,
+	"DisableVolumeReplication":    true,
+	"PromoteVolume":               true,
+	"DemoteVolume":                true,
+	"ResyncVolume":                true,
+	"CreateVolumeGroup":           true,
+	"DeleteVolumeGroup":           true,
+	"ModifyVolumeGroupMembership": true,
+	"EncryptionKeyRotate":         true,
+	"ControllerReclaimSpace":      true,
+	// BatchReclaimSpace service
+	"NodeReclaimSpaceStagedVolumes": true,
+}
+
+// maintenanceModeTeardown are the names of the gRPC methods that detach
+// volumes from nodes. They are never rejected, so that nodes can be drained
+// and pods can be removed while maintenance mode is enabled.
+var maintenanceModeTeardown = map[string]bool{
+	"ControllerUnpublishVolume": true,
+	"NodeUnpublishVolume":       true,
+	"NodeUnstageVolume":         true,
+}
+
+// isMaintenanceModeMutation returns true if the gRPC method is blocked in
+// maintenance mode.
+func isMaintenanceModeMutation(fullMethod string) bool {
+	method := path.Base(fullMethod)
+	if maintenanceModeTeardown[method] {
+		return false
+	}
+
+	return maintenanceModeMutations[method]
+}
+
+// requestClusterID returns the cluster ID of the volumes or snapshots of a
+// request, from the clusterID parameter or from the first ID that can be
+// decoded. An empty string is returned when the cluster is not known.
+func requestClusterID(req interface{}) string {
+	if r, ok := req.(interface{ GetParameters() map[string]string }); ok {
+		if clusterID := r.GetParameters()["clusterID"]; clusterID != "" {
+			return clusterID
+		}
+	}
+
+	ids := []string{}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok {
+		ids = append(ids, r.GetVolumeId())
+	}
+	if r, ok := req.(interface{ GetSourceVolumeId() string }); ok {
+		ids = append(ids, r.GetSourceVolumeId())
+	}
+	if r, ok := req.(interface{ GetSnapshotId() string }); ok {
+		ids = append(ids, r.GetSnapshotId())
+	}
+	for _, id := range ids {
+		vi := util.CSIIdentifier{}
+		if id != "" && vi.DecomposeCSIID(id) == nil {
+			return vi.ClusterID
+		}
+	}
+
+	return ""
+}
+
+// maintenanceModeGuard returns Unavailable for mutating requests while
+// maintenance mode is enabled in the file at pathToConfig for the cluster of
+// the request. Requests of which the cluster is not known are rejected while
+// maintenance mode is enabled for any cluster.
+func maintenanceModeGuard(
+	pathToConfig string,
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if !isMaintenanceModeMutation(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	mm := util.GetMaintenanceMode(pathToConfig)
+	enabled := mm.Enabled()
+	if clusterID := requestClusterID(req); clusterID != "" {
+		enabled = mm.EnabledFor(clusterID)
+	}
+	if enabled {
+		log.WarningLog(ctx, "rejecting %s, maintenance mode is enabled", info.FullMethod)
+
+		return nil, status.Errorf(codes.Unavailable,
+			"%s is not allowed while maintenance mode is enabled", path.Base(info.FullMethod))
+	}
+
+	return handler(ctx, req)
+}
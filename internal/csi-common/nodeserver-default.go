@@ -18,6 +18,7 @@ package csicommon
 
 import (
 	"context"
+	"strings"
 
 	"github.com/ceph/ceph-csi/internal/util/log"
 
@@ -96,6 +97,38 @@ func ConstructMountOptions(mountOptions []string, volCap *csi.VolumeCapability)
 	return mountOptions
 }
 
+// selinuxContextOptions are the mount options that set the SELinux context of
+// a superblock. The kubelet passes "context=..." in the mount flags when the
+// CSIDriver has seLinuxMount enabled.
+var selinuxContextOptions = []string{"context=", "fscontext=", "defcontext=", "rootcontext="}
+
+// IsSELinuxContextOption returns true if the mount option sets an SELinux
+// context.
+func IsSELinuxContextOption(opt string) bool {
+	for _, prefix := range selinuxContextOptions {
+		if strings.HasPrefix(opt, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConstructBindMountOptions returns the unique mount options for bind
+// mounting a staged volume to the target path. The SELinux context is a
+// property of the superblock that gets set while staging the volume, it can
+// not be changed with a bind mount and is therefore not included.
+func ConstructBindMountOptions(mountOptions []string, volCap *csi.VolumeCapability) []string {
+	options := make([]string, 0, len(mountOptions))
+	for _, opt := range ConstructMountOptions(mountOptions, volCap) {
+		if !IsSELinuxContextOption(opt) {
+			options = append(options, opt)
+		}
+	}
+
+	return options
+}
+
 // MountOptionContains checks the opt is present in mountOptions.
 func MountOptionContains(mountOptions []string, opt string) bool {
 	for _, mnt := range mountOptions {
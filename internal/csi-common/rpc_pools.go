@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// RPCClass is a group of gRPC methods that share a worker pool.
+type RPCClass string
+
+const (
+	// RPCClassStage are the long-running node operations that map, format,
+	// check and resize volumes.
+	RPCClassStage RPCClass = "stage"
+	// RPCClassPublish are the node operations that bind mount staged
+	// volumes into the pods.
+	RPCClassPublish RPCClass = "publish"
+)
+
+// rpcClasses maps the gRPC methods to their RPCClass. Methods that are not
+// listed, like NodeGetVolumeStats and the identity calls, are never queued.
+var rpcClasses = map[string]RPCClass{
+	"/csi.v1.Node/NodeStageVolume":     RPCClassStage,
+	"/csi.v1.Node/NodeUnstageVolume":   RPCClassStage,
+	"/csi.v1.Node/NodeExpandVolume":    RPCClassStage,
+	"/csi.v1.Node/NodePublishVolume":   RPCClassPublish,
+	"/csi.v1.Node/NodeUnpublishVolume": RPCClassPublish,
+}
+
+var (
+	rpcQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "csi",
+		Subsystem: "grpc",
+		Name:      "queued_requests",
+		Help:      "Number of gRPC calls that are waiting for a worker of their class",
+	}, []string{"class"})
+	registerRPCPoolMetricsOnce sync.Once
+)
+
+// rpcPools limits the number of concurrent gRPC calls per RPCClass. Every
+// class has its own pool, so that a queue of slow NodeStageVolume calls does
+// not delay the publishing of volumes that are staged already.
+type rpcPools map[RPCClass]chan struct{}
+
+// NodeRPCConcurrency returns the concurrency of the node RPCClasses from the
+// configuration, for MiddlewareServerOptionConfig.RPCConcurrency.
+func NodeRPCConcurrency(conf *util.Config) map[RPCClass]uint {
+	return map[RPCClass]uint{
+		RPCClassStage:   conf.NodeStageConcurrency,
+		RPCClassPublish: conf.NodePublishConcurrency,
+	}
+}
+
+// newRPCPools returns the pools for the classes with a concurrency, classes
+// without a concurrency (or 0) are not limited.
+func newRPCPools(concurrency map[RPCClass]uint) rpcPools {
+	pools := rpcPools{}
+	for class, n := range concurrency {
+		if n != 0 {
+			pools[class] = make(chan struct{}, n)
+		}
+	}
+
+	if len(pools) != 0 {
+		registerRPCPoolMetricsOnce.Do(func() {
+			err := prometheus.Register(rpcQueued)
+			if err != nil {
+				log.WarningLogMsg("failed to register gRPC queue metrics: %v", err)
+			}
+		})
+	}
+
+	return pools
+}
+
+// intercept runs the handler once a worker of the class of the method is
+// available. A call that is cancelled while it waits returns the error of its
+// context.
+func (p rpcPools) intercept(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	class, ok := rpcClasses[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+	pool, ok := p[class]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	select {
+	case pool <- struct{}{}:
+	default:
+		log.DebugLog(ctx, "waiting for one of the %d %s workers to become available", cap(pool), class)
+		queued := rpcQueued.WithLabelValues(string(class))
+		queued.Inc()
+		select {
+		case pool <- struct{}{}:
+			queued.Dec()
+		case <-ctx.Done():
+			queued.Dec()
+
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	defer func() { <-pool }()
+
+	return handler(ctx, req)
+}
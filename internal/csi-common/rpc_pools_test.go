@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCPools(t *testing.T) {
+	t.Parallel()
+
+	pools := newRPCPools(map[RPCClass]uint{
+		RPCClassStage:   1,
+		RPCClassPublish: 0,
+	})
+	require.Len(t, pools, 1)
+
+	stage := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	publish := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodePublishVolume"}
+	stats := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeGetVolumeStats"}
+	done := func(context.Context, interface{}) (interface{}, error) {
+		return "done", nil
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = pools.intercept(context.TODO(), nil, stage, func(context.Context, interface{}) (interface{}, error) {
+			close(started)
+			<-release
+
+			return nil, nil
+		})
+	}()
+	<-started
+
+	// other classes do not wait for the running stage call
+	resp, err := pools.intercept(context.TODO(), nil, publish, done)
+	require.NoError(t, err)
+	require.Equal(t, "done", resp)
+	resp, err = pools.intercept(context.TODO(), nil, stats, done)
+	require.NoError(t, err)
+	require.Equal(t, "done", resp)
+
+	// a second stage call waits until its context is cancelled
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = pools.intercept(ctx, nil, stage, done)
+	require.Equal(t, codes.Canceled, status.Code(err))
+
+	close(release)
+	resp, err = pools.intercept(context.TODO(), nil, stage, done)
+	require.NoError(t, err)
+	require.Equal(t, "done", resp)
+}
@@ -106,7 +106,14 @@ func (s *nonBlockingGRPCServer) serve(
 		klog.Fatalf("Failed to listen: %v", err)
 	}
 
-	server := grpc.NewServer(NewMiddlewareServerOption(middlewareConfig))
+	opts := []grpc.ServerOption{NewMiddlewareServerOption(middlewareConfig)}
+	if middlewareConfig.MaxMessageSize > 0 {
+		opts = append(opts,
+			grpc.MaxRecvMsgSize(middlewareConfig.MaxMessageSize),
+			grpc.MaxSendMsgSize(middlewareConfig.MaxMessageSize))
+	}
+
+	server := grpc.NewServer(opts...)
 	s.server = server
 
 	if srv.IS != nil {
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// defaultSlowOpThreshold is the key of the threshold for the methods that do
+// not have their own threshold.
+const defaultSlowOpThreshold = "*"
+
+// slowOpThresholds contains the durations after which a completed call of a
+// gRPC method is logged as slow, by the name of the method without service.
+type slowOpThresholds map[string]time.Duration
+
+// ParseSlowOpThresholds parses the thresholds of the slow operation logger,
+// in the format "<method>=<duration>" separated by ",". The method is the
+// name of the gRPC method, like NodeStageVolume, or "*" for all methods that
+// are not listed.
+func ParseSlowOpThresholds(thresholds string) (map[string]time.Duration, error) {
+	parsed := map[string]time.Duration{}
+	if thresholds == "" {
+		return parsed, nil
+	}
+
+	for _, threshold := range strings.Split(thresholds, ",") {
+		method, value, ok := strings.Cut(strings.TrimSpace(threshold), "=")
+		if !ok || method == "" {
+			return nil, fmt.Errorf("invalid slow operation threshold %q, expected <method>=<duration>", threshold)
+		}
+		if _, ok = parsed[method]; ok {
+			return nil, fmt.Errorf("duplicate slow operation threshold for %q", method)
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slow operation threshold for %q: %w", method, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("slow operation threshold for %q needs to be positive", method)
+		}
+		parsed[method] = d
+	}
+
+	return parsed, nil
+}
+
+// SlowOpThresholds returns the thresholds of the slow operation logger from
+// the configuration, for MiddlewareServerOptionConfig.SlowOpThresholds. The
+// configuration is validated on startup, invalid thresholds disable the
+// logger.
+func SlowOpThresholds(conf *util.Config) map[string]time.Duration {
+	thresholds, err := ParseSlowOpThresholds(conf.SlowOpThresholds)
+	if err != nil {
+		log.WarningLogMsg("slow operation logging is disabled: %v", err)
+
+		return nil
+	}
+
+	return thresholds
+}
+
+// threshold returns the threshold of the gRPC method, and false when calls of
+// the method are not logged.
+func (t slowOpThresholds) threshold(fullMethod string) (time.Duration, bool) {
+	d, ok := t[path.Base(fullMethod)]
+	if !ok {
+		d, ok = t[defaultSlowOpThreshold]
+	}
+
+	return d, ok
+}
+
+// intercept logs the calls that took longer than the threshold of their
+// method once they completed, with the volume, snapshot or group of the
+// request, so that slow pools or MDS issues can be spotted without debug
+// logging. Calls that outlive their context are logged by logSlowGRPC while
+// they are still running.
+func (t slowOpThresholds) intercept(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	threshold, ok := t.threshold(info.FullMethod)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if elapsed := time.Since(start); elapsed > threshold {
+		log.WarningLog(ctx, "slow GRPC call %s for %q took %s (threshold %s), result %s",
+			info.FullMethod, getReqID(req), elapsed.Truncate(time.Millisecond), threshold, status.Code(err))
+	}
+
+	return resp, err
+}
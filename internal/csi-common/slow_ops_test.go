@@ -0,0 +1,81 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseSlowOpThresholds(t *testing.T) {
+	t.Parallel()
+
+	thresholds, err := ParseSlowOpThresholds("")
+	require.NoError(t, err)
+	require.Empty(t, thresholds)
+
+	thresholds, err = ParseSlowOpThresholds("NodeStageVolume=10s, *=1m")
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Duration{
+		"NodeStageVolume": 10 * time.Second,
+		"*":               time.Minute,
+	}, thresholds)
+
+	for _, invalid := range []string{
+		"NodeStageVolume",
+		"=10s",
+		"NodeStageVolume=soon",
+		"NodeStageVolume=0s",
+		"NodeStageVolume=10s,NodeStageVolume=1m",
+	} {
+		_, err = ParseSlowOpThresholds(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestSlowOpThresholds(t *testing.T) {
+	t.Parallel()
+
+	thresholds := slowOpThresholds{"NodeStageVolume": time.Second}
+	d, ok := thresholds.threshold("/csi.v1.Node/NodeStageVolume")
+	require.True(t, ok)
+	require.Equal(t, time.Second, d)
+	_, ok = thresholds.threshold("/csi.v1.Node/NodePublishVolume")
+	require.False(t, ok)
+
+	thresholds["*"] = time.Minute
+	d, ok = thresholds.threshold("/csi.v1.Node/NodePublishVolume")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, d)
+
+	// the response and error of the handler are returned as is
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	handler := func(context.Context, interface{}) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+
+		return "done", status.Error(codes.Aborted, "busy")
+	}
+	resp, err := slowOpThresholds{"*": time.Millisecond}.intercept(context.TODO(), nil, info, handler)
+	require.Equal(t, "done", resp)
+	require.Equal(t, codes.Aborted, status.Code(err))
+}
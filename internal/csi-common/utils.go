@@ -22,6 +22,7 @@ import (
 	"os"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -32,6 +33,7 @@ import (
 	"github.com/csi-addons/spec/lib/go/replication"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -63,10 +65,16 @@ func NewDefaultNodeServer(
 ) *DefaultNodeServer {
 	d.topology = topology
 
+	mounter := mount.NewWithoutSystemd("")
+	if util.MountsViaSystemd() {
+		// mount-utils runs mount(8) with "systemd-run --scope"
+		mounter = mount.New("")
+	}
+
 	return &DefaultNodeServer{
 		Driver:                 d,
 		Type:                   t,
-		Mounter:                mount.NewWithoutSystemd(""),
+		Mounter:                mounter,
 		NodeLabels:             nodeLabels,
 		CLIReadAffinityOptions: cliReadAffinityMapOptions,
 	}
@@ -114,6 +122,21 @@ func NewGroupControllerServiceCapability(ctrlCap csi.GroupControllerServiceCapab
 // are instantiated when starting gRPC servers.
 type MiddlewareServerOptionConfig struct {
 	LogSlowOpInterval time.Duration
+	// MaintenanceModeFile is checked for every mutating request, which are
+	// rejected when it enables maintenance mode.
+	MaintenanceModeFile string
+	// RPCConcurrency is the number of calls of an RPCClass that are
+	// processed at a time, the other calls of the class wait in a queue.
+	RPCConcurrency map[RPCClass]uint
+	// MaxMessageSize is the maximum size of the gRPC messages that are
+	// sent and received, 0 keeps the defaults of gRPC.
+	MaxMessageSize int
+	// SlowOpThresholds are the durations per gRPC method after which a
+	// completed call is logged as slow, see ParseSlowOpThresholds.
+	SlowOpThresholds map[string]time.Duration
+	// InstanceID is added to the context of the calls, for drivers that
+	// serve multiple instances in one process, see util.WithInstanceID.
+	InstanceID string
 }
 
 // NewMiddlewareServerOption creates a new grpc.ServerOption that configures a
@@ -124,6 +147,17 @@ func NewMiddlewareServerOption(config MiddlewareServerOptionConfig) grpc.ServerO
 		logGRPC,
 	}
 
+	if config.InstanceID != "" {
+		middleWare = append(middleWare, func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			return handler(util.WithInstanceID(ctx, config.InstanceID), req)
+		})
+	}
+
 	if config.LogSlowOpInterval > 0 {
 		middleWare = append(middleWare, func(
 			ctx context.Context,
@@ -137,6 +171,29 @@ func NewMiddlewareServerOption(config MiddlewareServerOptionConfig) grpc.ServerO
 		})
 	}
 
+	if config.MaintenanceModeFile != "" {
+		middleWare = append(middleWare, func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			return maintenanceModeGuard(
+				config.MaintenanceModeFile, ctx, req, info, handler,
+			)
+		})
+	}
+
+	if pools := newRPCPools(config.RPCConcurrency); len(pools) != 0 {
+		middleWare = append(middleWare, pools.intercept)
+	}
+
+	// the time a call waits for a worker of its pool is not counted
+	if len(config.SlowOpThresholds) != 0 {
+		middleWare = append(middleWare, slowOpThresholds(config.SlowOpThresholds).intercept)
+	}
+
+	registerPanicMetrics()
 	middleWare = append(middleWare, panicHandler)
 
 	return grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(middleWare...))
@@ -323,6 +380,31 @@ func logSlowGRPC(
 	return resp, err
 }
 
+var (
+	grpcPanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "csi",
+		Subsystem: "grpc",
+		Name:      "panics_total",
+		Help:      "Number of gRPC calls that panicked",
+	}, []string{"method"})
+	registerPanicMetricsOnce sync.Once
+)
+
+// registerPanicMetrics registers the panic metrics with prometheus, once for
+// all gRPC servers of the process.
+func registerPanicMetrics() {
+	registerPanicMetricsOnce.Do(func() {
+		err := prometheus.Register(grpcPanicsTotal)
+		if err != nil {
+			log.WarningLogMsg("failed to register gRPC panic metrics: %v", err)
+		}
+	})
+}
+
+// panicHandler recovers from a panic in the handler of a gRPC call, so that
+// only the failing call returns an Internal error, and the other calls of the
+// plugin continue. The stack trace is logged with the ID of the request.
+//
 //nolint:nonamedreturns // named return used to send recovered panic error.
 func panicHandler(
 	ctx context.Context,
@@ -332,8 +414,9 @@ func panicHandler(
 ) (resp interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			klog.Errorf("panic occurred: %v", r)
-			debug.PrintStack()
+			grpcPanicsTotal.WithLabelValues(info.FullMethod).Inc()
+			log.ErrorLog(ctx, "panic occurred in GRPC call %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			log.ErrorLog(ctx, "GRPC request that panicked: %s", protosanitizer.StripSecrets(req))
 			err = status.Errorf(codes.Internal, "panic %v", r)
 		}
 	}()
@@ -23,9 +23,14 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ceph/ceph-csi/internal/util"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/csi-addons/spec/lib/go/replication"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	mount "k8s.io/mount-utils"
 )
 
@@ -738,3 +743,125 @@ func TestIsReaderOnly(t *testing.T) {
 		})
 	}
 }
+
+func TestConstructBindMountOptions(t *testing.T) {
+	t.Parallel()
+
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{
+					"noatime",
+					`context="system_u:object_r:container_file_t:s0:c0,c1"`,
+					"_netdev",
+				},
+			},
+		},
+	}
+
+	require.Equal(t,
+		[]string{"bind", "_netdev", "noatime"},
+		ConstructBindMountOptions([]string{"bind", "_netdev"}, volCap))
+	require.Equal(t,
+		[]string{"_netdev", "noatime", `context="system_u:object_r:container_file_t:s0:c0,c1"`},
+		ConstructMountOptions([]string{"_netdev"}, volCap))
+}
+
+func TestMaintenanceModeGuard(t *testing.T) {
+	t.Parallel()
+
+	modeFile := filepath.Join(t.TempDir(), "maintenance-mode")
+	require.NoError(t, os.WriteFile(modeFile, []byte("true"), 0o600))
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	_, err := maintenanceModeGuard(modeFile, context.TODO(), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}, handler)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	resp, err := maintenanceModeGuard(modeFile, context.TODO(), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/ListVolumes"}, handler)
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+
+	// volumes can be detached from nodes that are drained
+	for _, method := range []string{
+		"/csi.v1.Node/NodeStageVolume",
+		"/csi.v1.Node/NodeUnpublishVolume",
+		"/csi.v1.Node/NodeUnstageVolume",
+		"/csi.v1.Controller/ControllerUnpublishVolume",
+	} {
+		resp, err = maintenanceModeGuard(modeFile, context.TODO(), nil,
+			&grpc.UnaryServerInfo{FullMethod: method}, handler)
+		require.NoError(t, err, method)
+		require.Equal(t, "ok", resp)
+	}
+
+	require.NoError(t, os.WriteFile(modeFile, []byte("false"), 0o600))
+	resp, err = maintenanceModeGuard(modeFile, context.TODO(), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}, handler)
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+}
+
+func TestMaintenanceModeGuardCluster(t *testing.T) {
+	t.Parallel()
+
+	modeFile := filepath.Join(t.TempDir(), "maintenance-mode")
+	require.NoError(t, os.WriteFile(modeFile, []byte("cluster-1"), 0o600))
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	volumeID := func(clusterID string) string {
+		id, err := util.CSIIdentifier{
+			LocationID: 1,
+			ClusterID:  clusterID,
+			ObjectUUID: "00000000-1111-2222-bbbb-cacacacacaca",
+		}.ComposeCSIID()
+		require.NoError(t, err)
+
+		return id
+	}
+	publish := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/ControllerPublishVolume"}
+
+	_, err := maintenanceModeGuard(modeFile, context.TODO(),
+		&csi.ControllerPublishVolumeRequest{VolumeId: volumeID("cluster-1")}, publish, handler)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	resp, err := maintenanceModeGuard(modeFile, context.TODO(),
+		&csi.ControllerPublishVolumeRequest{VolumeId: volumeID("cluster-2")}, publish, handler)
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+
+	resp, err = maintenanceModeGuard(modeFile, context.TODO(),
+		&csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID("cluster-1")},
+		&grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/ControllerUnpublishVolume"}, handler)
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+
+	_, err = maintenanceModeGuard(modeFile, context.TODO(),
+		&csi.CreateVolumeRequest{Parameters: map[string]string{"clusterID": "cluster-1"}},
+		&grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}, handler)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestPanicHandler(t *testing.T) {
+	t.Parallel()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+	_, err := panicHandler(context.TODO(), &csi.NodeStageVolumeRequest{}, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("test")
+		})
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	resp, err := panicHandler(context.TODO(), &csi.NodeStageVolumeRequest{}, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// VolumeStatsFunc returns the stats of a volume.
+type VolumeStatsFunc func(ctx context.Context) (*csi.NodeGetVolumeStatsResponse, error)
+
+// VolumeStatsCache keeps the stats of the volumes on the node, so that the
+// frequent NodeGetVolumeStats calls of the CO do not run statfs on every
+// mount. Stats that are older than the maximum age are returned while they
+// are refreshed in the background. A volume of which the refresh does not
+// complete within the maximum age is reported as abnormal, the same way a
+// statfs that fails would be.
+//
+// A nil VolumeStatsCache does not cache, all methods can be called on it.
+type VolumeStatsCache struct {
+	maxAge time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*volumeStatsEntry
+}
+
+// volumeStatsEntry is the cached response for a volume path.
+type volumeStatsEntry struct {
+	resp    *csi.NodeGetVolumeStatsResponse
+	updated time.Time
+
+	// refreshStarted is set while the stats are refreshed in the
+	// background.
+	refreshStarted *time.Time
+}
+
+// NewVolumeStatsCache returns a VolumeStatsCache that refreshes the stats of
+// a volume once they are older than maxAge. A nil cache is returned when
+// maxAge is 0.
+func NewVolumeStatsCache(maxAge time.Duration) *VolumeStatsCache {
+	if maxAge == 0 {
+		return nil
+	}
+
+	return &VolumeStatsCache{
+		maxAge:  maxAge,
+		entries: map[string]*volumeStatsEntry{},
+	}
+}
+
+// Get returns the stats of the volume on path. The stats are fetched with
+// fetch when they are not cached yet, errors and abnormal volumes are not
+// cached.
+func (c *VolumeStatsCache) Get(
+	ctx context.Context,
+	path string,
+	fetch VolumeStatsFunc,
+) (*csi.NodeGetVolumeStatsResponse, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	now := time.Now()
+	c.mutex.Lock()
+	entry := c.entries[path]
+	if entry != nil {
+		resp := c.cachedResponse(ctx, path, entry, fetch, now)
+		c.mutex.Unlock()
+
+		return resp, nil
+	}
+	c.mutex.Unlock()
+
+	resp, err := fetch(ctx)
+	if err != nil || resp.GetVolumeCondition().GetAbnormal() {
+		return resp, err
+	}
+
+	c.mutex.Lock()
+	if c.entries[path] == nil {
+		c.entries[path] = &volumeStatsEntry{resp: resp, updated: now}
+	}
+	c.mutex.Unlock()
+
+	return resp, nil
+}
+
+// cachedResponse returns the response of the entry, and starts a refresh when
+// it is stale. The mutex of c must be held.
+func (c *VolumeStatsCache) cachedResponse(
+	ctx context.Context,
+	path string,
+	entry *volumeStatsEntry,
+	fetch VolumeStatsFunc,
+	now time.Time,
+) *csi.NodeGetVolumeStatsResponse {
+	switch {
+	case now.Sub(entry.updated) < c.maxAge:
+		return entry.resp
+
+	case entry.refreshStarted == nil:
+		started := now
+		entry.refreshStarted = &started
+		go c.refresh(path, entry, fetch)
+
+		return entry.resp
+
+	case now.Sub(*entry.refreshStarted) < c.maxAge:
+		return entry.resp
+	}
+
+	msg := fmt.Sprintf("stats of %s were not refreshed since %s, the volume may be hung",
+		path, entry.refreshStarted.Format(time.RFC3339))
+	log.WarningLog(ctx, "%s", msg)
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: entry.resp.GetUsage(),
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  msg,
+		},
+	}
+}
+
+// refresh fetches the stats of the entry for path. A failed refresh removes
+// the entry, so that the next Get fetches the stats and returns the error or
+// the abnormal condition of the volume.
+func (c *VolumeStatsCache) refresh(path string, entry *volumeStatsEntry, fetch VolumeStatsFunc) {
+	ctx := context.Background()
+	resp, err := fetch(ctx)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries[path] != entry {
+		// the volume was unpublished while refreshing
+		return
+	}
+
+	if err != nil || resp.GetVolumeCondition().GetAbnormal() {
+		log.DebugLog(ctx, "failed to refresh stats of %s, removing them from the cache: %v", path, err)
+		delete(c.entries, path)
+
+		return
+	}
+
+	entry.resp = resp
+	entry.updated = time.Now()
+	entry.refreshStarted = nil
+}
+
+// Forget removes the stats of the volume on path from the cache.
+func (c *VolumeStatsCache) Forget(path string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	delete(c.entries, path)
+	c.mutex.Unlock()
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVolumeStatsCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	var calls atomic.Int32
+	fetch := func(context.Context) (*csi.NodeGetVolumeStatsResponse, error) {
+		calls.Add(1)
+
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{{Used: int64(calls.Load())}},
+		}, nil
+	}
+
+	// no cache, every call fetches the stats
+	var disabled *VolumeStatsCache
+	_, err := disabled.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	_, err = disabled.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), calls.Load())
+	disabled.Forget("/mnt/a")
+
+	calls.Store(0)
+	c := NewVolumeStatsCache(time.Hour)
+	resp, err := c.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), resp.GetUsage()[0].GetUsed())
+	resp, err = c.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), resp.GetUsage()[0].GetUsed())
+	require.Equal(t, int32(1), calls.Load())
+
+	c.Forget("/mnt/a")
+	resp, err = c.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), resp.GetUsage()[0].GetUsed())
+
+	// errors are not cached
+	failing := func(context.Context) (*csi.NodeGetVolumeStatsResponse, error) {
+		return nil, errors.New("statfs failed")
+	}
+	_, err = c.Get(ctx, "/mnt/b", failing)
+	require.Error(t, err)
+	_, err = c.Get(ctx, "/mnt/b", fetch)
+	require.NoError(t, err)
+}
+
+func TestVolumeStatsCacheRefresh(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	c := NewVolumeStatsCache(time.Hour)
+	c.entries["/mnt/a"] = &volumeStatsEntry{
+		resp: &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{{Used: 1}},
+		},
+		updated: time.Now().Add(-2 * time.Hour),
+	}
+
+	refreshed := make(chan struct{})
+	hung := make(chan struct{})
+	fetch := func(context.Context) (*csi.NodeGetVolumeStatsResponse, error) {
+		close(refreshed)
+		<-hung
+
+		return nil, errors.New("stopped")
+	}
+
+	// stale stats are returned while they are refreshed
+	resp, err := c.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	require.False(t, resp.GetVolumeCondition().GetAbnormal())
+	require.Equal(t, int64(1), resp.GetUsage()[0].GetUsed())
+	<-refreshed
+
+	// a refresh that does not complete marks the volume abnormal
+	c.mutex.Lock()
+	started := time.Now().Add(-2 * time.Hour)
+	c.entries["/mnt/a"].refreshStarted = &started
+	c.mutex.Unlock()
+	resp, err = c.Get(ctx, "/mnt/a", fetch)
+	require.NoError(t, err)
+	require.True(t, resp.GetVolumeCondition().GetAbnormal())
+	require.Equal(t, int64(1), resp.GetUsage()[0].GetUsed())
+
+	// a failed refresh removes the stats from the cache
+	close(hung)
+	require.Eventually(t, func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		return c.entries["/mnt/a"] == nil
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2024 ceph-csi authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthchecker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// probePrefix is the prefix of the temporary file that is written by
+// ProbeMount.
+const probePrefix = ".csi-mount-probe-"
+
+// ProbeMount verifies that a freshly mounted filesystem at dir can be used.
+// A file is written, read back and removed, or for readOnly mounts the
+// filesystem is stat'ed and the extended attributes of dir are listed. A
+// filesystem that is full or at its quota is probed like a readOnly mount. An
+// error is returned when the probe fails or does not finish within timeout.
+//
+// A probe that times out may leave a go-routine blocked on the mount, until
+// the filesystem responds or is unmounted.
+func ProbeMount(dir string, readOnly bool, timeout time.Duration) error {
+	probe := probeWriteOrRead
+	if readOnly {
+		probe = probeRead
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- probe(dir)
+	}()
+
+	select {
+	case err := <-result:
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, unix.EROFS) {
+			return fmt.Errorf("%w, check that the credentials of the volume permit %s access to its path",
+				err, accessMode(readOnly))
+		}
+
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("the mount at %q did not respond within %s, check the health of the "+
+			"metadata servers and the network", dir, timeout)
+	}
+}
+
+func accessMode(readOnly bool) string {
+	if readOnly {
+		return "read"
+	}
+
+	return "read-write"
+}
+
+// probeRead stats the filesystem and lists the extended attributes of dir,
+// which needs a response from the server of the filesystem.
+func probeRead(dir string) error {
+	var st unix.Statfs_t
+	err := unix.Statfs(dir, &st)
+	if err != nil {
+		return fmt.Errorf("failed to statfs %q: %w", dir, err)
+	}
+
+	_, err = unix.Listxattr(dir, nil)
+	if err != nil && !errors.Is(err, unix.ENOTSUP) {
+		return fmt.Errorf("failed to list extended attributes of %q: %w", dir, err)
+	}
+
+	return nil
+}
+
+// probeWriteOrRead probes dir with probeWrite. When the filesystem has no
+// space left, or the quota is reached, the filesystem is healthy as far as
+// the write could tell, and dir is probed with probeRead instead.
+func probeWriteOrRead(dir string) error {
+	err := probeWrite(dir)
+	if errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.EDQUOT) {
+		return probeRead(dir)
+	}
+
+	return err
+}
+
+// probeWrite writes a file in dir, reads it back and removes it again.
+func probeWrite(dir string) error {
+	f, err := os.CreateTemp(dir, probePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create probe file in %q: %w", dir, err)
+	}
+	filename := f.Name()
+	defer os.Remove(filename) //nolint:errcheck // cleanup on failure, removed explicitly below
+
+	data := []byte(path.Base(filename))
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	cErr := f.Close()
+	if err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write probe file %q: %w", filename, err)
+	}
+
+	read, err := os.ReadFile(filename) //nolint:gosec // the file was created above
+	if err != nil {
+		return fmt.Errorf("failed to read probe file %q: %w", filename, err)
+	}
+	if !bytes.Equal(data, read) {
+		return fmt.Errorf("probe file %q does not contain what was written", filename)
+	}
+
+	err = os.Remove(filename)
+	if err != nil {
+		return fmt.Errorf("failed to remove probe file %q: %w", filename, err)
+	}
+
+	return nil
+}
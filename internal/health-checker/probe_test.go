@@ -0,0 +1,57 @@
+/*
+Copyright 2024 ceph-csi authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthchecker
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestProbeMount(t *testing.T) {
+	t.Parallel()
+
+	volumePath := t.TempDir()
+
+	err := ProbeMount(volumePath, false, time.Minute)
+	if err != nil {
+		t.Fatalf("read-write probe failed: %v", err)
+	}
+
+	// the probe file should have been removed
+	entries, err := os.ReadDir(volumePath)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", volumePath, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("probe left %d files behind", len(entries))
+	}
+
+	err = ProbeMount(volumePath, true, time.Minute)
+	if err != nil {
+		t.Fatalf("read-only probe failed: %v", err)
+	}
+
+	missing := path.Join(volumePath, "missing")
+	if ProbeMount(missing, false, time.Minute) == nil {
+		t.Error("read-write probe of a missing directory succeeded")
+	}
+	if ProbeMount(missing, true, time.Minute) == nil {
+		t.Error("read-only probe of a missing directory succeeded")
+	}
+}
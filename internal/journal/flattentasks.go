@@ -0,0 +1,100 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+/*
+The flatten tasks that were added to the Ceph manager for images in a pool are
+tracked in the omap csiDirectory+".flatten", like
+"csi.volumes.default.flatten". Every key is the name of an image, the value is
+a JSON encoded FlattenTask. The entry is removed once the image has no parent
+anymore.
+*/
+
+// FlattenTask is a flatten task of the Ceph manager for an image.
+type FlattenTask struct {
+	// ID is the id of the task in the Ceph manager.
+	ID string `json:"id"`
+	// StartTime is the time the task was first seen.
+	StartTime time.Time `json:"startTime"`
+}
+
+// flattenTasksOid returns the name of the omap that tracks the flatten
+// tasks.
+func (conn *Connection) flattenTasksOid() string {
+	return conn.config.csiDirectory + ".flatten"
+}
+
+// SetFlattenTask records the flatten task of the image.
+func (conn *Connection) SetFlattenTask(ctx context.Context, pool, imageName string, task *FlattenTask) error {
+	value, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal flatten task of %q: %w", imageName, err)
+	}
+
+	err = setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.flattenTasksOid(),
+		map[string]string{imageName: string(value)})
+	if err != nil {
+		return fmt.Errorf("failed to store flatten task of %q: %w", imageName, err)
+	}
+
+	return nil
+}
+
+// GetFlattenTask returns the flatten task of the image, or nil when no task
+// is recorded for it.
+func (conn *Connection) GetFlattenTask(ctx context.Context, pool, imageName string) (*FlattenTask, error) {
+	values, err := getOMapValues(ctx, conn, pool, conn.config.namespace, conn.flattenTasksOid(),
+		[]string{imageName})
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get flatten task of %q: %w", imageName, err)
+	}
+
+	value, ok := values[imageName]
+	if !ok {
+		return nil, nil
+	}
+
+	task := &FlattenTask{}
+	err = json.Unmarshal([]byte(value), task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse flatten task of %q: %w", imageName, err)
+	}
+
+	return task, nil
+}
+
+// RemoveFlattenTask removes the flatten task of the image.
+func (conn *Connection) RemoveFlattenTask(ctx context.Context, pool, imageName string) error {
+	err := removeMapKeys(ctx, conn, pool, conn.config.namespace, conn.flattenTasksOid(), []string{imageName})
+	if err != nil {
+		return fmt.Errorf("failed to remove flatten task of %q: %w", imageName, err)
+	}
+
+	return nil
+}
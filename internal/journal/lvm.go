@@ -0,0 +1,150 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+/*
+The backing images of the volumes with the lvm layout are tracked in the omap
+csiDirectory+".lvm", like "csi.volumes.default.lvm", in the pool of the
+images. Every key is the name of a backing image, the value is its size in
+bytes.
+
+The logical volumes that are carved from a backing image are tracked in an
+omap per backing image, named csiDirectory+".lvm."+[backing image name], like
+"csi.volumes.default.lvm.csi-lvm-<uuid>". Every key is the name of a logical
+volume, which is the image name of the volume in its reservation, the value is
+the size of the logical volume in bytes. The nodes create the logical volumes
+from these allocations, and remove the logical volumes that are not allocated
+anymore.
+*/
+
+// lvmBackingImagesOid returns the name of the omap that tracks the backing
+// images of the volumes with the lvm layout.
+func (conn *Connection) lvmBackingImagesOid() string {
+	return conn.config.csiDirectory + ".lvm"
+}
+
+// lvmAllocationsOid returns the name of the omap that tracks the logical
+// volumes of the backing image.
+func (conn *Connection) lvmAllocationsOid(backingImage string) string {
+	return conn.config.csiDirectory + ".lvm." + backingImage
+}
+
+// AddLVMBackingImage records the backing image with its size, after the
+// image was created.
+func (conn *Connection) AddLVMBackingImage(ctx context.Context, pool, backingImage string, size int64) error {
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.lvmBackingImagesOid(),
+		map[string]string{backingImage: strconv.FormatInt(size, 10)})
+	if err != nil {
+		return fmt.Errorf("failed to add backing image %q: %w", backingImage, err)
+	}
+
+	return nil
+}
+
+// RemoveLVMBackingImage removes the backing image and the tracking of its
+// logical volumes, after the image was deleted.
+func (conn *Connection) RemoveLVMBackingImage(ctx context.Context, pool, backingImage string) error {
+	err := util.RemoveObject(ctx, conn.monitors, conn.cr, pool, conn.config.namespace,
+		conn.lvmAllocationsOid(backingImage))
+	if err != nil && !errors.Is(err, util.ErrObjectNotFound) {
+		return fmt.Errorf("failed to remove logical volumes of %q: %w", backingImage, err)
+	}
+
+	err = removeMapKeys(ctx, conn, pool, conn.config.namespace, conn.lvmBackingImagesOid(), []string{backingImage})
+	if err != nil {
+		return fmt.Errorf("failed to remove backing image %q: %w", backingImage, err)
+	}
+
+	return nil
+}
+
+// ListLVMBackingImages returns the sizes of the backing images in the pool,
+// indexed by their name.
+func (conn *Connection) ListLVMBackingImages(ctx context.Context, pool string) (map[string]int64, error) {
+	values, err := listOMapValues(ctx, conn, pool, conn.config.namespace, conn.lvmBackingImagesOid(), "")
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return map[string]int64{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list backing images: %w", err)
+	}
+
+	return parseLVMSizes(values)
+}
+
+// AddLVMAllocation records the logical volume with its size as carved from
+// the backing image.
+func (conn *Connection) AddLVMAllocation(
+	ctx context.Context,
+	pool, backingImage, logicalVolume string,
+	size int64,
+) error {
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.lvmAllocationsOid(backingImage),
+		map[string]string{logicalVolume: strconv.FormatInt(size, 10)})
+	if err != nil {
+		return fmt.Errorf("failed to add logical volume %q of %q: %w", logicalVolume, backingImage, err)
+	}
+
+	return nil
+}
+
+// RemoveLVMAllocation removes the logical volume from the allocations of the
+// backing image.
+func (conn *Connection) RemoveLVMAllocation(ctx context.Context, pool, backingImage, logicalVolume string) error {
+	err := removeMapKeys(ctx, conn, pool, conn.config.namespace, conn.lvmAllocationsOid(backingImage),
+		[]string{logicalVolume})
+	if err != nil {
+		return fmt.Errorf("failed to remove logical volume %q of %q: %w", logicalVolume, backingImage, err)
+	}
+
+	return nil
+}
+
+// ListLVMAllocations returns the sizes of the logical volumes of the backing
+// image, indexed by their name.
+func (conn *Connection) ListLVMAllocations(ctx context.Context, pool, backingImage string) (map[string]int64, error) {
+	values, err := listOMapValues(ctx, conn, pool, conn.config.namespace, conn.lvmAllocationsOid(backingImage), "")
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return map[string]int64{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list logical volumes of %q: %w", backingImage, err)
+	}
+
+	return parseLVMSizes(values)
+}
+
+// parseLVMSizes converts the sizes in the values of an lvm omap to bytes.
+func parseLVMSizes(values map[string]string) (map[string]int64, error) {
+	sizes := make(map[string]int64, len(values))
+	for name, value := range values {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse size %q of %q: %w", value, name, err)
+		}
+		sizes[name] = size
+	}
+
+	return sizes, nil
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
 
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
@@ -27,54 +29,40 @@ import (
 	"github.com/ceph/go-ceph/rados"
 )
 
+// ErrObjectModified is returned when an omap update was rejected because the
+// object was modified since the version that the update was based on.
+var ErrObjectModified = errors.New("object was modified concurrently")
+
 // chunkSize is the number of key-value pairs that will be fetched in
 // one call. This is set fairly large to avoid calling into ceph APIs
 // over and over.
 const chunkSize int64 = 512
 
+// batchConcurrency is the number of objects that are read at the same time
+// when the omaps of many objects are fetched.
+const batchConcurrency = 16
+
+// getOMapValues fetches the values of the keys from the omap of the object
+// in a single read operation. Keys that do not exist are not returned.
 func getOMapValues(
 	ctx context.Context,
 	conn *Connection,
-	poolName, namespace, oid, prefix string, keys []string,
+	poolName, namespace, oid string, keys []string,
 ) (map[string]string, error) {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	// fetch and configure the rados objects
+	objects, err := conn.conn.GetRadosObjects(poolName)
 	if err != nil {
 		return nil, omapPoolError(err)
 	}
-	defer ioctx.Destroy()
+	defer objects.Destroy()
 
 	if namespace != "" {
-		ioctx.SetNamespace(namespace)
-	}
-
-	results := map[string]string{}
-	// want is our "lookup map" that ensures O(1) checks for keys
-	// while iterating, without needing to complicate the caller.
-	want := make(map[string]bool, len(keys))
-	for i := range keys {
-		want[keys[i]] = true
-	}
-	numKeys := uint64(0)
-	startAfter := ""
-	for {
-		prevNumKeys := numKeys
-		err = ioctx.ListOmapValues(
-			oid, startAfter, prefix, chunkSize,
-			func(key string, value []byte) {
-				numKeys++
-				startAfter = key
-				if want[key] {
-					results[key] = string(value)
-				}
-			},
-		)
-		// if we hit an error, or no new keys were seen, exit the loop
-		if err != nil || numKeys == prevNumKeys {
-			break
-		}
+		objects.SetNamespace(namespace)
 	}
 
+	done := conn.conn.TrackCall("omap_get")
+	results, err := readOMapKeys(objects, oid, keys)
+	done(err)
 	if err != nil {
 		if errors.Is(err, rados.ErrNotFound) {
 			log.ErrorLog(ctx, "omap not found (pool=%q, namespace=%q, name=%q): %v",
@@ -92,23 +80,111 @@ func getOMapValues(
 	return results, nil
 }
 
+// readOMapKeys reads the keys from the omap of the object with one round
+// trip.
+func readOMapKeys(objects util.RadosObjects, oid string, keys []string) (map[string]string, error) {
+	// unset keys of the journal configuration are never stored
+	keys = slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+		return key == ""
+	})
+
+	values, err := objects.GetOmapValuesByKeys(oid, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]string, len(values))
+	for k, v := range values {
+		results[k] = string(v)
+	}
+
+	return results, nil
+}
+
+// getOMapValuesOfObjects fetches the values of the keys from the omaps of
+// all objects. The objects are read concurrently, with at most
+// batchConcurrency operations in flight. Objects that do not exist are not
+// part of the returned map.
+func getOMapValuesOfObjects(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace string,
+	oids, keys []string,
+) (map[string]map[string]string, error) {
+	objects, err := conn.conn.GetRadosObjects(poolName)
+	if err != nil {
+		return nil, omapPoolError(err)
+	}
+	defer objects.Destroy()
+
+	if namespace != "" {
+		objects.SetNamespace(namespace)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		results  = make(map[string]map[string]string, len(oids))
+		sem      = make(chan struct{}, batchConcurrency)
+	)
+	for _, oid := range oids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(oid string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			done := conn.conn.TrackCall("omap_get")
+			values, rErr := readOMapKeys(objects, oid, keys)
+			done(rErr)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(rErr, rados.ErrNotFound):
+			case rErr != nil:
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to read omap of %q: %w", oid, rErr)
+				}
+			default:
+				results[oid] = values
+			}
+		}(oid)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	log.DebugLog(ctx, "got omap values of %d out of %d objects (pool=%q, namespace=%q)",
+		len(results), len(oids), poolName, namespace)
+
+	return results, nil
+}
+
 func removeMapKeys(
 	ctx context.Context,
 	conn *Connection,
 	poolName, namespace, oid string, keys []string,
 ) error {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	// fetch and configure the rados objects
+	objects, err := conn.conn.GetRadosObjects(poolName)
 	if err != nil {
 		return omapPoolError(err)
 	}
-	defer ioctx.Destroy()
+	defer objects.Destroy()
 
 	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+		objects.SetNamespace(namespace)
 	}
 
-	err = ioctx.RmOmapKeys(oid, keys)
+	done := conn.conn.TrackCall("omap_remove")
+	err = objects.RmOmapKeys(oid, keys)
+	done(err)
 	if err != nil {
 		if errors.Is(err, rados.ErrNotFound) {
 			// the previous implementation of removing omap keys (via the cli)
@@ -134,22 +210,24 @@ func setOMapKeys(
 	conn *Connection,
 	poolName, namespace, oid string, pairs map[string]string,
 ) error {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	// fetch and configure the rados objects
+	objects, err := conn.conn.GetRadosObjects(poolName)
 	if err != nil {
 		return omapPoolError(err)
 	}
-	defer ioctx.Destroy()
+	defer objects.Destroy()
 
 	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+		objects.SetNamespace(namespace)
 	}
 
 	bpairs := make(map[string][]byte, len(pairs))
 	for k, v := range pairs {
 		bpairs[k] = []byte(v)
 	}
-	err = ioctx.SetOmap(oid, bpairs)
+	done := conn.conn.TrackCall("omap_set")
+	err = objects.SetOmap(oid, bpairs)
+	done(err)
 	if err != nil {
 		log.ErrorLog(ctx, "failed setting omap keys (pool=%q, namespace=%q, name=%q, pairs=%+v): %v",
 			poolName, namespace, oid, pairs, err)
@@ -162,6 +240,84 @@ func setOMapKeys(
 	return nil
 }
 
+// getObjectVersion returns the version of the object, it is 0 when the object
+// does not exist. Every write to the object increases its version.
+func getObjectVersion(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace, oid string,
+) (uint64, error) {
+	objects, err := conn.conn.GetRadosObjects(poolName)
+	if err != nil {
+		return 0, omapPoolError(err)
+	}
+	defer objects.Destroy()
+
+	if namespace != "" {
+		objects.SetNamespace(namespace)
+	}
+
+	done := conn.conn.TrackCall("object_version")
+	version, err := objects.GetVersion(oid)
+	done(err)
+	if errors.Is(err, rados.ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read version of object (pool=%q, namespace=%q, name=%q): %w",
+			poolName, namespace, oid, err)
+	}
+
+	return version, nil
+}
+
+// updateOMapKeys sets and removes omap keys of the object in a single
+// operation, and returns the new version of the object. When version is not
+// 0, the update is only applied if the object is still at that version,
+// ErrObjectModified is returned otherwise.
+func updateOMapKeys(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace, oid string,
+	version uint64,
+	pairs map[string]string,
+	keys []string,
+) (uint64, error) {
+	objects, err := conn.conn.GetRadosObjects(poolName)
+	if err != nil {
+		return 0, omapPoolError(err)
+	}
+	defer objects.Destroy()
+
+	if namespace != "" {
+		objects.SetNamespace(namespace)
+	}
+
+	bpairs := make(map[string][]byte, len(pairs))
+	for k, v := range pairs {
+		bpairs[k] = []byte(v)
+	}
+
+	done := conn.conn.TrackCall("omap_update")
+	newVersion, err := objects.UpdateOmap(oid, version, bpairs, keys)
+	done(err)
+	if err != nil {
+		if util.IsVersionMismatch(err) {
+			log.DebugLog(ctx, "omap of object (pool=%q, namespace=%q, name=%q) was modified after version %d",
+				poolName, namespace, oid, version)
+
+			return 0, fmt.Errorf("%w: %s/%s", ErrObjectModified, poolName, oid)
+		}
+		log.ErrorLog(ctx, "failed updating omap keys (pool=%q, namespace=%q, name=%q, set=%+v, remove=%+v): %v",
+			poolName, namespace, oid, pairs, keys, err)
+
+		return 0, err
+	}
+	log.DebugLog(ctx, "updated omap keys (pool=%q, namespace=%q, name=%q): set=%+v, remove=%+v",
+		poolName, namespace, oid, pairs, keys)
+
+	return newVersion, nil
+}
+
 func omapPoolError(err error) error {
 	if errors.Is(err, rados.ErrNotFound) {
 		return fmt.Errorf("Failed as %w (internal %w)", util.ErrPoolNotFound, err)
@@ -176,24 +332,25 @@ func listOMapValues(
 	conn *Connection,
 	poolName, namespace, oid, prefix string,
 ) (map[string]string, error) {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	// fetch and configure the rados objects
+	objects, err := conn.conn.GetRadosObjects(poolName)
 	if err != nil {
 		return nil, omapPoolError(err)
 	}
-	defer ioctx.Destroy()
+	defer objects.Destroy()
 
 	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+		objects.SetNamespace(namespace)
 	}
 
 	results := map[string]string{}
 
+	done := conn.conn.TrackCall("omap_list")
 	numKeys := uint64(0)
 	startAfter := ""
 	for {
 		prevNumKeys := numKeys
-		err = ioctx.ListOmapValues(
+		err = objects.ListOmapValues(
 			oid, startAfter, prefix, chunkSize,
 			func(key string, value []byte) {
 				numKeys++
@@ -206,6 +363,7 @@ func listOMapValues(
 			break
 		}
 	}
+	done(err)
 
 	if err != nil {
 		if errors.Is(err, rados.ErrNotFound) {
@@ -223,3 +381,59 @@ func listOMapValues(
 
 	return results, nil
 }
+
+// listOMapValuesPage fetches up to maxEntries omap values that start with
+// prefix, in the order of their keys, beginning after the key startAfter. A
+// maxEntries of 0 fetches all remaining values. The returned keys are sorted.
+func listOMapValuesPage(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace, oid, prefix, startAfter string,
+	maxEntries int64,
+) ([]string, map[string]string, error) {
+	// fetch and configure the rados objects
+	objects, err := conn.conn.GetRadosObjects(poolName)
+	if err != nil {
+		return nil, nil, omapPoolError(err)
+	}
+	defer objects.Destroy()
+
+	if namespace != "" {
+		objects.SetNamespace(namespace)
+	}
+
+	keys := []string{}
+	results := map[string]string{}
+	done := conn.conn.TrackCall("omap_list")
+	for maxEntries == 0 || int64(len(keys)) < maxEntries {
+		fetch := chunkSize
+		if maxEntries != 0 && maxEntries-int64(len(keys)) < fetch {
+			fetch = maxEntries - int64(len(keys))
+		}
+
+		prevNumKeys := len(keys)
+		err = objects.ListOmapValues(
+			oid, startAfter, prefix, fetch,
+			func(key string, value []byte) {
+				keys = append(keys, key)
+				startAfter = key
+				results[key] = string(value)
+			},
+		)
+		// if we hit an error, or no new keys were seen, exit the loop
+		if err != nil || len(keys) == prevNumKeys {
+			break
+		}
+	}
+	done(err)
+
+	if err != nil {
+		if errors.Is(err, rados.ErrNotFound) {
+			return nil, nil, fmt.Errorf("%w: %w", util.ErrKeyNotFound, err)
+		}
+
+		return nil, nil, err
+	}
+
+	return keys, results, nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"maps"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// prefetchKey is the key in a context.Context for the ImageAttributes that
+// were fetched with GetImageAttributesBatch.
+type prefetchKey struct{}
+
+// prefetchEntry identifies the ImageAttributes of a UUID directory, the
+// snapSource flag changes how the attributes are parsed.
+type prefetchEntry struct {
+	pool, namespace, oid string
+	snapSource           bool
+}
+
+// GetImageAttributesBatch fetches the ImageAttributes of many UUID
+// directories in the pool, with concurrent reads that each return all keys
+// of a directory. UUIDs without a directory, or with attributes that can not
+// be parsed, are not part of the returned map; GetImageAttributes reports
+// the problem when they are resolved one by one.
+func (conn *Connection) GetImageAttributesBatch(
+	ctx context.Context,
+	pool string,
+	objectUUIDs []string,
+	snapSource bool,
+) (map[string]*ImageAttributes, error) {
+	cj := conn.config
+
+	if snapSource && cj.cephSnapSourceKey == "" {
+		return nil, errors.New("invalid request, cephSnapSourceKey is nil")
+	}
+
+	oids := make([]string, 0, len(objectUUIDs))
+	for _, objectUUID := range objectUUIDs {
+		oids = append(oids, cj.cephUUIDDirectoryPrefix+objectUUID)
+	}
+
+	values, err := getOMapValuesOfObjects(ctx, conn, pool, cj.namespace, oids, conn.imageAttributeKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]*ImageAttributes, len(values))
+	for _, objectUUID := range objectUUIDs {
+		v, ok := values[cj.cephUUIDDirectoryPrefix+objectUUID]
+		if !ok {
+			continue
+		}
+
+		a, pErr := conn.parseImageAttributes(objectUUID, v, snapSource)
+		if pErr != nil {
+			log.DebugLog(ctx, "not prefetching attributes of %q: %v", objectUUID, pErr)
+
+			continue
+		}
+		attrs[objectUUID] = a
+	}
+
+	return attrs, nil
+}
+
+// WithImageAttributes returns a context in which GetImageAttributes returns
+// the attrs, as returned by GetImageAttributesBatch, instead of reading the
+// journal again. The context should only be used for the duration of a
+// single request, the attributes are not refreshed.
+func (conn *Connection) WithImageAttributes(
+	ctx context.Context,
+	pool string,
+	attrs map[string]*ImageAttributes,
+	snapSource bool,
+) context.Context {
+	prefetched := map[prefetchEntry]*ImageAttributes{}
+	if parent, ok := ctx.Value(prefetchKey{}).(map[prefetchEntry]*ImageAttributes); ok {
+		maps.Copy(prefetched, parent)
+	}
+
+	for objectUUID, a := range attrs {
+		prefetched[conn.prefetchEntry(pool, objectUUID, snapSource)] = a
+	}
+
+	return context.WithValue(ctx, prefetchKey{}, prefetched)
+}
+
+// prefetchedImageAttributes returns a copy of the prefetched ImageAttributes
+// of the UUID directory, or nil when they were not prefetched.
+func (conn *Connection) prefetchedImageAttributes(
+	ctx context.Context,
+	pool, objectUUID string,
+	snapSource bool,
+) *ImageAttributes {
+	prefetched, ok := ctx.Value(prefetchKey{}).(map[prefetchEntry]*ImageAttributes)
+	if !ok {
+		return nil
+	}
+
+	a, ok := prefetched[conn.prefetchEntry(pool, objectUUID, snapSource)]
+	if !ok {
+		return nil
+	}
+
+	attrs := *a
+
+	return &attrs
+}
+
+func (conn *Connection) prefetchEntry(pool, objectUUID string, snapSource bool) prefetchEntry {
+	return prefetchEntry{
+		pool:       pool,
+		namespace:  conn.config.namespace,
+		oid:        conn.config.cephUUIDDirectoryPrefix + objectUUID,
+		snapSource: snapSource,
+	}
+}
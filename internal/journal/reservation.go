@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// ReservationHeartbeatInterval is the interval in which a pending
+	// reservation is refreshed while its volume is being created.
+	ReservationHeartbeatInterval = time.Minute
+
+	// StaleReservationTimeout is the time after which a pending reservation
+	// without a heartbeat is considered to be left behind by a provisioner
+	// that stopped while creating the volume.
+	StaleReservationTimeout = 5 * ReservationHeartbeatInterval
+)
+
+// reservationOwner identifies the provisioner in the pending reservations. The
+// hostname is the name of the pod, it does not change when the container of
+// the provisioner restarts.
+var reservationOwner, _ = os.Hostname()
+
+// RefreshReservation marks the reservation of reservedUUID as pending, and
+// stores the current time as its heartbeat. It is called after ReserveName,
+// and periodically while the volume is created, so that other provisioners
+// can tell an active creation from a stale reservation.
+func (conn *Connection) RefreshReservation(ctx context.Context, pool, reservedUUID string) error {
+	cj := conn.config
+	if cj.reservedAtKey == "" {
+		return nil
+	}
+
+	t, err := time.Now().MarshalText()
+	if err != nil {
+		return err
+	}
+
+	err = setOMapKeys(ctx, conn, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{cj.reservedAtKey: string(t), cj.reservedByKey: reservationOwner})
+	if err != nil {
+		return fmt.Errorf("failed to refresh reservation %q: %w", reservedUUID, err)
+	}
+
+	return nil
+}
+
+// CompleteReservation removes the heartbeat of the reservation of
+// reservedUUID, once its volume was created.
+func (conn *Connection) CompleteReservation(ctx context.Context, pool, reservedUUID string) error {
+	cj := conn.config
+	if cj.reservedAtKey == "" {
+		return nil
+	}
+
+	err := removeMapKeys(ctx, conn, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+reservedUUID,
+		[]string{cj.reservedAtKey, cj.reservedByKey})
+	if err != nil {
+		return fmt.Errorf("failed to complete reservation %q: %w", reservedUUID, err)
+	}
+
+	return nil
+}
+
+// ReservationPending returns true when the creation of the volume of the
+// reservation did not complete yet.
+func (ia *ImageAttributes) ReservationPending() bool {
+	return ia.ReservedAt != nil
+}
+
+// ReservationStale returns true when the reservation is pending, and its
+// heartbeat is older than StaleReservationTimeout.
+func (ia *ImageAttributes) ReservationStale() bool {
+	return ia.ReservationPending() && time.Since(*ia.ReservedAt) > StaleReservationTimeout
+}
+
+// ReservationOwned returns true when the reservation is pending, and its
+// heartbeat is kept by this provisioner. The requests of a volume are
+// serialized by the provisioner, so the creation of the volume is not in
+// progress anymore, the process that reserved it stopped or failed.
+func (ia *ImageAttributes) ReservationOwned() bool {
+	return ia.ReservationPending() && reservationOwner != "" && ia.ReservedBy == reservationOwner
+}
+
+// parseReservedAt returns the time of the heartbeat in value, or nil when
+// there is no (valid) heartbeat.
+func parseReservedAt(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	t := &time.Time{}
+	err := t.UnmarshalText([]byte(value))
+	if err != nil {
+		return nil
+	}
+
+	return t
+}
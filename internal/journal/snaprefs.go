@@ -0,0 +1,119 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+/*
+The snapshots of a source volume are tracked in an omap per source, named
+csiDirectory+"."+[source image or subvolume name], like
+"csi.snaps.default.csi-vol-<uuid>". Every key is the uuid of a snapshot, the
+value is the CO generated name of the snapshot. The omap is only used to count
+the snapshots of a volume, the snapshot reservations remain the authoritative
+record. Snapshots that were created before they were tracked are added to the
+omap when the snapshots of the volume are counted.
+*/
+
+// snapshotRefsOid returns the name of the omap that tracks the snapshots of
+// the source volume.
+func (conn *Connection) snapshotRefsOid(sourceName string) string {
+	return conn.config.csiDirectory + "." + sourceName
+}
+
+// AddSnapshotRef records the snapshot with snapUUID as a snapshot of the
+// source volume.
+func (conn *Connection) AddSnapshotRef(ctx context.Context, pool, sourceName, snapUUID, reqName string) error {
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.snapshotRefsOid(sourceName),
+		map[string]string{snapUUID: reqName})
+	if err != nil {
+		return fmt.Errorf("failed to add snapshot %q of %q: %w", snapUUID, sourceName, err)
+	}
+
+	return nil
+}
+
+// AddSnapshotRefs records the snapshots in refs, indexed by their uuid, as
+// snapshots of the source volume.
+func (conn *Connection) AddSnapshotRefs(ctx context.Context, pool, sourceName string, refs map[string]string) error {
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.snapshotRefsOid(sourceName), refs)
+	if err != nil {
+		return fmt.Errorf("failed to add %d snapshots of %q: %w", len(refs), sourceName, err)
+	}
+
+	return nil
+}
+
+// RemoveSnapshotRef removes the snapshot with snapUUID from the snapshots of
+// the source volume.
+func (conn *Connection) RemoveSnapshotRef(ctx context.Context, pool, sourceName, snapUUID string) error {
+	err := removeMapKeys(ctx, conn, pool, conn.config.namespace, conn.snapshotRefsOid(sourceName),
+		[]string{snapUUID})
+	if err != nil {
+		return fmt.Errorf("failed to remove snapshot %q of %q: %w", snapUUID, sourceName, err)
+	}
+
+	return nil
+}
+
+// ListSnapshotRefs returns the snapshots that are recorded for the source
+// volume, indexed by their uuid.
+func (conn *Connection) ListSnapshotRefs(ctx context.Context, pool, sourceName string) (map[string]string, error) {
+	refs, err := listOMapValues(ctx, conn, pool, conn.config.namespace, conn.snapshotRefsOid(sourceName), "")
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %q: %w", sourceName, err)
+	}
+
+	return refs, nil
+}
+
+// MoveSnapshotRefs moves the snapshots that are recorded for the source
+// volume oldName to newName, after the source volume was renamed.
+func (conn *Connection) MoveSnapshotRefs(ctx context.Context, pool, oldName, newName string) error {
+	refs, err := conn.ListSnapshotRefs(ctx, pool, oldName)
+	if err != nil {
+		return err
+	}
+
+	if len(refs) != 0 {
+		err = setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.snapshotRefsOid(newName), refs)
+		if err != nil {
+			return fmt.Errorf("failed to move snapshots of %q to %q: %w", oldName, newName, err)
+		}
+	}
+
+	return conn.PurgeSnapshotRefs(ctx, pool, oldName)
+}
+
+// PurgeSnapshotRefs removes the tracking of the snapshots of a source volume
+// that is deleted.
+func (conn *Connection) PurgeSnapshotRefs(ctx context.Context, pool, sourceName string) error {
+	err := util.RemoveObject(ctx, conn.monitors, conn.cr, pool, conn.config.namespace,
+		conn.snapshotRefsOid(sourceName))
+	if err != nil && !errors.Is(err, util.ErrObjectNotFound) {
+		return fmt.Errorf("failed to remove snapshots of %q: %w", sourceName, err)
+	}
+
+	return nil
+}
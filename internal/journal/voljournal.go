@@ -23,6 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
@@ -89,6 +90,12 @@ name in the csiImageKey
 - Finally, the volume is created (or promoted from a snapshot, if content source was provided),
 using the uuid and a corresponding name prefix (namingPrefix) as the volume name
 
+- While the volume is created, the CephUUIDDirectory can hold a heartbeat (see RefreshReservation),
+which is removed once the volume is created. A reservation with a heartbeat older than
+StaleReservationTimeout was left behind by a stopped provisioner, and is adopted by the next request.
+A reservation of the same provisioner (see ReservationOwned) is adopted right away, the previous
+process stopped while creating the volume
+
 The entire operation is locked based on VolName hash, to ensure there is only ever a single entity
 modifying the related omaps for a given VolName.
 
@@ -162,6 +169,17 @@ type Config struct {
 	// backingSnapshotIDKey ID of the snapshot on which the CephFS snapshot-backed volume is based
 	backingSnapshotIDKey string
 
+	// reservedAtKey holds the last heartbeat of a reservation for which the volume is still being
+	// created (see RefreshReservation), the key is removed once the creation completed
+	reservedAtKey string
+
+	// reservedByKey holds the provisioner that keeps the heartbeat of a pending reservation
+	reservedByKey string
+
+	// lvmBackingImageKey holds the image that the logical volume of a volume with the lvm layout
+	// is carved from
+	lvmBackingImageKey string
+
 	// commonPrefix is the prefix common to all omap keys for this Config
 	commonPrefix string
 }
@@ -183,6 +201,9 @@ func NewCSIVolumeJournal(suffix string) *Config {
 		encryptionType:          "csi.volume.encryptionType",
 		ownerKey:                "csi.volume.owner",
 		backingSnapshotIDKey:    "csi.volume.backingsnapshotid",
+		reservedAtKey:           "csi.volume.reservedat",
+		reservedByKey:           "csi.volume.reservedby",
+		lvmBackingImageKey:      "csi.volume.lvmbackingimage",
 		commonPrefix:            "csi.",
 	}
 }
@@ -258,14 +279,17 @@ type Connection struct {
 }
 
 // Connect establishes a new connection to a ceph cluster for journal metadata.
+// The connection uses its own copy of the Config, so that connections to
+// different namespaces can be used at the same time.
 func (cj *Config) Connect(monitors, namespace string, cr *util.Credentials) (*Connection, error) {
-	cj.namespace = namespace
+	config := *cj
+	config.namespace = namespace
 	cc := &util.ClusterConnection{}
 	if err := cc.Connect(monitors, cr); err != nil {
 		return nil, fmt.Errorf("failed to establish the connection: %w", err)
 	}
 	conn := &Connection{
-		config:   cj,
+		config:   &config,
 		monitors: monitors,
 		cr:       cr,
 		conn:     cc,
@@ -315,8 +339,7 @@ func (conn *Connection) CheckReservation(ctx context.Context,
 		cj.csiNameKeyPrefix + reqName,
 	}
 	values, err := getOMapValues(
-		ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
-		cj.commonPrefix, fetchKeys)
+		ctx, conn, journalPool, cj.namespace, cj.csiDirectory, fetchKeys)
 	if err != nil {
 		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
 			// pool or omap (oid) was not present
@@ -426,6 +449,165 @@ func (conn *Connection) CheckReservation(ctx context.Context,
 	return imageData, nil
 }
 
+// Reservation is a request name that is reserved in the CSI directory of a
+// journal pool.
+type Reservation struct {
+	RequestName string
+	ImageUUID   string
+	// ImagePoolID is util.InvalidPoolID when the image is in the journal
+	// pool.
+	ImagePoolID int64
+}
+
+// ListReservations returns up to maxEntries reservations from the CSI
+// directory in journalPool, ordered by request name. The listing starts after
+// the request name in startAfter, an empty startAfter lists from the
+// beginning. A maxEntries of 0 lists all reservations. When the pool or the
+// CSI directory does not exist, no reservations are returned.
+func (conn *Connection) ListReservations(
+	ctx context.Context,
+	journalPool, startAfter string,
+	maxEntries int64,
+) ([]Reservation, error) {
+	cj := conn.config
+
+	after := ""
+	if startAfter != "" {
+		after = cj.csiNameKeyPrefix + startAfter
+	}
+	keys, values, err := listOMapValuesPage(
+		ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
+		cj.csiNameKeyPrefix, after, maxEntries)
+	if err != nil {
+		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	reservations := make([]Reservation, 0, len(keys))
+	for _, key := range keys {
+		r, pErr := parseReservation(strings.TrimPrefix(key, cj.csiNameKeyPrefix), values[key])
+		if errors.Is(pErr, errInvalidReservation) {
+			log.WarningLog(ctx, "skipping reservation %q: %v", key, pErr)
+
+			continue
+		} else if pErr != nil {
+			return nil, pErr
+		}
+
+		reservations = append(reservations, *r)
+	}
+
+	return reservations, nil
+}
+
+// errInvalidReservation is returned when the value of a request name key in
+// the CSI directory can not be parsed.
+var errInvalidReservation = errors.New("invalid reservation")
+
+// parseReservation parses the value of the request name key of reqName in the
+// CSI directory. The value is either the UUID, or the poolID/UUID of the
+// image.
+func parseReservation(reqName, objUUIDAndPool string) (*Reservation, error) {
+	r := &Reservation{
+		RequestName: reqName,
+		ImagePoolID: util.InvalidPoolID,
+	}
+
+	if len(objUUIDAndPool) == uuidEncodedLength {
+		r.ImageUUID = objUUIDAndPool
+
+		return r, nil
+	}
+
+	components := strings.Split(objUUIDAndPool, "/")
+	if len(components) != 2 {
+		return nil, fmt.Errorf("%w: value %q", errInvalidReservation, objUUIDAndPool)
+	}
+
+	buf64, err := hex.DecodeString(components[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode string: %w", err)
+	}
+	r.ImagePoolID = int64(binary.BigEndian.Uint64(buf64))
+	r.ImageUUID = components[1]
+
+	return r, nil
+}
+
+// reservationValue returns the value of the request name key in the CSI
+// directory, that points to the image with volUUID. The pool of the image is
+// only stored when it is not the journal pool.
+func reservationValue(journalPool, imagePool string, imagePoolID int64, volUUID string) string {
+	if journalPool == imagePool || imagePoolID == util.InvalidPoolID {
+		return volUUID
+	}
+
+	buf64 := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf64, uint64(imagePoolID))
+
+	return hex.EncodeToString(buf64) + "/" + volUUID
+}
+
+// GetReservation returns the reservation of reqName in the CSI directory in
+// journalPool. util.ErrKeyNotFound is returned when reqName is not reserved.
+func (conn *Connection) GetReservation(ctx context.Context, journalPool, reqName string) (*Reservation, error) {
+	cj := conn.config
+
+	key := cj.csiNameKeyPrefix + reqName
+	values, err := getOMapValues(ctx, conn, journalPool, cj.namespace, cj.csiDirectory, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	value, found := values[key]
+	if !found {
+		return nil, fmt.Errorf("%w: request name %q is not reserved", util.ErrKeyNotFound, reqName)
+	}
+
+	return parseReservation(reqName, value)
+}
+
+// RestoreReservation points the request name key of reqName in the CSI
+// directory in journalPool to the existing UUID directory of volUUID in
+// imagePool. It repairs a reservation of which only the request name key was
+// lost, ReserveName can not be used as the UUID directory exists already.
+//
+// NOTE: As the function manipulates omaps, it should be called with a lock
+// against the request name held.
+func (conn *Connection) RestoreReservation(ctx context.Context,
+	journalPool, imagePool string, imagePoolID int64,
+	reqName, volUUID string,
+) error {
+	cj := conn.config
+
+	return setOMapKeys(ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
+		map[string]string{
+			cj.csiNameKeyPrefix + reqName: reservationValue(journalPool, imagePool, imagePoolID, volUUID),
+		})
+}
+
+// CountReservations returns the number of reservations in the CSI directory
+// in journalPool. When the pool or the CSI directory does not exist, there are
+// no reservations.
+func (conn *Connection) CountReservations(ctx context.Context, journalPool string) (int, error) {
+	cj := conn.config
+
+	keys, _, err := listOMapValuesPage(
+		ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
+		cj.csiNameKeyPrefix, "", 0)
+	if err != nil {
+		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return len(keys), nil
+}
+
 /*
 UndoReservation undoes a reservation, in the reverse order of ReserveName
 - The UUID directory is cleaned up before the VolName key in the csiDirectory is cleaned up
@@ -572,7 +754,6 @@ func (conn *Connection) ReserveName(ctx context.Context,
 	// TODO: Take in-arg as ImageAttributes?
 	var (
 		snapSource bool
-		nameKeyVal string
 		cj         = conn.config
 		err        error
 	)
@@ -606,14 +787,7 @@ func (conn *Connection) ReserveName(ctx context.Context,
 
 	// Create request name (csiNameKey) key in csiDirectory and store the UUID based
 	// volume name and optionally the image pool location into it
-	if journalPool != imagePool && imagePoolID != util.InvalidPoolID {
-		buf64 := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf64, uint64(imagePoolID))
-		poolIDEncodedHex := hex.EncodeToString(buf64)
-		nameKeyVal = poolIDEncodedHex + "/" + volUUID
-	} else {
-		nameKeyVal = volUUID
-	}
+	nameKeyVal := reservationValue(journalPool, imagePool, imagePoolID, volUUID)
 
 	// After generating the UUID Directory omap, we populate the csiDirectory
 	// omap with a key-value entry to map the request to the backend volume:
@@ -695,6 +869,9 @@ type ImageAttributes struct {
 	GroupID           string              // Contains the group id of the image
 	JournalPoolID     int64               // Pool ID of the CSI journal pool, stored in big endian format (on-disk data)
 	BackingSnapshotID string              // ID of the snapshot on which the CephFS snapshot-backed volume is based
+	ReservedAt        *time.Time          // Last heartbeat of a pending reservation, nil once the creation completed
+	ReservedBy        string              // Provisioner that keeps the heartbeat of a pending reservation
+	LVMBackingImage   string              // Image that the logical volume of an lvm layout volume is carved from
 }
 
 // GetImageAttributes fetches all keys and their values, from a UUID directory, returning ImageAttributes structure.
@@ -703,19 +880,34 @@ func (conn *Connection) GetImageAttributes(
 	pool, objectUUID string,
 	snapSource bool,
 ) (*ImageAttributes, error) {
-	var (
-		err             error
-		imageAttributes = &ImageAttributes{}
-		cj              = conn.config
-	)
+	cj := conn.config
 
 	if snapSource && cj.cephSnapSourceKey == "" {
-		err = errors.New("invalid request, cephSnapSourceKey is nil")
+		return nil, errors.New("invalid request, cephSnapSourceKey is nil")
+	}
 
-		return nil, err
+	if attrs := conn.prefetchedImageAttributes(ctx, pool, objectUUID, snapSource); attrs != nil {
+		return attrs, nil
 	}
 
-	fetchKeys := []string{
+	values, err := getOMapValues(
+		ctx, conn, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+objectUUID, conn.imageAttributeKeys())
+	if err != nil {
+		if !errors.Is(err, util.ErrKeyNotFound) && !errors.Is(err, util.ErrPoolNotFound) {
+			return nil, err
+		}
+		log.WarningLog(ctx, "unable to read omap keys: pool or key missing: %v", err)
+	}
+
+	return conn.parseImageAttributes(objectUUID, values, snapSource)
+}
+
+// imageAttributeKeys returns the omap keys of a UUID directory that are
+// parsed into the ImageAttributes.
+func (conn *Connection) imageAttributeKeys() []string {
+	cj := conn.config
+
+	return []string{
 		cj.csiNameKey,
 		cj.csiImageKey,
 		cj.encryptKMSKey,
@@ -726,18 +918,26 @@ func (conn *Connection) GetImageAttributes(
 		cj.ownerKey,
 		cj.backingSnapshotIDKey,
 		cj.csiGroupIDKey,
+		cj.reservedAtKey,
+		cj.reservedByKey,
+		cj.lvmBackingImageKey,
 	}
-	values, err := getOMapValues(
-		ctx, conn, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+objectUUID,
-		cj.commonPrefix, fetchKeys)
-	if err != nil {
-		if !errors.Is(err, util.ErrKeyNotFound) && !errors.Is(err, util.ErrPoolNotFound) {
-			return nil, err
-		}
-		log.WarningLog(ctx, "unable to read omap keys: pool or key missing: %v", err)
-	}
+}
+
+// parseImageAttributes converts the omap values of the UUID directory of
+// objectUUID to ImageAttributes.
+func (conn *Connection) parseImageAttributes(
+	objectUUID string,
+	values map[string]string,
+	snapSource bool,
+) (*ImageAttributes, error) {
+	var (
+		err             error
+		found           bool
+		imageAttributes = &ImageAttributes{}
+		cj              = conn.config
+	)
 
-	var found bool
 	imageAttributes.RequestName = values[cj.csiNameKey]
 	imageAttributes.KmsID = values[cj.encryptKMSKey]
 	imageAttributes.EncryptionType = util.ParseEncryptionType(values[cj.encryptionType])
@@ -745,6 +945,9 @@ func (conn *Connection) GetImageAttributes(
 	imageAttributes.ImageID = values[cj.csiImageIDKey]
 	imageAttributes.BackingSnapshotID = values[cj.backingSnapshotIDKey]
 	imageAttributes.GroupID = values[cj.csiGroupIDKey]
+	imageAttributes.ReservedAt = parseReservedAt(values[cj.reservedAtKey])
+	imageAttributes.ReservedBy = values[cj.reservedByKey]
+	imageAttributes.LVMBackingImage = values[cj.lvmBackingImageKey]
 
 	// image key was added at a later point, so not all volumes will have this
 	// key set when ceph-csi was upgraded
@@ -792,6 +995,50 @@ func (conn *Connection) StoreImageID(ctx context.Context, pool, reservedUUID, im
 	return nil
 }
 
+// StoreImageName stores the name of the image that the reservation refers
+// to, used when the image was renamed.
+func (conn *Connection) StoreImageName(ctx context.Context, pool, reservedUUID, imageName string) error {
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{conn.config.csiImageKey: imageName})
+	if err != nil {
+		return fmt.Errorf("failed to store image name %q of %q: %w", imageName, reservedUUID, err)
+	}
+
+	return nil
+}
+
+// StoreLVMBackingImage stores the image that the logical volume of the volume
+// reservation is carved from.
+func (conn *Connection) StoreLVMBackingImage(ctx context.Context, pool, reservedUUID, backingImage string) error {
+	if conn.config.lvmBackingImageKey == "" {
+		return errors.New("invalid request, lvmBackingImageKey is nil")
+	}
+
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{conn.config.lvmBackingImageKey: backingImage})
+	if err != nil {
+		return fmt.Errorf("failed to store backing image %q of %q: %w", backingImage, reservedUUID, err)
+	}
+
+	return nil
+}
+
+// StoreSnapshotSource stores the name of the source volume of the snapshot
+// reservation, used when the image of the source volume was renamed.
+func (conn *Connection) StoreSnapshotSource(ctx context.Context, pool, reservedUUID, sourceName string) error {
+	if conn.config.cephSnapSourceKey == "" {
+		return errors.New("invalid request, cephSnapSourceKey is nil")
+	}
+
+	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{conn.config.cephSnapSourceKey: sourceName})
+	if err != nil {
+		return fmt.Errorf("failed to store source %q of snapshot %q: %w", sourceName, reservedUUID, err)
+	}
+
+	return nil
+}
+
 // StoreAttribute stores an attribute (key/value) in omap.
 func (conn *Connection) StoreAttribute(ctx context.Context, pool, reservedUUID, attribute, value string) error {
 	key := conn.config.commonPrefix + attribute
@@ -819,15 +1066,14 @@ func (conn *Connection) StoreGroupID(ctx context.Context, pool, reservedUUID, gr
 func (conn *Connection) FetchAttribute(ctx context.Context, pool, reservedUUID, attribute string) (string, error) {
 	key := conn.config.commonPrefix + attribute
 	values, err := getOMapValues(
-		ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
-		conn.config.commonPrefix, []string{key})
+		ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID, []string{key})
 	if err != nil {
 		return "", fmt.Errorf("failed to get values for key %q from OMAP: %w", key, err)
 	}
 
 	value, ok := values[key]
 	if !ok {
-		return "", fmt.Errorf("failed to find key %q in returned map: %v", key, values)
+		return "", fmt.Errorf("%w: failed to find key %q in returned map: %v", util.ErrKeyNotFound, key, values)
 	}
 
 	return value, nil
@@ -852,8 +1098,7 @@ func (conn *Connection) CheckNewUUIDMapping(ctx context.Context,
 		cj.csiNameKeyPrefix + volumeHandle,
 	}
 	values, err := getOMapValues(
-		ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
-		cj.commonPrefix, fetchKeys)
+		ctx, conn, journalPool, cj.namespace, cj.csiDirectory, fetchKeys)
 	if err != nil {
 		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
 			// pool or omap (oid) was not present
@@ -890,3 +1135,82 @@ func (conn *Connection) ResetVolumeOwner(ctx context.Context, pool, reservedUUID
 	return setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
 		map[string]string{conn.config.ownerKey: owner})
 }
+
+// MoveReservation moves the UUID directory of reservedUUID from srcPool to
+// dstPool, and points the request name in the csiDirectory of dstJournalPool
+// to it. The request name is removed from srcJournalPool when the journal
+// pool changes as well. This is used after the image was migrated to another
+// pool, the image ID is removed from the UUID directory as it changes with
+// the migration.
+//
+// The UUID directory in dstPool is written before the one in srcPool is
+// removed, a MoveReservation that is retried after a failure continues with
+// the remaining updates.
+func (conn *Connection) MoveReservation(ctx context.Context,
+	srcJournalPool, srcPool string,
+	dstJournalPool string, dstJournalPoolID int64,
+	dstPool string, dstPoolID int64,
+	reservedUUID, reqName string,
+) error {
+	cj := conn.config
+	oid := cj.cephUUIDDirectoryPrefix + reservedUUID
+
+	values, err := listOMapValues(ctx, conn, srcPool, cj.namespace, oid, "")
+	switch {
+	case errors.Is(err, util.ErrKeyNotFound):
+		// moved by a previous attempt
+		log.DebugLog(ctx, "UUID directory %s is not in pool %q anymore, updating the request name", oid, srcPool)
+	case err != nil:
+		return err
+	default:
+		delete(values, cj.csiImageIDKey)
+		removeKeys := []string{cj.csiImageIDKey}
+		if dstJournalPool != dstPool && dstJournalPoolID != util.InvalidPoolID {
+			buf64 := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf64, uint64(dstJournalPoolID))
+			values[cj.csiJournalPool] = hex.EncodeToString(buf64)
+		} else {
+			delete(values, cj.csiJournalPool)
+			removeKeys = append(removeKeys, cj.csiJournalPool)
+		}
+
+		err = util.CreateObject(ctx, conn.monitors, conn.cr, dstPool, cj.namespace, oid)
+		if err != nil && !errors.Is(err, util.ErrObjectExists) {
+			return fmt.Errorf("failed to create omap object %s in pool %q: %w", oid, dstPool, err)
+		}
+		err = setOMapKeys(ctx, conn, dstPool, cj.namespace, oid, values)
+		if err != nil {
+			return err
+		}
+		err = removeMapKeys(ctx, conn, dstPool, cj.namespace, oid, removeKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	nameKeyVal := reservedUUID
+	if dstJournalPool != dstPool && dstPoolID != util.InvalidPoolID {
+		buf64 := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf64, uint64(dstPoolID))
+		nameKeyVal = hex.EncodeToString(buf64) + "/" + reservedUUID
+	}
+	err = setOMapKeys(ctx, conn, dstJournalPool, cj.namespace, cj.csiDirectory,
+		map[string]string{cj.csiNameKeyPrefix + reqName: nameKeyVal})
+	if err != nil {
+		return err
+	}
+	if srcJournalPool != dstJournalPool {
+		err = removeMapKeys(ctx, conn, srcJournalPool, cj.namespace, cj.csiDirectory,
+			[]string{cj.csiNameKeyPrefix + reqName})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = util.RemoveObject(ctx, conn.monitors, conn.cr, srcPool, cj.namespace, oid)
+	if err != nil && !errors.Is(err, util.ErrObjectNotFound) {
+		return fmt.Errorf("failed to remove omap object %s from pool %q: %w", oid, srcPool, err)
+	}
+
+	return nil
+}
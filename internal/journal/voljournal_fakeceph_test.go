@@ -0,0 +1,73 @@
+//go:build fakeceph
+
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/fakeceph"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservationSimulated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cr := &util.Credentials{}
+	cluster := fakeceph.NewCluster()
+	cluster.AddPool("replicapool")
+	fakeceph.Register(t.Name(), cluster)
+
+	j, err := NewCSIVolumeJournal("default").Connect(t.Name(), "", cr)
+	require.NoError(t, err)
+	defer j.Destroy()
+
+	poolID, err := util.GetPoolID(t.Name(), cr, "replicapool")
+	require.NoError(t, err)
+
+	imageData, err := j.CheckReservation(ctx, "replicapool", "pvc-1", "csi-vol-", "", "", util.EncryptionTypeNone)
+	require.NoError(t, err)
+	require.Nil(t, imageData)
+
+	volUUID, imageName, err := j.ReserveName(ctx, "replicapool", poolID, "replicapool", poolID,
+		"pvc-1", "csi-vol-", "", "", "", "", "", util.EncryptionTypeNone)
+	require.NoError(t, err)
+	require.Equal(t, "csi-vol-"+volUUID, imageName)
+
+	imageData, err = j.CheckReservation(ctx, "replicapool", "pvc-1", "csi-vol-", "", "", util.EncryptionTypeNone)
+	require.NoError(t, err)
+	require.NotNil(t, imageData)
+	require.Equal(t, volUUID, imageData.ImageUUID)
+	require.Equal(t, imageName, imageData.ImageAttributes.ImageName)
+	require.Equal(t, "pvc-1", imageData.ImageAttributes.RequestName)
+
+	require.NoError(t, j.UndoReservation(ctx, "replicapool", "replicapool", imageName, "pvc-1"))
+	imageData, err = j.CheckReservation(ctx, "replicapool", "pvc-1", "csi-vol-", "", "", util.EncryptionTypeNone)
+	require.NoError(t, err)
+	require.Nil(t, imageData)
+
+	// no reservation exists in a deleted pool
+	cluster.RemovePool("replicapool")
+	imageData, err = j.CheckReservation(ctx, "replicapool", "pvc-1", "csi-vol-", "", "", util.EncryptionTypeNone)
+	require.NoError(t, err)
+	require.Nil(t, imageData)
+}
@@ -18,6 +18,7 @@ package journal
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -72,6 +73,62 @@ type VolumeGroupJournal interface {
 		pool,
 		reservedUUID string,
 		volumeIDs []string) error
+	// UpdateVolumesMapping adds the volumeMap and removes the volumeIDs
+	// mapping of the UUID directory in a single operation, and returns the
+	// new Generation of the directory. The update is rejected with
+	// ErrObjectModified when the directory was modified after the
+	// Generation that was returned by GetVolumeGroupAttributes.
+	UpdateVolumesMapping(
+		ctx context.Context,
+		pool,
+		reservedUUID string,
+		generation uint64,
+		volumeMap map[string]string,
+		volumeIDs []string) (uint64, error)
+	// SetFailoverMarker stores the FailoverMarker in the UUID directory,
+	// replacing a marker that may exist already.
+	SetFailoverMarker(
+		ctx context.Context,
+		pool,
+		reservedUUID string,
+		marker *FailoverMarker) error
+	// RemoveFailoverMarker removes the FailoverMarker from the UUID
+	// directory.
+	RemoveFailoverMarker(
+		ctx context.Context,
+		pool,
+		reservedUUID string) error
+	// SetMirroringState stores the MirroringState in the UUID directory,
+	// replacing a state that may exist already.
+	SetMirroringState(
+		ctx context.Context,
+		pool,
+		reservedUUID string,
+		state *MirroringState) error
+	// RemoveMirroringState removes the MirroringState from the UUID
+	// directory.
+	RemoveMirroringState(
+		ctx context.Context,
+		pool,
+		reservedUUID string) error
+	// SetSnapshotMarker stores the SnapshotMarker in the UUID directory,
+	// replacing a marker that may exist already.
+	SetSnapshotMarker(
+		ctx context.Context,
+		pool,
+		reservedUUID string,
+		marker *SnapshotMarker) error
+	// RemoveSnapshotMarker removes the SnapshotMarker from the UUID
+	// directory.
+	RemoveSnapshotMarker(
+		ctx context.Context,
+		pool,
+		reservedUUID string) error
+	// CountReservations returns the number of groups that are reserved in
+	// the CSI directory in journalPool.
+	CountReservations(
+		ctx context.Context,
+		journalPool string) (int, error)
 }
 
 // VolumeGroupJournalConfig contains the configuration.
@@ -82,6 +139,18 @@ type VolumeGroupJournalConfig struct {
 	// created. At least RBD groups do not provide the creation time
 	// through API calls.
 	csiCreationTimeKey string
+
+	// csiFailoverKey is the key for the FailoverMarker of a group, it is
+	// only set while all volumes of the group are promoted or demoted.
+	csiFailoverKey string
+
+	// csiMirroringKey is the key for the MirroringState of a group, it is
+	// set while mirroring is enabled on the group.
+	csiMirroringKey string
+
+	// csiSnapshotKey is the key for the SnapshotMarker of a group, it is
+	// only set while a group snapshot is created.
+	csiSnapshotKey string
 }
 
 type volumeGroupJournalConnection struct {
@@ -105,6 +174,9 @@ func NewCSIVolumeGroupJournal(suffix string) VolumeGroupJournalConfig {
 			namespace:               "",
 		},
 		csiCreationTimeKey: "csi.creationtime",
+		csiFailoverKey:     "csi.failover",
+		csiMirroringKey:    "csi.mirroring",
+		csiSnapshotKey:     "csi.groupsnapshot",
 	}
 }
 
@@ -132,6 +204,9 @@ func (vgc *VolumeGroupJournalConfig) Connect(
 	vgjc.config = &VolumeGroupJournalConfig{
 		Config:             vgc.Config,
 		csiCreationTimeKey: vgc.csiCreationTimeKey,
+		csiFailoverKey:     vgc.csiFailoverKey,
+		csiMirroringKey:    vgc.csiMirroringKey,
+		csiSnapshotKey:     vgc.csiSnapshotKey,
 	}
 	conn, err := vgc.Config.Connect(monitors, namespace, cr)
 	if err != nil {
@@ -196,8 +271,7 @@ func (vgjc *volumeGroupJournalConnection) CheckReservation(ctx context.Context,
 		cj.csiNameKeyPrefix + reqName,
 	}
 	values, err := getOMapValues(
-		ctx, vgjc.connection, journalPool, cj.namespace, cj.csiDirectory,
-		cj.commonPrefix, fetchKeys)
+		ctx, vgjc.connection, journalPool, cj.namespace, cj.csiDirectory, fetchKeys)
 	if err != nil {
 		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
 			// pool or omap (oid) was not present
@@ -385,10 +459,60 @@ func (vgjc *volumeGroupJournalConnection) ReserveName(ctx context.Context,
 // VolumeGroupAttributes contains the request name and the volumeID's and
 // the corresponding snapshotID's.
 type VolumeGroupAttributes struct {
-	RequestName  string            // Contains the request name for the passed in UUID
-	GroupName    string            // Contains the group name
-	CreationTime *time.Time        // Contains the time of creation of the group
-	VolumeMap    map[string]string // Contains the volumeID and the corresponding value mapping
+	RequestName    string            // Contains the request name for the passed in UUID
+	GroupName      string            // Contains the group name
+	CreationTime   *time.Time        // Contains the time of creation of the group
+	VolumeMap      map[string]string // Contains the volumeID and the corresponding value mapping
+	FailoverMarker *FailoverMarker   // Contains the failover that is in progress, if any
+	MirroringState *MirroringState   // Contains the mirroring of the group, if enabled
+	SnapshotMarker *SnapshotMarker   // Contains the group snapshot that is being created, if any
+	Generation     uint64            // Changes with every update of the UUID directory
+}
+
+// FailoverMarker records the promotion or demotion of all volumes in a group.
+// It is stored before the first volume is changed, and removed once all
+// volumes are done. A marker that is still present means the failover was
+// interrupted, and needs to be resumed or rolled back.
+type FailoverMarker struct {
+	// Intent is the operation that was requested, like "promote"
+	Intent string `json:"intent"`
+	// Force is set when the operation was requested with force
+	Force bool `json:"force,omitempty"`
+	// StartTime is the time the operation was started
+	StartTime time.Time `json:"startTime"`
+	// Initiator identifies the provisioner that started the operation
+	Initiator string `json:"initiator"`
+	// Completed contains the IDs of the volumes that are done
+	Completed []string `json:"completed,omitempty"`
+}
+
+// MirroringState records how the RBD group is mirrored. It is stored when
+// mirroring is enabled on the group, updated when the group is promoted or
+// demoted, and removed when mirroring is disabled again.
+type MirroringState struct {
+	// Mode is the mirroring mode of the group, like "snapshot"
+	Mode string `json:"mode"`
+	// Primary is set when the group was last promoted on this site
+	Primary bool `json:"primary"`
+	// UpdateTime is the time the state was last changed
+	UpdateTime time.Time `json:"updateTime"`
+	// ResyncTime is set when a resync of the group was requested, until
+	// the group has been resynchronized
+	ResyncTime *time.Time `json:"resyncTime,omitempty"`
+	// DivergenceSnapshotPending is set before the group is force-promoted,
+	// until the divergence snapshot of the group was created
+	DivergenceSnapshotPending bool `json:"divergenceSnapshotPending,omitempty"`
+}
+
+// SnapshotMarker records the creation of a group snapshot. It is stored before
+// the RBD group snapshot is created, and removed once the snapshots of all
+// volumes have been created from it. A marker that is still present means the
+// creation was interrupted, and the RBD group snapshot can be reused.
+type SnapshotMarker struct {
+	// Name is the name of the RBD group snapshot
+	Name string `json:"name"`
+	// StartTime is the time the creation was started
+	StartTime time.Time `json:"startTime"`
 }
 
 func (vgjc *volumeGroupJournalConnection) GetVolumeGroupAttributes(
@@ -401,6 +525,14 @@ func (vgjc *volumeGroupJournalConnection) GetVolumeGroupAttributes(
 		cj              = vgjc.config
 	)
 
+	// the generation is read before the values, a concurrent update in
+	// between causes a conflict on the next update instead of losing it
+	generation, err := getObjectVersion(ctx, vgjc.connection, pool, cj.namespace,
+		cj.cephUUIDDirectoryPrefix+objectUUID)
+	if err != nil && !errors.Is(err, util.ErrPoolNotFound) {
+		return nil, err
+	}
+
 	values, err := listOMapValues(
 		ctx, vgjc.connection, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+objectUUID,
 		cj.commonPrefix)
@@ -420,12 +552,40 @@ func (vgjc *volumeGroupJournalConnection) GetVolumeGroupAttributes(
 	groupAttributes.RequestName = values[cj.csiNameKey]
 	groupAttributes.GroupName = values[cj.csiImageKey]
 	groupAttributes.CreationTime = t
+	groupAttributes.Generation = generation
+
+	if marker, ok := values[cj.csiFailoverKey]; ok && marker != "" {
+		groupAttributes.FailoverMarker = &FailoverMarker{}
+		err = json.Unmarshal([]byte(marker), groupAttributes.FailoverMarker)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse failover marker %q: %w", marker, err)
+		}
+	}
+
+	if state, ok := values[cj.csiMirroringKey]; ok && state != "" {
+		groupAttributes.MirroringState = &MirroringState{}
+		err = json.Unmarshal([]byte(state), groupAttributes.MirroringState)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mirroring state %q: %w", state, err)
+		}
+	}
+
+	if marker, ok := values[cj.csiSnapshotKey]; ok && marker != "" {
+		groupAttributes.SnapshotMarker = &SnapshotMarker{}
+		err = json.Unmarshal([]byte(marker), groupAttributes.SnapshotMarker)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot marker %q: %w", marker, err)
+		}
+	}
 
 	// Remove request name key and group name key from the omap, as we are
 	// looking for volumeID/snapshotID mapping
 	delete(values, cj.csiNameKey)
 	delete(values, cj.csiImageKey)
 	delete(values, cj.csiCreationTimeKey)
+	delete(values, cj.csiFailoverKey)
+	delete(values, cj.csiMirroringKey)
+	delete(values, cj.csiSnapshotKey)
 	groupAttributes.VolumeMap = map[string]string{}
 	for k, v := range values {
 		groupAttributes.VolumeMap[k] = v
@@ -468,3 +628,151 @@ func (vgjc *volumeGroupJournalConnection) RemoveVolumesMapping(
 
 	return nil
 }
+
+func (vgjc *volumeGroupJournalConnection) UpdateVolumesMapping(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+	generation uint64,
+	volumeMap map[string]string,
+	volumeIDs []string,
+) (uint64, error) {
+	newGeneration, err := updateOMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		generation, volumeMap, volumeIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update volume mapping of group (add %v, remove %v): %w",
+			volumeMap, volumeIDs, err)
+	}
+
+	return newGeneration, nil
+}
+
+func (vgjc *volumeGroupJournalConnection) SetFailoverMarker(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+	marker *FailoverMarker,
+) error {
+	value, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("failed to encode failover marker %+v: %w", marker, err)
+	}
+
+	err = setOMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{vgjc.config.csiFailoverKey: string(value)})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to set failover marker %s: %v", value, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (vgjc *volumeGroupJournalConnection) RemoveFailoverMarker(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+) error {
+	err := removeMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		[]string{vgjc.config.csiFailoverKey})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to remove failover marker: %v", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (vgjc *volumeGroupJournalConnection) SetMirroringState(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+	state *MirroringState,
+) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode mirroring state %+v: %w", state, err)
+	}
+
+	err = setOMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{vgjc.config.csiMirroringKey: string(value)})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to set mirroring state %s: %v", value, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (vgjc *volumeGroupJournalConnection) RemoveMirroringState(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+) error {
+	err := removeMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		[]string{vgjc.config.csiMirroringKey})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to remove mirroring state: %v", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (vgjc *volumeGroupJournalConnection) SetSnapshotMarker(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+	marker *SnapshotMarker,
+) error {
+	value, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot marker %+v: %w", marker, err)
+	}
+
+	err = setOMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		map[string]string{vgjc.config.csiSnapshotKey: string(value)})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to set snapshot marker %s: %v", value, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (vgjc *volumeGroupJournalConnection) RemoveSnapshotMarker(
+	ctx context.Context,
+	pool,
+	reservedUUID string,
+) error {
+	err := removeMapKeys(ctx, vgjc.connection, pool, vgjc.config.namespace,
+		vgjc.config.cephUUIDDirectoryPrefix+reservedUUID,
+		[]string{vgjc.config.csiSnapshotKey})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to remove snapshot marker: %v", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// CountReservations returns the number of groups that are reserved in the CSI
+// directory in journalPool.
+func (vgjc *volumeGroupJournalConnection) CountReservations(
+	ctx context.Context,
+	journalPool string,
+) (int, error) {
+	return vgjc.connection.CountReservations(ctx, journalPool)
+}
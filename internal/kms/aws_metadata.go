@@ -182,6 +182,24 @@ func (kms *awsMetadataKMS) getService() (*awsKMS.KMS, error) {
 	return awsKMS.New(sess), nil
 }
 
+// checkHealth verifies that the configured CMK can be described with the
+// credentials.
+func (kms *awsMetadataKMS) checkHealth(ctx context.Context) error {
+	svc, err := kms.getService()
+	if err != nil {
+		return fmt.Errorf("could not get KMS service: %w", err)
+	}
+
+	_, err = svc.DescribeKeyWithContext(ctx, &awsKMS.DescribeKeyInput{
+		KeyId: aws.String(kms.cmk),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe CMK: %w", err)
+	}
+
+	return nil
+}
+
 // EncryptDEK uses the Amazon KMS and the configured CMK to encrypt the DEK.
 func (kms *awsMetadataKMS) EncryptDEK(ctx context.Context, volumeID, plainDEK string) (string, error) {
 	svc, err := kms.getService()
@@ -192,6 +192,24 @@ func (as *awsSTSMetadataKMS) getServiceWithSTS() (*awsKMS.KMS, error) {
 	return awsKMS.New(sess), nil
 }
 
+// checkHealth verifies that the role can be assumed, and that the configured
+// CMK can be described with it.
+func (as *awsSTSMetadataKMS) checkHealth(ctx context.Context) error {
+	svc, err := as.getServiceWithSTS()
+	if err != nil {
+		return fmt.Errorf("failed to get KMS service: %w", err)
+	}
+
+	_, err = svc.DescribeKeyWithContext(ctx, &awsKMS.DescribeKeyInput{
+		KeyId: aws.String(as.cmk),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe CMK: %w", err)
+	}
+
+	return nil
+}
+
 // EncryptDEK uses the Amazon KMS and the configured CMK to encrypt the DEK.
 func (as *awsSTSMetadataKMS) EncryptDEK(ctx context.Context, _, plainDEK string) (string, error) {
 	svc, err := as.getServiceWithSTS()
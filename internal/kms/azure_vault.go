@@ -135,6 +135,22 @@ func (kms *azureKMS) getService() (*azsecrets.Client, error) {
 	return azClient, nil
 }
 
+// checkHealth verifies that the secrets in the Azure key vault can be listed
+// with the credentials.
+func (kms *azureKMS) checkHealth(ctx context.Context) error {
+	svc, err := kms.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get KMS service: %w", err)
+	}
+
+	_, err = svc.NewListSecretPropertiesPager(nil).NextPage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	return nil
+}
+
 func (kms *azureKMS) getSecrets() (map[string]interface{}, error) {
 	c, err := k8s.NewK8sClient()
 	if err != nil {
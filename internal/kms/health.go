@@ -0,0 +1,298 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// healthProbeTimeout limits the time a single KMS may take to respond to a
+// health check.
+const healthProbeTimeout = 10 * time.Second
+
+// ErrKMSUnavailable is returned by CheckHealth when the last health check of
+// the KMS failed.
+var ErrKMSUnavailable = errors.New("KMS is unavailable")
+
+// healthProber is implemented by the KMS providers that can verify that the
+// KMS is reachable, without reading or storing a key.
+type healthProber interface {
+	checkHealth(ctx context.Context) error
+}
+
+// healthCheckedProviders are the KMS providers that are checked by the
+// HealthChecker. Providers that need the details of a tenant to connect, or
+// that do not connect to a remote service, are not checked.
+var healthCheckedProviders = []string{
+	kmsTypeAWSMetadata,
+	kmsTypeAWSSTSMetadata,
+	kmsTypeAzure,
+	kmsTypeKMIP,
+	kmsTypeVault,
+}
+
+// HealthStatus is the result of the last health check of a KMS.
+type HealthStatus struct {
+	KMSID       string    `json:"kmsID"`
+	Ready       bool      `json:"ready"`
+	Error       string    `json:"error,omitempty"`
+	LastChecked time.Time `json:"lastChecked"`
+}
+
+// HealthChecker checks the connectivity to all configured KMS at an
+// interval, and keeps the results.
+type HealthChecker struct {
+	interval time.Duration
+
+	getConfig func() (map[string]interface{}, error)
+	buildKMS  func(config map[string]interface{}) (EncryptionKMS, error)
+
+	mu       sync.RWMutex
+	checked  bool
+	statuses map[string]HealthStatus
+
+	ready *prometheus.GaugeVec
+}
+
+// healthChecker is used by CheckHealth, it is set by StartHealthChecks.
+var healthChecker atomic.Pointer[HealthChecker]
+
+func newHealthChecker(interval time.Duration) *HealthChecker {
+	return &HealthChecker{
+		interval:  interval,
+		getConfig: getKMSConfiguration,
+		buildKMS: func(config map[string]interface{}) (EncryptionKMS, error) {
+			return kmsManager.buildKMS("", config, nil)
+		},
+		statuses: map[string]HealthStatus{},
+		ready: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "csi",
+			Name:      "kms_ready",
+			Help:      "Whether the KMS passed the last health check (1) or not (0).",
+		}, []string{"kms_id"}),
+	}
+}
+
+// StartHealthChecks checks the connectivity to all configured KMS in the
+// background, every interval. Once a KMS failed a check, CheckHealth returns
+// an error for it until it passes a check again.
+//
+// Only the providers in healthCheckedProviders are checked, the ones that
+// need the details of a tenant (like "vaulttokens") are skipped.
+func StartHealthChecks(interval time.Duration) (*HealthChecker, error) {
+	hc := newHealthChecker(interval)
+
+	err := prometheus.Register(hc.ready)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register KMS health metrics: %w", err)
+	}
+
+	healthChecker.Store(hc)
+	go hc.Run(context.Background())
+
+	return hc, nil
+}
+
+// CheckHealth returns ErrKMSUnavailable when the last health check of the
+// KMS with kmsID failed. No error is returned when the health checks are not
+// enabled, or the KMS is not checked.
+func CheckHealth(kmsID string) error {
+	hc := healthChecker.Load()
+	if hc == nil {
+		return nil
+	}
+
+	hc.mu.RLock()
+	s, ok := hc.statuses[kmsID]
+	hc.mu.RUnlock()
+
+	if !ok || s.Ready {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q failed the health check at %s: %s", ErrKMSUnavailable, kmsID,
+		s.LastChecked.Format(time.RFC3339), s.Error)
+}
+
+// Run checks the KMS until the context is cancelled.
+func (hc *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		hc.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *HealthChecker) check(ctx context.Context) {
+	config, err := hc.getConfig()
+	if err != nil {
+		// keep the previous results, the configuration may be unavailable
+		// for a moment only
+		log.ErrorLog(ctx, "failed to read the KMS configuration for health checks: %v", err)
+
+		return
+	}
+
+	statuses := make(map[string]HealthStatus, len(config))
+	for kmsID, section := range config {
+		kmsConfig, ok := section.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		s, ok := hc.checkKMS(ctx, kmsID, kmsConfig)
+		if ok {
+			statuses[kmsID] = s
+		}
+	}
+
+	hc.ready.Reset()
+	for kmsID, s := range statuses {
+		value := 0.0
+		if s.Ready {
+			value = 1
+		}
+		hc.ready.WithLabelValues(kmsID).Set(value)
+	}
+
+	hc.mu.Lock()
+	hc.checked = true
+	hc.statuses = statuses
+	hc.mu.Unlock()
+}
+
+// checkKMS returns the HealthStatus of the KMS, false is returned when the
+// KMS can not be checked.
+func (hc *HealthChecker) checkKMS(
+	ctx context.Context,
+	kmsID string,
+	config map[string]interface{},
+) (HealthStatus, bool) {
+	provider, err := getProvider(config)
+	if err != nil || !slices.Contains(healthCheckedProviders, provider) {
+		return HealthStatus{}, false
+	}
+
+	s := HealthStatus{KMSID: kmsID, LastChecked: time.Now().UTC()}
+	err = probeHealthWithTimeout(ctx, func(ctx context.Context) error {
+		// some providers connect to the KMS while they are initialized
+		kms, bErr := hc.buildKMS(config)
+		if bErr != nil {
+			return bErr
+		}
+		defer kms.Destroy()
+
+		prober, ok := kms.(healthProber)
+		if !ok {
+			return fmt.Errorf("KMS provider %q does not support health checks", provider)
+		}
+
+		return prober.checkHealth(ctx)
+	})
+	if err != nil {
+		s.Error = err.Error()
+		log.WarningLog(ctx, "KMS %q failed the health check: %s", kmsID, s.Error)
+
+		return s, true
+	}
+	s.Ready = true
+
+	return s, true
+}
+
+// probeHealthWithTimeout returns an error when the health check does not
+// finish in time. Not all KMS clients can be cancelled, the check continues in
+// the background in that case.
+func probeHealthWithTimeout(ctx context.Context, probe func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- probe(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("KMS did not respond within %s", healthProbeTimeout)
+	}
+}
+
+// Name returns the key of the results in the readiness endpoint.
+func (hc *HealthChecker) Name() string {
+	return "kms"
+}
+
+// Ready returns true when all checked KMS passed the last check. The
+// HealthChecker is not ready before the first check completed.
+func (hc *HealthChecker) Ready() bool {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	if !hc.checked {
+		return false
+	}
+
+	for _, s := range hc.statuses {
+		if !s.Ready {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Statuses returns the HealthStatus of all checked KMS, sorted by kmsID.
+func (hc *HealthChecker) Statuses() []HealthStatus {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	statuses := make([]HealthStatus, 0, len(hc.statuses))
+	for _, s := range hc.statuses {
+		statuses = append(statuses, s)
+	}
+	slices.SortFunc(statuses, func(a, b HealthStatus) int {
+		return strings.Compare(a.KMSID, b.KMSID)
+	})
+
+	return statuses
+}
+
+// Report returns the Statuses for the readiness endpoint.
+func (hc *HealthChecker) Report() any {
+	return hc.Statuses()
+}
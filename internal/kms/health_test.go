@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kms
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProberKMS struct {
+	EncryptionKMS
+
+	err error
+}
+
+func (kms *fakeProberKMS) Destroy() {}
+
+func (kms *fakeProberKMS) checkHealth(context.Context) error {
+	return kms.err
+}
+
+func TestHealthChecker(t *testing.T) {
+	t.Parallel()
+
+	errs := map[string]error{}
+	hc := newHealthChecker(0)
+	hc.getConfig = func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"vault-test": map[string]interface{}{
+				kmsTypeKey: kmsTypeVault,
+				"name":     "vault-test",
+			},
+			"kmip-test": map[string]interface{}{
+				kmsTypeKey: kmsTypeKMIP,
+				"name":     "kmip-test",
+			},
+			// tenant configurations are not checked
+			"tokens-test": map[string]interface{}{
+				kmsTypeKey: kmsTypeVaultTokens,
+				"name":     "tokens-test",
+			},
+		}, nil
+	}
+	hc.buildKMS = func(config map[string]interface{}) (EncryptionKMS, error) {
+		kmsID, _ := config["name"].(string)
+		// kmip fails while it is initialized
+		if kmsID == "kmip-test" && errs[kmsID] != nil {
+			return nil, errs[kmsID]
+		}
+
+		return &fakeProberKMS{err: errs[kmsID]}, nil
+	}
+
+	// not ready before the first check
+	require.False(t, hc.Ready())
+
+	hc.check(context.Background())
+	require.True(t, hc.Ready())
+	statuses := hc.Statuses()
+	require.Len(t, statuses, 2)
+	require.Equal(t, "kmip-test", statuses[0].KMSID)
+	require.Equal(t, "vault-test", statuses[1].KMSID)
+
+	errs["vault-test"] = errors.New("connection refused")
+	errs["kmip-test"] = errors.New("failed to get secret")
+	hc.check(context.Background())
+	require.False(t, hc.Ready())
+	statuses = hc.Statuses()
+	require.Equal(t, "failed to get secret", statuses[0].Error)
+	require.Equal(t, "connection refused", statuses[1].Error)
+
+	// a failure to read the configuration keeps the previous results
+	hc.getConfig = func() (map[string]interface{}, error) {
+		return nil, errors.New("ConfigMap not found")
+	}
+	hc.check(context.Background())
+	require.False(t, hc.Ready())
+	require.Len(t, hc.Statuses(), 2)
+}
+
+func TestCheckHealth(t *testing.T) {
+	// CheckHealth uses the global healthChecker
+	hc := newHealthChecker(0)
+	hc.statuses = map[string]HealthStatus{
+		"ready":       {KMSID: "ready", Ready: true},
+		"unavailable": {KMSID: "unavailable", Error: "connection refused"},
+	}
+
+	require.NoError(t, CheckHealth("unavailable"))
+
+	healthChecker.Store(hc)
+	defer healthChecker.Store(nil)
+
+	require.NoError(t, CheckHealth("ready"))
+	require.NoError(t, CheckHealth("not-checked"))
+	err := CheckHealth("unavailable")
+	require.ErrorIs(t, err, ErrKMSUnavailable)
+	require.ErrorContains(t, err, "connection refused")
+}
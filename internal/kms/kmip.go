@@ -359,6 +359,17 @@ func (kms *kmipKMS) connect() (*tls.Conn, error) {
 	return conn, nil
 }
 
+// checkHealth connects to the KMIP endpoint, which performs the TLS and KMIP
+// handshakes.
+func (kms *kmipKMS) checkHealth(_ context.Context) error {
+	conn, err := kms.connect()
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
 // discover performs KMIP discover operation.
 // https://docs.oasis-open.org/kmip/spec/v1.4/kmip-spec-v1.4.html
 // chapter 4.26.
@@ -93,6 +93,44 @@ func GetKMS(tenant, kmsID string, secrets map[string]string) (EncryptionKMS, err
 	return kmsManager.buildKMS(tenant, kmsConfig, secrets)
 }
 
+// ValidateKMSID checks that a configuration section for the kmsID exists, and
+// that it references a registered KMS provider. No connection to the KMS is
+// made, so this can be used to validate parameters before a volume is
+// created.
+func ValidateKMSID(kmsID string) error {
+	if kmsID == "" || kmsID == DefaultKMSType {
+		return nil
+	}
+
+	config, err := getKMSConfiguration()
+	if err != nil {
+		return err
+	}
+
+	section, ok := config[kmsID]
+	if !ok {
+		return fmt.Errorf("could not get KMS configuration "+
+			"for %q (have %v)", kmsID, getKeys(config))
+	}
+
+	kmsConfig, ok := section.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("failed to convert KMS configuration "+
+			"section: %s", kmsID)
+	}
+
+	provider, err := getProvider(kmsConfig)
+	if err != nil {
+		return err
+	}
+
+	if _, ok = kmsManager.providers[provider]; !ok {
+		return fmt.Errorf("could not find KMS provider %q", provider)
+	}
+
+	return nil
+}
+
 // getKMSConfiguration reads the configuration file from the filesystem, or if
 // that fails the ConfigMap directly. The returned map contains all the KMS
 // configuration sections, each keyed by its own kmsID.
@@ -298,6 +298,49 @@ func (vc *vaultConnection) connectVault() error {
 	return nil
 }
 
+// checkHealth verifies that Vault is reachable, initialized and unsealed.
+// The health endpoint of Vault does not need a token.
+func (vc *vaultConnection) checkHealth(ctx context.Context) error {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return fmt.Errorf("failed to configure Vault client: %w", config.Error)
+	}
+	config.Address, _ = vc.vaultConfig[api.EnvVaultAddress].(string)
+
+	tlsConfig := &api.TLSConfig{}
+	tlsConfig.CACert, _ = vc.vaultConfig[api.EnvVaultCACert].(string)
+	tlsConfig.ClientCert, _ = vc.vaultConfig[api.EnvVaultClientCert].(string)
+	tlsConfig.ClientKey, _ = vc.vaultConfig[api.EnvVaultClientKey].(string)
+	tlsConfig.TLSServerName, _ = vc.vaultConfig[api.EnvVaultTLSServerName].(string)
+	insecure, _ := vc.vaultConfig[api.EnvVaultInsecure].(string)
+	tlsConfig.Insecure = insecure == "true"
+	err := config.ConfigureTLS(tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to configure TLS for Vault client: %w", err)
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Vault client: %w", err)
+	}
+	if namespace, ok := vc.vaultConfig[api.EnvVaultNamespace].(string); ok {
+		client.SetNamespace(namespace)
+	}
+
+	health, err := client.Sys().HealthWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get health of Vault: %w", err)
+	}
+	switch {
+	case !health.Initialized:
+		return errors.New("vault is not initialized")
+	case health.Sealed:
+		return errors.New("vault is sealed")
+	}
+
+	return nil
+}
+
 // Destroy frees allocated resources. For a vaultConnection that means removing
 // the created temporary files.
 func (vc *vaultConnection) Destroy() {
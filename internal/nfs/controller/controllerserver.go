@@ -25,6 +25,7 @@ import (
 	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/nfs/export"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
@@ -40,6 +41,14 @@ type Server struct {
 
 	// backendServer handles the CephFS requests
 	backendServer *cephfs.ControllerServer
+
+	// VerifyExports checks the NFS-export of a volume in
+	// ControllerPublishVolume, and creates it again when it is missing.
+	VerifyExports bool
+	// MigrateExports adds an NFS-export with the current path in
+	// ControllerPublishVolume for volumes that were exported with the
+	// legacy path by an older release.
+	MigrateExports bool
 }
 
 // NewControllerServer initialize a controller server for ceph CSI driver.
@@ -97,7 +106,7 @@ func (cs *Server) CreateVolume(
 	}
 	defer cr.DeleteCredentials()
 
-	nfsVolume, err := NewNFSVolume(ctx, backend.GetVolumeId())
+	nfsVolume, err := export.NewNFSVolume(ctx, backend.GetVolumeId())
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -136,7 +145,7 @@ func (cs *Server) DeleteVolume(
 	}
 	defer cr.DeleteCredentials()
 
-	nfsVolume, err := NewNFSVolume(ctx, req.GetVolumeId())
+	nfsVolume, err := export.NewNFSVolume(ctx, req.GetVolumeId())
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -149,7 +158,7 @@ func (cs *Server) DeleteVolume(
 
 	err = nfsVolume.DeleteExport()
 	// if the export does not exist, continue with deleting the backend volume
-	if err != nil && !errors.Is(err, ErrNotFound) {
+	if err != nil && !errors.Is(err, export.ErrNotFound) {
 		return nil, status.Errorf(codes.InvalidArgument, "failed to delete export: %v", err)
 	}
 
@@ -158,6 +167,117 @@ func (cs *Server) DeleteVolume(
 	return cs.backendServer.DeleteVolume(ctx, req)
 }
 
+// ControllerPublishVolume verifies that the NFS-export of the volume exists
+// when VerifyExports is set, a missing export is created again before the
+// volume is mounted on the node. With MigrateExports, the legacy export of a
+// volume of an older release is migrated. Attaching is a no-op otherwise.
+func (cs *Server) ControllerPublishVolume(
+	ctx context.Context,
+	req *csi.ControllerPublishVolumeRequest,
+) (*csi.ControllerPublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID cannot be empty")
+	}
+	if req.GetNodeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Node ID cannot be empty")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities cannot be empty")
+	}
+
+	if cs.VerifyExports || cs.MigrateExports {
+		err := cs.verifyExport(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{},
+	}, nil
+}
+
+// ControllerUnpublishVolume is a no-op, the NFS-export stays until the volume
+// is deleted.
+func (cs *Server) ControllerUnpublishVolume(
+	ctx context.Context,
+	req *csi.ControllerUnpublishVolumeRequest,
+) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID cannot be empty")
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+// verifyExport migrates the legacy NFS-export of the volume in the request
+// when MigrateExports is set, and creates the NFS-export again when it does
+// not exist on the NFS-cluster anymore and VerifyExports is set.
+func (cs *Server) verifyExport(ctx context.Context, req *csi.ControllerPublishVolumeRequest) error {
+	volumeID := req.GetVolumeId()
+	secrets := req.GetSecrets()
+	if len(secrets) == 0 {
+		log.WarningLog(ctx, "not verifying the NFS-export of volume %s, the StorageClass has no "+
+			"controller-publish secret", volumeID)
+
+		return nil
+	}
+
+	if acquired := cs.backendServer.VolumeLocks.TryAcquire(volumeID); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, volumeID)
+
+		return status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cs.backendServer.VolumeLocks.Release(volumeID)
+
+	cr, err := util.NewAdminCredentials(secrets)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to retrieve admin credentials: %v", err)
+
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer cr.DeleteCredentials()
+
+	nfsVolume, err := export.NewNFSVolume(ctx, volumeID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = nfsVolume.Connect(cr)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to connect: %v", err)
+	}
+	defer nfsVolume.Destroy()
+
+	backend := &csi.Volume{
+		VolumeId:      volumeID,
+		VolumeContext: req.GetVolumeContext(),
+	}
+	if cs.MigrateExports {
+		migrated, mErr := nfsVolume.MigrateExport(backend)
+		if mErr != nil {
+			return status.Errorf(codes.Internal, "failed to migrate export: %v", mErr)
+		}
+		if migrated {
+			log.DebugLog(ctx, "legacy NFS-export of volume %s has been migrated to %q", volumeID, nfsVolume)
+		}
+	}
+
+	if !cs.VerifyExports {
+		return nil
+	}
+
+	created, err := nfsVolume.EnsureExport(backend)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to verify export: %v", err)
+	}
+	if created {
+		log.WarningLog(ctx, "NFS-export %q of volume %s was missing, it has been created again", nfsVolume, volumeID)
+	}
+
+	return nil
+}
+
 // ControllerExpandVolume calls the backend (CephFS) procedure to expand the
 // volume. There is no interaction with the NFS-server needed to publish the
 // new size.
@@ -0,0 +1,237 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/ceph/ceph-csi/internal/nfs/export"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+	"github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExportMigrationService is the name of the gRPC service that migrates the
+// NFS-exports of the volumes of older releases at once. It is served on the
+// admin endpoint of the provisioner, the messages are encoded as JSON.
+const ExportMigrationService = "cephcsi.nfs.v1.ExportMigration"
+
+// MigrateExportsRequest is the request of MigrateExports. The Secret contains
+// the admin credentials of the cluster, the controller-publish secret of the
+// PersistentVolume is used when it is not set.
+type MigrateExportsRequest struct {
+	// VolumeIDs limits the migration to the volumes, all volumes of the
+	// driver are migrated when it is empty
+	VolumeIDs       []string `json:"volumeIDs,omitempty"`
+	SecretName      string   `json:"secretName,omitempty"`
+	SecretNamespace string   `json:"secretNamespace,omitempty"`
+}
+
+// MigratedExport is the result of the migration of the NFS-export of a
+// volume. Migrated is false when the volume already has an NFS-export with
+// the current path, or has no NFS-export with the legacy path.
+type MigratedExport struct {
+	VolumeID         string `json:"volumeID"`
+	PersistentVolume string `json:"persistentVolume"`
+	Migrated         bool   `json:"migrated"`
+	Error            string `json:"error,omitempty"`
+}
+
+// MigrateExportsResponse is the response of MigrateExports.
+type MigrateExportsResponse struct {
+	Volumes []MigratedExport `json:"volumes"`
+}
+
+// exportMigrationServer is the interface of the ExportMigrationService.
+type exportMigrationServer interface {
+	MigrateExports(ctx context.Context, req *MigrateExportsRequest) (*MigrateExportsResponse, error)
+}
+
+// exportMigrationServiceDesc describes the ExportMigrationService for the
+// gRPC server.
+var exportMigrationServiceDesc = grpc.ServiceDesc{
+	ServiceName: ExportMigrationService,
+	HandlerType: (*exportMigrationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		jsongrpc.UnaryMethod(ExportMigrationService, "MigrateExports", exportMigrationServer.MigrateExports),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// ExportMigration migrates the NFS-exports with the legacy path of older
+// releases, like ControllerPublishVolume does with MigrateExports, for all
+// PersistentVolumes of the driver at once.
+type ExportMigration struct {
+	cs         *Server
+	driverName string
+}
+
+var _ exportMigrationServer = &ExportMigration{}
+
+// NewExportMigration returns the ExportMigration of the volumes of the
+// driver, the volumes are locked in the controller server.
+func NewExportMigration(cs *Server, driverName string) *ExportMigration {
+	return &ExportMigration{
+		cs:         cs,
+		driverName: driverName,
+	}
+}
+
+// RegisterService registers the ExportMigrationService on the server.
+func (em *ExportMigration) RegisterService(server grpc.ServiceRegistrar) {
+	server.RegisterService(&exportMigrationServiceDesc, em)
+}
+
+// MigrateExports migrates the NFS-exports of the PersistentVolumes of the
+// driver. A failed migration of a volume is reported in the response, the
+// other volumes are migrated.
+func (em *ExportMigration) MigrateExports(
+	ctx context.Context,
+	req *MigrateExportsRequest,
+) (*MigrateExportsResponse, error) {
+	if (req.SecretName == "") != (req.SecretNamespace == "") {
+		return nil, status.Error(codes.InvalidArgument, "secretName and secretNamespace must be set together")
+	}
+
+	client, err := k8s.NewK8sClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+
+	pvs, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list PersistentVolumes: %v", err)
+	}
+
+	resp := &MigrateExportsResponse{Volumes: []MigratedExport{}}
+	for _, pv := range migrationCandidates(pvs.Items, em.driverName, req.VolumeIDs) {
+		me := MigratedExport{
+			VolumeID:         pv.Spec.CSI.VolumeHandle,
+			PersistentVolume: pv.Name,
+		}
+
+		me.Migrated, err = em.migrateVolume(ctx, client, pv, req)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to migrate NFS-export of volume %s: %v", me.VolumeID, err)
+			me.Error = err.Error()
+		}
+		resp.Volumes = append(resp.Volumes, me)
+	}
+
+	return resp, nil
+}
+
+// migrationCandidates returns the PersistentVolumes of the driver, only the
+// ones of the volumeIDs when it is not empty.
+func migrationCandidates(pvs []v1.PersistentVolume, driverName string, volumeIDs []string) []*v1.PersistentVolume {
+	candidates := []*v1.PersistentVolume{}
+	for i := range pvs {
+		pv := &pvs[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName {
+			continue
+		}
+		if len(volumeIDs) != 0 && !slices.Contains(volumeIDs, pv.Spec.CSI.VolumeHandle) {
+			continue
+		}
+		candidates = append(candidates, pv)
+	}
+
+	return candidates
+}
+
+// migrateVolume migrates the NFS-export of the volume of the
+// PersistentVolume, with the Secret of the request or the controller-publish
+// secret of the PersistentVolume.
+func (em *ExportMigration) migrateVolume(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pv *v1.PersistentVolume,
+	req *MigrateExportsRequest,
+) (bool, error) {
+	secretName, secretNamespace := req.SecretName, req.SecretNamespace
+	if secretName == "" && pv.Spec.CSI.ControllerPublishSecretRef != nil {
+		secretName = pv.Spec.CSI.ControllerPublishSecretRef.Name
+		secretNamespace = pv.Spec.CSI.ControllerPublishSecretRef.Namespace
+	}
+	if secretName == "" {
+		return false, fmt.Errorf("PersistentVolume %q has no controller-publish secret", pv.Name)
+	}
+
+	secret, err := client.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get secret %s/%s: %w", secretNamespace, secretName, err)
+	}
+	secrets := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		secrets[k] = string(v)
+	}
+
+	backend := &csi.Volume{
+		VolumeId:      pv.Spec.CSI.VolumeHandle,
+		VolumeContext: pv.Spec.CSI.VolumeAttributes,
+	}
+
+	return em.cs.migrateExport(ctx, backend, secrets)
+}
+
+// migrateExport migrates the legacy NFS-export of the volume, with the volume
+// locked.
+func (cs *Server) migrateExport(ctx context.Context, backend *csi.Volume, secrets map[string]string) (bool, error) {
+	volumeID := backend.GetVolumeId()
+	if acquired := cs.backendServer.VolumeLocks.TryAcquire(volumeID); !acquired {
+		return false, fmt.Errorf(util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cs.backendServer.VolumeLocks.Release(volumeID)
+
+	cr, err := util.NewAdminCredentials(secrets)
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve admin credentials: %w", err)
+	}
+	defer cr.DeleteCredentials()
+
+	nfsVolume, err := export.NewNFSVolume(ctx, volumeID)
+	if err != nil {
+		return false, err
+	}
+
+	err = nfsVolume.Connect(cr)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer nfsVolume.Destroy()
+
+	migrated, err := nfsVolume.MigrateExport(backend)
+	if err != nil {
+		return false, fmt.Errorf("failed to migrate export: %w", err)
+	}
+	if migrated {
+		log.DebugLog(ctx, "legacy NFS-export of volume %s has been migrated to %q", volumeID, nfsVolume)
+	}
+
+	return migrated, nil
+}
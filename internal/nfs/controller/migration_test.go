@@ -0,0 +1,57 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMigrationCandidates(t *testing.T) {
+	t.Parallel()
+
+	pv := func(name, driver, handle string) v1.PersistentVolume {
+		p := v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if driver != "" {
+			p.Spec.CSI = &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: handle}
+		}
+
+		return p
+	}
+	pvs := []v1.PersistentVolume{
+		pv("pv-1", "nfs.csi.ceph.com", "vol-1"),
+		pv("pv-2", "cephfs.csi.ceph.com", "vol-2"),
+		pv("pv-3", "", ""),
+		pv("pv-4", "nfs.csi.ceph.com", "vol-4"),
+	}
+
+	names := func(candidates []*v1.PersistentVolume) []string {
+		n := []string{}
+		for _, c := range candidates {
+			n = append(n, c.Name)
+		}
+
+		return n
+	}
+
+	require.Equal(t, []string{"pv-1", "pv-4"}, names(migrationCandidates(pvs, "nfs.csi.ceph.com", nil)))
+	require.Equal(t, []string{"pv-4"}, names(migrationCandidates(pvs, "nfs.csi.ceph.com", []string{"vol-4", "vol-2"})))
+	require.Empty(t, migrationCandidates(pvs, "other.csi.ceph.com", nil))
+}
@@ -17,11 +17,17 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/admin"
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/nfs/controller"
 	"github.com/ceph/ceph-csi/internal/nfs/identity"
 	"github.com/ceph/ceph-csi/internal/nfs/nodeserver"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/clusterconfig"
+	"github.com/ceph/ceph-csi/internal/util/featuregate"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -44,14 +50,25 @@ func (fs *Driver) Run(conf *util.Config) {
 		log.FatalLogMsg("failed to initialize CSI driver")
 	}
 
+	if featuregate.Enabled(featuregate.ClusterConfigCRD) {
+		err := clusterconfig.Start(context.Background(), conf.DriverNamespace, conf.IsControllerServer)
+		if err != nil {
+			log.FatalLogMsg("failed to read ClusterConfig objects: %v", err)
+		}
+	}
+
 	if conf.IsControllerServer || !conf.IsNodeServer {
-		cd.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
+		controllerCaps := []csi.ControllerServiceCapability_RPC_Type{
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 			csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
-		})
+		}
+		if conf.NFSVerifyExports || conf.NFSMigrateExports {
+			controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+		}
+		cd.AddControllerServiceCapabilities(controllerCaps)
 		// VolumeCapabilities are validated by the CephFS Controller
 		cd.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{
 			csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
@@ -71,14 +88,31 @@ func (fs *Driver) Run(conf *util.Config) {
 	case conf.IsNodeServer:
 		srv.NS = nodeserver.NewNodeServer(cd, conf.Vtype)
 	case conf.IsControllerServer:
-		srv.CS = controller.NewControllerServer(cd)
+		cs := controller.NewControllerServer(cd)
+		cs.VerifyExports = conf.NFSVerifyExports
+		cs.MigrateExports = conf.NFSMigrateExports
+		srv.CS = cs
+
+		if conf.AdminEndpoint != "" {
+			err := startAdminServer(conf, cs)
+			if err != nil {
+				log.FatalLogMsg("%v", err)
+			}
+		}
 	default:
 		srv.NS = nodeserver.NewNodeServer(cd, conf.Vtype)
-		srv.CS = controller.NewControllerServer(cd)
+		cs := controller.NewControllerServer(cd)
+		cs.VerifyExports = conf.NFSVerifyExports
+		cs.MigrateExports = conf.NFSMigrateExports
+		srv.CS = cs
 	}
 
 	server.Start(conf.Endpoint, srv, csicommon.MiddlewareServerOptionConfig{
-		LogSlowOpInterval: conf.LogSlowOpInterval,
+		LogSlowOpInterval:   conf.LogSlowOpInterval,
+		SlowOpThresholds:    csicommon.SlowOpThresholds(conf),
+		MaintenanceModeFile: util.MaintenanceModeFile,
+		RPCConcurrency:      csicommon.NodeRPCConcurrency(conf),
+		MaxMessageSize:      conf.GRPCMaxMessageSize,
 	})
 
 	if conf.EnableProfiling {
@@ -88,3 +122,21 @@ func (fs *Driver) Run(conf *util.Config) {
 	}
 	server.Wait()
 }
+
+// startAdminServer starts the admin server on the admin endpoint, with the
+// ExportMigration service of the provisioner.
+func startAdminServer(conf *util.Config, cs *controller.Server) error {
+	as, err := admin.NewServer(conf.AdminEndpoint)
+	if err != nil {
+		return fmt.Errorf("failed to create the admin server: %w", err)
+	}
+
+	controller.NewExportMigration(cs, conf.DriverName).RegisterService(as)
+
+	err = as.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start the admin server: %w", err)
+	}
+
+	return nil
+}
@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package controller
+package export
 
 import (
 	"errors"
@@ -22,9 +22,8 @@ import (
 )
 
 var (
-	// ErrNotConnected is returned when components from the NFS
-	// ControllerServer can not connect to the Ceph cluster or NFS-Ganesha
-	// service.
+	// ErrNotConnected is returned when an NFSVolume is used before it
+	// connected to the Ceph cluster or NFS-Ganesha service.
 	ErrNotConnected = errors.New("not connected")
 
 	// ErrNotFound is a generic error that is the parent of other "not
@@ -14,7 +14,9 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package controller
+// Package export manages the NFS-exports of CephFS volumes on the Ceph
+// managed NFS-server.
+package export
 
 import (
 	"context"
@@ -121,6 +123,41 @@ func (nv *NFSVolume) GetExportPath() string {
 	return "/" + nv.volumeID
 }
 
+// GetLegacyExportPath returns the path on the NFS-server that older releases
+// used for the NFS-export of the volume, the name of the subvolume. Volumes
+// of these releases have the legacy path as "share" in their volume context.
+// An error with util.ErrKeyNotFound is returned when the journal does not have
+// the subvolume of the volume, the volume has no legacy export then.
+func (nv *NFSVolume) GetLegacyExportPath() (string, error) {
+	if !nv.connected {
+		return "", fmt.Errorf("can not get legacy export path for %q: %w", nv, ErrNotConnected)
+	}
+
+	mdPool, err := nv.getMetadataPool()
+	if err != nil {
+		return "", err
+	}
+
+	// Connect to cephfs' default radosNamespace (csi)
+	j, err := store.VolJournal.Connect(nv.mons, fsutil.RadosNamespace, nv.cr)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to journal: %w", err)
+	}
+	defer j.Destroy()
+
+	attrs, err := j.GetImageAttributes(nv.ctx, mdPool, nv.objectUUID, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to get subvolume name for %q: %w", nv.objectUUID, err)
+	}
+	// the journal returns the default name of the subvolume when the UUID
+	// directory does not exist
+	if attrs.RequestName == "" {
+		return "", fmt.Errorf("%w: no journal entry for %q", util.ErrKeyNotFound, nv.objectUUID)
+	}
+
+	return "/" + attrs.ImageName, nil
+}
+
 // CreateExport takes the (CephFS) CSI-volume and instructs Ceph Mgr to create
 // a new NFS-export for the volume on the Ceph managed NFS-server.
 func (nv *NFSVolume) CreateExport(backend *csi.Volume) error {
@@ -187,6 +224,125 @@ func (nv *NFSVolume) CreateExport(backend *csi.Volume) error {
 	return nil
 }
 
+// EnsureExport checks that the NFS-export of the volume exists on the
+// NFS-cluster, and creates it again from the volume context of backend when
+// it is missing. Exports can get lost when the configuration of the
+// NFS-server is restored after a failover. True is returned when the export
+// was created.
+func (nv *NFSVolume) EnsureExport(backend *csi.Volume) (bool, error) {
+	if !nv.connected {
+		return false, fmt.Errorf("can not verify export for %q: %w", nv, ErrNotConnected)
+	}
+
+	nfsCluster := backend.GetVolumeContext()["nfsCluster"]
+	nfsa, err := nv.conn.GetNFSAdmin()
+	if err != nil {
+		return false, fmt.Errorf("failed to get NFSAdmin: %w", err)
+	}
+
+	_, err = nfsa.ExportInfo(nfsCluster, nv.GetExportPath())
+	switch {
+	case err == nil:
+		return false, nil
+	case isExportNotFound(err):
+		break
+	default:
+		return false, fmt.Errorf("failed to get export %q from NFS-cluster %q: %w", nv, nfsCluster, err)
+	}
+
+	err = nv.CreateExport(backend)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MigrateExport adds an NFS-export with the path of GetExportPath() for a
+// volume that was exported with the legacy path by an older release. The new
+// export gets the filesystem, path, clients and security types of the legacy
+// export. The legacy export is kept, as clients may still have it mounted,
+// both exports are removed by DeleteExport. True is returned when the new
+// export was created.
+func (nv *NFSVolume) MigrateExport(backend *csi.Volume) (bool, error) {
+	if !nv.connected {
+		return false, fmt.Errorf("can not migrate export for %q: %w", nv, ErrNotConnected)
+	}
+
+	nfsCluster := backend.GetVolumeContext()["nfsCluster"]
+	nfsa, err := nv.conn.GetNFSAdmin()
+	if err != nil {
+		return false, fmt.Errorf("failed to get NFSAdmin: %w", err)
+	}
+
+	_, err = nfsa.ExportInfo(nfsCluster, nv.GetExportPath())
+	switch {
+	case err == nil:
+		return false, nil
+	case isExportNotFound(err):
+		break
+	default:
+		return false, fmt.Errorf("failed to get export %q from NFS-cluster %q: %w", nv, nfsCluster, err)
+	}
+
+	legacyPath, err := nv.GetLegacyExportPath()
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	legacy, err := nfsa.ExportInfo(nfsCluster, legacyPath)
+	switch {
+	case err == nil:
+		break
+	case isExportNotFound(err):
+		// not exported by an older release
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to get export %q from NFS-cluster %q: %w", legacyPath, nfsCluster, err)
+	}
+
+	_, err = nfsa.CreateCephFSExport(migratedExportSpec(&legacy, nv.GetExportPath()))
+	if err != nil {
+		return false, fmt.Errorf("failed to migrate export %q of %q on NFS-cluster %q: %w",
+			legacyPath, nv, nfsCluster, err)
+	}
+
+	return true, nil
+}
+
+// migratedExportSpec returns the specification of an export with pseudoPath,
+// that exports the same directory as the legacy export.
+func migratedExportSpec(legacy *nfs.ExportInfo, pseudoPath string) nfs.CephFSExportSpec {
+	export := nfs.CephFSExportSpec{
+		FileSystemName: legacy.FSAL.FileSystemName,
+		ClusterID:      legacy.ClusterID,
+		PseudoPath:     pseudoPath,
+		Path:           legacy.Path,
+		ReadOnly:       legacy.AccessType == "RO",
+		Squash:         legacy.Squash,
+		SecType:        legacy.SecType,
+	}
+
+	for _, client := range legacy.Clients {
+		export.ClientAddr = append(export.ClientAddr, client.Addresses...)
+	}
+
+	return export
+}
+
+// isExportNotFound returns true when the error of an `nfs export info`
+// command indicates that the export does not exist. Depending on the Ceph
+// release, the command returns no output or an ENOENT error.
+func isExportNotFound(err error) bool {
+	msg := err.Error()
+
+	return strings.Contains(msg, "No export info found") ||
+		strings.Contains(msg, "ret=-2") ||
+		strings.Contains(msg, "Export does not exist")
+}
+
 // createExportCommand returns the "ceph nfs export create ..." command
 // arguments (without "ceph"). The order of the parameters matches old Ceph
 // releases, new Ceph releases added --option formats, which can be added  when
@@ -207,7 +363,9 @@ func (nv *NFSVolume) createExportCommand(nfsCluster, fs, export, path string) []
 	}
 }
 
-// DeleteExport removes the NFS-export from the Ceph managed NFS-server.
+// DeleteExport removes the NFS-export from the Ceph managed NFS-server. The
+// export with the legacy path of older releases is removed as well, when it
+// exists. ErrExportNotFound is returned when the volume has neither export.
 func (nv *NFSVolume) DeleteExport() error {
 	if !nv.connected {
 		return fmt.Errorf("can not delete export for %q: not connected", nv)
@@ -218,12 +376,43 @@ func (nv *NFSVolume) DeleteExport() error {
 		return fmt.Errorf("failed to identify NFS cluster: %w", err)
 	}
 
+	legacyPath, err := nv.GetLegacyExportPath()
+	if errors.Is(err, util.ErrKeyNotFound) {
+		// the journal of the volume is gone, it has no legacy export
+		legacyPath = ""
+	} else if err != nil {
+		return err
+	}
+
+	err = nv.removeExport(nfsCluster, nv.GetExportPath())
+	if err != nil && !errors.Is(err, ErrExportNotFound) {
+		return err
+	}
+
+	lErr := ErrExportNotFound
+	if legacyPath != "" {
+		lErr = nv.removeExport(nfsCluster, legacyPath)
+		if lErr != nil && !errors.Is(lErr, ErrExportNotFound) {
+			return lErr
+		}
+	}
+
+	if err != nil && lErr != nil {
+		return ErrExportNotFound
+	}
+
+	return nil
+}
+
+// removeExport removes the NFS-export with the path from the NFS-cluster.
+// ErrExportNotFound is returned when the export does not exist.
+func (nv *NFSVolume) removeExport(nfsCluster, path string) error {
 	nfsa, err := nv.conn.GetNFSAdmin()
 	if err != nil {
 		return fmt.Errorf("failed to get NFSAdmin: %w", err)
 	}
 
-	err = nfsa.RemoveExport(nfsCluster, nv.GetExportPath())
+	err = nfsa.RemoveExport(nfsCluster, path)
 	switch {
 	case err == nil:
 		return nil
@@ -233,27 +422,30 @@ func (nv *NFSVolume) DeleteExport() error {
 		return ErrExportNotFound
 	default: // any other error
 		return fmt.Errorf("failed to remove %q from NFS-cluster %q: "+
-			"%w", nv, nfsCluster, err)
+			"%w", path, nfsCluster, err)
 	}
 
 	// if we get here, the API call failed, fallback to the old command
 
 	// ceph nfs export delete <cluster_id> <pseudo_path>
-	cmd := nv.deleteExportCommand("delete", nfsCluster)
+	cmd := nv.deleteExportCommand("delete", nfsCluster, path)
 
 	_, stderr, err := util.ExecCommand(nv.ctx, "ceph", cmd...)
-	if err != nil {
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(stderr, "does not exist"):
+		return ErrExportNotFound
+	default:
 		return fmt.Errorf("failed to delete export %q from NFS-cluster"+
-			"%q (%v): %s", nv, nfsCluster, err, stderr)
+			"%q (%v): %s", path, nfsCluster, err, stderr)
 	}
-
-	return nil
 }
 
 // deleteExportCommand returns the "ceph nfs export delete ..." command
 // arguments (without "ceph"). Old releases of Ceph expect "delete" as cmd,
 // newer releases use "rm".
-func (nv *NFSVolume) deleteExportCommand(cmd, nfsCluster string) []string {
+func (nv *NFSVolume) deleteExportCommand(cmd, nfsCluster, path string) []string {
 	return []string{
 		"--id", nv.cr.ID,
 		"--keyfile=" + nv.cr.KeyFile,
@@ -262,16 +454,13 @@ func (nv *NFSVolume) deleteExportCommand(cmd, nfsCluster string) []string {
 		"export",
 		cmd,
 		nfsCluster,
-		nv.GetExportPath(),
+		path,
 	}
 }
 
-// getNFSCluster fetches the NFS-cluster name from the CephFS journal.
-func (nv *NFSVolume) getNFSCluster() (string, error) {
-	if !nv.connected {
-		return "", fmt.Errorf("can not get NFS-cluster for %q: %w", nv, ErrNotConnected)
-	}
-
+// getMetadataPool returns the metadata pool of the filesystem of the volume,
+// which contains the CephFS journal.
+func (nv *NFSVolume) getMetadataPool() (string, error) {
 	fs := fscore.NewFileSystem(nv.conn)
 	fsName, err := fs.GetFsName(nv.ctx, nv.fscID)
 	if err != nil && errors.Is(err, util.ErrPoolNotFound) {
@@ -287,6 +476,20 @@ func (nv *NFSVolume) getNFSCluster() (string, error) {
 		return "", fmt.Errorf("failed to get metadata pool for %q: %w", fsName, err)
 	}
 
+	return mdPool, nil
+}
+
+// getNFSCluster fetches the NFS-cluster name from the CephFS journal.
+func (nv *NFSVolume) getNFSCluster() (string, error) {
+	if !nv.connected {
+		return "", fmt.Errorf("can not get NFS-cluster for %q: %w", nv, ErrNotConnected)
+	}
+
+	mdPool, err := nv.getMetadataPool()
+	if err != nil {
+		return "", err
+	}
+
 	// Connect to cephfs' default radosNamespace (csi)
 	j, err := store.VolJournal.Connect(nv.mons, fsutil.RadosNamespace, nv.cr)
 	if err != nil {
@@ -310,19 +513,9 @@ func (nv *NFSVolume) setNFSCluster(clusterName string) error {
 		return fmt.Errorf("can not set NFS-cluster for %q: %w", nv, ErrNotConnected)
 	}
 
-	fs := fscore.NewFileSystem(nv.conn)
-	fsName, err := fs.GetFsName(nv.ctx, nv.fscID)
-	if err != nil && errors.Is(err, util.ErrPoolNotFound) {
-		return fmt.Errorf("%w for ID %x: %w", ErrFilesystemNotFound, nv.fscID, err)
-	} else if err != nil {
-		return fmt.Errorf("failed to get filesystem name for ID %x: %w", nv.fscID, err)
-	}
-
-	mdPool, err := fs.GetMetadataPool(nv.ctx, fsName)
-	if err != nil && errors.Is(err, util.ErrPoolNotFound) {
-		return fmt.Errorf("metadata pool for %q %w: %w", fsName, ErrNotFound, err)
-	} else if err != nil {
-		return fmt.Errorf("failed to get metadata pool for %q: %w", fsName, err)
+	mdPool, err := nv.getMetadataPool()
+	if err != nil {
+		return err
 	}
 
 	// Connect to cephfs' default radosNamespace (csi)
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/util"
@@ -40,8 +41,20 @@ const (
 	paramServer    = "server"
 	paramShare     = "share"
 	paramClusterID = "clusterID"
+
+	// staleExportRetries is the number of times a mount is retried when the
+	// NFS-server does not know the export. After a failover, the NFS-server
+	// may serve a stale export configuration until it is reloaded, or the
+	// provisioner created the missing export again.
+	staleExportRetries = 3
+	// staleExportRetryDelay is the time between the retries of a mount of a
+	// stale export.
+	staleExportRetryDelay = 5 * time.Second
 )
 
+// errStaleExport is returned while the mount of a stale export is retried.
+var errStaleExport = errors.New("export is not known by the NFS-server")
+
 // NodeServer struct of ceph CSI driver with supported methods of CSI
 // node server spec.
 type NodeServer struct {
@@ -98,6 +111,19 @@ func (ns *NodeServer) NodePublishVolume(
 		targetPath,
 		netNamespaceFilePath,
 		mountOptions)
+	if migrated, ok := getMigratedSource(volumeID, req.GetVolumeContext()); ok && isStaleExportError(err, "") {
+		// the volume was created by an older release, its legacy export
+		// may have been removed after it was migrated
+		log.WarningLog(ctx, "nfs: legacy export %q of volume %q is not available, mounting %q: %v",
+			source, volumeID, migrated, err)
+		source = migrated
+		err = ns.mountNFS(ctx,
+			volumeID,
+			source,
+			targetPath,
+			netNamespaceFilePath,
+			mountOptions)
+	}
 	if err != nil {
 		if os.IsPermission(err) {
 			return nil, status.Error(codes.PermissionDenied, err.Error())
@@ -230,11 +256,27 @@ func (ns *NodeServer) mountNFS(
 
 	log.DefaultLog("nfs: mounting volumeID(%v) source(%s) targetPath(%s) mountflags(%v)",
 		volumeID, source, mountPoint, mountOptions)
-	if netNamespaceFilePath != "" {
-		_, stderr, err = util.ExecuteCommandWithNSEnter(
-			ctx, netNamespaceFilePath, "mount", args...)
-	} else {
-		err = ns.Mounter.Mount(source, mountPoint, "nfs", mountOptions)
+	policy := util.RetryPolicy{
+		InitialInterval: staleExportRetryDelay,
+		MaxAttempts:     staleExportRetries + 1,
+	}
+	rErr := util.Retry(ctx, policy, func(ctx context.Context) error {
+		if netNamespaceFilePath != "" {
+			_, stderr, err = util.ExecuteCommandWithNSEnter(
+				ctx, netNamespaceFilePath, "mount", args...)
+		} else {
+			err = ns.Mounter.Mount(source, mountPoint, "nfs", mountOptions)
+		}
+		if !isStaleExportError(err, stderr) {
+			return nil
+		}
+
+		log.WarningLog(ctx, "nfs: export %q is not known by the NFS-server: %v %s", source, err, stderr)
+
+		return errStaleExport
+	})
+	if errors.Is(rErr, context.Canceled) || errors.Is(rErr, context.DeadlineExceeded) {
+		return fmt.Errorf("nfs: failed to mount %q to %q : %w", source, mountPoint, ctx.Err())
 	}
 	if err != nil {
 		return fmt.Errorf("nfs: failed to mount %q to %q : %w stderr: %q",
@@ -248,6 +290,22 @@ func (ns *NodeServer) mountNFS(
 	return err
 }
 
+// isStaleExportError returns true when a mount failed because the NFS-server
+// does not have the export (yet), which can be resolved by retrying.
+func isStaleExportError(err error, stderr string) bool {
+	if err == nil && stderr == "" {
+		return false
+	}
+
+	msg := stderr
+	if err != nil {
+		msg = err.Error() + " " + stderr
+	}
+
+	return strings.Contains(msg, "No such file or directory") ||
+		strings.Contains(msg, "access denied by server")
+}
+
 // validateNodePublishVolumeRequest validates node publish volume request.
 func validateNodePublishVolumeRequest(req *csi.NodePublishVolumeRequest) error {
 	switch {
@@ -282,3 +340,27 @@ func getSource(volContext map[string]string) (string, error) {
 
 	return fmt.Sprintf("%s:%s", server, baseDir), nil
 }
+
+// getMigratedSource returns the source of the export with the path that the
+// current release uses for the volume, when the volume context has the legacy
+// export path of an older release as share. False is returned when the share
+// is not a legacy export path.
+func getMigratedSource(volumeID string, volContext map[string]string) (string, bool) {
+	share := volContext[paramShare]
+	if share == "" || share == "/"+volumeID {
+		return "", false
+	}
+
+	migrated := make(map[string]string, len(volContext))
+	for k, v := range volContext {
+		migrated[k] = v
+	}
+	migrated[paramShare] = "/" + volumeID
+
+	source, err := getSource(migrated)
+	if err != nil {
+		return "", false
+	}
+
+	return source, true
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package nodeserver
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -170,3 +171,91 @@ func Test_getSource(t *testing.T) {
 		})
 	}
 }
+
+func Test_isStaleExportError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		err    error
+		stderr string
+		want   bool
+	}{
+		{
+			name: "mounted",
+			want: false,
+		},
+		{
+			name:   "export not found",
+			err:    errors.New("exit status 32"),
+			stderr: "mount.nfs: mounting 10.0.0.1:/0001-0009 failed, reason given by server: No such file or directory",
+			want:   true,
+		},
+		{
+			name: "access denied",
+			err:  errors.New("mount failed: exit status 32: mount.nfs: access denied by server while mounting"),
+			want: true,
+		},
+		{
+			name: "server unreachable",
+			err:  errors.New("mount failed: exit status 32: mount.nfs: Connection timed out"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := isStaleExportError(tt.err, tt.stderr); got != tt.want {
+				t.Errorf("isStaleExportError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getMigratedSource(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		volContext map[string]string
+		want       string
+		wantOK     bool
+	}{
+		{
+			name: "legacy export path",
+			volContext: map[string]string{
+				paramServer: "example.io",
+				paramShare:  "/csi-vol-1234",
+			},
+			want:   "example.io:/0001-0009-rook-ceph-0000000000000001-1234",
+			wantOK: true,
+		},
+		{
+			name: "current export path",
+			volContext: map[string]string{
+				paramServer: "example.io",
+				paramShare:  "/0001-0009-rook-ceph-0000000000000001-1234",
+			},
+			want:   "",
+			wantOK: false,
+		},
+		{
+			name: "missing server parameter",
+			volContext: map[string]string{
+				paramShare: "/csi-vol-1234",
+			},
+			want:   "",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := getMigratedSource("0001-0009-rook-ceph-0000000000000001-1234", tt.volContext)
+			if ok != tt.wantOK {
+				t.Errorf("getMigratedSource() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("getMigratedSource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,591 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/admin"
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer implements the admin.Service for RBD volumes.
+type AdminServer struct {
+	// volumeLocks are the locks of the volume IDs and request names of the
+	// ControllerServer, the journal is only modified while holding them
+	volumeLocks *util.VolumeLocks
+
+	// checkLeader fails when the ControllerServer of this process does not
+	// receive the CSI requests, it is replaced by the unit tests
+	checkLeader func(ctx context.Context) error
+}
+
+var _ admin.Service = &AdminServer{}
+
+// NewAdminServer returns an AdminServer that takes the volumeLocks of the
+// ControllerServer before modifying the journal. The volumeLocks are local to
+// the process, so the journal is only modified by the replica of the
+// provisioner that holds the lease of the external-provisioner of the driver.
+func NewAdminServer(driverName string, volumeLocks *util.VolumeLocks) *AdminServer {
+	return &AdminServer{
+		volumeLocks: volumeLocks,
+		checkLeader: func(ctx context.Context) error {
+			return checkProvisionerLeader(ctx, driverName)
+		},
+	}
+}
+
+// checkProvisionerLeader fails with FailedPrecondition when the pod does not
+// hold the lease of the external-provisioner of the driver. Outside of
+// Kubernetes there is a single provisioner.
+func checkProvisionerLeader(ctx context.Context, driverName string) error {
+	if !kubeclient.RunsOnKubernetes() {
+		return nil
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+	leader, holder, err := kubeclient.HoldsProvisionerLease(ctx, c, driverName)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to check the leader of the provisioner: %v", err)
+	}
+	if !leader {
+		return status.Errorf(codes.FailedPrecondition,
+			"the provisioner is not the leader, call the admin endpoint in pod %q", holder)
+	}
+
+	return nil
+}
+
+// adminVolume is a connection to the pool and the journal of the volume of
+// an admin request.
+type adminVolume struct {
+	volumeID       string
+	vi             util.CSIIdentifier
+	cr             *util.Credentials
+	monitors       string
+	radosNamespace string
+	pool           string
+	journal        *journal.Connection
+}
+
+// connectAdminVolume connects to the cluster of the volume, with the
+// credentials in the Secret of the request.
+func connectAdminVolume(ctx context.Context, req *admin.VolumeRequest) (*adminVolume, error) {
+	if req.VolumeID == "" || req.SecretName == "" || req.SecretNamespace == "" {
+		return nil, status.Error(codes.InvalidArgument, "volumeID, secretName and secretNamespace are required")
+	}
+
+	av := &adminVolume{volumeID: req.VolumeID}
+	err := av.vi.DecomposeCSIID(req.VolumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error decoding volume ID %q: %v", req.VolumeID, err)
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+	secrets, err := getSecret(c, req.SecretNamespace, req.SecretName)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to get the secret: %v", err)
+	}
+	av.cr, err = util.NewUserCredentials(secrets)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	av.monitors, _, err = util.GetMonsAndClusterID(ctx, av.vi.ClusterID, false)
+	if err == nil {
+		av.radosNamespace, err = util.GetRBDRadosNamespace(util.CsiConfigFile, av.vi.ClusterID)
+	}
+	if err != nil {
+		av.cr.DeleteCredentials()
+
+		return nil, status.Errorf(codes.NotFound, "failed to get configuration of cluster %q: %v", av.vi.ClusterID, err)
+	}
+
+	av.pool, err = util.GetPoolName(av.monitors, av.cr, av.vi.LocationID)
+	if err == nil {
+		av.journal, err = volJournal(ctx).Connect(av.monitors, av.radosNamespace, av.cr)
+	}
+	if err != nil {
+		av.cr.DeleteCredentials()
+		if errors.Is(err, util.ErrPoolNotFound) {
+			return nil, status.Errorf(codes.NotFound, "pool %d of volume %q does not exist", av.vi.LocationID, req.VolumeID)
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return av, nil
+}
+
+// Destroy closes the connection to the journal.
+func (av *adminVolume) Destroy() {
+	av.journal.Destroy()
+	av.cr.DeleteCredentials()
+}
+
+// image returns the unconnected image with the name in the pool of the
+// volume.
+func (av *adminVolume) image(name string) *rbdImage {
+	return &rbdImage{
+		Monitors:       av.monitors,
+		Pool:           av.pool,
+		RadosNamespace: av.radosNamespace,
+		RbdImageName:   name,
+		ClusterID:      av.vi.ClusterID,
+	}
+}
+
+// journalPool returns the name of the journal pool in the attributes, which
+// is the pool of the image when it is not set.
+func (av *adminVolume) journalPool(attrs *journal.ImageAttributes) (string, error) {
+	if attrs.JournalPoolID == util.InvalidPoolID {
+		return av.pool, nil
+	}
+
+	return util.GetPoolName(av.monitors, av.cr, attrs.JournalPoolID)
+}
+
+// pointsToVolume returns true when the reservation in journalPool refers to
+// the image of the volume.
+func (av *adminVolume) pointsToVolume(r *journal.Reservation, journalPool string) bool {
+	if r.ImageUUID != av.vi.ObjectUUID {
+		return false
+	}
+	if r.ImagePoolID == util.InvalidPoolID {
+		return journalPool == av.pool
+	}
+
+	return r.ImagePoolID == av.vi.LocationID
+}
+
+// findImage returns the name of the image of the volume in its pool. The
+// name of an image ends with its UUID, temporary clones and failover drill
+// images have a suffix after it. An empty name is returned when there is no
+// such image.
+func (av *adminVolume) findImage() (string, error) {
+	ri := av.image("")
+	err := ri.Connect(av.cr)
+	if err != nil {
+		return "", err
+	}
+	defer ri.Destroy(context.Background())
+
+	err = ri.openIoctx()
+	if err != nil {
+		return "", err
+	}
+
+	names, err := librbd.GetImageNames(ri.ioctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list images in pool %q: %w", av.pool, err)
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, av.vi.ObjectUUID) {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// revalidate reports the problems of the journal and the image of the
+// volume.
+func (av *adminVolume) revalidate(ctx context.Context) (*admin.VolumeReport, error) {
+	report := &admin.VolumeReport{
+		VolumeID: av.volumeID,
+		Pool:     av.pool,
+	}
+
+	attrs, err := av.journal.GetImageAttributes(ctx, av.pool, av.vi.ObjectUUID, false)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("UUID directory of %q does not exist in pool %q", av.vi.ObjectUUID, av.pool))
+		report.ImageName, err = av.findImage()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if report.ImageName == "" {
+			report.Problems = append(report.Problems, "the volume has no image")
+		}
+
+		return report, nil
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	report.RequestName = attrs.RequestName
+	report.ImageName = attrs.ImageName
+
+	if attrs.ReservationPending() {
+		problem := "the volume is being created"
+		if attrs.ReservationStale() {
+			problem = fmt.Sprintf("the creation of the volume stopped at %s", attrs.ReservedAt)
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+
+	journalPool, err := av.journalPool(attrs)
+	if err != nil {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("journal pool %d does not exist: %v", attrs.JournalPoolID, err))
+	} else {
+		var r *journal.Reservation
+		r, err = av.journal.GetReservation(ctx, journalPool, attrs.RequestName)
+		switch {
+		case errors.Is(err, util.ErrKeyNotFound), errors.Is(err, util.ErrPoolNotFound):
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("request name %q is not reserved in journal pool %q", attrs.RequestName, journalPool))
+		case err != nil:
+			return nil, status.Error(codes.Internal, err.Error())
+		case !av.pointsToVolume(r, journalPool):
+			report.Problems = append(report.Problems,
+				fmt.Sprintf("request name %q in journal pool %q points to image %q of pool %d",
+					attrs.RequestName, journalPool, r.ImageUUID, r.ImagePoolID))
+		}
+	}
+
+	ri := av.image(attrs.ImageName)
+	err = ri.Connect(av.cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer ri.Destroy(ctx)
+
+	image, err := ri.open()
+	if errors.Is(err, ErrImageNotFound) {
+		report.Problems = append(report.Problems, fmt.Sprintf("image %s does not exist", ri))
+
+		return report, nil
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer image.Close()
+
+	id, err := image.GetId()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get ID of image %s: %v", ri, err)
+	}
+	if attrs.ImageID != "" && attrs.ImageID != id {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("journal stores image ID %q, image %s has ID %q", attrs.ImageID, ri, id))
+	}
+
+	return report, nil
+}
+
+// RevalidateVolume checks that the UUID directory, the request name and the
+// image of the volume exist and point to each other.
+func (as *AdminServer) RevalidateVolume(
+	ctx context.Context,
+	req *admin.VolumeRequest,
+) (*admin.VolumeReport, error) {
+	av, err := connectAdminVolume(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer av.Destroy()
+
+	return av.revalidate(ctx)
+}
+
+// RebuildJournalEntry recreates the UUID directory and the request name of a
+// volume from its image. Encrypted images are refused, their KMS
+// configuration can not be recovered from the image. The volume is locked
+// before the journal is read, it is only rebuilt by the leader of the
+// provisioner, which holds the locks of the CSI requests.
+func (as *AdminServer) RebuildJournalEntry(
+	ctx context.Context,
+	req *admin.RebuildJournalEntryRequest,
+) (*admin.VolumeReport, error) {
+	err := as.checkLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// DeleteVolume takes the lock of the volume ID, CreateVolume the lock
+	// of the request name
+	if acquired := as.volumeLocks.TryAcquire(req.VolumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, req.VolumeID)
+	}
+	defer as.volumeLocks.Release(req.VolumeID)
+
+	av, err := connectAdminVolume(ctx, &req.VolumeRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer av.Destroy()
+
+	attrs, err := av.journal.GetImageAttributes(ctx, av.pool, av.vi.ObjectUUID, false)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		attrs = nil
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var imageName string
+	if attrs != nil {
+		imageName = attrs.ImageName
+	} else {
+		imageName, err = av.findImage()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if imageName == "" {
+		return nil, status.Errorf(codes.NotFound, "volume %q has no image in pool %q", av.volumeID, av.pool)
+	}
+
+	ri := av.image(imageName)
+	err = ri.Connect(av.cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer ri.Destroy(ctx)
+
+	image, err := ri.open()
+	if errors.Is(err, ErrImageNotFound) {
+		return nil, status.Errorf(codes.NotFound, "image %s of volume %q does not exist", ri, av.volumeID)
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	imageID, err := image.GetId()
+	if err != nil {
+		image.Close()
+
+		return nil, status.Errorf(codes.Internal, "failed to get ID of image %s: %v", ri, err)
+	}
+	metadata, err := image.ListMetadata()
+	image.Close()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list metadata of image %s: %v", ri, err)
+	}
+
+	if metadata[encryptionMetaKey] != "" || metadata[oldEncryptionMetaKey] != "" {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"image %s is encrypted, the journal entry can not be rebuilt", ri)
+	}
+
+	reqName := req.RequestName
+	if reqName == "" && attrs != nil {
+		reqName = attrs.RequestName
+	}
+	if reqName == "" {
+		reqName = kubeclient.GetPVName(metadata)
+	}
+	if reqName == "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"image %s has no PersistentVolume name in its metadata, requestName is required", ri)
+	}
+	if attrs != nil && attrs.RequestName != reqName {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"UUID directory of volume %q belongs to request %q", av.volumeID, attrs.RequestName)
+	}
+
+	journalPool := req.JournalPool
+	if journalPool == "" && attrs != nil {
+		journalPool, err = av.journalPool(attrs)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "journal pool %d does not exist: %v", attrs.JournalPoolID, err)
+		}
+	}
+	if journalPool == "" {
+		journalPool = av.pool
+	}
+
+	if acquired := as.volumeLocks.TryAcquire(reqName); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, reqName)
+	}
+	defer as.volumeLocks.Release(reqName)
+
+	r, err := av.journal.GetReservation(ctx, journalPool, reqName)
+	switch {
+	case errors.Is(err, util.ErrKeyNotFound):
+		r = nil
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	case !av.pointsToVolume(r, journalPool):
+		return nil, status.Errorf(codes.AlreadyExists,
+			"request name %q in journal pool %q points to image %q of pool %d",
+			reqName, journalPool, r.ImageUUID, r.ImagePoolID)
+	}
+
+	switch {
+	case attrs == nil:
+		err = av.reserve(ctx, journalPool, reqName, imageName, imageID)
+	case r == nil:
+		err = av.journal.RestoreReservation(ctx, journalPool, av.pool, av.vi.LocationID, reqName, av.vi.ObjectUUID)
+	case attrs.ImageID == "":
+		err = av.journal.StoreImageID(ctx, av.pool, av.vi.ObjectUUID, imageID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to rebuild journal entry of volume %q: %v", av.volumeID, err)
+	}
+	log.DefaultLog("rebuilt journal entry of volume %q for request %q in journal pool %q",
+		av.volumeID, reqName, journalPool)
+
+	return av.revalidate(ctx)
+}
+
+// reserve creates the UUID directory and the request name of the existing
+// image of the volume.
+func (av *adminVolume) reserve(ctx context.Context, journalPool, reqName, imageName, imageID string) error {
+	journalPoolID, err := util.GetPoolID(av.monitors, av.cr, journalPool)
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(imageName, av.vi.ObjectUUID)
+	_, _, err = av.journal.ReserveName(ctx, journalPool, journalPoolID, av.pool, av.vi.LocationID,
+		reqName, prefix, "", "", av.vi.ObjectUUID, "", "", util.EncryptionTypeNone)
+	if err != nil {
+		return err
+	}
+
+	return av.journal.StoreImageID(ctx, av.pool, av.vi.ObjectUUID, imageID)
+}
+
+// ListOrphanedReservations lists the reservations in the journal pool that
+// do not have an image. Reservations of volumes that are being created are
+// skipped until they are stale.
+func (as *AdminServer) ListOrphanedReservations(
+	ctx context.Context,
+	req *admin.ListOrphanedReservationsRequest,
+) (*admin.ListOrphanedReservationsResponse, error) {
+	if req.ClusterID == "" || req.JournalPool == "" || req.SecretName == "" || req.SecretNamespace == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"clusterID, journalPool, secretName and secretNamespace are required")
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+
+	lc, err := connectListVolumesSource(ctx, c, &listVolumesSource{
+		ClusterID:       req.ClusterID,
+		JournalPool:     req.JournalPool,
+		SecretName:      req.SecretName,
+		SecretNamespace: req.SecretNamespace,
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, req.JournalPool, "", 0)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &admin.ListOrphanedReservationsResponse{
+		Reservations: []admin.OrphanedReservation{},
+	}
+	for _, r := range reservations {
+		orphan, cErr := lc.checkReservation(ctx, r)
+		if cErr != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check reservation %q: %v", r.RequestName, cErr)
+		}
+		if orphan != nil {
+			resp.Reservations = append(resp.Reservations, *orphan)
+		}
+	}
+
+	return resp, nil
+}
+
+// checkReservation returns the reservation as an OrphanedReservation when it
+// does not have an image, or nil when it is a volume.
+func (lc *listVolumesConnection) checkReservation(
+	ctx context.Context,
+	r journal.Reservation,
+) (*admin.OrphanedReservation, error) {
+	orphan := &admin.OrphanedReservation{
+		RequestName: r.RequestName,
+		ImageUUID:   r.ImageUUID,
+		ImagePool:   lc.source.JournalPool,
+	}
+
+	var err error
+	if r.ImagePoolID != util.InvalidPoolID {
+		orphan.ImagePool, err = util.GetPoolName(lc.monitors, lc.cr, r.ImagePoolID)
+		if errors.Is(err, util.ErrPoolNotFound) {
+			orphan.ImagePool = ""
+			orphan.Reason = fmt.Sprintf("image pool %d does not exist", r.ImagePoolID)
+
+			return orphan, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	attrs, err := lc.journal.GetImageAttributes(ctx, orphan.ImagePool, r.ImageUUID, false)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		orphan.Reason = "UUID directory does not exist"
+
+		return orphan, nil
+	} else if err != nil {
+		return nil, err
+	}
+	orphan.ImageName = attrs.ImageName
+	if attrs.RequestName != r.RequestName {
+		orphan.Reason = fmt.Sprintf("UUID directory belongs to request %q", attrs.RequestName)
+
+		return orphan, nil
+	}
+	if attrs.ReservationPending() && !attrs.ReservationStale() {
+		return nil, nil
+	}
+
+	ri := &rbdImage{
+		Monitors:       lc.monitors,
+		Pool:           orphan.ImagePool,
+		RadosNamespace: lc.radosNamespace,
+		RbdImageName:   attrs.ImageName,
+		ClusterID:      lc.source.ClusterID,
+	}
+	err = ri.Connect(lc.cr)
+	if err != nil {
+		return nil, err
+	}
+	defer ri.Destroy(ctx)
+
+	err = ri.getImageID()
+	if errors.Is(err, ErrImageNotFound) {
+		orphan.Reason = "image does not exist"
+
+		return orphan, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
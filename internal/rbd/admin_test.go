@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/admin"
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnectAdminVolumeValidation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	for _, req := range []*admin.VolumeRequest{
+		{},
+		{VolumeID: "vol-1", SecretName: "csi-rbd-secret"},
+		{VolumeID: "vol-1", SecretNamespace: "ceph-csi"},
+		{SecretName: "csi-rbd-secret", SecretNamespace: "ceph-csi"},
+		// not a volume ID of the driver
+		{VolumeID: "vol-1", SecretName: "csi-rbd-secret", SecretNamespace: "ceph-csi"},
+	} {
+		_, err := connectAdminVolume(ctx, req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "request %+v", req)
+	}
+}
+
+func TestRebuildJournalEntryLocking(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	req := &admin.RebuildJournalEntryRequest{
+		VolumeRequest: admin.VolumeRequest{VolumeID: "vol-1"},
+	}
+
+	// a replica that is not the leader does not hold the locks of the CSI
+	// requests
+	as := &AdminServer{
+		volumeLocks: util.NewVolumeLocks(),
+		checkLeader: func(context.Context) error {
+			return status.Error(codes.FailedPrecondition, "not the leader")
+		},
+	}
+	_, err := as.RebuildJournalEntry(ctx, req)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the volume is locked before the journal is read
+	as.checkLeader = func(context.Context) error { return nil }
+	require.True(t, as.volumeLocks.TryAcquire("vol-1"))
+	_, err = as.RebuildJournalEntry(ctx, req)
+	require.Equal(t, codes.Aborted, status.Code(err))
+	as.volumeLocks.Release("vol-1")
+
+	// the lock is released after the request failed
+	_, err = as.RebuildJournalEntry(ctx, req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.True(t, as.volumeLocks.TryAcquire("vol-1"))
+}
+
+func TestAdminVolumePointsToVolume(t *testing.T) {
+	t.Parallel()
+
+	av := &adminVolume{pool: "rbd"}
+	av.vi.ObjectUUID = "1234"
+	av.vi.LocationID = 3
+
+	tests := []struct {
+		name        string
+		r           journal.Reservation
+		journalPool string
+		want        bool
+	}{
+		{
+			name:        "image in the journal pool",
+			r:           journal.Reservation{ImageUUID: "1234", ImagePoolID: util.InvalidPoolID},
+			journalPool: "rbd",
+			want:        true,
+		},
+		{
+			name:        "image in another pool than the journal pool",
+			r:           journal.Reservation{ImageUUID: "1234", ImagePoolID: util.InvalidPoolID},
+			journalPool: "replicapool",
+			want:        false,
+		},
+		{
+			name:        "image in the pool of the volume",
+			r:           journal.Reservation{ImageUUID: "1234", ImagePoolID: 3},
+			journalPool: "replicapool",
+			want:        true,
+		},
+		{
+			name:        "image in another pool",
+			r:           journal.Reservation{ImageUUID: "1234", ImagePoolID: 4},
+			journalPool: "replicapool",
+			want:        false,
+		},
+		{
+			name:        "other image",
+			r:           journal.Reservation{ImageUUID: "5678", ImagePoolID: 3},
+			journalPool: "rbd",
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, av.pointsToVolume(&tt.r, tt.journalPool))
+		})
+	}
+}
+
+func TestAdminVolumeJournalPool(t *testing.T) {
+	t.Parallel()
+
+	// the journal is in the pool of the image when no pool is recorded
+	av := &adminVolume{pool: "rbd"}
+	pool, err := av.journalPool(&journal.ImageAttributes{JournalPoolID: util.InvalidPoolID})
+	require.NoError(t, err)
+	require.Equal(t, "rbd", pool)
+}
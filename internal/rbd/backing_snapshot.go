@@ -0,0 +1,336 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/reftracker"
+	rterrors "github.com/ceph/ceph-csi/internal/util/reftracker/errors"
+	"github.com/ceph/ceph-csi/internal/util/reftracker/radoswrapper"
+	"github.com/ceph/ceph-csi/internal/util/reftracker/reftype"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backingSnapshotParam is the StorageClass parameter that creates read-only
+// volumes from a snapshot without cloning an image, the nodes map the
+// snapshot itself.
+const backingSnapshotParam = "backingSnapshot"
+
+func fmtBackingSnapshotReftrackerName(backingSnapID string) string {
+	return "rt-backingsnapshot-" + backingSnapID
+}
+
+// isBackingSnapshotRequest returns true when the volume of the request is
+// backed by its source snapshot. validateBackingSnapshotRequest checks the
+// parameter before.
+func isBackingSnapshotRequest(req *csi.CreateVolumeRequest) bool {
+	backingSnapshot, err := strconv.ParseBool(req.GetParameters()[backingSnapshotParam])
+
+	return err == nil && backingSnapshot
+}
+
+// validateBackingSnapshotRequest returns an InvalidArgument error when the
+// request asks for a snapshot-backed volume that can be written to, or that is
+// not created from a snapshot.
+func validateBackingSnapshotRequest(req *csi.CreateVolumeRequest) error {
+	value, ok := req.GetParameters()[backingSnapshotParam]
+	if !ok {
+		return nil
+	}
+
+	backingSnapshot, err := strconv.ParseBool(value)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to parse %s: %v", backingSnapshotParam, err)
+	}
+	if !backingSnapshot {
+		return nil
+	}
+
+	if req.GetVolumeContentSource().GetSnapshot() == nil {
+		return status.Errorf(codes.InvalidArgument, "%s requires a snapshot as volume content source",
+			backingSnapshotParam)
+	}
+	for _, capability := range req.GetVolumeCapabilities() {
+		if !csicommon.IsReaderOnly([]*csi.VolumeCapability{capability}) {
+			return status.Errorf(codes.InvalidArgument, "%s is only supported with read-only access modes",
+				backingSnapshotParam)
+		}
+	}
+
+	return nil
+}
+
+// createSnapshotBackedVolume reserves a volume that maps rbdSnap read-only on
+// the nodes, instead of creating an image from the snapshot. The snapshot is
+// not deleted until the last volume backed by it is deleted.
+func (cs *ControllerServer) createSnapshotBackedVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+	cr *util.Credentials,
+	rbdVol *rbdVolume,
+	rbdSnap *rbdSnapshot,
+) (*csi.CreateVolumeResponse, error) {
+	var err error
+	switch {
+	case rbdSnap.groupID != "":
+		return nil, status.Errorf(codes.InvalidArgument,
+			"snapshot %s of volume group %s can not back a volume", rbdSnap, rbdSnap.groupID)
+	case rbdSnap.isBlockEncrypted(), rbdSnap.isFileEncrypted(), rbdVol.isBlockEncrypted(), rbdVol.isFileEncrypted():
+		return nil, status.Errorf(codes.InvalidArgument, "encrypted volumes can not be backed by snapshot %s", rbdSnap)
+	case rbdVol.VolSize > rbdSnap.VolSize:
+		return nil, status.Errorf(codes.OutOfRange,
+			"size %d of snapshot-backed volume can not be larger than the size %d of snapshot %s",
+			rbdVol.VolSize, rbdSnap.VolSize, rbdSnap)
+	}
+	rbdVol.BackingSnapshotID = rbdSnap.VolID
+	rbdVol.VolSize = rbdSnap.VolSize
+
+	found, err := rbdVol.Exists(ctx, nil)
+	if err != nil {
+		return nil, getGRPCErrorForCreateVolume(err)
+	}
+	if !found {
+		err = reserveVol(ctx, rbdVol, cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		defer func() {
+			if err != nil {
+				errDefer := undoVolReservation(ctx, rbdVol, cr)
+				if errDefer != nil {
+					log.WarningLog(ctx, "failed undoing reservation of volume: %s (%s)", req.GetName(), errDefer)
+				}
+			}
+		}()
+	}
+
+	// the snapshot can not be deleted while the reference is added
+	if err = cs.OperationLocks.GetRestoreLock(rbdSnap.VolID); err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer cs.OperationLocks.ReleaseRestoreLock(rbdSnap.VolID)
+
+	// the reference is added again for a volume that was found, in case the
+	// provisioner stopped before it was added
+	err = addSnapshotBackedVolumeRef(ctx, rbdSnap, rbdVol.VolID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	completeVolReservation(ctx, rbdVol, cr)
+
+	log.DebugLog(ctx, "volume %s is backed by snapshot %s", rbdVol.VolID, rbdSnap)
+
+	return buildCreateVolumeResponse(ctx, req, rbdVol)
+}
+
+// deleteSnapshotBackedVolume removes the reference of the volume from its
+// backing snapshot, and deletes the snapshot when it was the last reference
+// and the snapshot itself was deleted already.
+func (cs *ControllerServer) deleteSnapshotBackedVolume(
+	ctx context.Context,
+	rbdVol *rbdVolume,
+	cr *util.Credentials,
+	secrets map[string]string,
+) (*csi.DeleteVolumeResponse, error) {
+	if acquired := cs.VolumeLocks.TryAcquire(rbdVol.RequestName); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, rbdVol.RequestName)
+
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, rbdVol.RequestName)
+	}
+	defer cs.VolumeLocks.Release(rbdVol.RequestName)
+
+	snapshotID := rbdVol.BackingSnapshotID
+	if acquired := cs.SnapshotLocks.TryAcquire(snapshotID); !acquired {
+		log.ErrorLog(ctx, util.SnapshotOperationAlreadyExistsFmt, snapshotID)
+
+		return nil, status.Errorf(codes.Aborted, util.SnapshotOperationAlreadyExistsFmt, snapshotID)
+	}
+	defer cs.SnapshotLocks.Release(snapshotID)
+
+	rbdSnap, err := genSnapFromSnapID(ctx, snapshotID, cr, secrets)
+	switch {
+	case err == nil:
+		defer rbdSnap.Destroy(ctx)
+
+		var deleted bool
+		deleted, err = unrefSnapshotBackedVolume(ctx, rbdSnap, rbdVol.VolID)
+		if err != nil {
+			if errors.Is(err, rterrors.ErrObjectOutOfDate) {
+				return nil, status.Error(codes.Aborted, err.Error())
+			}
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if deleted {
+			log.DebugLog(ctx, "deleting snapshot %s, volume %s was the last one backed by it", rbdSnap, rbdVol.VolID)
+
+			err = rbdSnap.Delete(ctx)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+	case errors.Is(err, util.ErrKeyNotFound), errors.Is(err, util.ErrPoolNotFound), errors.Is(err, ErrImageNotFound):
+		log.WarningLog(ctx, "backing snapshot %s of volume %s was deleted already: %v", snapshotID, rbdVol.VolID, err)
+	default:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = undoVolReservation(ctx, rbdVol, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// genSnapshotBackedVolume returns the volume that maps the backing snapshot
+// of rv, which was returned by GenVolFromVolID with ErrSnapshotBackedVolume.
+func genSnapshotBackedVolume(
+	ctx context.Context,
+	req *csi.NodeStageVolumeRequest,
+	rv *rbdVolume,
+	cr *util.Credentials,
+) (*rbdVolume, error) {
+	defer rv.Destroy(ctx)
+
+	if !csicommon.IsReaderOnly([]*csi.VolumeCapability{req.GetVolumeCapability()}) {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot-backed volume %s can only be staged read-only",
+			req.GetVolumeId())
+	}
+
+	rbdSnap, err := genSnapFromSnapID(ctx, rv.BackingSnapshotID, cr, req.GetSecrets())
+	if err != nil {
+		log.ErrorLog(ctx, "failed to get backing snapshot %s of volume %s: %v",
+			rv.BackingSnapshotID, req.GetVolumeId(), err)
+
+		return nil, status.Errorf(codes.Internal, "failed to get backing snapshot %s of volume %s: %v",
+			rv.BackingSnapshotID, req.GetVolumeId(), err)
+	}
+	defer rbdSnap.Destroy(ctx)
+
+	vol := rbdSnap.toVolume()
+	vol.BackingSnapshotID = rbdSnap.VolID
+	vol.mapSnapName = rbdSnap.RbdSnapName
+
+	return vol, nil
+}
+
+// mapSpec returns the image-spec of the image that is mapped on the node, or
+// the snap-spec for a snapshot-backed volume.
+func (rv *rbdVolume) mapSpec() string {
+	if rv.mapSnapName == "" {
+		return rv.String()
+	}
+
+	return rv.String() + "@" + rv.mapSnapName
+}
+
+// addSnapshotBackedVolumeRef adds the references of the snapshot itself and
+// of the volume backed by it to the reftracker of the snapshot.
+func addSnapshotBackedVolumeRef(ctx context.Context, rbdSnap *rbdSnapshot, volID string) error {
+	ioctx, err := rbdSnap.conn.GetIoctx(rbdSnap.JournalPool)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to create RADOS ioctx: %s", err)
+
+		return err
+	}
+	defer ioctx.Destroy()
+
+	ioctx.SetNamespace(rbdSnap.RadosNamespace)
+
+	_, err = reftracker.Add(
+		radoswrapper.NewIOContext(ioctx),
+		fmtBackingSnapshotReftrackerName(rbdSnap.VolID),
+		map[string]struct{}{
+			rbdSnap.VolID: {},
+			volID:         {},
+		},
+	)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to add refs for backing snapshot %s: %v", rbdSnap.VolID, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// unrefSnapshotBackedVolume removes the reference of the volume from the
+// reftracker of its backing snapshot. The returned boolean value signals
+// whether the snapshot is not referenced anymore and needs to be removed.
+func unrefSnapshotBackedVolume(ctx context.Context, rbdSnap *rbdSnapshot, volID string) (bool, error) {
+	ioctx, err := rbdSnap.conn.GetIoctx(rbdSnap.JournalPool)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to create RADOS ioctx: %s", err)
+
+		return false, err
+	}
+	defer ioctx.Destroy()
+
+	ioctx.SetNamespace(rbdSnap.RadosNamespace)
+
+	deleted, err := reftracker.Remove(
+		radoswrapper.NewIOContext(ioctx),
+		fmtBackingSnapshotReftrackerName(rbdSnap.VolID),
+		map[string]reftype.RefType{
+			volID: reftype.Normal,
+		},
+	)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to remove refs for backing snapshot %s: %v", rbdSnap.VolID, err)
+
+		return false, err
+	}
+
+	return deleted, nil
+}
+
+// unrefSelfInSnapshotBackedVolumes removes (masks) the snapshot ID in the
+// reftracker for volumes backed by this snapshot. The returned boolean value
+// signals whether the snapshot is not referenced by any such volumes and
+// needs to be removed.
+func unrefSelfInSnapshotBackedVolumes(ctx context.Context, rbdSnap *rbdSnapshot) (bool, error) {
+	ioctx, err := rbdSnap.conn.GetIoctx(rbdSnap.JournalPool)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to create RADOS ioctx: %s", err)
+
+		return false, err
+	}
+	defer ioctx.Destroy()
+
+	ioctx.SetNamespace(rbdSnap.RadosNamespace)
+
+	return reftracker.Remove(
+		radoswrapper.NewIOContext(ioctx),
+		fmtBackingSnapshotReftrackerName(rbdSnap.VolID),
+		map[string]reftype.RefType{
+			rbdSnap.VolID: reftype.Mask,
+		},
+	)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateBackingSnapshotRequest(t *testing.T) {
+	t.Parallel()
+
+	newCapability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		}
+	}
+	newRequest := func(backingSnapshot string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       "pvc-1234",
+			Parameters: map[string]string{backingSnapshotParam: backingSnapshot},
+			VolumeCapabilities: []*csi.VolumeCapability{
+				newCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1234"},
+				},
+			},
+		}
+	}
+
+	req := newRequest("true")
+	require.NoError(t, validateBackingSnapshotRequest(req))
+	require.True(t, isBackingSnapshotRequest(req))
+
+	req = newRequest("false")
+	req.VolumeContentSource = nil
+	require.NoError(t, validateBackingSnapshotRequest(req))
+	require.False(t, isBackingSnapshotRequest(req))
+
+	req = newRequest("")
+	delete(req.Parameters, backingSnapshotParam)
+	require.NoError(t, validateBackingSnapshotRequest(req))
+	require.False(t, isBackingSnapshotRequest(req))
+
+	tests := map[string]func(req *csi.CreateVolumeRequest){
+		"invalid value":     func(req *csi.CreateVolumeRequest) { req.Parameters[backingSnapshotParam] = "yes please" },
+		"no content source": func(req *csi.CreateVolumeRequest) { req.VolumeContentSource = nil },
+		"volume source": func(req *csi.CreateVolumeRequest) {
+			req.VolumeContentSource = &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "vol-1234"},
+				},
+			}
+		},
+		"writer": func(req *csi.CreateVolumeRequest) {
+			req.VolumeCapabilities = append(req.VolumeCapabilities,
+				newCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER))
+		},
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := newRequest("true")
+			modify(req)
+			err := validateBackingSnapshotRequest(req)
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestSnapshotBackedVolumeMapSpec(t *testing.T) {
+	t.Parallel()
+
+	rv := &rbdVolume{}
+	rv.Pool = "rbd"
+	rv.RadosNamespace = "ns"
+	rv.RbdImageName = "csi-snap-1234"
+	require.Equal(t, "rbd/ns/csi-snap-1234", rv.mapSpec())
+
+	rv.mapSnapName = "csi-snap-1234"
+	require.Equal(t, "rbd/ns/csi-snap-1234@csi-snap-1234", rv.mapSpec())
+
+	// the mapping is released by NodeUnstageVolume with the spec of the stash
+	imgMeta := rbdImageMetadataStash{
+		Pool:           rv.Pool,
+		RadosNamespace: rv.RadosNamespace,
+		ImageName:      rv.RbdImageName,
+		SnapName:       rv.mapSnapName,
+	}
+	require.Equal(t, rv.mapSpec(), imgMeta.String())
+}
@@ -121,13 +121,19 @@ func (rv *rbdVolume) generateTempClone() *rbdVolume {
 	// The temp cloned image name will be always (rbd image name + "-temp")
 	// this name will be always unique, as cephcsi never creates an image with
 	// this format for new rbd images
-	tempClone.RbdImageName = rv.RbdImageName + "-temp"
+	tempClone.RbdImageName = tempCloneName(rv.RbdImageName)
 
 	return &tempClone
 }
 
+// tempCloneName returns the name of the temporary clone of the image with
+// imageName.
+func tempCloneName(imageName string) string {
+	return imageName + tempCloneSuffix
+}
+
 func (rv *rbdVolume) createCloneFromImage(ctx context.Context, parentVol *rbdVolume) error {
-	j, err := volJournal.Connect(rv.Monitors, rv.RadosNamespace, rv.conn.Creds)
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, rv.conn.Creds)
 	if err != nil {
 		return status.Error(codes.Internal, err.Error())
 	}
@@ -0,0 +1,354 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// attachmentsMetaKey is the key in the image metadata with the nodes that
+// the volume is published to, as a JSON encoded attachmentsRecord.
+const attachmentsMetaKey = "rbd.csi.ceph.com/attachments"
+
+// singleNodeAccessModes are the access modes that allow a volume to be
+// published to a single node only.
+var singleNodeAccessModes = []csi.VolumeCapability_AccessMode_Mode{
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+}
+
+// errStaticVolume is returned by genPublishVolume for static volumes, the
+// attachments of their images are not tracked.
+var errStaticVolume = errors.New("volume is a static volume")
+
+// attachments are the nodes that a volume is published to, with the name of
+// the access mode of the publish request.
+type attachments map[string]string
+
+// publishVolume records the node of the request in the metadata of the
+// image. A volume with a single node access mode is only published to one
+// node, a request for another node fails with FailedPrecondition and names
+// the node that the volume is published to, before the staging on the node
+// fails on the watchers of the image.
+func (cs *ControllerServer) publishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) error {
+	if req.GetVolumeContext()[staticVol] == "true" {
+		return nil
+	}
+
+	volumeID := req.GetVolumeId()
+	nodeID := req.GetNodeId()
+	mode := req.GetVolumeCapability().GetAccessMode().GetMode()
+
+	return cs.updateAttachments(ctx, volumeID, req.GetSecrets(), func(a attachments) (bool, error) {
+		err := a.check(nodeID, mode)
+		if err != nil {
+			return false, status.Errorf(codes.FailedPrecondition, "volume %s %v", volumeID, err)
+		}
+		if a[nodeID] == mode.String() {
+			return false, nil
+		}
+		a[nodeID] = mode.String()
+		log.DebugLog(ctx, "publishing volume %s to node %q with access mode %s", volumeID, nodeID, mode)
+
+		return true, nil
+	})
+}
+
+// unpublishVolume removes the node of the request from the metadata of the
+// image. All nodes are removed when the request has no node ID.
+func (cs *ControllerServer) unpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) error {
+	volumeID := req.GetVolumeId()
+	nodeID := req.GetNodeId()
+
+	err := cs.updateAttachments(ctx, volumeID, req.GetSecrets(), func(a attachments) (bool, error) {
+		if nodeID == "" {
+			clear(a)
+
+			return true, nil
+		}
+		if _, ok := a[nodeID]; !ok {
+			return false, nil
+		}
+		delete(a, nodeID)
+		log.DebugLog(ctx, "unpublishing volume %s from node %q", volumeID, nodeID)
+
+		return true, nil
+	})
+	// a deleted volume is not published anymore
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+
+	return err
+}
+
+// updateAttachments passes the attachments of the image of the volume to
+// update, and stores them when update returns true. The metadata is removed
+// from the image when the volume is not published to any node anymore.
+func (cs *ControllerServer) updateAttachments(
+	ctx context.Context,
+	volumeID string,
+	secrets map[string]string,
+	update func(attachments) (bool, error),
+) error {
+	if acquired := cs.VolumeLocks.TryAcquire(volumeID); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, volumeID)
+
+		return status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer cs.VolumeLocks.Release(volumeID)
+
+	rbdVol, cr, err := cs.genPublishVolume(ctx, volumeID, secrets)
+	if errors.Is(err, ErrSnapshotBackedVolume) || errors.Is(err, ErrLVMVolume) || errors.Is(err, errStaticVolume) {
+		// snapshot-backed volumes are read-only, any number of nodes can
+		// map their snapshot, logical volumes do not have an image to
+		// record the nodes in, and static volumes are managed by the admin
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+	defer rbdVol.Destroy(ctx)
+
+	a, err := rbdVol.getAttachments()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to get attachments of image %s: %v", rbdVol, err)
+
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	updated, err := update(a)
+	if err != nil || !updated {
+		return err
+	}
+
+	err = rbdVol.setAttachments(a)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to store attachments of image %s: %v", rbdVol, err)
+
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return nil
+}
+
+// genPublishVolume returns the volume of a ControllerPublishVolume or
+// ControllerUnpublishVolume request. The publish secrets of the StorageClass
+// are optional, the provisioner secrets of a StorageClass for the cluster of
+// the volume are used when the request has no secrets. Static volumes, that
+// have the name of their image as volume ID, return errStaticVolume.
+func (cs *ControllerServer) genPublishVolume(
+	ctx context.Context,
+	volumeID string,
+	secrets map[string]string,
+) (*rbdVolume, *util.Credentials, error) {
+	if isStaticVolID(volumeID) {
+		return nil, nil, errStaticVolume
+	}
+
+	var err error
+	if len(secrets) == 0 {
+		var vi util.CSIIdentifier
+		err = vi.DecomposeCSIID(volumeID)
+		if err != nil {
+			return nil, nil, status.Errorf(codes.FailedPrecondition,
+				"no secrets in the request for volume %q: %v", volumeID, err)
+		}
+
+		c, cErr := kubeclient.NewK8sClient()
+		if cErr != nil {
+			return nil, nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", cErr)
+		}
+
+		secrets, err = cs.getStorageClassSecrets(ctx, c, vi.ClusterID, volumeID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	secrets, err = tenantSecrets(ctx, volumeID, secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cr, err := util.NewUserCredentialsWithMigration(secrets)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rbdVol, err := genVolFromVolIDWithMigration(ctx, volumeID, cr, secrets)
+	if err != nil {
+		cr.DeleteCredentials()
+
+		switch {
+		case errors.Is(err, ErrSnapshotBackedVolume), errors.Is(err, ErrLVMVolume):
+			return nil, nil, err
+		case errors.Is(err, ErrImageNotFound), errors.Is(err, util.ErrPoolNotFound):
+			return nil, nil, status.Errorf(codes.NotFound, "volume ID %s not found: %v", volumeID, err)
+		default:
+			log.ErrorLog(ctx, "failed to get backend volume for %s: %v", volumeID, err)
+
+			return nil, nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return rbdVol, cr, nil
+}
+
+// isStaticVolID returns true when the volume ID is neither a CSI ID nor the
+// ID of a migrated in-tree volume, as for a static volume.
+func isStaticVolID(volumeID string) bool {
+	if isMigrationVolID(volumeID) {
+		return false
+	}
+
+	var vi util.CSIIdentifier
+
+	return vi.DecomposeCSIID(volumeID) != nil
+}
+
+// check returns an error when the volume can not be published to nodeID with
+// the access mode, because it is published to other nodes.
+func (a attachments) check(nodeID string, mode csi.VolumeCapability_AccessMode_Mode) error {
+	others := []string{}
+	singleNode := slices.Contains(singleNodeAccessModes, mode)
+	for node, nodeMode := range a {
+		if node == nodeID {
+			continue
+		}
+
+		others = append(others, fmt.Sprintf("%q (%s)", node, nodeMode))
+		m := csi.VolumeCapability_AccessMode_Mode(csi.VolumeCapability_AccessMode_Mode_value[nodeMode])
+		if slices.Contains(singleNodeAccessModes, m) {
+			singleNode = true
+		}
+	}
+
+	if !singleNode || len(others) == 0 {
+		return nil
+	}
+	slices.Sort(others)
+
+	return fmt.Errorf("is published to node %s, it can not be published to node %q with access mode %s "+
+		"until it is unpublished from the other node", strings.Join(others, ", "), nodeID, mode)
+}
+
+// attachmentsRecord is the value of the attachmentsMetaKey metadata. The
+// metadata of an image is copied into its clones, and by mirroring into the
+// image of the peer cluster. The attachments are only valid for the image
+// with the ID in the cluster with the fsid of the record, other images
+// ignore them.
+type attachmentsRecord struct {
+	FSID    string      `json:"fsid"`
+	ImageID string      `json:"imageID"`
+	Nodes   attachments `json:"nodes"`
+}
+
+// newAttachmentsRecord returns the record of the attachments of the image.
+func (ri *rbdImage) newAttachmentsRecord(a attachments) (*attachmentsRecord, error) {
+	fsID, err := ri.conn.GetFSID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fsid of the cluster of image %s: %w", ri, err)
+	}
+
+	err = ri.getImageID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ID of image %s: %w", ri, err)
+	}
+
+	return &attachmentsRecord{
+		FSID:    fsID,
+		ImageID: ri.ImageID,
+		Nodes:   a,
+	}, nil
+}
+
+// owns returns true when the attachments were recorded for the image of
+// other.
+func (r *attachmentsRecord) owns(other *attachmentsRecord) bool {
+	return r.FSID == other.FSID && r.ImageID == other.ImageID
+}
+
+// getAttachments returns the nodes that the volume is published to. The
+// attachments that were copied from another image are ignored, they are
+// replaced by the next update of the attachments.
+func (ri *rbdImage) getAttachments() (attachments, error) {
+	value, err := ri.GetMetadata(attachmentsMetaKey)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return attachments{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored := &attachmentsRecord{}
+	err = json.Unmarshal([]byte(value), stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metadata %q of image %s: %w", attachmentsMetaKey, ri, err)
+	}
+
+	current, err := ri.newAttachmentsRecord(attachments{})
+	if err != nil {
+		return nil, err
+	}
+
+	if !current.owns(stored) || stored.Nodes == nil {
+		return attachments{}, nil
+	}
+
+	return stored.Nodes, nil
+}
+
+// setAttachments stores the nodes that the volume is published to.
+func (ri *rbdImage) setAttachments(a attachments) error {
+	if len(a) == 0 {
+		err := ri.RemoveMetadata(attachmentsMetaKey)
+		if errors.Is(err, librbd.ErrNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	record, err := ri.newAttachmentsRecord(a)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attachments of image %s: %w", ri, err)
+	}
+
+	return ri.SetMetadata(attachmentsMetaKey, string(value))
+}
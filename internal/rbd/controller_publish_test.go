@@ -0,0 +1,114 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/util"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachmentsCheck(t *testing.T) {
+	t.Parallel()
+
+	rwo := csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	rwx := csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+
+	tests := []struct {
+		name        string
+		attachments attachments
+		nodeID      string
+		mode        csi.VolumeCapability_AccessMode_Mode
+		wantErr     bool
+	}{
+		{
+			name:        "not published",
+			attachments: attachments{},
+			nodeID:      "node-a",
+			mode:        rwo,
+		},
+		{
+			name:        "published to the same node",
+			attachments: attachments{"node-a": rwo.String()},
+			nodeID:      "node-a",
+			mode:        rwo,
+		},
+		{
+			name:        "single node published to another node",
+			attachments: attachments{"node-a": rwo.String()},
+			nodeID:      "node-b",
+			mode:        rwo,
+			wantErr:     true,
+		},
+		{
+			name:        "multi node published single node to another node",
+			attachments: attachments{"node-a": rwo.String()},
+			nodeID:      "node-b",
+			mode:        rwx,
+			wantErr:     true,
+		},
+		{
+			name:        "multi node published to other nodes",
+			attachments: attachments{"node-a": rwx.String(), "node-c": rwx.String()},
+			nodeID:      "node-b",
+			mode:        rwx,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.attachments.check(tt.nodeID, tt.mode)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAttachmentsRecordOwns(t *testing.T) {
+	t.Parallel()
+
+	record := &attachmentsRecord{FSID: "fsid-a", ImageID: "image-a"}
+
+	require.True(t, record.owns(&attachmentsRecord{FSID: "fsid-a", ImageID: "image-a"}))
+	// a clone in the same cluster
+	require.False(t, record.owns(&attachmentsRecord{FSID: "fsid-a", ImageID: "image-b"}))
+	// the mirrored image in the peer cluster
+	require.False(t, record.owns(&attachmentsRecord{FSID: "fsid-b", ImageID: "image-a"}))
+}
+
+func TestIsStaticVolID(t *testing.T) {
+	t.Parallel()
+
+	csiID, err := util.CSIIdentifier{
+		LocationID: 1,
+		ClusterID:  "cluster-1",
+		ObjectUUID: "e0b45b52-7e09-47d3-8f1b-806995fa4412",
+	}.ComposeCSIID()
+	require.NoError(t, err)
+
+	require.False(t, isStaticVolID(csiID))
+	require.False(t, isStaticVolID(
+		"mig_mons-b7f67366bb43f32e07d8a261a7840da9_image-e0b45b52-7e09-47d3-8f1b-806995fa4412_706f6f6c"))
+	require.True(t, isStaticVolID("static-image"))
+}
@@ -20,12 +20,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"strconv"
 
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
+	rterrors "github.com/ceph/ceph-csi/internal/util/reftracker/errors"
 
 	librbd "github.com/ceph/go-ceph/rbd"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -42,6 +44,10 @@ const (
 // controller server spec.
 type ControllerServer struct {
 	*csicommon.DefaultControllerServer
+	// LockManager keeps the VolumeLocks, SnapshotLocks and VolumeGroupLocks,
+	// and acquires locks of multiple levels in a fixed order.
+	LockManager *util.LockManager
+
 	// A map storing all volumes with ongoing operations so that additional operations
 	// for that same volume (as defined by VolumeID/volume name) return an Aborted error
 	VolumeLocks *util.VolumeLocks
@@ -62,6 +68,23 @@ type ControllerServer struct {
 
 	// Set metadata on volume
 	SetMetadata bool
+
+	// SnapshotPoolUsageThreshold is the fraction of the pool capacity that
+	// may be in use after creating a snapshot, 0 disables the check.
+	SnapshotPoolUsageThreshold float64
+
+	// MaxSnapshotsPerVolume is the default limit of snapshots of a volume,
+	// 0 means unlimited.
+	MaxSnapshotsPerVolume uint
+
+	// AttachTracking records the nodes that a volume is published to in
+	// the metadata of the image, and rejects publishing a volume with a
+	// single node access mode to a second node.
+	AttachTracking bool
+
+	// ListLimits bound the number of entries and the size of the ListVolumes
+	// responses.
+	ListLimits csicommon.ListLimits
 }
 
 func (cs *ControllerServer) validateVolumeReq(ctx context.Context, req *csi.CreateVolumeRequest) error {
@@ -102,6 +125,9 @@ func (cs *ControllerServer) validateVolumeReq(ctx context.Context, req *csi.Crea
 	if value, ok := options["volumeNamePrefix"]; ok && value == "" {
 		return status.Error(codes.InvalidArgument, "empty volume name prefix to provision volume from")
 	}
+	if err := validateVolumeNameTemplate(options); err != nil {
+		return err
+	}
 
 	// Allow readonly access mode for volume with content source
 	err := util.CheckReadOnlyManyIsSupported(req)
@@ -114,7 +140,12 @@ func (cs *ControllerServer) validateVolumeReq(ctx context.Context, req *csi.Crea
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	return nil
+	err = validateVolumeLayout(req)
+	if err != nil {
+		return err
+	}
+
+	return validateBackingSnapshotRequest(req)
 }
 
 func validateStriping(parameters map[string]string) error {
@@ -188,6 +219,11 @@ func (cs *ControllerServer) parseVolCreateRequest(
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	rbdVol.lvmBackingImageSize, err = parseVolumeLayout(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	// set cluster name on volume
 	rbdVol.ClusterName = cs.ClusterName
 	// set metadata on volume
@@ -207,6 +243,12 @@ func (cs *ControllerServer) parseVolCreateRequest(
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	// fail before any reservation is made, instead of waiting for the KMS
+	// to time out while the passphrase is stored
+	err = rbdVol.checkKMSHealth()
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 
 	rbdVol.RequestName = req.GetName()
 
@@ -216,9 +258,10 @@ func (cs *ControllerServer) parseVolCreateRequest(
 		volSizeBytes = req.GetCapacityRange().GetRequiredBytes()
 	}
 
-	// always round up the request size in bytes to the nearest MiB/GiB
-	rbdVol.VolSize = util.RoundOffBytes(volSizeBytes)
-	// RequestedVolSize has the size of the volume requested by the user.
+	// always round up the request size in bytes to the nearest MiB/GiB, the
+	// image of an encrypted volume is larger to hold the LUKS header
+	rbdVol.VolSize = util.RoundOffBytes(volSizeBytes) + rbdVol.encryptionOverhead()
+	// RequestedVolSize has the size of the image requested by the user.
 	rbdVol.RequestedVolSize = rbdVol.VolSize
 
 	// start with pool the same as journal pool, in case there is a topology
@@ -246,7 +289,7 @@ func (cs *ControllerServer) parseVolCreateRequest(
 func (rbdVol *rbdVolume) ToCSI(ctx context.Context) (*csi.Volume, error) {
 	vol := &csi.Volume{
 		VolumeId:      rbdVol.VolID,
-		CapacityBytes: rbdVol.VolSize,
+		CapacityBytes: rbdVol.usableSize(rbdVol.VolSize),
 		VolumeContext: map[string]string{
 			"pool":        rbdVol.Pool,
 			"journalPool": rbdVol.JournalPool,
@@ -273,6 +316,24 @@ func (rbdVol *rbdVolume) ToCSI(ctx context.Context) (*csi.Volume, error) {
 	return vol, nil
 }
 
+// validateVolumeNameTemplate returns an InvalidArgument error when the
+// volumeNameTemplate parameter is empty, or combined with volumeNamePrefix.
+func validateVolumeNameTemplate(options map[string]string) error {
+	template, ok := options[k8s.VolumeNameTemplateParam]
+	if !ok {
+		return nil
+	}
+	if template == "" {
+		return status.Error(codes.InvalidArgument, "empty volume name template to provision volume from")
+	}
+	if _, ok = options["volumeNamePrefix"]; ok {
+		return status.Errorf(codes.InvalidArgument, "parameters %s and volumeNamePrefix can not be combined",
+			k8s.VolumeNameTemplateParam)
+	}
+
+	return nil
+}
+
 func buildCreateVolumeResponse(
 	ctx context.Context,
 	req *csi.CreateVolumeRequest,
@@ -291,6 +352,11 @@ func buildCreateVolumeResponse(
 	for param, value := range util.GetVolumeContext(req.GetParameters()) {
 		volume.VolumeContext[param] = value
 	}
+	// the rendered prefix is needed to regenerate the journal of the volume
+	if _, ok := volume.VolumeContext[k8s.VolumeNameTemplateParam]; ok {
+		delete(volume.VolumeContext, k8s.VolumeNameTemplateParam)
+		volume.VolumeContext["volumeNamePrefix"] = rbdVol.NamePrefix
+	}
 
 	return &csi.CreateVolumeResponse{Volume: volume}, nil
 }
@@ -302,7 +368,7 @@ func getGRPCErrorForCreateVolume(err error) error {
 	if errors.Is(err, ErrVolNameConflict) {
 		return status.Error(codes.AlreadyExists, err.Error())
 	}
-	if errors.Is(err, ErrFlattenInProgress) {
+	if errors.Is(err, ErrFlattenInProgress) || errors.Is(err, ErrReservationPending) {
 		return status.Error(codes.Aborted, err.Error())
 	}
 
@@ -348,6 +414,14 @@ func (cs *ControllerServer) CreateVolume(
 		return nil, err
 	}
 
+	// volumes of PVCs in the namespace of a tenant are created with the
+	// clusterID and Ceph user of the tenant, the request is updated so that
+	// the volume context refers to the clusterID of the tenant as well
+	req.Parameters, req.Secrets, err = tenantCreateParameters(ctx, req.GetParameters(), req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
 	// TODO: create/get a connection from the ConnPool, and do not pass the
 	// credentials to any of the utility functions.
 
@@ -385,6 +459,18 @@ func (cs *ControllerServer) CreateVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	err = cs.setStretchTopology(ctx, rbdVol, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBackingSnapshotRequest(req) {
+		return cs.createSnapshotBackedVolume(ctx, req, cr, rbdVol, rbdSnap)
+	}
+	if rbdVol.lvmBackingImageSize != 0 {
+		return cs.createLVMVolume(ctx, req, cr, rbdVol)
+	}
+
 	found, err := rbdVol.Exists(ctx, parentVol)
 	if err != nil {
 		return nil, getGRPCErrorForCreateVolume(err)
@@ -397,11 +483,17 @@ func (cs *ControllerServer) CreateVolume(
 		return nil, err
 	}
 
-	err = flattenParentImage(ctx, parentVol, rbdSnap, cr)
+	err = flattenParentImage(ctx, parentVol, rbdSnap, rbdVol.getFlattenPolicy(), cr)
 	if err != nil {
 		return nil, err
 	}
 
+	releaseNamespace, err := prepareRadosNamespace(ctx, rbdVol)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseNamespace()
+
 	err = reserveVol(ctx, rbdVol, cr)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -414,6 +506,17 @@ func (cs *ControllerServer) CreateVolume(
 			}
 		}
 	}()
+	stopHeartbeat := startReservationHeartbeat(ctx, rbdVol, cr)
+	defer stopHeartbeat()
+
+	err = rbdVol.storeFlattenPolicy(ctx, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = rbdVol.storeImageConfig(ctx, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
 	err = cs.createBackingImage(ctx, cr, req.GetSecrets(), rbdVol, parentVol, rbdSnap)
 	if err != nil {
@@ -426,7 +529,13 @@ func (cs *ControllerServer) CreateVolume(
 
 	// Set Metadata on PV Create
 	metadata := k8s.GetVolumeMetadata(req.GetParameters())
+	if rbdVol.EnableMetadata {
+		maps.Copy(metadata, k8s.GetVolumeLineageMetadata(ctx, req.GetParameters(), req.GetVolumeContentSource()))
+	}
 	err = rbdVol.setAllMetadata(metadata)
+	if err == nil {
+		err = rbdVol.setImageConfig(ctx)
+	}
 	if err != nil {
 		if deleteErr := rbdVol.Delete(ctx); deleteErr != nil {
 			log.ErrorLog(ctx, "failed to delete rbd image: %s with error: %v", rbdVol, deleteErr)
@@ -434,6 +543,7 @@ func (cs *ControllerServer) CreateVolume(
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	completeVolReservation(ctx, rbdVol, cr)
 
 	return buildCreateVolumeResponse(ctx, req, rbdVol)
 }
@@ -443,10 +553,12 @@ func (cs *ControllerServer) CreateVolume(
 // make sure no flattening is required during or after the new volume creation.
 // For parent volume, it's parent(temp clone or snapshot) is flattened.
 // For parent snapshot, the snapshot itself is flattened.
+// The limits are taken from the flatten policy of the new volume.
 func flattenParentImage(
 	ctx context.Context,
 	rbdVol *rbdVolume,
 	rbdSnap *rbdSnapshot,
+	policy flattenPolicy,
 	cr *util.Credentials,
 ) error {
 	// flatten the image's parent before the reservation to avoid
@@ -458,17 +570,17 @@ func flattenParentImage(
 	// omap entries and stale temporary snapshots in corner cases, if we reduce
 	// the limit and check for the depth of the parent image clain itself we
 	// can flatten the parent images before used to avoid the stale omap entries.
-	hardLimit := rbdHardMaxCloneDepth
-	softLimit := rbdSoftMaxCloneDepth
+	hardLimit := policy.HardMaxCloneDepth
+	softLimit := policy.SoftMaxCloneDepth
 	if rbdVol != nil {
 		// choosing 3, since cloning image creates a temp clone and a final clone which
 		// will add a total depth of 2 and the parent image itself adds one depth.
 		const depthToAvoidFlatten = 3
-		if rbdHardMaxCloneDepth > depthToAvoidFlatten {
-			hardLimit = rbdHardMaxCloneDepth - depthToAvoidFlatten
+		if policy.HardMaxCloneDepth > depthToAvoidFlatten {
+			hardLimit = policy.HardMaxCloneDepth - depthToAvoidFlatten
 		}
-		if rbdSoftMaxCloneDepth > depthToAvoidFlatten {
-			softLimit = rbdSoftMaxCloneDepth - depthToAvoidFlatten
+		if policy.SoftMaxCloneDepth > depthToAvoidFlatten {
+			softLimit = policy.SoftMaxCloneDepth - depthToAvoidFlatten
 		}
 		err := rbdVol.flattenParent(ctx, hardLimit, softLimit)
 		if err != nil {
@@ -477,7 +589,7 @@ func flattenParentImage(
 
 		// flatten cloned images if the snapshot count on the parent image
 		// exceeds maxSnapshotsOnImage
-		err = flattenTemporaryClonedImages(ctx, rbdVol, cr)
+		err = flattenTemporaryClonedImages(ctx, rbdVol, policy, cr)
 		if err != nil {
 			return err
 		}
@@ -496,11 +608,11 @@ func flattenParentImage(
 
 		// choosing 1, since restore from snapshot adds one depth.
 		const depthToAvoidFlatten = 1
-		if rbdHardMaxCloneDepth > depthToAvoidFlatten {
-			hardLimit = rbdHardMaxCloneDepth - depthToAvoidFlatten
+		if policy.HardMaxCloneDepth > depthToAvoidFlatten {
+			hardLimit = policy.HardMaxCloneDepth - depthToAvoidFlatten
 		}
-		if rbdSoftMaxCloneDepth > depthToAvoidFlatten {
-			softLimit = rbdSoftMaxCloneDepth - depthToAvoidFlatten
+		if policy.SoftMaxCloneDepth > depthToAvoidFlatten {
+			softLimit = policy.SoftMaxCloneDepth - depthToAvoidFlatten
 		}
 
 		err = rbdSnap.flattenRbdImage(ctx, false, hardLimit, softLimit)
@@ -558,10 +670,14 @@ func (cs *ControllerServer) repairExistingVolume(ctx context.Context, req *csi.C
 
 	// Set metadata on restart of provisioner pod when image exist
 	metadata := k8s.GetVolumeMetadata(req.GetParameters())
+	if rbdVol.EnableMetadata {
+		maps.Copy(metadata, k8s.GetVolumeLineageMetadata(ctx, req.GetParameters(), vcs))
+	}
 	err := rbdVol.setAllMetadata(metadata)
 	if err != nil {
 		return nil, err
 	}
+	completeVolReservation(ctx, rbdVol, rbdVol.conn.Creds)
 
 	return buildCreateVolumeResponse(ctx, req, rbdVol)
 }
@@ -572,7 +688,12 @@ func (cs *ControllerServer) repairExistingVolume(ctx context.Context, req *csi.C
 // the temporary cloned images and return ABORT error message. If the snapshots
 // are more than the `minSnapshotOnImage` Add a task to flatten all the
 // temporary cloned images.
-func flattenTemporaryClonedImages(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) error {
+func flattenTemporaryClonedImages(
+	ctx context.Context,
+	rbdVol *rbdVolume,
+	policy flattenPolicy,
+	cr *util.Credentials,
+) error {
 	snaps, children, err := rbdVol.listSnapAndChildren()
 	if err != nil {
 		if errors.Is(err, ErrImageNotFound) {
@@ -582,13 +703,13 @@ func flattenTemporaryClonedImages(ctx context.Context, rbdVol *rbdVolume, cr *ut
 		return status.Error(codes.Internal, err.Error())
 	}
 
-	if len(snaps) > int(maxSnapshotsOnImage) {
+	if len(snaps) > int(policy.MaxSnapshotsOnImage) {
 		log.DebugLog(
 			ctx,
 			"snapshots count %d on image: %s reached configured hard limit %d",
 			len(snaps),
 			rbdVol,
-			maxSnapshotsOnImage)
+			policy.MaxSnapshotsOnImage)
 
 		if len(children) == 0 {
 			// if none of the child images(are in trash) exist, we can't flatten them.
@@ -605,6 +726,7 @@ func flattenTemporaryClonedImages(ctx context.Context, rbdVol *rbdVolume, cr *ut
 			rbdVol.Pool,
 			rbdVol.Monitors,
 			rbdVol.RbdImageName,
+			policy,
 			cr)
 		if err != nil {
 			return status.Error(codes.Internal, err.Error())
@@ -613,13 +735,13 @@ func flattenTemporaryClonedImages(ctx context.Context, rbdVol *rbdVolume, cr *ut
 		return status.Errorf(codes.ResourceExhausted, "rbd image %s has %d snapshots", rbdVol, len(snaps))
 	}
 
-	if len(snaps) > int(minSnapshotsOnImageToStartFlatten) {
+	if len(snaps) > int(policy.MinSnapshotsOnImageToStartFlatten) {
 		log.DebugLog(
 			ctx,
 			"snapshots count %d on image: %s reached configured soft limit %d",
 			len(snaps),
 			rbdVol,
-			minSnapshotsOnImageToStartFlatten)
+			policy.MinSnapshotsOnImageToStartFlatten)
 		if len(children) == 0 {
 			// if none of the child images(are in trash) exist, we can't flatten them.
 			// return nil since we have only reach the soft limit.
@@ -632,7 +754,7 @@ func flattenTemporaryClonedImages(ctx context.Context, rbdVol *rbdVolume, cr *ut
 		// snapshots. Use the min of the extra snapshots and the number of children
 		// to avoid scenario where number of children are less than the extra snapshots.
 		// This occurs when the child images are in trash and not yet deleted.
-		extraSnapshots := min((len(snaps) - int(minSnapshotsOnImageToStartFlatten)), len(children))
+		extraSnapshots := min((len(snaps) - int(policy.MinSnapshotsOnImageToStartFlatten)), len(children))
 		children = children[:extraSnapshots]
 		err = flattenClonedRbdImages(
 			ctx,
@@ -640,6 +762,7 @@ func flattenTemporaryClonedImages(ctx context.Context, rbdVol *rbdVolume, cr *ut
 			rbdVol.Pool,
 			rbdVol.Monitors,
 			rbdVol.RbdImageName,
+			policy,
 			cr)
 		if err != nil {
 			return status.Error(codes.Internal, err.Error())
@@ -683,7 +806,7 @@ func (cs *ControllerServer) createVolumeFromSnapshot(
 	defer parentVol.Destroy(ctx)
 
 	// create clone image and delete snapshot
-	err = rbdVol.cloneRbdImageFromSnapshot(ctx, rbdSnap, parentVol)
+	err = rbdVol.restoreFromSnapshot(ctx, rbdSnap, parentVol)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to clone rbd image %s from snapshot %s: %v", rbdVol, rbdSnap, err)
 
@@ -707,6 +830,13 @@ func (cs *ControllerServer) createVolumeFromSnapshot(
 		return err
 	}
 
+	err = rbdVol.inheritMetadata(ctx, &parentVol.rbdImage, rbdVol.inheritMetadataKeys)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to inherit metadata of snapshot %s: %v", rbdSnap, err)
+
+		return err
+	}
+
 	log.DebugLog(ctx, "create volume %s from snapshot %s", rbdVol, rbdSnap)
 
 	err = parentVol.copyEncryptionConfig(ctx, &rbdVol.rbdImage, true)
@@ -735,7 +865,7 @@ func (cs *ControllerServer) createBackingImage(
 ) error {
 	var err error
 
-	j, err := volJournal.Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	j, err := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
 	if err != nil {
 		return status.Error(codes.Internal, err.Error())
 	}
@@ -762,7 +892,17 @@ func (cs *ControllerServer) createBackingImage(
 		}
 		defer cs.OperationLocks.ReleaseCloneLock(parentVol.VolID)
 
-		return rbdVol.createCloneFromImage(ctx, parentVol)
+		err = rbdVol.createCloneFromImage(ctx, parentVol)
+		if err != nil {
+			return err
+		}
+
+		err = rbdVol.inheritMetadata(ctx, &parentVol.rbdImage, rbdVol.inheritMetadataKeys)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		return nil
 	default:
 		err = createImage(ctx, rbdVol, cr)
 		if err != nil {
@@ -831,6 +971,12 @@ func checkContentSource(
 		rbdvol, err := GenVolFromVolID(ctx, volID, cr, req.GetSecrets())
 		if err != nil {
 			log.ErrorLog(ctx, "failed to get backend image for %s: %v", volID, err)
+			if errors.Is(err, ErrSnapshotBackedVolume) {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "snapshot-backed volume %s can not be cloned", volID)
+			}
+			if errors.Is(err, ErrLVMVolume) {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "logical volume %s can not be cloned", volID)
+			}
 			if !errors.Is(err, ErrImageNotFound) {
 				return nil, nil, status.Error(codes.Internal, err.Error())
 			}
@@ -856,6 +1002,10 @@ func (cs *ControllerServer) checkErrAndUndoReserve(
 	volumeID string,
 	rbdVol *rbdVolume, cr *util.Credentials,
 ) (*csi.DeleteVolumeResponse, error) {
+	// the journal of the volume was removed with a deleted pool, the
+	// reservation that a topology constrained StorageClass keeps in its
+	// journal pool is reported by ListOrphanedReservations of the admin
+	// service
 	if errors.Is(err, util.ErrPoolNotFound) {
 		log.WarningLog(ctx, "failed to get backend volume for %s: %v", volumeID, err)
 
@@ -918,7 +1068,12 @@ func (cs *ControllerServer) DeleteVolume(
 		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
 	}
 
-	cr, err := util.NewUserCredentialsWithMigration(req.GetSecrets())
+	secrets, err := tenantSecrets(ctx, volumeID, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := util.NewUserCredentialsWithMigration(secrets)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -953,12 +1108,18 @@ func (cs *ControllerServer) DeleteVolume(
 		return &csi.DeleteVolumeResponse{}, nil
 	}
 
-	rbdVol, err := GenVolFromVolID(ctx, volumeID, cr, req.GetSecrets())
+	rbdVol, err := GenVolFromVolID(ctx, volumeID, cr, secrets)
 	defer func() {
 		if rbdVol != nil {
 			rbdVol.Destroy(ctx)
 		}
 	}()
+	if errors.Is(err, ErrSnapshotBackedVolume) {
+		return cs.deleteSnapshotBackedVolume(ctx, rbdVol, cr, secrets)
+	}
+	if errors.Is(err, ErrLVMVolume) {
+		return cs.deleteLVMVolume(ctx, rbdVol, cr)
+	}
 	if err != nil {
 		return cs.checkErrAndUndoReserve(ctx, err, volumeID, rbdVol, cr)
 	}
@@ -1032,9 +1193,15 @@ func cleanupRBDImage(ctx context.Context,
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	if inUse {
-		log.ErrorLog(ctx, "rbd %s is still being used", rbdVol)
+		// the watchers tell on which node the image is still mapped
+		watchers, wErr := rbdVol.describeWatchers(ctx)
+		if wErr != nil {
+			log.WarningLog(ctx, "failed to get the watchers of image %s: %v", rbdVol, wErr)
+		}
+		log.ErrorLog(ctx, "rbd %s is still being used by %s", rbdVol, watchers)
 
-		return nil, status.Errorf(codes.Internal, "rbd %s is still being used", rbdVol.RbdImageName)
+		return nil, status.Errorf(codes.FailedPrecondition, "rbd %s is still being used by watchers: %s",
+			rbdVol.RbdImageName, watchers)
 	}
 
 	// delete the temporary rbd image created as part of volume clone during
@@ -1055,6 +1222,10 @@ func cleanupRBDImage(ctx context.Context,
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if err = purgeSnapshotRefs(ctx, rbdVol, cr); err != nil {
+		log.WarningLog(ctx, "failed to remove snapshot tracking of volume %s: %v", rbdVol, err)
+	}
+
 	if err = undoVolReservation(ctx, rbdVol, cr); err != nil {
 		log.ErrorLog(ctx, "failed to remove reservation for volume (%s) with backing image (%s) (%s)",
 			rbdVol.RequestName, rbdVol.RbdImageName, err)
@@ -1103,14 +1274,19 @@ func (cs *ControllerServer) CreateSnapshot(
 		return nil, err
 	}
 
-	cr, err := util.NewUserCredentials(req.GetSecrets())
+	secrets, err := tenantSecrets(ctx, req.GetSourceVolumeId(), req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := util.NewUserCredentials(secrets)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	defer cr.DeleteCredentials()
 
 	// Fetch source volume information
-	rbdVol, err := GenVolFromVolID(ctx, req.GetSourceVolumeId(), cr, req.GetSecrets())
+	rbdVol, err := GenVolFromVolID(ctx, req.GetSourceVolumeId(), cr, secrets)
 	defer func() {
 		if rbdVol != nil {
 			rbdVol.Destroy(ctx)
@@ -1118,6 +1294,12 @@ func (cs *ControllerServer) CreateSnapshot(
 	}()
 	if err != nil {
 		switch {
+		case errors.Is(err, ErrSnapshotBackedVolume):
+			err = status.Errorf(codes.InvalidArgument, "snapshot-backed volume %s can not be snapshotted",
+				req.GetSourceVolumeId())
+		case errors.Is(err, ErrLVMVolume):
+			err = status.Errorf(codes.InvalidArgument, "logical volume %s can not be snapshotted",
+				req.GetSourceVolumeId())
 		case errors.Is(err, ErrImageNotFound):
 			err = status.Errorf(codes.NotFound, "source Volume ID %s not found", req.GetSourceVolumeId())
 		case errors.Is(err, util.ErrPoolNotFound):
@@ -1174,9 +1356,49 @@ func (cs *ControllerServer) CreateSnapshot(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	if found {
+		err = rbdVol.loadFlattenPolicy(ctx, cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
 		return cloneFromSnapshot(ctx, rbdVol, rbdSnap, cr, req.GetParameters())
 	}
 
+	limit, err := util.GetMaxSnapshotsPerVolume(req.GetParameters(), cs.MaxSnapshotsPerVolume)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// With a snapshot limit, the source volume is locked while the snapshots
+	// are counted, until the new snapshot is tracked, so that concurrent
+	// requests can not exceed the limit.
+	sourceLocked := false
+	if limit != 0 {
+		if acquired := cs.VolumeLocks.TryAcquire(rbdSnap.SourceVolumeID); !acquired {
+			log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, rbdSnap.SourceVolumeID)
+
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, rbdSnap.SourceVolumeID)
+		}
+		sourceLocked = true
+	}
+	releaseSource := func() {
+		if sourceLocked {
+			cs.VolumeLocks.Release(rbdSnap.SourceVolumeID)
+			sourceLocked = false
+		}
+	}
+	defer releaseSource()
+
+	err = checkSnapshotCount(ctx, rbdVol, limit, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cs.checkSnapshotPoolUsage(ctx, rbdVol)
+	if err != nil {
+		return nil, err
+	}
+
 	err = rbdVol.PrepareVolumeForSnapshot(ctx, cr)
 	if err != nil {
 		return nil, err
@@ -1195,17 +1417,52 @@ func (cs *ControllerServer) CreateSnapshot(
 		}
 	}()
 
-	vol, err := cs.doSnapshotClone(ctx, rbdVol, rbdSnap, cr)
+	sourceName := rbdVol.RbdImageName
+	err = trackSnapshot(ctx, rbdSnap, sourceName, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, ErrFlattenInProgress) {
+			errDefer := untrackSnapshot(ctx, rbdSnap, sourceName, cr)
+			if errDefer != nil {
+				log.WarningLog(ctx, "failed removing snapshot %s from volume %s: %v", req.GetName(), sourceName, errDefer)
+			}
+		}
+	}()
+	releaseSource()
+
+	hook, err := util.NewSnapshotHook(util.CsiConfigFile, rbdVol.ClusterID)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// the snapshot inherits the keys of the VolumeSnapshotClass
+	rbdVol.inheritMetadataKeys = parseInheritMetadataKeys(req.GetParameters())
+	var vol *rbdVolume
+	err = hook.Run(ctx, &util.SnapshotHookRequest{
+		ClusterID:       rbdVol.ClusterID,
+		Name:            req.GetName(),
+		SourceVolumeIDs: []string{req.GetSourceVolumeId()},
+		Parameters:      req.GetParameters(),
+	}, func() error {
+		var sErr error
+		vol, sErr = cs.doSnapshotClone(ctx, rbdVol, rbdSnap, cr)
+
+		return sErr
+	})
+	if errors.Is(err, util.ErrSnapshotHookFailed) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	// Update the metadata on snapshot not on the original image
 	rbdVol.RbdImageName = rbdSnap.RbdSnapName
 	rbdVol.ClusterName = cs.ClusterName
 
 	defer func() {
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrFlattenInProgress) {
 			log.DebugLog(ctx, "Removing clone image %q", rbdVol)
 			errDefer := rbdVol.Delete(ctx)
 			if errDefer != nil {
@@ -1214,6 +1471,15 @@ func (cs *ControllerServer) CreateSnapshot(
 		}
 	}()
 
+	if rbdSnap.flattenTimeout != 0 {
+		// the snapshot and its clone are kept when flattening takes longer,
+		// the retried CreateSnapshot waits for it again
+		err = flattenSnapshotImage(ctx, rbdSnap, cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	err = rbdVol.unsetAllMetadata(k8s.GetVolumeMetadataKeys())
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -1262,7 +1528,12 @@ func cloneFromSnapshot(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	err = vol.flattenRbdImage(ctx, false, rbdHardMaxCloneDepth, rbdSoftMaxCloneDepth)
+	if rbdSnap.flattenTimeout != 0 {
+		err = vol.flattenAndWait(ctx, rbdSnap.flattenTimeout)
+	} else {
+		policy := rbdVol.getFlattenPolicy()
+		err = vol.flattenRbdImage(ctx, false, policy.HardMaxCloneDepth, policy.SoftMaxCloneDepth)
+	}
 	if errors.Is(err, ErrFlattenInProgress) {
 		// if flattening is in progress, return error and do not cleanup
 		return nil, status.Error(codes.Internal, err.Error())
@@ -1289,6 +1560,9 @@ func cloneFromSnapshot(
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	// rbdSnap and its clone do not carry the encryption of the volume, the
+	// usable size is converted like the one of the source volume
+	csiSnap.SizeBytes = rbdVol.usableSize(rbdSnap.VolSize)
 
 	return &csi.CreateSnapshotResponse{
 		Snapshot: csiSnap,
@@ -1367,6 +1641,13 @@ func (cs *ControllerServer) doSnapshotClone(
 		return nil, err
 	}
 
+	err = cloneRbd.inheritMetadata(ctx, &parentVol.rbdImage, parentVol.inheritMetadataKeys)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to inherit metadata of %s: %v", parentVol, err)
+
+		return nil, err
+	}
+
 	err = cloneRbd.createSnapshot(ctx, rbdSnap)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to create snapshot %s: %v", rbdSnap, err)
@@ -1381,7 +1662,7 @@ func (cs *ControllerServer) doSnapshotClone(
 		return cloneRbd, err
 	}
 	// save image ID
-	j, err := snapJournal.Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to connect to cluster: %v", err)
 
@@ -1412,17 +1693,22 @@ func (cs *ControllerServer) DeleteSnapshot(
 		return nil, err
 	}
 
-	cr, err := util.NewUserCredentials(req.GetSecrets())
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-	defer cr.DeleteCredentials()
-
 	snapshotID := req.GetSnapshotId()
 	if snapshotID == "" {
 		return nil, status.Error(codes.InvalidArgument, "snapshot ID cannot be empty")
 	}
 
+	secrets, err := tenantSecrets(ctx, snapshotID, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := util.NewUserCredentials(secrets)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer cr.DeleteCredentials()
+
 	if acquired := cs.SnapshotLocks.TryAcquire(snapshotID); !acquired {
 		log.ErrorLog(ctx, util.SnapshotOperationAlreadyExistsFmt, snapshotID)
 
@@ -1438,7 +1724,7 @@ func (cs *ControllerServer) DeleteSnapshot(
 	}
 	defer cs.OperationLocks.ReleaseDeleteLock(snapshotID)
 
-	rbdSnap, err := genSnapFromSnapID(ctx, snapshotID, cr, req.GetSecrets())
+	rbdSnap, err := genSnapFromSnapID(ctx, snapshotID, cr, secrets)
 	if err != nil {
 		// if error is ErrPoolNotFound, the pool is already deleted we don't
 		// need to worry about deleting snapshot or omap data, return success
@@ -1462,6 +1748,13 @@ func (cs *ControllerServer) DeleteSnapshot(
 		if errors.Is(err, ErrImageNotFound) {
 			log.UsefulLog(ctx, "cleaning up leftovers of snapshot %s: %v", snapshotID, err)
 
+			if rbdSnap.groupID == "" {
+				err = untrackSnapshot(ctx, rbdSnap, rbdSnap.RbdImageName, cr)
+				if err != nil {
+					return nil, status.Error(codes.Internal, err.Error())
+				}
+			}
+
 			err = cleanUpImageAndSnapReservation(ctx, rbdSnap, cr)
 			if err != nil {
 				return nil, status.Error(codes.Internal, err.Error())
@@ -1483,6 +1776,31 @@ func (cs *ControllerServer) DeleteSnapshot(
 	}
 	defer cs.SnapshotLocks.Release(rbdSnap.RequestName)
 
+	// the reservation is gone once the snapshot is deleted, remove it from
+	// the snapshots of the source volume first so that a retry can do so too
+	if rbdSnap.groupID == "" {
+		err = untrackSnapshot(ctx, rbdSnap, rbdSnap.RbdImageName, cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// volumes that are backed by the snapshot keep it until the last one of
+	// them is deleted
+	needsDelete, err := unrefSelfInSnapshotBackedVolumes(ctx, rbdSnap)
+	if err != nil {
+		if errors.Is(err, rterrors.ErrObjectOutOfDate) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !needsDelete {
+		log.UsefulLog(ctx, "snapshot %s backs volumes, it is deleted with the last of them", rbdSnap)
+
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
 	// Deleting snapshot and cloned volume
 	log.DebugLog(ctx, "deleting cloned rbd volume %s", rbdSnap.RbdSnapName)
 
@@ -1554,14 +1872,23 @@ func (cs *ControllerServer) ControllerExpandVolume(
 	}
 	defer cs.VolumeLocks.Release(volID)
 
-	cr, err := util.NewUserCredentialsWithMigration(req.GetSecrets())
+	secrets, err := tenantSecrets(ctx, volID, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := util.NewUserCredentialsWithMigration(secrets)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	defer cr.DeleteCredentials()
-	rbdVol, err := genVolFromVolIDWithMigration(ctx, volID, cr, req.GetSecrets())
+	rbdVol, err := genVolFromVolIDWithMigration(ctx, volID, cr, secrets)
 	if err != nil {
 		switch {
+		case errors.Is(err, ErrSnapshotBackedVolume):
+			err = status.Errorf(codes.InvalidArgument, "snapshot-backed volume %s can not be expanded", volID)
+		case errors.Is(err, ErrLVMVolume):
+			err = status.Errorf(codes.InvalidArgument, "logical volume %s can not be expanded", volID)
 		case errors.Is(err, ErrImageNotFound):
 			err = status.Errorf(codes.NotFound, "volume ID %s not found", volID)
 		case errors.Is(err, util.ErrPoolNotFound):
@@ -1593,10 +1920,17 @@ func (cs *ControllerServer) ControllerExpandVolume(
 	defer cs.OperationLocks.ReleaseExpandLock(volID)
 
 	// always round up the request size in bytes to the nearest MiB/GiB
-	volSize := util.RoundOffBytes(req.GetCapacityRange().GetRequiredBytes())
+	volSize := util.RoundOffBytes(req.GetCapacityRange().GetRequiredBytes()) + rbdVol.encryptionOverhead()
 
 	// resize volume if required
 	if rbdVol.VolSize < volSize {
+		var releaseNamespace func()
+		releaseNamespace, err = reserveRadosNamespaceGrowth(ctx, rbdVol, volSize-rbdVol.VolSize)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseNamespace()
+
 		log.DebugLog(ctx, "rbd volume %s size is %v,resizing to %v", rbdVol, rbdVol.VolSize, volSize)
 		err = rbdVol.resize(volSize)
 		if err != nil {
@@ -1607,12 +1941,13 @@ func (cs *ControllerServer) ControllerExpandVolume(
 	}
 
 	return &csi.ControllerExpandVolumeResponse{
-		CapacityBytes:         rbdVol.VolSize,
+		CapacityBytes:         rbdVol.usableSize(rbdVol.VolSize),
 		NodeExpansionRequired: nodeExpansion,
 	}, nil
 }
 
 // ControllerPublishVolume is a dummy publish implementation to mimic a successful attach operation being a NOOP.
+// With AttachTracking, the node is recorded in the metadata of the image, see publishVolume().
 func (cs *ControllerServer) ControllerPublishVolume(
 	ctx context.Context,
 	req *csi.ControllerPublishVolumeRequest,
@@ -1627,6 +1962,13 @@ func (cs *ControllerServer) ControllerPublishVolume(
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities cannot be empty")
 	}
 
+	if cs.AttachTracking {
+		err := cs.publishVolume(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &csi.ControllerPublishVolumeResponse{
 		// the dummy response carry an empty map in its response.
 		PublishContext: map[string]string{},
@@ -1634,6 +1976,7 @@ func (cs *ControllerServer) ControllerPublishVolume(
 }
 
 // ControllerUnPublishVolume is a dummy unpublish implementation to mimic a successful attach operation being a NOOP.
+// With AttachTracking, the node is removed from the metadata of the image.
 func (cs *ControllerServer) ControllerUnpublishVolume(
 	ctx context.Context,
 	req *csi.ControllerUnpublishVolumeRequest,
@@ -1642,5 +1985,12 @@ func (cs *ControllerServer) ControllerUnpublishVolume(
 		return nil, status.Error(codes.InvalidArgument, "Volume ID cannot be empty")
 	}
 
+	if cs.AttachTracking {
+		err := cs.unpublishVolume(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
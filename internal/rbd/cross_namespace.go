@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+const (
+	// crossNamespaceRestoreParam is the StorageClass parameter that selects
+	// how a volume is restored from a snapshot in another RADOS namespace.
+	crossNamespaceRestoreParam = "crossNamespaceRestore"
+	// crossNamespaceClone clones the snapshot, and copies it when the Ceph
+	// user is not allowed to clone images of the other namespace.
+	crossNamespaceClone = "clone"
+	// crossNamespaceCopy always copies the snapshot, so that the volume does
+	// not depend on an image in another namespace.
+	crossNamespaceCopy = "copy"
+
+	// copyImageSuffix is appended to the name of the image while the
+	// snapshot is copied, so that a partial copy is never used as volume.
+	copyImageSuffix = "-copy"
+)
+
+// parseCrossNamespaceRestore returns the crossNamespaceRestoreParam of the
+// StorageClass, it defaults to crossNamespaceClone.
+func parseCrossNamespaceRestore(options map[string]string) (string, error) {
+	mode, ok := options[crossNamespaceRestoreParam]
+	if !ok {
+		return crossNamespaceClone, nil
+	}
+
+	switch mode {
+	case crossNamespaceClone, crossNamespaceCopy:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q, must be %q or %q", crossNamespaceRestoreParam, mode,
+			crossNamespaceClone, crossNamespaceCopy)
+	}
+}
+
+// restoreFromSnapshot creates the image of the volume from the snapshot. A
+// snapshot in the same RADOS namespace is cloned. A snapshot in another
+// namespace, like a golden image that is shared by tenants, is cloned unless
+// the StorageClass requests a copy. Cloning writes a reference to the clone
+// into the namespace of the snapshot, the snapshot is copied instead when the
+// Ceph user may only read that namespace.
+func (rv *rbdVolume) restoreFromSnapshot(ctx context.Context, rbdSnap *rbdSnapshot, parentVol *rbdVolume) error {
+	if rbdSnap.RadosNamespace == rv.RadosNamespace {
+		return rv.cloneRbdImageFromSnapshot(ctx, rbdSnap, parentVol)
+	}
+
+	if rv.crossNamespaceRestore != crossNamespaceCopy {
+		err := rv.cloneRbdImageFromSnapshot(ctx, rbdSnap, parentVol)
+		if !errors.Is(err, rados.ErrPermissionDenied) {
+			return err
+		}
+		log.WarningLog(ctx, "not allowed to clone snapshot %s into RADOS namespace %q, copying it instead: %v",
+			rbdSnap, rv.RadosNamespace, err)
+	}
+
+	return rv.copyFromSnapshot(ctx, rbdSnap, parentVol)
+}
+
+// copyFromSnapshot creates the image of the volume with a deep copy of the
+// snapshot, the image has no parent. The snapshot is copied to a temporary
+// image that is renamed when the copy completed, a temporary image of a
+// previous attempt is removed first.
+func (rv *rbdVolume) copyFromSnapshot(ctx context.Context, rbdSnap *rbdSnapshot, parentVol *rbdVolume) error {
+	log.DebugLog(ctx, "rbd: copy %s %s (features: %s) using mon %s",
+		rbdSnap, rv, rv.ImageFeatureSet.Names(), rv.Monitors)
+
+	err := parentVol.openIoctx()
+	if err != nil {
+		return fmt.Errorf("failed to get parent IOContext: %w", err)
+	}
+	defer func() {
+		defer parentVol.ioctx.Destroy()
+		parentVol.ioctx = nil
+	}()
+
+	err = rv.openIoctx()
+	if err != nil {
+		return fmt.Errorf("failed to get IOContext: %w", err)
+	}
+
+	tmpName := rv.RbdImageName + copyImageSuffix
+	err = librbd.RemoveImage(rv.ioctx, tmpName)
+	if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return fmt.Errorf("failed to remove image %q of a previous copy: %w", tmpName, err)
+	}
+
+	options, err := rv.constructImageOptions(ctx)
+	if err != nil {
+		return err
+	}
+	defer options.Destroy()
+
+	err = options.SetUint64(librbd.ImageOptionFlatten, 1)
+	if err != nil {
+		return err
+	}
+
+	image, err := librbd.OpenImageReadOnly(parentVol.ioctx, rbdSnap.RbdImageName, rbdSnap.RbdSnapName)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot %s: %w", rbdSnap, err)
+	}
+	defer image.Close()
+
+	done := rv.conn.TrackCall("deep_copy_image")
+	err = image.DeepCopy(rv.ioctx, tmpName, options)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to copy snapshot %s to image %q: %w", rbdSnap, tmpName, err)
+	}
+
+	err = librbd.GetImage(rv.ioctx, tmpName).Rename(rv.RbdImageName)
+	if err != nil {
+		rErr := librbd.RemoveImage(rv.ioctx, tmpName)
+		if rErr != nil {
+			log.ErrorLog(ctx, "failed to delete temporary image %q: %v", tmpName, rErr)
+		}
+
+		return fmt.Errorf("failed to rename image %q to %q: %w", tmpName, rv.RbdImageName, err)
+	}
+
+	err = rv.getImageInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get image info of %s: %w", rv, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCrossNamespaceRestore(t *testing.T) {
+	t.Parallel()
+
+	mode, err := parseCrossNamespaceRestore(map[string]string{})
+	require.NoError(t, err)
+	require.Equal(t, crossNamespaceClone, mode)
+
+	mode, err = parseCrossNamespaceRestore(map[string]string{crossNamespaceRestoreParam: "copy"})
+	require.NoError(t, err)
+	require.Equal(t, crossNamespaceCopy, mode)
+
+	_, err = parseCrossNamespaceRestore(map[string]string{crossNamespaceRestoreParam: "flatten"})
+	require.Error(t, err)
+}
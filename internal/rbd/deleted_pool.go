@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var deletedPoolLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "csi",
+	Subsystem: "rbd",
+	Name:      "deleted_pool_lookups_total",
+	Help:      "Number of volumes and snapshots that were looked up, while the pool of their image was deleted",
+}, []string{"cluster_id", "pool_id"})
+
+// RegisterDeletedPoolMetrics registers the metric of the lookups of volumes
+// and snapshots in deleted pools with prometheus.
+func RegisterDeletedPoolMetrics() error {
+	err := prometheus.Register(deletedPoolLookups)
+	if err != nil {
+		return fmt.Errorf("failed to register deleted pool metrics: %w", err)
+	}
+
+	return nil
+}
+
+// reportDeletedPool logs and counts the lookup of the volume or snapshot with
+// the CSI ID, when err tells that the pool of its image was deleted.
+func reportDeletedPool(ctx context.Context, id string, vi util.CSIIdentifier, err error) {
+	if !errors.Is(err, ErrPoolDeleted) {
+		return
+	}
+
+	log.WarningLog(ctx, "pool %d of cluster %q of %s was deleted, the volume or snapshot can only be removed",
+		vi.LocationID, vi.ClusterID, id)
+	deletedPoolLookups.WithLabelValues(vi.ClusterID, strconv.FormatInt(vi.LocationID, 10)).Inc()
+}
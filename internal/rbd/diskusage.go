@@ -19,38 +19,112 @@ package rbd
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
 )
 
+// sparsifyProgressInterval is the minimal time between two progress messages
+// of Sparsify in the log.
+const sparsifyProgressInterval = 30 * time.Second
+
 // Sparsify checks the size of the objects in the RBD image and calls
 // rbd_sparify() to free zero-filled blocks and reduce the storage consumption
 // of the image.
 // This function will return ErrImageInUse if the image is in use, since
 // sparsifying an image on which i/o is in progress is not optimal.
-func (ri *rbdImage) Sparsify(_ context.Context) error {
+//
+// The operation is aborted once ctx is done, so that a request that timed out
+// does not keep the lock of the volume. The returned result contains the
+// offset up to which the image was sparsified.
+func (ri *rbdImage) Sparsify(ctx context.Context) (*types.SparsifyResult, error) {
 	inUse, err := ri.isInUse()
 	if err != nil {
-		return fmt.Errorf("failed to check if image is in use: %w", err)
+		return nil, fmt.Errorf("failed to check if image is in use: %w", err)
 	}
 	if inUse {
 		// if the image is in use, we should not sparsify it, return ErrImageInUse.
-		return ErrImageInUse
+		return nil, ErrImageInUse
 	}
 
 	image, err := ri.open()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer image.Close()
 
 	imageInfo, err := image.Stat()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	result := &types.SparsifyResult{Size: imageInfo.Size}
+	result.PreUsage, err = getUsedBytes(image)
+	if err != nil {
+		return nil, err
+	}
+
+	lastReport := time.Now()
+	err = image.SparsifyWithProgress(1<<imageInfo.Order, func(offset, total uint64, _ interface{}) int {
+		result.Offset = offset
+		if ctx.Err() != nil {
+			// a non-zero return value aborts the operation
+			return -1
+		}
+		if total != 0 && time.Since(lastReport) >= sparsifyProgressInterval {
+			lastReport = time.Now()
+			log.DebugLog(ctx, "sparsify of image %s is %d%% done", ri, offset*100/total)
+		}
+
+		return 0
+	}, nil)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("sparsify of image %s was aborted at offset %d of %d: %w",
+			ri, result.Offset, result.Size, ctx.Err())
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to sparsify image: %w", err)
+	}
+	result.Offset = result.Size
+
+	result.PostUsage, err = getUsedBytes(image)
+	if err != nil {
+		return result, err
+	}
+	log.DebugLog(ctx, "sparsified image %s, allocated bytes went from %d to %d", ri, result.PreUsage, result.PostUsage)
+
+	return result, nil
+}
+
+// getUsedBytes returns the number of bytes that are allocated for the image,
+// excluding its parent, like `rbd du` does. When the image has the fast-diff
+// feature enabled, the object map is used instead of checking every object.
+func getUsedBytes(image *librbd.Image) (uint64, error) {
+	size, err := image.GetSize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get image size: %w", err)
 	}
 
-	err = image.Sparsify(1 << imageInfo.Order)
+	var used uint64
+	err = image.DiffIterate(librbd.DiffIterateConfig{
+		Offset:        0,
+		Length:        size,
+		IncludeParent: librbd.ExcludeParent,
+		WholeObject:   librbd.EnableWholeObject,
+		Callback: func(_, length uint64, exists int, _ interface{}) int {
+			if exists != 0 {
+				used += length
+			}
+
+			return 0
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to sparsify image: %w", err)
+		return 0, fmt.Errorf("failed to iterate over allocated extents: %w", err)
 	}
 
-	return nil
+	return used, nil
 }
@@ -0,0 +1,271 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+const (
+	// divergenceSnapshotsKey is the journal attribute of a volume that lists
+	// the snapshots that were taken when the volume was force-promoted.
+	divergenceSnapshotsKey = "divergencesnapshots"
+
+	// divergenceSnapshotPendingKey is the journal attribute of a volume that
+	// is set before the volume is force-promoted, and removed once the
+	// divergence snapshot was created. The image can not record it, the
+	// metadata of a non-primary image is read-only.
+	divergenceSnapshotPendingKey = "divergencesnapshotpending"
+
+	// divergenceSnapshotPrefix is the prefix of the names of the snapshots
+	// that are taken when an image is force-promoted.
+	divergenceSnapshotPrefix = "csi-divergence-"
+
+	divergenceTimeFormat = "20060102T150405Z"
+)
+
+// divergenceSnapshotName returns the name of the divergence snapshot of an
+// image that is force-promoted at the given time.
+func divergenceSnapshotName(t time.Time) string {
+	return divergenceSnapshotPrefix + t.UTC().Format(divergenceTimeFormat)
+}
+
+// parseDivergenceSnapshots decodes the divergence snapshots from the value of
+// the journal attribute.
+func parseDivergenceSnapshots(value string) ([]types.DivergenceSnapshot, error) {
+	snaps := []types.DivergenceSnapshot{}
+	if value == "" {
+		return snaps, nil
+	}
+
+	err := json.Unmarshal([]byte(value), &snaps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse divergence snapshots: %w", err)
+	}
+
+	return snaps, nil
+}
+
+// MarkDivergenceSnapshotPending records in the journal of the volume that a
+// divergence snapshot needs to be created, before the image is
+// force-promoted. A promotion that fails to create the snapshot is completed
+// by the next PromoteVolume request.
+func (rv *rbdVolume) MarkDivergenceSnapshotPending(ctx context.Context, cr *util.Credentials) error {
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	err = j.StoreAttribute(ctx, rv.JournalPool, rv.ReservedID, divergenceSnapshotPendingKey, "true")
+	if err != nil {
+		return fmt.Errorf("failed to mark divergence snapshot of %q as pending: %w", rv, err)
+	}
+
+	return nil
+}
+
+// IsDivergenceSnapshotPending returns true when the volume was
+// force-promoted, but the divergence snapshot was not created yet.
+func (rv *rbdVolume) IsDivergenceSnapshotPending(ctx context.Context, cr *util.Credentials) (bool, error) {
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return false, err
+	}
+	defer j.Destroy()
+
+	value, err := j.FetchAttribute(ctx, rv.JournalPool, rv.ReservedID, divergenceSnapshotPendingKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return value == "true", nil
+}
+
+// CreateDivergenceSnapshot creates a snapshot of the image, and records it in
+// the journal of the volume. It is called directly after a forced promotion,
+// before the image is used again, so that the snapshot contains the data that
+// the image had when the sites diverged. Writes that diverge from it can be
+// extracted by comparing the image with the snapshot. The pending mark of
+// MarkDivergenceSnapshotPending is cleared.
+func (rv *rbdVolume) CreateDivergenceSnapshot(
+	ctx context.Context,
+	cr *util.Credentials,
+) (*types.DivergenceSnapshot, error) {
+	now := time.Now()
+	name := divergenceSnapshotName(now)
+
+	image, err := rv.open()
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	_, err = image.CreateSnapshot(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create divergence snapshot %q of %q: %w", name, rv, err)
+	}
+
+	id, err := image.GetSnapID(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the ID of divergence snapshot %q of %q: %w", name, rv, err)
+	}
+
+	ds := types.DivergenceSnapshot{
+		Name:    name,
+		ID:      id,
+		Created: now.UTC().Truncate(time.Second),
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	value, err := j.FetchAttribute(ctx, rv.JournalPool, rv.ReservedID, divergenceSnapshotsKey)
+	if err != nil && !errors.Is(err, util.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	snaps, err := parseDivergenceSnapshots(value)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(append(snaps, ds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal divergence snapshots: %w", err)
+	}
+
+	err = j.StoreAttribute(ctx, rv.JournalPool, rv.ReservedID, divergenceSnapshotsKey, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record divergence snapshot %q of %q: %w", name, rv, err)
+	}
+
+	err = j.StoreAttribute(ctx, rv.JournalPool, rv.ReservedID, divergenceSnapshotPendingKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear pending divergence snapshot of %q: %w", rv, err)
+	}
+
+	log.DebugLog(ctx, "created divergence snapshot %q of %q", name, rv)
+
+	return &ds, nil
+}
+
+// GetDivergenceSnapshots lists the divergence snapshots that are recorded in
+// the journal of the volume. Snapshots that have been removed from the image
+// are not returned.
+func (rv *rbdVolume) GetDivergenceSnapshots(
+	ctx context.Context,
+	cr *util.Credentials,
+) ([]types.DivergenceSnapshot, error) {
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	value, err := j.FetchAttribute(ctx, rv.JournalPool, rv.ReservedID, divergenceSnapshotsKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return []types.DivergenceSnapshot{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	snaps, err := parseDivergenceSnapshots(value)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := rv.open()
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	infos, err := image.GetSnapshotNames()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %q: %w", rv, err)
+	}
+
+	existing := make(map[uint64]string, len(infos))
+	for _, info := range infos {
+		existing[info.Id] = info.Name
+	}
+
+	found := make([]types.DivergenceSnapshot, 0, len(snaps))
+	for _, ds := range snaps {
+		if existing[ds.ID] == ds.Name {
+			found = append(found, ds)
+		}
+	}
+
+	return found, nil
+}
+
+// removeDivergenceSnapshots removes the divergence snapshots from the image,
+// an image with snapshots can not be removed from the trash. Divergence
+// snapshots are only taken of force-promoted images, the snapshots of images
+// without mirroring are not listed.
+func (ri *rbdImage) removeDivergenceSnapshots(ctx context.Context) error {
+	image, err := ri.open()
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	mirrorInfo, err := image.GetMirrorImageInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get mirroring info of %q: %w", ri, err)
+	}
+	if mirrorInfo.State == librbd.MirrorImageDisabled {
+		return nil
+	}
+
+	infos, err := image.GetSnapshotNames()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots of %q: %w", ri, err)
+	}
+
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name, divergenceSnapshotPrefix) {
+			continue
+		}
+
+		err = image.GetSnapshot(info.Name).Remove()
+		if err != nil {
+			return fmt.Errorf("failed to remove divergence snapshot %q of %q: %w", info.Name, ri, err)
+		}
+		log.DebugLog(ctx, "removed divergence snapshot %q of %q", info.Name, ri)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDivergenceSnapshotName(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.FixedZone("CET", 3600))
+	require.Equal(t, "csi-divergence-20240305T130709Z", divergenceSnapshotName(ts))
+}
+
+func TestParseDivergenceSnapshots(t *testing.T) {
+	t.Parallel()
+
+	snaps, err := parseDivergenceSnapshots("")
+	require.NoError(t, err)
+	require.Empty(t, snaps)
+
+	recorded := []types.DivergenceSnapshot{
+		{
+			Name:    "csi-divergence-20240305T130709Z",
+			ID:      12,
+			Created: time.Date(2024, time.March, 5, 13, 7, 9, 0, time.UTC),
+		},
+	}
+	value, err := json.Marshal(recorded)
+	require.NoError(t, err)
+
+	snaps, err = parseDivergenceSnapshots(string(value))
+	require.NoError(t, err)
+	require.Equal(t, recorded, snaps)
+
+	_, err = parseDivergenceSnapshots("not-json")
+	require.Error(t, err)
+}
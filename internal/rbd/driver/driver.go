@@ -17,18 +17,30 @@ limitations under the License.
 package rbddriver
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 
+	"github.com/ceph/ceph-csi/internal/admin"
 	casrbd "github.com/ceph/ceph-csi/internal/csi-addons/rbd"
 	csiaddons "github.com/ceph/ceph-csi/internal/csi-addons/server"
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/kms"
 	"github.com/ceph/ceph-csi/internal/rbd"
 	"github.com/ceph/ceph-csi/internal/rbd/features"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/clusterconfig"
+	"github.com/ceph/ceph-csi/internal/util/cryptsetup"
+	"github.com/ceph/ceph-csi/internal/util/driverstatus"
+	"github.com/ceph/ceph-csi/internal/util/featuregate"
+	"github.com/ceph/ceph-csi/internal/util/journalstats"
 	"github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/readiness"
+	"github.com/ceph/ceph-csi/internal/util/usage"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
@@ -58,11 +70,14 @@ func NewIdentityServer(d *csicommon.CSIDriver) *rbd.IdentityServer {
 
 // NewControllerServer initialize a controller server for rbd CSI driver.
 func NewControllerServer(d *csicommon.CSIDriver) *rbd.ControllerServer {
+	lm := util.NewLockManager()
+
 	return &rbd.ControllerServer{
 		DefaultControllerServer: csicommon.NewDefaultControllerServer(d),
-		VolumeLocks:             util.NewVolumeLocks(),
-		SnapshotLocks:           util.NewVolumeLocks(),
-		VolumeGroupLocks:        util.NewVolumeLocks(),
+		LockManager:             lm,
+		VolumeLocks:             lm.Locks(util.VolumeLock),
+		SnapshotLocks:           lm.Locks(util.SnapshotLock),
+		VolumeGroupLocks:        lm.Locks(util.VolumeGroupLock),
 		OperationLocks:          util.NewOperationLock(),
 	}
 }
@@ -86,11 +101,13 @@ func NewNodeServer(
 // rbd CSI driver which can serve multiple parallel requests.
 //
 // This also configures and starts a new CSI-Addons service, by calling
-// setupCSIAddonsServer().
+// setupCSIAddonsServer(). The additional instances of conf.Instances are
+// served by the process as well, the background tasks and the admin endpoint
+// only run for the instance of conf.
 func (r *Driver) Run(conf *util.Config) {
 	var (
-		err                                    error
-		nodeLabels, topology, crushLocationMap map[string]string
+		err                          error
+		nodeLabels, crushLocationMap map[string]string
 	)
 	// update clone soft and hard limit
 	rbd.SetGlobalInt("rbdHardMaxCloneDepth", conf.RbdHardMaxCloneDepth)
@@ -98,6 +115,121 @@ func (r *Driver) Run(conf *util.Config) {
 	rbd.SetGlobalBool("skipForceFlatten", conf.SkipForceFlatten)
 	rbd.SetGlobalInt("maxSnapshotsOnImage", conf.MaxSnapshotsOnImage)
 	rbd.SetGlobalInt("minSnapshotsOnImageToStartFlatten", conf.MinSnapshotsOnImage)
+
+	err = util.RegisterCephCallMetrics()
+	if err != nil {
+		log.FatalLogMsg("%v", err.Error())
+	}
+
+	if featuregate.Enabled(featuregate.ClusterConfigCRD) {
+		err = clusterconfig.Start(context.Background(), conf.DriverNamespace, conf.IsControllerServer)
+		if err != nil {
+			log.FatalLogMsg("failed to read ClusterConfig objects: %v", err)
+		}
+	}
+
+	if featuregate.Enabled(featuregate.SystemdMounts) {
+		err = util.EnableMountsViaSystemd(context.TODO())
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+	}
+
+	if k8s.RunsOnKubernetes() && conf.IsNodeServer {
+		nodeLabels, err = k8s.GetNodeLabels(conf.NodeID)
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+	}
+
+	if conf.EnableReadAffinity {
+		crushLocationMap = util.GetCrushLocationMap(conf.CrushLocationLabels, nodeLabels)
+	}
+
+	if conf.IsNodeServer {
+		var attr string
+		attr, err = rbd.GetKrbdSupportedFeatures()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.FatalLogMsg("%v", err.Error())
+		}
+		var krbdFeatures uint
+		krbdFeatures, err = rbd.HexStringToInteger(attr)
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+		rbd.SetGlobalInt("krbdFeatures", krbdFeatures)
+
+		rbd.SetRbdNbdToolFeatures()
+		setNodeCapabilities(attr, krbdFeatures)
+		if conf.PassphraseCacheTTL != 0 {
+			if err = util.EnablePassphraseCache(conf.PassphraseCacheTTL); err != nil {
+				log.FatalLogMsg("failed to enable the passphrase cache: %v", err)
+			}
+		}
+	}
+
+	if conf.IsControllerServer {
+		err = util.RegisterLockMetrics()
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+
+		err = rbd.RegisterFlattenMetrics()
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+		err = rbd.RegisterDeletedPoolMetrics()
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+	}
+
+	s := r.startInstance(conf, nodeLabels, crushLocationMap)
+
+	if conf.IsControllerServer {
+		startBackgroundTasks(conf)
+	}
+
+	if conf.AdminEndpoint != "" {
+		err = r.startAdminServer(conf)
+		if err != nil {
+			log.FatalLogMsg("%v", err.Error())
+		}
+	} else if conf.PauseIOMaxTTL != 0 {
+		log.FatalLogMsg("pauseio-max-ttl requires the admin-endpoint of the nodeplugin")
+	} else if conf.EnableFailoverDrill {
+		log.FatalLogMsg("enable-failover-drill requires the admin-endpoint of the provisioner")
+	}
+
+	for _, inst := range conf.Instances {
+		log.DefaultLog("Starting instance %q of driver %q", inst.InstanceID, inst.DriverName)
+		instConf := conf.ForInstance(inst)
+		d := NewDriver()
+		d.startInstance(instConf, nodeLabels, crushLocationMap)
+		if conf.IsNodeServer {
+			go d.runVolumeHealer(instConf)
+		}
+	}
+
+	r.startProfiling(conf)
+
+	if conf.IsNodeServer {
+		go r.runVolumeHealer(conf)
+	}
+	s.Wait()
+}
+
+// startInstance creates the servers of the instance of the driver in conf,
+// and starts the CSI and CSI-Addons endpoints of the instance. The requests of
+// the endpoints use the journals of the instance.
+func (r *Driver) startInstance(
+	conf *util.Config,
+	nodeLabels, crushLocationMap map[string]string,
+) csicommon.NonBlockingGRPCServer {
+	var (
+		err      error
+		topology map[string]string
+	)
 	// Create instances of the volume and snapshot journal
 	rbd.InitJournals(conf.InstanceID)
 
@@ -107,12 +239,23 @@ func (r *Driver) Run(conf *util.Config) {
 		log.FatalLogMsg("Failed to initialize CSI Driver.")
 	}
 	if conf.IsControllerServer || !conf.IsNodeServer {
-		r.cd.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
+		controllerCaps := []csi.ControllerServiceCapability_RPC_Type{
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
 			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
 			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-		})
+		}
+		if featuregate.Enabled(featuregate.AttachTracking) {
+			controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+		}
+		if featuregate.Enabled(featuregate.ListVolumes) {
+			controllerCaps = append(controllerCaps,
+				csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+				csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+				csi.ControllerServiceCapability_RPC_GET_VOLUME,
+				csi.ControllerServiceCapability_RPC_VOLUME_CONDITION)
+		}
+		r.cd.AddControllerServiceCapabilities(controllerCaps)
 		// We only support the multi-writer option when using block, but it's a supported capability for the plugin in
 		// general
 		// In addition, we want to add the remaining modes like MULTI_NODE_READER_ONLY,
@@ -128,7 +271,7 @@ func (r *Driver) Run(conf *util.Config) {
 
 		// GroupSnapGetInfo is used within the VolumeGroupSnapshot implementation
 		vgsSupported, vgsErr := features.SupportsGroupSnapGetInfo()
-		if vgsSupported {
+		if vgsSupported && featuregate.Enabled(featuregate.GroupSnapshot) {
 			r.cd.AddGroupControllerServiceCapabilities([]csi.GroupControllerServiceCapability_RPC_Type{
 				csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
 			})
@@ -140,17 +283,6 @@ func (r *Driver) Run(conf *util.Config) {
 		}
 	}
 
-	if k8s.RunsOnKubernetes() && conf.IsNodeServer {
-		nodeLabels, err = k8s.GetNodeLabels(conf.NodeID)
-		if err != nil {
-			log.FatalLogMsg("%v", err.Error())
-		}
-	}
-
-	if conf.EnableReadAffinity {
-		crushLocationMap = util.GetCrushLocationMap(conf.CrushLocationLabels, nodeLabels)
-	}
-
 	// Create GRPC servers
 	r.ids = NewIdentityServer(r.cd)
 
@@ -159,27 +291,46 @@ func (r *Driver) Run(conf *util.Config) {
 		if err != nil {
 			log.FatalLogMsg("%v", err.Error())
 		}
-		r.ns = NewNodeServer(r.cd, conf.Vtype, nodeLabels, topology, crushLocationMap)
+		topology = util.AddTopologyAliases(topology, conf.DriverName)
 
-		var attr string
-		attr, err = rbd.GetKrbdSupportedFeatures()
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			log.FatalLogMsg("%v", err.Error())
-		}
-		var krbdFeatures uint
-		krbdFeatures, err = rbd.HexStringToInteger(attr)
-		if err != nil {
-			log.FatalLogMsg("%v", err.Error())
+		if featuregate.Enabled(featuregate.NodeCapabilityLabels) {
+			err = util.ApplyNodeCapabilityLabels(context.Background(), conf.NodeID, conf.DriverName)
+			if err != nil {
+				log.WarningLogMsg("failed to label node %q with its capabilities: %v", conf.NodeID, err)
+			}
 		}
-		rbd.SetGlobalInt("krbdFeatures", krbdFeatures)
 
-		rbd.SetRbdNbdToolFeatures()
+		r.ns = NewNodeServer(r.cd, conf.Vtype, nodeLabels, topology, crushLocationMap)
+		r.ns.ForceUnstage = featuregate.Enabled(featuregate.ForceUnstage)
+		r.ns.ReadAheadKB = conf.ReadAheadKB
+		r.ns.StatsCache = csicommon.NewVolumeStatsCache(conf.VolumeStatsCacheMaxAge)
+		r.ns.MapRefs = rbd.NewMapRefs(filepath.Join(conf.StagingPath, conf.DriverName, ".map-refs"))
+		if featuregate.Enabled(featuregate.EphemeralVolumes) {
+			r.ns.EphemeralVolumes = rbd.NewEphemeralVolumes(filepath.Join(conf.StagingPath, conf.DriverName, ".ephemeral"))
+		}
+		if featuregate.Enabled(featuregate.IDMappedMounts) {
+			r.ns.IDMappedMounts = util.GetNodeCapabilities().CheckSupported(util.IDMappedMountCapability) == nil
+			if !r.ns.IDMappedMounts {
+				log.WarningLogMsg("ID-mapped mounts are not supported on this node, not enabling VOLUME_MOUNT_GROUP")
+			}
+		}
 	}
 
 	if conf.IsControllerServer {
 		r.cs = NewControllerServer(r.cd)
 		r.cs.ClusterName = conf.ClusterName
 		r.cs.SetMetadata = conf.SetMetadata
+		r.cs.SnapshotPoolUsageThreshold = conf.SnapshotPoolUsageThreshold
+		r.cs.MaxSnapshotsPerVolume = conf.MaxSnapshotsPerVolume
+		r.cs.AttachTracking = featuregate.Enabled(featuregate.AttachTracking)
+		r.cs.ListLimits = csicommon.ListLimits{
+			MaxEntries:     conf.ListMaxEntries,
+			MaxMessageSize: conf.GRPCMaxMessageSize,
+		}
+
+		if conf.StuckLockThreshold != 0 {
+			go r.cs.LockManager.DetectStuckLocks(context.Background(), conf.StuckLockThreshold, conf.StuckLockThreshold)
+		}
 	}
 
 	// configure CSI-Addons server and components
@@ -196,21 +347,164 @@ func (r *Driver) Run(conf *util.Config) {
 		GS: r.cs,
 	}
 	s.Start(conf.Endpoint, srv, csicommon.MiddlewareServerOptionConfig{
-		LogSlowOpInterval: conf.LogSlowOpInterval,
+		LogSlowOpInterval:   conf.LogSlowOpInterval,
+		SlowOpThresholds:    csicommon.SlowOpThresholds(conf),
+		MaintenanceModeFile: util.MaintenanceModeFile,
+		RPCConcurrency:      csicommon.NodeRPCConcurrency(conf),
+		MaxMessageSize:      conf.GRPCMaxMessageSize,
+		InstanceID:          conf.InstanceID,
 	})
 
-	r.startProfiling(conf)
+	return s
+}
 
-	if conf.IsNodeServer {
-		go func() {
-			// TODO: move the healer to csi-addons
-			err := rbd.RunVolumeHealer(r.ns, conf)
-			if err != nil {
-				log.ErrorLogMsg("healer had failures, err %v\n", err)
-			}
-		}()
+// startBackgroundTasks starts the reports, checks and reconcilers of the
+// provisioner that are enabled in conf.
+func startBackgroundTasks(conf *util.Config) {
+	var err error
+	if conf.UsageReportInterval != 0 {
+		err = usage.Start(conf.DriverName, conf.UsageReportInterval,
+			conf.DriverNamespace, conf.UsageReportConfigMap, rbd.CollectUsage(conf.DriverName))
+		if err != nil {
+			log.FatalLogMsg("failed to start usage reporting: %v", err)
+		}
 	}
-	s.Wait()
+
+	if conf.JournalStatsInterval != 0 {
+		err = journalstats.Start(conf.DriverName, conf.JournalStatsInterval,
+			rbd.CountJournalEntries(conf.DriverName, conf.InstanceID))
+		if err != nil {
+			log.FatalLogMsg("failed to start counting of journal entries: %v", err)
+		}
+	}
+
+	dependencies := []readiness.Dependency{}
+	if conf.KMSHealthInterval != 0 {
+		kmsChecker, kErr := kms.StartHealthChecks(conf.KMSHealthInterval)
+		if kErr != nil {
+			log.FatalLogMsg("failed to start KMS health checks: %v", kErr)
+		}
+		dependencies = append(dependencies, kmsChecker)
+	}
+
+	var checker *readiness.Checker
+	if conf.ClusterReadinessInterval != 0 || conf.ValidateClusters {
+		checker, err = readiness.Start(readiness.Options{
+			DriverName:    conf.DriverName,
+			Interval:      conf.ClusterReadinessInterval,
+			Selector:      conf.ClusterReadinessSelector,
+			PoolParameter: "pool",
+			ClusterIDs:    util.ListClusterIDs,
+			Probe:         rbd.ProbeStorageClass,
+			Dependencies:  dependencies,
+		})
+		if err != nil {
+			log.FatalLogMsg("failed to start cluster readiness checks: %v", err)
+		}
+	} else if len(dependencies) != 0 {
+		http.Handle(readiness.Path, readiness.Handler(dependencies...))
+	}
+
+	if conf.StatusReportInterval != 0 {
+		err = driverstatus.Start(driverstatus.Options{
+			DriverName:   conf.DriverName,
+			DriverType:   conf.Vtype,
+			Version:      util.DriverVersion,
+			GitCommit:    util.GitCommit,
+			Interval:     conf.StatusReportInterval,
+			Namespace:    conf.DriverNamespace,
+			ConfigMap:    conf.StatusReportConfigMap,
+			Features:     featuregate.DefaultGate.EnabledFeatures,
+			Checker:      checker,
+			CountVolumes: rbd.CountVolumes(conf.DriverName),
+		})
+		if err != nil {
+			log.FatalLogMsg("failed to start status reporting: %v", err)
+		}
+	}
+
+	if conf.RBDIOStatsInterval != 0 {
+		err = rbd.StartIOStats(conf.DriverName, conf.DriverNamespace, conf.RBDIOStatsInterval)
+		if err != nil {
+			log.FatalLogMsg("failed to start sampling of image IO: %v", err)
+		}
+	}
+
+	if conf.RBDImageReconcileInterval != 0 {
+		err = rbd.StartImageReconcile(conf.DriverName, conf.DriverNamespace, conf.RBDImageReconcileInterval)
+		if err != nil {
+			log.FatalLogMsg("failed to start reconciling of images: %v", err)
+		}
+	}
+
+	if conf.RBDTempCloneReapInterval != 0 {
+		err = rbd.StartTempCloneReaper(conf.DriverName, conf.DriverNamespace,
+			conf.RBDTempCloneReapInterval, conf.RBDTempCloneTTL)
+		if err != nil {
+			log.FatalLogMsg("failed to start reaping of temporary clones: %v", err)
+		}
+	}
+}
+
+// runVolumeHealer stages the rbd-nbd volumes of the instance that are
+// attached to the node again.
+func (r *Driver) runVolumeHealer(conf *util.Config) {
+	// TODO: move the healer to csi-addons
+	err := rbd.RunVolumeHealer(r.ns, conf)
+	if err != nil {
+		log.ErrorLogMsg("healer had failures, err %v\n", err)
+	}
+}
+
+// setNodeCapabilities records the results of the probes for the features
+// the nodeplugin depends on.
+func setNodeCapabilities(krbdAttr string, krbdFeatures uint) {
+	nodeCaps := util.GetNodeCapabilities()
+	nodeCaps.Set(util.KrbdFeaturesCapability, krbdFeatures != 0, krbdAttr)
+	nodeCaps.Set(util.NBDCapability, rbd.HasNBD(), "")
+
+	version, err := cryptsetup.Version(context.Background())
+	if err != nil {
+		log.WarningLogMsg("failed to detect cryptsetup version: %v", err)
+	}
+	nodeCaps.Set(util.CryptsetupCapability, err == nil, version)
+
+	idMap, err := util.IDMappedMountsSupported(context.Background())
+	if err != nil {
+		log.WarningLogMsg("failed to detect support for ID-mapped mounts: %v", err)
+	}
+	nodeCaps.Set(util.IDMappedMountCapability, idMap, "")
+}
+
+// startAdminServer starts the admin server on the admin endpoint, with the
+// admin and FailoverDrill services of the provisioner and the PauseIO service
+// of the nodeplugin.
+func (r *Driver) startAdminServer(conf *util.Config) error {
+	as, err := admin.NewServer(conf.AdminEndpoint)
+	if err != nil {
+		return fmt.Errorf("failed to create the admin server: %w", err)
+	}
+
+	if conf.IsControllerServer {
+		admin.Register(as, rbd.NewAdminServer(conf.DriverName, r.cs.VolumeLocks))
+
+		if conf.EnableFailoverDrill {
+			fd := rbd.NewFailoverDrill(conf.InstanceID, r.cs.VolumeLocks)
+			fd.RegisterService(as)
+		}
+	}
+
+	if conf.IsNodeServer && conf.PauseIOMaxTTL != 0 {
+		pio := rbd.NewPauseIO(r.ns.Mounter, conf.StagingPath, conf.DriverName, conf.PauseIOMaxTTL)
+		pio.RegisterService(as)
+	}
+
+	err = as.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start the admin server: %w", err)
+	}
+
+	return nil
 }
 
 // setupCSIAddonsServer creates a new CSI-Addons Server on the given (URL)
@@ -224,12 +518,23 @@ func (r *Driver) setupCSIAddonsServer(conf *util.Config) error {
 		return fmt.Errorf("failed to create CSI-Addons server: %w", err)
 	}
 
+	if conf.CSIAddonsTCPEndpoint != "" {
+		err = r.cas.EnableTCP(conf.CSIAddonsTCPEndpoint, csiaddons.TLSFiles{
+			CertFile: conf.CSIAddonsTLSCertFile,
+			KeyFile:  conf.CSIAddonsTLSKeyFile,
+			CAFile:   conf.CSIAddonsTLSCAFile,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure CSI-Addons TCP endpoint: %w", err)
+		}
+	}
+
 	// register services
 	is := casrbd.NewIdentityServer(conf)
 	r.cas.RegisterService(is)
 
 	if conf.IsControllerServer {
-		rs := casrbd.NewReclaimSpaceControllerServer(conf.InstanceID, r.cs.VolumeLocks)
+		rs := casrbd.NewReclaimSpaceControllerServer(conf.InstanceID, r.cs.VolumeLocks, conf.ReclaimSpaceMinInterval)
 		r.cas.RegisterService(rs)
 
 		fcs := casrbd.NewFenceControllerServer()
@@ -238,7 +543,7 @@ func (r *Driver) setupCSIAddonsServer(conf *util.Config) error {
 		rcs := casrbd.NewReplicationServer(conf.InstanceID, NewControllerServer(r.cd))
 		r.cas.RegisterService(rcs)
 
-		vgcs := casrbd.NewVolumeGroupServer(conf.InstanceID)
+		vgcs := casrbd.NewVolumeGroupServer(conf.InstanceID, r.cs.VolumeGroupLocks)
 		r.cas.RegisterService(vgcs)
 	}
 
@@ -246,16 +551,25 @@ func (r *Driver) setupCSIAddonsServer(conf *util.Config) error {
 		fcs := casrbd.NewFenceControllerServer()
 		r.cas.RegisterService(fcs)
 
-		rs := casrbd.NewReclaimSpaceNodeServer(r.ns.VolumeLocks)
+		rs := casrbd.NewReclaimSpaceNodeServer(conf.InstanceID, r.ns.VolumeLocks, conf.ReclaimSpaceMinInterval)
 		r.cas.RegisterService(rs)
 
+		if conf.ReclaimSpaceBatchConcurrency != 0 {
+			brs := casrbd.NewBatchReclaimSpace(rs, r.ns.Mounter,
+				conf.StagingPath, conf.DriverName, conf.ReclaimSpaceBatchConcurrency)
+			r.cas.RegisterService(brs)
+		}
+
 		ekr := casrbd.NewEncryptionKeyRotationServer(conf.InstanceID, r.ns.VolumeLocks)
 		r.cas.RegisterService(ekr)
 	}
 
 	// start the server, this does not block, it runs a new go-routine
 	err = r.cas.Start(csicommon.MiddlewareServerOptionConfig{
-		LogSlowOpInterval: conf.LogSlowOpInterval,
+		LogSlowOpInterval:   conf.LogSlowOpInterval,
+		SlowOpThresholds:    csicommon.SlowOpThresholds(conf),
+		MaintenanceModeFile: util.MaintenanceModeFile,
+		InstanceID:          conf.InstanceID,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to start CSI-Addons server: %w", err)
@@ -267,8 +581,12 @@ func (r *Driver) setupCSIAddonsServer(conf *util.Config) error {
 // startProfiling checks which profiling options are enabled in the config and
 // starts the required profiling services.
 func (r *Driver) startProfiling(conf *util.Config) {
-	if conf.EnableProfiling {
+	if conf.EnableProfiling || conf.UsageReportInterval != 0 || conf.JournalStatsInterval != 0 ||
+		conf.ClusterReadinessInterval != 0 || conf.ValidateClusters || conf.KMSHealthInterval != 0 ||
+		conf.RBDIOStatsInterval != 0 || conf.RBDImageReconcileInterval != 0 || conf.RBDTempCloneReapInterval != 0 {
 		go util.StartMetricsServer(conf)
+	}
+	if conf.EnableProfiling {
 		log.DebugLogMsg("Registering profiling handler")
 		go util.EnableProfiling()
 	}
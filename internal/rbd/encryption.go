@@ -110,6 +110,22 @@ func (ri *rbdImage) isFileEncrypted() bool {
 	return ri.fileEncryption != nil
 }
 
+// encryptionOverhead returns the part of the image that is not usable by the
+// filesystem or the application, because it holds the LUKS header.
+func (ri *rbdImage) encryptionOverhead() int64 {
+	if !ri.isBlockEncrypted() {
+		return 0
+	}
+
+	return cryptsetup.LUKS2HeaderSize
+}
+
+// usableSize returns the capacity of an image of the given size that is
+// usable by the filesystem or the application.
+func (ri *rbdImage) usableSize(size int64) int64 {
+	return max(size-ri.encryptionOverhead(), 0)
+}
+
 func IsFileEncrypted(ctx context.Context, volOptions map[string]string) (bool, error) {
 	_, encType, err := ParseEncryptionOpts(volOptions, util.EncryptionTypeInvalid)
 	if err != nil {
@@ -331,6 +347,23 @@ func (ri *rbdImage) initKMS(ctx context.Context, volOptions, credentials map[str
 	return nil
 }
 
+// checkKMSHealth returns an error wrapping kmsapi.ErrKMSUnavailable when the
+// KMS of the encrypted image failed its last health check.
+func (ri *rbdImage) checkKMSHealth() error {
+	for _, ve := range []*util.VolumeEncryption{ri.blockEncryption, ri.fileEncryption} {
+		if ve == nil {
+			continue
+		}
+
+		err := kmsapi.CheckHealth(ve.GetID())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // ParseEncryptionOpts returns kmsID and sets Owner attribute.
 func ParseEncryptionOpts(
 	volOptions map[string]string,
@@ -20,6 +20,9 @@ import (
 	"testing"
 
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/cryptsetup"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseEncryptionOpts(t *testing.T) {
@@ -96,3 +99,16 @@ func TestParseEncryptionOpts(t *testing.T) {
 		})
 	}
 }
+
+func TestUsableSize(t *testing.T) {
+	t.Parallel()
+
+	plain := &rbdImage{}
+	require.Equal(t, int64(0), plain.encryptionOverhead())
+	require.Equal(t, int64(oneGB), plain.usableSize(oneGB))
+
+	encrypted := &rbdImage{blockEncryption: &util.VolumeEncryption{}}
+	require.Equal(t, int64(cryptsetup.LUKS2HeaderSize), encrypted.encryptionOverhead())
+	require.Equal(t, int64(oneGB), encrypted.usableSize(oneGB+cryptsetup.LUKS2HeaderSize))
+	require.Equal(t, int64(0), encrypted.usableSize(1024))
+}
@@ -0,0 +1,635 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mount "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// ephemeralContextKey is set in the VolumeContext by Kubernetes for
+	// inline ephemeral volumes, when the CSIDriver has podInfoOnMount.
+	ephemeralContextKey = "csi.storage.k8s.io/ephemeral"
+
+	// ephemeralImagePrefix is prepended to the VolumeID for the name of
+	// the image of an ephemeral volume.
+	ephemeralImagePrefix = "csi-ephemeral-"
+
+	// ephemeralSizeKey is the volume attribute with the size of an
+	// ephemeral volume, like "10Gi".
+	ephemeralSizeKey = "size"
+
+	// defaultEphemeralSize is the size of an ephemeral volume without a
+	// size attribute.
+	defaultEphemeralSize = "1Gi"
+
+	// defaultEphemeralFsType is used for ephemeral filesystem volumes
+	// without fsType.
+	defaultEphemeralFsType = "ext4"
+
+	// the pod info keys in the VolumeContext of ephemeral volumes.
+	podNameContextKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceContextKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDContextKey       = "csi.storage.k8s.io/pod.uid"
+
+	// ephemeralRefFileName is the file next to the image metadata stash
+	// with the ephemeralVolumeRef of the volume.
+	ephemeralRefFileName = "ephemeral.json"
+)
+
+// errEphemeralSecretsUnknown is returned when the secrets to delete the image
+// of an ephemeral volume can not be found anymore.
+var errEphemeralSecretsUnknown = errors.New("secrets of the ephemeral volume are not known")
+
+// ephemeralVolumeRef is stored with the image metadata stash of an ephemeral
+// volume. It references the Secret of the volume, so that the image can be
+// deleted after a restart of the nodeplugin. The kubelet does not republish
+// the volumes of a terminating pod, which would pass the secrets again.
+type ephemeralVolumeRef struct {
+	ClusterID       string `json:"clusterID"`
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+}
+
+// ephemeralVolume has the details to delete the image of an ephemeral volume
+// that are not in the image metadata stash.
+type ephemeralVolume struct {
+	monitors  string
+	clusterID string
+	// secrets are needed to delete the image, NodeUnpublishVolume does
+	// not pass them
+	secrets map[string]string
+}
+
+// EphemeralVolumes keeps track of the ephemeral volumes that are published on
+// the node. The image metadata stash of every volume is kept in the directory
+// of the EphemeralVolumes, named after the VolumeID. The secrets to delete the
+// image are only kept in memory, after a restart of the nodeplugin they are
+// read from the Secret in the ephemeralVolumeRef of the volume.
+type EphemeralVolumes struct {
+	dir string
+
+	mu      sync.Mutex
+	volumes map[string]*ephemeralVolume
+}
+
+// NewEphemeralVolumes returns EphemeralVolumes that keeps the image metadata
+// stashes in dir.
+func NewEphemeralVolumes(dir string) *EphemeralVolumes {
+	return &EphemeralVolumes{
+		dir:     dir,
+		volumes: map[string]*ephemeralVolume{},
+	}
+}
+
+// path returns the directory with the image metadata stash of the volume.
+func (ev *EphemeralVolumes) path(volID string) string {
+	return filepath.Join(ev.dir, volID)
+}
+
+// isEphemeral returns true when the volume was published as an ephemeral
+// volume on this node.
+func (ev *EphemeralVolumes) isEphemeral(volID string) bool {
+	if ev == nil {
+		return false
+	}
+
+	return checkRBDImageMetadataStashExists(ev.path(volID))
+}
+
+func (ev *EphemeralVolumes) add(volID string, vol *ephemeralVolume) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	ev.volumes[volID] = vol
+}
+
+func (ev *EphemeralVolumes) get(volID string) *ephemeralVolume {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	return ev.volumes[volID]
+}
+
+func (ev *EphemeralVolumes) remove(volID string) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	delete(ev.volumes, volID)
+}
+
+// isEphemeralVolume returns true when the VolumeContext is of an inline
+// ephemeral volume.
+func isEphemeralVolume(volCtx map[string]string) bool {
+	return volCtx[ephemeralContextKey] == "true"
+}
+
+// getEphemeralVolumeSize returns the size in bytes of an ephemeral volume.
+func getEphemeralVolumeSize(volCtx map[string]string) (int64, error) {
+	size := volCtx[ephemeralSizeKey]
+	if size == "" {
+		size = defaultEphemeralSize
+	}
+
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q for ephemeral volume: %w", size, err)
+	}
+	if quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid size %q for ephemeral volume: must be positive", size)
+	}
+
+	return quantity.Value(), nil
+}
+
+// validateEphemeralVolumeRequest checks the NodePublishVolumeRequest of an
+// ephemeral volume, which does not pass the staging path that
+// util.ValidateNodePublishVolumeRequest requires.
+func validateEphemeralVolumeRequest(req *csi.NodePublishVolumeRequest) error {
+	if req.GetVolumeCapability() == nil {
+		return status.Error(codes.InvalidArgument, "volume capability missing in request")
+	}
+	if req.GetVolumeId() == "" {
+		return status.Error(codes.InvalidArgument, "volume ID missing in request")
+	}
+	if req.GetTargetPath() == "" {
+		return status.Error(codes.InvalidArgument, "target path missing in request")
+	}
+	if len(req.GetSecrets()) == 0 {
+		return status.Error(codes.InvalidArgument, "empty secrets in request, set nodePublishSecretRef")
+	}
+	if req.GetReadonly() {
+		return status.Error(codes.InvalidArgument, "ephemeral volumes can not be read-only")
+	}
+
+	volCtx := req.GetVolumeContext()
+	// the volume is always encrypted with a random key, a KMS is not used
+	if _, ok := volCtx["encrypted"]; ok {
+		return status.Error(codes.InvalidArgument, "ephemeral volumes do not support the encrypted option")
+	}
+	if _, ok := volCtx["encryptionKMSID"]; ok {
+		return status.Error(codes.InvalidArgument, "ephemeral volumes do not support the encryptionKMSID option")
+	}
+
+	return nil
+}
+
+// publishEphemeralVolume creates the image of an inline ephemeral volume,
+// encrypts it with a random passphrase that is never stored and mounts it on
+// the target path. All data is lost when the volume is unpublished.
+func (ns *NodeServer) publishEphemeralVolume(
+	ctx context.Context,
+	req *csi.NodePublishVolumeRequest,
+) (*csi.NodePublishVolumeResponse, error) {
+	if ns.EphemeralVolumes == nil {
+		return nil, status.Error(codes.InvalidArgument,
+			"ephemeral volumes are not enabled, set --feature-gates=EphemeralVolumes=true")
+	}
+
+	err := validateEphemeralVolumeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	targetPath := req.GetTargetPath()
+	isBlock := req.GetVolumeCapability().GetBlock() != nil
+	volID := req.GetVolumeId()
+	volCtx := req.GetVolumeContext()
+
+	if acquired := ns.VolumeLocks.TryAcquire(targetPath); !acquired {
+		log.ErrorLog(ctx, util.TargetPathOperationAlreadyExistsFmt, targetPath)
+
+		return nil, status.Errorf(codes.Aborted, util.TargetPathOperationAlreadyExistsFmt, targetPath)
+	}
+	defer ns.VolumeLocks.Release(targetPath)
+
+	size, err := getEphemeralVolumeSize(volCtx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rv, err := genVolFromVolumeOptions(ctx, volCtx, false, false)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	rv.RbdImageName = ephemeralImagePrefix + volID
+	rv.VolID = volID
+	rv.VolSize = size
+	rv.RequestedVolSize = size
+	rv.ephemeral = true
+
+	vol := &ephemeralVolume{
+		monitors:  rv.Monitors,
+		clusterID: rv.ClusterID,
+		secrets:   req.GetSecrets(),
+	}
+
+	notMnt, err := ns.createTargetMountPath(ctx, targetPath, isBlock)
+	if err != nil {
+		return nil, err
+	}
+	if !notMnt {
+		// republished, or the nodeplugin restarted and lost the secrets
+		ns.EphemeralVolumes.add(volID, vol)
+
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	ref, err := getEphemeralVolumeRef(ctx, volID, rv.ClusterID, volCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := util.NewUserCredentials(req.GetSecrets())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer cr.DeleteCredentials()
+	defer rv.Destroy(ctx)
+
+	err = createImage(ctx, rv, cr)
+	if err != nil && !errors.Is(err, librbd.ErrExist) {
+		log.ErrorLog(ctx, "failed to create ephemeral volume %s: %v", rv, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	ns.EphemeralVolumes.add(volID, vol)
+
+	err = ns.setupEphemeralVolume(ctx, rv, cr, ref, req)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to publish ephemeral volume %s: %v", rv, err)
+
+		if cErr := ns.releaseEphemeralVolume(ctx, volID, targetPath); cErr != nil {
+			log.ErrorLog(ctx, "failed to clean up ephemeral volume %s: %v", rv, cErr)
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.DebugLog(ctx, "rbd: successfully published ephemeral volume %s on %s", rv, targetPath)
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// setupEphemeralVolume stores the reference to the Secret, maps the image, formats it with LUKS and mounts the
+// opened LUKS device on the target path.
+func (ns *NodeServer) setupEphemeralVolume(
+	ctx context.Context,
+	rv *rbdVolume,
+	cr *util.Credentials,
+	ref *ephemeralVolumeRef,
+	req *csi.NodePublishVolumeRequest,
+) error {
+	stashPath := ns.EphemeralVolumes.path(rv.VolID)
+	err := os.MkdirAll(stashPath, 0o750)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", stashPath, err)
+	}
+	err = ref.store(stashPath)
+	if err != nil {
+		return err
+	}
+	// the stash is written before mapping, so that a failed publish can be
+	// cleaned up
+	err = stashRBDImageMetadata(rv, stashPath)
+	if err != nil {
+		return err
+	}
+
+	devicePath, err := attachRBDImage(ctx, rv, "", cr)
+	if err != nil {
+		return err
+	}
+	if rv.Mounter == rbdTonbd && hasNBD {
+		err = updateRBDImageMetadataStash(stashPath, devicePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	passphrase, err := util.NewEncryptionPassphrase(GetEncryptionPassphraseSize())
+	if err != nil {
+		return fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+	err = util.EncryptVolume(ctx, devicePath, passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", devicePath, err)
+	}
+	mapperFile, mapperPath := util.VolumeMapper(rv.VolID)
+	err = util.OpenEncryptedVolume(ctx, devicePath, mapperFile, passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", devicePath, err)
+	}
+
+	diskMounter := &mount.SafeFormatAndMount{Interface: ns.Mounter, Exec: utilexec.New()}
+	targetPath := req.GetTargetPath()
+	fsType := req.GetVolumeCapability().GetMount().GetFsType()
+	if fsType == "" {
+		fsType = defaultEphemeralFsType
+	}
+	opt := mountDefaultOpts[fsType]
+	opt = append(opt, "_netdev")
+	opt = csicommon.ConstructMountOptions(opt, req.GetVolumeCapability())
+
+	if req.GetVolumeCapability().GetBlock() != nil {
+		opt = append(opt, "bind")
+		err = diskMounter.MountSensitiveWithoutSystemd(mapperPath, targetPath, "", opt, nil)
+	} else {
+		err = diskMounter.FormatAndMount(mapperPath, targetPath, fsType, opt)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mount %s on %s: %w", mapperPath, targetPath, err)
+	}
+
+	return nil
+}
+
+// unpublishEphemeralVolume unmounts an ephemeral volume and deletes its image.
+func (ns *NodeServer) unpublishEphemeralVolume(
+	ctx context.Context,
+	req *csi.NodeUnpublishVolumeRequest,
+) (*csi.NodeUnpublishVolumeResponse, error) {
+	err := ns.releaseEphemeralVolume(ctx, req.GetVolumeId(), req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.DebugLog(ctx, "rbd: successfully removed ephemeral volume %s from %s", req.GetVolumeId(), req.GetTargetPath())
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// releaseEphemeralVolume undoes publishEphemeralVolume, the steps that were
+// not done yet are skipped. The secrets to delete the image are looked up
+// before the volume is unmounted, a failed lookup is retried. When the Secret
+// of the volume does not exist anymore, the image can not be deleted and is
+// left behind, the volume is released nevertheless.
+func (ns *NodeServer) releaseEphemeralVolume(ctx context.Context, volID, targetPath string) error {
+	stashPath := ns.EphemeralVolumes.path(volID)
+	imgMeta, err := lookupRBDImageMetadataStash(stashPath)
+	if errors.Is(err, ErrMissingStash) {
+		// failed before the stash was written, or released already
+		err = mount.CleanupMountPoint(targetPath, ns.Mounter, false)
+		if err != nil {
+			return fmt.Errorf("failed to unmount %s: %w", targetPath, err)
+		}
+		err = os.RemoveAll(stashPath)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", stashPath, err)
+		}
+		ns.EphemeralVolumes.remove(volID)
+
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	vol, err := ns.EphemeralVolumes.restore(ctx, volID)
+	if errors.Is(err, errEphemeralSecretsUnknown) {
+		log.ErrorLog(ctx, "image %s of ephemeral volume %s can not be deleted and needs to be removed manually: %v",
+			imgMeta, volID, err)
+	} else if err != nil {
+		return err
+	}
+
+	err = mount.CleanupMountPoint(targetPath, ns.Mounter, false)
+	if err != nil {
+		return fmt.Errorf("failed to unmount %s: %w", targetPath, err)
+	}
+
+	dArgs := detachRBDImageArgs{
+		imageOrDeviceSpec: imgMeta.String(),
+		isImageSpec:       true,
+		isNbd:             imgMeta.Mounter == rbdNbdMounter,
+		encrypted:         imgMeta.Encrypted,
+		volumeID:          volID,
+		unmapOptions:      imgMeta.UnmapOptions,
+		logDir:            imgMeta.LogDir,
+		logStrategy:       imgMeta.LogStrategy,
+	}
+	err = detachRBDImageOrDeviceSpec(ctx, &dArgs)
+	if err != nil {
+		return fmt.Errorf("failed to unmap %s: %w", imgMeta, err)
+	}
+
+	if vol != nil {
+		err = deleteEphemeralImage(ctx, volID, vol, &imgMeta)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.RemoveAll(stashPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", stashPath, err)
+	}
+	ns.EphemeralVolumes.remove(volID)
+
+	return nil
+}
+
+// restore returns the ephemeralVolume with the secrets to delete the image.
+// After a restart of the nodeplugin, the secrets are read from the Secret in
+// the ephemeralVolumeRef of the volume. errEphemeralSecretsUnknown is returned
+// when the volume has no ephemeralVolumeRef, or the Secret was deleted.
+func (ev *EphemeralVolumes) restore(ctx context.Context, volID string) (*ephemeralVolume, error) {
+	if vol := ev.get(volID); vol != nil {
+		return vol, nil
+	}
+
+	ref, err := loadEphemeralVolumeRef(ev.path(volID))
+	if err != nil {
+		return nil, err
+	}
+
+	monitors, err := util.Mons(util.CsiConfigFile, ref.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monitors of cluster %q: %w", ref.ClusterID, err)
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+	secrets, err := getSecret(c, ref.SecretNamespace, ref.SecretName)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: Secret %s/%s does not exist", errEphemeralSecretsUnknown,
+			ref.SecretNamespace, ref.SecretName)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get Secret %s/%s of ephemeral volume %s: %w",
+			ref.SecretNamespace, ref.SecretName, volID, err)
+	}
+	log.DebugLog(ctx, "rbd: restored the secrets of ephemeral volume %s from Secret %s/%s",
+		volID, ref.SecretNamespace, ref.SecretName)
+
+	vol := &ephemeralVolume{
+		monitors:  monitors,
+		clusterID: ref.ClusterID,
+		secrets:   secrets,
+	}
+	ev.add(volID, vol)
+
+	return vol, nil
+}
+
+// deleteEphemeralImage deletes the image of the ephemeral volume with the
+// secrets of vol.
+func deleteEphemeralImage(
+	ctx context.Context,
+	volID string,
+	vol *ephemeralVolume,
+	imgMeta *rbdImageMetadataStash,
+) error {
+	cr, err := util.NewUserCredentials(vol.secrets)
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+
+	rv := &rbdVolume{}
+	rv.VolID = volID
+	rv.Monitors = vol.monitors
+	rv.ClusterID = vol.clusterID
+	rv.Pool = imgMeta.Pool
+	rv.RadosNamespace = imgMeta.RadosNamespace
+	rv.RbdImageName = imgMeta.ImageName
+	defer rv.Destroy(ctx)
+
+	err = rv.Connect(cr)
+	if err != nil {
+		return err
+	}
+
+	err = rv.Delete(ctx)
+	if err != nil && !errors.Is(err, ErrImageNotFound) {
+		return fmt.Errorf("failed to delete image %s: %w", imgMeta, err)
+	}
+
+	return nil
+}
+
+// ephemeralVolumeHandle returns the VolumeID that the kubelet uses for the
+// inline volume with the name of the volume in the pod with the UID.
+func ephemeralVolumeHandle(podUID, volumeName string) string {
+	return fmt.Sprintf("csi-%x", sha256.Sum256([]byte(podUID+volumeName)))
+}
+
+// getEphemeralVolumeRef returns the ephemeralVolumeRef of the volume. The name
+// of the Secret is not passed to NodePublishVolume, it is taken from the
+// nodePublishSecretRef of the inline volume in the pod of the VolumeContext.
+func getEphemeralVolumeRef(
+	ctx context.Context,
+	volID, clusterID string,
+	volCtx map[string]string,
+) (*ephemeralVolumeRef, error) {
+	namespace := volCtx[podNamespaceContextKey]
+	name := volCtx[podNameContextKey]
+	if namespace == "" || name == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"pod info missing in the volume context, set podInfoOnMount in the CSIDriver")
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+	pod, err := c.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get pod %s/%s: %v", namespace, name, err)
+	}
+	if uid := volCtx[podUIDContextKey]; uid != "" && uid != string(pod.UID) {
+		return nil, status.Errorf(codes.FailedPrecondition, "pod %s/%s was recreated", namespace, name)
+	}
+
+	for i := range pod.Spec.Volumes {
+		v := &pod.Spec.Volumes[i]
+		if v.CSI == nil || ephemeralVolumeHandle(string(pod.UID), v.Name) != volID {
+			continue
+		}
+		if v.CSI.NodePublishSecretRef == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "volume %q of pod %s/%s has no nodePublishSecretRef",
+				v.Name, namespace, name)
+		}
+
+		return &ephemeralVolumeRef{
+			ClusterID:       clusterID,
+			SecretName:      v.CSI.NodePublishSecretRef.Name,
+			SecretNamespace: namespace,
+		}, nil
+	}
+
+	return nil, status.Errorf(codes.NotFound, "ephemeral volume %s not found in pod %s/%s", volID, namespace, name)
+}
+
+// store writes the ephemeralVolumeRef into dir.
+func (ref *ephemeralVolumeRef) store(dir string) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reference of ephemeral volume: %w", err)
+	}
+
+	path := filepath.Join(dir, ephemeralRefFileName)
+	err = os.WriteFile(path, data, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEphemeralVolumeRef reads the ephemeralVolumeRef from dir, volumes that
+// were published by older releases do not have it.
+func loadEphemeralVolumeRef(dir string) (*ephemeralVolumeRef, error) {
+	path := filepath.Join(dir, ephemeralRefFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s does not exist", errEphemeralSecretsUnknown, path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	ref := &ephemeralVolumeRef{}
+	err = json.Unmarshal(data, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return ref, nil
+}
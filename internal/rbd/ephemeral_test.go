@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetEphemeralVolumeSize(t *testing.T) {
+	t.Parallel()
+
+	size, err := getEphemeralVolumeSize(map[string]string{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<30), size)
+
+	size, err = getEphemeralVolumeSize(map[string]string{ephemeralSizeKey: "512Mi"})
+	require.NoError(t, err)
+	require.Equal(t, int64(512<<20), size)
+
+	_, err = getEphemeralVolumeSize(map[string]string{ephemeralSizeKey: "lots"})
+	require.Error(t, err)
+
+	_, err = getEphemeralVolumeSize(map[string]string{ephemeralSizeKey: "0"})
+	require.Error(t, err)
+}
+
+func TestValidateEphemeralVolumeRequest(t *testing.T) {
+	t.Parallel()
+
+	newRequest := func() *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:   "csi-1234",
+			TargetPath: "/target",
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			Secrets: map[string]string{"userID": "admin", "userKey": "key"},
+			VolumeContext: map[string]string{
+				ephemeralContextKey: "true",
+				"clusterID":         "cluster",
+				"pool":              "pool",
+			},
+		}
+	}
+
+	req := newRequest()
+	require.True(t, isEphemeralVolume(req.GetVolumeContext()))
+	require.NoError(t, validateEphemeralVolumeRequest(req))
+
+	tests := map[string]func(req *csi.NodePublishVolumeRequest){
+		"no secrets":      func(req *csi.NodePublishVolumeRequest) { req.Secrets = nil },
+		"read-only":       func(req *csi.NodePublishVolumeRequest) { req.Readonly = true },
+		"no target path":  func(req *csi.NodePublishVolumeRequest) { req.TargetPath = "" },
+		"encrypted":       func(req *csi.NodePublishVolumeRequest) { req.VolumeContext["encrypted"] = "true" },
+		"encryptionKMSID": func(req *csi.NodePublishVolumeRequest) { req.VolumeContext["encryptionKMSID"] = "vault" },
+		"no capability":   func(req *csi.NodePublishVolumeRequest) { req.VolumeCapability = nil },
+		"no volume ID":    func(req *csi.NodePublishVolumeRequest) { req.VolumeId = "" },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := newRequest()
+			modify(req)
+			err := validateEphemeralVolumeRequest(req)
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestEphemeralVolumes(t *testing.T) {
+	t.Parallel()
+
+	var disabled *EphemeralVolumes
+	require.False(t, disabled.isEphemeral("csi-1234"))
+
+	root := t.TempDir()
+	ev := NewEphemeralVolumes(root)
+	require.False(t, ev.isEphemeral("csi-1234"))
+
+	newStagingPath(t, root, "csi-1234")
+	require.True(t, ev.isEphemeral("csi-1234"))
+	require.False(t, ev.isEphemeral("csi-5678"))
+
+	require.Nil(t, ev.get("csi-1234"))
+	vol := &ephemeralVolume{monitors: "mon", clusterID: "cluster"}
+	ev.add("csi-1234", vol)
+	require.Same(t, vol, ev.get("csi-1234"))
+	ev.remove("csi-1234")
+	require.Nil(t, ev.get("csi-1234"))
+}
+
+func TestEphemeralVolumeRef(t *testing.T) {
+	t.Parallel()
+
+	ev := NewEphemeralVolumes(t.TempDir())
+	dir := newStagingPath(t, ev.dir, "csi-1234")
+
+	// published by an older release, the image can not be deleted
+	_, err := ev.restore(context.TODO(), "csi-1234")
+	require.ErrorIs(t, err, errEphemeralSecretsUnknown)
+
+	ref := &ephemeralVolumeRef{ClusterID: "cluster", SecretName: "ceph", SecretNamespace: "tenant"}
+	require.NoError(t, ref.store(dir))
+	loaded, err := loadEphemeralVolumeRef(dir)
+	require.NoError(t, err)
+	require.Equal(t, ref, loaded)
+
+	// the secrets are known until the nodeplugin restarts
+	vol := &ephemeralVolume{monitors: "mon", clusterID: "cluster"}
+	ev.add("csi-1234", vol)
+	restored, err := ev.restore(context.TODO(), "csi-1234")
+	require.NoError(t, err)
+	require.Same(t, vol, restored)
+}
+
+func TestEphemeralVolumeHandle(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t,
+		"csi-65fb08edbb7bb4dc9553a6eca019fb5547d7dc478453bb59b97b207e258bda96",
+		ephemeralVolumeHandle("8f1c0b6e-5b1a-4a8e-9f43-2c1d3e4f5a6b", "scratch"))
+}
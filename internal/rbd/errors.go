@@ -57,4 +57,23 @@ var (
 	ErrInvalidArgument = errors.New("invalid arguments provided")
 	// ErrImageInUse is returned when the image is in use.
 	ErrImageInUse = errors.New("image is in use")
+	// ErrRadosNamespaceNotFound is returned when the RADOS namespace does not
+	// exist in the pool, and it should not be created automatically.
+	ErrRadosNamespaceNotFound = errors.New("RADOS namespace not found")
+	// ErrReservationPending is returned when the volume of a reservation is
+	// still being created, and the reservation is not stale yet.
+	ErrReservationPending = errors.New("reservation is pending")
+	// ErrRadosNamespaceQuotaExceeded is returned when the images in a RADOS
+	// namespace would provision more than the quota of the namespace.
+	ErrRadosNamespaceQuotaExceeded = errors.New("RADOS namespace quota exceeded")
+	// ErrPoolDeleted is returned when the pool in the ID of a volume or
+	// snapshot does not exist anymore. It is returned together with
+	// util.ErrPoolNotFound.
+	ErrPoolDeleted = errors.New("pool of the image was deleted")
+	// ErrSnapshotBackedVolume is returned by GenVolFromVolID for volumes that
+	// map their backing snapshot, and do not have an image.
+	ErrSnapshotBackedVolume = errors.New("volume is backed by a snapshot")
+	// ErrLVMVolume is returned by GenVolFromVolID for volumes with the lvm
+	// layout, which are logical volumes in a backing image.
+	ErrLVMVolume = errors.New("volume is a logical volume of a backing image")
 )
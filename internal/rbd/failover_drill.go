@@ -0,0 +1,355 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+const (
+	// failoverDrillSuffix is appended to the name of a secondary image for
+	// the name of its failover drill clone.
+	failoverDrillSuffix = "-drill"
+
+	// failoverDrillKey is the journal attribute of a volume that contains
+	// the name of its failover drill clone.
+	failoverDrillKey = "failoverdrill"
+
+	// snapNamespaceTypeMirror is RBD_SNAP_NAMESPACE_TYPE_MIRROR, go-ceph
+	// does not provide a constant for it.
+	snapNamespaceTypeMirror = librbd.SnapNamespaceType(3)
+)
+
+// mirrorSnapshot is a mirror snapshot of an image that can be cloned for a
+// failover drill.
+type mirrorSnapshot struct {
+	id      uint64
+	created time.Time
+}
+
+// parseLocalSnapshotTime returns the time of the last mirror snapshot that
+// was synchronized to the secondary image, from the description of the
+// mirroring status of the local site. The format of the description is
+// described by getLastSyncInfo of the ReplicationServer.
+func parseLocalSnapshotTime(description string) (time.Time, error) {
+	_, details, found := strings.Cut(description, ",")
+	if !found {
+		return time.Time{}, fmt.Errorf("no snapshot details in %q: %w", description, ErrLastSyncTimeNotFound)
+	}
+
+	var status struct {
+		LocalSnapshotTime int64 `json:"local_snapshot_timestamp"`
+	}
+	err := json.Unmarshal([]byte(details), &status)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to unmarshal local snapshot info: %w", err)
+	}
+	if status.LocalSnapshotTime == 0 {
+		return time.Time{}, fmt.Errorf("empty local snapshot timestamp: %w", ErrLastSyncTimeNotFound)
+	}
+
+	return time.Unix(status.LocalSnapshotTime, 0), nil
+}
+
+// selectDrillSnapshot returns the newest mirror snapshot that was created
+// before synced. Newer mirror snapshots are still being synchronized, and do
+// not contain all data yet.
+func selectDrillSnapshot(snaps []mirrorSnapshot, synced time.Time) (*mirrorSnapshot, error) {
+	var selected *mirrorSnapshot
+	for i := range snaps {
+		// the timestamp in the status has a resolution of seconds
+		if snaps[i].created.Truncate(time.Second).After(synced) {
+			continue
+		}
+		if selected == nil || snaps[i].created.After(selected.created) {
+			selected = &snaps[i]
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("%w: no mirror snapshot has been synchronized", ErrFailedPrecondition)
+	}
+
+	return selected, nil
+}
+
+// failoverDrillName returns the name of the failover drill clone of the
+// image.
+func (rv *rbdVolume) failoverDrillName() string {
+	return rv.RbdImageName + failoverDrillSuffix
+}
+
+// listMirrorSnapshots returns the mirror snapshots of the image.
+func listMirrorSnapshots(image *librbd.Image) ([]mirrorSnapshot, error) {
+	infos, err := image.GetSnapshotNames()
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make([]mirrorSnapshot, 0, len(infos))
+	for _, info := range infos {
+		nsType, err := image.GetSnapNamespaceType(info.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get namespace of snapshot %d: %w", info.Id, err)
+		}
+		if nsType != snapNamespaceTypeMirror {
+			continue
+		}
+
+		ts, err := image.GetSnapTimestamp(info.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get timestamp of snapshot %d: %w", info.Id, err)
+		}
+
+		snaps = append(snaps, mirrorSnapshot{id: info.Id, created: time.Unix(ts.Sec, ts.Nsec)})
+	}
+
+	return snaps, nil
+}
+
+// storeFailoverDrill records the name of the failover drill clone in the
+// journal of the volume, an empty name removes the record.
+func (rv *rbdVolume) storeFailoverDrill(ctx context.Context, cr *util.Credentials, name string) error {
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	err = j.StoreAttribute(ctx, rv.JournalPool, rv.ReservedID, failoverDrillKey, name)
+	if err != nil {
+		return fmt.Errorf("failed to record failover drill image %q of %q: %w", name, rv, err)
+	}
+
+	return nil
+}
+
+// fetchFailoverDrill returns the name of the failover drill clone that is
+// recorded in the journal of the volume, or an empty string.
+func (rv *rbdVolume) fetchFailoverDrill(ctx context.Context, cr *util.Credentials) (string, error) {
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return "", err
+	}
+	defer j.Destroy()
+
+	name, err := j.FetchAttribute(ctx, rv.JournalPool, rv.ReservedID, failoverDrillKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return "", nil
+	}
+
+	return name, err
+}
+
+// StartFailoverDrill clones the newest synchronized mirror snapshot of the
+// secondary image into a writable image in the same pool, and records the
+// clone in the journal of the volume. The image stays secondary, and the
+// rbd-mirror daemon keeps replicating it while the clone is used to test the
+// workload on this site. Calling StartFailoverDrill again returns the
+// existing clone, also when an earlier request failed after cloning.
+func (rv *rbdVolume) StartFailoverDrill(ctx context.Context, cr *util.Credentials) (*types.FailoverDrill, error) {
+	drill, err := rv.GetFailoverDrill(ctx, cr)
+	if err != nil || drill != nil {
+		return drill, err
+	}
+
+	info, err := rv.GetMirroringInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.GetState() != librbd.MirrorImageEnabled.String() {
+		return nil, fmt.Errorf("%w: mirroring is not enabled on %q", ErrFailedPrecondition, rv)
+	}
+	if info.IsPrimary() {
+		return nil, fmt.Errorf("%w: %q is primary, a failover drill needs a secondary image",
+			ErrFailedPrecondition, rv)
+	}
+
+	status, err := rv.GetGlobalMirroringStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	localStatus, err := status.GetLocalSiteStatus()
+	if err != nil {
+		return nil, err
+	}
+	synced, err := parseLocalSnapshotTime(localStatus.GetDescription())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFailedPrecondition, err)
+	}
+
+	image, err := rv.open()
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := listMirrorSnapshots(image)
+	image.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirror snapshots of %q: %w", rv, err)
+	}
+
+	snap, err := selectDrillSnapshot(snaps, synced)
+	if err != nil {
+		return nil, err
+	}
+
+	options := librbd.NewRbdImageOptions()
+	defer options.Destroy()
+	err = options.SetUint64(librbd.ImageOptionCloneFormat, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	// the clone is recorded before it is created, so that a clone that is
+	// left behind by a failed request is adopted or removed by a retry
+	name := rv.failoverDrillName()
+	err = rv.storeFailoverDrill(ctx, cr, name)
+	if err != nil {
+		return nil, err
+	}
+
+	log.DebugLog(ctx, "cloning mirror snapshot %d of %q as failover drill image %q", snap.id, rv, name)
+
+	done := rv.conn.TrackCall("clone_image")
+	err = librbd.CloneImageByID(rv.ioctx, rv.RbdImageName, snap.id, rv.ioctx, name, options)
+	done(err)
+	if errors.Is(err, librbd.ErrExist) {
+		// GetFailoverDrill verifies that the existing image is a clone of
+		// this image
+		return rv.adoptFailoverDrill(ctx, cr)
+	} else if err != nil {
+		rmErr := rv.storeFailoverDrill(ctx, cr, "")
+		if rmErr != nil {
+			log.ErrorLog(ctx, "failed to remove record of failover drill image %q: %v", name, rmErr)
+		}
+
+		return nil, fmt.Errorf("failed to clone mirror snapshot %d of %q as %q: %w", snap.id, rv, name, err)
+	}
+
+	return &types.FailoverDrill{
+		ImageName:    name,
+		SnapshotID:   snap.id,
+		SnapshotTime: snap.created.UTC(),
+	}, nil
+}
+
+// adoptFailoverDrill returns the failover drill clone that was created by an
+// earlier request, after it has been recorded. The record is removed when the
+// image with the name of the clone is not a clone of the image.
+func (rv *rbdVolume) adoptFailoverDrill(ctx context.Context, cr *util.Credentials) (*types.FailoverDrill, error) {
+	drill, err := rv.GetFailoverDrill(ctx, cr)
+	if errors.Is(err, ErrVolNameConflict) {
+		rmErr := rv.storeFailoverDrill(ctx, cr, "")
+		if rmErr != nil {
+			log.ErrorLog(ctx, "failed to remove record of failover drill image %q: %v", rv.failoverDrillName(), rmErr)
+		}
+	}
+	if err == nil && drill == nil {
+		// the image was removed after the clone failed
+		err = fmt.Errorf("failover drill image %q of %q disappeared: %w", rv.failoverDrillName(), rv, ErrImageNotFound)
+	}
+
+	return drill, err
+}
+
+// GetFailoverDrill returns the failover drill clone that is recorded in the
+// journal of the volume, or nil when there is none.
+func (rv *rbdVolume) GetFailoverDrill(ctx context.Context, cr *util.Credentials) (*types.FailoverDrill, error) {
+	err := rv.openIoctx()
+	if err != nil {
+		return nil, err
+	}
+
+	// an image that only happens to have the name of the clone is not a
+	// failover drill, unless it is recorded
+	name, err := rv.fetchFailoverDrill(ctx, cr)
+	if err != nil || name == "" {
+		return nil, err
+	}
+
+	clone, err := librbd.OpenImageReadOnly(rv.ioctx, name, librbd.NoSnapshot)
+	if errors.Is(err, librbd.ErrNotFound) {
+		log.WarningLog(ctx, "recorded failover drill image %q of %q does not exist", name, rv)
+
+		return nil, rv.storeFailoverDrill(ctx, cr, "")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open failover drill image %q: %w", name, err)
+	}
+	defer clone.Close()
+
+	parent, err := clone.GetParent()
+	if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get parent of failover drill image %q: %w", name, err)
+	}
+	// do not touch an image that only happens to have the name
+	if err != nil || parent.Image.ImageName != rv.RbdImageName {
+		return nil, fmt.Errorf("%w: image %q is not a clone of %q", ErrVolNameConflict, name, rv)
+	}
+
+	drill := &types.FailoverDrill{
+		ImageName:  name,
+		SnapshotID: parent.Snap.ID,
+	}
+
+	image, err := rv.open()
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
+
+	snaps, err := listMirrorSnapshots(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirror snapshots of %q: %w", rv, err)
+	}
+	// the rbd-mirror daemon may have moved the mirror snapshot to the trash
+	// namespace already, it is kept there until the clone is removed
+	for _, snap := range snaps {
+		if snap.id == parent.Snap.ID {
+			drill.SnapshotTime = snap.created.UTC()
+		}
+	}
+
+	return drill, nil
+}
+
+// StopFailoverDrill removes the failover drill clone of the image and its
+// record in the journal, if there is one.
+func (rv *rbdVolume) StopFailoverDrill(ctx context.Context, cr *util.Credentials) error {
+	drill, err := rv.GetFailoverDrill(ctx, cr)
+	if err != nil || drill == nil {
+		return err
+	}
+
+	err = librbd.RemoveImage(rv.ioctx, drill.ImageName)
+	if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return fmt.Errorf("failed to remove failover drill image %q: %w", drill.ImageName, err)
+	}
+	log.DebugLog(ctx, "removed failover drill image %q of %q", drill.ImageName, rv)
+
+	return rv.storeFailoverDrill(ctx, cr, "")
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FailoverDrillService is the name of the gRPC service that starts and stops
+// failover drills of secondary volumes. It is served on the admin endpoint of
+// the provisioner, the messages are encoded as JSON.
+const FailoverDrillService = "cephcsi.rbd.v1.FailoverDrill"
+
+var errNoFailoverDrill = errors.New("volume has no failover drill")
+
+// FailoverDrillRequest is the request of the methods of the
+// FailoverDrillService. The secrets contain the credentials to open the image
+// of the volume.
+type FailoverDrillRequest struct {
+	VolumeID string            `json:"volumeID"`
+	Secrets  map[string]string `json:"secrets"`
+}
+
+// FailoverDrillResponse is the response of the methods of the
+// FailoverDrillService, the drill is not set by StopFailoverDrill.
+type FailoverDrillResponse struct {
+	Drill *types.FailoverDrill `json:"drill,omitempty"`
+}
+
+// failoverDrillServer is the interface of the FailoverDrillService.
+type failoverDrillServer interface {
+	StartFailoverDrill(ctx context.Context, req *FailoverDrillRequest) (*FailoverDrillResponse, error)
+	GetFailoverDrill(ctx context.Context, req *FailoverDrillRequest) (*FailoverDrillResponse, error)
+	StopFailoverDrill(ctx context.Context, req *FailoverDrillRequest) (*FailoverDrillResponse, error)
+}
+
+// failoverDrillServiceDesc describes the FailoverDrillService for the gRPC
+// server.
+var failoverDrillServiceDesc = grpc.ServiceDesc{
+	ServiceName: FailoverDrillService,
+	HandlerType: (*failoverDrillServer)(nil),
+	Methods: []grpc.MethodDesc{
+		jsongrpc.UnaryMethod(FailoverDrillService, "StartFailoverDrill", failoverDrillServer.StartFailoverDrill),
+		jsongrpc.UnaryMethod(FailoverDrillService, "GetFailoverDrill", failoverDrillServer.GetFailoverDrill),
+		jsongrpc.UnaryMethod(FailoverDrillService, "StopFailoverDrill", failoverDrillServer.StopFailoverDrill),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// FailoverDrill tests a failover of volumes on the secondary site, while the
+// primary site keeps running the workload. Instead of promoting the image of
+// a volume, its last synchronized mirror snapshot is cloned into a writable
+// image that a static PersistentVolume can use. The mirroring of the volume
+// is not interrupted, and continues while the drill is running.
+type FailoverDrill struct {
+	// getVolume opens the volume, it is replaced by the unit tests
+	getVolume func(ctx context.Context, volumeID string, secrets map[string]string) (
+		types.Volume, func(context.Context), error)
+
+	// volumeLocks are the locks of the controller server, so that a drill
+	// does not run concurrently with other operations on the volume
+	volumeLocks *util.VolumeLocks
+}
+
+var _ failoverDrillServer = &FailoverDrill{}
+
+// NewFailoverDrill returns a FailoverDrill for the volumes of the driver
+// instance, that serializes the requests with the volumeLocks.
+func NewFailoverDrill(driverInstance string, volumeLocks *util.VolumeLocks) *FailoverDrill {
+	return &FailoverDrill{
+		getVolume: func(ctx context.Context, volumeID string, secrets map[string]string) (
+			types.Volume, func(context.Context), error,
+		) {
+			mgr := NewManager(driverInstance, nil, secrets)
+			rbdVol, err := mgr.GetVolumeByID(ctx, volumeID)
+			if err != nil {
+				mgr.Destroy(ctx)
+
+				return nil, nil, fmt.Errorf("failed to find volume with ID %q: %w", volumeID, err)
+			}
+
+			return rbdVol, func(ctx context.Context) {
+				rbdVol.Destroy(ctx)
+				mgr.Destroy(ctx)
+			}, nil
+		},
+		volumeLocks: volumeLocks,
+	}
+}
+
+// RegisterService registers the FailoverDrillService on the admin server.
+func (fd *FailoverDrill) RegisterService(server grpc.ServiceRegistrar) {
+	server.RegisterService(&failoverDrillServiceDesc, fd)
+}
+
+// do opens the volume with the credentials of the request, and calls fn with
+// it while the volume is locked.
+func (fd *FailoverDrill) do(
+	ctx context.Context,
+	method string,
+	req *FailoverDrillRequest,
+	fn func(rbdVol types.Volume, cr *util.Credentials) (*types.FailoverDrill, error),
+) (*FailoverDrillResponse, error) {
+	if req.VolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
+	}
+
+	cr, err := util.NewUserCredentials(req.Secrets)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer cr.DeleteCredentials()
+
+	if acquired := fd.volumeLocks.TryAcquire(req.VolumeID); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, req.VolumeID)
+
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, req.VolumeID)
+	}
+	defer fd.volumeLocks.Release(req.VolumeID)
+
+	rbdVol, destroy, err := fd.getVolume(ctx, req.VolumeID, req.Secrets)
+	if err != nil {
+		log.ErrorLog(ctx, "%s of volume %q failed: %v", method, req.VolumeID, err)
+
+		return nil, status.Error(failoverDrillCode(err), err.Error())
+	}
+	defer destroy(ctx)
+
+	drill, err := fn(rbdVol, cr)
+	if err != nil {
+		log.ErrorLog(ctx, "%s of volume %q failed: %v", method, req.VolumeID, err)
+
+		return nil, status.Error(failoverDrillCode(err), err.Error())
+	}
+
+	return &FailoverDrillResponse{Drill: drill}, nil
+}
+
+// StartFailoverDrill clones the last synchronized mirror snapshot of the
+// volume, and responds with the clone that was created.
+func (fd *FailoverDrill) StartFailoverDrill(
+	ctx context.Context,
+	req *FailoverDrillRequest,
+) (*FailoverDrillResponse, error) {
+	return fd.do(ctx, "StartFailoverDrill", req,
+		func(rbdVol types.Volume, cr *util.Credentials) (*types.FailoverDrill, error) {
+			return rbdVol.StartFailoverDrill(ctx, cr)
+		})
+}
+
+// GetFailoverDrill responds with the running failover drill of the volume.
+func (fd *FailoverDrill) GetFailoverDrill(
+	ctx context.Context,
+	req *FailoverDrillRequest,
+) (*FailoverDrillResponse, error) {
+	return fd.do(ctx, "GetFailoverDrill", req,
+		func(rbdVol types.Volume, cr *util.Credentials) (*types.FailoverDrill, error) {
+			drill, err := rbdVol.GetFailoverDrill(ctx, cr)
+			if err == nil && drill == nil {
+				err = errNoFailoverDrill
+			}
+
+			return drill, err
+		})
+}
+
+// StopFailoverDrill removes the clone of the failover drill of the volume.
+func (fd *FailoverDrill) StopFailoverDrill(
+	ctx context.Context,
+	req *FailoverDrillRequest,
+) (*FailoverDrillResponse, error) {
+	return fd.do(ctx, "StopFailoverDrill", req,
+		func(rbdVol types.Volume, cr *util.Credentials) (*types.FailoverDrill, error) {
+			return nil, rbdVol.StopFailoverDrill(ctx, cr)
+		})
+}
+
+// failoverDrillCode returns the gRPC code for an error of a failover drill
+// request.
+func failoverDrillCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrInvalidArgument):
+		return codes.InvalidArgument
+	case errors.Is(err, errNoFailoverDrill), errors.Is(err, ErrImageNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrVolNameConflict):
+		return codes.AlreadyExists
+	case errors.Is(err, ErrFailedPrecondition):
+		return codes.FailedPrecondition
+	}
+
+	return codes.Internal
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDrillVolume keeps the failover drill in memory, it is secondary unless
+// primary is set.
+type fakeDrillVolume struct {
+	types.Volume
+
+	primary bool
+	drill   *types.FailoverDrill
+}
+
+func (v *fakeDrillVolume) StartFailoverDrill(context.Context, *util.Credentials) (*types.FailoverDrill, error) {
+	if v.primary {
+		return nil, fmt.Errorf("%w: primary", ErrFailedPrecondition)
+	}
+	if v.drill == nil {
+		v.drill = &types.FailoverDrill{ImageName: "csi-vol-1234-drill", SnapshotID: 7}
+	}
+
+	return v.drill, nil
+}
+
+func (v *fakeDrillVolume) GetFailoverDrill(context.Context, *util.Credentials) (*types.FailoverDrill, error) {
+	return v.drill, nil
+}
+
+func (v *fakeDrillVolume) StopFailoverDrill(context.Context, *util.Credentials) error {
+	v.drill = nil
+
+	return nil
+}
+
+func TestFailoverDrillService(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	volumes := map[string]*fakeDrillVolume{
+		"vol-1": {},
+		"vol-2": {primary: true},
+	}
+	fd := &FailoverDrill{
+		getVolume: func(_ context.Context, volumeID string, _ map[string]string) (
+			types.Volume, func(context.Context), error,
+		) {
+			v, ok := volumes[volumeID]
+			if !ok {
+				return nil, nil, ErrImageNotFound
+			}
+
+			return v, func(context.Context) {}, nil
+		},
+		volumeLocks: util.NewVolumeLocks(),
+	}
+	request := func(volumeID string) *FailoverDrillRequest {
+		return &FailoverDrillRequest{VolumeID: volumeID, Secrets: map[string]string{"userID": "admin", "userKey": "key"}}
+	}
+
+	_, err := fd.GetFailoverDrill(ctx, request("vol-1"))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	resp, err := fd.StartFailoverDrill(ctx, request("vol-1"))
+	require.NoError(t, err)
+	require.Equal(t, "csi-vol-1234-drill", resp.Drill.ImageName)
+
+	resp, err = fd.GetFailoverDrill(ctx, request("vol-1"))
+	require.NoError(t, err)
+	require.EqualValues(t, 7, resp.Drill.SnapshotID)
+
+	_, err = fd.StartFailoverDrill(ctx, request("vol-2"))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = fd.StartFailoverDrill(ctx, request("vol-3"))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = fd.StartFailoverDrill(ctx, &FailoverDrillRequest{VolumeID: "vol-1"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a request for a volume with an operation in progress is rejected
+	require.True(t, fd.volumeLocks.TryAcquire("vol-1"))
+	_, err = fd.StopFailoverDrill(ctx, request("vol-1"))
+	require.Equal(t, codes.Aborted, status.Code(err))
+	fd.volumeLocks.Release("vol-1")
+
+	resp, err = fd.StopFailoverDrill(ctx, request("vol-1"))
+	require.NoError(t, err)
+	require.Nil(t, resp.Drill)
+	require.Nil(t, volumes["vol-1"].drill)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocalSnapshotTime(t *testing.T) {
+	t.Parallel()
+
+	synced, err := parseLocalSnapshotTime(`replaying, {"bytes_per_second":0.0,"bytes_per_snapshot":81920.0,` +
+		`"last_snapshot_bytes":81920,"last_snapshot_sync_seconds":0,"local_snapshot_timestamp":1684675261,` +
+		`"remote_snapshot_timestamp":1684675261,"replay_state":"idle"}`)
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1684675261, 0), synced)
+
+	_, err = parseLocalSnapshotTime("starting replay")
+	require.ErrorIs(t, err, ErrLastSyncTimeNotFound)
+
+	_, err = parseLocalSnapshotTime(`replaying, {"replay_state":"syncing"}`)
+	require.ErrorIs(t, err, ErrLastSyncTimeNotFound)
+
+	_, err = parseLocalSnapshotTime("replaying, not json")
+	require.Error(t, err)
+}
+
+func TestSelectDrillSnapshot(t *testing.T) {
+	t.Parallel()
+
+	synced := time.Unix(1684675261, 0)
+	snaps := []mirrorSnapshot{
+		{id: 4, created: synced.Add(-time.Hour)},
+		// the timestamp in the status has no sub-second part
+		{id: 5, created: synced.Add(300 * time.Millisecond)},
+		// still being synchronized
+		{id: 6, created: synced.Add(time.Minute)},
+	}
+
+	snap, err := selectDrillSnapshot(snaps, synced)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, snap.id)
+
+	snap, err = selectDrillSnapshot(snaps, synced.Add(-time.Minute))
+	require.NoError(t, err)
+	require.EqualValues(t, 4, snap.id)
+
+	_, err = selectDrillSnapshot(snaps, synced.Add(-2*time.Hour))
+	require.ErrorIs(t, err, ErrFailedPrecondition)
+
+	_, err = selectDrillSnapshot(nil, synced)
+	require.ErrorIs(t, err, ErrFailedPrecondition)
+}
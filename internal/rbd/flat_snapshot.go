@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+const (
+	// flattenSnapshotParam is the VolumeSnapshotClass parameter that makes
+	// CreateSnapshot flatten the image that backs the snapshot, so that
+	// restoring the snapshot does not depend on the source volume anymore.
+	flattenSnapshotParam = "flattenSnapshot"
+	// flattenSnapshotTimeoutParam is the time CreateSnapshot waits for the
+	// flattening to finish. A snapshot of which the image is not flattened
+	// in time is reported as not created yet, the next CreateSnapshot waits
+	// again.
+	flattenSnapshotTimeoutParam = "flattenSnapshotTimeout"
+
+	defaultFlattenSnapshotTimeout = time.Minute
+	flattenPollInterval           = 2 * time.Second
+)
+
+// parseFlattenSnapshot returns the timeout to wait for the flattening of the
+// snapshot image, or 0 when the snapshot is not flattened right away.
+func parseFlattenSnapshot(parameters map[string]string) (time.Duration, error) {
+	value, ok := parameters[flattenSnapshotParam]
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	flatten, err := strconv.ParseBool(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s %q: %w", flattenSnapshotParam, value, err)
+	}
+	if !flatten {
+		return 0, nil
+	}
+
+	value, ok = parameters[flattenSnapshotTimeoutParam]
+	if !ok || value == "" {
+		return defaultFlattenSnapshotTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s %q: %w", flattenSnapshotTimeoutParam, value, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s %q must be positive", flattenSnapshotTimeoutParam, value)
+	}
+
+	return timeout, nil
+}
+
+// flattenAndWait flattens the image, and waits up to timeout until the image
+// has no parent anymore. ErrFlattenInProgress is returned when the flattening
+// by the Ceph manager takes longer.
+func (ri *rbdImage) flattenAndWait(ctx context.Context, timeout time.Duration) error {
+	err := ri.flattenRbdImage(ctx, true, 0, 0)
+	if err == nil {
+		// flattened without the Ceph manager, or the image had no parent
+		return nil
+	}
+	if !errors.Is(err, ErrFlattenInProgress) {
+		return err
+	}
+
+	policy := util.RetryPolicy{
+		InitialInterval: flattenPollInterval,
+		MaxElapsedTime:  timeout,
+		Retryable:       util.RetryOn(ErrFlattenInProgress),
+	}
+	err = util.Retry(ctx, policy, func(context.Context) error {
+		_, pErr := ri.getParentName()
+		if errors.Is(pErr, librbd.ErrNotFound) {
+			return nil
+		} else if pErr != nil {
+			return pErr
+		}
+
+		return ErrFlattenInProgress
+	})
+	if errors.Is(err, ErrFlattenInProgress) {
+		return fmt.Errorf("%w: image %s was not flattened within %s", ErrFlattenInProgress, ri, timeout)
+	} else if err != nil {
+		return fmt.Errorf("failed to check the parent of image %s: %w", ri, err)
+	}
+
+	ri.untrackFlattenTask(ctx)
+	log.DebugLog(ctx, "image %s is flattened", ri)
+
+	return nil
+}
+
+// flattenSnapshotImage flattens the image that CreateSnapshot cloned for the
+// snapshot.
+func flattenSnapshotImage(ctx context.Context, rbdSnap *rbdSnapshot, cr *util.Credentials) error {
+	vol := rbdSnap.toVolume()
+	defer vol.Destroy(ctx)
+
+	err := vol.Connect(cr)
+	if err != nil {
+		return err
+	}
+
+	return vol.flattenAndWait(ctx, rbdSnap.flattenTimeout)
+}
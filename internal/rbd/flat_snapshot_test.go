@@ -0,0 +1,57 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlattenSnapshot(t *testing.T) {
+	t.Parallel()
+
+	timeout, err := parseFlattenSnapshot(map[string]string{"clusterID": "cluster"})
+	require.NoError(t, err)
+	require.Zero(t, timeout)
+
+	timeout, err = parseFlattenSnapshot(map[string]string{flattenSnapshotParam: "false"})
+	require.NoError(t, err)
+	require.Zero(t, timeout)
+
+	timeout, err = parseFlattenSnapshot(map[string]string{flattenSnapshotParam: "true"})
+	require.NoError(t, err)
+	require.Equal(t, defaultFlattenSnapshotTimeout, timeout)
+
+	timeout, err = parseFlattenSnapshot(map[string]string{
+		flattenSnapshotParam:        "true",
+		flattenSnapshotTimeoutParam: "5m",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, timeout)
+
+	invalid := []map[string]string{
+		{flattenSnapshotParam: "yes please"},
+		{flattenSnapshotParam: "true", flattenSnapshotTimeoutParam: "soon"},
+		{flattenSnapshotParam: "true", flattenSnapshotTimeoutParam: "-1s"},
+	}
+	for _, p := range invalid {
+		_, err = parseFlattenSnapshot(p)
+		require.Error(t, err, p)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+const (
+	// StorageClass parameters that override the flatten flags of the driver.
+	hardMaxCloneDepthKey   = "rbdHardMaxCloneDepth"
+	softMaxCloneDepthKey   = "rbdSoftMaxCloneDepth"
+	maxSnapshotsOnImageKey = "maxSnapshotsOnImage"
+	minSnapshotsOnImageKey = "minSnapshotsOnImageToStartFlatten"
+
+	// flattenPolicyKey is the journal attribute of a volume that stores the
+	// flatten policy of its StorageClass, CreateSnapshot does not receive
+	// the StorageClass parameters.
+	flattenPolicyKey = "flattenpolicy"
+
+	// the same limits as the flags of the driver, see validateCloneDepthFlag
+	// and validateMaxSnapshotFlag.
+	maxCloneDepthLimit       = 14
+	maxSnapshotsOnImageLimit = 500
+)
+
+// flattenPolicy decides when clones and snapshots of an image get flattened.
+type flattenPolicy struct {
+	// HardMaxCloneDepth is the depth of the clone chain at which a new
+	// clone waits until its parent is flattened.
+	HardMaxCloneDepth uint `json:"rbdHardMaxCloneDepth"`
+	// SoftMaxCloneDepth is the depth of the clone chain at which flattening
+	// is started in the background.
+	SoftMaxCloneDepth uint `json:"rbdSoftMaxCloneDepth"`
+	// MaxSnapshotsOnImage is the number of snapshots on an image at which
+	// new snapshots or clones wait for the flattening of the children.
+	MaxSnapshotsOnImage uint `json:"maxSnapshotsOnImage"`
+	// MinSnapshotsOnImageToStartFlatten is the number of snapshots on an
+	// image at which flattening of the children is started in the
+	// background.
+	MinSnapshotsOnImageToStartFlatten uint `json:"minSnapshotsOnImageToStartFlatten"`
+}
+
+// defaultFlattenPolicy returns the policy that is configured with the flags
+// of the driver.
+func defaultFlattenPolicy() flattenPolicy {
+	return flattenPolicy{
+		HardMaxCloneDepth:                 rbdHardMaxCloneDepth,
+		SoftMaxCloneDepth:                 rbdSoftMaxCloneDepth,
+		MaxSnapshotsOnImage:               maxSnapshotsOnImage,
+		MinSnapshotsOnImageToStartFlatten: minSnapshotsOnImageToStartFlatten,
+	}
+}
+
+// parseFlattenPolicy returns the flatten policy of the StorageClass
+// parameters, parameters that are not set are taken from the flags of the
+// driver. nil is returned when none of the parameters is set.
+func parseFlattenPolicy(parameters map[string]string) (*flattenPolicy, error) {
+	policy := defaultFlattenPolicy()
+	custom := false
+
+	for key, dest := range map[string]*uint{
+		hardMaxCloneDepthKey:   &policy.HardMaxCloneDepth,
+		softMaxCloneDepthKey:   &policy.SoftMaxCloneDepth,
+		maxSnapshotsOnImageKey: &policy.MaxSnapshotsOnImage,
+		minSnapshotsOnImageKey: &policy.MinSnapshotsOnImageToStartFlatten,
+	} {
+		value, ok := parameters[key]
+		if !ok {
+			continue
+		}
+
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s %q: %w", key, value, err)
+		}
+		*dest = uint(v)
+		custom = true
+	}
+
+	if !custom {
+		return nil, nil
+	}
+
+	err := policy.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// validate checks the policy against the same limits as the flags of the
+// driver.
+func (fp *flattenPolicy) validate() error {
+	if fp.HardMaxCloneDepth == 0 || fp.HardMaxCloneDepth > maxCloneDepthLimit {
+		return fmt.Errorf("%s should be between 1 and %d", hardMaxCloneDepthKey, maxCloneDepthLimit)
+	}
+
+	if fp.SoftMaxCloneDepth > fp.HardMaxCloneDepth {
+		return fmt.Errorf("%s should not be greater than %s", softMaxCloneDepthKey, hardMaxCloneDepthKey)
+	}
+
+	if fp.MaxSnapshotsOnImage == 0 || fp.MaxSnapshotsOnImage > maxSnapshotsOnImageLimit {
+		return fmt.Errorf("%s should be between 1 and %d", maxSnapshotsOnImageKey, maxSnapshotsOnImageLimit)
+	}
+
+	if fp.MinSnapshotsOnImageToStartFlatten > fp.MaxSnapshotsOnImage {
+		return fmt.Errorf("%s should not be greater than %s", minSnapshotsOnImageKey, maxSnapshotsOnImageKey)
+	}
+
+	return nil
+}
+
+// getFlattenPolicy returns the flatten policy of the image, or the policy of
+// the driver flags if the StorageClass of the image does not set one.
+func (ri *rbdImage) getFlattenPolicy() flattenPolicy {
+	if ri.flattenPolicy != nil {
+		return *ri.flattenPolicy
+	}
+
+	return defaultFlattenPolicy()
+}
+
+// storeFlattenPolicy stores the flatten policy of the volume in the journal,
+// if the StorageClass sets one.
+func (rv *rbdVolume) storeFlattenPolicy(ctx context.Context, cr *util.Credentials) error {
+	if rv.flattenPolicy == nil {
+		return nil
+	}
+
+	value, err := json.Marshal(rv.flattenPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal flatten policy: %w", err)
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	return j.StoreAttribute(ctx, rv.JournalPool, rv.ReservedID, flattenPolicyKey, string(value))
+}
+
+// loadFlattenPolicy reads the flatten policy of the volume from the journal.
+// Volumes that were created without a policy in the StorageClass keep using
+// the flags of the driver.
+func (rv *rbdVolume) loadFlattenPolicy(ctx context.Context, cr *util.Credentials) error {
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	value, err := j.FetchAttribute(ctx, rv.JournalPool, rv.ReservedID, flattenPolicyKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	policy := &flattenPolicy{}
+	err = json.Unmarshal([]byte(value), policy)
+	if err != nil {
+		return fmt.Errorf("failed to parse flatten policy of %q: %w", rv, err)
+	}
+	rv.flattenPolicy = policy
+
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlattenPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := parseFlattenPolicy(map[string]string{"pool": "replicapool"})
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	params := map[string]string{
+		hardMaxCloneDepthKey:   "4",
+		softMaxCloneDepthKey:   "2",
+		maxSnapshotsOnImageKey: "100",
+		minSnapshotsOnImageKey: "50",
+	}
+	policy, err = parseFlattenPolicy(params)
+	require.NoError(t, err)
+	require.Equal(t, &flattenPolicy{
+		HardMaxCloneDepth:                 4,
+		SoftMaxCloneDepth:                 2,
+		MaxSnapshotsOnImage:               100,
+		MinSnapshotsOnImageToStartFlatten: 50,
+	}, policy)
+
+	invalid := []map[string]string{
+		{hardMaxCloneDepthKey: "x"},
+		{hardMaxCloneDepthKey: "15", softMaxCloneDepthKey: "2", maxSnapshotsOnImageKey: "100"},
+		{hardMaxCloneDepthKey: "4", softMaxCloneDepthKey: "5", maxSnapshotsOnImageKey: "100"},
+		{hardMaxCloneDepthKey: "4", softMaxCloneDepthKey: "2", maxSnapshotsOnImageKey: "501"},
+		{
+			hardMaxCloneDepthKey:   "4",
+			softMaxCloneDepthKey:   "2",
+			maxSnapshotsOnImageKey: "100",
+			minSnapshotsOnImageKey: "101",
+		},
+	}
+	for _, p := range invalid {
+		_, err = parseFlattenPolicy(p)
+		require.Error(t, err, "parameters %v", p)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/ceph/go-ceph/rbd/admin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	flattenProgressGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "csi",
+		Subsystem: "rbd",
+		Name:      "flatten_progress",
+		Help:      "Progress of the flatten task of an image, from 0 to 1",
+	}, []string{"pool", "image"})
+	flattenETAGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "csi",
+		Subsystem: "rbd",
+		Name:      "flatten_eta_seconds",
+		Help:      "Estimated time until the flatten task of an image completes",
+	}, []string{"pool", "image"})
+)
+
+// RegisterFlattenMetrics registers the metrics of the flatten tasks with
+// prometheus.
+func RegisterFlattenMetrics() error {
+	for _, c := range []prometheus.Collector{flattenProgressGauge, flattenETAGauge} {
+		err := prometheus.Register(c)
+		if err != nil {
+			return fmt.Errorf("failed to register flatten metrics: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// flattenStatus is the state of a flatten task of the Ceph manager.
+type flattenStatus struct {
+	TaskID string
+	// Progress of the task, from 0 to 1.
+	Progress float64
+	// Elapsed is the time since the task was first seen.
+	Elapsed time.Duration
+	// ETA is the estimated time until the task completes, it is 0 when no
+	// estimate is possible yet.
+	ETA time.Duration
+}
+
+// newFlattenStatus returns the status of the tracked task at the given
+// progress.
+func newFlattenStatus(task *journal.FlattenTask, progress float64, now time.Time) *flattenStatus {
+	elapsed := now.Sub(task.StartTime).Round(time.Second)
+
+	return &flattenStatus{
+		TaskID:   task.ID,
+		Progress: progress,
+		Elapsed:  elapsed,
+		ETA:      flattenETA(progress, elapsed),
+	}
+}
+
+// flattenETA estimates the remaining time of a task from the time it took to
+// reach the progress, assuming the task continues at the same rate.
+func flattenETA(progress float64, elapsed time.Duration) time.Duration {
+	if progress <= 0 || progress >= 1 {
+		return 0
+	}
+
+	return time.Duration(float64(elapsed) * (1 - progress) / progress).Round(time.Second)
+}
+
+// String returns the status like "task 1 is 40% done, ETA 3m0s".
+func (fs *flattenStatus) String() string {
+	eta := "unknown"
+	if fs.ETA != 0 {
+		eta = fs.ETA.String()
+	}
+
+	return fmt.Sprintf("task %s is %.0f%% done, ETA %s", fs.TaskID, fs.Progress*100, eta)
+}
+
+// setFlattenMetrics exports the status of the flatten task of the image.
+func (ri *rbdImage) setFlattenMetrics(fs *flattenStatus) {
+	flattenProgressGauge.WithLabelValues(ri.Pool, ri.RbdImageName).Set(fs.Progress)
+	flattenETAGauge.WithLabelValues(ri.Pool, ri.RbdImageName).Set(fs.ETA.Seconds())
+}
+
+// deleteFlattenMetrics removes the metrics of a flatten task that is done.
+func (ri *rbdImage) deleteFlattenMetrics() {
+	flattenProgressGauge.DeleteLabelValues(ri.Pool, ri.RbdImageName)
+	flattenETAGauge.DeleteLabelValues(ri.Pool, ri.RbdImageName)
+}
+
+// trackFlattenTask records the flatten task that was added for the image in
+// the journal, and returns its status. The start time of a task that was
+// recorded before is kept, so that the ETA is based on the whole runtime of
+// the task. Failing to record the task is not fatal, the image is flattened
+// regardless.
+func (ri *rbdImage) trackFlattenTask(ctx context.Context, task *admin.TaskResponse) *flattenStatus {
+	now := time.Now().UTC()
+	tracked := &journal.FlattenTask{ID: task.ID, StartTime: now}
+
+	j, err := volJournal(ctx).Connect(ri.Monitors, ri.RadosNamespace, ri.conn.Creds)
+	if err != nil {
+		log.WarningLog(ctx, "failed to connect to journal to track flatten task of %s: %v", ri, err)
+	} else {
+		defer j.Destroy()
+
+		prev, gErr := j.GetFlattenTask(ctx, ri.Pool, ri.RbdImageName)
+		if gErr != nil {
+			log.WarningLog(ctx, "failed to get flatten task of %s: %v", ri, gErr)
+		}
+		if prev != nil && prev.ID == task.ID {
+			tracked = prev
+		} else if sErr := j.SetFlattenTask(ctx, ri.Pool, ri.RbdImageName, tracked); sErr != nil {
+			log.WarningLog(ctx, "failed to track flatten task of %s: %v", ri, sErr)
+		}
+	}
+
+	fs := newFlattenStatus(tracked, task.Progress, now)
+	ri.setFlattenMetrics(fs)
+
+	return fs
+}
+
+// untrackFlattenTask removes the flatten task of an image that has no parent
+// anymore.
+func (ri *rbdImage) untrackFlattenTask(ctx context.Context) {
+	ri.deleteFlattenMetrics()
+
+	j, err := volJournal(ctx).Connect(ri.Monitors, ri.RadosNamespace, ri.conn.Creds)
+	if err != nil {
+		log.WarningLog(ctx, "failed to connect to journal to remove flatten task of %s: %v", ri, err)
+
+		return
+	}
+	defer j.Destroy()
+
+	err = j.RemoveFlattenTask(ctx, ri.Pool, ri.RbdImageName)
+	if err != nil {
+		log.WarningLog(ctx, "failed to remove flatten task of %s: %v", ri, err)
+	}
+}
+
+// getFlattenStatus returns the status of the flatten task that is tracked for
+// the image, or nil when the image is not being flattened.
+func (ri *rbdImage) getFlattenStatus(ctx context.Context) (*flattenStatus, error) {
+	j, err := volJournal(ctx).Connect(ri.Monitors, ri.RadosNamespace, ri.conn.Creds)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	tracked, err := j.GetFlattenTask(ctx, ri.Pool, ri.RbdImageName)
+	if err != nil || tracked == nil {
+		return nil, err
+	}
+
+	ta, err := ri.conn.GetTaskAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := ta.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks of the Ceph manager: %w", err)
+	}
+
+	for i := range tasks {
+		if tasks[i].ID == tracked.ID {
+			fs := newFlattenStatus(tracked, tasks[i].Progress, time.Now().UTC())
+			ri.setFlattenMetrics(fs)
+
+			return fs, nil
+		}
+	}
+
+	// the task completed or was cancelled
+	log.DebugLog(ctx, "flatten task %s of %s is not pending anymore", tracked.ID, ri)
+	ri.deleteFlattenMetrics()
+	err = j.RemoveFlattenTask(ctx, ri.Pool, ri.RbdImageName)
+	if err != nil {
+		log.WarningLog(ctx, "failed to remove flatten task of %s: %v", ri, err)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlattenETA(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		progress float64
+		elapsed  time.Duration
+		want     time.Duration
+	}{
+		{"no progress", 0, time.Minute, 0},
+		{"quarter done", 0.25, time.Minute, 3 * time.Minute},
+		{"half done", 0.5, 90 * time.Second, 90 * time.Second},
+		{"done", 1, time.Minute, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, flattenETA(tt.progress, tt.elapsed))
+		})
+	}
+}
+
+func TestFlattenStatusString(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	task := &journal.FlattenTask{ID: "1", StartTime: start}
+
+	fs := newFlattenStatus(task, 0.4, start.Add(2*time.Minute))
+	require.Equal(t, 2*time.Minute, fs.Elapsed)
+	require.Equal(t, "task 1 is 40% done, ETA 3m0s", fs.String())
+
+	fs = newFlattenStatus(task, 0, start.Add(time.Minute))
+	require.Equal(t, "task 1 is 0% done, ETA unknown", fs.String())
+}
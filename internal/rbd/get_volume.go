@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// ControllerGetVolume returns the current state of a volume. The volume is
+// reported as abnormal while a flatten task of the Ceph manager is pending for
+// its image, the condition contains the progress of the task. As the request
+// carries no secrets, the credentials are taken from a StorageClass for the
+// cluster of the volume, like for ListVolumes.
+func (cs *ControllerServer) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+	if err := cs.Driver.ValidateControllerServiceRequest(
+		csi.ControllerServiceCapability_RPC_GET_VOLUME); err != nil {
+		log.ErrorLog(ctx, "invalid get volume req: %v", req)
+
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
+	}
+
+	var vi util.CSIIdentifier
+	err := vi.DecomposeCSIID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "error decoding volume ID %q: %v", volumeID, err)
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+
+	secrets, err := cs.getStorageClassSecrets(ctx, c, vi.ClusterID, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	cr, err := util.NewUserCredentials(secrets)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer cr.DeleteCredentials()
+
+	rbdVol, err := GenVolFromVolID(ctx, volumeID, cr, secrets)
+	if rbdVol != nil {
+		defer rbdVol.Destroy(ctx)
+	}
+	if err != nil {
+		if errors.Is(err, ErrImageNotFound) || errors.Is(err, util.ErrKeyNotFound) {
+			return nil, status.Errorf(codes.NotFound, "volume %q does not exist", volumeID)
+		}
+		log.ErrorLog(ctx, "failed to get backend image for %s: %v", volumeID, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	condition, err := getFlattenCondition(ctx, rbdVol)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to get flatten status of %s: %v", rbdVol, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	volStatus := &csi.ControllerGetVolumeResponse_VolumeStatus{
+		VolumeCondition: condition,
+	}
+	image, err := rbdVol.open()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer image.Close()
+
+	watchers, err := image.ListWatchers()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list watchers of image %s: %v", rbdVol, err)
+	}
+	nodes := getNodesByAddress(ctx, c)
+	for _, w := range watchers {
+		node, ok := nodes[watcherIP(w.Addr)]
+		if ok && !slices.Contains(volStatus.PublishedNodeIds, node) {
+			volStatus.PublishedNodeIds = append(volStatus.PublishedNodeIds, node)
+		}
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: rbdVol.VolSize,
+		},
+		Status: volStatus,
+	}, nil
+}
+
+// getStorageClassSecrets returns the provisioner secrets of a StorageClass of
+// the driver for the cluster of the volume, for requests that do not carry
+// secrets.
+func (cs *ControllerServer) getStorageClassSecrets(
+	ctx context.Context,
+	c *k8s.Clientset,
+	clusterID, volumeID string,
+) (map[string]string, error) {
+	sources, err := getListVolumesSources(ctx, c, cs.Driver.GetName())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	i := slices.IndexFunc(sources, func(s *listVolumesSource) bool {
+		return s.ClusterID == clusterID
+	})
+	if i == -1 {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"no StorageClass with credentials for cluster %q of volume %q", clusterID, volumeID)
+	}
+
+	secrets, err := getSecret(c, sources[i].SecretNamespace, sources[i].SecretName)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return secrets, nil
+}
+
+// getFlattenCondition returns the condition of the volume, based on the
+// flatten tasks of its image and of the temporary clone that is used while
+// cloning a volume. A flatten in progress is not abnormal, its progress is
+// only reported in the message.
+func getFlattenCondition(ctx context.Context, rbdVol *rbdVolume) (*csi.VolumeCondition, error) {
+	tempClone := rbdVol.generateTempClone()
+	defer tempClone.Destroy(ctx)
+
+	for _, ri := range []*rbdImage{&rbdVol.rbdImage, &tempClone.rbdImage} {
+		fs, err := ri.getFlattenStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if fs != nil {
+			return &csi.VolumeCondition{
+				Abnormal: false,
+				Message:  fmt.Sprintf("volume is healthy, image %s is being flattened, %s", ri.RbdImageName, fs),
+			}, nil
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "volume is healthy",
+	}, nil
+}
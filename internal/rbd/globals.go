@@ -17,16 +17,23 @@ limitations under the License.
 package rbd
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
 )
 
 var (
-	// volJournal and snapJournal are used to maintain RADOS based journals for CO generated
-	// VolumeName to backing RBD images.
-	volJournal  *journal.Config
-	snapJournal *journal.Config
+	// journals are the RADOS based journals for CO generated VolumeName to
+	// backing RBD images, of the instances of the driver by instance ID.
+	journals      = map[string]*instanceJournals{}
+	journalsMutex sync.RWMutex
+	// defaultInstance is the instance of the journals that are used when
+	// the context does not carry an instance ID, like in background tasks.
+	defaultInstance string
+
 	// rbdHardMaxCloneDepth is the hard limit for maximum number of nested volume clones that are taken before flatten
 	// occurs.
 	rbdHardMaxCloneDepth uint
@@ -80,13 +87,73 @@ func SetGlobalBool(name string, value bool) {
 	}
 }
 
-// InitJournals initializes the global journals that are used by the rbd
-// package. This is called from the rbd-driver on startup.
+// instanceJournals are the volume and snapshot journals of an instance of the
+// driver.
+type instanceJournals struct {
+	instance string
+	vol      *journal.Config
+	snap     *journal.Config
+}
+
+// InitJournals initializes the journals of the instance of the driver that are
+// used by the rbd package. This is called from the rbd-driver on startup, for
+// every instance that the process serves. The journals of the first instance
+// are used when the context of an operation does not carry an instance ID.
 //
 // TODO: these global journals should be set in the ControllerService and
 // NodeService where appropriate. Using global journals limits the ability to
 // configure these options based on the Ceph cluster or StorageClass.
 func InitJournals(instance string) {
-	volJournal = journal.NewCSIVolumeJournal(instance)
-	snapJournal = journal.NewCSISnapshotJournal(instance)
+	journalsMutex.Lock()
+	defer journalsMutex.Unlock()
+
+	if defaultInstance == "" {
+		defaultInstance = instance
+	}
+	journals[instance] = newInstanceJournals(instance)
+}
+
+func newInstanceJournals(instance string) *instanceJournals {
+	return &instanceJournals{
+		instance: instance,
+		vol:      journal.NewCSIVolumeJournal(instance),
+		snap:     journal.NewCSISnapshotJournal(instance),
+	}
+}
+
+// journalsOf returns the journals of the instance in ctx, see
+// util.WithInstanceID.
+func journalsOf(ctx context.Context) *instanceJournals {
+	instance := util.InstanceIDFromContext(ctx)
+
+	journalsMutex.RLock()
+	if instance == "" {
+		instance = defaultInstance
+	}
+	ij, ok := journals[instance]
+	journalsMutex.RUnlock()
+	if ok {
+		return ij
+	}
+
+	// an instance that was not initialized, like in the controllers that
+	// regenerate the journal for an instance ID
+	journalsMutex.Lock()
+	defer journalsMutex.Unlock()
+	if ij, ok = journals[instance]; !ok {
+		ij = newInstanceJournals(instance)
+		journals[instance] = ij
+	}
+
+	return ij
+}
+
+// volJournal returns the volume journal of the instance in ctx.
+func volJournal(ctx context.Context) *journal.Config {
+	return journalsOf(ctx).vol
+}
+
+// snapJournal returns the snapshot journal of the instance in ctx.
+func snapJournal(ctx context.Context) *journal.Config {
+	return journalsOf(ctx).snap
 }
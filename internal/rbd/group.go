@@ -22,7 +22,9 @@ import (
 
 	librbd "github.com/ceph/go-ceph/rbd"
 
+	rbd_group "github.com/ceph/ceph-csi/internal/rbd/group"
 	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
 // AddToGroup adds the image to the group. This is called from the rbd_group
@@ -46,6 +48,16 @@ func (rv *rbdVolume) AddToGroup(ctx context.Context, vg types.VolumeGroup) error
 	}
 	defer image.Close()
 
+	// librbd rejects images in another RADOS namespace than the group
+	namespace, err := ioctx.GetNamespace()
+	if err != nil {
+		return fmt.Errorf("could not get namespace of volume group %q: %w", vg, err)
+	}
+	if namespace != rv.RadosNamespace {
+		return fmt.Errorf("%w: image %q is in RADOS namespace %q, volume group %q in %q",
+			rbd_group.ErrIncompatibleVolume, rv, rv.RadosNamespace, vg, namespace)
+	}
+
 	info, err := image.GetGroup()
 	if err != nil {
 		return fmt.Errorf("could not get group information for image %q: %w", rv, err)
@@ -53,6 +65,11 @@ func (rv *rbdVolume) AddToGroup(ctx context.Context, vg types.VolumeGroup) error
 
 	if info.Name != "" && info.Name != name {
 		return fmt.Errorf("image %q is already part of volume group %q", rv, info.Name)
+	} else if info.Name == name {
+		// added by an earlier attempt that failed to update the journal
+		log.DebugLog(ctx, "image %q is already part of volume group %q", rv, name)
+
+		return nil
 	}
 
 	err = librbd.GroupImageAdd(ioctx, name, rv.ioctx, rv.RbdImageName)
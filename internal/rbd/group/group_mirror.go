@@ -0,0 +1,699 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/ceph/go-ceph/rbd/admin"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+const (
+	// groupMirrorTimeout is the timeout for the rbd commands that manage
+	// the mirroring of a group, the Replication RPC timeout is 2.5
+	// minutes.
+	groupMirrorTimeout = 2 * time.Minute
+
+	// groupDivergenceSnapshotPrefix is the prefix of the names of the
+	// group snapshots that are taken when a group is force-promoted.
+	groupDivergenceSnapshotPrefix = "csi-divergence-"
+
+	groupDivergenceTimeFormat = "20060102T150405Z"
+
+	// lastUpdateFormat is the format of the "last_update" time in the
+	// mirroring status that the rbd command reports.
+	lastUpdateFormat = time.DateTime
+)
+
+// ErrMirroringNotSupported is returned for mirroring operations that RBD does
+// not provide for groups.
+var ErrMirroringNotSupported = errors.New("operation is not supported for mirrored volume groups")
+
+// verify that volumeGroup implements the Mirror interface.
+var _ types.Mirror = &volumeGroup{}
+
+// ToMirror returns the types.Mirror to manage the mirroring of the RBD group
+// as a whole. All images in the group are mirrored consistently with each
+// other.
+func (vg *volumeGroup) ToMirror() (types.Mirror, error) {
+	return vg, nil
+}
+
+// GetMirroringState returns the mirroring of the group as it is recorded in
+// the journal, nil if mirroring was not enabled on the group.
+func (vg *volumeGroup) GetMirroringState(ctx context.Context) (*journal.MirroringState, error) {
+	return vg.mirroringState, nil
+}
+
+// setMirroringState stores the state in the journal of the group.
+func (vg *volumeGroup) setMirroringState(ctx context.Context, state *journal.MirroringState) error {
+	j, err := vg.getJournal(ctx)
+	if err != nil {
+		return err
+	}
+
+	state.UpdateTime = time.Now().UTC()
+	err = j.SetMirroringState(ctx, vg.pool, vg.objectUUID, state)
+	if err != nil {
+		return fmt.Errorf("failed to set mirroring state for volume group %q: %w", vg, err)
+	}
+	vg.mirroringState = state
+
+	return nil
+}
+
+// updateMirroringState changes the recorded state of a group that is mirrored
+// already. Groups that are mirrored without a recorded state, like groups on
+// a secondary site, get a new state.
+func (vg *volumeGroup) updateMirroringState(ctx context.Context, update func(*journal.MirroringState)) error {
+	state := &journal.MirroringState{
+		Mode: librbd.ImageMirrorModeSnapshot.String(),
+	}
+	if vg.mirroringState != nil {
+		*state = *vg.mirroringState
+	}
+	update(state)
+
+	return vg.setMirroringState(ctx, state)
+}
+
+// execMirrorCommand runs the rbd command with the given arguments and the
+// credentials of the group, and returns the output of the command.
+func (vg *volumeGroup) execMirrorCommand(ctx context.Context, args ...string) (string, error) {
+	if vg.credentials == nil {
+		return "", fmt.Errorf("can not manage mirroring of volume group %q without credentials", vg)
+	}
+
+	args = append(args,
+		"--id", vg.credentials.ID,
+		"-m", vg.monitors,
+		"--keyfile="+vg.credentials.KeyFile)
+
+	stdout, _, err := util.ExecCommandWithTimeout(ctx, groupMirrorTimeout, "rbd", args...)
+	if err != nil {
+		return "", err
+	}
+
+	return stdout, nil
+}
+
+// groupSpec returns the pool/{namespace}/group spec that the rbd command uses
+// to identify the group.
+func (vg *volumeGroup) groupSpec(ctx context.Context) (string, error) {
+	name, err := vg.GetName(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return mirrorGroupSpec(vg.pool, vg.namespace, name), nil
+}
+
+func mirrorGroupSpec(pool, namespace, name string) string {
+	if namespace != "" {
+		return fmt.Sprintf("%s/%s/%s", pool, namespace, name)
+	}
+
+	return fmt.Sprintf("%s/%s", pool, name)
+}
+
+// EnableMirroring enables mirroring on the group, only snapshot based
+// mirroring is supported for groups.
+func (vg *volumeGroup) EnableMirroring(ctx context.Context, mode librbd.ImageMirrorMode) error {
+	if mode != librbd.ImageMirrorModeSnapshot {
+		return fmt.Errorf("%w: %s mirroring of volume group %q, only snapshot mirroring is possible",
+			ErrMirroringNotSupported, mode, vg)
+	}
+
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = vg.execMirrorCommand(ctx, "mirror", "group", "enable", spec, mode.String())
+	if err != nil {
+		return fmt.Errorf("failed to enable mirroring on volume group %q: %w", vg, err)
+	}
+
+	return vg.setMirroringState(ctx, &journal.MirroringState{
+		Mode:    mode.String(),
+		Primary: true,
+	})
+}
+
+// DisableMirroring disables mirroring on the group, and removes the mirroring
+// state from the journal.
+func (vg *volumeGroup) DisableMirroring(ctx context.Context, force bool) error {
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"mirror", "group", "disable", spec}
+	if force {
+		args = append(args, "--force")
+	}
+
+	_, err = vg.execMirrorCommand(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("failed to disable mirroring on volume group %q: %w", vg, err)
+	}
+
+	j, err := vg.getJournal(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = j.RemoveMirroringState(ctx, vg.pool, vg.objectUUID)
+	if err != nil {
+		return fmt.Errorf("failed to remove mirroring state for volume group %q: %w", vg, err)
+	}
+	vg.mirroringState = nil
+
+	return nil
+}
+
+// GetMirroringMode returns the mirroring mode of the group.
+func (vg *volumeGroup) GetMirroringMode(ctx context.Context) (librbd.ImageMirrorMode, error) {
+	info, err := vg.getGroupMirrorInfo(ctx)
+	if err != nil {
+		return librbd.ImageMirrorModeSnapshot, err
+	}
+
+	if info.Mode != "" && info.Mode != librbd.ImageMirrorModeSnapshot.String() {
+		return librbd.ImageMirrorModeJournal, nil
+	}
+
+	return librbd.ImageMirrorModeSnapshot, nil
+}
+
+// Promote promotes the group to primary.
+func (vg *volumeGroup) Promote(ctx context.Context, force bool) error {
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"mirror", "group", "promote", spec}
+	if force {
+		args = append(args, "--force")
+	}
+
+	_, err = vg.execMirrorCommand(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("failed to promote volume group %q with error: %w", vg, err)
+	}
+
+	return vg.updateMirroringState(ctx, func(state *journal.MirroringState) {
+		state.Primary = true
+	})
+}
+
+// ForcePromote promotes the group to primary with the force option. The rbd
+// command is killed when it does not finish within 2 minutes.
+func (vg *volumeGroup) ForcePromote(ctx context.Context, _ *util.Credentials) error {
+	return vg.Promote(ctx, true)
+}
+
+// Demote demotes the group to secondary.
+func (vg *volumeGroup) Demote(ctx context.Context) error {
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = vg.execMirrorCommand(ctx, "mirror", "group", "demote", spec)
+	if err != nil {
+		return fmt.Errorf("failed to demote volume group %q with error: %w", vg, err)
+	}
+
+	return vg.updateMirroringState(ctx, func(state *journal.MirroringState) {
+		state.Primary = false
+		state.ResyncTime = nil
+	})
+}
+
+// Resync flags the group for resynchronization to correct a split-brain, the
+// images of the group are recreated from the primary site.
+func (vg *volumeGroup) Resync(ctx context.Context) error {
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = vg.execMirrorCommand(ctx, "mirror", "group", "resync", spec)
+	if err != nil {
+		return fmt.Errorf("failed to resync volume group %q with error: %w", vg, err)
+	}
+
+	return vg.updateMirroringState(ctx, func(state *journal.MirroringState) {
+		now := time.Now().UTC()
+		state.ResyncTime = &now
+	})
+}
+
+// ClearResync removes the time of the last resync from the mirroring state,
+// once the group has been resynchronized.
+func (vg *volumeGroup) ClearResync(ctx context.Context) error {
+	if vg.mirroringState == nil || vg.mirroringState.ResyncTime == nil {
+		return nil
+	}
+
+	return vg.updateMirroringState(ctx, func(state *journal.MirroringState) {
+		state.ResyncTime = nil
+	})
+}
+
+// groupMirrorInfo is the "mirroring" section of the group info that the rbd
+// command reports.
+type groupMirrorInfo struct {
+	Mode     string `json:"mode"`
+	State    string `json:"state"`
+	GlobalID string `json:"global_id"`
+	Primary  bool   `json:"primary"`
+}
+
+func (info groupMirrorInfo) GetState() string {
+	if info.State == "" {
+		return librbd.MirrorImageDisabled.String()
+	}
+
+	return info.State
+}
+
+func (info groupMirrorInfo) IsPrimary() bool {
+	return info.Primary
+}
+
+// parseGroupMirrorInfo decodes the output of `rbd group info --format json`,
+// the mirroring section is missing when mirroring is disabled.
+func parseGroupMirrorInfo(output string) (groupMirrorInfo, error) {
+	var info struct {
+		Mirroring groupMirrorInfo `json:"mirroring"`
+	}
+
+	err := json.Unmarshal([]byte(output), &info)
+	if err != nil {
+		return groupMirrorInfo{}, fmt.Errorf("failed to parse group info: %w", err)
+	}
+
+	return info.Mirroring, nil
+}
+
+func (vg *volumeGroup) getGroupMirrorInfo(ctx context.Context) (groupMirrorInfo, error) {
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return groupMirrorInfo{}, err
+	}
+
+	output, err := vg.execMirrorCommand(ctx, "group", "info", spec, "--format", "json")
+	if err != nil {
+		return groupMirrorInfo{}, fmt.Errorf("failed to get info of volume group %q: %w", vg, err)
+	}
+
+	return parseGroupMirrorInfo(output)
+}
+
+// GetMirroringInfo returns the mirroring state of the group, and if the group
+// is primary.
+func (vg *volumeGroup) GetMirroringInfo(ctx context.Context) (types.MirrorInfo, error) {
+	info, err := vg.getGroupMirrorInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// groupSiteStatus is the status of the group on a site, as the rbd command
+// reports it.
+type groupSiteStatus struct {
+	SiteName    string `json:"site_name"`
+	MirrorUUID  string `json:"mirror_uuids"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	LastUpdate  string `json:"last_update"`
+}
+
+func (status groupSiteStatus) GetMirrorUUID() string {
+	return status.MirrorUUID
+}
+
+// IsUP returns true when the rbd-mirror daemon on the site is running, the
+// state is reported as "up+replaying" or "down+stopped".
+func (status groupSiteStatus) IsUP() bool {
+	return strings.HasPrefix(status.State, "up+")
+}
+
+func (status groupSiteStatus) GetState() string {
+	_, state, found := strings.Cut(status.State, "+")
+	if !found {
+		return status.State
+	}
+
+	return state
+}
+
+func (status groupSiteStatus) GetDescription() string {
+	return status.Description
+}
+
+func (status groupSiteStatus) GetLastUpdate() time.Time {
+	t, err := time.ParseInLocation(lastUpdateFormat, status.LastUpdate, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+
+	// convert the last update time to UTC
+	return t.UTC()
+}
+
+// groupMirrorStatus is the global mirroring status of a group, it combines
+// the output of `rbd mirror group status` with the mirroring info of the
+// group.
+type groupMirrorStatus struct {
+	groupMirrorInfo
+
+	local groupSiteStatus
+	peers []groupSiteStatus
+}
+
+// parseGroupMirrorStatus decodes the output of `rbd mirror group status
+// --format json`.
+func parseGroupMirrorStatus(output string, info groupMirrorInfo) (*groupMirrorStatus, error) {
+	var status struct {
+		State       string            `json:"state"`
+		Description string            `json:"description"`
+		LastUpdate  string            `json:"last_update"`
+		PeerSites   []groupSiteStatus `json:"peer_sites"`
+	}
+
+	err := json.Unmarshal([]byte(output), &status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse group mirroring status: %w", err)
+	}
+
+	return &groupMirrorStatus{
+		groupMirrorInfo: info,
+		local: groupSiteStatus{
+			State:       status.State,
+			Description: status.Description,
+			LastUpdate:  status.LastUpdate,
+		},
+		peers: status.PeerSites,
+	}, nil
+}
+
+func (status *groupMirrorStatus) GetLocalSiteStatus() (types.SiteStatus, error) {
+	if status.local.State == "" {
+		return status.local, errors.New("failed to get local site status: no status reported")
+	}
+
+	return status.local, nil
+}
+
+func (status *groupMirrorStatus) GetAllSitesStatus() []types.SiteStatus {
+	siteStatuses := []types.SiteStatus{status.local}
+	for _, ss := range status.peers {
+		siteStatuses = append(siteStatuses, ss)
+	}
+
+	return siteStatuses
+}
+
+func (status *groupMirrorStatus) GetRemoteSiteStatus(ctx context.Context) (types.SiteStatus, error) {
+	for _, ss := range status.peers {
+		log.DebugLog(
+			ctx,
+			"Site status of MirrorUUID: %s, state: %s, description: %s, lastUpdate: %v",
+			ss.MirrorUUID,
+			ss.State,
+			ss.Description,
+			ss.LastUpdate)
+
+		if ss.MirrorUUID != "" {
+			return ss, nil
+		}
+	}
+
+	return groupSiteStatus{}, librbd.ErrNotExist
+}
+
+func (status *groupMirrorStatus) GetRemoteSitesStatus() []types.SiteStatus {
+	var siteStatuses []types.SiteStatus
+	for _, ss := range status.peers {
+		if ss.MirrorUUID != "" {
+			siteStatuses = append(siteStatuses, ss)
+		}
+	}
+
+	return siteStatuses
+}
+
+// GetGlobalMirroringStatus returns the mirroring status of the group on the
+// local and the remote sites.
+func (vg *volumeGroup) GetGlobalMirroringStatus(ctx context.Context) (types.GlobalStatus, error) {
+	info, err := vg.getGroupMirrorInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := vg.groupSpec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := vg.execMirrorCommand(ctx, "mirror", "group", "status", spec, "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mirroring status of volume group %q: %w", vg, err)
+	}
+
+	status, err := parseGroupMirrorStatus(output, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// AddSnapshotScheduling adds a mirror snapshot schedule for the group.
+func (vg *volumeGroup) AddSnapshotScheduling(interval admin.Interval, startTime admin.StartTime) error {
+	ctx := context.TODO()
+
+	name, err := vg.GetName(ctx)
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"mirror", "snapshot", "schedule", "add",
+		"--pool", vg.pool,
+		"--group", name,
+	}
+	if vg.namespace != "" {
+		args = append(args, "--namespace", vg.namespace)
+	}
+	args = append(args, string(interval))
+	if startTime != admin.NoStartTime {
+		args = append(args, string(startTime))
+	}
+
+	_, err = vg.execMirrorCommand(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("failed to add snapshot schedule %s for volume group %q: %w", interval, vg, err)
+	}
+
+	return nil
+}
+
+// GetSnapshotSchedules returns the mirror snapshot schedules of the group.
+func (vg *volumeGroup) GetSnapshotSchedules(ctx context.Context) ([]admin.ScheduleTerm, error) {
+	name, err := vg.GetName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"mirror", "snapshot", "schedule", "ls",
+		"--pool", vg.pool,
+		"--group", name,
+		"--format", "json",
+	}
+	if vg.namespace != "" {
+		args = append(args, "--namespace", vg.namespace)
+	}
+
+	output, err := vg.execMirrorCommand(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot schedules of volume group %q: %w", vg, err)
+	}
+
+	terms := []admin.ScheduleTerm{}
+	if strings.TrimSpace(output) == "" {
+		return terms, nil
+	}
+
+	err = json.Unmarshal([]byte(output), &terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot schedules of volume group %q: %w", vg, err)
+	}
+
+	return terms, nil
+}
+
+// GetMirrorPeers returns the mirror peers that are configured for the pool of
+// the group.
+func (vg *volumeGroup) GetMirrorPeers(ctx context.Context) ([]types.MirrorPeer, error) {
+	conn, err := vg.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetMirrorPeers(conn, vg.pool)
+}
+
+// GetPoolMirroring returns the mirror mode of the RADOS namespace of the
+// group, the site name and the peers of the pool of the group.
+func (vg *volumeGroup) GetPoolMirroring(ctx context.Context) (*types.PoolMirroring, error) {
+	conn, err := vg.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetPoolMirroring(conn, vg.pool, vg.namespace)
+}
+
+// EnablePoolMirroring sets the mirror mode of the RADOS namespace of the
+// group to "image", groups can only be mirrored with that mode.
+func (vg *volumeGroup) EnablePoolMirroring(ctx context.Context) error {
+	conn, err := vg.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+
+	return EnablePoolMirroring(ctx, conn, vg.pool, vg.namespace)
+}
+
+// MarkDivergenceSnapshotPending records in the mirroring state of the group
+// that a divergence snapshot needs to be created, before the group is
+// force-promoted.
+func (vg *volumeGroup) MarkDivergenceSnapshotPending(ctx context.Context, _ *util.Credentials) error {
+	return vg.updateMirroringState(ctx, func(state *journal.MirroringState) {
+		state.DivergenceSnapshotPending = true
+	})
+}
+
+// IsDivergenceSnapshotPending returns true when the group was force-promoted,
+// but the divergence snapshot was not created yet.
+func (vg *volumeGroup) IsDivergenceSnapshotPending(_ context.Context, _ *util.Credentials) (bool, error) {
+	return vg.mirroringState != nil && vg.mirroringState.DivergenceSnapshotPending, nil
+}
+
+// CreateDivergenceSnapshot creates a group snapshot after the group was
+// force-promoted, so that the images keep the data they had when the sites
+// diverged. The group snapshot is the record, it is listed again by
+// GetDivergenceSnapshots. The pending
+// mark of MarkDivergenceSnapshotPending is cleared.
+func (vg *volumeGroup) CreateDivergenceSnapshot(
+	ctx context.Context,
+	_ *util.Credentials,
+) (*types.DivergenceSnapshot, error) {
+	now := time.Now()
+	name := groupDivergenceSnapshotPrefix + now.UTC().Format(groupDivergenceTimeFormat)
+
+	ops, err := vg.getGroupOperations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groupName, err := vg.GetName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ops.SnapCreate(groupName, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create divergence snapshot %q of volume group %q: %w", name, vg, err)
+	}
+
+	err = vg.updateMirroringState(ctx, func(state *journal.MirroringState) {
+		state.DivergenceSnapshotPending = false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.DebugLog(ctx, "created divergence snapshot %q of volume group %q", name, vg)
+
+	return &types.DivergenceSnapshot{
+		Name:    name,
+		Created: now.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// GetDivergenceSnapshots lists the group snapshots that were taken when the
+// group was force-promoted.
+func (vg *volumeGroup) GetDivergenceSnapshots(
+	ctx context.Context,
+	_ *util.Credentials,
+) ([]types.DivergenceSnapshot, error) {
+	ops, err := vg.getGroupOperations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groupName, err := vg.GetName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ops.SnapList(groupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of volume group %q: %w", vg, err)
+	}
+
+	snaps := make([]types.DivergenceSnapshot, 0, len(infos))
+	for _, info := range infos {
+		ts, found := strings.CutPrefix(info.Name, groupDivergenceSnapshotPrefix)
+		if !found {
+			continue
+		}
+
+		created, pErr := time.Parse(groupDivergenceTimeFormat, ts)
+		if pErr != nil {
+			log.WarningLog(ctx, "failed to parse the time of divergence snapshot %q of volume group %q: %v",
+				info.Name, vg, pErr)
+		}
+
+		snaps = append(snaps, types.DivergenceSnapshot{
+			Name:    info.Name,
+			Created: created,
+		})
+	}
+
+	return snaps, nil
+}
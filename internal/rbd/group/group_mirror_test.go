@@ -0,0 +1,85 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"testing"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorGroupSpec(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "replicapool/csi-vol-group-1", mirrorGroupSpec("replicapool", "", "csi-vol-group-1"))
+	require.Equal(t, "replicapool/ns/csi-vol-group-1", mirrorGroupSpec("replicapool", "ns", "csi-vol-group-1"))
+}
+
+func TestParseGroupMirrorInfo(t *testing.T) {
+	t.Parallel()
+
+	info, err := parseGroupMirrorInfo(`{"group_name":"csi-vol-group-1","group_id":"10ab",` +
+		`"mirroring":{"mode":"snapshot","state":"enabled","global_id":"53c9","primary":true}}`)
+	require.NoError(t, err)
+	require.Equal(t, librbd.MirrorImageEnabled.String(), info.GetState())
+	require.True(t, info.IsPrimary())
+
+	// the mirroring section is missing when mirroring is disabled
+	info, err = parseGroupMirrorInfo(`{"group_name":"csi-vol-group-1","group_id":"10ab"}`)
+	require.NoError(t, err)
+	require.Equal(t, librbd.MirrorImageDisabled.String(), info.GetState())
+	require.False(t, info.IsPrimary())
+
+	_, err = parseGroupMirrorInfo("rbd: unknown option")
+	require.Error(t, err)
+}
+
+func TestParseGroupMirrorStatus(t *testing.T) {
+	t.Parallel()
+
+	status, err := parseGroupMirrorStatus(`{"name":"csi-vol-group-1","global_id":"53c9",`+
+		`"state":"up+stopped","description":"local group is primary","last_update":"2024-10-01 12:00:00",`+
+		`"peer_sites":[{"site_name":"site-b","mirror_uuids":"7b2a","state":"up+replaying",`+
+		`"description":"replaying, {\"local_snapshot_timestamp\":1727784000}","last_update":"2024-10-01 12:00:30"}]}`,
+		groupMirrorInfo{State: "enabled", Primary: true})
+	require.NoError(t, err)
+	require.True(t, status.IsPrimary())
+
+	local, err := status.GetLocalSiteStatus()
+	require.NoError(t, err)
+	require.True(t, local.IsUP())
+	require.Equal(t, librbd.MirrorImageStatusStateStopped.String(), local.GetState())
+	require.Empty(t, local.GetMirrorUUID())
+
+	remote, err := status.GetRemoteSiteStatus(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, "7b2a", remote.GetMirrorUUID())
+	require.Equal(t, librbd.MirrorImageStatusStateReplaying.String(), remote.GetState())
+	require.False(t, remote.GetLastUpdate().IsZero())
+
+	require.Len(t, status.GetAllSitesStatus(), 2)
+	require.Len(t, status.GetRemoteSitesStatus(), 1)
+
+	status, err = parseGroupMirrorStatus(`{"name":"csi-vol-group-1"}`, groupMirrorInfo{})
+	require.NoError(t, err)
+	_, err = status.GetLocalSiteStatus()
+	require.Error(t, err)
+	_, err = status.GetRemoteSiteStatus(context.TODO())
+	require.ErrorIs(t, err, librbd.ErrNotExist)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
+
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+// groupOperations are the librbd calls that manage an RBD group and its
+// snapshots. The volumeGroup uses them through getGroupOperations, so that
+// its logic can be tested without a Ceph cluster.
+type groupOperations interface {
+	// Create creates the RBD group.
+	Create(name string) error
+	// Remove removes the RBD group.
+	Remove(name string) error
+	// ImageList returns the images of the RBD group.
+	ImageList(name string) ([]librbd.GroupImageInfo, error)
+	// ImageRemove removes the image in pool from the RBD group.
+	ImageRemove(name, pool, image string) error
+
+	// SnapCreate creates a snapshot of all images in the RBD group.
+	SnapCreate(name, snap string) error
+	// SnapRemove removes the snapshot of the RBD group.
+	SnapRemove(name, snap string) error
+	// SnapGetInfo returns the snapshot of the RBD group.
+	SnapGetInfo(name, snap string) (librbd.GroupSnapInfo, error)
+	// SnapList returns the snapshots of the RBD group.
+	SnapList(name string) ([]librbd.GroupSnapInfo, error)
+	// SnapRollback rolls back all images of the RBD group to the snapshot.
+	SnapRollback(name, snap string) error
+}
+
+// librbdGroupOperations implements groupOperations with librbd, for the RBD
+// groups in the IOContext.
+type librbdGroupOperations struct {
+	conn      *util.ClusterConnection
+	ioctx     *rados.IOContext
+	namespace string
+}
+
+var _ groupOperations = &librbdGroupOperations{}
+
+func (ops *librbdGroupOperations) Create(name string) error {
+	return librbd.GroupCreate(ops.ioctx, name)
+}
+
+func (ops *librbdGroupOperations) Remove(name string) error {
+	return librbd.GroupRemove(ops.ioctx, name)
+}
+
+func (ops *librbdGroupOperations) ImageList(name string) ([]librbd.GroupImageInfo, error) {
+	return librbd.GroupImageList(ops.ioctx, name)
+}
+
+// ImageRemove opens the pool of the image in the RADOS namespace of the
+// group, the images of a group can be in different pools.
+func (ops *librbdGroupOperations) ImageRemove(name, pool, image string) error {
+	imageIoctx, err := ops.conn.GetIoctx(pool)
+	if err != nil {
+		return fmt.Errorf("failed to get IOContext for pool %q: %w", pool, err)
+	}
+	defer imageIoctx.Destroy()
+
+	if ops.namespace != "" {
+		imageIoctx.SetNamespace(ops.namespace)
+	}
+
+	return librbd.GroupImageRemove(ops.ioctx, name, imageIoctx, image)
+}
+
+func (ops *librbdGroupOperations) SnapCreate(name, snap string) error {
+	return librbd.GroupSnapCreate(ops.ioctx, name, snap)
+}
+
+func (ops *librbdGroupOperations) SnapRemove(name, snap string) error {
+	return librbd.GroupSnapRemove(ops.ioctx, name, snap)
+}
+
+func (ops *librbdGroupOperations) SnapGetInfo(name, snap string) (librbd.GroupSnapInfo, error) {
+	return librbd.GroupSnapGetInfo(ops.ioctx, name, snap)
+}
+
+func (ops *librbdGroupOperations) SnapList(name string) ([]librbd.GroupSnapInfo, error) {
+	return librbd.GroupSnapList(ops.ioctx, name)
+}
+
+func (ops *librbdGroupOperations) SnapRollback(name, snap string) error {
+	return librbd.GroupSnapRollback(ops.ioctx, name, snap)
+}
+
+// getGroupOperations returns the groupOperations for the volume group, the
+// librbd implementation uses the connection and IOContext of the group.
+func (cvg *commonVolumeGroup) getGroupOperations(ctx context.Context) (groupOperations, error) {
+	if cvg.ops != nil {
+		return cvg.ops, nil
+	}
+
+	ioctx, err := cvg.GetIOContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cvg.ops = &librbdGroupOperations{
+		conn:      cvg.conn,
+		ioctx:     ioctx,
+		namespace: cvg.namespace,
+	}
+
+	return cvg.ops, nil
+}
@@ -0,0 +1,664 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+const (
+	testClusterID  = "testcluster"
+	testPool       = "replicapool"
+	testPoolID     = 1
+	testGroupName  = "csi-vol-group-6b36c5b5-0b24-4f3e-9840-7c1b7f65c3b7"
+	testObjectUUID = "6b36c5b5-0b24-4f3e-9840-7c1b7f65c3b7"
+)
+
+// fakeGroupOperations keeps the RBD groups in memory, the images of a group
+// are added by the AddToGroup of the fakeVolume.
+type fakeGroupOperations struct {
+	groups map[string][]librbd.GroupImageInfo
+	snaps  map[string][]librbd.GroupSnapInfo
+	// rolledBack contains the group snapshots that were rolled back to
+	rolledBack []string
+	// snapCreates counts the group snapshots that were created
+	snapCreates int
+}
+
+var _ groupOperations = &fakeGroupOperations{}
+
+func newFakeGroupOperations() *fakeGroupOperations {
+	return &fakeGroupOperations{
+		groups: map[string][]librbd.GroupImageInfo{},
+		snaps:  map[string][]librbd.GroupSnapInfo{},
+	}
+}
+
+func (f *fakeGroupOperations) Create(name string) error {
+	if _, ok := f.groups[name]; ok {
+		return librbd.ErrExist
+	}
+	f.groups[name] = []librbd.GroupImageInfo{}
+
+	return nil
+}
+
+func (f *fakeGroupOperations) Remove(name string) error {
+	if _, ok := f.groups[name]; !ok {
+		return rados.ErrNotFound
+	}
+	delete(f.groups, name)
+	delete(f.snaps, name)
+
+	return nil
+}
+
+func (f *fakeGroupOperations) ImageList(name string) ([]librbd.GroupImageInfo, error) {
+	images, ok := f.groups[name]
+	if !ok {
+		return nil, librbd.ErrNotFound
+	}
+
+	return images, nil
+}
+
+func (f *fakeGroupOperations) imageAdd(name, image string) error {
+	if _, ok := f.groups[name]; !ok {
+		return librbd.ErrNotFound
+	}
+	f.groups[name] = append(f.groups[name], librbd.GroupImageInfo{
+		Name:   image,
+		PoolID: testPoolID,
+		State:  librbd.GroupImageStateAttached,
+	})
+
+	return nil
+}
+
+func (f *fakeGroupOperations) ImageRemove(name, _, image string) error {
+	images, ok := f.groups[name]
+	if !ok {
+		return librbd.ErrNotFound
+	}
+
+	i := slices.IndexFunc(images, func(info librbd.GroupImageInfo) bool {
+		return info.Name == image
+	})
+	if i == -1 {
+		return librbd.ErrNotExist
+	}
+	f.groups[name] = slices.Delete(images, i, i+1)
+
+	return nil
+}
+
+func (f *fakeGroupOperations) SnapCreate(name, snap string) error {
+	images, ok := f.groups[name]
+	if !ok {
+		return librbd.ErrNotFound
+	}
+
+	f.snapCreates++
+	info := librbd.GroupSnapInfo{Name: snap, State: librbd.GroupSnapStateComplete}
+	for i, image := range images {
+		info.Snapshots = append(info.Snapshots, librbd.GroupSnap{
+			Name:   image.Name,
+			PoolID: uint64(image.PoolID),
+			SnapID: uint64(i + 1),
+		})
+	}
+	f.snaps[name] = append(f.snaps[name], info)
+
+	return nil
+}
+
+func (f *fakeGroupOperations) SnapRemove(name, snap string) error {
+	f.snaps[name] = slices.DeleteFunc(f.snaps[name], func(info librbd.GroupSnapInfo) bool {
+		return info.Name == snap
+	})
+
+	return nil
+}
+
+func (f *fakeGroupOperations) SnapGetInfo(name, snap string) (librbd.GroupSnapInfo, error) {
+	for _, info := range f.snaps[name] {
+		if info.Name == snap {
+			return info, nil
+		}
+	}
+
+	return librbd.GroupSnapInfo{}, librbd.ErrNotFound
+}
+
+func (f *fakeGroupOperations) SnapList(name string) ([]librbd.GroupSnapInfo, error) {
+	return f.snaps[name], nil
+}
+
+func (f *fakeGroupOperations) SnapRollback(name, snap string) error {
+	_, err := f.SnapGetInfo(name, snap)
+	if err != nil {
+		return err
+	}
+	f.rolledBack = append(f.rolledBack, snap)
+
+	return nil
+}
+
+// fakeGroupJournal keeps the volume mapping of a single group in memory.
+type fakeGroupJournal struct {
+	journal.VolumeGroupJournal
+
+	generation uint64
+	volumes    map[string]string
+	// undone is set when the reservation of the group was removed
+	undone bool
+	marker *journal.SnapshotMarker
+}
+
+func (j *fakeGroupJournal) Destroy() {}
+
+func (j *fakeGroupJournal) UndoReservation(_ context.Context, _, _, _ string) error {
+	j.undone = true
+
+	return nil
+}
+
+func (j *fakeGroupJournal) UpdateVolumesMapping(
+	_ context.Context,
+	_, _ string,
+	generation uint64,
+	volumeMap map[string]string,
+	volumeIDs []string,
+) (uint64, error) {
+	if generation != j.generation {
+		return 0, journal.ErrObjectModified
+	}
+
+	maps.Copy(j.volumes, volumeMap)
+	for _, id := range volumeIDs {
+		delete(j.volumes, id)
+	}
+	j.generation++
+
+	return j.generation, nil
+}
+
+func (j *fakeGroupJournal) SetSnapshotMarker(
+	_ context.Context,
+	_, _ string,
+	marker *journal.SnapshotMarker,
+) error {
+	j.marker = marker
+
+	return nil
+}
+
+func (j *fakeGroupJournal) RemoveSnapshotMarker(_ context.Context, _, _ string) error {
+	j.marker = nil
+
+	return nil
+}
+
+func (j *fakeGroupJournal) GetVolumeGroupAttributes(
+	_ context.Context,
+	_, _ string,
+) (*journal.VolumeGroupAttributes, error) {
+	return &journal.VolumeGroupAttributes{
+		RequestName: "my-group",
+		GroupName:   testGroupName,
+		VolumeMap:   maps.Clone(j.volumes),
+		Generation:  j.generation,
+	}, nil
+}
+
+// fakeVolume is a volume in the pool of the group, only the functions that
+// are used by the volumeGroup are implemented.
+type fakeVolume struct {
+	types.Volume
+
+	id        string
+	name      string
+	pool      string
+	clusterID string
+	inUse     bool
+	ops       *fakeGroupOperations
+	// snapshots contains the names of the snapshots of the volume
+	snapshots []string
+	// snapshotErr is returned by NewSnapshotByID when set
+	snapshotErr error
+	// namePrefixes contains the name prefixes of the created snapshots
+	namePrefixes []string
+}
+
+func newFakeVolume(ops *fakeGroupOperations, name string) *fakeVolume {
+	return &fakeVolume{
+		id:        "vol-" + name,
+		name:      name,
+		pool:      testPool,
+		clusterID: testClusterID,
+		ops:       ops,
+	}
+}
+
+func (v *fakeVolume) String() string {
+	return v.pool + "/" + v.name
+}
+
+func (v *fakeVolume) Destroy(context.Context) {}
+
+func (v *fakeVolume) GetID(context.Context) (string, error) {
+	return v.id, nil
+}
+
+func (v *fakeVolume) GetName(context.Context) (string, error) {
+	return v.name, nil
+}
+
+func (v *fakeVolume) GetPool(context.Context) (string, error) {
+	return v.pool, nil
+}
+
+func (v *fakeVolume) GetClusterID(context.Context) (string, error) {
+	return v.clusterID, nil
+}
+
+func (v *fakeVolume) IsInUse(context.Context) (bool, error) {
+	return v.inUse, nil
+}
+
+func (v *fakeVolume) NewSnapshotByID(
+	_ context.Context,
+	_ *util.Credentials,
+	name, namePrefix string,
+	_ uint64,
+) (types.Snapshot, error) {
+	if v.snapshotErr != nil {
+		return nil, v.snapshotErr
+	}
+	v.snapshots = append(v.snapshots, name)
+	v.namePrefixes = append(v.namePrefixes, namePrefix)
+
+	return &fakeSnapshot{volume: v, name: name}, nil
+}
+
+func (v *fakeVolume) AddToGroup(ctx context.Context, vg types.VolumeGroup) error {
+	name, err := vg.GetName(ctx)
+	if err != nil {
+		return err
+	}
+
+	return v.ops.imageAdd(name, v.name)
+}
+
+func (v *fakeVolume) RemoveFromGroup(ctx context.Context, vg types.VolumeGroup) error {
+	name, err := vg.GetName(ctx)
+	if err != nil {
+		return err
+	}
+
+	return v.ops.ImageRemove(name, v.pool, v.name)
+}
+
+// fakeSnapshot is a snapshot of a fakeVolume.
+type fakeSnapshot struct {
+	types.Snapshot
+
+	volume *fakeVolume
+	name   string
+}
+
+func (s *fakeSnapshot) String() string {
+	return s.volume.String() + "@" + s.name
+}
+
+func (s *fakeSnapshot) Destroy(context.Context) {}
+
+func (s *fakeSnapshot) Delete(context.Context) error {
+	s.volume.snapshots = slices.DeleteFunc(s.volume.snapshots, func(name string) bool {
+		return name == s.name
+	})
+
+	return nil
+}
+
+// newTestVolumeGroup returns a volumeGroup that uses the fakes instead of a
+// Ceph cluster.
+func newTestVolumeGroup(t *testing.T, ops *fakeGroupOperations, j *fakeGroupJournal) *volumeGroup {
+	t.Helper()
+
+	csiID := util.CSIIdentifier{
+		LocationID: testPoolID,
+		ClusterID:  testClusterID,
+		ObjectUUID: testObjectUUID,
+	}
+	id, err := csiID.ComposeCSIID()
+	require.NoError(t, err)
+
+	return &volumeGroup{
+		commonVolumeGroup: commonVolumeGroup{
+			id:          id,
+			requestName: "my-group",
+			name:        testGroupName,
+			generation:  j.generation,
+			clusterID:   testClusterID,
+			objectUUID:  testObjectUUID,
+			pool:        testPool,
+			journal:     j,
+			ops:         ops,
+		},
+	}
+}
+
+func TestVolumeGroupCreateDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+
+	require.NoError(t, vg.Create(ctx))
+	require.Contains(t, ops.groups, testGroupName)
+
+	// creating an existing group succeeds
+	require.NoError(t, vg.Create(ctx))
+
+	require.NoError(t, vg.Delete(ctx))
+	require.NotContains(t, ops.groups, testGroupName)
+	require.True(t, j.undone)
+
+	// deleting a removed group only removes the reservation
+	j.undone = false
+	require.NoError(t, vg.Delete(ctx))
+	require.True(t, j.undone)
+}
+
+func TestVolumeGroupAddRemoveVolume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	vol1 := newFakeVolume(ops, "csi-vol-1")
+	vol2 := newFakeVolume(ops, "csi-vol-2")
+	require.NoError(t, vg.AddVolume(ctx, vol1))
+	require.NoError(t, vg.AddVolume(ctx, vol2))
+	require.Equal(t, map[string]string{"vol-csi-vol-1": "", "vol-csi-vol-2": ""}, j.volumes)
+	require.Len(t, ops.groups[testGroupName], 2)
+
+	volumes, err := vg.ListVolumes(ctx)
+	require.NoError(t, err)
+	require.Len(t, volumes, 2)
+
+	require.NoError(t, vg.RemoveVolume(ctx, vol1))
+	require.Equal(t, map[string]string{"vol-csi-vol-2": ""}, j.volumes)
+	require.Len(t, ops.groups[testGroupName], 1)
+
+	// removing a volume that was removed from the RBD group by an earlier
+	// attempt updates the journal anyway
+	require.NoError(t, ops.ImageRemove(testGroupName, testPool, "csi-vol-2"))
+	require.NoError(t, vg.RemoveVolume(ctx, vol2))
+	require.Empty(t, j.volumes)
+
+	volumes, err = vg.ListVolumes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, volumes)
+}
+
+func TestVolumeGroupAddVolumeIncompatible(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	vol := newFakeVolume(ops, "csi-vol-1")
+	vol.clusterID = "othercluster"
+	require.ErrorIs(t, vg.AddVolume(ctx, vol), ErrIncompatibleVolume)
+	require.Empty(t, ops.groups[testGroupName])
+	require.Empty(t, j.volumes)
+}
+
+func TestVolumeGroupAddVolumeModified(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	// another process updated the journal after the group was read
+	j.generation++
+	require.ErrorIs(t, vg.AddVolume(ctx, newFakeVolume(ops, "csi-vol-1")), journal.ErrObjectModified)
+}
+
+func TestVolumeGroupRepairMembers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+
+	// the RBD group does not exist yet
+	require.NoError(t, vg.RepairMembers(ctx))
+
+	// an empty RBD group matches an empty journal
+	require.NoError(t, vg.Create(ctx))
+	require.NoError(t, vg.RepairMembers(ctx))
+	require.Empty(t, ops.groups[testGroupName])
+}
+
+func TestVolumeGroupRollbackToSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	vol1 := newFakeVolume(ops, "csi-vol-1")
+	require.NoError(t, vg.AddVolume(ctx, vol1))
+	require.NoError(t, ops.SnapCreate(testGroupName, "snap-1"))
+
+	require.NoError(t, vg.RollbackToSnapshot(ctx, "snap-1"))
+	require.Equal(t, []string{"snap-1"}, ops.rolledBack)
+
+	// a volume that was added after the snapshot can not be rolled back
+	vol2 := newFakeVolume(ops, "csi-vol-2")
+	require.NoError(t, vg.AddVolume(ctx, vol2))
+	require.Error(t, vg.RollbackToSnapshot(ctx, "snap-1"))
+
+	// volumes in use are not rolled back
+	require.NoError(t, ops.SnapCreate(testGroupName, "snap-2"))
+	vol1.inUse = true
+	require.Error(t, vg.RollbackToSnapshot(ctx, "snap-2"))
+	require.Equal(t, []string{"snap-1"}, ops.rolledBack)
+
+	require.Error(t, vg.RollbackToSnapshot(ctx, "missing"))
+}
+
+func TestVolumeGroupCreateSnapshotsResume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	vol1 := newFakeVolume(ops, "csi-vol-1")
+	vol2 := newFakeVolume(ops, "csi-vol-2")
+	require.NoError(t, vg.AddVolume(ctx, vol1))
+	require.NoError(t, vg.AddVolume(ctx, vol2))
+
+	// the deadline of the caller passed, the group snapshot is kept
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := vg.CreateSnapshots(cctx, nil, "group-snap-1", nil)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, ops.snaps[testGroupName], 1)
+	require.NotNil(t, j.marker)
+	require.Equal(t, "group-snap-1", j.marker.Name)
+
+	// the next call resumes from the existing group snapshot
+	snapshots, err := vg.CreateSnapshots(ctx, nil, "group-snap-2", nil)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.Equal(t, 1, ops.snapCreates)
+	require.Empty(t, ops.snaps[testGroupName])
+	require.Nil(t, j.marker)
+	require.Len(t, vol1.snapshots, 1)
+	require.Len(t, vol2.snapshots, 1)
+
+	// an incomplete group snapshot is created again
+	require.NoError(t, ops.SnapCreate(testGroupName, "group-snap-3"))
+	ops.snaps[testGroupName][0].State = librbd.GroupSnapStateIncomplete
+	require.NoError(t, vg.setSnapshotMarker(ctx, &journal.SnapshotMarker{Name: "group-snap-3"}))
+	snapshots, err = vg.CreateSnapshots(ctx, nil, "group-snap-4", nil)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.Equal(t, 3, ops.snapCreates)
+	require.Empty(t, ops.snaps[testGroupName])
+	require.Nil(t, j.marker)
+}
+
+func TestVolumeGroupResumableSnapshots(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+	require.NoError(t, vg.AddVolume(ctx, newFakeVolume(ops, "csi-vol-1")))
+
+	resume, err := vg.ResumableSnapshots(ctx, time.Now())
+	require.NoError(t, err)
+	require.False(t, resume)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = vg.CreateSnapshots(cctx, nil, "group-snap-1", nil)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// a recent group snapshot is resumed
+	resume, err = vg.ResumableSnapshots(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, resume)
+	require.Len(t, ops.snaps[testGroupName], 1)
+	require.NotNil(t, j.marker)
+
+	// a stale group snapshot is removed with its marker
+	resume, err = vg.ResumableSnapshots(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, resume)
+	require.Empty(t, ops.snaps[testGroupName])
+	require.Nil(t, j.marker)
+}
+
+func TestVolumeGroupCreateSnapshotsParallel(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	volumes := make([]*fakeVolume, 2*snapshotCreateConcurrency+1)
+	for i := range volumes {
+		volumes[i] = newFakeVolume(ops, fmt.Sprintf("csi-vol-%d", i))
+		require.NoError(t, vg.AddVolume(ctx, volumes[i]))
+	}
+
+	snapshots, err := vg.CreateSnapshots(ctx, nil, "group-snap-1", nil)
+	require.NoError(t, err)
+	require.Len(t, snapshots, len(volumes))
+	for i, vol := range volumes {
+		// the snapshot is stored at the index of the RBD-snapshot
+		snap, ok := snapshots[i].(*fakeSnapshot)
+		require.True(t, ok)
+		require.Equal(t, vol.String()+"@"+vol.snapshots[0], snap.String())
+	}
+
+	// the failure of one image removes the snapshots of the other images
+	for _, vol := range volumes {
+		vol.snapshots = nil
+	}
+	errBroken := errors.New("broken image")
+	volumes[3].snapshotErr = errBroken
+	_, err = vg.CreateSnapshots(ctx, nil, "group-snap-2", nil)
+	require.ErrorIs(t, err, errBroken)
+	for _, vol := range volumes {
+		require.Empty(t, vol.snapshots, vol.name)
+	}
+	require.Empty(t, ops.snaps[testGroupName])
+}
+
+func TestVolumeGroupCreateSnapshotsNamePrefix(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	ops := newFakeGroupOperations()
+	j := &fakeGroupJournal{volumes: map[string]string{}}
+	vg := newTestVolumeGroup(t, ops, j)
+	require.NoError(t, vg.Create(ctx))
+
+	vol1 := newFakeVolume(ops, "csi-vol-1")
+	vol2 := newFakeVolume(ops, "csi-vol-2")
+	require.NoError(t, vg.AddVolume(ctx, vol1))
+	require.NoError(t, vg.AddVolume(ctx, vol2))
+
+	namePrefix := func(image string, index int) (string, error) {
+		return fmt.Sprintf("%s-%d-", image, index), nil
+	}
+	_, err := vg.CreateSnapshots(ctx, nil, "group-snap-1", namePrefix)
+	require.NoError(t, err)
+	require.Equal(t, []string{"csi-vol-1-0-"}, vol1.namePrefixes)
+	require.Equal(t, []string{"csi-vol-2-1-"}, vol2.namePrefixes)
+
+	// no snapshots are created when a prefix can not be rendered
+	errTemplate := errors.New("invalid template")
+	_, err = vg.CreateSnapshots(ctx, nil, "group-snap-2", func(string, int) (string, error) {
+		return "", errTemplate
+	})
+	require.ErrorIs(t, err, errTemplate)
+	require.Len(t, vol1.namePrefixes, 1)
+	require.Empty(t, ops.snaps[testGroupName])
+}
@@ -20,6 +20,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -29,6 +32,10 @@ import (
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
+// snapshotDeleteConcurrency is the number of snapshots of a volume group
+// snapshot that are deleted at the same time.
+const snapshotDeleteConcurrency = 8
+
 // volumeGroupSnapshot handles all requests for 'rbd group snap' operations.
 type volumeGroupSnapshot struct {
 	commonVolumeGroup
@@ -91,8 +98,11 @@ func GetVolumeGroupSnapshot(
 			s.Destroy(ctx)
 		}
 	}()
+	// the journal of all snapshots is read in batches, instead of one
+	// snapshot after the other
+	resolveCtx := snapshotResolver.PrefetchSnapshots(ctx, slices.Collect(maps.Keys(attrs.VolumeMap)))
 	for snapID := range attrs.VolumeMap {
-		snap, err := snapshotResolver.GetSnapshotByID(ctx, snapID)
+		snap, err := snapshotResolver.GetSnapshotByID(resolveCtx, snapID)
 		if err != nil {
 			// free the previously allocated snapshots
 			for _, s := range snapshots {
@@ -232,18 +242,73 @@ func (vgs *volumeGroupSnapshot) Destroy(ctx context.Context) {
 	vgs.commonVolumeGroup.Destroy(ctx)
 }
 
-// Delete removes all snapshots and eventually the volume group snapshot.
+// Delete removes the snapshots of the volume group snapshot, and the volume
+// group snapshot itself once all snapshots are deleted. The error of every
+// snapshot that could not be deleted is returned.
 func (vgs *volumeGroupSnapshot) Delete(ctx context.Context) error {
+	errs := vgs.deleteSnapshots(ctx)
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to delete %d of %d snapshots of volume group snapshot %q: %w",
+			len(errs), len(vgs.snapshots), vgs, errors.Join(errs...))
+	}
+
+	return vgs.commonVolumeGroup.Delete(ctx)
+}
+
+// deleteSnapshots deletes the snapshots of the volume group snapshot, with at
+// most snapshotDeleteConcurrency deletions in flight. The deleted snapshots
+// are removed from the journal of the volume group snapshot, so that a retry
+// only deletes the snapshots that failed.
+func (vgs *volumeGroupSnapshot) deleteSnapshots(ctx context.Context) []error {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errs    []error
+		deleted []string
+		sem     = make(chan struct{}, snapshotDeleteConcurrency)
+	)
 	for _, snapshot := range vgs.snapshots {
-		log.DebugLog(ctx, "deleting snapshot image %q for volume group snapshot %q", snapshot, vgs)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(snapshot types.Snapshot) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			log.DebugLog(ctx, "deleting snapshot image %q for volume group snapshot %q", snapshot, vgs)
+			id, err := snapshot.GetID(ctx)
+			if err == nil {
+				err = snapshot.Delete(ctx)
+			}
 
-		err := snapshot.Delete(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to delete snapshot %q as part of volume groups snapshot %q: %w", snapshot, vgs, err)
-		}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.ErrorLog(ctx, "failed to delete snapshot %q of volume group snapshot %q: %v", snapshot, vgs, err)
+				errs = append(errs, fmt.Errorf("snapshot %q: %w", snapshot, err))
+
+				return
+			}
+			deleted = append(deleted, id)
+		}(snapshot)
 	}
+	wg.Wait()
 
-	return vgs.commonVolumeGroup.Delete(ctx)
+	if len(deleted) == 0 || len(errs) == 0 {
+		// the whole journal is removed when all snapshots are deleted
+		return errs
+	}
+
+	j, err := vgs.getJournal(ctx)
+	if err == nil {
+		err = j.RemoveVolumesMapping(ctx, vgs.pool, vgs.objectUUID, deleted)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove the deleted snapshots from the journal: %w", err))
+	}
+
+	return errs
 }
 
 func (vgs *volumeGroupSnapshot) ListSnapshots(ctx context.Context) ([]types.Snapshot, error) {
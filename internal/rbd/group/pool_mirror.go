@@ -0,0 +1,133 @@
+/*
+Copyright 2026 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"fmt"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// poolSpec returns the pool[/namespace] spec that the rbd command uses.
+func poolSpec(pool, namespace string) string {
+	if namespace != "" {
+		return pool + "/" + namespace
+	}
+
+	return pool
+}
+
+// mirrorPeerDirectionString returns the direction like the rbd command
+// reports it.
+func mirrorPeerDirectionString(direction librbd.MirrorPeerDirection) string {
+	switch direction {
+	case librbd.MirrorPeerDirectionRx:
+		return "rx"
+	case librbd.MirrorPeerDirectionTx:
+		return "tx"
+	case librbd.MirrorPeerDirectionRxTx:
+		return "rx-tx"
+	}
+
+	return "unknown"
+}
+
+// GetMirrorPeers returns the mirror peers that are configured for the pool.
+func GetMirrorPeers(conn *util.ClusterConnection, pool string) ([]types.MirrorPeer, error) {
+	// peers are configured on the pool, not on a RADOS namespace
+	ioctx, err := conn.GetIoctx(pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pool %q: %w", pool, err)
+	}
+	defer ioctx.Destroy()
+
+	sites, err := librbd.ListMirrorPeerSite(ioctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mirror peers of pool %q: %w", pool, err)
+	}
+
+	peers := make([]types.MirrorPeer, 0, len(sites))
+	for _, site := range sites {
+		peers = append(peers, types.MirrorPeer{
+			UUID:       site.UUID,
+			SiteName:   site.SiteName,
+			MirrorUUID: site.MirrorUUID,
+			Direction:  mirrorPeerDirectionString(site.Direction),
+		})
+	}
+
+	return peers, nil
+}
+
+// GetPoolMirroring returns the mirror mode of the RADOS namespace in the
+// pool, the site name and the peers of the pool. The mirror mode of a RADOS
+// namespace is independent of the mode of the pool itself.
+func GetPoolMirroring(conn *util.ClusterConnection, pool, namespace string) (*types.PoolMirroring, error) {
+	ioctx, err := conn.GetIoctx(pool)
+	if err != nil {
+		return nil, err
+	}
+	defer ioctx.Destroy()
+	ioctx.SetNamespace(namespace)
+
+	mode, err := librbd.GetMirrorMode(ioctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mirror mode of %q: %w", poolSpec(pool, namespace), err)
+	}
+
+	siteName, err := conn.GetMirrorSiteName()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mirror site name: %w", err)
+	}
+
+	peers, err := GetMirrorPeers(conn, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.PoolMirroring{
+		Pool:      pool,
+		Namespace: namespace,
+		Mode:      mode,
+		SiteName:  siteName,
+		Peers:     peers,
+	}, nil
+}
+
+// EnablePoolMirroring sets the mirror mode of the RADOS namespace in the pool
+// to "image", so that mirroring can be enabled per image and group.
+func EnablePoolMirroring(ctx context.Context, conn *util.ClusterConnection, pool, namespace string) error {
+	ioctx, err := conn.GetIoctx(pool)
+	if err != nil {
+		return err
+	}
+	defer ioctx.Destroy()
+	ioctx.SetNamespace(namespace)
+
+	err = librbd.SetMirrorMode(ioctx, librbd.MirrorModeImage)
+	if err != nil {
+		return fmt.Errorf("failed to enable mirroring of %q: %w", poolSpec(pool, namespace), err)
+	}
+	log.DebugLog(ctx, "enabled mirroring of images in %q", poolSpec(pool, namespace))
+
+	return nil
+}
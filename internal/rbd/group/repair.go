@@ -0,0 +1,187 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+
+	"github.com/ceph/ceph-csi/internal/rbd/types"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// RepairMembers compares the images of the RBD group with the volumes in the
+// journal of the volume group, and repairs the RBD group when they differ.
+// AddVolume and RemoveVolume update the RBD group before the journal, an
+// interrupted call leaves an image in the RBD group that is not in the
+// journal, or the other way around.
+//
+// The journal is what the VolumeGroup reports to the CO, so the RBD group is
+// made to match it: volumes that are missing in the RBD group are added to
+// it, images that are not in the journal are removed from the RBD group.
+func (vg *volumeGroup) RepairMembers(ctx context.Context) error {
+	group, err := vg.GetName(ctx)
+	if err != nil {
+		return err
+	}
+
+	ops, err := vg.getGroupOperations(ctx)
+	if err != nil {
+		return err
+	}
+
+	images, err := ops.ImageList(group)
+	if errors.Is(err, librbd.ErrNotFound) {
+		// the RBD group is created after the reservation in the journal
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list images of volume group %q: %w", vg, err)
+	}
+
+	// poolNames caches the names of the pools of the images in the group
+	poolNames := map[int64]string{}
+	// members contains the attached images of the RBD group, by image-spec
+	members := map[string]librbd.GroupImageInfo{}
+	// incomplete contains the images of an interrupted add or remove
+	incomplete := map[string]librbd.GroupImageInfo{}
+	for _, image := range images {
+		pool, ok := poolNames[image.PoolID]
+		if !ok {
+			pool, err = util.GetPoolName(vg.monitors, vg.credentials, image.PoolID)
+			if err != nil {
+				return fmt.Errorf("failed to get name of pool %d of image %q: %w", image.PoolID, image.Name, err)
+			}
+			poolNames[image.PoolID] = pool
+		}
+
+		if image.State != librbd.GroupImageStateAttached {
+			incomplete[pool+"/"+image.Name] = image
+
+			continue
+		}
+
+		members[pool+"/"+image.Name] = image
+	}
+
+	var missing []types.Volume
+	for _, volume := range vg.volumes {
+		name, gErr := volume.GetName(ctx)
+		if gErr != nil {
+			return fmt.Errorf("failed to get name for volume %q: %w", volume, gErr)
+		}
+
+		pool, gErr := volume.GetPool(ctx)
+		if gErr != nil {
+			return fmt.Errorf("failed to get pool for volume %q: %w", volume, gErr)
+		}
+
+		spec := pool + "/" + name
+		if _, ok := members[spec]; ok {
+			delete(members, spec)
+
+			continue
+		}
+
+		missing = append(missing, volume)
+	}
+
+	if len(missing) == 0 && len(members) == 0 && len(incomplete) == 0 {
+		return nil
+	}
+
+	// AddVolume of another process may have added an image to the RBD group
+	// and not updated the journal yet, the journal is read again to skip
+	// the repair when it was modified in the meantime.
+	modified, err := vg.journalModified(ctx)
+	if err != nil {
+		return err
+	}
+	if modified {
+		log.DebugLog(ctx, "journal of volume group %q was modified, not repairing the members", vg)
+
+		return nil
+	}
+
+	// incomplete images are removed, and added again when the journal
+	// contains them
+	for spec, image := range incomplete {
+		log.WarningLog(ctx, "image %s is not completely attached to volume group %q, removing it", spec, vg)
+
+		err = vg.removeImage(ctx, poolNames[image.PoolID], image.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, volume := range missing {
+		log.WarningLog(ctx, "volume %q is in the journal of volume group %q, but not in the RBD group, adding it",
+			volume, vg)
+
+		err = volume.AddToGroup(ctx, vg)
+		if err != nil {
+			return fmt.Errorf("failed to add volume %q to volume group %q: %w", volume, vg, err)
+		}
+	}
+
+	for spec, image := range members {
+		log.WarningLog(ctx, "image %s is in the RBD group %q, but not in its journal, removing it", spec, vg)
+
+		err = vg.removeImage(ctx, poolNames[image.PoolID], image.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// journalModified returns true when the volume mapping in the journal was
+// updated after GetVolumeGroup read it.
+func (vg *volumeGroup) journalModified(ctx context.Context) (bool, error) {
+	j, err := vg.getJournal(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	attrs, err := j.GetVolumeGroupAttributes(ctx, vg.pool, vg.objectUUID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get attributes for volume group %q: %w", vg, err)
+	}
+
+	return attrs.Generation != vg.generation, nil
+}
+
+// removeImage removes the image in pool from the RBD group. The image is
+// expected in the RADOS namespace of the group.
+func (vg *volumeGroup) removeImage(ctx context.Context, pool, image string) error {
+	ops, err := vg.getGroupOperations(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ops.ImageRemove(vg.name, pool, image)
+	if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return fmt.Errorf("failed to remove image %s/%s from volume group %q: %w", pool, image, vg, err)
+	}
+
+	return nil
+}
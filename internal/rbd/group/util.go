@@ -43,6 +43,10 @@ type commonVolumeGroup struct {
 	// creationTime is the time the group was created
 	creationTime *time.Time
 
+	// generation of the journal when the attributes were read, updates of
+	// the volume mapping fail when another process modified the group
+	generation uint64
+
 	clusterID  string
 	objectUUID string
 
@@ -51,6 +55,8 @@ type commonVolumeGroup struct {
 	// temporary connection attributes
 	conn  *util.ClusterConnection
 	ioctx *rados.IOContext
+	// use getGroupOperations() to call librbd for the group
+	ops groupOperations
 
 	// required details to perform operations on the group
 	monitors  string
@@ -105,6 +111,8 @@ func (cvg *commonVolumeGroup) initCommonVolumeGroup(
 }
 
 func (cvg *commonVolumeGroup) Destroy(ctx context.Context) {
+	cvg.ops = nil
+
 	if cvg.ioctx != nil {
 		cvg.ioctx.Destroy()
 		cvg.ioctx = nil
@@ -154,6 +162,7 @@ func (cvg *commonVolumeGroup) getVolumeGroupAttributes(ctx context.Context) (*jo
 	cvg.requestName = attrs.RequestName
 	cvg.name = attrs.GroupName
 	cvg.creationTime = attrs.CreationTime
+	cvg.generation = attrs.Generation
 
 	return attrs, nil
 }
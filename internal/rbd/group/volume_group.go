@@ -20,6 +20,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/ceph/go-ceph/rados"
 	librados "github.com/ceph/go-ceph/rados"
@@ -27,14 +31,22 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/csi-addons/spec/lib/go/volumegroup"
 
+	"github.com/ceph/ceph-csi/internal/journal"
 	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
+// snapshotCreateConcurrency is the number of RBD-images that are created at
+// the same time from the RBD-snapshots of a volume group snapshot.
+const snapshotCreateConcurrency = 8
+
 var (
 	ErrRBDGroupNotConnected = fmt.Errorf("%w: RBD group is not connected", librados.ErrNotConnected)
 	ErrRBDGroupNotFound     = fmt.Errorf("%w: RBD group not found", librbd.ErrNotFound)
+	// ErrIncompatibleVolume is returned when a volume can not be added to
+	// the RBD group.
+	ErrIncompatibleVolume = errors.New("volume can not be added to the volume group")
 )
 
 // volumeGroup handles all requests for 'rbd group' operations.
@@ -50,6 +62,17 @@ type volumeGroup struct {
 	// this by calling AddVolume (Volumes are allocated elsewhere), and
 	// RemoveVolume (need to keep track of the allocated Volume).
 	volumesToFree []types.Volume
+
+	// failoverMarker is set when a promote or demote of the volumes in the
+	// group was interrupted.
+	failoverMarker *journal.FailoverMarker
+
+	// mirroringState is set when mirroring is enabled on the group.
+	mirroringState *journal.MirroringState
+
+	// snapshotMarker is set when the creation of a group snapshot was
+	// interrupted.
+	snapshotMarker *journal.SnapshotMarker
 }
 
 // verify that volumeGroup implements the VolumeGroup and Stringer interfaces.
@@ -98,8 +121,9 @@ func GetVolumeGroup(
 			v.Destroy(ctx)
 		}
 	}()
+	resolveCtx := volumeResolver.PrefetchVolumes(ctx, slices.Collect(maps.Keys(attrs.VolumeMap)))
 	for volID := range attrs.VolumeMap {
-		vol, err := volumeResolver.GetVolumeByID(ctx, volID)
+		vol, err := volumeResolver.GetVolumeByID(resolveCtx, volID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get attributes for volume group id %q: %w", id, err)
 		}
@@ -110,6 +134,9 @@ func GetVolumeGroup(
 	vg.volumes = volumes
 	// all allocated volumes need to be free'd at Destroy() time
 	vg.volumesToFree = volumes
+	vg.failoverMarker = attrs.FailoverMarker
+	vg.mirroringState = attrs.MirroringState
+	vg.snapshotMarker = attrs.SnapshotMarker
 
 	log.DebugLog(ctx, "GetVolumeGroup(%s) returns %+v", id, *vg)
 
@@ -165,12 +192,12 @@ func (vg *volumeGroup) Create(ctx context.Context) error {
 		return fmt.Errorf("missing name to create volume group: %w", err)
 	}
 
-	ioctx, err := vg.GetIOContext(ctx)
+	ops, err := vg.getGroupOperations(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = librbd.GroupCreate(ioctx, name)
+	err = ops.Create(name)
 	if err != nil {
 		if !errors.Is(err, librbd.ErrExist) {
 			return fmt.Errorf("failed to create volume group %q: %w", name, err)
@@ -190,12 +217,12 @@ func (vg *volumeGroup) Delete(ctx context.Context) error {
 		return err
 	}
 
-	ioctx, err := vg.GetIOContext(ctx)
+	ops, err := vg.getGroupOperations(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = librbd.GroupRemove(ioctx, name)
+	err = ops.Remove(name)
 	if err != nil && !errors.Is(err, rados.ErrNotFound) {
 		return fmt.Errorf("failed to remove volume group %q: %w", vg, err)
 	}
@@ -206,18 +233,26 @@ func (vg *volumeGroup) Delete(ctx context.Context) error {
 }
 
 func (vg *volumeGroup) AddVolume(ctx context.Context, vol types.Volume) error {
-	err := vol.AddToGroup(ctx, vg)
+	err := vg.validateVolume(ctx, vol)
 	if err != nil {
-		return fmt.Errorf("failed to add volume %q to volume group %q: %w", vol, vg, err)
+		return err
 	}
 
-	vg.volumes = append(vg.volumes, vol)
+	err = vol.AddToGroup(ctx, vg)
+	if err != nil {
+		return fmt.Errorf("failed to add volume %q to volume group %q: %w", vol, vg, err)
+	}
 
 	volID, err := vol.GetID(ctx)
 	if err != nil {
 		return err
 	}
 
+	// a volume that an earlier attempt added is already listed
+	if !vg.hasVolume(ctx, volID) {
+		vg.volumes = append(vg.volumes, vol)
+	}
+
 	pool, err := vg.GetPool(ctx)
 	if err != nil {
 		return err
@@ -243,7 +278,7 @@ func (vg *volumeGroup) AddVolume(ctx context.Context, vol types.Volume) error {
 		return err
 	}
 
-	err = j.AddVolumesMapping(ctx, pool, csiID.ObjectUUID, toAdd)
+	vg.generation, err = j.UpdateVolumesMapping(ctx, pool, csiID.ObjectUUID, vg.generation, toAdd, nil)
 	if err != nil {
 		return fmt.Errorf("failed to add mapping for volume %q to volume group id %q: %w",
 			volID, id, err)
@@ -252,18 +287,100 @@ func (vg *volumeGroup) AddVolume(ctx context.Context, vol types.Volume) error {
 	return nil
 }
 
+// validateVolume checks that the volume can be a member of the RBD group. The
+// images of an RBD group can be in different pools of the cluster of the group,
+// as long as the connection of the group can open these pools. When the volume
+// is in another pool than the group, the pools need to be mirrored to the same
+// sites, otherwise the group can not be mirrored.
+func (vg *volumeGroup) validateVolume(ctx context.Context, vol types.Volume) error {
+	clusterID, err := vol.GetClusterID(ctx)
+	if err != nil {
+		return err
+	}
+	if clusterID != vg.clusterID {
+		return fmt.Errorf("%w: volume %q is in cluster %q, volume group %q is in cluster %q",
+			ErrIncompatibleVolume, vol, clusterID, vg, vg.clusterID)
+	}
+
+	pool, err := vol.GetPool(ctx)
+	if err != nil {
+		return err
+	}
+	// all members were compared with the pool of the group when they were
+	// added, so the pools of the members are mirrored in the same way
+	if pool == vg.pool {
+		return nil
+	}
+
+	conn, err := vg.getConnection(ctx)
+	if err != nil {
+		return err
+	}
+
+	ioctx, err := conn.GetIoctx(pool)
+	if err != nil {
+		return fmt.Errorf("%w: pool %q of volume %q can not be opened for volume group %q: %w",
+			ErrIncompatibleVolume, pool, vol, vg, err)
+	}
+	ioctx.Destroy()
+
+	return vg.checkPoolMirroring(ctx, vol)
+}
+
+// checkPoolMirroring returns an error when the pool of the volume is not
+// mirrored in the same way as the pool of the group.
+func (vg *volumeGroup) checkPoolMirroring(ctx context.Context, vol types.Volume) error {
+	mirror, err := vol.ToMirror()
+	if err != nil {
+		return err
+	}
+
+	volPool, err := mirror.GetPoolMirroring(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get mirroring of the pool of volume %q: %w", vol, err)
+	}
+
+	groupPool, err := vg.GetPoolMirroring(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get mirroring of the pool of volume group %q: %w", vg, err)
+	}
+
+	enabled := volPool.Mode != librbd.MirrorModeDisabled
+	if enabled != (groupPool.Mode != librbd.MirrorModeDisabled) {
+		return fmt.Errorf("%w: mirroring of pool %q of volume %q differs from pool %q of volume group %q",
+			ErrIncompatibleVolume, volPool.Pool, vol, groupPool.Pool, vg)
+	}
+	if !enabled {
+		return nil
+	}
+
+	sites := func(pm *types.PoolMirroring) []string {
+		names := make([]string, 0, len(pm.Peers))
+		for _, peer := range pm.Peers {
+			names = append(names, peer.SiteName)
+		}
+		slices.Sort(names)
+
+		return names
+	}
+	if !slices.Equal(sites(volPool), sites(groupPool)) {
+		return fmt.Errorf("%w: pool %q of volume %q is mirrored to sites %v, pool %q of volume group %q to %v",
+			ErrIncompatibleVolume, volPool.Pool, vol, sites(volPool), groupPool.Pool, vg, sites(groupPool))
+	}
+
+	return nil
+}
+
 func (vg *volumeGroup) RemoveVolume(ctx context.Context, vol types.Volume) error {
 	// volume was already removed from the group
 	if len(vg.volumes) == 0 {
 		return nil
 	}
 
+	// the image may have been removed from the group by an earlier attempt
+	// that failed to update the journal, the journal is updated anyway
 	err := vol.RemoveFromGroup(ctx, vg)
-	if err != nil {
-		if errors.Is(err, librbd.ErrNotExist) {
-			return nil
-		}
-
+	if err != nil && !errors.Is(err, librbd.ErrNotExist) {
 		return fmt.Errorf("failed to remove volume %q from volume group %q: %w", vol, vg, err)
 	}
 
@@ -318,7 +435,7 @@ func (vg *volumeGroup) RemoveVolume(ctx context.Context, vol types.Volume) error
 		return err
 	}
 
-	err = j.RemoveVolumesMapping(ctx, pool, csiID.ObjectUUID, mapping)
+	vg.generation, err = j.UpdateVolumesMapping(ctx, pool, csiID.ObjectUUID, vg.generation, nil, mapping)
 	if err != nil {
 		return fmt.Errorf("failed to remove mapping for volume %q to volume group id %q: %w",
 			toRemove, id, err)
@@ -327,47 +444,97 @@ func (vg *volumeGroup) RemoveVolume(ctx context.Context, vol types.Volume) error
 	return nil
 }
 
+// hasVolume returns true when the volume with the ID is in the volumes of the
+// group.
+func (vg *volumeGroup) hasVolume(ctx context.Context, volID string) bool {
+	return slices.ContainsFunc(vg.volumes, func(v types.Volume) bool {
+		id, err := v.GetID(ctx)
+
+		return err == nil && id == volID
+	})
+}
+
 func (vg *volumeGroup) ListVolumes(ctx context.Context) ([]types.Volume, error) {
 	return vg.volumes, nil
 }
 
+// GetFailoverMarker returns the marker of an interrupted promote or demote of
+// the volumes in the group, or nil if there is none.
+func (vg *volumeGroup) GetFailoverMarker(ctx context.Context) (*journal.FailoverMarker, error) {
+	return vg.failoverMarker, nil
+}
+
+// SetFailoverMarker stores the marker in the journal of the group.
+func (vg *volumeGroup) SetFailoverMarker(ctx context.Context, marker *journal.FailoverMarker) error {
+	j, err := vg.getJournal(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = j.SetFailoverMarker(ctx, vg.pool, vg.objectUUID, marker)
+	if err != nil {
+		return fmt.Errorf("failed to set failover marker for volume group %q: %w", vg, err)
+	}
+	vg.failoverMarker = marker
+
+	return nil
+}
+
+// ClearFailoverMarker removes the marker from the journal of the group.
+func (vg *volumeGroup) ClearFailoverMarker(ctx context.Context) error {
+	j, err := vg.getJournal(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = j.RemoveFailoverMarker(ctx, vg.pool, vg.objectUUID)
+	if err != nil {
+		return fmt.Errorf("failed to remove failover marker for volume group %q: %w", vg, err)
+	}
+	vg.failoverMarker = nil
+
+	return nil
+}
+
 // CreateSnapshots makes consistent snapshots of all the volumes in the volume group.
+// The RBD group snapshot is only used as the source of the snapshots, it is
+// removed afterwards. When ctx is done before all snapshots are created, the
+// RBD group snapshot is kept and recorded in the journal, so that the next
+// call resumes from it instead of creating it again.
 func (vg *volumeGroup) CreateSnapshots(
 	ctx context.Context,
 	cr *util.Credentials,
 	name string,
+	namePrefix types.SnapshotNamePrefixFunc,
 ) ([]types.Snapshot, error) {
 	group, err := vg.GetName(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	ioctx, err := vg.GetIOContext(ctx)
+	ops, err := vg.getGroupOperations(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	err = librbd.GroupSnapCreate(ioctx, group, name)
-	if err != nil {
-		if !errors.Is(err, librbd.ErrExist) {
-			return nil, fmt.Errorf("failed to create volume group snapshot %q: %w", name, err)
+	groupSnap, info, err := vg.startGroupSnapshot(ctx, ops, group, name)
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			log.WarningLog(ctx, "creating volume group snapshot %q was interrupted, keeping it to resume: %v",
+				vg.String()+"@"+groupSnap, err)
+
+			return
 		}
 
-		log.DebugLog(ctx, "ignoring error while creating volume group snapshot %q: %v", vg, err)
-	}
-	defer func() {
-		// always remove the groups-snapshot on function exit, it is not used anymore afterwards
-		cleanupErr := librbd.GroupSnapRemove(ioctx, group, name)
-		if cleanupErr != nil {
+		// remove the groups-snapshot on function exit, it is not used anymore afterwards
+		fErr := vg.finishGroupSnapshot(ctx, ops, group, groupSnap)
+		if fErr != nil {
 			log.ErrorLog(ctx, "failed to remove temporary volume group snapshot %q: %v",
-				name, cleanupErr)
+				vg.String()+"@"+groupSnap, fErr)
 		}
 	}()
-
-	info, err := librbd.GroupSnapGetInfo(ioctx, group, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get info for volume group snapshot %q: %w",
-			vg.String()+"@"+name, err)
+		return nil, err
 	}
 
 	snapshots := make([]types.Snapshot, len(info.Snapshots))
@@ -377,15 +544,40 @@ func (vg *volumeGroup) CreateSnapshots(
 			return
 		}
 
+		// the snapshots are created again when the call is resumed
 		for _, snapshot := range snapshots {
+			if snapshot == nil {
+				continue
+			}
+
+			delErr := snapshot.Delete(ctx)
+			if delErr != nil {
+				log.ErrorLog(ctx, "failed to delete snapshot %q: %v", snapshot, delErr)
+			}
 			snapshot.Destroy(ctx)
 		}
 	}()
 
+	// stop before the deadline of the caller, the group snapshot is kept
+	// for the next call
+	if err = ctx.Err(); err != nil {
+		return nil, fmt.Errorf("stopped creating snapshots from volume group snapshot %q: %w",
+			vg.String()+"@"+groupSnap, err)
+	}
+
 	// Loop though all the RBD-snapshots in the group, and find the volume
 	// that was used to create the snapshot. Once found, use the volume to
 	// create a new RBD-image from the RBD-snapshot.
+	sources := make([]types.Volume, len(info.Snapshots))
+	prefixes := make([]string, len(info.Snapshots))
 	for i, snap := range info.Snapshots {
+		if namePrefix != nil {
+			prefixes[i], err = namePrefix(snap.Name, i)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the name prefix for the snapshot of image %q: %w", snap.Name, err)
+			}
+		}
+
 		for _, volume := range vg.volumes {
 			var volName string
 
@@ -394,24 +586,306 @@ func (vg *volumeGroup) CreateSnapshots(
 				return nil, fmt.Errorf(
 					"failed to get name for volume %q: %w", volume, err)
 			}
-			if volName != snap.Name {
-				// the volume isn't the snapshot-source, continue with the next one
-				continue
+			if volName == snap.Name {
+				sources[i] = volume
+
+				break
 			}
+		}
+	}
+
+	err = vg.createSnapshotImages(ctx, cr, group, info.Snapshots, sources, prefixes, snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshots from volume group snapshot %q: %w",
+			vg.String()+"@"+groupSnap, err)
+	}
+
+	return snapshots, nil
+}
+
+// createSnapshotImages creates the RBD-images for the RBD-snapshots of the
+// group from their source volume and with their name prefix, with at most
+// snapshotCreateConcurrency creations in flight. The created snapshots are stored in snapshots at the
+// index of their RBD-snapshot, also when other creations failed, so that the
+// caller can clean them up. The error of every image that failed is returned,
+// images that were not started before ctx is done fail with the context error.
+func (vg *volumeGroup) createSnapshotImages(
+	ctx context.Context,
+	cr *util.Credentials,
+	group string,
+	snaps []librbd.GroupSnap,
+	sources []types.Volume,
+	prefixes []string,
+	snapshots []types.Snapshot,
+) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, snapshotCreateConcurrency)
+	)
+	for i, snap := range snaps {
+		if sources[i] == nil {
+			// the image of the RBD-snapshot is not a volume of the group
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("image %q: %w", snap.Name, err))
+			mu.Unlock()
+
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, snap librbd.GroupSnap) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
 
-			// yay, volume for the RBD-snapshot found!
 			snapName := fmt.Sprintf("%s-snap-%d", group, i)
-			snapshots[i], err = volume.NewSnapshotByID(ctx, cr, snapName, snap.SnapID)
+			snapshot, err := sources[i].NewSnapshotByID(ctx, cr, snapName, prefixes[i], snap.SnapID)
 			if err != nil {
-				return nil, fmt.Errorf(
-					"failed to create snapshot for image %q with snapshot id %d: %w",
+				log.ErrorLog(ctx, "failed to create snapshot for image %q with snapshot id %d: %v",
 					snap.Name, snap.SnapID, err)
+
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Errorf("image %q with snapshot id %d: %w", snap.Name, snap.SnapID, err))
+
+				return
+			}
+
+			// every goroutine writes its own index
+			snapshots[i] = snapshot
+		}(i, snap)
+	}
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%d of %d images failed: %w", len(errs), len(snaps), errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// startGroupSnapshot returns the name and info of the RBD group snapshot for
+// CreateSnapshots. A complete group snapshot that an interrupted earlier call
+// left behind is reused, the name of the request may differ as the temporary
+// group is only used for a single request. Otherwise the group snapshot is
+// created, after storing the marker in the journal.
+func (vg *volumeGroup) startGroupSnapshot(
+	ctx context.Context,
+	ops groupOperations,
+	group, name string,
+) (string, librbd.GroupSnapInfo, error) {
+	if marker := vg.snapshotMarker; marker != nil {
+		info, err := ops.SnapGetInfo(group, marker.Name)
+		switch {
+		case err == nil && info.State == librbd.GroupSnapStateComplete:
+			log.DebugLog(ctx, "resuming volume group snapshot %q that was started at %s",
+				vg.String()+"@"+marker.Name, marker.StartTime)
+
+			return marker.Name, info, nil
+		case err == nil:
+			// not all images were snapshotted, start over
+			err = ops.SnapRemove(group, marker.Name)
+			if err != nil {
+				return marker.Name, librbd.GroupSnapInfo{}, fmt.Errorf(
+					"failed to remove incomplete volume group snapshot %q: %w", vg.String()+"@"+marker.Name, err)
 			}
+		case !errors.Is(err, librbd.ErrNotFound):
+			return marker.Name, librbd.GroupSnapInfo{}, fmt.Errorf(
+				"failed to get info for volume group snapshot %q: %w", vg.String()+"@"+marker.Name, err)
+		}
+	}
 
-			// done, no need to try more volumes in the loop
-			break
+	err := vg.setSnapshotMarker(ctx, &journal.SnapshotMarker{
+		Name:      name,
+		StartTime: time.Now(),
+	})
+	if err != nil {
+		return name, librbd.GroupSnapInfo{}, err
+	}
+
+	err = ops.SnapCreate(group, name)
+	if err != nil {
+		if !errors.Is(err, librbd.ErrExist) {
+			return name, librbd.GroupSnapInfo{}, fmt.Errorf("failed to create volume group snapshot %q: %w", name, err)
 		}
+
+		log.DebugLog(ctx, "ignoring error while creating volume group snapshot %q: %v", vg, err)
 	}
 
-	return snapshots, nil
+	info, err := ops.SnapGetInfo(group, name)
+	if err != nil {
+		return name, librbd.GroupSnapInfo{}, fmt.Errorf("failed to get info for volume group snapshot %q: %w",
+			vg.String()+"@"+name, err)
+	}
+
+	return name, info, nil
+}
+
+// finishGroupSnapshot removes the RBD group snapshot and the marker from the
+// journal. The marker is kept when the group snapshot can not be removed.
+func (vg *volumeGroup) finishGroupSnapshot(ctx context.Context, ops groupOperations, group, name string) error {
+	err := ops.SnapRemove(group, name)
+	if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return err
+	}
+
+	if vg.snapshotMarker == nil {
+		return nil
+	}
+
+	j, err := vg.getJournal(ctx)
+	if err == nil {
+		err = j.RemoveSnapshotMarker(ctx, vg.pool, vg.objectUUID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove snapshot marker: %w", err)
+	}
+	vg.snapshotMarker = nil
+
+	return nil
+}
+
+// ResumableSnapshots returns true when an interrupted CreateSnapshots left a
+// group snapshot behind that was started at notBefore or later, the next call
+// of CreateSnapshots resumes from it. An older group snapshot is stale, it is
+// removed together with its marker so that CreateSnapshots takes a new one.
+func (vg *volumeGroup) ResumableSnapshots(ctx context.Context, notBefore time.Time) (bool, error) {
+	marker := vg.snapshotMarker
+	if marker == nil {
+		return false, nil
+	}
+
+	if !marker.StartTime.Before(notBefore) {
+		return true, nil
+	}
+
+	log.DebugLog(ctx, "removing stale volume group snapshot %q that was started at %s",
+		vg.String()+"@"+marker.Name, marker.StartTime)
+
+	group, err := vg.GetName(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	ops, err := vg.getGroupOperations(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	err = vg.finishGroupSnapshot(ctx, ops, group, marker.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove stale volume group snapshot %q: %w",
+			vg.String()+"@"+marker.Name, err)
+	}
+
+	return false, nil
+}
+
+// setSnapshotMarker stores the marker in the journal of the group.
+func (vg *volumeGroup) setSnapshotMarker(ctx context.Context, marker *journal.SnapshotMarker) error {
+	j, err := vg.getJournal(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = j.SetSnapshotMarker(ctx, vg.pool, vg.objectUUID, marker)
+	if err != nil {
+		return fmt.Errorf("failed to set snapshot marker for volume group %q: %w", vg, err)
+	}
+	vg.snapshotMarker = marker
+
+	return nil
+}
+
+// RollbackToSnapshot reverts all the volumes in the volume group to the group
+// snapshot with the given name. librbd rolls back the images of the group
+// together, so that the volumes stay consistent with each other. The rollback
+// is refused when a volume is in use, as the data would change underneath
+// the application, or when the group snapshot does not match the current
+// members of the group.
+func (vg *volumeGroup) RollbackToSnapshot(ctx context.Context, name string) error {
+	group, err := vg.GetName(ctx)
+	if err != nil {
+		return err
+	}
+
+	ops, err := vg.getGroupOperations(ctx)
+	if err != nil {
+		return err
+	}
+
+	info, err := ops.SnapGetInfo(group, name)
+	if err != nil {
+		return fmt.Errorf("failed to get info for volume group snapshot %q: %w",
+			vg.String()+"@"+name, err)
+	}
+
+	if info.State != librbd.GroupSnapStateComplete {
+		return fmt.Errorf("volume group snapshot %q is incomplete, it can not be rolled back to",
+			vg.String()+"@"+name)
+	}
+
+	err = vg.checkSnapshotMembers(ctx, name, info.Snapshots)
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range vg.volumes {
+		inUse, iErr := volume.IsInUse(ctx)
+		if iErr != nil {
+			return fmt.Errorf("failed to check watchers of volume %q: %w", volume, iErr)
+		}
+		if inUse {
+			return fmt.Errorf("volume %q is in use, can not roll back volume group %q to snapshot %q",
+				volume, vg, name)
+		}
+	}
+
+	err = ops.SnapRollback(group, name)
+	if err != nil {
+		return fmt.Errorf("failed to roll back volume group %q to snapshot %q: %w", vg, name, err)
+	}
+
+	log.DebugLog(ctx, "rolled back %d volumes of volume group %q to snapshot %q", len(vg.volumes), vg, name)
+
+	return nil
+}
+
+// checkSnapshotMembers verifies that the RBD-snapshots of the group snapshot
+// belong to the volumes that are in the group now. A volume that was added
+// after the group snapshot was taken would not be rolled back, and the
+// snapshot of a volume that was removed since can not be restored anymore.
+func (vg *volumeGroup) checkSnapshotMembers(ctx context.Context, name string, snaps []librbd.GroupSnap) error {
+	pending := make(map[string]bool, len(snaps))
+	for _, snap := range snaps {
+		pending[snap.Name] = true
+	}
+
+	for _, volume := range vg.volumes {
+		volName, err := volume.GetName(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get name for volume %q: %w", volume, err)
+		}
+
+		if !pending[volName] {
+			return fmt.Errorf("volume %q was added to volume group %q after snapshot %q was created",
+				volume, vg, name)
+		}
+		delete(pending, volName)
+	}
+
+	if len(pending) != 0 {
+		return fmt.Errorf("volume group snapshot %q contains images that are not in the group anymore: %v",
+			vg.String()+"@"+name, slices.Sorted(maps.Keys(pending)))
+	}
+
+	return nil
 }
@@ -19,6 +19,7 @@ package rbd
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -27,9 +28,15 @@ import (
 	"github.com/ceph/ceph-csi/internal/rbd/group"
 	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
+// groupSnapshotResumeTimeout is how long the group snapshot of an interrupted
+// CreateVolumeGroupSnapshot is resumed by the next request, an older group
+// snapshot is taken again.
+const groupSnapshotResumeTimeout = 10 * time.Minute
+
 // CreateVolumeGroupSnapshot receives a list of volume handles and is requested
 // to create a list of snapshots that are created at the same time. This is
 // similar (although not exactly the same) to consistency groups.
@@ -53,6 +60,14 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 		// the VG and VGS should not have the same name
 		vgName  = req.GetName() + "-vg" // stable temporary name
 		vgsName = req.GetName()
+
+		// keepGroup is set when creating the group snapshot was
+		// interrupted, the next call resumes with the VG and its snapshot
+		keepGroup bool
+
+		// group snapshots of interrupted requests that were started
+		// before resumeAfter are not resumed
+		resumeAfter = time.Now().Add(-groupSnapshotResumeTimeout)
 	)
 
 	// Existence and conflict checks
@@ -63,6 +78,11 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 	}
 	defer cs.VolumeGroupLocks.Release(vgsName)
 
+	err = validateSnapshotNameTemplate(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
 	mgr := NewManager(cs.Driver.GetInstanceID(), req.GetParameters(), req.GetSecrets())
 	defer mgr.Destroy(ctx)
 
@@ -70,7 +90,7 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 	volumes := make([]types.Volume, len(req.GetSourceVolumeIds()))
 	defer func() {
 		for _, volume := range volumes {
-			if vg != nil {
+			if vg != nil && !keepGroup {
 				// 'normal' cleanup, remove all images from the group
 				vgErr := vg.RemoveVolume(ctx, volume)
 				if vgErr != nil {
@@ -87,7 +107,11 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 			}
 		}
 
-		if vg != nil {
+		if vg != nil && keepGroup {
+			log.DebugLog(ctx, "keeping temporary volume group %q to resume the volume group snapshot", vg)
+
+			vg.Destroy(ctx)
+		} else if vg != nil {
 			// the VG should always be deleted, volumes can only belong to a single VG
 			log.DebugLog(ctx, "removing temporary volume group %q", vg)
 
@@ -176,8 +200,51 @@ func (cs *ControllerServer) CreateVolumeGroupSnapshot(
 		}
 	}
 
-	groupSnapshot, err = mgr.CreateVolumeGroupSnapshot(ctx, vg, vgsName)
+	clusterID, err := util.GetClusterID(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	hook, err := util.NewSnapshotHook(util.CsiConfigFile, clusterID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// the applications were quiesced for the group snapshot of an
+	// interrupted request already, quiescing them again would be followed
+	// by a snapshot of the earlier state
+	resume, err := vg.ResumableSnapshots(ctx, resumeAfter)
 	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	createSnapshot := func() error {
+		var sErr error
+		groupSnapshot, sErr = mgr.CreateVolumeGroupSnapshot(ctx, vg, vgsName)
+
+		return sErr
+	}
+	if resume {
+		log.DebugLog(ctx, "resuming volume group snapshot %q without calling the snapshot hook", vgsName)
+		err = createSnapshot()
+	} else {
+		err = hook.Run(ctx, &util.SnapshotHookRequest{
+			ClusterID:       clusterID,
+			Name:            vgsName,
+			SourceVolumeIDs: req.GetSourceVolumeIds(),
+			Parameters:      req.GetParameters(),
+		}, createSnapshot)
+	}
+	if errors.Is(err, util.ErrSnapshotHookFailed) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil && ctx.Err() != nil {
+		// the group and its links are only kept when there is a group
+		// snapshot to resume from, otherwise they are removed
+		keepGroup, _ = vg.ResumableSnapshots(ctx, resumeAfter)
+
+		return nil, status.Errorf(status.FromContextError(ctx.Err()).Code(),
+			"volume group snapshot %q was interrupted, it is resumed by the next request: %s",
+			vgsName, err.Error())
+	} else if err != nil {
 		return nil, status.Error(codes.Aborted, err.Error())
 	}
 	defer groupSnapshot.Destroy(ctx)
@@ -287,3 +354,22 @@ func (cs *ControllerServer) GetVolumeGroupSnapshot(
 		GroupSnapshot: csiVGS,
 	}, nil
 }
+
+// validateSnapshotNameTemplate returns an InvalidArgument error when the
+// snapshotNameTemplate parameter is empty, or can not be rendered.
+func validateSnapshotNameTemplate(parameters map[string]string) error {
+	template, ok := parameters[k8s.SnapshotNameTemplateParam]
+	if !ok {
+		return nil
+	}
+	if template == "" {
+		return status.Error(codes.InvalidArgument, "empty snapshot name template to create volume group snapshot")
+	}
+
+	_, err := k8s.RenderSnapshotNamePrefix(template, "groupsnapshot", "image", 0)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return nil
+}
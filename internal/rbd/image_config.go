@@ -0,0 +1,321 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/prometheus/client_golang/prometheus"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// imageConfigParam is the StorageClass parameter with the librbd
+	// configuration overrides of the images, like
+	// "rbd_qos_iops_limit=1000,rbd_compression_hint=compressible".
+	imageConfigParam = "imageConfig"
+
+	// imageConfigKey is the journal attribute of a volume that stores the
+	// desired configuration of its image, so that it can be re-applied.
+	imageConfigKey = "imageconfig"
+
+	// imageConfigMetadataPrefix is the prefix of the image metadata keys
+	// that librbd reads as configuration overrides of the image.
+	imageConfigMetadataPrefix = "conf_"
+)
+
+// imageConfigOptionRegex matches the names of librbd configuration options.
+var imageConfigOptionRegex = regexp.MustCompile(`^rbd_[a-z0-9_]+$`)
+
+// parseImageConfig returns the configuration overrides of the imageConfigParam
+// in the parameters, nil is returned when the parameter is not set.
+func parseImageConfig(parameters map[string]string) (map[string]string, error) {
+	value := strings.TrimSpace(parameters[imageConfigParam])
+	if value == "" {
+		return nil, nil
+	}
+
+	config := map[string]string{}
+	for _, option := range strings.Split(value, ",") {
+		key, v, ok := strings.Cut(strings.TrimSpace(option), "=")
+		key = strings.TrimSpace(key)
+		v = strings.TrimSpace(v)
+		if !ok || v == "" {
+			return nil, fmt.Errorf("invalid %s option %q, expected <name>=<value>", imageConfigParam, option)
+		}
+		if !imageConfigOptionRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid %s option %q, only rbd_* options can be set on an image",
+				imageConfigParam, key)
+		}
+		config[key] = v
+	}
+
+	return config, nil
+}
+
+// storeImageConfig stores the configuration overrides of the volume in the
+// journal, if the StorageClass sets them.
+func (rv *rbdVolume) storeImageConfig(ctx context.Context, cr *util.Credentials) error {
+	if len(rv.imageConfig) == 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(rv.imageConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image configuration: %w", err)
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	return j.StoreAttribute(ctx, rv.Pool, rv.ReservedID, imageConfigKey, string(value))
+}
+
+// setImageConfig sets the configuration overrides of the volume on its image.
+func (rv *rbdVolume) setImageConfig(ctx context.Context) error {
+	if len(rv.imageConfig) == 0 {
+		return nil
+	}
+
+	image, err := rv.open()
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	_, err = applyImageConfig(ctx, image, rv.imageConfig)
+	if err != nil {
+		return fmt.Errorf("failed to set configuration of image %s: %w", rv, err)
+	}
+
+	return nil
+}
+
+// applyImageConfig sets the configuration overrides in config on the image,
+// the options that have the desired value already are not modified. The
+// names of the options that were set are returned.
+func applyImageConfig(ctx context.Context, image *librbd.Image, config map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	applied := []string{}
+	for _, key := range keys {
+		current, err := image.GetMetadata(imageConfigMetadataPrefix + key)
+		switch {
+		case err == nil && current == config[key]:
+			continue
+		case err != nil && !errors.Is(err, librbd.ErrNotFound):
+			return applied, fmt.Errorf("failed to get option %s: %w", key, err)
+		}
+
+		err = image.SetMetadata(imageConfigMetadataPrefix+key, config[key])
+		if err != nil {
+			return applied, fmt.Errorf("failed to set option %s to %q: %w", key, config[key], err)
+		}
+		log.DebugLog(ctx, "set option %s of image %s to %q (was %q)", key, image.GetName(), config[key], current)
+		applied = append(applied, key)
+	}
+
+	return applied, nil
+}
+
+// imageReconcileFunc re-applies a part of the desired state of the image of
+// a reservation, which is kept in the journal. It returns true when the image
+// was modified.
+type imageReconcileFunc func(
+	ctx context.Context,
+	lc *listVolumesConnection,
+	imagePool string,
+	r journal.Reservation,
+	image *librbd.Image,
+) (bool, error)
+
+// imageReconcilers are run for every image by the reconcile worker, keyed by
+// the name that is used in logs and metrics.
+var imageReconcilers = map[string]imageReconcileFunc{
+	"imageConfig": reconcileImageConfig,
+}
+
+// reconcileImageConfig re-applies the configuration overrides in the journal
+// to the image. Offline migrations and `rbd import` do not keep the
+// overrides of an image.
+func reconcileImageConfig(
+	ctx context.Context,
+	lc *listVolumesConnection,
+	imagePool string,
+	r journal.Reservation,
+	image *librbd.Image,
+) (bool, error) {
+	value, err := lc.journal.FetchAttribute(ctx, imagePool, r.ImageUUID, imageConfigKey)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	config := map[string]string{}
+	err = json.Unmarshal([]byte(value), &config)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse image configuration of %q: %w", r.RequestName, err)
+	}
+
+	applied, err := applyImageConfig(ctx, image, config)
+	if len(applied) != 0 {
+		log.WarningLog(ctx, "re-applied options %v of image %s/%s of volume %q", applied, imagePool,
+			image.GetName(), r.RequestName)
+	}
+
+	return len(applied) != 0, err
+}
+
+// imageReconciler runs the imageReconcilers on the images of the driver.
+type imageReconciler struct {
+	driverName string
+	interval   time.Duration
+
+	reconciled *prometheus.CounterVec
+}
+
+// StartImageReconcile runs the imageReconcilers on the images in the journals
+// of the StorageClass pools of the driver at the interval, to re-apply the
+// desired configuration of the volumes after cluster upgrades and
+// migrations. Only the replica of the provisioner that holds the
+// `<drivername>-image-reconcile` lease in namespace modifies the images.
+func StartImageReconcile(driverName, namespace string, interval time.Duration) error {
+	r := &imageReconciler{
+		driverName: driverName,
+		interval:   interval,
+		reconciled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "csi",
+			Subsystem: "rbd",
+			Name:      "image_reconciles_total",
+			Help:      "Number of images that were modified (result=modified) or failed (result=failed) to reconcile",
+		}, []string{"reconciler", "result"}),
+	}
+	err := prometheus.Register(r.reconciled)
+	if err != nil {
+		return fmt.Errorf("failed to register image reconcile metrics: %w", err)
+	}
+
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	return kubeclient.StartElected(client, namespace, driverName+"-image-reconcile", r.run)
+}
+
+func (r *imageReconciler) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.reconcile(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *imageReconciler) reconcile(ctx context.Context) {
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+		return
+	}
+
+	sources, err := getListVolumesSources(ctx, client, r.driverName)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", r.driverName, err)
+
+		return
+	}
+
+	for _, source := range sources {
+		err = r.reconcileSource(ctx, client, source)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to reconcile images in pool %q of cluster %q: %v",
+				source.JournalPool, source.ClusterID, err)
+		}
+	}
+}
+
+// reconcileSource runs the imageReconcilers on the images in the journal of
+// the source. A failure of a single image is logged and counted, the other
+// images are still reconciled.
+func (r *imageReconciler) reconcileSource(ctx context.Context, client *k8s.Clientset, source *listVolumesSource) error {
+	lc, err := connectListVolumesSource(ctx, client, source)
+	if err != nil {
+		return err
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, source.JournalPool, "", 0)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range reservations {
+		imagePool, ri, image, _, oErr := lc.openReservedImage(ctx, res)
+		if oErr != nil {
+			if isMissingReservedImage(oErr) {
+				continue
+			}
+
+			return oErr
+		}
+
+		for name, reconcile := range imageReconcilers {
+			modified, rErr := reconcile(ctx, lc, imagePool, res, image)
+			switch {
+			case rErr != nil:
+				log.ErrorLog(ctx, "failed to reconcile %s of image %s of volume %q: %v", name, ri, res.RequestName, rErr)
+				r.reconciled.WithLabelValues(name, "failed").Inc()
+			case modified:
+				r.reconciled.WithLabelValues(name, "modified").Inc()
+			}
+		}
+
+		image.Close()
+		ri.Destroy(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageConfig(t *testing.T) {
+	t.Parallel()
+
+	config, err := parseImageConfig(map[string]string{"pool": "replicapool"})
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	config, err = parseImageConfig(map[string]string{
+		imageConfigParam: "rbd_qos_iops_limit=1000, rbd_compression_hint = compressible",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"rbd_qos_iops_limit":   "1000",
+		"rbd_compression_hint": "compressible",
+	}, config)
+
+	invalid := []string{
+		"rbd_qos_iops_limit",
+		"rbd_qos_iops_limit=",
+		"rbd_qos_iops_limit=1000,,rbd_qos_bps_limit=1",
+		"osd_pool_default_size=1",
+		"rbd_QoS=1",
+	}
+	for _, value := range invalid {
+		_, err = parseImageConfig(map[string]string{imageConfigParam: value})
+		require.Error(t, err, value)
+	}
+}
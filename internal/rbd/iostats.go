@@ -0,0 +1,271 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// ioStatsImage is an image of a reservation in the journal, cached by the
+// ioStatsCollector as the image of a reservation does not change.
+type ioStatsImage struct {
+	pool      string
+	image     string
+	volume    string
+	namespace string
+}
+
+// ioStatsSample is the IO rate of an image.
+type ioStatsSample struct {
+	ioStatsImage
+	stats util.ImageIOStats
+}
+
+// ioStatsCollector samples the IO rates of the images of the driver from the
+// rbd_support manager module, and publishes them per volume.
+type ioStatsCollector struct {
+	driverName string
+	interval   time.Duration
+
+	// images is keyed by the key of the source and the UUID of the
+	// reservation
+	images map[string]ioStatsImage
+
+	ops     *prometheus.GaugeVec
+	bytes   *prometheus.GaugeVec
+	latency *prometheus.GaugeVec
+}
+
+// StartIOStats registers the IO metrics of the images, and samples them from
+// `rbd perf image stats` at the interval. Only the images in the journals of
+// the StorageClass pools of the driver are reported, by the replica of the
+// provisioner that holds the `<drivername>-io-stats` lease in namespace.
+func StartIOStats(driverName, namespace string, interval time.Duration) error {
+	newGaugeVec := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "csi",
+			Subsystem: "rbd_image",
+			Name:      name,
+			Help:      help,
+		}, []string{"driver", "volume", "namespace", "pool", "image", "direction"})
+	}
+
+	c := &ioStatsCollector{
+		driverName: driverName,
+		interval:   interval,
+		images:     map[string]ioStatsImage{},
+		ops:        newGaugeVec("operations_per_second", "IO operations per second of the image"),
+		bytes:      newGaugeVec("bytes_per_second", "Bytes read or written per second of the image"),
+		latency:    newGaugeVec("latency_seconds", "Average latency of the IO operations of the image"),
+	}
+	for _, m := range []prometheus.Collector{c.ops, c.bytes, c.latency} {
+		err := prometheus.Register(m)
+		if err != nil {
+			return fmt.Errorf("failed to register image IO metrics: %w", err)
+		}
+	}
+
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	return kubeclient.StartElected(client, namespace, driverName+"-io-stats", c.run)
+}
+
+func (c *ioStatsCollector) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.sample(ctx)
+
+		select {
+		case <-ctx.Done():
+			// the replica that holds the lease now reports the images
+			c.reset()
+
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ioStatsCollector) sample(ctx context.Context) {
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+		return
+	}
+
+	sources, err := getListVolumesSources(ctx, client, c.driverName)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", c.driverName, err)
+
+		return
+	}
+
+	images := map[string]ioStatsImage{}
+	samples := []ioStatsSample{}
+	for _, source := range sources {
+		s, sErr := c.sampleSource(ctx, client, source, images)
+		if sErr != nil {
+			log.ErrorLog(ctx, "failed to sample IO of images in pool %q of cluster %q: %v",
+				source.JournalPool, source.ClusterID, sErr)
+
+			continue
+		}
+		samples = append(samples, s...)
+	}
+	// reservations that were removed are not looked up again
+	c.images = images
+
+	c.publish(samples)
+	log.DebugLog(ctx, "sampled IO of %d images", len(samples))
+}
+
+// sampleSource returns the IO rates of the images in the journal of the
+// source. The images of the reservations are added to images.
+func (c *ioStatsCollector) sampleSource(
+	ctx context.Context,
+	client *k8s.Clientset,
+	source *listVolumesSource,
+	images map[string]ioStatsImage,
+) ([]ioStatsSample, error) {
+	lc, err := connectListVolumesSource(ctx, client, source)
+	if err != nil {
+		return nil, err
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, source.JournalPool, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// the images are keyed by pool and name, as reported by the manager
+	byPool := map[string]map[string]ioStatsImage{}
+	for _, r := range reservations {
+		key := source.key() + "/" + r.ImageUUID
+		img, ok := c.images[key]
+		if !ok {
+			img, err = lc.getIOStatsImage(ctx, r)
+			if err != nil {
+				if isMissingReservedImage(err) {
+					continue
+				}
+
+				return nil, err
+			}
+		}
+		images[key] = img
+
+		if byPool[img.pool] == nil {
+			byPool[img.pool] = map[string]ioStatsImage{}
+		}
+		byPool[img.pool][img.image] = img
+	}
+
+	cc := &util.ClusterConnection{}
+	err = cc.Connect(lc.monitors, lc.cr)
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Destroy()
+
+	samples := []ioStatsSample{}
+	for pool, poolImages := range byPool {
+		stats, sErr := cc.GetImageIOStats(pool, lc.radosNamespace)
+		if sErr != nil {
+			return nil, sErr
+		}
+
+		for _, s := range stats {
+			// images that are not created by the driver are skipped
+			if img, ok := poolImages[s.Image]; ok {
+				samples = append(samples, ioStatsSample{ioStatsImage: img, stats: s})
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+// getIOStatsImage returns the pool and the name of the image of the
+// reservation.
+func (lc *listVolumesConnection) getIOStatsImage(ctx context.Context, r journal.Reservation) (ioStatsImage, error) {
+	var err error
+
+	imagePool := lc.source.JournalPool
+	if r.ImagePoolID != util.InvalidPoolID {
+		imagePool, err = util.GetPoolName(lc.monitors, lc.cr, r.ImagePoolID)
+		if err != nil {
+			return ioStatsImage{}, err
+		}
+	}
+
+	attrs, err := lc.journal.GetImageAttributes(ctx, imagePool, r.ImageUUID, false)
+	if err != nil {
+		return ioStatsImage{}, err
+	}
+	if attrs.RequestName != r.RequestName {
+		return ioStatsImage{}, fmt.Errorf("%w: reservation %q points to image of request %q",
+			util.ErrKeyNotFound, r.RequestName, attrs.RequestName)
+	}
+
+	return ioStatsImage{
+		pool:      imagePool,
+		image:     attrs.ImageName,
+		volume:    r.RequestName,
+		namespace: attrs.Owner,
+	}, nil
+}
+
+// reset removes the IO rates of all images.
+func (c *ioStatsCollector) reset() {
+	c.ops.Reset()
+	c.bytes.Reset()
+	c.latency.Reset()
+}
+
+func (c *ioStatsCollector) publish(samples []ioStatsSample) {
+	// images without IO are not reported by the manager anymore
+	c.reset()
+
+	for _, s := range samples {
+		read := []string{c.driverName, s.volume, s.namespace, s.pool, s.image, "read"}
+		write := []string{c.driverName, s.volume, s.namespace, s.pool, s.image, "write"}
+
+		c.ops.WithLabelValues(read...).Set(s.stats.ReadOpsPerSec)
+		c.ops.WithLabelValues(write...).Set(s.stats.WriteOpsPerSec)
+		c.bytes.WithLabelValues(read...).Set(s.stats.ReadBytesPerSec)
+		c.bytes.WithLabelValues(write...).Set(s.stats.WriteBytesPerSec)
+		c.latency.WithLabelValues(read...).Set(s.stats.ReadLatency.Seconds())
+		c.latency.WithLabelValues(write...).Set(s.stats.WriteLatency.Seconds())
+	}
+}
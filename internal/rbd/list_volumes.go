@@ -0,0 +1,550 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// the StorageClass parameters of the external-provisioner that point
+	// to the secret with the credentials for the Ceph cluster.
+	provisionerSecretNameKey      = "csi.storage.k8s.io/provisioner-secret-name"
+	provisionerSecretNamespaceKey = "csi.storage.k8s.io/provisioner-secret-namespace"
+)
+
+// listVolumesSource is a journal pool that may contain volumes of this
+// driver. The credentials are taken from a StorageClass that uses the pool.
+type listVolumesSource struct {
+	ClusterID       string
+	JournalPool     string
+	SecretName      string
+	SecretNamespace string
+}
+
+// key identifies the source in a listVolumesToken.
+func (s *listVolumesSource) key() string {
+	return s.ClusterID + "/" + s.JournalPool
+}
+
+// listVolumesToken is the position in the listing of volumes, it is passed to
+// the CO as an opaque next_token.
+type listVolumesToken struct {
+	// Source is the key of the listVolumesSource to continue with.
+	Source string `json:"source"`
+	// After is the last request name that was returned for the source.
+	After string `json:"after"`
+}
+
+// encode returns the listVolumesToken as a string for the next_token.
+func (t *listVolumesToken) encode() (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// parseListVolumesToken decodes the starting_token of a ListVolumes request.
+// An empty token starts at the beginning of the listing.
+func parseListVolumesToken(token string) (*listVolumesToken, error) {
+	t := &listVolumesToken{}
+	if token == "" {
+		return t, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token %q: %w", token, err)
+	}
+
+	err = json.Unmarshal(data, t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token %q: %w", token, err)
+	}
+
+	if t.Source == "" {
+		return nil, fmt.Errorf("token %q does not contain a source", token)
+	}
+
+	return t, nil
+}
+
+// ListVolumes returns the volumes that are reserved in the journal of the
+// pools used by the StorageClasses of this driver. When the node addresses
+// can be read from Kubernetes, the nodes that have the image mapped are
+// detected from the watchers of the image.
+func (cs *ControllerServer) ListVolumes(
+	ctx context.Context,
+	req *csi.ListVolumesRequest,
+) (*csi.ListVolumesResponse, error) {
+	if err := cs.Driver.ValidateControllerServiceRequest(
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES); err != nil {
+		log.ErrorLog(ctx, "invalid list volumes req: %v", req)
+
+		return nil, err
+	}
+
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid max_entries %d", req.GetMaxEntries())
+	}
+
+	token, err := parseListVolumesToken(req.GetStartingToken())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to connect to Kubernetes: %v", err)
+	}
+
+	sources, err := getListVolumesSources(ctx, c, cs.Driver.GetName())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	start := 0
+	if token.Source != "" {
+		start = slices.IndexFunc(sources, func(s *listVolumesSource) bool {
+			return s.key() == token.Source
+		})
+		if start == -1 {
+			return nil, status.Errorf(codes.Aborted, "source %q of starting_token does not exist", token.Source)
+		}
+	}
+
+	nodes := getNodesByAddress(ctx, c)
+
+	maxEntries := cs.ListLimits.MaxEntriesFor(req.GetMaxEntries())
+	budget := cs.ListLimits.NewResponseBudget()
+	entries := []*csi.ListVolumesResponse_Entry{}
+	for i := start; i < len(sources); i++ {
+		after := ""
+		if i == start {
+			after = token.After
+		}
+
+		remaining := int64(0)
+		if maxEntries != 0 {
+			remaining = maxEntries - int64(len(entries))
+		}
+
+		var (
+			found []*csi.ListVolumesResponse_Entry
+			last  string
+			more  bool
+		)
+		found, last, more, err = cs.listSourceVolumes(ctx, c, sources[i], after, remaining, nodes, budget)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to list volumes in pool %q of cluster %q: %v",
+				sources[i].JournalPool, sources[i].ClusterID, err)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		entries = append(entries, found...)
+
+		// the response may have fewer than max_entries entries when
+		// reservations were skipped, the next_token continues after them
+		if more {
+			next := &listVolumesToken{Source: sources[i].key(), After: last}
+			nextToken, eErr := next.encode()
+			if eErr != nil {
+				return nil, status.Error(codes.Internal, eErr.Error())
+			}
+
+			return &csi.ListVolumesResponse{
+				Entries:   entries,
+				NextToken: nextToken,
+			}, nil
+		}
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries: entries,
+	}, nil
+}
+
+// listVolumesConnection is a connection to the journal of a
+// listVolumesSource.
+type listVolumesConnection struct {
+	source         *listVolumesSource
+	journal        *journal.Connection
+	cr             *util.Credentials
+	monitors       string
+	radosNamespace string
+}
+
+// connectListVolumesSource connects to the journal of the source, with the
+// credentials from the secret of the StorageClass.
+func connectListVolumesSource(
+	ctx context.Context,
+	c *k8s.Clientset,
+	source *listVolumesSource,
+) (*listVolumesConnection, error) {
+	secrets, err := getSecret(c, source.SecretNamespace, source.SecretName)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := &listVolumesConnection{source: source}
+	lc.cr, err = util.NewUserCredentials(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user credentials from secret %s/%s: %w",
+			source.SecretNamespace, source.SecretName, err)
+	}
+	defer func() {
+		if err != nil {
+			lc.cr.DeleteCredentials()
+		}
+	}()
+
+	lc.monitors, err = util.Mons(util.CsiConfigFile, source.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monitors of cluster %q: %w", source.ClusterID, err)
+	}
+
+	lc.radosNamespace, err = util.GetRBDRadosNamespace(util.CsiConfigFile, source.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get RADOS namespace of cluster %q: %w", source.ClusterID, err)
+	}
+
+	lc.journal, err = volJournal(ctx).Connect(lc.monitors, lc.radosNamespace, lc.cr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the journal of cluster %q: %w", source.ClusterID, err)
+	}
+
+	return lc, nil
+}
+
+// Destroy closes the connection to the journal.
+func (lc *listVolumesConnection) Destroy() {
+	lc.journal.Destroy()
+	lc.cr.DeleteCredentials()
+}
+
+// prefetchReservations reads the journal of the images of the reservations
+// in a batch per pool, and returns the context to resolve them with.
+func (lc *listVolumesConnection) prefetchReservations(
+	ctx context.Context,
+	reservations []journal.Reservation,
+) context.Context {
+	uuids := map[int64][]string{}
+	for _, r := range reservations {
+		uuids[r.ImagePoolID] = append(uuids[r.ImagePoolID], r.ImageUUID)
+	}
+
+	for poolID, objectUUIDs := range uuids {
+		imagePool := lc.source.JournalPool
+		if poolID != util.InvalidPoolID {
+			var err error
+			imagePool, err = util.GetPoolName(lc.monitors, lc.cr, poolID)
+			if err != nil {
+				// reported when the images are opened
+				continue
+			}
+		}
+
+		attrs, err := lc.journal.GetImageAttributesBatch(ctx, imagePool, objectUUIDs, false)
+		if err != nil {
+			log.WarningLog(ctx, "failed to prefetch journal of %d images in pool %q: %v",
+				len(objectUUIDs), imagePool, err)
+
+			continue
+		}
+		ctx = lc.journal.WithImageAttributes(ctx, imagePool, attrs, false)
+	}
+
+	return ctx
+}
+
+// openReservedImage opens the image of a reservation. The image pool is
+// returned, together with the opened image and the journal attributes.
+func (lc *listVolumesConnection) openReservedImage(
+	ctx context.Context,
+	r journal.Reservation,
+) (string, *rbdImage, *librbd.Image, *journal.ImageAttributes, error) {
+	var err error
+
+	imagePool := lc.source.JournalPool
+	if r.ImagePoolID != util.InvalidPoolID {
+		imagePool, err = util.GetPoolName(lc.monitors, lc.cr, r.ImagePoolID)
+		if err != nil {
+			return "", nil, nil, nil, fmt.Errorf("failed to get name of pool %d: %w", r.ImagePoolID, err)
+		}
+	}
+
+	attrs, err := lc.journal.GetImageAttributes(ctx, imagePool, r.ImageUUID, false)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	if attrs.RequestName != r.RequestName {
+		return "", nil, nil, nil, fmt.Errorf("%w: reservation %q points to image of request %q",
+			util.ErrKeyNotFound, r.RequestName, attrs.RequestName)
+	}
+
+	ri := &rbdImage{
+		Monitors:       lc.monitors,
+		Pool:           imagePool,
+		RadosNamespace: lc.radosNamespace,
+		RbdImageName:   attrs.ImageName,
+		ClusterID:      lc.source.ClusterID,
+	}
+	err = ri.Connect(lc.cr)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+
+	image, err := ri.open()
+	if err != nil {
+		ri.Destroy(ctx)
+
+		return "", nil, nil, nil, err
+	}
+
+	return imagePool, ri, image, attrs, nil
+}
+
+// isMissingReservedImage returns true when the error indicates that the image
+// of a reservation does not exist. Such a reservation is not a volume (yet),
+// it gets cleaned up by the CreateVolume or DeleteVolume request that is
+// retried for it.
+func isMissingReservedImage(err error) bool {
+	return errors.Is(err, ErrImageNotFound) || errors.Is(err, util.ErrKeyNotFound)
+}
+
+// hasMoreReservations returns true when the journal may have reservations
+// after a listing of up to maxEntries that returned count reservations.
+func hasMoreReservations(count int, maxEntries int64) bool {
+	return maxEntries != 0 && int64(count) >= maxEntries
+}
+
+// listSourceVolumes lists the volumes of up to maxEntries reservations from
+// the journal of the source, starting after the request name in after. It
+// returns the request name of the last reservation that was processed, or an
+// empty string when the journal has no reservations left. more is returned
+// as true when the journal returned maxEntries reservations, including the
+// ones that were skipped as their image is missing, or when an entry does not
+// fit in the budget of the response and the listing stopped before it.
+func (cs *ControllerServer) listSourceVolumes(
+	ctx context.Context,
+	c *k8s.Clientset,
+	source *listVolumesSource,
+	after string,
+	maxEntries int64,
+	nodes map[string]string,
+	budget *csicommon.ResponseBudget,
+) ([]*csi.ListVolumesResponse_Entry, string, bool, error) {
+	lc, err := connectListVolumesSource(ctx, c, source)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, source.JournalPool, after, maxEntries)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(reservations) == 0 {
+		return nil, "", false, nil
+	}
+
+	ctx = lc.prefetchReservations(ctx, reservations)
+
+	more := hasMoreReservations(len(reservations), maxEntries)
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(reservations))
+	last := after
+	for _, r := range reservations {
+		entry, lErr := lc.getListVolumesEntry(ctx, r, nodes)
+		if lErr != nil {
+			if !isMissingReservedImage(lErr) {
+				return nil, "", false, lErr
+			}
+			log.DebugLog(ctx, "skipping reservation %q in pool %q: %v", r.RequestName, source.JournalPool, lErr)
+			last = r.RequestName
+
+			continue
+		}
+
+		if !budget.Add(entry) {
+			log.DebugLog(ctx, "response is full after %d volumes of pool %q, returning a next_token",
+				len(entries), source.JournalPool)
+
+			return entries, last, true, nil
+		}
+
+		entries = append(entries, entry)
+		last = r.RequestName
+	}
+
+	return entries, last, more, nil
+}
+
+// getListVolumesEntry returns the ListVolumes entry for a single reservation.
+func (lc *listVolumesConnection) getListVolumesEntry(
+	ctx context.Context,
+	r journal.Reservation,
+	nodes map[string]string,
+) (*csi.ListVolumesResponse_Entry, error) {
+	imagePool, ri, image, _, err := lc.openReservedImage(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer ri.Destroy(ctx)
+	defer image.Close()
+
+	volID, err := util.GenerateVolID(ctx, lc.monitors, lc.cr, r.ImagePoolID, imagePool,
+		lc.source.ClusterID, r.ImageUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := image.GetSize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get size of image %s: %w", ri, err)
+	}
+
+	entry := &csi.ListVolumesResponse_Entry{
+		Volume: &csi.Volume{
+			VolumeId:      volID,
+			CapacityBytes: int64(size),
+		},
+		Status: &csi.ListVolumesResponse_VolumeStatus{},
+	}
+
+	watchers, err := image.ListWatchers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watchers of image %s: %w", ri, err)
+	}
+	for _, w := range watchers {
+		node, ok := nodes[watcherIP(w.Addr)]
+		if ok && !slices.Contains(entry.Status.PublishedNodeIds, node) {
+			entry.Status.PublishedNodeIds = append(entry.Status.PublishedNodeIds, node)
+		}
+	}
+
+	return entry, nil
+}
+
+// getListVolumesSources returns the journal pools of the StorageClasses that
+// use the driver, sorted so that the listing is stable across requests.
+func getListVolumesSources(
+	ctx context.Context,
+	c *k8s.Clientset,
+	driverName string,
+) ([]*listVolumesSource, error) {
+	scs, err := c.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StorageClasses: %w", err)
+	}
+
+	sources := []*listVolumesSource{}
+	for i := range scs.Items {
+		sc := &scs.Items[i]
+		if sc.Provisioner != driverName {
+			continue
+		}
+
+		source := &listVolumesSource{
+			ClusterID:       sc.Parameters["clusterID"],
+			JournalPool:     sc.Parameters["journalPool"],
+			SecretName:      sc.Parameters[provisionerSecretNameKey],
+			SecretNamespace: sc.Parameters[provisionerSecretNamespaceKey],
+		}
+		if source.JournalPool == "" {
+			source.JournalPool = sc.Parameters["pool"]
+		}
+		if source.ClusterID == "" || source.JournalPool == "" || source.SecretName == "" {
+			log.WarningLog(ctx, "skipping StorageClass %q for listing volumes, it has no clusterID, pool or secret",
+				sc.Name)
+
+			continue
+		}
+
+		if !slices.ContainsFunc(sources, func(s *listVolumesSource) bool {
+			return s.key() == source.key()
+		}) {
+			sources = append(sources, source)
+		}
+	}
+
+	slices.SortFunc(sources, func(a, b *listVolumesSource) int {
+		return strings.Compare(a.key(), b.key())
+	})
+
+	return sources, nil
+}
+
+// getNodesByAddress returns the names of the Kubernetes nodes, indexed by
+// their addresses. The map is empty when the nodes could not be listed, the
+// published nodes are then not reported.
+func getNodesByAddress(ctx context.Context, c *k8s.Clientset) map[string]string {
+	nodes := map[string]string{}
+
+	nodeList, err := c.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.WarningLog(ctx, "failed to list nodes, published nodes will not be reported: %v", err)
+
+		return nodes
+	}
+
+	for i := range nodeList.Items {
+		for _, addr := range nodeList.Items[i].Status.Addresses {
+			nodes[addr.Address] = nodeList.Items[i].Name
+		}
+	}
+
+	return nodes
+}
+
+// watcherIP returns the IP address of a watcher of an image. The address of
+// a watcher is formatted like "10.0.0.1:0/3466790009", optionally with a
+// "v1:" or "v2:" messenger prefix.
+func watcherIP(addr string) string {
+	addr, _, _ = strings.Cut(addr, "/")
+	for _, prefix := range []string{"v1:", "v2:", "any:"} {
+		addr = strings.TrimPrefix(addr, prefix)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListVolumesToken(t *testing.T) {
+	t.Parallel()
+
+	token, err := parseListVolumesToken("")
+	require.NoError(t, err)
+	require.Empty(t, token.Source)
+
+	next := &listVolumesToken{Source: "cluster-1/replicapool", After: "pvc-6a0f7f9c"}
+	encoded, err := next.encode()
+	require.NoError(t, err)
+
+	token, err = parseListVolumesToken(encoded)
+	require.NoError(t, err)
+	require.Equal(t, next, token)
+
+	_, err = parseListVolumesToken("not a token")
+	require.Error(t, err)
+
+	empty := &listVolumesToken{}
+	encoded, err = empty.encode()
+	require.NoError(t, err)
+	_, err = parseListVolumesToken(encoded)
+	require.Error(t, err)
+}
+
+func TestHasMoreReservations(t *testing.T) {
+	t.Parallel()
+
+	require.False(t, hasMoreReservations(100, 0))
+	require.False(t, hasMoreReservations(3, 5))
+	// skipped reservations count, the response can have fewer entries
+	require.True(t, hasMoreReservations(5, 5))
+}
+
+func TestWatcherIP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "10.0.0.1:0/3466790009", want: "10.0.0.1"},
+		{addr: "v1:192.168.39.20:0/1234", want: "192.168.39.20"},
+		{addr: "[fd00::1]:0/42", want: "fd00::1"},
+		{addr: "10.0.0.2", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, watcherIP(tt.addr), tt.addr)
+	}
+}
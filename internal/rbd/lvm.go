@@ -0,0 +1,582 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/cloud-provider/volume/helpers"
+	mount "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// volumeLayoutParam is the StorageClass parameter that selects how the
+	// volumes are stored, volumeLayoutLVM carves them as logical volumes
+	// from backing images that are shared by many volumes.
+	volumeLayoutParam = "volumeLayout"
+	volumeLayoutImage = "image"
+	volumeLayoutLVM   = "lvm"
+
+	// lvmBackingImageSizeParam is the StorageClass parameter with the size
+	// of the backing images that are created for the lvm layout.
+	lvmBackingImageSizeParam   = "lvmBackingImageSize"
+	defaultLVMBackingImageSize = 100 * oneGB
+
+	// lvmBackingImagePrefix is the prefix of the names of the backing images,
+	// their volume group has the name of the image.
+	lvmBackingImagePrefix = "csi-lvm-"
+
+	// lvmExtentSize is the size of the physical extents of the volume groups,
+	// the logical volumes are a multiple of it. The first extent of a
+	// backing image holds the LVM metadata.
+	lvmExtentSize = 4 * helpers.MiB
+
+	// lvmConfig is passed to the LVM commands of the node. The nodeplugin
+	// creates the device nodes itself, and the extents of removed logical
+	// volumes are discarded so that new logical volumes read as zeros.
+	lvmConfig = "activation { udev_sync = 0 udev_rules = 0 } devices { issue_discards = 1 }"
+
+	// lvmPhysicalVolumeFormat is the format of a device with LVM metadata.
+	lvmPhysicalVolumeFormat = "LVM2_member"
+)
+
+// lvmAllocationLock serializes the allocations in the backing images, only
+// the elected provisioner creates and deletes volumes.
+var lvmAllocationLock sync.Mutex
+
+// logicalVolume is the logical volume of a volume with the lvm layout, that
+// the node stages from the mapped backing image.
+type logicalVolume struct {
+	// vg is the volume group of the backing image, named after the image
+	vg string
+	// name is the name of the logical volume, the image name of the volume
+	name string
+	// size of the logical volume in bytes
+	size int64
+	// allocated has the sizes of the logical volumes of the backing image,
+	// the logical volumes that are not allocated anymore are removed
+	allocated map[string]int64
+}
+
+// parseVolumeLayout returns the size of the backing images for the lvm
+// layout, or 0 for volumes that have their own image.
+func parseVolumeLayout(parameters map[string]string) (int64, error) {
+	switch layout := parameters[volumeLayoutParam]; layout {
+	case "", volumeLayoutImage:
+		if _, ok := parameters[lvmBackingImageSizeParam]; ok {
+			return 0, fmt.Errorf("%s is only supported with %s %q", lvmBackingImageSizeParam,
+				volumeLayoutParam, volumeLayoutLVM)
+		}
+
+		return 0, nil
+	case volumeLayoutLVM:
+	default:
+		return 0, fmt.Errorf("invalid %s %q, supported are %q and %q", volumeLayoutParam, layout,
+			volumeLayoutImage, volumeLayoutLVM)
+	}
+
+	value, ok := parameters[lvmBackingImageSizeParam]
+	if !ok {
+		return defaultLVMBackingImageSize, nil
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", lvmBackingImageSizeParam, value, err)
+	}
+	size := roundUpToExtent(quantity.Value())
+	if size < 2*lvmExtentSize {
+		return 0, fmt.Errorf("invalid %s %q: must be at least %d bytes", lvmBackingImageSizeParam, value,
+			2*lvmExtentSize)
+	}
+
+	return size, nil
+}
+
+// validateVolumeLayout returns an InvalidArgument error when a volume with
+// the lvm layout is requested with features that work on whole images, or
+// with an access mode for multiple nodes.
+func validateVolumeLayout(req *csi.CreateVolumeRequest) error {
+	backingImageSize, err := parseVolumeLayout(req.GetParameters())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if backingImageSize == 0 {
+		return nil
+	}
+
+	if req.GetVolumeContentSource() != nil {
+		return status.Errorf(codes.InvalidArgument, "volumes with %s %q can not have a content source",
+			volumeLayoutParam, volumeLayoutLVM)
+	}
+	for _, capability := range req.GetVolumeCapabilities() {
+		if isMultiNodeMode(capability.GetAccessMode().GetMode()) {
+			return status.Errorf(codes.InvalidArgument, "volumes with %s %q only support single node access modes",
+				volumeLayoutParam, volumeLayoutLVM)
+		}
+	}
+
+	return nil
+}
+
+// isMultiNodeMode returns true for the access modes that allow staging the
+// volume on multiple nodes.
+func isMultiNodeMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode { //nolint:exhaustive // only check what we want
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+
+	return false
+}
+
+// roundUpToExtent rounds size up to a multiple of lvmExtentSize.
+func roundUpToExtent(size int64) int64 {
+	return (size + lvmExtentSize - 1) / lvmExtentSize * lvmExtentSize
+}
+
+// lvmFreeSpace returns the bytes of a backing image of size that are not
+// allocated to the logical volumes.
+func lvmFreeSpace(size int64, allocated map[string]int64) int64 {
+	free := size - lvmExtentSize
+	for _, lvSize := range allocated {
+		free -= lvSize
+	}
+
+	return free
+}
+
+// lvmBackingImage returns the backing image name of rv, with the size and the
+// image options of rv.
+func (rv *rbdVolume) lvmBackingImage(name string, size int64) *rbdVolume {
+	bi := &rbdVolume{}
+	bi.ClusterID = rv.ClusterID
+	bi.Monitors = rv.Monitors
+	bi.Pool = rv.Pool
+	bi.JournalPool = rv.JournalPool
+	bi.RadosNamespace = rv.RadosNamespace
+	bi.DataPool = rv.DataPool
+	bi.RbdImageName = name
+	bi.VolSize = size
+	bi.ImageFeatureSet = rv.ImageFeatureSet
+	bi.StripeCount = rv.StripeCount
+	bi.StripeUnit = rv.StripeUnit
+	bi.ObjectSize = rv.ObjectSize
+	bi.ClusterName = rv.ClusterName
+
+	return bi
+}
+
+// lvmVolumeExists completes rv from the reservation in imageData, for a
+// volume with the lvm layout. A reservation without backing image is undone,
+// the provisioner stopped before the logical volume was allocated.
+func (rv *rbdVolume) lvmVolumeExists(
+	ctx context.Context,
+	j *journal.Connection,
+	imageData *journal.ImageData,
+) (bool, error) {
+	backingImage := imageData.ImageAttributes.LVMBackingImage
+	if backingImage == "" {
+		err := j.UndoReservation(ctx, rv.JournalPool, rv.Pool, rv.RbdImageName, rv.RequestName)
+		if err != nil {
+			return false, fmt.Errorf("failed to undo reservation of %s without backing image: %w", rv, err)
+		}
+
+		return false, nil
+	}
+
+	allocated, err := j.ListLVMAllocations(ctx, rv.Pool, backingImage)
+	if err != nil {
+		return false, err
+	}
+	if size, ok := allocated[rv.RbdImageName]; ok {
+		rv.VolSize = size
+	}
+	rv.LVMBackingImage = backingImage
+
+	rv.VolID, err = util.GenerateVolID(ctx, rv.Monitors, rv.conn.Creds, imageData.ImagePoolID, rv.Pool,
+		rv.ClusterID, rv.ReservedID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// createLVMVolume reserves a volume with the lvm layout, and allocates its
+// logical volume in a backing image with enough free space. A new backing
+// image is created when there is none. The nodes create the logical volume
+// when the volume is staged.
+func (cs *ControllerServer) createLVMVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+	cr *util.Credentials,
+	rbdVol *rbdVolume,
+) (*csi.CreateVolumeResponse, error) {
+	if rbdVol.isBlockEncrypted() || rbdVol.isFileEncrypted() {
+		return nil, status.Errorf(codes.InvalidArgument, "volumes with %s %q can not be encrypted",
+			volumeLayoutParam, volumeLayoutLVM)
+	}
+	rbdVol.VolSize = roundUpToExtent(rbdVol.VolSize)
+	if rbdVol.VolSize > rbdVol.lvmBackingImageSize-lvmExtentSize {
+		return nil, status.Errorf(codes.OutOfRange,
+			"size %d of volume does not fit in backing images of %d bytes, increase %s",
+			rbdVol.VolSize, rbdVol.lvmBackingImageSize, lvmBackingImageSizeParam)
+	}
+
+	lvmAllocationLock.Lock()
+	defer lvmAllocationLock.Unlock()
+
+	found, err := rbdVol.Exists(ctx, nil)
+	if err != nil {
+		return nil, getGRPCErrorForCreateVolume(err)
+	}
+
+	j, err := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer j.Destroy()
+
+	if !found {
+		var backingImage string
+		backingImage, err = rbdVol.allocateLVMBackingImage(ctx, j, cr)
+		if err != nil {
+			return nil, err
+		}
+
+		err = reserveVol(ctx, rbdVol, cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		defer func() {
+			if err != nil {
+				errDefer := undoVolReservation(ctx, rbdVol, cr)
+				if errDefer != nil {
+					log.WarningLog(ctx, "failed undoing reservation of volume: %s (%s)", req.GetName(), errDefer)
+				}
+			}
+		}()
+
+		// the backing image is stored before the allocation, a reservation
+		// without it is undone when the volume is requested again
+		rbdVol.LVMBackingImage = backingImage
+		err = j.StoreLVMBackingImage(ctx, rbdVol.JournalPool, rbdVol.ReservedID, backingImage)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// the allocation is added again for a volume that was found, in case the
+	// provisioner stopped before it was added
+	err = j.AddLVMAllocation(ctx, rbdVol.Pool, rbdVol.LVMBackingImage, rbdVol.RbdImageName, rbdVol.VolSize)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	completeVolReservation(ctx, rbdVol, cr)
+
+	log.DebugLog(ctx, "volume %s is logical volume %s of backing image %s", rbdVol.VolID, rbdVol.RbdImageName,
+		rbdVol.LVMBackingImage)
+
+	return buildCreateVolumeResponse(ctx, req, rbdVol)
+}
+
+// allocateLVMBackingImage returns the first backing image in the pool of rv
+// with enough free space for rv, or creates a new backing image. It returns
+// gRPC errors.
+func (rv *rbdVolume) allocateLVMBackingImage(
+	ctx context.Context,
+	j *journal.Connection,
+	cr *util.Credentials,
+) (string, error) {
+	backingImages, err := j.ListLVMBackingImages(ctx, rv.Pool)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	names := make([]string, 0, len(backingImages))
+	for name := range backingImages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		var allocated map[string]int64
+		allocated, err = j.ListLVMAllocations(ctx, rv.Pool, name)
+		if err != nil {
+			return "", status.Error(codes.Internal, err.Error())
+		}
+		if lvmFreeSpace(backingImages[name], allocated) >= rv.VolSize {
+			return name, nil
+		}
+	}
+
+	bi := rv.lvmBackingImage(lvmBackingImagePrefix+uuid.NewString(), rv.lvmBackingImageSize)
+	defer bi.Destroy(ctx)
+
+	releaseNamespace, err := prepareRadosNamespace(ctx, bi)
+	if err != nil {
+		return "", err
+	}
+	defer releaseNamespace()
+
+	err = createImage(ctx, bi, cr)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to create backing image %s: %v", bi, err)
+
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	// an image that is not recorded is not used for volumes, it is left
+	// behind when the provisioner stops before it is recorded
+	err = j.AddLVMBackingImage(ctx, bi.Pool, bi.RbdImageName, bi.VolSize)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	log.DebugLog(ctx, "created backing image %s of %d bytes", bi, bi.VolSize)
+
+	return bi.RbdImageName, nil
+}
+
+// deleteLVMVolume removes the allocation of the logical volume of rbdVol from
+// its backing image, and deletes the backing image when it has no logical
+// volumes anymore. The nodes remove the logical volume when the backing image
+// is staged again.
+func (cs *ControllerServer) deleteLVMVolume(
+	ctx context.Context,
+	rbdVol *rbdVolume,
+	cr *util.Credentials,
+) (*csi.DeleteVolumeResponse, error) {
+	if acquired := cs.VolumeLocks.TryAcquire(rbdVol.RequestName); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, rbdVol.RequestName)
+
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, rbdVol.RequestName)
+	}
+	defer cs.VolumeLocks.Release(rbdVol.RequestName)
+
+	lvmAllocationLock.Lock()
+	defer lvmAllocationLock.Unlock()
+
+	j, err := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer j.Destroy()
+
+	err = j.RemoveLVMAllocation(ctx, rbdVol.Pool, rbdVol.LVMBackingImage, rbdVol.RbdImageName)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	allocated, err := j.ListLVMAllocations(ctx, rbdVol.Pool, rbdVol.LVMBackingImage)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(allocated) == 0 {
+		err = deleteLVMBackingImage(ctx, j, rbdVol.lvmBackingImage(rbdVol.LVMBackingImage, 0), cr)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	err = undoVolReservation(ctx, rbdVol, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// deleteLVMBackingImage deletes the backing image bi, which has no logical
+// volumes anymore, and removes it from the journal.
+func deleteLVMBackingImage(ctx context.Context, j *journal.Connection, bi *rbdVolume, cr *util.Credentials) error {
+	err := bi.Connect(cr)
+	if err != nil {
+		return err
+	}
+	defer bi.Destroy(ctx)
+
+	log.DebugLog(ctx, "deleting backing image %s, it has no logical volumes anymore", bi)
+	err = bi.Delete(ctx)
+	if err != nil && !errors.Is(err, ErrImageNotFound) {
+		return fmt.Errorf("failed to delete backing image %s: %w", bi, err)
+	}
+
+	return j.RemoveLVMBackingImage(ctx, bi.Pool, bi.RbdImageName)
+}
+
+// genLVMVolume returns the backing image of rv, which was returned by
+// GenVolFromVolID with ErrLVMVolume, with the logical volume that is staged.
+func genLVMVolume(
+	ctx context.Context,
+	req *csi.NodeStageVolumeRequest,
+	rv *rbdVolume,
+	cr *util.Credentials,
+) (*rbdVolume, error) {
+	defer rv.Destroy(ctx)
+
+	if isMultiNodeMode(req.GetVolumeCapability().GetAccessMode().GetMode()) {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s with %s %q can only be staged on a single node",
+			req.GetVolumeId(), volumeLayoutParam, volumeLayoutLVM)
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer j.Destroy()
+
+	allocated, err := j.ListLVMAllocations(ctx, rv.Pool, rv.LVMBackingImage)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to get logical volumes of backing image %s: %v", rv.LVMBackingImage, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	size, ok := allocated[rv.RbdImageName]
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "logical volume %s of volume %s is not allocated in backing image %s",
+			rv.RbdImageName, req.GetVolumeId(), rv.LVMBackingImage)
+	}
+
+	vol := rv.lvmBackingImage(rv.LVMBackingImage, 0)
+	vol.lvm = &logicalVolume{
+		vg:        rv.LVMBackingImage,
+		name:      rv.RbdImageName,
+		size:      size,
+		allocated: allocated,
+	}
+
+	return vol, nil
+}
+
+// sharesMapping returns true when the mapping of the image is shared with
+// the other volumes that are staged from it.
+func (rv *rbdVolume) sharesMapping() bool {
+	return rv.readOnly || rv.lvm != nil
+}
+
+// lvmDevicePath returns the device-mapper device of the logical volume.
+func lvmDevicePath(vg, lv string) string {
+	// device-mapper separates the names with a dash, and doubles the
+	// dashes in the names
+	return "/dev/mapper/" + strings.ReplaceAll(vg, "-", "--") + "-" + strings.ReplaceAll(lv, "-", "--")
+}
+
+// runLVM runs the LVM command on the volume group of device, the other
+// devices of the node are not scanned.
+func runLVM(ctx context.Context, command, device string, args ...string) (string, error) {
+	args = append([]string{"--config", lvmConfig, "--devices", device}, args...)
+	stdout, stderr, err := util.ExecCommand(ctx, command, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s on %s (%w): %s", command, device, err, stderr)
+	}
+
+	return stdout, nil
+}
+
+// activateLogicalVolume creates or activates the logical volume lv in the
+// backing image that is mapped at device, and returns the device of the
+// logical volume. The volume group is created in a new backing image, and
+// the logical volumes of deleted volumes are removed.
+func activateLogicalVolume(ctx context.Context, lv *logicalVolume, device string) (string, error) {
+	format, err := (&mount.SafeFormatAndMount{Exec: utilexec.New()}).GetDiskFormat(device)
+	if err != nil {
+		return "", fmt.Errorf("failed to get format of backing image device %s: %w", device, err)
+	}
+	switch format {
+	case "":
+		_, err = runLVM(ctx, "vgcreate", device, "--physicalextentsize", fmt.Sprintf("%db", lvmExtentSize),
+			lv.vg, device)
+		if err != nil {
+			return "", err
+		}
+	case lvmPhysicalVolumeFormat:
+	default:
+		return "", fmt.Errorf("backing image device %s has format %q instead of a volume group", device, format)
+	}
+
+	stdout, err := runLVM(ctx, "lvs", device, "--noheadings", "--options", "lv_name", lv.vg)
+	if err != nil {
+		return "", err
+	}
+	existing := strings.Fields(stdout)
+
+	for _, name := range staleLogicalVolumes(existing, lv.allocated) {
+		// a logical volume that is still open is removed the next time
+		_, err = runLVM(ctx, "lvremove", device, "--yes", lv.vg+"/"+name)
+		if err != nil {
+			log.WarningLog(ctx, "failed to remove logical volume %s/%s of a deleted volume: %v", lv.vg, name, err)
+		}
+	}
+
+	if slices.Contains(existing, lv.name) {
+		_, err = runLVM(ctx, "lvchange", device, "--activate", "y", lv.vg+"/"+lv.name)
+	} else {
+		_, err = runLVM(ctx, "lvcreate", device, "--yes", "--name", lv.name, "--size", fmt.Sprintf("%db", lv.size),
+			lv.vg)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return lvmDevicePath(lv.vg, lv.name), nil
+}
+
+// staleLogicalVolumes returns the existing logical volumes that are not
+// allocated anymore.
+func staleLogicalVolumes(existing []string, allocated map[string]int64) []string {
+	stale := []string{}
+	for _, name := range existing {
+		if _, ok := allocated[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+
+	return stale
+}
+
+// deactivateLogicalVolume deactivates the logical volume lv of the volume
+// group vg in the backing image that is mapped at device.
+func deactivateLogicalVolume(ctx context.Context, device, vg, lv string) error {
+	_, err := runLVM(ctx, "lvchange", device, "--activate", "n", vg+"/"+lv)
+
+	return err
+}
+
+// deactivateVolumeGroup deactivates the volume group vg in the backing image
+// that is mapped at device, before the backing image is unmapped.
+func deactivateVolumeGroup(ctx context.Context, device, vg string) error {
+	_, err := runLVM(ctx, "vgchange", device, "--activate", "n", vg)
+
+	return err
+}
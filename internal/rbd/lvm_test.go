@@ -0,0 +1,161 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseVolumeLayout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		size       int64
+		wantErr    bool
+	}{
+		{
+			name:       "default layout",
+			parameters: map[string]string{},
+		},
+		{
+			name:       "image layout",
+			parameters: map[string]string{volumeLayoutParam: volumeLayoutImage},
+		},
+		{
+			name:       "lvm layout with default size",
+			parameters: map[string]string{volumeLayoutParam: volumeLayoutLVM},
+			size:       defaultLVMBackingImageSize,
+		},
+		{
+			name:       "lvm layout with size",
+			parameters: map[string]string{volumeLayoutParam: volumeLayoutLVM, lvmBackingImageSizeParam: "10Gi"},
+			size:       10 * oneGB,
+		},
+		{
+			name:       "size is rounded up to an extent",
+			parameters: map[string]string{volumeLayoutParam: volumeLayoutLVM, lvmBackingImageSizeParam: "9M"},
+			size:       3 * lvmExtentSize,
+		},
+		{
+			name:       "unknown layout",
+			parameters: map[string]string{volumeLayoutParam: "zfs"},
+			wantErr:    true,
+		},
+		{
+			name:       "size without lvm layout",
+			parameters: map[string]string{lvmBackingImageSizeParam: "10Gi"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid size",
+			parameters: map[string]string{volumeLayoutParam: volumeLayoutLVM, lvmBackingImageSizeParam: "ten"},
+			wantErr:    true,
+		},
+		{
+			name:       "size without room for a logical volume",
+			parameters: map[string]string{volumeLayoutParam: volumeLayoutLVM, lvmBackingImageSizeParam: "4Mi"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			size, err := parseVolumeLayout(tt.parameters)
+			if tt.wantErr {
+				require.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.size, size)
+		})
+	}
+}
+
+func TestValidateVolumeLayout(t *testing.T) {
+	t.Parallel()
+
+	newRequest := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       "pvc-1234",
+			Parameters: map[string]string{volumeLayoutParam: volumeLayoutLVM},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			}},
+		}
+	}
+
+	require.NoError(t, validateVolumeLayout(newRequest(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)))
+
+	req := newRequest(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+	delete(req.Parameters, volumeLayoutParam)
+	require.NoError(t, validateVolumeLayout(req))
+
+	req = newRequest(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	req.VolumeContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1234"},
+		},
+	}
+	err := validateVolumeLayout(req)
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = validateVolumeLayout(newRequest(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY))
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLVMFreeSpace(t *testing.T) {
+	t.Parallel()
+
+	size := int64(10 * lvmExtentSize)
+	require.Equal(t, size-lvmExtentSize, lvmFreeSpace(size, map[string]int64{}))
+	require.Equal(t, size-4*lvmExtentSize, lvmFreeSpace(size, map[string]int64{
+		"csi-vol-1": lvmExtentSize,
+		"csi-vol-2": 2 * lvmExtentSize,
+	}))
+
+	require.Equal(t, int64(lvmExtentSize), roundUpToExtent(1))
+	require.Equal(t, int64(lvmExtentSize), roundUpToExtent(lvmExtentSize))
+	require.Equal(t, int64(2*lvmExtentSize), roundUpToExtent(lvmExtentSize+1))
+}
+
+func TestLVMDevicePath(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "/dev/mapper/csi--lvm--1234-csi--vol--5678", lvmDevicePath("csi-lvm-1234", "csi-vol-5678"))
+}
+
+func TestStaleLogicalVolumes(t *testing.T) {
+	t.Parallel()
+
+	allocated := map[string]int64{"csi-vol-1": lvmExtentSize, "csi-vol-2": lvmExtentSize}
+	require.Empty(t, staleLogicalVolumes([]string{"csi-vol-1"}, allocated))
+	require.Equal(t, []string{"csi-vol-3"}, staleLogicalVolumes([]string{"csi-vol-1", "csi-vol-3", "csi-vol-2"},
+		allocated))
+}
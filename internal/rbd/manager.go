@@ -25,6 +25,7 @@ import (
 	rbd_group "github.com/ceph/ceph-csi/internal/rbd/group"
 	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
@@ -72,7 +73,8 @@ func (mgr *rbdManager) getCredentials() (*util.Credentials, error) {
 		return mgr.creds, nil
 	}
 
-	creds, err := util.NewUserCredentials(mgr.secrets)
+	// the secrets of migrated in-tree volumes have a different format
+	creds, err := util.NewUserCredentialsWithMigration(mgr.secrets)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get credentials: %w", err)
 	}
@@ -171,23 +173,7 @@ func (mgr *rbdManager) GetVolumeByID(ctx context.Context, id string) (types.Volu
 		return nil, err
 	}
 
-	volume, err := GenVolFromVolID(ctx, id, creds, mgr.secrets)
-	if err != nil {
-		switch {
-		case errors.Is(err, ErrImageNotFound):
-			err = fmt.Errorf("volume %s not found: %w", id, err)
-
-			return nil, err
-		case errors.Is(err, util.ErrPoolNotFound):
-			err = fmt.Errorf("pool %s not found for %s: %w", volume.Pool, id, err)
-
-			return nil, err
-		default:
-			return nil, fmt.Errorf("failed to get volume from id %q: %w", id, err)
-		}
-	}
-
-	return volume, nil
+	return resolver.GetVolume(ctx, id, creds, mgr.secrets)
 }
 
 func (mgr *rbdManager) GetSnapshotByID(ctx context.Context, id string) (types.Snapshot, error) {
@@ -196,23 +182,25 @@ func (mgr *rbdManager) GetSnapshotByID(ctx context.Context, id string) (types.Sn
 		return nil, err
 	}
 
-	snapshot, err := genSnapFromSnapID(ctx, id, creds, mgr.secrets)
+	return resolver.GetSnapshot(ctx, id, creds, mgr.secrets)
+}
+
+func (mgr *rbdManager) PrefetchVolumes(ctx context.Context, ids []string) context.Context {
+	creds, err := mgr.getCredentials()
 	if err != nil {
-		switch {
-		case errors.Is(err, ErrImageNotFound):
-			err = fmt.Errorf("volume %s not found: %w", id, err)
+		return ctx
+	}
 
-			return nil, err
-		case errors.Is(err, util.ErrPoolNotFound):
-			err = fmt.Errorf("pool %s not found for %s: %w", snapshot.Pool, id, err)
+	return prefetchJournal(ctx, volJournal(ctx), ids, false, creds)
+}
 
-			return nil, err
-		default:
-			return nil, fmt.Errorf("failed to get volume from id %q: %w", id, err)
-		}
+func (mgr *rbdManager) PrefetchSnapshots(ctx context.Context, ids []string) context.Context {
+	creds, err := mgr.getCredentials()
+	if err != nil {
+		return ctx
 	}
 
-	return snapshot, nil
+	return prefetchJournal(ctx, snapJournal(ctx), ids, true, creds)
 }
 
 func (mgr *rbdManager) GetVolumeGroupByID(ctx context.Context, id string) (types.VolumeGroup, error) {
@@ -399,6 +387,21 @@ func (mgr *rbdManager) GetVolumeGroupSnapshotByName(
 	return vgs, nil
 }
 
+// snapshotNamePrefix returns the function that renders the snapshotNameTemplate
+// parameter to the name prefix of the snapshot images in the volume group
+// snapshot, or nil when the parameter is not set. The prefix is part of the
+// image name that the journal of the snapshot records.
+func (mgr *rbdManager) snapshotNamePrefix(name string) types.SnapshotNamePrefixFunc {
+	template, ok := mgr.parameters[k8s.SnapshotNameTemplateParam]
+	if !ok {
+		return nil
+	}
+
+	return func(image string, index int) (string, error) {
+		return k8s.RenderSnapshotNamePrefix(template, name, image, index)
+	}
+}
+
 func (mgr *rbdManager) CreateVolumeGroupSnapshot(
 	ctx context.Context,
 	vg types.VolumeGroup,
@@ -474,7 +477,7 @@ func (mgr *rbdManager) CreateVolumeGroupSnapshot(
 		return nil, fmt.Errorf("failed to check for existing volume group snapshot with id %q: %w", groupID, err)
 	}
 
-	snapshots, err := vg.CreateSnapshots(ctx, mgr.creds, groupID)
+	snapshots, err := vg.CreateSnapshots(ctx, mgr.creds, groupID, mgr.snapshotNamePrefix(name))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create volume group snapshot %q: %w", name, err)
 	}
@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+// MapRefs tracks the staging paths that use the mapping of an image on the
+// node. Read-only volumes that refer to the same image share a single read-only
+// mapping, it is unmapped when the last staging path releases it.
+//
+// Every image has a file in the directory of the MapRefs, named after the
+// escaped image-spec, with the JSON encoded list of staging paths. Staging
+// paths that have no image metadata stash anymore are dropped when the file is
+// read, so that a volume that was cleaned up without NodeUnstageVolume does not
+// keep the mapping forever.
+type MapRefs struct {
+	dir string
+	// locks serializes the updates of the references of an image with the
+	// mapping and unmapping of the image.
+	locks *util.VolumeLocks
+}
+
+// NewMapRefs returns MapRefs that keeps its files in dir.
+func NewMapRefs(dir string) *MapRefs {
+	return &MapRefs{
+		dir:   dir,
+		locks: util.NewVolumeLocks(),
+	}
+}
+
+// TryAcquire locks the references of the image, it returns false when another
+// operation for the image is in progress.
+func (mr *MapRefs) TryAcquire(imageSpec string) bool {
+	return mr.locks.TryAcquire(imageSpec)
+}
+
+// Release unlocks the references of the image.
+func (mr *MapRefs) Release(imageSpec string) {
+	mr.locks.Release(imageSpec)
+}
+
+// path returns the file with the references of the image.
+func (mr *MapRefs) path(imageSpec string) string {
+	return filepath.Join(mr.dir, url.PathEscape(imageSpec)+".json")
+}
+
+// read returns the staging paths that use the mapping of the image.
+func (mr *MapRefs) read(imageSpec string) ([]string, error) {
+	fPath := mr.path(imageSpec)
+	data, err := os.ReadFile(fPath) // #nosec:G304, the path is built from the image-spec
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read mapping references from %s: %w", fPath, err)
+	}
+
+	var refs []string
+	err = json.Unmarshal(data, &refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mapping references from %s: %w", fPath, err)
+	}
+
+	return slices.DeleteFunc(refs, func(stagingPath string) bool {
+		return !checkRBDImageMetadataStashExists(stagingPath)
+	}), nil
+}
+
+// write stores the staging paths that use the mapping of the image, the file
+// is removed when there are none.
+func (mr *MapRefs) write(imageSpec string, refs []string) error {
+	fPath := mr.path(imageSpec)
+	if len(refs) == 0 {
+		err := os.Remove(fPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove mapping references %s: %w", fPath, err)
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mapping references of %s: %w", imageSpec, err)
+	}
+
+	err = os.MkdirAll(mr.dir, 0o750)
+	if err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", mr.dir, err)
+	}
+
+	// write a temporary file first, a partially written file would lose
+	// all references
+	tmpPath := fPath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0o600)
+	if err == nil {
+		err = os.Rename(tmpPath, fPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write mapping references %s: %w", fPath, err)
+	}
+
+	return nil
+}
+
+// Add records that the mapping of the image is used by stagingPath, and
+// returns the number of staging paths that use it.
+func (mr *MapRefs) Add(imageSpec, stagingPath string) (int, error) {
+	refs, err := mr.read(imageSpec)
+	if err != nil {
+		return 0, err
+	}
+
+	if !slices.Contains(refs, stagingPath) {
+		refs = append(refs, stagingPath)
+	}
+
+	return len(refs), mr.write(imageSpec, refs)
+}
+
+// Remove records that the mapping of the image is not used by stagingPath
+// anymore, and returns the number of staging paths that still use it. The
+// image can be unmapped when none remain.
+func (mr *MapRefs) Remove(imageSpec, stagingPath string) (int, error) {
+	refs, err := mr.read(imageSpec)
+	if err != nil {
+		return 0, err
+	}
+
+	refs = slices.DeleteFunc(refs, func(ref string) bool {
+		return ref == stagingPath
+	})
+
+	return len(refs), mr.write(imageSpec, refs)
+}
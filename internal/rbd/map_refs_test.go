@@ -0,0 +1,96 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newStagingPath returns a staging path with an image metadata stash.
+func newStagingPath(t *testing.T, root, name string) string {
+	t.Helper()
+
+	stagingPath := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(stagingPath, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(stagingPath, stashFileName), []byte("{}"), 0o600))
+
+	return stagingPath
+}
+
+func TestMapRefs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	mr := NewMapRefs(filepath.Join(root, "refs"))
+	spec := "pool/ns/image"
+	first := newStagingPath(t, root, "first")
+	second := newStagingPath(t, root, "second")
+
+	users, err := mr.Add(spec, first)
+	require.NoError(t, err)
+	require.Equal(t, 1, users)
+
+	// adding the same staging path again is a no-op
+	users, err = mr.Add(spec, first)
+	require.NoError(t, err)
+	require.Equal(t, 1, users)
+
+	users, err = mr.Add(spec, second)
+	require.NoError(t, err)
+	require.Equal(t, 2, users)
+
+	users, err = mr.Remove(spec, first)
+	require.NoError(t, err)
+	require.Equal(t, 1, users)
+
+	users, err = mr.Remove(spec, second)
+	require.NoError(t, err)
+	require.Equal(t, 0, users)
+	require.NoFileExists(t, mr.path(spec))
+
+	// removing from an image without references succeeds
+	users, err = mr.Remove(spec, second)
+	require.NoError(t, err)
+	require.Equal(t, 0, users)
+}
+
+func TestMapRefsDropsStaleStagingPaths(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	mr := NewMapRefs(filepath.Join(root, "refs"))
+	spec := "pool/image"
+	stale := newStagingPath(t, root, "stale")
+	active := newStagingPath(t, root, "active")
+
+	_, err := mr.Add(spec, stale)
+	require.NoError(t, err)
+	_, err = mr.Add(spec, active)
+	require.NoError(t, err)
+
+	// the stash of a volume that was cleaned up without NodeUnstageVolume
+	// is gone, its reference does not keep the mapping
+	require.NoError(t, os.RemoveAll(stale))
+
+	users, err := mr.Remove(spec, active)
+	require.NoError(t, err)
+	require.Equal(t, 0, users)
+}
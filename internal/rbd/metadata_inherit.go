@@ -0,0 +1,124 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+const (
+	// inheritMetadataKeysParam is the StorageClass (or VolumeSnapshotClass)
+	// parameter with the metadata keys that are inherited by clones and
+	// restored volumes, on top of defaultInheritedMetadataKeys.
+	inheritMetadataKeysParam = "inheritMetadataKeys"
+
+	// ownerKey is the metadata key with the owner of a volume, it can be
+	// set by the admin and is inherited by default.
+	ownerKey = "csi.ceph.com/owner"
+	// inheritPrefix is the prefix of custom metadata keys that are
+	// inherited by default.
+	inheritPrefix = "csi.ceph.com/inherit/"
+	// driverMetadataPrefix is the prefix of the metadata that keeps the
+	// state of the driver, like the encryption, it is never inherited.
+	driverMetadataPrefix = "rbd.csi.ceph.com/"
+)
+
+// defaultInheritedMetadataKeys are the metadata keys that are copied from the
+// parent to a clone or a restored volume. A key that ends with "*" matches all
+// keys with that prefix.
+var defaultInheritedMetadataKeys = []string{
+	ownerKey,
+	clusterNameKey,
+	inheritPrefix + "*",
+}
+
+// parseInheritMetadataKeys returns the metadata keys of the
+// inheritMetadataKeysParam in the parameters.
+func parseInheritMetadataKeys(parameters map[string]string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(parameters[inheritMetadataKeysParam], ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// inheritedMetadata returns the metadata of the parent that matches the
+// default keys or the extra keys. The metadata that describes the parent
+// itself (like its PVC or snapshot name) is not inherited, the driver sets it
+// for the new image.
+func inheritedMetadata(parent map[string]string, extraKeys []string) map[string]string {
+	patterns := slices.Concat(defaultInheritedMetadataKeys, extraKeys)
+	own := slices.Concat(k8s.GetVolumeMetadataKeys(), k8s.GetSnapshotMetadataKeys())
+
+	inherited := map[string]string{}
+	for key, value := range parent {
+		if strings.HasPrefix(key, driverMetadataPrefix) || slices.Contains(own, key) {
+			continue
+		}
+
+		if slices.ContainsFunc(patterns, func(pattern string) bool {
+			prefix, isPrefix := strings.CutSuffix(pattern, "*")
+			if isPrefix {
+				return strings.HasPrefix(key, prefix)
+			}
+
+			return key == pattern
+		}) {
+			inherited[key] = value
+		}
+	}
+
+	return inherited
+}
+
+// inheritMetadata copies the metadata of the inheritance policy from the
+// parent image to the image. Keys that are set by the driver afterwards, like
+// the cluster name, are overwritten with the value for the image.
+func (ri *rbdImage) inheritMetadata(ctx context.Context, parent *rbdImage, extraKeys []string) error {
+	image, err := parent.open()
+	if err != nil {
+		return err
+	}
+	parentMetadata, err := image.ListMetadata()
+	image.Close()
+	if err != nil {
+		return fmt.Errorf("failed to list metadata of image %s: %w", parent, err)
+	}
+
+	inherited := inheritedMetadata(parentMetadata, extraKeys)
+	for key, value := range inherited {
+		err = ri.SetMetadata(key, value)
+		if err != nil {
+			return fmt.Errorf("failed to set inherited metadata key %q on image %s: %w", key, ri, err)
+		}
+	}
+	if len(inherited) != 0 {
+		log.DebugLog(ctx, "image %s inherited %d metadata keys from %s", ri, len(inherited), parent)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInheritMetadataKeys(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, parseInheritMetadataKeys(map[string]string{}))
+	require.Equal(t, []string{"team", "example.com/*"},
+		parseInheritMetadataKeys(map[string]string{inheritMetadataKeysParam: " team,example.com/*,,team"}))
+}
+
+func TestInheritedMetadata(t *testing.T) {
+	t.Parallel()
+
+	parent := map[string]string{
+		ownerKey:                                 "team-a",
+		clusterNameKey:                           "cluster-1",
+		inheritPrefix + "cost-center":            "1234",
+		"csi.storage.k8s.io/pvc/name":            "data",
+		"csi.storage.k8s.io/volumesnapshot/name": "snap",
+		"rbd.csi.ceph.com/encrypted":             "encrypted",
+		"example.com/tier":                       "gold",
+		"unrelated":                              "value",
+	}
+
+	require.Equal(t, map[string]string{
+		ownerKey:                      "team-a",
+		clusterNameKey:                "cluster-1",
+		inheritPrefix + "cost-center": "1234",
+	}, inheritedMetadata(parent, nil))
+
+	require.Equal(t, map[string]string{
+		ownerKey:                      "team-a",
+		clusterNameKey:                "cluster-1",
+		inheritPrefix + "cost-center": "1234",
+		"example.com/tier":            "gold",
+	}, inheritedMetadata(parent, []string{"example.com/*", "csi.storage.k8s.io/pvc/name", "rbd.csi.ceph.com/*"}))
+}
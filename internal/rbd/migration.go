@@ -19,6 +19,7 @@ package rbd
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
 	"strings"
 
 	"github.com/ceph/ceph-csi/internal/util"
@@ -90,6 +91,31 @@ func deleteMigratedVolume(ctx context.Context, parsedMigHandle *migrationVolID,
 	return err
 }
 
+// getMigratedVolume returns the volume of a migrated in-tree volume ID. The
+// image of the volume has no journal, it is looked up by the name in the
+// volume ID.
+func getMigratedVolume(ctx context.Context, volID string, cr *util.Credentials) (*rbdVolume, error) {
+	migVolID, err := parseMigrationVolID(volID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse migration volume ID %q: %w", volID, err)
+	}
+
+	rv, err := genVolFromMigVolID(ctx, migVolID, cr)
+	if err != nil {
+		return nil, err
+	}
+	rv.VolID = volID
+
+	err = rv.getImageID()
+	if err != nil {
+		rv.Destroy(ctx)
+
+		return nil, fmt.Errorf("failed to get volume from id %q: %w", volID, err)
+	}
+
+	return rv, nil
+}
+
 // genVolFromMigVolID populate rbdVol struct from the migration volID.
 func genVolFromMigVolID(ctx context.Context, migVolID *migrationVolID, cr *util.Credentials) (*rbdVolume, error) {
 	var err error
@@ -16,6 +16,7 @@ limitations under the License.
 package rbd
 
 import (
+	"context"
 	"reflect"
 	"testing"
 )
@@ -169,3 +170,15 @@ func TestParseMigrationVolID(t *testing.T) {
 		})
 	}
 }
+
+func TestRepairResyncedImageIDOfMigratedVolume(t *testing.T) {
+	t.Parallel()
+	rv := &rbdVolume{}
+	rv.VolID = "mig_mons-b7f67366bb43f32e07d8a261a7840da9_image-e0b45b52-7e09-47d3-8f1b-806995fa4412_706f6f6c5f7265706c6963615f706f6f6c" //nolint:lll // migration volID
+
+	// a migrated volume has no journal, the journal must not be connected
+	err := rv.RepairResyncedImageID(context.TODO(), true)
+	if err != nil {
+		t.Errorf("RepairResyncedImageID() error = %v", err)
+	}
+}
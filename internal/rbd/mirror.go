@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/ceph/ceph-csi/internal/rbd/group"
 	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
@@ -113,6 +114,22 @@ func (ri *rbdImage) DisableMirroring(_ context.Context, force bool) error {
 	return nil
 }
 
+// GetMirroringMode returns the mirroring mode of an image.
+func (ri *rbdImage) GetMirroringMode(_ context.Context) (librbd.ImageMirrorMode, error) {
+	image, err := ri.open()
+	if err != nil {
+		return librbd.ImageMirrorModeJournal, fmt.Errorf("failed to open image %q with error: %w", ri, err)
+	}
+	defer image.Close()
+
+	mode, err := image.GetImageMirrorMode()
+	if err != nil {
+		return mode, fmt.Errorf("failed to get mirroring mode of %q with error: %w", ri, err)
+	}
+
+	return mode, nil
+}
+
 // GetMirroringInfo gets mirroring information of an image.
 func (ri *rbdImage) GetMirroringInfo(_ context.Context) (types.MirrorInfo, error) {
 	image, err := ri.open()
@@ -222,6 +239,51 @@ func (ri *rbdImage) GetGlobalMirroringStatus(_ context.Context) (types.GlobalSta
 	return GlobalMirrorStatus{GlobalMirrorImageStatus: statusInfo}, nil
 }
 
+// GetMirrorPeers returns the mirror peers that are configured for the pool of
+// the image.
+func (ri *rbdImage) GetMirrorPeers(_ context.Context) ([]types.MirrorPeer, error) {
+	if ri.conn == nil {
+		return nil, fmt.Errorf("can not get mirror peers of unconnected image %q", ri)
+	}
+
+	return group.GetMirrorPeers(ri.conn, ri.Pool)
+}
+
+// GetPoolMirroring returns the mirror mode of the RADOS namespace of the
+// image, the site name and the peers of the pool of the image.
+func (ri *rbdImage) GetPoolMirroring(_ context.Context) (*types.PoolMirroring, error) {
+	if ri.conn == nil {
+		return nil, fmt.Errorf("can not get mirroring of pool of unconnected image %q", ri)
+	}
+
+	return group.GetPoolMirroring(ri.conn, ri.Pool, ri.RadosNamespace)
+}
+
+// EnablePoolMirroring sets the mirror mode of the RADOS namespace of the
+// image to "image", so that mirroring can be enabled per image.
+func (ri *rbdImage) EnablePoolMirroring(ctx context.Context) error {
+	if ri.conn == nil {
+		return fmt.Errorf("can not enable mirroring of pool of unconnected image %q", ri)
+	}
+
+	return group.EnablePoolMirroring(ctx, ri.conn, ri.Pool, ri.RadosNamespace)
+}
+
+// mirrorPeerDirectionString returns the direction like the rbd command
+// reports it.
+func mirrorPeerDirectionString(direction librbd.MirrorPeerDirection) string {
+	switch direction {
+	case librbd.MirrorPeerDirectionRx:
+		return "rx"
+	case librbd.MirrorPeerDirectionTx:
+		return "tx"
+	case librbd.MirrorPeerDirectionRxTx:
+		return "rx-tx"
+	}
+
+	return "unknown"
+}
+
 // ImageStatus is a wrapper around librbd.MirrorImageInfo that contains the
 // image mirror status.
 type ImageStatus struct {
@@ -300,6 +362,19 @@ func (status GlobalMirrorStatus) GetRemoteSiteStatus(ctx context.Context) (types
 	return SiteMirrorImageStatus{SiteMirrorImageStatus: ss}, err
 }
 
+// GetRemoteSitesStatus returns the SiteMirrorImageStatus of all remote sites,
+// the status of the local site is not included.
+func (status GlobalMirrorStatus) GetRemoteSitesStatus() []types.SiteStatus {
+	var siteStatuses []types.SiteStatus
+	for _, ss := range status.SiteStatuses {
+		if ss.MirrorUUID != "" {
+			siteStatuses = append(siteStatuses, SiteMirrorImageStatus{SiteMirrorImageStatus: ss})
+		}
+	}
+
+	return siteStatuses
+}
+
 // SiteMirrorImageStatus is a wrapper around librbd.SiteMirrorImageStatus that contains the
 // site mirror image status.
 type SiteMirrorImageStatus struct {
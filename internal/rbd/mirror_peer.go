@@ -0,0 +1,124 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+// CreateMirrorPeerBootstrapToken returns a token that can be imported in the
+// pool of another cluster with ImportMirrorPeerBootstrapToken, to add the
+// pool of the cluster with clusterID as mirror peer. Creating the token also
+// creates the Ceph user that the rbd-mirror daemon of the peer uses.
+func CreateMirrorPeerBootstrapToken(
+	ctx context.Context,
+	clusterID, pool string,
+	cr *util.Credentials,
+) (string, error) {
+	conn, err := connectCluster(clusterID, cr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Destroy()
+
+	// peers are configured on the pool, not on a RADOS namespace
+	ioctx, err := conn.GetIoctx(pool)
+	if err != nil {
+		return "", fmt.Errorf("failed to open pool %q in cluster %q: %w", pool, clusterID, err)
+	}
+	defer ioctx.Destroy()
+
+	token, err := librbd.CreateMirrorPeerBootstrapToken(ioctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create mirror peer bootstrap token for pool %q: %w", pool, err)
+	}
+	log.DebugLog(ctx, "created mirror peer bootstrap token for pool %q in cluster %q", pool, clusterID)
+
+	return token, nil
+}
+
+// ImportMirrorPeerBootstrapToken adds the cluster of the token, that was
+// created with CreateMirrorPeerBootstrapToken, as mirror peer of the pool in
+// the cluster with clusterID. The direction is "rx-only" or "rx-tx", an
+// empty direction defaults to "rx-tx".
+func ImportMirrorPeerBootstrapToken(
+	ctx context.Context,
+	clusterID, pool, direction, token string,
+	cr *util.Credentials,
+) error {
+	peerDirection, err := parseMirrorPeerDirection(direction)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connectCluster(clusterID, cr)
+	if err != nil {
+		return err
+	}
+	defer conn.Destroy()
+
+	ioctx, err := conn.GetIoctx(pool)
+	if err != nil {
+		return fmt.Errorf("failed to open pool %q in cluster %q: %w", pool, clusterID, err)
+	}
+	defer ioctx.Destroy()
+
+	err = librbd.ImportMirrorPeerBootstrapToken(ioctx, peerDirection, token)
+	if err != nil {
+		return fmt.Errorf("failed to import mirror peer bootstrap token for pool %q: %w", pool, err)
+	}
+	log.DebugLog(ctx, "imported mirror peer bootstrap token for pool %q in cluster %q (%s)",
+		pool, clusterID, mirrorPeerDirectionString(peerDirection))
+
+	return nil
+}
+
+// connectCluster returns a connection to the cluster with clusterID.
+func connectCluster(clusterID string, cr *util.Credentials) (*util.ClusterConnection, error) {
+	monitors, err := util.Mons(util.CsiConfigFile, clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get monitors of cluster %q: %w", clusterID, err)
+	}
+
+	conn := &util.ClusterConnection{}
+	err = conn.Connect(monitors, cr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cluster %q: %w", clusterID, err)
+	}
+
+	return conn, nil
+}
+
+// parseMirrorPeerDirection returns the direction for an import of a
+// bootstrap token, the names match the --direction option of
+// `rbd mirror pool peer bootstrap import`.
+func parseMirrorPeerDirection(direction string) (librbd.MirrorPeerDirection, error) {
+	switch direction {
+	case "", "rx-tx":
+		return librbd.MirrorPeerDirectionRxTx, nil
+	case "rx-only":
+		return librbd.MirrorPeerDirectionRx, nil
+	}
+
+	return 0, fmt.Errorf("invalid mirror peer direction %q, expected rx-only or rx-tx", direction)
+}
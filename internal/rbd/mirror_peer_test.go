@@ -0,0 +1,44 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMirrorPeerDirection(t *testing.T) {
+	t.Parallel()
+
+	direction, err := parseMirrorPeerDirection("")
+	require.NoError(t, err)
+	require.Equal(t, librbd.MirrorPeerDirectionRxTx, direction)
+
+	direction, err = parseMirrorPeerDirection("rx-tx")
+	require.NoError(t, err)
+	require.Equal(t, librbd.MirrorPeerDirectionRxTx, direction)
+
+	direction, err = parseMirrorPeerDirection("rx-only")
+	require.NoError(t, err)
+	require.Equal(t, librbd.MirrorPeerDirectionRx, direction)
+
+	// tx-only is not supported by rbd_mirror_peer_bootstrap_import
+	_, err = parseMirrorPeerDirection("tx-only")
+	require.Error(t, err)
+}
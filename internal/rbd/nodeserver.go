@@ -51,6 +51,32 @@ type NodeServer struct {
 	ext4HasPrezeroedSupport featureFlag
 	// xfsHasReflinkSupport indicates whether the xfs filesystem has support for reflink.
 	xfsHasReflinkSupport featureFlag
+
+	// IDMappedMounts is set when the VolumeMountGroup of a volume should be
+	// applied with an ID-mapped bind mount, instead of having the CO change
+	// the ownership of all files in the volume.
+	IDMappedMounts bool
+
+	// ForceUnstage enables the escalation to a forced and a lazy umount,
+	// and a forced unmap in NodeUnstageVolume, when the volume can not be
+	// released normally.
+	ForceUnstage bool
+
+	// MapRefs tracks the staging paths that share the read-only mapping of
+	// an image, the image is only unmapped by the last NodeUnstageVolume.
+	MapRefs *MapRefs
+
+	// ReadAheadKB is the readahead that is set on the device of a volume
+	// without readAheadKB parameter, 0 keeps the default of the kernel.
+	ReadAheadKB uint
+
+	// StatsCache keeps the stats of the published filesystem volumes for
+	// NodeGetVolumeStats, nil disables the cache.
+	StatsCache *csicommon.VolumeStatsCache
+
+	// EphemeralVolumes tracks the inline ephemeral volumes that are
+	// published on the node, nil disables ephemeral volumes.
+	EphemeralVolumes *EphemeralVolumes
 }
 
 // stageTransaction struct represents the state a transaction was when it either completed
@@ -65,6 +91,9 @@ type stageTransaction struct {
 	isBlockEncrypted bool
 	// devicePath represents the path where rbd device is mapped
 	devicePath string
+	// lvPath is the device of the logical volume of a volume with the lvm
+	// layout, which is staged instead of the rbd device
+	lvPath string
 }
 
 // featureFlag represents a type for defining feature flags within the RBD node server.
@@ -199,6 +228,18 @@ func (ns *NodeServer) populateRbdVol(
 		rv.RbdImageName = volID
 	} else {
 		rv, err = GenVolFromVolID(ctx, volID, cr, req.GetSecrets())
+		if errors.Is(err, ErrSnapshotBackedVolume) {
+			rv, err = genSnapshotBackedVolume(ctx, req, rv, cr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if errors.Is(err, ErrLVMVolume) {
+			rv, err = genLVMVolume(ctx, req, rv, cr)
+			if err != nil {
+				return nil, err
+			}
+		}
 		if err != nil {
 			rv.Destroy(ctx)
 			log.ErrorLog(ctx, "error generating volume %s: %v", volID, err)
@@ -258,6 +299,13 @@ func (ns *NodeServer) populateRbdVol(
 		rv.Mounter = rbdNbdMounter
 	}
 
+	err = checkNodeCapabilities(rv)
+	if err != nil {
+		log.ErrorLog(ctx, "volume %s can not be staged on this node: %v", volID, err)
+
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
 	err = ns.getMapOptions(req, rv)
 	if err != nil {
 		return nil, err
@@ -277,6 +325,27 @@ func (ns *NodeServer) populateRbdVol(
 	return rv, err
 }
 
+// checkNodeCapabilities verifies that the features the volume depends on
+// were detected on the node, so that staging fails before the image is
+// mapped.
+func checkNodeCapabilities(rv *rbdVolume) error {
+	nodeCaps := util.GetNodeCapabilities()
+
+	if rv.Mounter == rbdNbdMounter {
+		if err := nodeCaps.CheckSupported(util.NBDCapability); err != nil {
+			return fmt.Errorf("mounter %q is not available: %w", rbdNbdMounter, err)
+		}
+	}
+
+	if rv.isBlockEncrypted() {
+		if err := nodeCaps.CheckSupported(util.CryptsetupCapability); err != nil {
+			return fmt.Errorf("encrypted volume can not be opened: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // appendReadAffinityMapOptions appends readAffinityMapOptions to mapOptions
 // if mounter is rbdDefaultMounter and readAffinityMapOptions is not empty.
 func (rv *rbdVolume) appendReadAffinityMapOptions(readAffinityMapOptions string) {
@@ -362,6 +431,19 @@ func (ns *NodeServer) NodeStageVolume(
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	// read-only volumes of the same image and the logical volumes of a
+	// backing image share the mapping, the references to it are updated
+	// while the image is mapped or unmapped
+	if (isReadOnlyStage(req) || rv.lvm != nil) && ns.MapRefs != nil {
+		imageSpec := rv.mapSpec()
+		if acquired := ns.MapRefs.TryAcquire(imageSpec); !acquired {
+			log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, imageSpec)
+
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, imageSpec)
+		}
+		defer ns.MapRefs.Release(imageSpec)
+	}
+
 	// Stash image details prior to mapping the image (useful during Unstage as it has no
 	// voloptions passed to the RPC as per the CSI spec)
 	err = stashRBDImageMetadata(rv, stagingParentPath)
@@ -399,10 +481,13 @@ func (ns *NodeServer) stageTransaction(
 ) (*stageTransaction, error) {
 	transaction := &stageTransaction{}
 
-	var err error
+	readAheadKB, err := util.GetReadAheadKB(req.GetVolumeContext(), ns.ReadAheadKB)
+	if err != nil {
+		return transaction, err
+	}
 
 	// Allow image to be mounted on multiple nodes if it is ROX
-	if req.GetVolumeCapability().GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
+	if isReadOnlyStage(req) {
 		log.ExtendedLog(ctx, "setting disableInUseChecks on rbd volume to: %v", req.GetVolumeId)
 		volOptions.DisableInUseChecks = true
 		volOptions.readOnly = true
@@ -421,6 +506,16 @@ func (ns *NodeServer) stageTransaction(
 	}
 	transaction.devicePath = devicePath
 
+	if volOptions.sharesMapping() && ns.MapRefs != nil {
+		var users int
+		users, err = ns.MapRefs.Add(volOptions.mapSpec(), req.GetStagingTargetPath())
+		if err != nil {
+			return transaction, err
+		}
+		log.DebugLog(ctx, "rbd: shared mapping %s of image %s is used by %d staging paths",
+			devicePath, volOptions, users)
+	}
+
 	log.DebugLog(ctx, "rbd image: %s was successfully mapped at %s\n",
 		volOptions, devicePath)
 
@@ -435,6 +530,15 @@ func (ns *NodeServer) stageTransaction(
 		}
 	}
 
+	if volOptions.lvm != nil {
+		devicePath, err = activateLogicalVolume(ctx, volOptions.lvm, devicePath)
+		if err != nil {
+			return transaction, err
+		}
+		transaction.lvPath = devicePath
+		log.DebugLog(ctx, "rbd: activated logical volume %s of image %s", devicePath, volOptions)
+	}
+
 	if volOptions.isBlockEncrypted() {
 		devicePath, err = ns.processEncryptedDevice(ctx, volOptions, devicePath)
 		if err != nil {
@@ -443,6 +547,16 @@ func (ns *NodeServer) stageTransaction(
 		transaction.isBlockEncrypted = true
 	}
 
+	// the filesystem reads ahead on the device it is mounted from, that is
+	// the dm-crypt device for encrypted volumes
+	if readAheadKB != 0 {
+		err = util.SetDeviceReadAheadKB(devicePath, readAheadKB)
+		if err != nil {
+			return transaction, err
+		}
+		log.DebugLog(ctx, "rbd: set readahead of %s to %d KiB", devicePath, readAheadKB)
+	}
+
 	if volOptions.isFileEncrypted() {
 		if err = fscrypt.InitializeNode(ctx); err != nil {
 			return transaction, fmt.Errorf("file encryption setup for %s failed: %w", volOptions.VolID, err)
@@ -484,9 +598,44 @@ func (ns *NodeServer) stageTransaction(
 		return transaction, err
 	}
 
+	err = ns.setVolumeMountGroupPermissions(ctx, req, stagingTargetPath)
+	if err != nil {
+		return transaction, err
+	}
+
 	return transaction, err
 }
 
+// setVolumeMountGroupPermissions gives the group of the ID-mapped mounts
+// write access to the staged filesystem. The CO does not change the
+// ownership of the volume for the fsGroup of a pod when the VolumeMountGroup
+// is applied by the driver.
+func (ns *NodeServer) setVolumeMountGroupPermissions(
+	ctx context.Context,
+	req *csi.NodeStageVolumeRequest,
+	stagingTargetPath string,
+) error {
+	volCap := req.GetVolumeCapability()
+	if !ns.IDMappedMounts || volCap.GetMount().GetVolumeMountGroup() == "" {
+		return nil
+	}
+
+	mode := volCap.GetAccessMode().GetMode()
+	if mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		csicommon.MountOptionContains(volCap.GetMount().GetMountFlags(), "ro") {
+		return nil
+	}
+
+	err := util.SetGroupIDMapPermissions(stagingTargetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to set permissions for the volume mount group: %v", err)
+	}
+	log.DebugLog(ctx, "rbd: set permissions for the volume mount group in %s", stagingTargetPath)
+
+	return nil
+}
+
 // resizeNodeStagePath resizes the device if its encrypted and it also resizes
 // the stagingTargetPath if filesystem needs resize.
 func resizeNodeStagePath(ctx context.Context,
@@ -497,6 +646,9 @@ func resizeNodeStagePath(ctx context.Context,
 ) error {
 	var err error
 	devicePath := transaction.devicePath
+	if transaction.lvPath != "" {
+		devicePath = transaction.lvPath
+	}
 	var ok bool
 
 	// if its a non encrypted block device we dont need any expansion
@@ -602,7 +754,8 @@ func flattenImageBeforeMapping(
 			return err
 		}
 		if feature || depth != 0 {
-			err = volOptions.flattenRbdImage(ctx, true, rbdHardMaxCloneDepth, rbdSoftMaxCloneDepth)
+			policy := volOptions.getFlattenPolicy()
+			err = volOptions.flattenRbdImage(ctx, true, policy.HardMaxCloneDepth, policy.SoftMaxCloneDepth)
 			if err != nil {
 				return err
 			}
@@ -642,8 +795,28 @@ func (ns *NodeServer) undoStagingTransaction(
 
 	volID := req.GetVolumeId()
 
-	// Unmapping rbd device
-	if transaction.devicePath != "" {
+	if transaction.lvPath != "" {
+		err = deactivateLogicalVolume(ctx, transaction.devicePath, volOptions.lvm.vg, volOptions.lvm.name)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to deactivate logical volume %s: %v", transaction.lvPath, err)
+		}
+	}
+
+	// Unmapping rbd device, unless other staging paths share the mapping
+	shared := false
+	if volOptions.sharesMapping() && transaction.devicePath != "" {
+		shared, err = ns.releaseMapping(ctx, volOptions.mapSpec(), req.GetStagingTargetPath())
+		if err != nil {
+			log.ErrorLog(ctx, "failed to release mapping of image %s, not unmapping it: %v", volOptions, err)
+		}
+	}
+	if volOptions.lvm != nil && transaction.devicePath != "" && !shared {
+		err = deactivateVolumeGroup(ctx, transaction.devicePath, volOptions.lvm.vg)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to deactivate volume group %s: %v", volOptions.lvm.vg, err)
+		}
+	}
+	if transaction.devicePath != "" && !shared {
 		err = detachRBDDevice(ctx, transaction.devicePath, volID, volOptions.UnmapOptions, transaction.isBlockEncrypted)
 		if err != nil {
 			log.ErrorLog(
@@ -701,6 +874,10 @@ func (ns *NodeServer) NodePublishVolume(
 	ctx context.Context,
 	req *csi.NodePublishVolumeRequest,
 ) (*csi.NodePublishVolumeResponse, error) {
+	if isEphemeralVolume(req.GetVolumeContext()) {
+		return ns.publishEphemeralVolume(ctx, req)
+	}
+
 	err := util.ValidateNodePublishVolumeRequest(req)
 	if err != nil {
 		return nil, err
@@ -861,7 +1038,12 @@ func (ns *NodeServer) mountVolume(ctx context.Context, stagingPath string, req *
 	isBlock := req.GetVolumeCapability().GetBlock() != nil
 	targetPath := req.GetTargetPath()
 
-	mountOptions = csicommon.ConstructMountOptions(mountOptions, req.GetVolumeCapability())
+	mountOptions = csicommon.ConstructBindMountOptions(mountOptions, req.GetVolumeCapability())
+
+	idMapOption, err := ns.getIDMapMountOption(req.GetVolumeCapability())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	log.DebugLog(ctx, "target %v\nisBlock %v\nfstype %v\nstagingPath %v\nreadonly %v\nmountflags %v\n",
 		targetPath, isBlock, fsType, stagingPath, readOnly, mountOptions)
@@ -869,6 +1051,18 @@ func (ns *NodeServer) mountVolume(ctx context.Context, stagingPath string, req *
 	if readOnly {
 		mountOptions = append(mountOptions, "ro")
 	}
+	if idMapOption != "" {
+		// the bind mount, ID-mapping and read-only flag need to be applied
+		// with a single mount(8) call, mount-utils would split them up
+		mountOptions = append(mountOptions, idMapOption)
+		_, stderr, mErr := util.ExecMountCommand(ctx, "", "mount", "-o", strings.Join(mountOptions, ","),
+			stagingPath, targetPath)
+		if mErr != nil {
+			return status.Errorf(codes.Internal, "failed to create ID-mapped mount: %v, stderr: %s", mErr, stderr)
+		}
+
+		return nil
+	}
 	if err := util.Mount(ns.Mounter, stagingPath, targetPath, fsType, mountOptions); err != nil {
 		return status.Error(codes.Internal, err.Error())
 	}
@@ -876,6 +1070,26 @@ func (ns *NodeServer) mountVolume(ctx context.Context, stagingPath string, req *
 	return nil
 }
 
+// getIDMapMountOption returns the mount option to present the files in the
+// volume as owned by the VolumeMountGroup. An empty string is returned when no
+// ID-mapped mount is needed.
+func (ns *NodeServer) getIDMapMountOption(volCap *csi.VolumeCapability) (string, error) {
+	group := volCap.GetMount().GetVolumeMountGroup()
+	if !ns.IDMappedMounts || group == "" {
+		return "", nil
+	}
+
+	gid, err := strconv.ParseUint(group, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid volume mount group %q: %w", group, err)
+	}
+	if gid == 0 {
+		return "", nil
+	}
+
+	return util.GroupIDMapMountOption(uint32(gid)), nil
+}
+
 func (ns *NodeServer) createTargetMountPath(ctx context.Context, mountPath string, isBlock bool) (bool, error) {
 	// Check if that mount path exists properly
 	notMnt, err := ns.Mounter.IsLikelyNotMountPoint(mountPath)
@@ -928,6 +1142,12 @@ func (ns *NodeServer) NodeUnpublishVolume(
 	}
 	defer ns.VolumeLocks.Release(targetPath)
 
+	ns.StatsCache.Forget(targetPath)
+
+	if ns.EphemeralVolumes.isEphemeral(req.GetVolumeId()) {
+		return ns.unpublishEphemeralVolume(ctx, req)
+	}
+
 	isMnt, err := ns.Mounter.IsMountPoint(targetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -992,24 +1212,38 @@ func (ns *NodeServer) NodeUnstageVolume(
 	}
 	defer ns.VolumeLocks.Release(volID)
 
+	// the passphrase is fetched from the KMS again when the volume is staged
+	util.InvalidatePassphrase(volID)
+
 	stagingParentPath := req.GetStagingTargetPath()
 	stagingTargetPath := getStagingTargetPath(req)
+	report := &util.CleanupReport{}
 
 	isMnt, err := ns.Mounter.IsMountPoint(stagingTargetPath)
 	if err != nil {
-		if !os.IsNotExist(err) {
+		switch {
+		case os.IsNotExist(err):
+			// Continue on ENOENT errors as we may still have the image mapped
+			isMnt = false
+		case ns.ForceUnstage && util.IsCorruptedMountError(err):
+			// a hung or disconnected mount needs to be unmounted too
+			log.WarningLog(ctx, "detected corrupted mount in staging path %s: %v", stagingTargetPath, err)
+			isMnt = true
+		default:
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
-		// Continue on ENOENT errors as we may still have the image mapped
-		isMnt = false
 	}
 	if isMnt {
 		// Unmounting the image
-		err = ns.Mounter.Unmount(stagingTargetPath)
+		if ns.ForceUnstage {
+			err = util.ForceUnmount(ctx, stagingTargetPath, report)
+		} else {
+			err = ns.Mounter.Unmount(stagingTargetPath)
+		}
 		if err != nil {
 			log.ExtendedLog(ctx, "failed to unmount targetPath: %s with error: %v", stagingTargetPath, err)
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, status.Error(codes.Internal, cleanupError(err, report))
 		}
 		log.DebugLog(ctx, "successfully unmounted volume (%s) from staging path (%s)",
 			req.GetVolumeId(), stagingTargetPath)
@@ -1048,17 +1282,68 @@ func (ns *NodeServer) NodeUnstageVolume(
 	// Unmapping rbd device
 	imageSpec := imgInfo.String()
 
+	if ns.MapRefs != nil {
+		if acquired := ns.MapRefs.TryAcquire(imageSpec); !acquired {
+			log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, imageSpec)
+
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, imageSpec)
+		}
+		defer ns.MapRefs.Release(imageSpec)
+	}
+
+	// the backing image of a logical volume is only mapped while it is
+	// staged, the logical volume is inactive once it is unmapped
+	lvmDevice := ""
+	if imgInfo.LogicalVolume != "" {
+		lvmDevice, _ = findDeviceMappingImage(ctx, imgInfo.Pool, imgInfo.RadosNamespace, imgInfo.ImageName,
+			imgInfo.NbdAccess)
+	}
+	if lvmDevice != "" {
+		err = deactivateLogicalVolume(ctx, lvmDevice, imgInfo.ImageName, imgInfo.LogicalVolume)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	shared, err := ns.releaseMapping(ctx, imageSpec, stagingParentPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if shared {
+		if err = cleanupRBDImageMetadataStash(stagingParentPath); err != nil {
+			log.ErrorLog(ctx, "failed to cleanup image metadata stash (%v)", err)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
+	if lvmDevice != "" {
+		err = deactivateVolumeGroup(ctx, lvmDevice, imgInfo.ImageName)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	dArgs := detachRBDImageArgs{
 		imageOrDeviceSpec: imageSpec,
 		isImageSpec:       true,
-		isNbd:             imgInfo.NbdAccess,
+		isNbd:             imgInfo.Mounter == rbdNbdMounter,
 		encrypted:         imgInfo.Encrypted,
 		volumeID:          req.GetVolumeId(),
 		unmapOptions:      imgInfo.UnmapOptions,
 		logDir:            imgInfo.LogDir,
 		logStrategy:       imgInfo.LogStrategy,
 	}
-	if err = detachRBDImageOrDeviceSpec(ctx, &dArgs); err != nil {
+	err = detachRBDImageOrDeviceSpec(ctx, &dArgs)
+	if err != nil && ns.ForceUnstage && !dArgs.isNbd {
+		log.WarningLog(ctx, "unmapping volume (%s) failed, retrying with force: %v", req.GetVolumeId(), err)
+		dArgs.unmapOptions = util.MountOptionsAdd(dArgs.unmapOptions, "force")
+		err = detachRBDImageOrDeviceSpec(ctx, &dArgs)
+		report.Add(util.CleanupStageForceUnmap, err)
+	}
+	if err != nil {
 		log.ErrorLog(
 			ctx,
 			"error unmapping volume (%s) from staging path (%s): (%v)",
@@ -1066,10 +1351,13 @@ func (ns *NodeServer) NodeUnstageVolume(
 			stagingTargetPath,
 			err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, status.Error(codes.Internal, cleanupError(err, report))
 	}
 
 	log.DebugLog(ctx, "successfully unmapped volume (%s)", req.GetVolumeId())
+	if report.Escalated() {
+		log.WarningLog(ctx, "volume (%s) was unstaged with forced cleanup: %s", req.GetVolumeId(), report)
+	}
 
 	if err = cleanupRBDImageMetadataStash(stagingParentPath); err != nil {
 		log.ErrorLog(ctx, "failed to cleanup image metadata stash (%v)", err)
@@ -1080,6 +1368,45 @@ func (ns *NodeServer) NodeUnstageVolume(
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// isReadOnlyStage returns true when the volume is staged for read-only access
+// from multiple nodes.
+func isReadOnlyStage(req *csi.NodeStageVolumeRequest) bool {
+	return req.GetVolumeCapability().GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// releaseMapping removes the reference of the staging path to the mapping of
+// the image, and returns true when other staging paths still use the mapping.
+// When the references can not be updated, the mapping is reported as shared,
+// as unmapping a device that is still in use breaks the other volumes.
+func (ns *NodeServer) releaseMapping(ctx context.Context, imageSpec, stagingPath string) (bool, error) {
+	if ns.MapRefs == nil {
+		return false, nil
+	}
+
+	users, err := ns.MapRefs.Remove(imageSpec, stagingPath)
+	if err != nil {
+		return true, err
+	}
+	if users != 0 {
+		log.DebugLog(ctx, "rbd: mapping of image %s is still used by %d staging paths, not unmapping it",
+			imageSpec, users)
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// cleanupError returns the message of err, including the stages of the forced
+// cleanup that were tried, if any.
+func cleanupError(err error, report *util.CleanupReport) string {
+	if len(report.Results) == 0 {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("%v (cleanup stages: %s)", err, report)
+}
+
 // getStagingPath returns the staging path for the volume from the volume path.
 // The staingTargetPath looks like
 // /var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-08937eb8-7e00-4033-b6ce-bc36147b4ed0/
@@ -1154,7 +1481,7 @@ func (ns *NodeServer) NodeExpandVolume(
 		imgInfo.Pool,
 		imgInfo.RadosNamespace,
 		imgInfo.ImageName,
-		imgInfo.NbdAccess)
+		imgInfo.Mounter == rbdNbdMounter)
 	if !found {
 		return nil, status.Errorf(codes.Internal,
 			"failed to get device for stagingtarget path %v", volumePath)
@@ -1195,7 +1522,7 @@ func (ns *NodeServer) NodeGetCapabilities(
 	ctx context.Context,
 	req *csi.NodeGetCapabilitiesRequest,
 ) (*csi.NodeGetCapabilitiesResponse, error) {
-	return &csi.NodeGetCapabilitiesResponse{
+	resp := &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
 			{
 				Type: &csi.NodeServiceCapability_Rpc{
@@ -1233,7 +1560,19 @@ func (ns *NodeServer) NodeGetCapabilities(
 				},
 			},
 		},
-	}, nil
+	}
+
+	if ns.IDMappedMounts {
+		resp.Capabilities = append(resp.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		})
+	}
+
+	return resp, nil
 }
 
 func (ns *NodeServer) processEncryptedDevice(
@@ -1394,7 +1733,9 @@ func (ns *NodeServer) NodeGetVolumeStats(
 	}
 
 	if stat.Mode().IsDir() {
-		return csicommon.FilesystemNodeGetVolumeStats(ctx, ns.Mounter, targetPath, true)
+		return ns.StatsCache.Get(ctx, targetPath, func(ctx context.Context) (*csi.NodeGetVolumeStatsResponse, error) {
+			return csicommon.FilesystemNodeGetVolumeStats(ctx, ns.Mounter, targetPath, true)
+		})
 	} else if (stat.Mode() & os.ModeDevice) == os.ModeDevice {
 		return blockNodeGetVolumeStats(ctx, targetPath)
 	}
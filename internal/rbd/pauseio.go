@@ -0,0 +1,353 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/jsongrpc"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+// PauseIOService is the name of the gRPC service that pauses and resumes the
+// IO of the volumes that are staged on the node, by freezing their
+// filesystem. It is served on the admin endpoint of the nodeplugin, the
+// messages are encoded as JSON.
+const PauseIOService = "cephcsi.rbd.v1.PauseIO"
+
+var (
+	errAlreadyPaused = errors.New("IO of the volume is paused already")
+	errNotPaused     = errors.New("IO of the volume is not paused")
+	errNotStaged     = errors.New("volume with a filesystem is not staged on the node")
+)
+
+// PauseVolumeIORequest is the request of PauseVolumeIO.
+type PauseVolumeIORequest struct {
+	VolumeID string `json:"volumeID"`
+	// TTL is the duration after which the IO is resumed, it defaults to
+	// and can not exceed the maximum TTL of the PauseIO.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// PauseVolumeIOResponse is the response of PauseVolumeIO.
+type PauseVolumeIOResponse struct {
+	// Until is the time at which the IO is resumed automatically.
+	Until time.Time `json:"until"`
+}
+
+// ResumeVolumeIORequest is the request of ResumeVolumeIO.
+type ResumeVolumeIORequest struct {
+	VolumeID string `json:"volumeID"`
+}
+
+// ResumeVolumeIOResponse is the response of ResumeVolumeIO.
+type ResumeVolumeIOResponse struct{}
+
+// ListPausedVolumesRequest is the request of ListPausedVolumes.
+type ListPausedVolumesRequest struct{}
+
+// ListPausedVolumesResponse is the response of ListPausedVolumes.
+type ListPausedVolumesResponse struct {
+	Volumes []PausedVolume `json:"volumes"`
+}
+
+// PausedVolume is a volume with paused IO.
+type PausedVolume struct {
+	VolumeID string    `json:"volumeID"`
+	Until    time.Time `json:"until"`
+}
+
+// pauseIOServer is the interface of the PauseIOService.
+type pauseIOServer interface {
+	PauseVolumeIO(ctx context.Context, req *PauseVolumeIORequest) (*PauseVolumeIOResponse, error)
+	ResumeVolumeIO(ctx context.Context, req *ResumeVolumeIORequest) (*ResumeVolumeIOResponse, error)
+	ListPausedVolumes(ctx context.Context, req *ListPausedVolumesRequest) (*ListPausedVolumesResponse, error)
+}
+
+// pauseIOServiceDesc describes the PauseIOService for the gRPC server.
+var pauseIOServiceDesc = grpc.ServiceDesc{
+	ServiceName: PauseIOService,
+	HandlerType: (*pauseIOServer)(nil),
+	Methods: []grpc.MethodDesc{
+		jsongrpc.UnaryMethod(PauseIOService, "PauseVolumeIO", pauseIOServer.PauseVolumeIO),
+		jsongrpc.UnaryMethod(PauseIOService, "ResumeVolumeIO", pauseIOServer.ResumeVolumeIO),
+		jsongrpc.UnaryMethod(PauseIOService, "ListPausedVolumes", pauseIOServer.ListPausedVolumes),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// pausedVolume is a volume with a frozen filesystem, until resume is called.
+type pausedVolume struct {
+	until  time.Time
+	timer  *time.Timer
+	resume func(ctx context.Context) error
+}
+
+// PauseIO pauses the IO of the volumes that are staged on the node for a
+// short quiesce window, for example for backup tools, without fencing the
+// clients from the Ceph cluster. The IO is resumed on request, or
+// automatically once the TTL passed, so that a client that goes away does not
+// block the volume forever.
+type PauseIO struct {
+	maxTTL time.Duration
+
+	// pause freezes the filesystem of the staged volume, it is replaced by
+	// the unit tests
+	pause func(ctx context.Context, volumeID string) (func(context.Context) error, error)
+
+	mutex  sync.Mutex
+	paused map[string]*pausedVolume
+	// pausing are the volumes of which the filesystem is being frozen,
+	// without holding the mutex
+	pausing map[string]bool
+}
+
+var _ pauseIOServer = &PauseIO{}
+
+// NewPauseIO returns a PauseIO for the volumes of the driver that are staged
+// below stagingPath, mounter detects the staging paths that are mounted. The
+// IO of a volume is paused for maxTTL at most.
+func NewPauseIO(mounter mount.Interface, stagingPath, driverName string, maxTTL time.Duration) *PauseIO {
+	return &PauseIO{
+		maxTTL: maxTTL,
+		pause: func(ctx context.Context, volumeID string) (func(context.Context) error, error) {
+			volumes, err := ListStagedVolumes(mounter, stagingPath, driverName)
+			if err != nil {
+				return nil, err
+			}
+			for i := range volumes {
+				if volumes[i].VolumeID == volumeID {
+					return FreezeStagedVolume(ctx, &volumes[i])
+				}
+			}
+
+			return nil, fmt.Errorf("%w: %s", errNotStaged, volumeID)
+		},
+		paused:  map[string]*pausedVolume{},
+		pausing: map[string]bool{},
+	}
+}
+
+// RegisterService registers the PauseIOService on the admin server.
+func (pio *PauseIO) RegisterService(server grpc.ServiceRegistrar) {
+	server.RegisterService(&pauseIOServiceDesc, pio)
+}
+
+// FreezeStagedVolume freezes the filesystem of the staged volume with
+// fsfreeze. The kernel flushes the dirty data to the image, and the writes of
+// the applications, including those through the bind-mounts of the publish
+// paths, block until the returned thaw function is called.
+func FreezeStagedVolume(ctx context.Context, vol *StagedVolume) (func(ctx context.Context) error, error) {
+	mountPath := filepath.Join(vol.StagingTargetPath, vol.VolumeID)
+	_, stderr, err := util.ExecCommand(ctx, "fsfreeze", "--freeze", mountPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to freeze the filesystem of volume %q: %w (%s)", vol.VolumeID, err, stderr)
+	}
+	log.DebugLog(ctx, "froze the filesystem in %q, IO is paused", mountPath)
+
+	thaw := func(ctx context.Context) error {
+		_, stderr, err := util.ExecCommand(ctx, "fsfreeze", "--unfreeze", mountPath)
+		if err != nil {
+			return fmt.Errorf("failed to thaw the filesystem of volume %q: %w (%s)", vol.VolumeID, err, stderr)
+		}
+		log.DebugLog(ctx, "thawed the filesystem in %q, IO is resumed", mountPath)
+
+		return nil
+	}
+
+	return thaw, nil
+}
+
+// Pause pauses the IO of the volume for the TTL, 0 for the maximum TTL, and
+// returns the time at which the IO is resumed.
+func (pio *PauseIO) Pause(ctx context.Context, volumeID string, ttl time.Duration) (
+	time.Time, error,
+) {
+	if ttl == 0 {
+		ttl = pio.maxTTL
+	}
+	if ttl <= 0 || ttl > pio.maxTTL {
+		return time.Time{}, fmt.Errorf("%w: ttl %s is not between 0 and %s",
+			ErrInvalidArgument, ttl, pio.maxTTL)
+	}
+
+	pio.mutex.Lock()
+	if _, ok := pio.paused[volumeID]; ok || pio.pausing[volumeID] {
+		pio.mutex.Unlock()
+
+		return time.Time{}, errAlreadyPaused
+	}
+	pio.pausing[volumeID] = true
+	pio.mutex.Unlock()
+
+	// freezing blocks until the dirty data is flushed, the other volumes can
+	// be paused and resumed in the meantime
+	resume, err := pio.pause(ctx, volumeID)
+
+	pio.mutex.Lock()
+	defer pio.mutex.Unlock()
+	delete(pio.pausing, volumeID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	pv := &pausedVolume{
+		until:  time.Now().Add(ttl),
+		resume: resume,
+	}
+	pv.timer = time.AfterFunc(ttl, func() {
+		ctx := context.Background()
+		log.WarningLog(ctx, "TTL of the paused IO of volume %q passed, resuming IO", volumeID)
+
+		// the volume can be resumed, and paused again, on request at the
+		// same time
+		rErr := pio.resume(ctx, volumeID, pv)
+		if rErr != nil && !errors.Is(rErr, errNotPaused) {
+			log.ErrorLog(ctx, "failed to resume IO of volume %q: %v", volumeID, rErr)
+		}
+	})
+	pio.paused[volumeID] = pv
+
+	return pv.until, nil
+}
+
+// Resume thaws the filesystem of the volume, so that its clients can write
+// again.
+func (pio *PauseIO) Resume(ctx context.Context, volumeID string) error {
+	return pio.resume(ctx, volumeID, nil)
+}
+
+// resume resumes the IO of the volume, only when it is still paused by
+// expected if that is set.
+func (pio *PauseIO) resume(ctx context.Context, volumeID string, expected *pausedVolume) error {
+	pio.mutex.Lock()
+	pv, ok := pio.paused[volumeID]
+	if ok && expected != nil && pv != expected {
+		ok = false
+	}
+	if ok {
+		delete(pio.paused, volumeID)
+	}
+	pio.mutex.Unlock()
+
+	if !ok {
+		return errNotPaused
+	}
+	pv.timer.Stop()
+
+	return pv.resume(ctx)
+}
+
+// Paused returns the volumes with paused IO, sorted by their ID.
+func (pio *PauseIO) Paused() []PausedVolume {
+	pio.mutex.Lock()
+	defer pio.mutex.Unlock()
+
+	paused := make([]PausedVolume, 0, len(pio.paused))
+	for volumeID, pv := range pio.paused {
+		paused = append(paused, PausedVolume{VolumeID: volumeID, Until: pv.until})
+	}
+	slices.SortFunc(paused, func(a, b PausedVolume) int {
+		return strings.Compare(a.VolumeID, b.VolumeID)
+	})
+
+	return paused
+}
+
+// PauseVolumeIO pauses the IO of the volume until the TTL passed or
+// ResumeVolumeIO is called.
+func (pio *PauseIO) PauseVolumeIO(
+	ctx context.Context,
+	req *PauseVolumeIORequest,
+) (*PauseVolumeIOResponse, error) {
+	if req.VolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid ttl %q: %v", req.TTL, err)
+		}
+	}
+
+	until, err := pio.Pause(ctx, req.VolumeID, ttl)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to pause IO of volume %q: %v", req.VolumeID, err)
+
+		return nil, status.Error(pauseIOCode(err), err.Error())
+	}
+
+	return &PauseVolumeIOResponse{Until: until}, nil
+}
+
+// ResumeVolumeIO resumes the IO of a volume that was paused with
+// PauseVolumeIO.
+func (pio *PauseIO) ResumeVolumeIO(
+	ctx context.Context,
+	req *ResumeVolumeIORequest,
+) (*ResumeVolumeIOResponse, error) {
+	if req.VolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty volume ID in request")
+	}
+
+	err := pio.Resume(ctx, req.VolumeID)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to resume IO of volume %q: %v", req.VolumeID, err)
+
+		return nil, status.Error(pauseIOCode(err), err.Error())
+	}
+
+	return &ResumeVolumeIOResponse{}, nil
+}
+
+// ListPausedVolumes returns the volumes with paused IO.
+func (pio *PauseIO) ListPausedVolumes(
+	context.Context,
+	*ListPausedVolumesRequest,
+) (*ListPausedVolumesResponse, error) {
+	return &ListPausedVolumesResponse{Volumes: pio.Paused()}, nil
+}
+
+// pauseIOCode returns the gRPC code for an error of Pause or Resume.
+func pauseIOCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrInvalidArgument):
+		return codes.InvalidArgument
+	case errors.Is(err, errNotPaused), errors.Is(err, errNotStaged):
+		return codes.NotFound
+	case errors.Is(err, errAlreadyPaused):
+		return codes.AlreadyExists
+	}
+
+	return codes.Internal
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestPauseIO returns a PauseIO that counts the volumes that are paused
+// instead of freezing their filesystem.
+func newTestPauseIO(maxTTL time.Duration, paused *atomic.Int32) *PauseIO {
+	return &PauseIO{
+		maxTTL: maxTTL,
+		pause: func(context.Context, string) (func(context.Context) error, error) {
+			paused.Add(1)
+
+			return func(context.Context) error {
+				paused.Add(-1)
+
+				return nil
+			}, nil
+		},
+		paused:  map[string]*pausedVolume{},
+		pausing: map[string]bool{},
+	}
+}
+
+func TestPauseIO(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	var paused atomic.Int32
+	pio := newTestPauseIO(time.Minute, &paused)
+
+	_, err := pio.Pause(ctx, "vol-1", time.Hour)
+	require.Error(t, err)
+
+	until, err := pio.Pause(ctx, "vol-1", 0)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), until, time.Second)
+	require.EqualValues(t, 1, paused.Load())
+
+	_, err = pio.Pause(ctx, "vol-1", 0)
+	require.ErrorIs(t, err, errAlreadyPaused)
+	require.Len(t, pio.Paused(), 1)
+
+	require.NoError(t, pio.Resume(ctx, "vol-1"))
+	require.EqualValues(t, 0, paused.Load())
+	require.ErrorIs(t, pio.Resume(ctx, "vol-1"), errNotPaused)
+
+	// the IO is resumed once the TTL passed
+	_, err = pio.Pause(ctx, "vol-2", 10*time.Millisecond)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return paused.Load() == 0 && len(pio.Paused()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPauseIOService(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	var paused atomic.Int32
+	pio := newTestPauseIO(time.Minute, &paused)
+
+	resp, err := pio.PauseVolumeIO(ctx, &PauseVolumeIORequest{VolumeID: "vol-1", TTL: "30s"})
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(30*time.Second), resp.Until, time.Second)
+
+	_, err = pio.PauseVolumeIO(ctx, &PauseVolumeIORequest{VolumeID: "vol-1"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = pio.PauseVolumeIO(ctx, &PauseVolumeIORequest{VolumeID: "vol-2", TTL: "2h"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = pio.PauseVolumeIO(ctx, &PauseVolumeIORequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := pio.ListPausedVolumes(ctx, &ListPausedVolumesRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Volumes, 1)
+	require.Equal(t, "vol-1", list.Volumes[0].VolumeID)
+
+	_, err = pio.ResumeVolumeIO(ctx, &ResumeVolumeIORequest{VolumeID: "vol-1"})
+	require.NoError(t, err)
+	require.EqualValues(t, 0, paused.Load())
+
+	_, err = pio.ResumeVolumeIO(ctx, &ResumeVolumeIORequest{VolumeID: "vol-1"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestPauseIOConcurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	acquiring := make(chan struct{})
+	release := make(chan struct{})
+	pio := newTestPauseIO(time.Minute, &atomic.Int32{})
+	pio.pause = func(_ context.Context, volumeID string) (func(context.Context) error, error) {
+		if volumeID == "vol-1" {
+			close(acquiring)
+			<-release
+		}
+
+		return func(context.Context) error { return nil }, nil
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := pio.Pause(ctx, "vol-1", 0)
+		done <- err
+	}()
+	<-acquiring
+
+	// other volumes are not blocked while vol-1 is frozen
+	_, err := pio.Pause(ctx, "vol-2", 0)
+	require.NoError(t, err)
+	require.Len(t, pio.Paused(), 1)
+
+	_, err = pio.Pause(ctx, "vol-1", 0)
+	require.ErrorIs(t, err, errAlreadyPaused)
+
+	close(release)
+	require.NoError(t, <-done)
+	require.Len(t, pio.Paused(), 2)
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+// PoolMigration describes a volume after it was moved by
+// MigrateVolumeToPool.
+type PoolMigration struct {
+	// VolumeID is the new ID of the volume, it contains the ID of the
+	// new pool.
+	VolumeID string
+	// Pool is the pool of the image.
+	Pool string
+	// JournalPool is the pool with the journal of the volume.
+	JournalPool string
+}
+
+// MigrateVolumeToPool moves the image of the volume with volumeID to pool with
+// RBD live migration, and moves the journal of the volume along. The ID of the
+// volume changes, as it contains the ID of the pool of the image. When the
+// request name of the volume is stored in the pool of the image, it is moved
+// to the new pool as well, so that the old pool can be removed once all
+// volumes are migrated.
+//
+// The image must not be in use. Volumes that are encrypted, mirrored, member
+// of a group or have snapshots can not be migrated. A MigrateVolumeToPool that
+// is retried after a failure resumes the migration.
+func MigrateVolumeToPool(ctx context.Context, volumeID, pool string, cr *util.Credentials) (*PoolMigration, error) {
+	var vi util.CSIIdentifier
+	err := vi.DecomposeCSIID(volumeID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding volume ID (%w) (%s)", ErrInvalidVolID, err, volumeID)
+	}
+
+	rv, err := genVolForRename(ctx, volumeID, cr)
+	if err != nil && !errors.Is(err, util.ErrPoolNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		defer rv.Destroy(ctx)
+	}
+	if err != nil || rv.RequestName == "" {
+		// the journal of the volume was moved by a previous attempt, or
+		// the old pool was removed already
+		return resumePoolMigration(ctx, vi, pool, cr)
+	}
+
+	if rv.Pool == pool {
+		return &PoolMigration{VolumeID: volumeID, Pool: rv.Pool, JournalPool: rv.JournalPool}, nil
+	}
+
+	err = rv.migrateImage(ctx, pool, cr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the request name moves along when it is kept next to the image
+	journalPool := rv.JournalPool
+	if rv.JournalPool == rv.Pool {
+		journalPool = pool
+	}
+	journalPoolID, poolID, err := util.GetPoolIDs(ctx, rv.Monitors, journalPool, pool, cr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the IDs of pools %q and %q: %w", journalPool, pool, err)
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	err = j.MoveReservation(ctx, rv.JournalPool, rv.Pool, journalPool, journalPoolID, pool, poolID,
+		rv.ReservedID, rv.RequestName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move the journal of volume %q to pool %q: %w", volumeID, pool, err)
+	}
+	resolver.Invalidate(volumeID)
+
+	vi.LocationID = poolID
+	newVolumeID, err := vi.ComposeCSIID()
+	if err != nil {
+		return nil, err
+	}
+	log.DebugLog(ctx, "moved volume %q to pool %q, the volume ID is %q now", volumeID, pool, newVolumeID)
+
+	return completePoolMigration(ctx, newVolumeID, cr)
+}
+
+// resumePoolMigration returns the volume in pool, for a volume of which the
+// journal was moved to pool already.
+func resumePoolMigration(
+	ctx context.Context,
+	vi util.CSIIdentifier,
+	pool string,
+	cr *util.Credentials,
+) (*PoolMigration, error) {
+	monitors, _, err := util.FetchMappedClusterIDAndMons(ctx, vi.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	vi.LocationID, err = util.GetPoolID(monitors, cr, pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the ID of pool %q: %w", pool, err)
+	}
+
+	volumeID, err := vi.ComposeCSIID()
+	if err != nil {
+		return nil, err
+	}
+	log.DebugLog(ctx, "resuming the migration of volume %q in pool %q", volumeID, pool)
+
+	return completePoolMigration(ctx, volumeID, cr)
+}
+
+// completePoolMigration stores the ID of the migrated image in the journal of
+// the volume.
+func completePoolMigration(ctx context.Context, volumeID string, cr *util.Credentials) (*PoolMigration, error) {
+	rv, err := genVolForRename(ctx, volumeID, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer rv.Destroy(ctx)
+
+	if rv.RequestName == "" {
+		return nil, fmt.Errorf("%w: no reservation for volume %q in pool %q", ErrImageNotFound, volumeID, rv.Pool)
+	}
+
+	err = rv.getImageID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the ID of image %s: %w", rv, err)
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	err = j.StoreImageID(ctx, rv.Pool, rv.ReservedID, rv.ImageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the ID of image %s: %w", rv, err)
+	}
+
+	return &PoolMigration{VolumeID: volumeID, Pool: rv.Pool, JournalPool: rv.JournalPool}, nil
+}
+
+// checkPoolMigrationAllowed returns an error when the volume can not be
+// migrated to another pool.
+func (rv *rbdVolume) checkPoolMigrationAllowed(ctx context.Context, cr *util.Credentials) error {
+	err := rv.checkExclusiveUse("migrating")
+	if err != nil {
+		return err
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	attrs, err := j.GetImageAttributes(ctx, rv.Pool, rv.ReservedID, false)
+	if err != nil {
+		return fmt.Errorf("failed to get the attributes of image %s: %w", rv, err)
+	}
+	// the ID of the volume is used to store the passphrase in a KMS
+	if attrs.KmsID != "" {
+		return fmt.Errorf("image %s is encrypted, encrypted volumes can not be migrated", rv)
+	}
+	if attrs.GroupID != "" {
+		return fmt.Errorf("image %s is a member of volume group %q, remove it from the group first", rv, attrs.GroupID)
+	}
+
+	sj, err := snapJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer sj.Destroy()
+
+	refs, err := sj.ListSnapshotRefs(ctx, rv.JournalPool, rv.RbdImageName)
+	if err != nil {
+		return fmt.Errorf("failed to list the snapshots of image %s: %w", rv, err)
+	}
+	if len(refs) != 0 {
+		return fmt.Errorf("image %s has %d snapshots, delete them before migrating it", rv, len(refs))
+	}
+
+	return nil
+}
+
+// migrateImage moves the image to pool with RBD live migration. The image
+// keeps its name. A migration that was started by a previous attempt is
+// resumed.
+func (rv *rbdVolume) migrateImage(ctx context.Context, pool string, cr *util.Credentials) error {
+	dst := &rbdImage{
+		Monitors:       rv.Monitors,
+		Pool:           pool,
+		RadosNamespace: rv.RadosNamespace,
+		RbdImageName:   rv.RbdImageName,
+	}
+	dst.conn = rv.conn.Copy()
+	defer dst.Destroy(ctx)
+
+	err := dst.openIoctx()
+	if err != nil {
+		return err
+	}
+	err = rv.openIoctx()
+	if err != nil {
+		return err
+	}
+
+	state := librbd.MigrationImagePrepared
+	status, err := librbd.MigrationStatus(dst.ioctx, rv.RbdImageName)
+	switch {
+	case err == nil:
+		state = status.State
+		log.DebugLog(ctx, "resuming migration of image %s to pool %q: %s", rv, pool, status.StateDescription)
+	case rv.committedMigration(dst):
+		log.DebugLog(ctx, "image %s was migrated to pool %q already", rv, pool)
+
+		return nil
+	default:
+		err = rv.checkPoolMigrationAllowed(ctx, cr)
+		if err != nil {
+			return err
+		}
+
+		opts := librbd.NewRbdImageOptions()
+		defer opts.Destroy()
+		err = librbd.MigrationPrepare(rv.ioctx, rv.RbdImageName, dst.ioctx, rv.RbdImageName, opts)
+		if err != nil {
+			return fmt.Errorf("failed to prepare migration of image %s to pool %q: %w", rv, pool, err)
+		}
+	}
+
+	switch state {
+	case librbd.MigrationImagePrepared, librbd.MigrationImageExecuting:
+		err = librbd.MigrationExecute(dst.ioctx, rv.RbdImageName)
+		if err != nil {
+			return fmt.Errorf("failed to execute migration of image %s to pool %q: %w", rv, pool, err)
+		}
+
+		fallthrough
+	case librbd.MigrationImageExecuted:
+		err = librbd.MigrationCommit(dst.ioctx, rv.RbdImageName)
+		if err != nil {
+			return fmt.Errorf("failed to commit migration of image %s to pool %q: %w", rv, pool, err)
+		}
+	default:
+		return fmt.Errorf("migration of image %s to pool %q is in state %d, it can not be resumed",
+			rv, pool, state)
+	}
+	log.DebugLog(ctx, "migrated image %s to pool %q", rv, pool)
+
+	return nil
+}
+
+// committedMigration returns true when the image exists in the pool of dst,
+// and not in its own pool anymore.
+func (rv *rbdVolume) committedMigration(dst *rbdImage) bool {
+	image, err := rv.open()
+	if err == nil {
+		image.Close()
+
+		return false
+	}
+
+	image, err = dst.open()
+	if err != nil {
+		return false
+	}
+	image.Close()
+
+	return true
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// journalLocation is the cluster and pool of a CSI ID.
+type journalLocation struct {
+	clusterID  string
+	locationID int64
+}
+
+// prefetchJournal reads the attributes of the CSI IDs from the journal in a
+// batch per pool, and returns a context that serves them to
+// GetImageAttributes. Prefetching only saves round trips, a failure is logged
+// and the IDs are resolved one by one.
+func prefetchJournal(
+	ctx context.Context,
+	jc *journal.Config,
+	ids []string,
+	snapSource bool,
+	cr *util.Credentials,
+) context.Context {
+	locations := map[journalLocation][]string{}
+	for _, id := range ids {
+		vi := util.CSIIdentifier{}
+		if err := vi.DecomposeCSIID(id); err != nil {
+			continue
+		}
+
+		loc := journalLocation{clusterID: vi.ClusterID, locationID: vi.LocationID}
+		locations[loc] = append(locations[loc], vi.ObjectUUID)
+	}
+
+	for loc, objectUUIDs := range locations {
+		// a single image needs a single read anyway
+		if len(objectUUIDs) < 2 {
+			continue
+		}
+
+		pctx, err := prefetchJournalLocation(ctx, jc, loc, objectUUIDs, snapSource, cr)
+		if err != nil {
+			log.WarningLog(ctx, "failed to prefetch journal of %d images in pool %d of cluster %q: %v",
+				len(objectUUIDs), loc.locationID, loc.clusterID, err)
+
+			continue
+		}
+		ctx = pctx
+	}
+
+	return ctx
+}
+
+func prefetchJournalLocation(
+	ctx context.Context,
+	jc *journal.Config,
+	loc journalLocation,
+	objectUUIDs []string,
+	snapSource bool,
+	cr *util.Credentials,
+) (context.Context, error) {
+	monitors, _, err := util.GetMonsAndClusterID(ctx, loc.clusterID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := util.GetPoolName(monitors, cr, loc.locationID)
+	if err != nil {
+		return nil, err
+	}
+
+	radosNamespace, err := util.GetRBDRadosNamespace(util.CsiConfigFile, loc.clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := jc.Connect(monitors, radosNamespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	attrs, err := j.GetImageAttributesBatch(ctx, pool, objectUUIDs, snapSource)
+	if err != nil {
+		return nil, err
+	}
+	log.DebugLog(ctx, "prefetched journal of %d out of %d images in pool %q", len(attrs), len(objectUUIDs), pool)
+
+	return j.WithImageAttributes(ctx, pool, attrs, snapSource), nil
+}
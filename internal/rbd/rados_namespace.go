@@ -0,0 +1,236 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// radosNamespaceLocks serializes the quota check and the provisioning
+	// in a RADOS namespace, so that parallel requests can not exceed the
+	// quota together.
+	radosNamespaceLocks      = map[string]*sync.Mutex{}
+	radosNamespaceLocksMutex sync.Mutex
+)
+
+// lockRadosNamespace locks the RADOS namespace of the pool, and returns the
+// function to release the lock.
+func lockRadosNamespace(clusterID, pool, namespace string) func() {
+	key := clusterID + "/" + pool + "/" + namespace
+
+	radosNamespaceLocksMutex.Lock()
+	mtx, ok := radosNamespaceLocks[key]
+	if !ok {
+		mtx = &sync.Mutex{}
+		radosNamespaceLocks[key] = mtx
+	}
+	radosNamespaceLocksMutex.Unlock()
+
+	mtx.Lock()
+
+	return mtx.Unlock
+}
+
+// ensureRadosNamespace checks that the RADOS namespace of the volume exists
+// in the pool of the image and in the journal pool. When the cluster is
+// configured with autoCreateRadosNamespace, a missing namespace is created,
+// otherwise ErrRadosNamespaceNotFound is returned.
+func (rv *rbdVolume) ensureRadosNamespace(ctx context.Context, autoCreate bool) error {
+	if rv.RadosNamespace == "" {
+		return nil
+	}
+
+	pools := []string{rv.Pool}
+	if rv.JournalPool != "" && rv.JournalPool != rv.Pool {
+		pools = append(pools, rv.JournalPool)
+	}
+
+	for _, pool := range pools {
+		err := rv.ensureRadosNamespaceInPool(ctx, pool, autoCreate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureRadosNamespaceInPool checks that the RADOS namespace of the volume
+// exists in the pool, and creates it when autoCreate is set.
+func (rv *rbdVolume) ensureRadosNamespaceInPool(ctx context.Context, pool string, autoCreate bool) error {
+	ioctx, err := rv.conn.GetIoctx(pool)
+	if err != nil {
+		return fmt.Errorf("failed to open pool %q: %w", pool, err)
+	}
+	defer ioctx.Destroy()
+
+	exists, err := librbd.NamespaceExists(ioctx, rv.RadosNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to check RADOS namespace %q in pool %q: %w", rv.RadosNamespace, pool, err)
+	}
+	if exists {
+		return nil
+	}
+
+	if !autoCreate {
+		return fmt.Errorf("%w: %q in pool %q", ErrRadosNamespaceNotFound, rv.RadosNamespace, pool)
+	}
+
+	err = librbd.NamespaceCreate(ioctx, rv.RadosNamespace)
+	if err != nil {
+		// created by a parallel request
+		if errors.Is(err, librbd.ErrExist) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to create RADOS namespace %q in pool %q: %w", rv.RadosNamespace, pool, err)
+	}
+	log.UsefulLog(ctx, "created RADOS namespace %q in pool %q", rv.RadosNamespace, pool)
+
+	return nil
+}
+
+// getRadosNamespaceProvisioned returns the sum of the sizes of all images in
+// the RADOS namespace of the pool of the image. The images that back
+// snapshots and temporary clones count as well, as they consume capacity too.
+func (ri *rbdImage) getRadosNamespaceProvisioned() (int64, error) {
+	err := ri.openIoctx()
+	if err != nil {
+		return 0, err
+	}
+
+	names, err := librbd.GetImageNames(ri.ioctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list images in %s/%s: %w", ri.Pool, ri.RadosNamespace, err)
+	}
+
+	var provisioned int64
+	for _, name := range names {
+		image, oErr := librbd.OpenImageReadOnly(ri.ioctx, name, librbd.NoSnapshot)
+		if oErr != nil {
+			// removed in the meantime
+			if errors.Is(oErr, librbd.ErrNotFound) {
+				continue
+			}
+
+			return 0, fmt.Errorf("failed to open image %s/%s/%s: %w", ri.Pool, ri.RadosNamespace, name, oErr)
+		}
+
+		size, sErr := image.GetSize()
+		image.Close()
+		if sErr != nil {
+			return 0, fmt.Errorf("failed to get size of image %s/%s/%s: %w", ri.Pool, ri.RadosNamespace, name, sErr)
+		}
+		provisioned += int64(size)
+	}
+
+	return provisioned, nil
+}
+
+// reserveRadosNamespaceCapacity checks that the RADOS namespace of the volume
+// has room for growing by the given number of bytes. When a quota is
+// configured for the namespace, the namespace stays locked until the returned
+// function is called, which should be done after the image was created or
+// resized.
+func (rv *rbdVolume) reserveRadosNamespaceCapacity(ctx context.Context, quota, growth int64) (func(), error) {
+	if rv.RadosNamespace == "" || quota == 0 || growth <= 0 {
+		return func() {}, nil
+	}
+
+	unlock := lockRadosNamespace(rv.ClusterID, rv.Pool, rv.RadosNamespace)
+
+	provisioned, err := rv.getRadosNamespaceProvisioned()
+	if err != nil {
+		unlock()
+
+		return nil, err
+	}
+
+	if provisioned+growth > quota {
+		unlock()
+
+		return nil, fmt.Errorf("%w: %q in pool %q has %d of %d bytes provisioned, %d bytes requested",
+			ErrRadosNamespaceQuotaExceeded, rv.RadosNamespace, rv.Pool, provisioned, quota, growth)
+	}
+	log.DebugLog(ctx, "RADOS namespace %q in pool %q has %d of %d bytes provisioned, reserving %d bytes",
+		rv.RadosNamespace, rv.Pool, provisioned, quota, growth)
+
+	return unlock, nil
+}
+
+// prepareRadosNamespace makes sure the RADOS namespace of a new volume exists
+// and has enough capacity left. The returned function releases the lock on
+// the namespace, and needs to be called once the image has been created.
+func prepareRadosNamespace(ctx context.Context, rv *rbdVolume) (func(), error) {
+	if rv.RadosNamespace == "" {
+		return func() {}, nil
+	}
+
+	autoCreate, _, err := util.GetRBDRadosNamespaceOptions(util.CsiConfigFile, rv.ClusterID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = rv.ensureRadosNamespace(ctx, autoCreate)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to prepare RADOS namespace for %s: %v", rv, err)
+		if errors.Is(err, ErrRadosNamespaceNotFound) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return reserveRadosNamespaceGrowth(ctx, rv, rv.VolSize)
+}
+
+// reserveRadosNamespaceGrowth checks the quota of the RADOS namespace of the
+// volume before it grows by the given number of bytes. It returns gRPC
+// errors, ResourceExhausted when the quota would be exceeded.
+func reserveRadosNamespaceGrowth(ctx context.Context, rv *rbdVolume, growth int64) (func(), error) {
+	if rv.RadosNamespace == "" {
+		return func() {}, nil
+	}
+
+	_, quota, err := util.GetRBDRadosNamespaceOptions(util.CsiConfigFile, rv.ClusterID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	release, err := rv.reserveRadosNamespaceCapacity(ctx, quota, growth)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to reserve capacity for %s: %v", rv, err)
+		if errors.Is(err, ErrRadosNamespaceQuotaExceeded) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return release, nil
+}
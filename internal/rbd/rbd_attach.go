@@ -19,6 +19,7 @@ package rbd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"slices"
@@ -29,9 +30,12 @@ import (
 	"github.com/ceph/ceph-csi/internal/util/log"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// errDeviceNotFound is returned while waiting for the device of an image that
+// is not mapped (yet).
+var errDeviceNotFound = errors.New("device for the image not found")
+
 const (
 	rbdTonbd  = "rbd-nbd"
 	moduleNbd = "nbd"
@@ -210,18 +214,26 @@ func findDeviceMappingImage(ctx context.Context, pool, namespace, image string,
 
 // Stat a path, if it doesn't exist, retry maxRetries times.
 func waitForPath(ctx context.Context, pool, namespace, image string, maxRetries int, useNbdDriver bool) (string, bool) {
-	for i := range maxRetries {
-		if i != 0 {
-			time.Sleep(time.Second)
-		}
+	policy := util.RetryPolicy{
+		InitialInterval: time.Second,
+		MaxAttempts:     maxRetries,
+	}
 
-		device, found := findDeviceMappingImage(ctx, pool, namespace, image, useNbdDriver)
-		if found {
-			return device, found
+	var device string
+	err := util.Retry(ctx, policy, func(ctx context.Context) error {
+		var found bool
+		device, found = findDeviceMappingImage(ctx, pool, namespace, image, useNbdDriver)
+		if !found {
+			return errDeviceNotFound
 		}
+
+		return nil
+	})
+	if err != nil {
+		return "", false
 	}
 
-	return "", false
+	return device, true
 }
 
 // SetRbdNbdToolFeatures sets features available with rbd-nbd, and NBD module
@@ -273,6 +285,12 @@ func SetRbdNbdToolFeatures() {
 	log.DefaultLog("rbd-nbd tool supports cookie feature")
 }
 
+// HasNBD returns true when rbd-nbd can be used to map images on this node.
+// The result is only valid after SetRbdNbdToolFeatures has been called.
+func HasNBD() bool {
+	return hasNBD
+}
+
 // parseMapOptions helps parse formatted mapOptions and unmapOptions and
 // returns mounter specific options.
 func parseMapOptions(mapOptions string) (string, string, error) {
@@ -318,8 +336,21 @@ func (ns *NodeServer) getMapOptions(req *csi.NodeStageVolumeRequest, rv *rbdVolu
 		return err
 	}
 	if rv.Mounter == rbdDefaultMounter {
-		rv.MapOptions = krbdMapOptions
+		// the rbd CLI does not get the cephOptions of the csi config, the
+		// default map options of the cluster are passed before the ones of
+		// the volume, which take precedence
+		var defaultMapOptions string
+		defaultMapOptions, err = util.GetClusterCephOption(rv.ClusterID, "rbd_default_map_options")
+		if err != nil {
+			return err
+		}
+		rv.MapOptions = joinMapOptions(defaultMapOptions, krbdMapOptions)
 		rv.UnmapOptions = krbdUnmapOptions
+		// the volume group of a backing image is only consistent while a
+		// single node writes to it, the exclusive lock is not handed over
+		if rv.lvm != nil {
+			rv.MapOptions = joinMapOptions(rv.MapOptions, "exclusive")
+		}
 	} else if rv.Mounter == rbdNbdMounter {
 		rv.MapOptions = nbdMapOptions
 		rv.UnmapOptions = nbdUnmapOptions
@@ -336,6 +367,19 @@ func (ns *NodeServer) getMapOptions(req *csi.NodeStageVolumeRequest, rv *rbdVolu
 	return nil
 }
 
+// joinMapOptions joins the comma separated map options, and skips the empty
+// ones.
+func joinMapOptions(options ...string) string {
+	nonEmpty := make([]string, 0, len(options))
+	for _, opt := range options {
+		if opt != "" {
+			nonEmpty = append(nonEmpty, opt)
+		}
+	}
+
+	return strings.Join(nonEmpty, ",")
+}
+
 func attachRBDImage(ctx context.Context, volOptions *rbdVolume, device string, cr *util.Credentials) (string, error) {
 	var err error
 
@@ -347,13 +391,14 @@ func attachRBDImage(ctx context.Context, volOptions *rbdVolume, device string, c
 
 	devicePath, found := waitForPath(ctx, volOptions.Pool, volOptions.RadosNamespace, image, 1, useNBD)
 	if !found {
-		backoff := wait.Backoff{
-			Duration: rbdImageWatcherInitDelay,
-			Factor:   rbdImageWatcherFactor,
-			Steps:    rbdImageWatcherSteps,
+		policy := util.RetryPolicy{
+			InitialInterval: rbdImageWatcherInitDelay,
+			Multiplier:      rbdImageWatcherFactor,
+			MaxAttempts:     rbdImageWatcherSteps,
+			Retryable:       util.RetryOn(ErrImageInUse),
 		}
 
-		err = waitForrbdImage(ctx, backoff, volOptions)
+		err = waitForrbdImage(ctx, policy, volOptions)
 		if err != nil {
 			return "", err
 		}
@@ -432,7 +477,7 @@ func appendRbdNbdCliOptions(cmdArgs []string, userOptions, cookie string) []stri
 
 func createPath(ctx context.Context, volOpt *rbdVolume, device string, cr *util.Credentials) (string, error) {
 	isNbd := false
-	imagePath := volOpt.String()
+	imagePath := volOpt.mapSpec()
 
 	log.TraceLog(ctx, "rbd: map mon %s", volOpt.Monitors)
 
@@ -477,11 +522,7 @@ func createPath(ctx context.Context, volOpt *rbdVolume, device string, cr *util.
 		err    error
 	)
 
-	if volOpt.NetNamespaceFilePath != "" {
-		stdout, stderr, err = util.ExecuteCommandWithNSEnter(ctx, volOpt.NetNamespaceFilePath, cli, mapArgs...)
-	} else {
-		stdout, stderr, err = util.ExecCommand(ctx, cli, mapArgs...)
-	}
+	stdout, stderr, err = util.ExecMountCommand(ctx, volOpt.NetNamespaceFilePath, cli, mapArgs...)
 	if err != nil {
 		log.WarningLog(ctx, "rbd: map error %v, rbd output: %s", err, stderr)
 		// unmap rbd image if connection timeout
@@ -509,25 +550,28 @@ func createPath(ctx context.Context, volOpt *rbdVolume, device string, cr *util.
 	return devicePath, nil
 }
 
-func waitForrbdImage(ctx context.Context, backoff wait.Backoff, volOptions *rbdVolume) error {
+func waitForrbdImage(ctx context.Context, policy util.RetryPolicy, volOptions *rbdVolume) error {
 	imagePath := volOptions.String()
 
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+	err := util.Retry(ctx, policy, func(context.Context) error {
 		used, err := volOptions.isInUse()
 		if err != nil {
-			return false, fmt.Errorf("fail to check rbd image status: (%w)", err)
+			return fmt.Errorf("fail to check rbd image status: (%w)", err)
 		}
 		if (volOptions.DisableInUseChecks) && (used) {
 			log.UsefulLog(ctx, "valid multi-node attach requested, ignoring watcher in-use result")
 
-			return used, nil
+			return nil
+		}
+		if used {
+			return ErrImageInUse
 		}
 
-		return !used, nil
+		return nil
 	})
 	// return error if rbd image has not become available for the specified timeout
-	if wait.Interrupted(err) {
-		return fmt.Errorf("rbd image %s is still being used", imagePath)
+	if errors.Is(err, ErrImageInUse) {
+		return fmt.Errorf("rbd image %s is still being used: %w", imagePath, err)
 	}
 	// return error if any other errors were encountered during waiting for the image to become available
 	return err
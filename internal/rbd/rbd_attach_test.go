@@ -103,3 +103,14 @@ func TestParseMapOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestJoinMapOptions(t *testing.T) {
+	t.Parallel()
+
+	if got := joinMapOptions("ms_mode=secure", "", "lock_on_read"); got != "ms_mode=secure,lock_on_read" {
+		t.Errorf("joinMapOptions() returned %q", got)
+	}
+	if got := joinMapOptions("", ""); got != "" {
+		t.Errorf("joinMapOptions() of empty options returned %q", got)
+	}
+}
@@ -97,7 +97,13 @@ func formatStagingTargetPath(c *k8s.Clientset, pv *v1.PersistentVolume, stagingP
 	return targetPath, nil
 }
 
-func callNodeStageVolume(ns *NodeServer, c *k8s.Clientset, pv *v1.PersistentVolume, stagingPath string) error {
+func callNodeStageVolume(
+	ctx context.Context,
+	ns *NodeServer,
+	c *k8s.Clientset,
+	pv *v1.PersistentVolume,
+	stagingPath string,
+) error {
 	publishContext := make(map[string]string)
 
 	volID := pv.Spec.PersistentVolumeSource.CSI.VolumeHandle
@@ -148,7 +154,7 @@ func callNodeStageVolume(ns *NodeServer, c *k8s.Clientset, pv *v1.PersistentVolu
 		}
 	}
 
-	_, err = ns.NodeStageVolume(context.TODO(), req)
+	_, err = ns.NodeStageVolume(ctx, req)
 	if err != nil {
 		log.ErrorLogMsg("nodeStageVolume request failed, volID: %s, stagingPath: %s, err: %v",
 			volID, stagingParentPath, err)
@@ -175,6 +181,8 @@ func RunVolumeHealer(ns *NodeServer, conf *util.Config) error {
 		return err
 	}
 
+	// the volumes are staged with the journals of the instance of the driver
+	ctx := util.WithInstanceID(context.TODO(), conf.InstanceID)
 	var wg sync.WaitGroup
 	channel := make(chan error)
 	for i := range val.Items {
@@ -224,7 +232,7 @@ func RunVolumeHealer(ns *NodeServer, conf *util.Config) error {
 		// run multiple NodeStageVolume calls concurrently
 		go func(wg *sync.WaitGroup, ns *NodeServer, c *k8s.Clientset, pv *v1.PersistentVolume, stagingPath string) {
 			defer wg.Done()
-			channel <- callNodeStageVolume(ns, c, pv, stagingPath)
+			channel <- callNodeStageVolume(ctx, ns, c, pv, stagingPath)
 		}(&wg, ns, c, pv, conf.StagingPath)
 	}
 
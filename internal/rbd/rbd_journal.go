@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ceph/ceph-csi/internal/journal"
 	"github.com/ceph/ceph-csi/internal/util"
@@ -138,7 +139,7 @@ func checkSnapCloneExists(
 		return false, err
 	}
 
-	j, err := snapJournal.Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
 	if err != nil {
 		return false, err
 	}
@@ -262,7 +263,7 @@ func (rv *rbdVolume) Exists(ctx context.Context, parentVol *rbdVolume) (bool, er
 
 	kmsID, encryptionType := getEncryptionConfig(rv)
 
-	j, err := volJournal.Connect(rv.Monitors, rv.RadosNamespace, rv.conn.Creds)
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, rv.conn.Creds)
 	if err != nil {
 		return false, err
 	}
@@ -277,6 +278,17 @@ func (rv *rbdVolume) Exists(ctx context.Context, parentVol *rbdVolume) (bool, er
 		return false, nil
 	}
 
+	// a pending reservation without a recent heartbeat was left behind by a
+	// provisioner that stopped while creating the volume, it is adopted. A
+	// reservation of this provisioner is adopted right away, the lock of the
+	// request name is held so the creation is not in progress anymore.
+	reservation := imageData.ImageAttributes
+	if reservation.ReservationPending() && !reservation.ReservationStale() && !reservation.ReservationOwned() {
+		return false, fmt.Errorf("%w: volume for request %q is being created since %s", ErrReservationPending,
+			rv.RequestName, reservation.ReservedAt.Format(time.RFC3339))
+	}
+	rv.reservationPending = reservation.ReservationPending()
+
 	rv.ReservedID = imageData.ImageUUID
 	rv.RbdImageName = imageData.ImageAttributes.ImageName
 	rv.ImageID = imageData.ImageAttributes.ImageID
@@ -292,6 +304,30 @@ func (rv *rbdVolume) Exists(ctx context.Context, parentVol *rbdVolume) (bool, er
 		rv.Pool = imageData.ImagePool
 	}
 
+	if reservation.BackingSnapshotID != rv.BackingSnapshotID {
+		return false, fmt.Errorf("%w: volume for request %q is backed by snapshot %q, not %q", ErrVolNameConflict,
+			rv.RequestName, reservation.BackingSnapshotID, rv.BackingSnapshotID)
+	}
+	if reservation.LVMBackingImage != "" && rv.lvmBackingImageSize == 0 {
+		return false, fmt.Errorf("%w: volume for request %q is carved from image %q", ErrVolNameConflict,
+			rv.RequestName, reservation.LVMBackingImage)
+	}
+	// volumes with the lvm layout are logical volumes of a backing image
+	if rv.lvmBackingImageSize != 0 {
+		return rv.lvmVolumeExists(ctx, j, imageData)
+	}
+
+	// snapshot-backed volumes do not have an image
+	if rv.BackingSnapshotID != "" {
+		rv.VolID, err = util.GenerateVolID(ctx, rv.Monitors, rv.conn.Creds, imageData.ImagePoolID, rv.Pool,
+			rv.ClusterID, rv.ReservedID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
 	// NOTE: Return volsize should be on-disk volsize, not request vol size, so
 	// save it for size checks before fetching image data
 	requestSize := rv.VolSize
@@ -324,6 +360,15 @@ func (rv *rbdVolume) Exists(ctx context.Context, parentVol *rbdVolume) (bool, er
 	}
 
 	// size checks
+	if rv.VolSize < requestSize && rv.reservationPending {
+		// the image of a stale reservation may not have been resized yet
+		log.WarningLog(ctx, "adopting stale reservation for request (%s), resizing image %s from %d to %d",
+			rv.RequestName, rv, rv.VolSize, requestSize)
+		err = rv.resize(requestSize)
+		if err != nil {
+			return false, fmt.Errorf("failed to resize image %s of stale reservation: %w", rv, err)
+		}
+	}
 	if rv.VolSize < requestSize {
 		return false, fmt.Errorf("%w: image with the same name (%s) but with different size already exists",
 			ErrVolNameConflict, rv.RbdImageName)
@@ -405,7 +450,7 @@ func reserveSnap(ctx context.Context, rbdSnap *rbdSnapshot, rbdVol *rbdVolume, c
 		return err
 	}
 
-	j, err := snapJournal.Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
 	if err != nil {
 		return err
 	}
@@ -493,7 +538,7 @@ func reserveVol(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) er
 
 	kmsID, encryptionType := getEncryptionConfig(rbdVol)
 
-	j, err := volJournal.Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	j, err := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
 	if err != nil {
 		return err
 	}
@@ -501,7 +546,8 @@ func reserveVol(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) er
 
 	rbdVol.ReservedID, rbdVol.RbdImageName, err = j.ReserveName(
 		ctx, rbdVol.JournalPool, journalPoolID, rbdVol.Pool, imagePoolID,
-		rbdVol.RequestName, rbdVol.NamePrefix, "", kmsID, rbdVol.ReservedID, rbdVol.Owner, "", encryptionType)
+		rbdVol.RequestName, rbdVol.NamePrefix, "", kmsID, rbdVol.ReservedID, rbdVol.Owner,
+		rbdVol.BackingSnapshotID, encryptionType)
 	if err != nil {
 		return err
 	}
@@ -512,15 +558,90 @@ func reserveVol(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) er
 		return err
 	}
 
+	// without a heartbeat the reservation is handled like a completed one
+	err = j.RefreshReservation(ctx, rbdVol.JournalPool, rbdVol.ReservedID)
+	if err != nil {
+		log.WarningLog(ctx, "failed to mark reservation of volume %s as pending: %v", rbdVol, err)
+	} else {
+		rbdVol.reservationPending = true
+	}
+
 	log.DebugLog(ctx, "generated Volume ID (%s) and image name (%s) for request name (%s)",
 		rbdVol.VolID, rbdVol.RbdImageName, rbdVol.RequestName)
 
 	return nil
 }
 
+// startReservationHeartbeat refreshes the pending reservation of rbdVol every
+// journal.ReservationHeartbeatInterval, until the returned function is called.
+// The heartbeat is not bound to the deadline of the request, the creation of
+// the volume continues when the CO cancelled the request.
+func startReservationHeartbeat(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) func() {
+	if !rbdVol.reservationPending {
+		return func() {}
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	monitors, namespace := rbdVol.Monitors, rbdVol.RadosNamespace
+	pool, reservedID := rbdVol.JournalPool, rbdVol.ReservedID
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(journal.ReservationHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			j, err := volJournal(ctx).Connect(monitors, namespace, cr)
+			if err == nil {
+				err = j.RefreshReservation(ctx, pool, reservedID)
+				j.Destroy()
+			}
+			if err != nil {
+				log.WarningLog(ctx, "failed to refresh reservation %q: %v", reservedID, err)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// completeVolReservation removes the heartbeat of the pending reservation of
+// rbdVol, after the volume was created. A failure is only logged, the volume
+// exists and a later CreateVolume adopts the reservation.
+func completeVolReservation(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) {
+	if !rbdVol.reservationPending {
+		return
+	}
+
+	j, err := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	if err != nil {
+		log.WarningLog(ctx, "failed to complete reservation of volume %s: %v", rbdVol, err)
+
+		return
+	}
+	defer j.Destroy()
+
+	err = j.CompleteReservation(ctx, rbdVol.JournalPool, rbdVol.ReservedID)
+	if err != nil {
+		log.WarningLog(ctx, "failed to complete reservation of volume %s: %v", rbdVol, err)
+
+		return
+	}
+	rbdVol.reservationPending = false
+}
+
 // undoSnapReservation is a helper routine to undo a name reservation for rbdSnapshot.
 func undoSnapReservation(ctx context.Context, rbdSnap *rbdSnapshot, cr *util.Credentials) error {
-	j, err := snapJournal.Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
 	if err != nil {
 		return err
 	}
@@ -529,13 +650,14 @@ func undoSnapReservation(ctx context.Context, rbdSnap *rbdSnapshot, cr *util.Cre
 	err = j.UndoReservation(
 		ctx, rbdSnap.JournalPool, rbdSnap.Pool, rbdSnap.RbdSnapName,
 		rbdSnap.RequestName)
+	resolver.Invalidate(rbdSnap.VolID)
 
 	return err
 }
 
 // undoVolReservation is a helper routine to undo a name reservation for rbdVolume.
 func undoVolReservation(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) error {
-	j, err := volJournal.Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	j, err := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
 	if err != nil {
 		return err
 	}
@@ -543,6 +665,7 @@ func undoVolReservation(ctx context.Context, rbdVol *rbdVolume, cr *util.Credent
 
 	err = j.UndoReservation(ctx, rbdVol.JournalPool, rbdVol.Pool,
 		rbdVol.RbdImageName, rbdVol.RequestName)
+	resolver.Invalidate(rbdVol.VolID)
 
 	return err
 }
@@ -624,8 +747,7 @@ func RegenerateJournal(
 	if rbdVol.JournalPool == "" {
 		rbdVol.JournalPool = rbdVol.Pool
 	}
-	volJournal = journal.NewCSIVolumeJournal(instanceID)
-	j, err := volJournal.Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	j, err := volJournal(util.WithInstanceID(ctx, instanceID)).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
 	if err != nil {
 		return "", err
 	}
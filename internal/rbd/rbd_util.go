@@ -30,6 +30,7 @@ import (
 
 	"github.com/ceph/ceph-csi/internal/rbd/types"
 	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
 	"github.com/ceph/go-ceph/rados"
@@ -148,6 +149,20 @@ type rbdImage struct {
 	EnableMetadata bool
 	// ParentInTrash indicates the parent image is in trash.
 	ParentInTrash bool
+
+	// flattenPolicy is set when the StorageClass overrides the flatten
+	// flags of the driver, use getFlattenPolicy() to get the effective one.
+	flattenPolicy *flattenPolicy
+
+	// inheritMetadataKeys are the metadata keys from the StorageClass that
+	// a clone or a restored volume inherits in addition to the defaults.
+	inheritMetadataKeys []string
+	// crossNamespaceRestore selects how a snapshot in another RADOS
+	// namespace is restored, see parseCrossNamespaceRestore().
+	crossNamespaceRestore string
+	// imageConfig are the librbd configuration overrides of the image from
+	// the StorageClass, see parseImageConfig().
+	imageConfig map[string]string
 }
 
 // check that rbdVolume implements the types.Volume interface.
@@ -181,6 +196,27 @@ type rbdVolume struct {
 	RequestedVolSize   int64
 	DisableInUseChecks bool
 	readOnly           bool
+	// ephemeral is set for inline volumes that NodePublishVolume creates
+	// and encrypts with a key that is not stored anywhere
+	ephemeral bool
+	// reservationPending is set when the reservation in the journal has a
+	// heartbeat, it is removed by completeVolReservation
+	reservationPending bool
+	// BackingSnapshotID is the ID of the snapshot that a snapshot-backed
+	// volume maps read-only, these volumes do not have an image
+	BackingSnapshotID string
+	// mapSnapName is the RBD snapshot of the image that is mapped instead
+	// of the image itself
+	mapSnapName string
+	// LVMBackingImage is the image that the logical volume of a volume with
+	// the lvm layout is carved from, these volumes do not have an image
+	LVMBackingImage string
+	// lvmBackingImageSize is the size of the backing images that are created
+	// for a volume with the lvm layout, it is 0 for the other layouts
+	lvmBackingImageSize int64
+	// lvm is the logical volume that the node stages, the image of the
+	// volume is the backing image of the logical volume
+	lvm *logicalVolume
 }
 
 // rbdSnapshot represents a CSI snapshot and its RBD snapshot specifics.
@@ -194,6 +230,10 @@ type rbdSnapshot struct {
 
 	// groupID is the CSI volume group ID where this snapshot belongs to
 	groupID string
+
+	// flattenTimeout is the time CreateSnapshot waits for the image of the
+	// snapshot to be flattened, it is not flattened right away when 0
+	flattenTimeout time.Duration
 }
 
 // imageFeature represents required image features and value.
@@ -378,7 +418,7 @@ func (ri *rbdImage) Connect(cr *util.Credentials) error {
 	}
 
 	conn := &util.ClusterConnection{}
-	if err := conn.Connect(ri.Monitors, cr); err != nil {
+	if err := conn.ConnectCluster(ri.ClusterID, ri.Monitors, cr); err != nil {
 		return err
 	}
 
@@ -453,8 +493,10 @@ func createImage(ctx context.Context, pOpts *rbdVolume, cr *util.Credentials) er
 		return fmt.Errorf("failed to get IOContext: %w", err)
 	}
 
+	done := pOpts.conn.TrackCall("create_image")
 	err = librbd.CreateImage(pOpts.ioctx, pOpts.RbdImageName,
 		uint64(util.RoundOffVolSize(pOpts.VolSize)*helpers.MiB), options)
+	done(err)
 	if err != nil {
 		return fmt.Errorf("failed to create rbd image: %w", err)
 	}
@@ -521,7 +563,9 @@ func (ri *rbdImage) open() (*librbd.Image, error) {
 		return nil, err
 	}
 
+	done := ri.conn.TrackCall("open_image")
 	image, err := librbd.OpenImage(ri.ioctx, ri.RbdImageName, librbd.NoSnapshot)
+	done(err)
 	if err != nil {
 		if errors.Is(err, librbd.ErrNotFound) {
 			err = fmt.Errorf("Failed as %w (internal %w)", ErrImageNotFound, err)
@@ -581,6 +625,50 @@ func (ri *rbdImage) isInUse() (bool, error) {
 	return len(watchers) > defaultWatchers, nil
 }
 
+// describeWatchers returns the address, the client ID and, when it can be
+// found, the Kubernetes node of every watcher of the image, except the
+// watcher of the connection that looks them up. The rbd-mirror daemons of
+// the cluster are listed as well.
+func (ri *rbdImage) describeWatchers(ctx context.Context) (string, error) {
+	image, err := ri.open()
+	if err != nil {
+		return "", err
+	}
+	defer image.Close()
+
+	watchers, err := image.ListWatchers()
+	if err != nil {
+		return "", fmt.Errorf("failed to list watchers of image %s: %w", ri, err)
+	}
+
+	self, err := ri.conn.GetInstanceID()
+	if err != nil {
+		return "", err
+	}
+
+	// the nodes are only known when running in Kubernetes
+	nodes := map[string]string{}
+	c, err := kubeclient.NewK8sClient()
+	if err == nil {
+		nodes = getNodesByAddress(ctx, c)
+	}
+
+	details := []string{}
+	for _, w := range watchers {
+		if w.Id == int64(self) {
+			continue
+		}
+
+		detail := fmt.Sprintf("%s (client.%d", w.Addr, w.Id)
+		if node, ok := nodes[watcherIP(w.Addr)]; ok {
+			detail += ", node " + node
+		}
+		details = append(details, detail+")")
+	}
+
+	return strings.Join(details, ", "), nil
+}
+
 // checkValidImageFeatures check presence of imageFeatures parameter. It returns false when
 // there imageFeatures is present and empty.
 func checkValidImageFeatures(imageFeatures string, ok bool) bool {
@@ -677,6 +765,11 @@ func (ri *rbdImage) Delete(ctx context.Context) error {
 		return err
 	}
 
+	err = ri.removeDivergenceSnapshots(ctx)
+	if err != nil && !errors.Is(err, ErrImageNotFound) {
+		return err
+	}
+
 	rbdImage := librbd.GetImage(ri.ioctx, image)
 	err = rbdImage.Trash(0)
 	if err != nil {
@@ -789,6 +882,7 @@ func flattenClonedRbdImages(
 	ctx context.Context,
 	children []string,
 	pool, monitors, rbdImageName string,
+	policy flattenPolicy,
 	cr *util.Credentials,
 ) error {
 	rv := &rbdVolume{}
@@ -806,7 +900,7 @@ func flattenClonedRbdImages(
 
 	for _, childName := range children {
 		rv.RbdImageName = childName
-		err = rv.flattenRbdImage(ctx, true, rbdHardMaxCloneDepth, rbdSoftMaxCloneDepth)
+		err = rv.flattenRbdImage(ctx, true, policy.HardMaxCloneDepth, policy.SoftMaxCloneDepth)
 		if err != nil {
 			log.ErrorLog(ctx, "failed to flatten %s; err %v", rv, err)
 
@@ -851,23 +945,27 @@ func (ri *rbdImage) flattenRbdImage(
 		return err
 	}
 
-	_, err = ta.AddFlatten(admin.NewImageSpec(ri.Pool, ri.RadosNamespace, ri.RbdImageName))
+	task, err := ta.AddFlatten(admin.NewImageSpec(ri.Pool, ri.RadosNamespace, ri.RbdImageName))
 	rbdCephMgrSupported := isCephMgrSupported(ctx, ri.ClusterID, err)
 	if rbdCephMgrSupported {
 		if err != nil {
 			// discard flattening error if the image does not have any parent
 			rbdFlattenNoParent := fmt.Sprintf("Image %s/%s does not have a parent", ri.Pool, ri.RbdImageName)
 			if strings.Contains(err.Error(), rbdFlattenNoParent) {
+				ri.untrackFlattenTask(ctx)
+
 				return nil
 			}
 			log.ErrorLog(ctx, "failed to add task flatten for %s : %v", ri, err)
 
 			return err
 		}
+		fs := ri.trackFlattenTask(ctx, &task)
 		if forceFlatten || depth >= hardlimit {
-			return fmt.Errorf("%w: flatten is in progress for image %s", ErrFlattenInProgress, ri.RbdImageName)
+			return fmt.Errorf("%w: flatten is in progress for image %s, %s",
+				ErrFlattenInProgress, ri.RbdImageName, fs)
 		}
-		log.DebugLog(ctx, "successfully added task to flatten image %q", ri)
+		log.DebugLog(ctx, "successfully added task to flatten image %q, %s", ri, fs)
 	}
 	if !rbdCephMgrSupported {
 		log.ErrorLog(
@@ -1003,42 +1101,30 @@ func genSnapFromSnapID(
 		return nil, err
 	}
 
-	rbdSnap.Pool, err = util.GetPoolName(rbdSnap.Monitors, cr, vi.LocationID)
-	if err != nil {
-		return nil, err
-	}
-	rbdSnap.JournalPool = rbdSnap.Pool
-
 	rbdSnap.RadosNamespace, err = util.GetRBDRadosNamespace(util.CsiConfigFile, rbdSnap.ClusterID)
 	if err != nil {
 		return nil, err
 	}
 
-	j, err := snapJournal.Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
-	if err != nil {
+	resolved, err := resolver.resolveImage(ctx, snapshotID, vi, rbdSnap.Monitors, rbdSnap.RadosNamespace, cr, true)
+	if resolved.pool == "" {
+		reportDeletedPool(ctx, snapshotID, vi, err)
+
 		return nil, err
 	}
-	defer j.Destroy()
-
-	imageAttributes, err := j.GetImageAttributes(
-		ctx, rbdSnap.Pool, vi.ObjectUUID, true)
+	rbdSnap.Pool = resolved.pool
+	rbdSnap.JournalPool = resolved.journalPool
 	if err != nil {
 		return rbdSnap, err
 	}
+
+	imageAttributes := &resolved.attrs
 	rbdSnap.ImageID = imageAttributes.ImageID
 	rbdSnap.RequestName = imageAttributes.RequestName
 	rbdSnap.RbdImageName = imageAttributes.SourceName
 	rbdSnap.RbdSnapName = imageAttributes.ImageName
 	rbdSnap.ReservedID = vi.ObjectUUID
 	rbdSnap.Owner = imageAttributes.Owner
-	// convert the journal pool ID to name, for use in DeleteSnapshot cases
-	if imageAttributes.JournalPoolID != util.InvalidPoolID {
-		rbdSnap.JournalPool, err = util.GetPoolName(rbdSnap.Monitors, cr, imageAttributes.JournalPoolID)
-		if err != nil {
-			// TODO: If pool is not found we may leak the image (as DeleteSnapshot will return success)
-			return rbdSnap, err
-		}
-	}
 
 	if imageAttributes.GroupID != "" {
 		rbdSnap.groupID = imageAttributes.GroupID
@@ -1080,7 +1166,7 @@ func genSnapFromSnapID(
 		return rbdSnap, fmt.Errorf("failed to update snapshot details for %q: %w", rbdSnap, err)
 	}
 
-	return rbdSnap, err
+	return rbdSnap, nil
 }
 
 // updateSnapshotDetails will copy the details from the rbdVolume to the
@@ -1134,33 +1220,39 @@ func generateVolumeFromVolumeID(
 		return rbdVol, err
 	}
 
-	j, err := volJournal.Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
-	if err != nil {
+	resolved, err := resolver.resolveImage(ctx, volumeID, vi, rbdVol.Monitors, rbdVol.RadosNamespace, cr, false)
+	if resolved.pool == "" {
 		return rbdVol, err
 	}
-	defer j.Destroy()
+	rbdVol.Pool = resolved.pool
+	rbdVol.JournalPool = resolved.journalPool
 
-	rbdVol.Pool, err = util.GetPoolName(rbdVol.Monitors, cr, vi.LocationID)
-	if err != nil {
-		return rbdVol, err
+	// connected even when the journal can not be read, callers may clean up
+	if cErr := rbdVol.Connect(cr); cErr != nil {
+		return rbdVol, cErr
 	}
-
-	err = rbdVol.Connect(cr)
 	if err != nil {
 		return rbdVol, err
 	}
-	rbdVol.JournalPool = rbdVol.Pool
 
-	imageAttributes, err := j.GetImageAttributes(
-		ctx, rbdVol.Pool, vi.ObjectUUID, false)
-	if err != nil {
-		return rbdVol, err
-	}
+	imageAttributes := &resolved.attrs
 	rbdVol.RequestName = imageAttributes.RequestName
 	rbdVol.RbdImageName = imageAttributes.ImageName
 	rbdVol.ReservedID = vi.ObjectUUID
 	rbdVol.ImageID = imageAttributes.ImageID
 	rbdVol.Owner = imageAttributes.Owner
+	rbdVol.BackingSnapshotID = imageAttributes.BackingSnapshotID
+
+	if rbdVol.BackingSnapshotID != "" {
+		return rbdVol, fmt.Errorf("%w: volume %s maps snapshot %s", ErrSnapshotBackedVolume,
+			volumeID, rbdVol.BackingSnapshotID)
+	}
+
+	rbdVol.LVMBackingImage = imageAttributes.LVMBackingImage
+	if rbdVol.LVMBackingImage != "" {
+		return rbdVol, fmt.Errorf("%w: volume %s is carved from image %s", ErrLVMVolume,
+			volumeID, rbdVol.LVMBackingImage)
+	}
 
 	if imageAttributes.KmsID != "" && imageAttributes.EncryptionType == util.EncryptionTypeBlock {
 		err = rbdVol.configureBlockEncryption(imageAttributes.KmsID, secrets)
@@ -1174,16 +1266,13 @@ func generateVolumeFromVolumeID(
 			return rbdVol, err
 		}
 	}
-	// convert the journal pool ID to name, for use in DeleteVolume cases
-	if imageAttributes.JournalPoolID >= 0 {
-		rbdVol.JournalPool, err = util.GetPoolName(rbdVol.Monitors, cr, imageAttributes.JournalPoolID)
-		if err != nil {
-			// TODO: If pool is not found we may leak the image (as DeleteVolume will return success)
-			return rbdVol, err
+	if rbdVol.ImageID == "" {
+		j, jErr := volJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+		if jErr != nil {
+			return rbdVol, jErr
 		}
-	}
+		defer j.Destroy()
 
-	if rbdVol.ImageID == "" {
 		err = rbdVol.storeImageID(ctx, j)
 		if err != nil {
 			return rbdVol, err
@@ -1229,6 +1318,7 @@ func GenVolFromVolID(
 			return rbdVol, vErr
 		}
 	}
+	reportDeletedPool(ctx, volumeID, vi, err)
 
 	return vol, err
 }
@@ -1340,6 +1430,12 @@ func genVolFromVolumeOptions(
 	if namePrefix, ok = volOptions["volumeNamePrefix"]; ok {
 		rbdVol.NamePrefix = namePrefix
 	}
+	if template, found := volOptions[kubeclient.VolumeNameTemplateParam]; found {
+		rbdVol.NamePrefix, err = kubeclient.RenderVolumeNamePrefix(template, volOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	clusterID, err := util.GetClusterID(volOptions)
 	if err != nil {
@@ -1380,6 +1476,20 @@ func genVolFromVolumeOptions(
 		return nil, err
 	}
 
+	rbdVol.flattenPolicy, err = parseFlattenPolicy(volOptions)
+	if err != nil {
+		return nil, err
+	}
+	rbdVol.inheritMetadataKeys = parseInheritMetadataKeys(volOptions)
+	rbdVol.crossNamespaceRestore, err = parseCrossNamespaceRestore(volOptions)
+	if err != nil {
+		return nil, err
+	}
+	rbdVol.imageConfig, err = parseImageConfig(volOptions)
+	if err != nil {
+		return nil, err
+	}
+
 	return rbdVol, nil
 }
 
@@ -1440,6 +1550,14 @@ func (rv *rbdVolume) validateImageFeatures(imageFeatures string) error {
 	return nil
 }
 
+// ValidateImageFeatures checks that the comma separated list of imageFeatures
+// is supported, and that all features can be used with the given mounter.
+func ValidateImageFeatures(imageFeatures, mounter string) error {
+	rv := &rbdVolume{Mounter: mounter}
+
+	return rv.validateImageFeatures(imageFeatures)
+}
+
 func genSnapFromOptions(ctx context.Context, rbdVol *rbdVolume, snapOptions map[string]string) (*rbdSnapshot, error) {
 	var err error
 
@@ -1463,6 +1581,11 @@ func genSnapFromOptions(ctx context.Context, rbdVol *rbdVolume, snapOptions map[
 		rbdSnap.NamePrefix = namePrefix
 	}
 
+	rbdSnap.flattenTimeout, err = parseFlattenSnapshot(snapOptions)
+	if err != nil {
+		return nil, err
+	}
+
 	return rbdSnap, nil
 }
 
@@ -1539,6 +1662,7 @@ func (rv *rbdVolume) cloneRbdImageFromSnapshot(
 		return fmt.Errorf("failed to get IOContext: %w", err)
 	}
 
+	done := rv.conn.TrackCall("clone_image")
 	err = librbd.CloneImage(
 		parentVol.ioctx,
 		pSnapOpts.RbdImageName,
@@ -1546,6 +1670,7 @@ func (rv *rbdVolume) cloneRbdImageFromSnapshot(
 		rv.ioctx,
 		rv.RbdImageName,
 		options)
+	done(err)
 	if err != nil {
 		return fmt.Errorf("failed to create rbd clone: %w", err)
 	}
@@ -1763,6 +1888,11 @@ func (ri *rbdImage) checkSnapExists(rbdSnap *rbdSnapshot) error {
 }
 
 // rbdImageMetadataStash strongly typed JSON spec for stashed RBD image metadata.
+//
+// Fields may only be added to the stash, so that older versions of the
+// nodeplugin can still unstage volumes that were staged by a newer version.
+// The fields that a new version adds need to be filled in for older stashes by
+// migrateRBDImageMetadataStash.
 type rbdImageMetadataStash struct {
 	Version        int    `json:"Version"`
 	Pool           string `json:"pool"`
@@ -1774,40 +1904,83 @@ type rbdImageMetadataStash struct {
 	DevicePath     string `json:"device"`          // holds NBD device path for now
 	LogDir         string `json:"logDir"`          // holds the client log path
 	LogStrategy    string `json:"logFileStrategy"` // ceph client log strategy
+
+	// added in version 4
+	Mounter        string `json:"mounter"`        // mounter that mapped the image
+	MapOptions     string `json:"mapOptions"`     // options used to map the image
+	EncryptionType string `json:"encryptionType"` // "block", "file" or empty
+
+	// added in version 5
+	Ephemeral bool `json:"ephemeral"` // image is deleted by NodeUnpublishVolume
+
+	// added in version 6
+	SnapName string `json:"snapName"` // snapshot of the image that is mapped
+
+	// added in version 7
+	LogicalVolume string `json:"logicalVolume"` // logical volume that is staged from the image
 }
 
-// file name in which image metadata is stashed.
-const stashFileName = "image-meta.json"
+const (
+	// file name in which image metadata is stashed.
+	stashFileName = "image-meta.json"
+
+	// stashVersion is the version of rbdImageMetadataStash that is written.
+	stashVersion = 7
 
-// spec returns the image-spec (pool/{namespace/}image) format of the image.
+	// stashVersionMounter is the version that added the Mounter,
+	// MapOptions and EncryptionType fields.
+	stashVersionMounter = 4
+)
+
+// spec returns the image-spec (pool/{namespace/}image) format of the image,
+// or the snap-spec (pool/{namespace/}image@snap) when a snapshot is mapped.
 func (ri *rbdImageMetadataStash) String() string {
+	spec := fmt.Sprintf("%s/%s", ri.Pool, ri.ImageName)
 	if ri.RadosNamespace != "" {
-		return fmt.Sprintf("%s/%s/%s", ri.Pool, ri.RadosNamespace, ri.ImageName)
+		spec = fmt.Sprintf("%s/%s/%s", ri.Pool, ri.RadosNamespace, ri.ImageName)
+	}
+	if ri.SnapName != "" {
+		spec += "@" + ri.SnapName
 	}
 
-	return fmt.Sprintf("%s/%s", ri.Pool, ri.ImageName)
+	return spec
 }
 
 // stashRBDImageMetadata stashes required fields into the stashFileName at the passed in path, in
 // JSON format.
 func stashRBDImageMetadata(volOptions *rbdVolume, metaDataPath string) error {
 	imgMeta := rbdImageMetadataStash{
-		// there are no checks for this at present
-		Version:        3, //nolint:mnd // number specifies version.
+		Version:        stashVersion,
 		Pool:           volOptions.Pool,
 		RadosNamespace: volOptions.RadosNamespace,
 		ImageName:      volOptions.RbdImageName,
-		Encrypted:      volOptions.isBlockEncrypted(),
+		Encrypted:      volOptions.isBlockEncrypted() || volOptions.ephemeral,
 		UnmapOptions:   volOptions.UnmapOptions,
+		Mounter:        rbdDefaultMounter,
+		MapOptions:     volOptions.MapOptions,
+		Ephemeral:      volOptions.ephemeral,
+		SnapName:       volOptions.mapSnapName,
+	}
+
+	if volOptions.lvm != nil {
+		imgMeta.LogicalVolume = volOptions.lvm.name
 	}
 
 	imgMeta.NbdAccess = false
 	if volOptions.Mounter == rbdTonbd && hasNBD {
 		imgMeta.NbdAccess = true
+		imgMeta.Mounter = rbdNbdMounter
 		imgMeta.LogDir = volOptions.LogDir
 		imgMeta.LogStrategy = volOptions.LogStrategy
 	}
 
+	switch {
+	case volOptions.isBlockEncrypted(), volOptions.ephemeral:
+		imgMeta.EncryptionType = util.EncryptionTypeBlock.String()
+	case volOptions.isFileEncrypted():
+		imgMeta.EncryptionType = util.EncryptionTypeFile.String()
+	}
+
 	encodedBytes, err := json.Marshal(imgMeta)
 	if err != nil {
 		return fmt.Errorf("failed to marshall JSON image metadata for image (%s): %w", volOptions, err)
@@ -1849,9 +2022,38 @@ func lookupRBDImageMetadataStash(metaDataPath string) (rbdImageMetadataStash, er
 		return imgMeta, fmt.Errorf("failed to unmarshall stashed JSON image metadata from path (%s): %w", fPath, err)
 	}
 
+	migrateRBDImageMetadataStash(&imgMeta)
+
 	return imgMeta, nil
 }
 
+// migrateRBDImageMetadataStash fills in the fields that were not present in
+// the version of the stash that was read, and updates the version. A stash
+// with a newer version than stashVersion is returned as-is, the fields that
+// are known are still valid.
+func migrateRBDImageMetadataStash(imgMeta *rbdImageMetadataStash) {
+	if imgMeta.Version > stashVersion {
+		log.WarningLogMsg("image metadata stash for %s has version %d, newer than supported version %d",
+			imgMeta, imgMeta.Version, stashVersion)
+
+		return
+	}
+
+	if imgMeta.Version < stashVersionMounter {
+		imgMeta.Mounter = rbdDefaultMounter
+		if imgMeta.NbdAccess {
+			imgMeta.Mounter = rbdNbdMounter
+		}
+
+		// fscrypt was not tracked, it does not need to be undone on unstage
+		if imgMeta.Encrypted {
+			imgMeta.EncryptionType = util.EncryptionTypeBlock.String()
+		}
+	}
+
+	imgMeta.Version = stashVersion
+}
+
 // updateRBDImageMetadataStash reads and updates stashFile with the required
 // fields at the passed in path, in JSON format.
 func updateRBDImageMetadataStash(metaDataPath, device string) error {
@@ -2096,6 +2298,28 @@ func (ri *rbdImage) AddSnapshotScheduling(
 	return nil
 }
 
+// GetSnapshotSchedules returns the mirror snapshot schedules that are set on
+// the image itself, schedules of the pool or namespace are not included.
+func (ri *rbdImage) GetSnapshotSchedules(_ context.Context) ([]admin.ScheduleTerm, error) {
+	ls := admin.NewLevelSpec(ri.Pool, ri.RadosNamespace, ri.RbdImageName)
+	ra, err := ri.conn.GetRBDAdmin()
+	if err != nil {
+		return nil, err
+	}
+	adminConn := ra.MirrorSnashotSchedule()
+	schedules, err := adminConn.List(ls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot schedules of %q: %w", ri, err)
+	}
+
+	terms := []admin.ScheduleTerm{}
+	for _, schedule := range schedules {
+		terms = append(terms, schedule.Schedule...)
+	}
+
+	return terms, nil
+}
+
 // getCephClientLogFileName compiles the complete log file path based on inputs.
 func getCephClientLogFileName(id, logDir, prefix string) string {
 	if prefix == "" {
@@ -2226,21 +2450,35 @@ func (ri *rbdImage) GetClusterID(ctx context.Context) (string, error) {
 	return ri.ClusterID, nil
 }
 
+// IsInUse returns true when the image has watchers other than the connection
+// that checks it and the rbd-mirror daemons.
+func (ri *rbdImage) IsInUse(ctx context.Context) (bool, error) {
+	return ri.isInUse()
+}
+
 func (rv *rbdVolume) PrepareVolumeForSnapshot(ctx context.Context, cr *util.Credentials) error {
-	hardLimit := rbdHardMaxCloneDepth
-	softLimit := rbdSoftMaxCloneDepth
-	err := flattenTemporaryClonedImages(ctx, rv, cr)
+	if rv.flattenPolicy == nil {
+		err := rv.loadFlattenPolicy(ctx, cr)
+		if err != nil {
+			return getGRPCErrorForCreateVolume(err)
+		}
+	}
+
+	policy := rv.getFlattenPolicy()
+	hardLimit := policy.HardMaxCloneDepth
+	softLimit := policy.SoftMaxCloneDepth
+	err := flattenTemporaryClonedImages(ctx, rv, policy, cr)
 	if err != nil {
 		return err
 	}
 
 	// choosing 2, since snapshot adds one depth and we'll be flattening the parent.
 	const depthToAvoidFlatten = 2
-	if rbdHardMaxCloneDepth > depthToAvoidFlatten {
-		hardLimit = rbdHardMaxCloneDepth - depthToAvoidFlatten
+	if policy.HardMaxCloneDepth > depthToAvoidFlatten {
+		hardLimit = policy.HardMaxCloneDepth - depthToAvoidFlatten
 	}
-	if rbdSoftMaxCloneDepth > depthToAvoidFlatten {
-		softLimit = rbdSoftMaxCloneDepth - depthToAvoidFlatten
+	if policy.SoftMaxCloneDepth > depthToAvoidFlatten {
+		softLimit = policy.SoftMaxCloneDepth - depthToAvoidFlatten
 	}
 
 	err = rv.flattenParent(ctx, hardLimit, softLimit)
@@ -441,3 +441,62 @@ func Test_shouldRetryVolumeGeneration(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupRBDImageMetadataStash(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		stash    string
+		expected rbdImageMetadataStash
+	}{
+		{
+			name:  "version 3 with rbd-nbd and encryption",
+			stash: `{"Version":3,"pool":"rbd","image":"csi-vol-1","accessType":true,"encrypted":true,"device":"/dev/nbd0"}`,
+			expected: rbdImageMetadataStash{
+				Version:        stashVersion,
+				Pool:           "rbd",
+				ImageName:      "csi-vol-1",
+				NbdAccess:      true,
+				Encrypted:      true,
+				DevicePath:     "/dev/nbd0",
+				Mounter:        rbdNbdMounter,
+				EncryptionType: util.EncryptionTypeBlock.String(),
+			},
+		},
+		{
+			name:  "version 2 with krbd",
+			stash: `{"Version":2,"pool":"rbd","image":"csi-vol-2","accessType":false}`,
+			expected: rbdImageMetadataStash{
+				Version:   stashVersion,
+				Pool:      "rbd",
+				ImageName: "csi-vol-2",
+				Mounter:   rbdDefaultMounter,
+			},
+		},
+		{
+			name:  "newer version is kept",
+			stash: `{"Version":99,"pool":"rbd","image":"csi-vol-3","mounter":"rbd","newField":"ignored"}`,
+			expected: rbdImageMetadataStash{
+				Version:   99,
+				Pool:      "rbd",
+				ImageName: "csi-vol-3",
+				Mounter:   rbdDefaultMounter,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			err := os.WriteFile(dir+"/"+stashFileName, []byte(tt.stash), 0o600)
+			require.NoError(t, err)
+
+			imgMeta, err := lookupRBDImageMetadataStash(dir)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, imgMeta)
+		})
+	}
+}
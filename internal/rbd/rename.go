@@ -0,0 +1,268 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+// validateImageName checks that name can be used as the new name of the
+// image of the volume with the reservation reservedID. The name must keep the
+// "<prefix><uuid>" format of the images of volumes, the journal parses the
+// UUID from the name of the image when a reservation is undone. Moving the
+// image to another pool or RADOS namespace is not supported, as these are
+// part of the volume ID, see MigrateVolumeToPool for moving to another pool.
+func validateImageName(name, reservedID string) error {
+	if name == "" {
+		return fmt.Errorf("%w: image name is empty", ErrInvalidArgument)
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("%w: image name %q must not contain '/', moving an image to another pool or "+
+			"RADOS namespace is not supported", ErrInvalidArgument, name)
+	}
+	if strings.Contains(name, "@") {
+		return fmt.Errorf("%w: image name %q must not contain '@'", ErrInvalidArgument, name)
+	}
+	if !strings.HasSuffix(name, reservedID) || len(name) == len(reservedID) {
+		return fmt.Errorf("%w: image name %q must be a prefix followed by the UUID %q of the volume",
+			ErrInvalidArgument, name, reservedID)
+	}
+
+	return nil
+}
+
+// RenameImage renames the image of the volume with volumeID to newName, that
+// must end with the UUID of the volume. The volume ID keeps referring to the
+// image, as the journal is updated with the new name. The image stays in its
+// pool and RADOS namespace, a newName in another RADOS namespace is rejected
+// with ErrInvalidArgument. The image must not be in use, and it must not be
+// mirrored as the peer clusters would keep the old name in their journal.
+//
+// The temporary clone of a cloned volume is renamed with the image, so that
+// it is deleted together with the volume. The images are renamed before the
+// journal is updated, a RenameImage that is retried after a failure continues
+// with the updates of the journal.
+func RenameImage(ctx context.Context, volumeID, newName string, cr *util.Credentials) error {
+	rv, err := genVolForRename(ctx, volumeID, cr)
+	if err != nil {
+		return err
+	}
+	defer rv.Destroy(ctx)
+
+	err = validateImageName(newName, rv.ReservedID)
+	if err != nil {
+		return err
+	}
+
+	oldName := rv.RbdImageName
+	if oldName == newName {
+		return nil
+	}
+
+	err = rv.checkExclusiveUse("renaming")
+	switch {
+	case errors.Is(err, ErrImageNotFound):
+		// a previous attempt may have renamed the image already
+		target := &rbdImage{
+			Monitors:       rv.Monitors,
+			Pool:           rv.Pool,
+			RadosNamespace: rv.RadosNamespace,
+			RbdImageName:   newName,
+		}
+		target.conn = rv.conn.Copy()
+		defer target.Destroy(ctx)
+
+		image, oErr := target.open()
+		if oErr != nil {
+			return fmt.Errorf("image %s does not exist and was not renamed to %q: %w", rv, newName, oErr)
+		}
+		image.Close()
+		log.DebugLog(ctx, "image %s was renamed to %q already, updating the journal", rv, newName)
+	case err != nil:
+		return err
+	default:
+		err = rv.openIoctx()
+		if err != nil {
+			return err
+		}
+		err = rv.renameTempClone(ctx, newName)
+		if err != nil {
+			return err
+		}
+		err = librbd.GetImage(rv.ioctx, oldName).Rename(newName)
+		if err != nil {
+			return fmt.Errorf("failed to rename image %s to %q: %w", rv, newName, err)
+		}
+		log.DebugLog(ctx, "renamed image %s to %q", rv, newName)
+	}
+
+	defer resolver.Invalidate(volumeID)
+
+	return rv.updateJournalForRename(ctx, oldName, newName, cr)
+}
+
+// renameTempClone renames the temporary clone of the volume, that is created
+// by generateTempClone() while cloning a volume, to the name of the temporary
+// clone of newName. The temporary clone is the parent of the image until the
+// image is flattened, and it is deleted by DeleteTempImage() with the name of
+// the image. Renaming the temporary clone first makes sure it is not leaked
+// when renaming the image fails.
+func (rv *rbdVolume) renameTempClone(ctx context.Context, newName string) error {
+	oldTempName := tempCloneName(rv.RbdImageName)
+	newTempName := tempCloneName(newName)
+	err := librbd.GetImage(rv.ioctx, oldTempName).Rename(newTempName)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename temporary clone %q to %q: %w", oldTempName, newTempName, err)
+	}
+	log.DebugLog(ctx, "renamed temporary clone %q to %q", oldTempName, newTempName)
+
+	return nil
+}
+
+// genVolForRename returns the volume with volumeID, with the image name and
+// the pools from the journal. The image is not opened, and the encryption of
+// the volume is not configured as a rename does not need it.
+func genVolForRename(ctx context.Context, volumeID string, cr *util.Credentials) (*rbdVolume, error) {
+	var vi util.CSIIdentifier
+	err := vi.DecomposeCSIID(volumeID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding volume ID (%w) (%s)", ErrInvalidVolID, err, volumeID)
+	}
+
+	rv := &rbdVolume{}
+	rv.VolID = volumeID
+	rv.Monitors, rv.ClusterID, err = util.FetchMappedClusterIDAndMons(ctx, vi.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	rv.RadosNamespace, err = util.GetRBDRadosNamespace(util.CsiConfigFile, rv.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	rv.Pool, err = util.GetPoolName(rv.Monitors, cr, vi.LocationID)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Destroy()
+
+	attrs, err := j.GetImageAttributes(ctx, rv.Pool, vi.ObjectUUID, false)
+	if err != nil {
+		return nil, err
+	}
+	rv.RbdImageName = attrs.ImageName
+	rv.RequestName = attrs.RequestName
+	rv.ReservedID = vi.ObjectUUID
+	rv.JournalPool = rv.Pool
+	if attrs.JournalPoolID != util.InvalidPoolID {
+		rv.JournalPool, err = util.GetPoolName(rv.Monitors, cr, attrs.JournalPoolID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = rv.Connect(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// checkExclusiveUse returns an error when the image is in use or mirrored,
+// operation is used in the error message.
+func (rv *rbdVolume) checkExclusiveUse(operation string) error {
+	image, err := rv.open()
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	watchers, err := image.ListWatchers()
+	if err != nil {
+		return fmt.Errorf("failed to list watchers of image %s: %w", rv, err)
+	}
+	// opening the image added a watcher
+	if len(watchers) > 1 {
+		return fmt.Errorf("image %s is in use by %d clients", rv, len(watchers)-1)
+	}
+
+	mirrorInfo, err := image.GetMirrorImageInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get mirroring info of image %s: %w", rv, err)
+	}
+	if mirrorInfo.State == librbd.MirrorImageEnabled {
+		return fmt.Errorf("image %s is mirrored, disable mirroring before %s it", rv, operation)
+	}
+
+	return nil
+}
+
+// updateJournalForRename points the journal of the volume, and of the
+// snapshots that are tracked for it, to the renamed image. The image name of
+// the volume is stored last, so that a retry finds the old name again.
+func (rv *rbdVolume) updateJournalForRename(ctx context.Context, oldName, newName string, cr *util.Credentials) error {
+	sj, err := snapJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer sj.Destroy()
+
+	refs, err := sj.ListSnapshotRefs(ctx, rv.JournalPool, oldName)
+	if err != nil {
+		return err
+	}
+	// the snapshots are stored in the pool of the image, like the volume
+	for snapUUID := range refs {
+		err = sj.StoreSnapshotSource(ctx, rv.Pool, snapUUID, newName)
+		if err != nil {
+			return err
+		}
+	}
+	err = sj.MoveSnapshotRefs(ctx, rv.JournalPool, oldName, newName)
+	if err != nil {
+		return err
+	}
+
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	err = j.RemoveFlattenTask(ctx, rv.Pool, oldName)
+	if err != nil {
+		log.WarningLog(ctx, "failed to remove flatten task of %q: %v", oldName, err)
+	}
+
+	return j.StoreImageName(ctx, rv.Pool, rv.ReservedID, newName)
+}
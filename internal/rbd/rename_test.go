@@ -0,0 +1,56 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateImageName(t *testing.T) {
+	t.Parallel()
+
+	const reservedID = "7b2b3b5e-4c4f-11ef-8f0b-0242ac110002"
+
+	tests := []struct {
+		name    string
+		image   string
+		wantErr bool
+	}{
+		{"new prefix", "app-db-" + reservedID, false},
+		{"default prefix", "csi-vol-" + reservedID, false},
+		{"empty", "", true},
+		{"only the UUID", reservedID, true},
+		{"without the UUID", "app-db", true},
+		{"UUID not at the end", reservedID + "-old", true},
+		{"other RADOS namespace", "ns/csi-vol-" + reservedID, true},
+		{"snapshot", "csi-vol-" + reservedID + "@snap", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateImageName(tt.image, reservedID)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidArgument)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -36,7 +36,11 @@ func (rv *rbdVolume) RepairResyncedImageID(ctx context.Context, ready bool) erro
 	if !ready {
 		return nil
 	}
-	j, err := volJournal.Connect(rv.Monitors, rv.RadosNamespace, rv.conn.Creds)
+	// migrated in-tree volumes have no journal that stores the image ID
+	if isMigrationVolID(rv.VolID) {
+		return nil
+	}
+	j, err := volJournal(ctx).Connect(rv.Monitors, rv.RadosNamespace, rv.conn.Creds)
 	if err != nil {
 		return err
 	}
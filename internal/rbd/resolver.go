@@ -0,0 +1,336 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"k8s.io/utils/lru"
+)
+
+const (
+	// resolverCacheSize is the number of volumes and snapshots that the
+	// resolver keeps, the least recently used are dropped first.
+	resolverCacheSize = 4096
+	// resolverCacheTTL limits the time the pools of an ID are used without
+	// looking them up again.
+	resolverCacheTTL = 5 * time.Minute
+	// resolverJournalCacheSize is the number of journal connections that the
+	// resolver keeps, there is one for each cluster, namespace and user.
+	resolverJournalCacheSize = 64
+)
+
+// resolver is shared by all services of the driver, so that the pools are
+// not looked up over and over for the same volumes and snapshots.
+var resolver = NewResolver(resolverCacheSize, resolverCacheTTL)
+
+// Resolver resolves the CSI IDs of volumes and snapshots.
+type Resolver interface {
+	// GetVolume returns the volume with the CSI VolumeId.
+	GetVolume(ctx context.Context, id string, cr *util.Credentials, secrets map[string]string) (*rbdVolume, error)
+	// GetSnapshot returns the snapshot with the CSI SnapshotId.
+	GetSnapshot(ctx context.Context, id string, cr *util.Credentials, secrets map[string]string) (*rbdSnapshot, error)
+	// Invalidate drops the cached state of the volume or snapshot with the
+	// CSI ID, it needs to be called when the ID moves to another pool or
+	// the reservation is removed from the journal.
+	Invalidate(id string)
+
+	// resolveImage reads the journal attributes of the ID and finds the
+	// pools of the ID. The returned resolvedImage has the pool set when the
+	// attributes can not be read, so that callers can report or clean up
+	// the reservation.
+	resolveImage(
+		ctx context.Context,
+		id string,
+		vi util.CSIIdentifier,
+		monitors, namespace string,
+		cr *util.Credentials,
+		snapSource bool,
+	) (*resolvedImage, error)
+}
+
+// resolvedPools are the names of the pools of a volume or snapshot, the pool
+// IDs of a CSI ID and its journal do not change.
+type resolvedPools struct {
+	clusterID  string
+	locationID int64
+
+	pool          string
+	journalPoolID int64
+	journalPool   string
+
+	expires time.Time
+}
+
+// resolvedImage is the location of a volume or snapshot, and its attributes
+// in the journal.
+type resolvedImage struct {
+	pool        string
+	journalPool string
+	attrs       journal.ImageAttributes
+}
+
+// cachedJournal is a journal connection of the resolver.
+type cachedJournal struct {
+	conn    *journal.Connection
+	expires time.Time
+}
+
+// cachingResolver is the Resolver of the driver. The names of the pools of
+// the resolved IDs are cached for a limited time, and dropped when the
+// reservation is removed from the journal. The journal attributes are read
+// for every ID, as they are modified by other operations and instances of
+// the driver, but the connections to the journals are reused.
+type cachingResolver struct {
+	cache    *lru.Cache
+	journals *lru.Cache
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+var _ Resolver = &cachingResolver{}
+
+// NewResolver returns a Resolver that caches the pools of at most size IDs,
+// and the journal connections, for ttl.
+func NewResolver(size int, ttl time.Duration) Resolver {
+	return newCachingResolver(size, ttl)
+}
+
+func newCachingResolver(size int, ttl time.Duration) *cachingResolver {
+	return &cachingResolver{
+		cache: lru.New(size),
+		journals: lru.NewWithEvictionFunc(resolverJournalCacheSize, func(_ lru.Key, value any) {
+			if cj, ok := value.(*cachedJournal); ok {
+				cj.conn.Destroy()
+			}
+		}),
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// GetVolume returns the volume with the CSI VolumeId.
+func (r *cachingResolver) GetVolume(
+	ctx context.Context,
+	id string,
+	cr *util.Credentials,
+	secrets map[string]string,
+) (*rbdVolume, error) {
+	if isMigrationVolID(id) {
+		return getMigratedVolume(ctx, id, cr)
+	}
+
+	volume, err := GenVolFromVolID(ctx, id, cr, secrets)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrImageNotFound):
+			return nil, fmt.Errorf("volume %s not found: %w", id, err)
+		case errors.Is(err, ErrPoolDeleted):
+			return nil, fmt.Errorf("pool of volume %s was deleted: %w", id, err)
+		case errors.Is(err, util.ErrPoolNotFound):
+			return nil, fmt.Errorf("pool %s not found for %s: %w", volume.Pool, id, err)
+		default:
+			return nil, fmt.Errorf("failed to get volume from id %q: %w", id, err)
+		}
+	}
+
+	return volume, nil
+}
+
+// GetSnapshot returns the snapshot with the CSI SnapshotId.
+func (r *cachingResolver) GetSnapshot(
+	ctx context.Context,
+	id string,
+	cr *util.Credentials,
+	secrets map[string]string,
+) (*rbdSnapshot, error) {
+	snapshot, err := genSnapFromSnapID(ctx, id, cr, secrets)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrImageNotFound):
+			return nil, fmt.Errorf("snapshot %s not found: %w", id, err)
+		case errors.Is(err, ErrPoolDeleted):
+			return nil, fmt.Errorf("pool of snapshot %s was deleted: %w", id, err)
+		case errors.Is(err, util.ErrPoolNotFound):
+			return nil, fmt.Errorf("pool not found for snapshot %s: %w", id, err)
+		default:
+			return nil, fmt.Errorf("failed to get snapshot from id %q: %w", id, err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// Invalidate drops the cached pools of the volume or snapshot with the CSI
+// ID.
+func (r *cachingResolver) Invalidate(id string) {
+	if id != "" {
+		r.cache.Remove(id)
+	}
+}
+
+// lookup returns the cached pools of the ID, when they were resolved for the
+// same cluster and pool and did not expire yet.
+func (r *cachingResolver) lookup(id string, vi util.CSIIdentifier) *resolvedPools {
+	value, ok := r.cache.Get(id)
+	if !ok {
+		return nil
+	}
+
+	rp, ok := value.(*resolvedPools)
+	if !ok || rp.clusterID != vi.ClusterID || rp.locationID != vi.LocationID {
+		return nil
+	}
+
+	if r.now().After(rp.expires) {
+		r.cache.Remove(id)
+
+		return nil
+	}
+
+	return rp
+}
+
+// remember caches the pools of the ID.
+func (r *cachingResolver) remember(id string, rp *resolvedPools) {
+	rp.expires = r.now().Add(r.ttl)
+	r.cache.Add(id, rp)
+}
+
+// journalKey identifies a journal connection by the instance of the driver,
+// the cluster, namespace and user, the key of the user is hashed as the key
+// file of the credentials is different for every request.
+func journalKey(instance, monitors, namespace string, cr *util.Credentials, snapSource bool) (string, error) {
+	key, err := os.ReadFile(cr.KeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file %q: %w", cr.KeyFile, err)
+	}
+
+	return fmt.Sprintf("%s|%s|%s|%s|%x|%t",
+		instance, monitors, namespace, cr.ID, sha256.Sum256(key), snapSource), nil
+}
+
+// connectJournal returns a connection to the volume or snapshot journal, a
+// connection that was opened before for the same cluster, namespace and user
+// is reused. The returned connection must not be destroyed by the caller.
+func (r *cachingResolver) connectJournal(
+	ctx context.Context,
+	monitors, namespace string,
+	cr *util.Credentials,
+	snapSource bool,
+) (*journal.Connection, error) {
+	ij := journalsOf(ctx)
+	key, err := journalKey(ij.instance, monitors, namespace, cr, snapSource)
+	if err != nil {
+		return nil, err
+	}
+
+	if value, ok := r.journals.Get(key); ok {
+		if cj, ok := value.(*cachedJournal); ok && !r.now().After(cj.expires) {
+			return cj.conn, nil
+		}
+		r.journals.Remove(key)
+	}
+
+	j := ij.vol
+	if snapSource {
+		j = ij.snap
+	}
+
+	conn, err := j.Connect(monitors, namespace, cr)
+	if err != nil {
+		return nil, err
+	}
+	r.journals.Add(key, &cachedJournal{conn: conn, expires: r.now().Add(r.ttl)})
+
+	return conn, nil
+}
+
+// resolveImage reads the journal attributes of the ID from the journal, and
+// finds the pools of the ID, or returns them from the cache.
+func (r *cachingResolver) resolveImage(
+	ctx context.Context,
+	id string,
+	vi util.CSIIdentifier,
+	monitors, namespace string,
+	cr *util.Credentials,
+	snapSource bool,
+) (*resolvedImage, error) {
+	j, err := r.connectJournal(ctx, monitors, namespace, cr, snapSource)
+	if err != nil {
+		return &resolvedImage{}, err
+	}
+
+	ri := &resolvedImage{}
+
+	rp := r.lookup(id, vi)
+	if rp != nil {
+		log.TraceLog(ctx, "resolved pool %q of %q from cache", rp.pool, id)
+		ri.pool = rp.pool
+	} else {
+		ri.pool, err = util.GetPoolName(monitors, cr, vi.LocationID)
+		if errors.Is(err, util.ErrPoolNotFound) {
+			return ri, fmt.Errorf("%w: %w", ErrPoolDeleted, err)
+		} else if err != nil {
+			return ri, err
+		}
+	}
+	ri.journalPool = ri.pool
+
+	attrs, err := j.GetImageAttributes(ctx, ri.pool, vi.ObjectUUID, snapSource)
+	if err != nil {
+		// the pool may have been deleted since it was cached
+		r.Invalidate(id)
+
+		return ri, err
+	}
+	ri.attrs = *attrs
+
+	// convert the journal pool ID to name, for use in DeleteVolume and
+	// DeleteSnapshot cases
+	if attrs.JournalPoolID != util.InvalidPoolID {
+		if rp != nil && rp.journalPoolID == attrs.JournalPoolID {
+			ri.journalPool = rp.journalPool
+		} else {
+			ri.journalPool, err = util.GetPoolName(monitors, cr, attrs.JournalPoolID)
+			if err != nil {
+				return ri, err
+			}
+		}
+	}
+
+	if rp == nil || rp.journalPoolID != attrs.JournalPoolID {
+		r.remember(id, &resolvedPools{
+			clusterID:     vi.ClusterID,
+			locationID:    vi.LocationID,
+			pool:          ri.pool,
+			journalPoolID: attrs.JournalPoolID,
+			journalPool:   ri.journalPool,
+		})
+	}
+
+	return ri, nil
+}
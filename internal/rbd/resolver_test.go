@@ -0,0 +1,101 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	r := newCachingResolver(2, time.Minute)
+	r.now = func() time.Time { return now }
+
+	vi := util.CSIIdentifier{ClusterID: "cluster-1", LocationID: 3}
+	r.remember("vol-1", &resolvedPools{clusterID: "cluster-1", locationID: 3, pool: "replicapool"})
+
+	ri := r.lookup("vol-1", vi)
+	require.NotNil(t, ri)
+	require.Equal(t, "replicapool", ri.pool)
+
+	// resolved for another cluster, like through a clusterID mapping
+	require.Nil(t, r.lookup("vol-1", util.CSIIdentifier{ClusterID: "cluster-2", LocationID: 3}))
+
+	r.Invalidate("vol-1")
+	require.Nil(t, r.lookup("vol-1", vi))
+
+	r.remember("vol-1", &resolvedPools{clusterID: "cluster-1", locationID: 3})
+	now = now.Add(2 * time.Minute)
+	require.Nil(t, r.lookup("vol-1", vi))
+
+	// the least recently used entry is dropped
+	r.remember("vol-1", &resolvedPools{clusterID: "cluster-1", locationID: 3})
+	r.remember("vol-2", &resolvedPools{clusterID: "cluster-1", locationID: 3})
+	r.remember("vol-3", &resolvedPools{clusterID: "cluster-1", locationID: 3})
+	require.Nil(t, r.lookup("vol-1", vi))
+	require.NotNil(t, r.lookup("vol-3", vi))
+}
+
+func TestJournalKey(t *testing.T) {
+	t.Parallel()
+
+	keyFile := func(key string) string {
+		path := filepath.Join(t.TempDir(), "keyfile")
+		require.NoError(t, os.WriteFile(path, []byte(key), 0o600))
+
+		return path
+	}
+
+	// the key file of the credentials differs for every request
+	key, err := journalKey("default", "mon-1", "ns", &util.Credentials{ID: "admin", KeyFile: keyFile("secret")}, false)
+	require.NoError(t, err)
+	same, err := journalKey("default", "mon-1", "ns", &util.Credentials{ID: "admin", KeyFile: keyFile("secret")}, false)
+	require.NoError(t, err)
+	require.Equal(t, key, same)
+	require.NotContains(t, key, "secret")
+
+	for _, other := range []struct {
+		instance  string
+		monitors  string
+		namespace string
+		cr        *util.Credentials
+		snap      bool
+	}{
+		{"cluster-b", "mon-1", "ns", &util.Credentials{ID: "admin", KeyFile: keyFile("secret")}, false},
+		{"default", "mon-2", "ns", &util.Credentials{ID: "admin", KeyFile: keyFile("secret")}, false},
+		{"default", "mon-1", "other", &util.Credentials{ID: "admin", KeyFile: keyFile("secret")}, false},
+		{"default", "mon-1", "ns", &util.Credentials{ID: "user", KeyFile: keyFile("secret")}, false},
+		{"default", "mon-1", "ns", &util.Credentials{ID: "admin", KeyFile: keyFile("rotated")}, false},
+		{"default", "mon-1", "ns", &util.Credentials{ID: "admin", KeyFile: keyFile("secret")}, true},
+	} {
+		got, err := journalKey(other.instance, other.monitors, other.namespace, other.cr, other.snap)
+		require.NoError(t, err)
+		require.NotEqual(t, key, got)
+	}
+
+	_, err = journalKey("default", "mon-1", "ns", &util.Credentials{ID: "admin", KeyFile: "/nonexistent"}, false)
+	require.Error(t, err)
+}
@@ -153,7 +153,7 @@ func (rbdSnap *rbdSnapshot) ToCSI(ctx context.Context) (*csi.Snapshot, error) {
 	}
 
 	return &csi.Snapshot{
-		SizeBytes:       rbdSnap.VolSize,
+		SizeBytes:       rbdSnap.usableSize(rbdSnap.VolSize),
 		SnapshotId:      rbdSnap.VolID,
 		SourceVolumeId:  rbdSnap.SourceVolumeID,
 		CreationTime:    timestamppb.New(*created),
@@ -220,6 +220,7 @@ func undoSnapshotCloning(
 //
 // Parameters:
 // - name of the new rbd-image backing the snapshot
+// - namePrefix of the new rbd-image, empty for the default prefix
 // - id of the rbd-snapshot to clone
 //
 // FIXME: When resolving the Snapshot, the RbdImageName will be set to the name
@@ -231,11 +232,12 @@ func undoSnapshotCloning(
 func (rv *rbdVolume) NewSnapshotByID(
 	ctx context.Context,
 	cr *util.Credentials,
-	name string,
+	name, namePrefix string,
 	id uint64,
 ) (types.Snapshot, error) {
 	snap := rv.toSnapshot()
 	snap.RequestName = name
+	snap.NamePrefix = namePrefix
 
 	srcVolID, err := rv.GetID(ctx)
 	if err != nil {
@@ -297,7 +299,9 @@ func (rv *rbdVolume) NewSnapshotByID(
 
 	log.DebugLog(ctx, "going to clone snapshot image %q from image %q with snapshot ID %d", snap, rv, id)
 
+	done := rv.conn.TrackCall("clone_image")
 	err = librbd.CloneImageByID(rv.ioctx, rv.RbdImageName, id, rv.ioctx, snap.RbdImageName, options)
+	done(err)
 	if err != nil && !errors.Is(err, librbd.ErrExist) {
 		log.ErrorLog(ctx, "failed to clone snapshot %q with id %d: %v", snap, id, err)
 
@@ -323,7 +327,7 @@ func (rv *rbdVolume) NewSnapshotByID(
 	}()
 
 	// update the snapshot image in the journal, after the image info is updated
-	j, err := snapJournal.Connect(snap.Monitors, snap.RadosNamespace, cr)
+	j, err := snapJournal(ctx).Connect(snap.Monitors, snap.RadosNamespace, cr)
 	if err != nil {
 		return nil, fmt.Errorf("snapshot image %q failed to connect to journal: %w", snap, err)
 	}
@@ -364,7 +368,7 @@ func (rbdSnap *rbdSnapshot) SetVolumeGroup(ctx context.Context, cr *util.Credent
 		return err
 	}
 
-	j, err := snapJournal.Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
 	if err != nil {
 		return fmt.Errorf("snapshot %q failed to connect to journal: %w", rbdSnap, err)
 	}
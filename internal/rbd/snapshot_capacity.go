@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkSnapshotPoolUsage returns ResourceExhausted when a snapshot of rbdVol
+// could push the usage of the pool over the configured threshold. The data
+// that is currently allocated by the image is what the snapshot keeps
+// referenced once the image is overwritten, so that is the projected growth.
+// The usage of the data pool is checked for images that have one.
+func (cs *ControllerServer) checkSnapshotPoolUsage(ctx context.Context, rbdVol *rbdVolume) error {
+	if cs.SnapshotPoolUsageThreshold == 0 {
+		return nil
+	}
+
+	image, err := rbdVol.open()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer image.Close()
+
+	used, err := getUsedBytes(image)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	// the data of images with a data pool, like erasure coded pools, is
+	// stored in the data pool
+	pool := rbdVol.DataPool
+	if pool == "" {
+		pool, err = rbdVol.getDataPool(ctx, image)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	pu, err := rbdVol.conn.GetPoolUsage(pool)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	err = pu.CheckThreshold(used, cs.SnapshotPoolUsageThreshold)
+	if errors.Is(err, util.ErrPoolUsageExceeded) {
+		log.ErrorLog(ctx, "not creating snapshot of %q: %v", rbdVol, err)
+
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	return err
+}
+
+// getDataPool returns the data pool of the image, or the pool of the image
+// when it does not have a separate data pool. go-ceph does not return the data
+// pool of an image, it is read with the rbd CLI.
+func (rv *rbdVolume) getDataPool(ctx context.Context, image *librbd.Image) (string, error) {
+	features, err := image.GetFeatures()
+	if err != nil {
+		return "", fmt.Errorf("failed to get features of image %s: %w", rv, err)
+	}
+	if features&librbd.FeatureDataPool == 0 {
+		return rv.Pool, nil
+	}
+
+	stdout, stderr, err := util.ExecCommand(
+		ctx,
+		rbd,
+		"info",
+		rv.String(),
+		"--format=json",
+		"--id", rv.conn.Creds.ID,
+		"-m", rv.Monitors,
+		"--keyfile="+rv.conn.Creds.KeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to get info of image %s: %w (%s)", rv, err, stderr)
+	}
+
+	info := struct {
+		DataPool string `json:"data_pool"`
+	}{}
+	err = json.Unmarshal([]byte(stdout), &info)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse info of image %s: %w", rv, err)
+	}
+	if info.DataPool == "" {
+		return rv.Pool, nil
+	}
+
+	return info.DataPool, nil
+}
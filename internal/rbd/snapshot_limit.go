@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkSnapshotCount returns ResourceExhausted when rbdVol already has the
+// limit of snapshots that is configured with the driver flag or the
+// VolumeSnapshotClass parameters, a limit of 0 is not checked. The caller
+// holds the lock of the source volume until the new snapshot is tracked, so
+// that concurrent requests can not exceed the limit.
+func checkSnapshotCount(
+	ctx context.Context,
+	rbdVol *rbdVolume,
+	limit uint,
+	cr *util.Credentials,
+) error {
+	if limit == 0 {
+		return nil
+	}
+
+	j, err := snapJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer j.Destroy()
+
+	count, err := countSnapshots(ctx, j, rbdVol)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if count >= int(limit) {
+		log.ErrorLog(ctx, "volume %q has %d snapshots, the limit is %d", rbdVol, count, limit)
+
+		return status.Errorf(codes.ResourceExhausted,
+			"volume %q has reached the limit of %d snapshots, delete snapshots before creating new ones",
+			rbdVol.VolID, limit)
+	}
+
+	return nil
+}
+
+// countSnapshots returns the number of snapshots of rbdVol. The snapshots
+// are clones of the image until they are flattened, clones that belong to a
+// snapshot of rbdVol in the journal but are not tracked yet, like the
+// snapshots that were created before the limit was introduced, are tracked
+// before they are counted.
+func countSnapshots(ctx context.Context, j *journal.Connection, rbdVol *rbdVolume) (int, error) {
+	refs, err := j.ListSnapshotRefs(ctx, rbdVol.JournalPool, rbdVol.RbdImageName)
+	if err != nil {
+		return 0, err
+	}
+
+	_, children, err := rbdVol.listSnapAndChildren()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list children of %q: %w", rbdVol, err)
+	}
+
+	untracked := map[string]string{}
+	for _, child := range children {
+		snapUUID, ok := parseSnapshotUUID(child)
+		if !ok {
+			continue
+		}
+		if _, tracked := refs[snapUUID]; !tracked {
+			untracked[snapUUID] = child
+		}
+	}
+	if len(untracked) == 0 {
+		return len(refs), nil
+	}
+
+	attrs, err := j.GetImageAttributesBatch(ctx, rbdVol.JournalPool, slices.Collect(maps.Keys(untracked)), true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get snapshots of %q: %w", rbdVol, err)
+	}
+
+	seed := map[string]string{}
+	for snapUUID, a := range attrs {
+		if a.SourceName != rbdVol.RbdImageName || a.ImageName != untracked[snapUUID] {
+			continue
+		}
+		seed[snapUUID] = a.RequestName
+	}
+	if len(seed) != 0 {
+		log.DebugLog(ctx, "tracking %d existing snapshots of volume %q", len(seed), rbdVol)
+
+		err = j.AddSnapshotRefs(ctx, rbdVol.JournalPool, rbdVol.RbdImageName, seed)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(refs) + len(seed), nil
+}
+
+// parseSnapshotUUID returns the UUID of the reservation at the end of the
+// name of a snapshot image. False is returned when name does not end with a
+// UUID.
+func parseSnapshotUUID(name string) (string, bool) {
+	if len(name) < uuidLength {
+		return "", false
+	}
+
+	snapUUID := name[len(name)-uuidLength:]
+	if _, err := uuid.Parse(snapUUID); err != nil {
+		return "", false
+	}
+
+	return snapUUID, true
+}
+
+// trackSnapshot records rbdSnap as a snapshot of the image sourceName, so
+// that it counts towards the snapshot limit of the volume.
+func trackSnapshot(ctx context.Context, rbdSnap *rbdSnapshot, sourceName string, cr *util.Credentials) error {
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	return j.AddSnapshotRef(ctx, rbdSnap.JournalPool, sourceName, rbdSnap.ReservedID, rbdSnap.RequestName)
+}
+
+// untrackSnapshot removes rbdSnap from the snapshots of the image sourceName.
+func untrackSnapshot(ctx context.Context, rbdSnap *rbdSnapshot, sourceName string, cr *util.Credentials) error {
+	j, err := snapJournal(ctx).Connect(rbdSnap.Monitors, rbdSnap.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	return j.RemoveSnapshotRef(ctx, rbdSnap.JournalPool, sourceName, rbdSnap.ReservedID)
+}
+
+// purgeSnapshotRefs removes the tracking of the snapshots of rbdVol once the
+// image is deleted.
+func purgeSnapshotRefs(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) error {
+	j, err := snapJournal(ctx).Connect(rbdVol.Monitors, rbdVol.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	return j.PurgeSnapshotRefs(ctx, rbdVol.JournalPool, rbdVol.RbdImageName)
+}
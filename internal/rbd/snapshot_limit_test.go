@@ -0,0 +1,45 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSnapshotUUID(t *testing.T) {
+	t.Parallel()
+
+	snapUUID, ok := parseSnapshotUUID("csi-snap-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11")
+	require.True(t, ok)
+	require.Equal(t, "0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11", snapUUID)
+
+	snapUUID, ok = parseSnapshotUUID("backup-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11")
+	require.True(t, ok)
+	require.Equal(t, "0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11", snapUUID)
+
+	invalid := []string{
+		"csi-vol-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11-temp",
+		"snap",
+		"csi-snap-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a1x",
+	}
+	for _, name := range invalid {
+		_, ok = parseSnapshotUUID(name)
+		require.False(t, ok, name)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	mount "k8s.io/mount-utils"
+)
+
+// StagedVolume is a volume with a filesystem that is staged on the node.
+type StagedVolume struct {
+	// VolumeID of the volume
+	VolumeID string
+	// StagingTargetPath is the staging path that the CO passed to
+	// NodeStageVolume, without the VolumeID.
+	StagingTargetPath string
+}
+
+// ListStagedVolumes returns the volumes of the driver that have a filesystem
+// mounted below stagingPath. Kubernetes 1.24+ stages volumes in
+// <stagingPath>/<driverName>/<hash>/globalmount, older versions in
+// <stagingPath>/pv/<name>/globalmount. Only the staging paths with an image
+// metadata stash are returned, the volume is mounted in a directory named
+// after its VolumeID. Block volumes are skipped.
+func ListStagedVolumes(mounter mount.Interface, stagingPath, driverName string) ([]StagedVolume, error) {
+	staged := []StagedVolume{}
+	for _, pattern := range []string{
+		filepath.Join(stagingPath, driverName, "*", "globalmount"),
+		filepath.Join(stagingPath, "pv", "*", "globalmount"),
+	} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list staging paths %q: %w", pattern, err)
+		}
+
+		for _, stagingTargetPath := range paths {
+			if !checkRBDImageMetadataStashExists(stagingTargetPath) {
+				continue
+			}
+
+			vol, err := findStagedVolume(mounter, stagingTargetPath)
+			if err != nil {
+				return nil, err
+			}
+			if vol != nil {
+				staged = append(staged, *vol)
+			}
+		}
+	}
+
+	return staged, nil
+}
+
+// findStagedVolume returns the volume with a filesystem mounted in the
+// stagingTargetPath, or nil if there is none.
+func findStagedVolume(mounter mount.Interface, stagingTargetPath string) (*StagedVolume, error) {
+	entries, err := os.ReadDir(stagingTargetPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging path %q: %w", stagingTargetPath, err)
+	}
+
+	for _, entry := range entries {
+		// block volumes are staged as a file
+		if !entry.IsDir() {
+			continue
+		}
+
+		notMnt, err := mounter.IsLikelyNotMountPoint(filepath.Join(stagingTargetPath, entry.Name()))
+		if err != nil || notMnt {
+			continue
+		}
+
+		return &StagedVolume{
+			VolumeID:          entry.Name(),
+			StagingTargetPath: stagingTargetPath,
+		}, nil
+	}
+
+	return nil, nil
+}
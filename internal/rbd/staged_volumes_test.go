@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	mount "k8s.io/mount-utils"
+)
+
+func TestListStagedVolumes(t *testing.T) {
+	t.Parallel()
+
+	stagingPath := t.TempDir()
+	mounter := mount.NewFakeMounter(nil)
+
+	// stage creates the staging path with an image metadata stash, the
+	// volume is mounted when mounted is set
+	stage := func(dir, volumeID string, mounted bool) string {
+		stagingTargetPath := filepath.Join(stagingPath, dir, "globalmount")
+		require.NoError(t, os.MkdirAll(filepath.Join(stagingTargetPath, volumeID), 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(stagingTargetPath, stashFileName), []byte("{}"), 0o600))
+		if mounted {
+			mounter.MountPoints = append(mounter.MountPoints, mount.MountPoint{
+				Device: "/dev/rbd0",
+				Path:   filepath.Join(stagingTargetPath, volumeID),
+			})
+		}
+
+		return stagingTargetPath
+	}
+
+	current := stage("rbd.csi.ceph.com/0123", "vol-1", true)
+	legacy := stage("pv/pvc-1", "vol-2", true)
+	stage("rbd.csi.ceph.com/4567", "vol-3", false)
+	stage("other.csi.ceph.com/89ab", "vol-4", true)
+
+	// a staging path without stash is not an RBD volume
+	cephfs := filepath.Join(stagingPath, "rbd.csi.ceph.com", "cdef", "globalmount", "vol-5")
+	require.NoError(t, os.MkdirAll(cephfs, 0o750))
+	mounter.MountPoints = append(mounter.MountPoints, mount.MountPoint{Path: cephfs})
+
+	staged, err := ListStagedVolumes(mounter, stagingPath, "rbd.csi.ceph.com")
+	require.NoError(t, err)
+	require.Equal(t, []StagedVolume{
+		{VolumeID: "vol-1", StagingTargetPath: current},
+		{VolumeID: "vol-2", StagingTargetPath: legacy},
+	}, staged)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// stretchTopologyDomainParam is the topology domain, like "zone", in
+	// which the sites of a cluster in stretch mode are reported. It needs
+	// to be one of the --domainlabels of the nodeplugin.
+	stretchTopologyDomainParam = "stretchTopologyDomain"
+	// stretchSitesParam maps the CRUSH buckets of the sites to values of the
+	// topology domain, like "site1=zone-a,site2=zone-b". Sites that are not
+	// mapped are reported with the name of their bucket.
+	stretchSitesParam = "stretchSites"
+)
+
+// setStretchTopology restricts the accessible topology of a new read-write
+// volume to the site that holds the primary OSDs of its pool, when the
+// cluster runs in stretch mode. Pods using the volume are then scheduled in
+// the site that serves the IO of the volume. Volumes that have a topology
+// from topologyConstrainedPools already are not modified. InvalidArgument is
+// returned when the site is not in the requisite topologies of the request.
+func (cs *ControllerServer) setStretchTopology(
+	ctx context.Context,
+	rbdVol *rbdVolume,
+	req *csi.CreateVolumeRequest,
+) error {
+	domain := req.GetParameters()[stretchTopologyDomainParam]
+	if domain == "" || rbdVol.Topology != nil || csicommon.IsReaderOnly(req.GetVolumeCapabilities()) {
+		return nil
+	}
+
+	siteValues, err := parseStretchSites(req.GetParameters()[stretchSitesParam])
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	site, err := rbdVol.conn.GetStretchPrimarySite(rbdVol.Pool)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get the primary site of pool %q: %v", rbdVol.Pool, err)
+	}
+	if site == "" {
+		return nil
+	}
+
+	value, ok := siteValues[site]
+	if !ok {
+		value = site
+	}
+	key := util.TopologyKey(cs.Driver.GetName(), domain)
+	if !isRequisiteTopology(req.GetAccessibilityRequirements(), key, value) {
+		return status.Errorf(codes.InvalidArgument,
+			"primary OSDs of pool %q are in site %q, which is not in the requisite topology (%s=%s)",
+			rbdVol.Pool, site, key, value)
+	}
+	rbdVol.Topology = map[string]string{key: value}
+	log.DebugLog(ctx, "primary OSDs of pool %q are in site %q, using topology %v", rbdVol.Pool, site, rbdVol.Topology)
+
+	return nil
+}
+
+// isRequisiteTopology returns true when a segment key=value is allowed by the
+// requisite topologies of the request. A requisite topology that does not
+// contain the key does not restrict it. Requests without requisite
+// topologies allow all segments.
+func isRequisiteTopology(requirements *csi.TopologyRequirement, key, value string) bool {
+	requisite := requirements.GetRequisite()
+	if len(requisite) == 0 {
+		return true
+	}
+
+	for _, topology := range requisite {
+		v, ok := topology.GetSegments()[key]
+		if !ok || v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseStretchSites parses the stretchSitesParam.
+func parseStretchSites(param string) (map[string]string, error) {
+	sites := map[string]string{}
+	if param == "" {
+		return sites, nil
+	}
+
+	for _, entry := range strings.Split(param, ",") {
+		site, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || site == "" || value == "" {
+			return nil, fmt.Errorf("invalid entry %q in %s, expected <site>=<value>", entry, stretchSitesParam)
+		}
+		sites[site] = value
+	}
+
+	return sites, nil
+}
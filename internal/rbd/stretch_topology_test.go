@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStretchSites(t *testing.T) {
+	t.Parallel()
+
+	sites, err := parseStretchSites("")
+	require.NoError(t, err)
+	require.Empty(t, sites)
+
+	sites, err = parseStretchSites("site1=zone-a, site2=zone-b")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"site1": "zone-a", "site2": "zone-b"}, sites)
+
+	_, err = parseStretchSites("site1")
+	require.Error(t, err)
+
+	_, err = parseStretchSites("site1=")
+	require.Error(t, err)
+}
+
+func TestIsRequisiteTopology(t *testing.T) {
+	t.Parallel()
+
+	key := "topology.rbd.csi.ceph.com/zone"
+	require.True(t, isRequisiteTopology(nil, key, "zone-a"))
+
+	requirements := &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{Segments: map[string]string{key: "zone-a"}},
+			{Segments: map[string]string{key: "zone-c"}},
+		},
+	}
+	require.True(t, isRequisiteTopology(requirements, key, "zone-a"))
+	require.False(t, isRequisiteTopology(requirements, key, "zone-b"))
+
+	requirements.Requisite = append(requirements.Requisite,
+		&csi.Topology{Segments: map[string]string{"topology.rbd.csi.ceph.com/region": "east"}})
+	require.True(t, isRequisiteTopology(requirements, key, "zone-b"))
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// tempCloneSuffix is appended to the name of a volume for the temporary
+	// clone that is created while cloning the volume, see
+	// generateTempClone().
+	tempCloneSuffix = "-temp"
+
+	// uuidLength is the length of the UUID at the end of an image name.
+	uuidLength = 36
+)
+
+// tempCloneReaper deletes the temporary clones that were left behind by
+// failed clone operations.
+type tempCloneReaper struct {
+	driverName string
+	interval   time.Duration
+	ttl        time.Duration
+
+	reaped *prometheus.CounterVec
+}
+
+// StartTempCloneReaper deletes the temporary clones in the pools of the
+// journals of the StorageClasses of the driver at the interval. A temporary
+// clone is only deleted when it is older than ttl, its volume does not exist
+// and the reservation of the volume is gone or was not refreshed within ttl.
+// Only the replica of the provisioner that holds the
+// `<drivername>-temp-clone-reaper` lease in namespace deletes images.
+func StartTempCloneReaper(driverName, namespace string, interval, ttl time.Duration) error {
+	if ttl < journal.StaleReservationTimeout {
+		return fmt.Errorf("the TTL of temporary clones (%s) must be at least %s", ttl, journal.StaleReservationTimeout)
+	}
+
+	r := &tempCloneReaper{
+		driverName: driverName,
+		interval:   interval,
+		ttl:        ttl,
+		reaped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "csi",
+			Subsystem: "rbd",
+			Name:      "temp_clones_reaped_total",
+			Help: "Number of orphaned temporary clones that were deleted (result=deleted) or failed to " +
+				"delete (result=failed)",
+		}, []string{"result"}),
+	}
+	err := prometheus.Register(r.reaped)
+	if err != nil {
+		return fmt.Errorf("failed to register temporary clone reaper metrics: %w", err)
+	}
+
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	return kubeclient.StartElected(client, namespace, driverName+"-temp-clone-reaper", r.run)
+}
+
+func (r *tempCloneReaper) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *tempCloneReaper) reap(ctx context.Context) {
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+		return
+	}
+
+	sources, err := getListVolumesSources(ctx, client, r.driverName)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", r.driverName, err)
+
+		return
+	}
+
+	for _, source := range sources {
+		err = r.reapSource(ctx, client, source)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to reap temporary clones for pool %q of cluster %q: %v",
+				source.JournalPool, source.ClusterID, err)
+		}
+	}
+}
+
+// reapSource deletes the orphaned temporary clones in the journal pool of the
+// source, and in the pools of the images of its reservations.
+func (r *tempCloneReaper) reapSource(ctx context.Context, client *k8s.Clientset, source *listVolumesSource) error {
+	lc, err := connectListVolumesSource(ctx, client, source)
+	if err != nil {
+		return err
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, source.JournalPool, "", 0)
+	if err != nil {
+		return err
+	}
+
+	pools := []string{source.JournalPool}
+	for _, res := range reservations {
+		if res.ImagePoolID == util.InvalidPoolID {
+			continue
+		}
+		pool, pErr := util.GetPoolName(lc.monitors, lc.cr, res.ImagePoolID)
+		if pErr != nil {
+			return pErr
+		}
+		if !slices.Contains(pools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+
+	for _, pool := range pools {
+		err = r.reapPool(ctx, lc, pool)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reapPool deletes the orphaned temporary clones in the pool. A failure to
+// delete a single clone is logged and counted, the other clones are still
+// processed.
+func (r *tempCloneReaper) reapPool(ctx context.Context, lc *listVolumesConnection, pool string) error {
+	ri := &rbdImage{
+		Monitors:       lc.monitors,
+		Pool:           pool,
+		RadosNamespace: lc.radosNamespace,
+		ClusterID:      lc.source.ClusterID,
+	}
+	err := ri.Connect(lc.cr)
+	if err != nil {
+		return err
+	}
+	defer ri.Destroy(ctx)
+
+	err = ri.openIoctx()
+	if err != nil {
+		return err
+	}
+
+	names, err := librbd.GetImageNames(ri.ioctx)
+	if err != nil {
+		return fmt.Errorf("failed to list images in pool %q: %w", pool, err)
+	}
+
+	for _, name := range names {
+		baseName, objectUUID, ok := parseTempCloneName(name)
+		if !ok {
+			continue
+		}
+
+		tempClone := &rbdImage{
+			Monitors:       lc.monitors,
+			Pool:           pool,
+			RadosNamespace: lc.radosNamespace,
+			RbdImageName:   name,
+			ClusterID:      lc.source.ClusterID,
+		}
+		tempClone.conn = ri.conn.Copy()
+
+		deleted, rErr := r.reapTempClone(ctx, lc, tempClone, baseName, objectUUID, slices.Contains(names, baseName))
+		switch {
+		case rErr != nil:
+			log.ErrorLog(ctx, "failed to reap temporary clone %s: %v", tempClone, rErr)
+			r.reaped.WithLabelValues("failed").Inc()
+		case deleted:
+			log.DebugLog(ctx, "deleted orphaned temporary clone %s", tempClone)
+			r.reaped.WithLabelValues("deleted").Inc()
+		}
+		tempClone.Destroy(ctx)
+	}
+
+	return nil
+}
+
+// reapTempClone deletes the temporary clone with its snapshots, when it is
+// orphaned. It returns true when the clone was deleted.
+func (r *tempCloneReaper) reapTempClone(
+	ctx context.Context,
+	lc *listVolumesConnection,
+	tempClone *rbdImage,
+	baseName, objectUUID string,
+	baseExists bool,
+) (bool, error) {
+	if baseExists {
+		// the volume may still be a clone of the temporary clone
+		return false, nil
+	}
+
+	attrs, err := lc.journal.GetImageAttributes(ctx, tempClone.Pool, objectUUID, false)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		attrs = nil
+	} else if err != nil {
+		return false, err
+	}
+
+	created, err := tempClone.GetCreationTime(ctx)
+	if errors.Is(err, ErrImageNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if !isOrphanedTempClone(attrs, baseName, *created, r.ttl, time.Now()) {
+		return false, nil
+	}
+
+	removed, err := removeTempCloneSnapshots(ctx, tempClone, baseName)
+	if !removed || err != nil {
+		return false, err
+	}
+
+	err = tempClone.Delete(ctx)
+	if errors.Is(err, ErrImageNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// removeTempCloneSnapshots removes the snapshots of the temporary clone of
+// the volume baseName, so that the clone can be deleted. False is returned
+// when the image is not a temporary clone, or when it still has children.
+func removeTempCloneSnapshots(ctx context.Context, tempClone *rbdImage, baseName string) (bool, error) {
+	image, err := tempClone.open()
+	if errors.Is(err, ErrImageNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer image.Close()
+
+	if !isCSITempClone(image, baseName) {
+		return false, nil
+	}
+
+	_, children, err := image.ListChildren()
+	if err != nil {
+		return false, fmt.Errorf("failed to list children: %w", err)
+	}
+	if len(children) != 0 {
+		log.WarningLog(ctx, "temporary clone %s of missing volume %q has children %v, not deleting it",
+			tempClone, baseName, children)
+
+		return false, nil
+	}
+
+	snaps, err := image.GetSnapshotNames()
+	if err != nil {
+		return false, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, snap := range snaps {
+		err = image.GetSnapshot(snap.Name).Remove()
+		if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+			return false, fmt.Errorf("failed to remove snapshot %q: %w", snap.Name, err)
+		}
+	}
+
+	return true, nil
+}
+
+// parseTempCloneName returns the name of the volume and the UUID of its
+// reservation for the name of a temporary clone. False is returned when name
+// is not the name of a temporary clone of a volume.
+func parseTempCloneName(name string) (string, string, bool) {
+	baseName, ok := strings.CutSuffix(name, tempCloneSuffix)
+	if !ok || len(baseName) < uuidLength {
+		return "", "", false
+	}
+
+	objectUUID := baseName[len(baseName)-uuidLength:]
+	if _, err := uuid.Parse(objectUUID); err != nil {
+		return "", "", false
+	}
+
+	return baseName, objectUUID, true
+}
+
+// isOrphanedTempClone returns true when a temporary clone of the volume
+// baseName that was created at the given time is left behind. The clone must
+// be older than ttl, and the reservation of the volume (attrs) must be gone,
+// belong to another image, or be pending without a heartbeat within ttl. The
+// caller checks that the volume itself does not exist.
+func isOrphanedTempClone(
+	attrs *journal.ImageAttributes,
+	baseName string,
+	created time.Time,
+	ttl time.Duration,
+	now time.Time,
+) bool {
+	if now.Sub(created) < ttl {
+		return false
+	}
+
+	switch {
+	case attrs == nil:
+		return true
+	case attrs.ImageName != baseName:
+		return true
+	case attrs.ReservationPending():
+		return now.Sub(*attrs.ReservedAt) > ttl
+	}
+
+	return false
+}
+
+// isCSITempClone returns true when the image looks like a temporary clone
+// that was created by generateTempClone(): it carries the snapshot for the
+// volume baseName, or it is a clone of a snapshot with its own name.
+func isCSITempClone(image *librbd.Image, baseName string) bool {
+	snaps, err := image.GetSnapshotNames()
+	if err == nil && slices.ContainsFunc(snaps, func(snap librbd.SnapInfo) bool {
+		return snap.Name == baseName
+	}) {
+		return true
+	}
+
+	parent, err := image.GetParent()
+
+	return err == nil && parent.Snap.SnapName == image.GetName()
+}
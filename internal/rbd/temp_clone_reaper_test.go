@@ -0,0 +1,64 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTempCloneName(t *testing.T) {
+	t.Parallel()
+
+	baseName, objectUUID, ok := parseTempCloneName("csi-vol-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11-temp")
+	require.True(t, ok)
+	require.Equal(t, "csi-vol-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11", baseName)
+	require.Equal(t, "0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11", objectUUID)
+
+	invalid := []string{
+		"csi-vol-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11",
+		"backup-temp",
+		"csi-vol-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a1x-temp",
+	}
+	for _, name := range invalid {
+		_, _, ok = parseTempCloneName(name)
+		require.False(t, ok, name)
+	}
+}
+
+func TestIsOrphanedTempClone(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	ttl := time.Hour
+	old := now.Add(-2 * ttl)
+	recent := now.Add(-time.Minute)
+	baseName := "csi-vol-0b9a4c6a-5b1c-4f0e-9c3e-2f5d0e4c7a11"
+
+	require.False(t, isOrphanedTempClone(nil, baseName, recent, ttl, now))
+	require.True(t, isOrphanedTempClone(nil, baseName, old, ttl, now))
+	require.True(t, isOrphanedTempClone(&journal.ImageAttributes{ImageName: "csi-vol-other"}, baseName, old, ttl, now))
+	require.False(t, isOrphanedTempClone(&journal.ImageAttributes{ImageName: baseName}, baseName, old, ttl, now))
+	require.False(t, isOrphanedTempClone(
+		&journal.ImageAttributes{ImageName: baseName, ReservedAt: &recent}, baseName, old, ttl, now))
+	require.True(t, isOrphanedTempClone(
+		&journal.ImageAttributes{ImageName: baseName, ReservedAt: &old}, baseName, old, ttl, now))
+}
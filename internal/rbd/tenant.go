@@ -0,0 +1,118 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/ceph/ceph-csi/api/deploy/kubernetes"
+	"github.com/ceph/ceph-csi/internal/util"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tenantCreateParameters returns the parameters and secrets for a new volume
+// of a PVC in a Kubernetes namespace that is mapped to a tenant in the
+// configuration of the clusterID of the StorageClass. The volume is created
+// with the clusterID and the Ceph user of the tenant instead, so that it can
+// only be placed in the RADOS namespace of the tenant. The parameters and
+// secrets are returned unmodified when the namespace is not mapped.
+func tenantCreateParameters(
+	ctx context.Context,
+	parameters, secrets map[string]string,
+) (map[string]string, map[string]string, error) {
+	clusterID, err := util.GetClusterID(parameters)
+	if err != nil {
+		// validated later on
+		return parameters, secrets, nil
+	}
+
+	namespace := kubeclient.GetOwner(parameters)
+	tenant, err := util.GetRBDTenant(util.CsiConfigFile, clusterID, namespace)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if tenant == nil {
+		return parameters, secrets, nil
+	}
+
+	secrets, err = getTenantSecrets(tenant, secrets)
+	if err != nil {
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+
+	parameters = maps.Clone(parameters)
+	parameters[util.ClusterIDKey] = tenant.ClusterID
+	log.DebugLog(ctx, "using clusterID %q and the Ceph user in secret %s/%s for PVC namespace %q",
+		tenant.ClusterID, tenant.SecretNamespace, tenant.SecretName, namespace)
+
+	return parameters, secrets, nil
+}
+
+// tenantSecrets returns the secrets with the Ceph user of the tenant that
+// uses the clusterID encoded in csiID, so that operations on existing
+// volumes and snapshots of a tenant are confined to the RADOS namespace of
+// the tenant as well. The secrets are returned unmodified when the clusterID
+// is not used by a tenant, or csiID can not be decoded. Only the provisioner
+// reads the Secrets of the tenants, the nodeplugin uses the node stage secrets
+// that the CO passes.
+func tenantSecrets(ctx context.Context, csiID string, secrets map[string]string) (map[string]string, error) {
+	var vi util.CSIIdentifier
+	if err := vi.DecomposeCSIID(csiID); err != nil {
+		// migrated volumes and invalid IDs are handled by the caller
+		return secrets, nil
+	}
+
+	tenant, err := util.GetRBDTenantByClusterID(util.CsiConfigFile, vi.ClusterID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if tenant == nil {
+		return secrets, nil
+	}
+
+	secrets, err = getTenantSecrets(tenant, secrets)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.DebugLog(ctx, "using the Ceph user in secret %s/%s for %q of clusterID %q",
+		tenant.SecretNamespace, tenant.SecretName, csiID, vi.ClusterID)
+
+	return secrets, nil
+}
+
+// getTenantSecrets replaces the Ceph user in secrets with the one from the
+// Secret of the tenant.
+func getTenantSecrets(tenant *kubernetes.Tenant, secrets map[string]string) (map[string]string, error) {
+	c, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	credentials, err := getSecret(c, tenant.SecretNamespace, tenant.SecretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s of tenant with clusterID %q: %w",
+			tenant.SecretNamespace, tenant.SecretName, tenant.ClusterID, err)
+	}
+
+	return util.ReplaceCredentials(secrets, credentials), nil
+}
@@ -18,10 +18,12 @@ package types
 
 import (
 	"context"
+	"time"
 
 	"github.com/ceph/go-ceph/rados"
 	"github.com/csi-addons/spec/lib/go/volumegroup"
 
+	"github.com/ceph/ceph-csi/internal/journal"
 	"github.com/ceph/ceph-csi/internal/util"
 )
 
@@ -39,6 +41,10 @@ type journalledObject interface {
 	GetClusterID(ctx context.Context) (string, error)
 }
 
+// SnapshotNamePrefixFunc returns the name prefix for the snapshot of the
+// image at index in the group snapshot.
+type SnapshotNamePrefixFunc func(image string, index int) (string, error)
+
 // VolumeGroup contains a number of volumes.
 type VolumeGroup interface {
 	journalledObject
@@ -71,6 +77,54 @@ type VolumeGroup interface {
 
 	// CreateSnapshots creates Snapshots of all Volume in the VolumeGroup.
 	// The Snapshots are crash consistent, and created as a consistency
-	// group.
-	CreateSnapshots(ctx context.Context, cr *util.Credentials, name string) ([]Snapshot, error)
+	// group. The name prefix of the Snapshots is returned by namePrefix,
+	// the default prefix is used when namePrefix is nil.
+	CreateSnapshots(
+		ctx context.Context,
+		cr *util.Credentials,
+		name string,
+		namePrefix SnapshotNamePrefixFunc,
+	) ([]Snapshot, error)
+
+	// RollbackToSnapshot reverts all Volumes in the VolumeGroup to the
+	// group snapshot with the given name, in a single operation. The
+	// Volumes must not be in use, and the group snapshot must contain
+	// exactly the Volumes that are in the VolumeGroup now.
+	RollbackToSnapshot(ctx context.Context, name string) error
+
+	// ResumableSnapshots returns true when an interrupted CreateSnapshots
+	// left a group snapshot behind that was started at notBefore or later,
+	// the next CreateSnapshots resumes from it. An older group snapshot is
+	// removed, so that CreateSnapshots takes a new one.
+	ResumableSnapshots(ctx context.Context, notBefore time.Time) (bool, error)
+
+	// RepairMembers makes the volumes in the backend storage group match
+	// the Volumes of the VolumeGroup in the journal, after an interrupted
+	// AddVolume or RemoveVolume. The caller needs to hold the lock of the
+	// VolumeGroup, so that no AddVolume or RemoveVolume runs concurrently.
+	RepairMembers(ctx context.Context) error
+
+	// GetFailoverMarker returns the marker of an interrupted promote or
+	// demote of the Volumes in the VolumeGroup, nil if there is none.
+	GetFailoverMarker(ctx context.Context) (*journal.FailoverMarker, error)
+
+	// SetFailoverMarker records the promote or demote of the Volumes in
+	// the VolumeGroup that is in progress.
+	SetFailoverMarker(ctx context.Context, marker *journal.FailoverMarker) error
+
+	// ClearFailoverMarker removes the marker once the promote or demote of
+	// all Volumes in the VolumeGroup is done.
+	ClearFailoverMarker(ctx context.Context) error
+
+	// ToMirror converts the VolumeGroup to a Mirror, to manage the
+	// mirroring of all Volumes in the VolumeGroup as a whole.
+	ToMirror() (Mirror, error)
+
+	// GetMirroringState returns the mirroring of the VolumeGroup as it is
+	// recorded in the journal, nil if the VolumeGroup is not mirrored.
+	GetMirroringState(ctx context.Context) (*journal.MirroringState, error)
+
+	// ClearResync removes the resync that was requested for the
+	// VolumeGroup from the journal, once the VolumeGroup is resynced.
+	ClearResync(ctx context.Context) error
 }
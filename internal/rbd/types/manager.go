@@ -24,12 +24,22 @@ import (
 type VolumeResolver interface {
 	// GetVolumeByID uses the CSI VolumeId to resolve the returned Volume.
 	GetVolumeByID(ctx context.Context, id string) (Volume, error)
+
+	// PrefetchVolumes reads the journal of the volumes with the CSI
+	// VolumeIds in batches. The returned context resolves these volumes
+	// with GetVolumeByID without reading their journal again.
+	PrefetchVolumes(ctx context.Context, ids []string) context.Context
 }
 
 // SnapshotResolver can be used to construct a Snapshot from a CSI SnapshotId.
 type SnapshotResolver interface {
 	// GetSnapshotByID uses the CSI SnapshotId to resolve the returned Snapshot.
 	GetSnapshotByID(ctx context.Context, id string) (Snapshot, error)
+
+	// PrefetchSnapshots reads the journal of the snapshots with the CSI
+	// SnapshotIds in batches. The returned context resolves these snapshots
+	// with GetSnapshotByID without reading their journal again.
+	PrefetchSnapshots(ctx context.Context, ids []string) context.Context
 }
 
 // Manager provides a way for other packages to get Volumes and VolumeGroups.
@@ -42,6 +42,8 @@ type Mirror interface {
 	EnableMirroring(ctx context.Context, mode librbd.ImageMirrorMode) error
 	// DisableMirroring disables mirroring on the resource with the option to force the operation
 	DisableMirroring(ctx context.Context, force bool) error
+	// GetMirroringMode returns the mode that is used for mirroring the resource
+	GetMirroringMode(ctx context.Context) (librbd.ImageMirrorMode, error)
 	// Promote promotes the resource to primary status with the option to force the operation
 	Promote(ctx context.Context, force bool) error
 	// ForcePromote promotes the resource to primary status with a timeout
@@ -56,6 +58,81 @@ type Mirror interface {
 	GetGlobalMirroringStatus(ctx context.Context) (GlobalStatus, error)
 	// AddSnapshotScheduling adds a snapshot scheduling to the resource
 	AddSnapshotScheduling(interval admin.Interval, startTime admin.StartTime) error
+	// GetSnapshotSchedules returns the snapshot schedules of the resource
+	GetSnapshotSchedules(ctx context.Context) ([]admin.ScheduleTerm, error)
+	// GetMirrorPeers returns the remote sites the resource can be mirrored to
+	GetMirrorPeers(ctx context.Context) ([]MirrorPeer, error)
+	// GetPoolMirroring returns the mirroring configuration of the pool of
+	// the resource
+	GetPoolMirroring(ctx context.Context) (*PoolMirroring, error)
+	// EnablePoolMirroring enables mirroring of individual images on the pool
+	// of the resource
+	EnablePoolMirroring(ctx context.Context) error
+	// MarkDivergenceSnapshotPending records in the journal, before a forced
+	// promotion, that a divergence snapshot of the resource is needed
+	MarkDivergenceSnapshotPending(ctx context.Context, cr *util.Credentials) error
+	// IsDivergenceSnapshotPending returns true when the resource was
+	// force-promoted, but the divergence snapshot was not created yet
+	IsDivergenceSnapshotPending(ctx context.Context, cr *util.Credentials) (bool, error)
+	// CreateDivergenceSnapshot snapshots the resource after a forced
+	// promotion, records the snapshot in the journal and clears the pending
+	// mark
+	CreateDivergenceSnapshot(ctx context.Context, cr *util.Credentials) (*DivergenceSnapshot, error)
+	// GetDivergenceSnapshots lists the snapshots that were taken when the
+	// resource was force-promoted
+	GetDivergenceSnapshots(ctx context.Context, cr *util.Credentials) ([]DivergenceSnapshot, error)
+}
+
+// DivergenceSnapshot is a snapshot that was taken when a resource was
+// force-promoted, it contains the data of the resource at the point where
+// the sites diverged.
+type DivergenceSnapshot struct {
+	// Name of the snapshot of the image
+	Name string `json:"name"`
+	// ID of the snapshot of the image
+	ID uint64 `json:"id"`
+	// Created is the time of the forced promotion
+	Created time.Time `json:"created"`
+}
+
+// FailoverDrill is a writable clone of a secondary image, that is used to
+// test a failover without promoting the image.
+type FailoverDrill struct {
+	// ImageName is the name of the clone, in the pool and RADOS namespace
+	// of the secondary image
+	ImageName string `json:"imageName"`
+	// SnapshotID is the ID of the mirror snapshot that was cloned
+	SnapshotID uint64 `json:"snapshotID"`
+	// SnapshotTime is the time the mirror snapshot was created
+	SnapshotTime time.Time `json:"snapshotTime"`
+}
+
+// PoolMirroring describes the mirroring configuration of a pool.
+type PoolMirroring struct {
+	// Pool is the name of the pool
+	Pool string
+	// Namespace is the RADOS namespace in the pool, Mode is the mirror
+	// mode of the namespace when it is set
+	Namespace string
+	// Mode is the mirror mode of the pool
+	Mode librbd.MirrorMode
+	// SiteName is the name of the local site
+	SiteName string
+	// Peers are the remote sites that are configured for the pool
+	Peers []MirrorPeer
+}
+
+// MirrorPeer describes a remote site that is configured as mirror peer of
+// the pool.
+type MirrorPeer struct {
+	// UUID identifies the peer configuration in the pool
+	UUID string
+	// SiteName is the name of the remote site
+	SiteName string
+	// MirrorUUID matches the GetMirrorUUID() of the SiteStatus of the peer
+	MirrorUUID string
+	// Direction of the mirroring with this peer, "rx", "tx" or "rx-tx"
+	Direction string
 }
 
 // MirrorImage is the interface for managing mirroring on an RBD image or group of images.
@@ -77,6 +154,8 @@ type GlobalStatus interface {
 	GetAllSitesStatus() []SiteStatus
 	// GetRemoteSiteStatus returns the status of the remote site
 	GetRemoteSiteStatus(ctx context.Context) (SiteStatus, error)
+	// GetRemoteSitesStatus returns the status of every remote site
+	GetRemoteSitesStatus() []SiteStatus
 }
 
 // SiteStatus is the interface for fetching the status of a site.
@@ -27,7 +27,9 @@ import (
 
 type snapshottableVolume interface {
 	// NewSnapshotByID creates a new Snapshot object based on the details of the Volume.
-	NewSnapshotByID(ctx context.Context, cr *util.Credentials, name string, id uint64) (Snapshot, error)
+	// The name of the new image starts with namePrefix, or the default
+	// prefix when namePrefix is empty.
+	NewSnapshotByID(ctx context.Context, cr *util.Credentials, name, namePrefix string, id uint64) (Snapshot, error)
 
 	// PrepareVolumeForSnapshot prepares the volume for snapshot by
 	// checking snapshots limit and clone depth limit and flatten it
@@ -46,7 +48,9 @@ type csiAddonsVolume interface {
 	RotateEncryptionKey(ctx context.Context) error
 
 	// Sparsify tries to free unused blocks of the volume from the CSI-Addons Controller.
-	Sparsify(ctx context.Context) error
+	// It stops when ctx is done, the returned result contains the progress
+	// that was made until then.
+	Sparsify(ctx context.Context) (*SparsifyResult, error)
 
 	// HandleParentImageExistence checks the image's parent.
 	// if the parent image does not exist and is not in trash, it returns nil.
@@ -60,6 +64,15 @@ type csiAddonsVolume interface {
 
 	// ToMirror converts the Volume to a Mirror.
 	ToMirror() (Mirror, error)
+
+	// StartFailoverDrill clones the last synchronized mirror snapshot of the
+	// secondary Volume into a writable image, and records the clone in the
+	// journal. The mirroring of the Volume is not changed.
+	StartFailoverDrill(ctx context.Context, cr *util.Credentials) (*FailoverDrill, error)
+	// GetFailoverDrill returns the clone of StartFailoverDrill, or nil.
+	GetFailoverDrill(ctx context.Context, cr *util.Credentials) (*FailoverDrill, error)
+	// StopFailoverDrill removes the clone of StartFailoverDrill.
+	StopFailoverDrill(ctx context.Context, cr *util.Credentials) error
 }
 
 type Volume interface {
@@ -83,4 +96,21 @@ type Volume interface {
 	GetMetadata(key string) (string, error)
 	// SetMetadata sets the value of the metadata key on the volume.
 	SetMetadata(key, value string) error
+
+	// IsInUse returns true when a client other than the provisioner and
+	// the rbd-mirror daemons watches the volume.
+	IsInUse(ctx context.Context) (bool, error)
+}
+
+// SparsifyResult reports the progress of Volume.Sparsify.
+type SparsifyResult struct {
+	// Offset in the image up to which zero-filled blocks were freed
+	Offset uint64
+	// Size of the image
+	Size uint64
+	// PreUsage is the number of bytes that were allocated before
+	PreUsage uint64
+	// PostUsage is the number of bytes that are allocated after a
+	// completed Sparsify
+	PostUsage uint64
 }
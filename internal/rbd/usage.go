@@ -0,0 +1,225 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/util/driverstatus"
+	"github.com/ceph/ceph-csi/internal/util/journalstats"
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/usage"
+
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// CollectUsage returns a usage.Collector that accounts the images in the
+// journals of the StorageClass pools of the driver to the namespace of their
+// PVC. A pool or an image that can not be read is skipped, so that the other
+// volumes are still reported.
+func CollectUsage(driverName string) usage.Collector {
+	return func(ctx context.Context) (*usage.Report, error) {
+		c, err := kubeclient.NewK8sClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Kubernetes: %w", err)
+		}
+
+		sources, err := getListVolumesSources(ctx, c, driverName)
+		if err != nil {
+			return nil, err
+		}
+
+		report := usage.NewReport()
+		for _, source := range sources {
+			err = collectSourceUsage(ctx, c, source, report)
+			if err != nil {
+				log.ErrorLog(ctx, "failed to collect usage of pool %q in cluster %q: %v",
+					source.JournalPool, source.ClusterID, err)
+			}
+		}
+
+		return report, nil
+	}
+}
+
+func collectSourceUsage(ctx context.Context, c *k8s.Clientset, source *listVolumesSource, report *usage.Report) error {
+	lc, err := connectListVolumesSource(ctx, c, source)
+	if err != nil {
+		return err
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, source.JournalPool, "", 0)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reservations {
+		_, ri, image, attrs, oErr := lc.openReservedImage(ctx, r)
+		if oErr != nil {
+			if !isMissingReservedImage(oErr) {
+				log.ErrorLog(ctx, "skipping usage of volume %q: %v", r.RequestName, oErr)
+			}
+
+			continue
+		}
+
+		size, sErr := image.GetSize()
+		var used uint64
+		if sErr == nil {
+			used, sErr = getUsedBytes(image)
+		}
+		image.Close()
+		ri.Destroy(ctx)
+		if sErr != nil {
+			log.ErrorLog(ctx, "skipping usage of image %s: %v", ri, sErr)
+
+			continue
+		}
+
+		report.Add(attrs.Owner, int64(size), int64(used))
+	}
+
+	return nil
+}
+
+// CountVolumes returns a driverstatus.VolumeCounter that counts the
+// reservations in the journals of the StorageClass pools of the driver.
+func CountVolumes(driverName string) driverstatus.VolumeCounter {
+	return func(ctx context.Context) []driverstatus.VolumeCount {
+		c, err := kubeclient.NewK8sClient()
+		if err != nil {
+			log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+			return nil
+		}
+
+		sources, err := getListVolumesSources(ctx, c, driverName)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", driverName, err)
+
+			return nil
+		}
+
+		counts := make([]driverstatus.VolumeCount, 0, len(sources))
+		for _, source := range sources {
+			vc := driverstatus.VolumeCount{ClusterID: source.ClusterID, Pool: source.JournalPool}
+			vc.Volumes, err = countSourceVolumes(ctx, c, source)
+			if err != nil {
+				vc.Error = err.Error()
+			}
+			counts = append(counts, vc)
+		}
+
+		return counts
+	}
+}
+
+func countSourceVolumes(ctx context.Context, c *k8s.Clientset, source *listVolumesSource) (int, error) {
+	lc, err := connectListVolumesSource(ctx, c, source)
+	if err != nil {
+		return 0, err
+	}
+	defer lc.Destroy()
+
+	reservations, err := lc.journal.ListReservations(ctx, source.JournalPool, "", 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(reservations), nil
+}
+
+// CountJournalEntries returns a journalstats.Counter that counts the
+// volumes, snapshots and groups in the journals of the StorageClass pools of
+// the driver.
+func CountJournalEntries(driverName, instanceID string) journalstats.Counter {
+	return func(ctx context.Context) []journalstats.PoolEntries {
+		c, err := kubeclient.NewK8sClient()
+		if err != nil {
+			log.ErrorLog(ctx, "failed to connect to Kubernetes: %v", err)
+
+			return nil
+		}
+
+		sources, err := getListVolumesSources(ctx, c, driverName)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to list StorageClasses of driver %q: %v", driverName, err)
+
+			return nil
+		}
+
+		pools := make([]journalstats.PoolEntries, 0, len(sources))
+		for _, source := range sources {
+			pe := journalstats.PoolEntries{ClusterID: source.ClusterID, Pool: source.JournalPool}
+			err = countSourceJournalEntries(ctx, c, source, instanceID, &pe)
+			if err != nil {
+				pe.Error = err.Error()
+			}
+			pools = append(pools, pe)
+		}
+
+		return pools
+	}
+}
+
+func countSourceJournalEntries(
+	ctx context.Context,
+	c *k8s.Clientset,
+	source *listVolumesSource,
+	instanceID string,
+	pe *journalstats.PoolEntries,
+) error {
+	lc, err := connectListVolumesSource(ctx, c, source)
+	if err != nil {
+		return err
+	}
+	defer lc.Destroy()
+
+	pe.Volumes, err = lc.journal.CountReservations(ctx, source.JournalPool)
+	if err != nil {
+		return fmt.Errorf("failed to count volumes: %w", err)
+	}
+
+	sj, err := snapJournal(ctx).Connect(lc.monitors, lc.radosNamespace, lc.cr)
+	if err != nil {
+		return err
+	}
+	defer sj.Destroy()
+
+	pe.Snapshots, err = sj.CountReservations(ctx, source.JournalPool)
+	if err != nil {
+		return fmt.Errorf("failed to count snapshots: %w", err)
+	}
+
+	vgConfig := journal.NewCSIVolumeGroupJournalWithNamespace(instanceID, lc.radosNamespace)
+	vgj, err := vgConfig.Connect(lc.monitors, lc.radosNamespace, lc.cr)
+	if err != nil {
+		return err
+	}
+	defer vgj.Destroy()
+
+	pe.Groups, err = vgj.CountReservations(ctx, source.JournalPool)
+	if err != nil {
+		return fmt.Errorf("failed to count groups: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cephCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "csi",
+		Subsystem: "ceph",
+		Name:      "calls_total",
+		Help:      "Number of calls to the Ceph cluster, by result",
+	}, []string{"cluster_id", "operation", "result"})
+	cephCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "csi",
+		Subsystem: "ceph",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of the calls to the Ceph cluster",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"cluster_id", "operation"})
+
+	// clusterIDs maps the monitors of a cluster to its clusterID in the
+	// csi config, so that the calls on a connection can be attributed to
+	// the clusterID.
+	clusterIDs sync.Map
+)
+
+// RegisterCephCallMetrics registers the metrics of the calls to the Ceph
+// clusters with prometheus.
+func RegisterCephCallMetrics() error {
+	for _, c := range []prometheus.Collector{cephCallsTotal, cephCallDuration} {
+		err := prometheus.Register(c)
+		if err != nil {
+			return fmt.Errorf("failed to register ceph call metrics: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rememberClusterID stores the clusterID of the monitors.
+func rememberClusterID(monitors, clusterID string) {
+	clusterIDs.Store(monitors, clusterID)
+}
+
+// clusterIDOf returns the clusterID of the monitors, or an empty string when
+// the monitors were not read from the csi config.
+func clusterIDOf(monitors string) string {
+	clusterID, ok := clusterIDs.Load(monitors)
+	if !ok {
+		return ""
+	}
+
+	return clusterID.(string) //nolint:forcetypeassert // only strings are stored
+}
+
+// TrackCall starts measuring a go-ceph call with the connection. The returned
+// function needs to be called with the result of the call, it records the
+// duration of the call for the clusterID of the connection.
+func (cc *ClusterConnection) TrackCall(operation string) func(error) {
+	start := time.Now()
+
+	return func(err error) {
+		clusterID := ""
+		switch {
+		case cc == nil:
+		case cc.clusterID != "":
+			clusterID = cc.clusterID
+		default:
+			clusterID = clusterIDOf(cc.monitors)
+		}
+		cephCallDuration.WithLabelValues(clusterID, operation).Observe(time.Since(start).Seconds())
+		cephCallsTotal.WithLabelValues(clusterID, operation, callResult(err)).Inc()
+	}
+}
+
+// callResult returns the result label of a call that returned err. Objects
+// that do not exist are a regular outcome of many calls, and are not counted
+// as errors.
+func callResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, rados.ErrNotFound), errors.Is(err, librbd.ErrNotFound):
+		return "not_found"
+	}
+
+	return "error"
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallResult(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "success", callResult(nil))
+	require.Equal(t, "not_found", callResult(fmt.Errorf("omap: %w", rados.ErrNotFound)))
+	require.Equal(t, "not_found", callResult(librbd.ErrNotFound))
+	require.Equal(t, "error", callResult(errors.New("timed out")))
+}
+
+func TestClusterIDOf(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, clusterIDOf("mon-unknown:6789"))
+
+	rememberClusterID("mon-a:6789,mon-b:6789", "cluster-a")
+	require.Equal(t, "cluster-a", clusterIDOf("mon-a:6789,mon-b:6789"))
+
+	cc := &ClusterConnection{monitors: "mon-a:6789,mon-b:6789"}
+	cc.TrackCall("open_ioctx")(nil)
+
+	var nilConn *ClusterConnection
+	nilConn.TrackCall("open_ioctx")(errors.New("not connected"))
+}
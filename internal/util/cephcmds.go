@@ -166,11 +166,11 @@ func ExecCommandWithTimeout(
 // GetPoolID fetches the ID of the pool that matches the passed in poolName
 // parameter.
 func GetPoolID(monitors string, cr *Credentials, poolName string) (int64, error) {
-	conn, err := connPool.Get(monitors, cr.ID, cr.KeyFile)
+	conn, err := getConn("", monitors, cr)
 	if err != nil {
 		return InvalidPoolID, err
 	}
-	defer connPool.Put(conn)
+	defer putConn(conn)
 
 	id, err := conn.GetPoolByName(poolName)
 	if errors.Is(err, rados.ErrNotFound) {
@@ -186,11 +186,11 @@ func GetPoolID(monitors string, cr *Credentials, poolName string) (int64, error)
 // GetPoolName fetches the pool whose pool ID is equal to the requested poolID
 // parameter.
 func GetPoolName(monitors string, cr *Credentials, poolID int64) (string, error) {
-	conn, err := connPool.Get(monitors, cr.ID, cr.KeyFile)
+	conn, err := getConn("", monitors, cr)
 	if err != nil {
 		return "", err
 	}
-	defer connPool.Put(conn)
+	defer putConn(conn)
 
 	name, err := conn.GetPoolByID(poolID)
 	if errors.Is(err, rados.ErrNotFound) {
@@ -233,7 +233,7 @@ func CreateObject(ctx context.Context, monitors string, cr *Credentials, poolNam
 	}
 	defer conn.Destroy()
 
-	ioctx, err := conn.GetIoctx(poolName)
+	objects, err := conn.GetRadosObjects(poolName)
 	if err != nil {
 		if errors.Is(err, ErrPoolNotFound) {
 			err = fmt.Errorf("Failed as %w (internal %w)", ErrObjectNotFound, err)
@@ -241,13 +241,13 @@ func CreateObject(ctx context.Context, monitors string, cr *Credentials, poolNam
 
 		return err
 	}
-	defer ioctx.Destroy()
+	defer objects.Destroy()
 
 	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+		objects.SetNamespace(namespace)
 	}
 
-	err = ioctx.Create(objectName, rados.CreateExclusive)
+	err = objects.Create(objectName)
 	if errors.Is(err, rados.ErrObjectExists) {
 		return fmt.Errorf("Failed as %w (internal %w)", ErrObjectExists, err)
 	} else if err != nil {
@@ -269,7 +269,7 @@ func RemoveObject(ctx context.Context, monitors string, cr *Credentials, poolNam
 	}
 	defer conn.Destroy()
 
-	ioctx, err := conn.GetIoctx(poolName)
+	objects, err := conn.GetRadosObjects(poolName)
 	if err != nil {
 		if errors.Is(err, ErrPoolNotFound) {
 			err = fmt.Errorf("Failed as %w (internal %w)", ErrObjectNotFound, err)
@@ -277,13 +277,13 @@ func RemoveObject(ctx context.Context, monitors string, cr *Credentials, poolNam
 
 		return err
 	}
-	defer ioctx.Destroy()
+	defer objects.Destroy()
 
 	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+		objects.SetNamespace(namespace)
 	}
 
-	err = ioctx.Delete(oMapName)
+	err = objects.Delete(oMapName)
 	if errors.Is(err, rados.ErrNotFound) {
 		return fmt.Errorf("Failed as %w (internal %w)", ErrObjectNotFound, err)
 	} else if err != nil {
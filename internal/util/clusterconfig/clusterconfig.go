@@ -0,0 +1,333 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clusterconfig reads the configuration of the Ceph clusters from
+// ClusterConfig objects, as an alternative to the JSON csi config.
+package clusterconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ceph/ceph-csi/api/deploy/kubernetes"
+	"github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GroupVersionKind of the ClusterConfig objects.
+var GroupVersionKind = schema.GroupVersionKind{
+	Group:   "csi.ceph.io",
+	Version: "v1alpha1",
+	Kind:    "ClusterConfig",
+}
+
+const (
+	// ConditionValid is the type of the condition in the status of a
+	// ClusterConfig that tells whether the configuration is used.
+	ConditionValid = "Valid"
+
+	reasonValid   = "Valid"
+	reasonInvalid = "InvalidConfiguration"
+)
+
+// store keeps the valid ClusterConfig objects by their name.
+type store struct {
+	mux      sync.RWMutex
+	watching bool
+	clusters map[string]kubernetes.ClusterInfo
+	// generation is incremented on every change of the clusters
+	generation uint64
+}
+
+var configs = &store{clusters: map[string]kubernetes.ClusterInfo{}}
+
+// List returns the configuration of the clusters from the valid ClusterConfig
+// objects, ordered by clusterID. The returned bool is false when the
+// ClusterConfig objects are not watched.
+func List() ([]kubernetes.ClusterInfo, bool) {
+	configs.mux.RLock()
+	defer configs.mux.RUnlock()
+
+	clusters := make([]kubernetes.ClusterInfo, 0, len(configs.clusters))
+	for _, info := range configs.clusters {
+		clusters = append(clusters, info)
+	}
+	slices.SortFunc(clusters, func(a, b kubernetes.ClusterInfo) int {
+		return strings.Compare(a.ClusterID, b.ClusterID)
+	})
+
+	return clusters, configs.watching
+}
+
+// Generation returns a number that changes whenever a ClusterConfig object
+// was added, updated or removed, so that the configuration derived from the
+// objects can be cached.
+func Generation() uint64 {
+	configs.mux.RLock()
+	defer configs.mux.RUnlock()
+
+	return configs.generation
+}
+
+// watcher updates the store with the ClusterConfig objects of the informer.
+type watcher struct {
+	ctx context.Context
+	// client updates the status of the objects, it is nil when the
+	// status is not reported
+	client client.Client
+}
+
+// Start watches the ClusterConfig objects in the namespace, and returns once
+// the existing objects are read. The objects are watched until ctx is
+// cancelled. When updateStatus is set, the Valid condition in the status of
+// the objects is updated, this should be done by a single component, like the
+// provisioner.
+func Start(ctx context.Context, namespace string, updateStatus bool) error {
+	cfg, err := k8s.RestConfig()
+	if err != nil {
+		return err
+	}
+
+	c, err := cache.New(cfg, cache.Options{
+		DefaultNamespaces: map[string]cache.Config{namespace: {}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ClusterConfig cache: %w", err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GroupVersionKind)
+	informer, err := c.GetInformer(ctx, obj)
+	if err != nil {
+		return fmt.Errorf("failed to get ClusterConfig informer: %w", err)
+	}
+
+	w := &watcher{ctx: ctx}
+	if updateStatus {
+		w.client, err = client.New(cfg, client.Options{})
+		if err != nil {
+			return fmt.Errorf("failed to create ClusterConfig client: %w", err)
+		}
+	}
+
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    w.update,
+		UpdateFunc: func(_, obj any) { w.update(obj) },
+		DeleteFunc: w.remove,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch ClusterConfig objects: %w", err)
+	}
+
+	go func() {
+		cErr := c.Start(ctx)
+		if cErr != nil {
+			log.ErrorLogMsg("failed to watch ClusterConfig objects: %v", cErr)
+		}
+	}()
+
+	if !c.WaitForCacheSync(ctx) {
+		return errors.New("failed to read the ClusterConfig objects")
+	}
+
+	configs.mux.Lock()
+	configs.watching = true
+	configs.mux.Unlock()
+	log.DefaultLog("watching ClusterConfig objects in namespace %q", namespace)
+
+	return nil
+}
+
+func (w *watcher) update(obj any) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	info, err := FromUnstructured(u)
+	configs.mux.Lock()
+	if err != nil {
+		delete(configs.clusters, u.GetName())
+	} else {
+		configs.clusters[u.GetName()] = *info
+	}
+	configs.generation++
+	configs.mux.Unlock()
+
+	if err != nil {
+		log.WarningLogMsg("ignoring ClusterConfig %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+	}
+
+	if w.client != nil {
+		sErr := w.setValidCondition(u, err)
+		if sErr != nil {
+			log.ErrorLogMsg("failed to update status of ClusterConfig %s/%s: %v", u.GetNamespace(), u.GetName(), sErr)
+		}
+	}
+}
+
+func (w *watcher) remove(obj any) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	configs.mux.Lock()
+	delete(configs.clusters, u.GetName())
+	configs.generation++
+	configs.mux.Unlock()
+}
+
+// setValidCondition sets the Valid condition in the status of the object,
+// when it changed.
+func (w *watcher) setValidCondition(obj *unstructured.Unstructured, validationErr error) error {
+	u := obj.DeepCopy()
+	conditions, err := getConditions(u)
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonValid,
+		Message:            "the configuration is used",
+		ObservedGeneration: u.GetGeneration(),
+	}
+	if validationErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonInvalid
+		condition.Message = validationErr.Error()
+	}
+	if !meta.SetStatusCondition(&conditions, condition) {
+		return nil
+	}
+
+	status := make([]any, 0, len(conditions))
+	for i := range conditions {
+		c, cErr := runtime.DefaultUnstructuredConverter.ToUnstructured(&conditions[i])
+		if cErr != nil {
+			return cErr
+		}
+		status = append(status, c)
+	}
+	err = unstructured.SetNestedSlice(u.Object, status, "status", "conditions")
+	if err != nil {
+		return err
+	}
+
+	return w.client.Status().Patch(w.ctx, u, client.MergeFrom(obj))
+}
+
+// getConditions returns the conditions in the status of the object.
+func getConditions(u *unstructured.Unstructured) ([]metav1.Condition, error) {
+	raw, found, err := unstructured.NestedSlice(u.Object, "status", "conditions")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	conditions := make([]metav1.Condition, 0, len(raw))
+	for _, r := range raw {
+		m, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		var c metav1.Condition
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(m, &c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition in status: %w", err)
+		}
+		conditions = append(conditions, c)
+	}
+
+	return conditions, nil
+}
+
+// FromUnstructured returns the configuration of the cluster in the spec of
+// the ClusterConfig object. The name of the object is the clusterID, unless
+// the spec contains a clusterID.
+func FromUnstructured(u *unstructured.Unstructured) (*kubernetes.ClusterInfo, error) {
+	spec, found, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	if !found {
+		return nil, errors.New("missing spec")
+	}
+
+	info := &kubernetes.ClusterInfo{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(spec, info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	if info.ClusterID == "" {
+		info.ClusterID = u.GetName()
+	}
+
+	err = Validate(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Validate checks the configuration of a cluster for errors that would make
+// the provisioning of volumes fail.
+func Validate(info *kubernetes.ClusterInfo) error {
+	if info.ClusterID == "" {
+		return errors.New("clusterID is empty")
+	}
+	if len(info.Monitors) == 0 && info.MonDNSSRVName == "" {
+		return errors.New("monitors and monDNSSRVName are empty")
+	}
+	if slices.Contains(info.Monitors, "") {
+		return errors.New("monitors contain an empty address")
+	}
+
+	if info.RBD.RadosNamespaceQuota != "" {
+		_, err := resource.ParseQuantity(info.RBD.RadosNamespaceQuota)
+		if err != nil {
+			return fmt.Errorf("invalid rbd.radosNamespaceQuota %q: %w", info.RBD.RadosNamespaceQuota, err)
+		}
+	}
+
+	for i, tenant := range info.RBD.Tenants {
+		if len(tenant.Namespaces) == 0 || tenant.ClusterID == "" || tenant.SecretName == "" ||
+			tenant.SecretNamespace == "" {
+			return fmt.Errorf("rbd.tenants[%d] needs namespaces, a clusterID, secretName and secretNamespace", i)
+		}
+	}
+
+	return nil
+}
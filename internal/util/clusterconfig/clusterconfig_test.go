@@ -0,0 +1,113 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newClusterConfig(name string, spec map[string]any) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]any{}}
+	u.SetGroupVersionKind(GroupVersionKind)
+	u.SetName(name)
+	if spec != nil {
+		u.Object["spec"] = spec
+	}
+
+	return u
+}
+
+func TestFromUnstructured(t *testing.T) {
+	t.Parallel()
+
+	info, err := FromUnstructured(newClusterConfig("cluster-1", map[string]any{
+		"monitors": []any{"mon1:6789", "mon2:6789"},
+		"rbd": map[string]any{
+			"radosNamespace":      "ns",
+			"radosNamespaceQuota": "100Gi",
+		},
+		"cephFS": map[string]any{
+			"subvolumeGroup": "csi",
+		},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "cluster-1", info.ClusterID)
+	require.Equal(t, []string{"mon1:6789", "mon2:6789"}, info.Monitors)
+	require.Equal(t, "ns", info.RBD.RadosNamespace)
+	require.Equal(t, "csi", info.CephFS.SubvolumeGroup)
+
+	info, err = FromUnstructured(newClusterConfig("cluster-1", map[string]any{
+		"clusterID": "other",
+		"monitors":  []any{"mon1:6789"},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "other", info.ClusterID)
+
+	_, err = FromUnstructured(newClusterConfig("no-spec", nil))
+	require.Error(t, err)
+
+	_, err = FromUnstructured(newClusterConfig("no-monitors", map[string]any{}))
+	require.Error(t, err)
+
+	info, err = FromUnstructured(newClusterConfig("dns-srv", map[string]any{
+		"monDNSSRVName": "ceph-mon_example.com",
+	}))
+	require.NoError(t, err)
+	require.Empty(t, info.Monitors)
+	require.Equal(t, "ceph-mon_example.com", info.MonDNSSRVName)
+
+	_, err = FromUnstructured(newClusterConfig("bad-quota", map[string]any{
+		"monitors": []any{"mon1:6789"},
+		"rbd":      map[string]any{"radosNamespaceQuota": "lots"},
+	}))
+	require.Error(t, err)
+
+	_, err = FromUnstructured(newClusterConfig("bad-tenant", map[string]any{
+		"monitors": []any{"mon1:6789"},
+		"rbd": map[string]any{
+			"tenants": []any{map[string]any{"namespaces": []any{"team-a"}}},
+		},
+	}))
+	require.Error(t, err)
+}
+
+func TestGetConditions(t *testing.T) {
+	t.Parallel()
+
+	u := newClusterConfig("cluster-1", nil)
+	conditions, err := getConditions(u)
+	require.NoError(t, err)
+	require.Empty(t, conditions)
+
+	u.Object["status"] = map[string]any{
+		"conditions": []any{map[string]any{
+			"type":               ConditionValid,
+			"status":             string(metav1.ConditionFalse),
+			"reason":             reasonInvalid,
+			"message":            "monitors are empty",
+			"lastTransitionTime": "2024-01-01T00:00:00Z",
+		}},
+	}
+	conditions, err = getConditions(u)
+	require.NoError(t, err)
+	require.Len(t, conditions, 1)
+	require.Equal(t, metav1.ConditionFalse, conditions[0].Status)
+}
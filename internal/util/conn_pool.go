@@ -17,14 +17,29 @@ limitations under the License.
 package util
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ceph/ceph-csi/internal/util/clusterconfig"
+
 	"github.com/ceph/go-ceph/rados"
 )
 
+// reservedCephOptions are set by the connection pool, they can not be
+// overridden by the CephOptions of a cluster.
+var reservedCephOptions = []string{"mon_host", "key", "keyring", "keyfile"}
+
+// cephOption is a Ceph client option that is set on a new connection.
+type cephOption struct {
+	name  string
+	value string
+}
+
 type connEntry struct {
 	conn     *rados.Conn
 	lastUsed time.Time
@@ -104,6 +119,115 @@ func (cp *ConnPool) generateUniqueKey(monitors, user, keyfile string) (string, e
 	return fmt.Sprintf("%s|%s|%s", monitors, user, string(key)), nil
 }
 
+// cephOptionsCache keeps the parsed CephOptions of the clusters, until the csi
+// config file or the ClusterConfig objects change.
+type cephOptionsCache struct {
+	lock       sync.Mutex
+	modTime    time.Time
+	generation uint64
+	options    map[string][]cephOption
+}
+
+var clusterOptions = &cephOptionsCache{}
+
+// get returns the CephOptions of the cluster in the csi config. The csi config
+// is only read again after it changed.
+func (c *cephOptionsCache) get(pathToConfig, clusterID string) ([]cephOption, error) {
+	var modTime time.Time
+	info, err := os.Stat(pathToConfig)
+	if err == nil {
+		modTime = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat %q: %w", pathToConfig, err)
+	}
+	generation := clusterconfig.Generation()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.options == nil || !modTime.Equal(c.modTime) || generation != c.generation {
+		c.modTime = modTime
+		c.generation = generation
+		c.options = map[string][]cephOption{}
+	}
+	if options, ok := c.options[clusterID]; ok {
+		return options, nil
+	}
+
+	// clusters that are not in the csi config, like the ones of migrated
+	// volumes, have no options
+	raw, err := GetCephOptions(pathToConfig, clusterID)
+	if err != nil && !errors.Is(err, ErrMissingClusterConfig) && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	options, err := parseCephOptions(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ceph options of cluster ID %q: %w", clusterID, err)
+	}
+	c.options[clusterID] = options
+
+	return options, nil
+}
+
+// GetClusterCephOption returns the value of the option in the CephOptions of
+// the cluster, or an empty string when it is not set. The name may use
+// spaces, dashes or underscores.
+func GetClusterCephOption(clusterID, name string) (string, error) {
+	options, err := clusterOptions.get(CsiConfigFile, clusterID)
+	if err != nil {
+		return "", err
+	}
+
+	name = normalizeCephOptionName(name)
+	for _, opt := range options {
+		if opt.name == name {
+			return opt.value, nil
+		}
+	}
+
+	return "", nil
+}
+
+// normalizeCephOptionName returns the name of an option with underscores,
+// like "rados_osd_op_timeout" for "rados osd-op timeout".
+func normalizeCephOptionName(name string) string {
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}), "_")
+}
+
+// parseCephOptions returns the options sorted by name, so that the same
+// options result in the same connection. Option names may use spaces, dashes
+// or underscores, like in ceph.conf.
+func parseCephOptions(options map[string]string) ([]cephOption, error) {
+	parsed := make([]cephOption, 0, len(options))
+	for name, value := range options {
+		name = normalizeCephOptionName(name)
+		if name == "" {
+			return nil, fmt.Errorf("ceph option with value %q has no name", value)
+		}
+		if slices.Contains(reservedCephOptions, name) {
+			return nil, fmt.Errorf("ceph option %q can not be set in the csi config", name)
+		}
+		parsed = append(parsed, cephOption{name: name, value: value})
+	}
+	slices.SortFunc(parsed, func(a, b cephOption) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return parsed, nil
+}
+
+// cephOptionsKey returns the part of the unique key of a connection for the
+// options.
+func cephOptionsKey(options []cephOption) string {
+	pairs := make([]string, 0, len(options))
+	for _, opt := range options {
+		pairs = append(pairs, opt.name+"="+opt.value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
 // getExisting returns the existing rados.Conn associated with the unique key.
 //
 // Requires: locked cp.lock because of ce.get().
@@ -122,11 +246,30 @@ func (cp *ConnPool) getConn(unique string) *rados.Conn {
 // case there is none. Use the returned rados.Conn to reduce the reference
 // count with ConnPool.Put(unique).
 func (cp *ConnPool) Get(monitors, user, keyfile string) (*rados.Conn, error) {
+	return cp.GetForCluster("", monitors, user, keyfile)
+}
+
+// GetForCluster returns a rados.Conn like Get, with the CephOptions of the
+// clusterID in the csi config set on a new rados.Conn. An empty clusterID
+// sets no options.
+func (cp *ConnPool) GetForCluster(clusterID, monitors, user, keyfile string) (*rados.Conn, error) {
 	unique, err := cp.generateUniqueKey(monitors, user, keyfile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate unique for connection: %w", err)
 	}
 
+	var options []cephOption
+	if clusterID != "" {
+		options, err = clusterOptions.get(CsiConfigFile, clusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ceph options of cluster ID %q: %w", clusterID, err)
+		}
+	}
+	if len(options) != 0 {
+		// a changed csi config results in a new connection
+		unique += "|" + cephOptionsKey(options)
+	}
+
 	cp.lock.RLock()
 	conn := cp.getConn(unique)
 	cp.lock.RUnlock()
@@ -149,6 +292,13 @@ func (cp *ConnPool) Get(monitors, user, keyfile string) (*rados.Conn, error) {
 		return nil, fmt.Errorf("failed to read config file %q: %w", CephConfigPath, err)
 	}
 
+	for _, opt := range options {
+		err = conn.SetConfigOption(opt.name, opt.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set ceph option %q to %q: %w", opt.name, opt.value, err)
+		}
+	}
+
 	err = conn.Connect()
 	if err != nil {
 		return nil, fmt.Errorf("connecting failed: %w", err)
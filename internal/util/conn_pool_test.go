@@ -18,10 +18,12 @@ package util
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/ceph/go-ceph/rados"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -177,3 +179,59 @@ func TestConnPool(t *testing.T) {
 		}
 	})
 }
+
+func TestParseCephOptions(t *testing.T) {
+	t.Parallel()
+
+	options, err := parseCephOptions(map[string]string{
+		"rados_osd_op_timeout":    "30",
+		"client mount timeout":    "60",
+		"rbd-default-map-options": "ms_mode=secure",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cephOption{
+		{name: "client_mount_timeout", value: "60"},
+		{name: "rados_osd_op_timeout", value: "30"},
+		{name: "rbd_default_map_options", value: "ms_mode=secure"},
+	}, options)
+	require.Equal(t,
+		"client_mount_timeout=60,rados_osd_op_timeout=30,rbd_default_map_options=ms_mode=secure",
+		cephOptionsKey(options))
+
+	options, err = parseCephOptions(nil)
+	require.NoError(t, err)
+	require.Empty(t, options)
+
+	_, err = parseCephOptions(map[string]string{"mon-host": "10.0.0.1"})
+	require.Error(t, err)
+
+	_, err = parseCephOptions(map[string]string{" ": "value"})
+	require.Error(t, err)
+}
+
+func TestCephOptionsCache(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path,
+		[]byte(`[{"clusterID":"a","monitors":["mon"],"cephOptions":{"rados osd op timeout":"30"}}]`), 0o600)
+	require.NoError(t, err)
+
+	cache := &cephOptionsCache{}
+	options, err := cache.get(path, "a")
+	require.NoError(t, err)
+	require.Equal(t, []cephOption{{name: "rados_osd_op_timeout", value: "30"}}, options)
+
+	// clusters that are not in the csi config have no options
+	options, err = cache.get(path, "b")
+	require.NoError(t, err)
+	require.Empty(t, options)
+
+	// a modified csi config is read again
+	err = os.WriteFile(path, []byte(`[{"clusterID":"a","monitors":["mon"],"cephOptions":{"key":"secret"}}]`), 0o600)
+	require.NoError(t, err)
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	_, err = cache.get(path, "a")
+	require.Error(t, err)
+}
@@ -23,13 +23,32 @@ import (
 
 	ca "github.com/ceph/go-ceph/cephfs/admin"
 	"github.com/ceph/go-ceph/common/admin/nfs"
+	ccom "github.com/ceph/go-ceph/common/commands"
 	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
 	ra "github.com/ceph/go-ceph/rbd/admin"
 )
 
+// cephConn is the connection to a Ceph cluster. It is a *rados.Conn, or the
+// simulated cluster of the fakeceph package when cephcsi is built with the
+// fakeceph build tag.
+type cephConn interface {
+	ccom.RadosCommander
+
+	GetFSID() (string, error)
+	GetInstanceID() uint64
+	GetAddrs() (string, error)
+	GetPoolByName(name string) (int64, error)
+	GetPoolByID(id int64) (string, error)
+}
+
 type ClusterConnection struct {
 	// connection
-	conn *rados.Conn
+	conn cephConn
+	// monitors of the cluster, to attribute the calls to a clusterID
+	monitors string
+	// clusterID that was passed to ConnectCluster
+	clusterID string
 
 	// FIXME: temporary reference for credentials. Remove this when go-ceph
 	// is used for operations.
@@ -49,13 +68,21 @@ var (
 
 // rbdVol.Connect() connects to the Ceph cluster and sets rbdVol.conn for further usage.
 func (cc *ClusterConnection) Connect(monitors string, cr *Credentials) error {
+	return cc.ConnectCluster("", monitors, cr)
+}
+
+// ConnectCluster connects like Connect, and applies the CephOptions of the
+// clusterID in the csi config to the connection.
+func (cc *ClusterConnection) ConnectCluster(clusterID, monitors string, cr *Credentials) error {
 	if cc.conn == nil {
-		conn, err := connPool.Get(monitors, cr.ID, cr.KeyFile)
+		conn, err := getConn(clusterID, monitors, cr)
 		if err != nil {
 			return fmt.Errorf("failed to get connection: %w", err)
 		}
 
 		cc.conn = conn
+		cc.monitors = monitors
+		cc.clusterID = clusterID
 
 		// FIXME: remove .Creds from ClusterConnection
 		cc.Creds = cr
@@ -66,7 +93,7 @@ func (cc *ClusterConnection) Connect(monitors string, cr *Credentials) error {
 
 func (cc *ClusterConnection) Destroy() {
 	if cc.conn != nil {
-		connPool.Put(cc.conn)
+		putConn(cc.conn)
 	}
 }
 
@@ -81,18 +108,38 @@ func (cc *ClusterConnection) Copy() *ClusterConnection {
 
 	c := ClusterConnection{}
 	c.discardOnZeroedWriteSameDisabled = cc.discardOnZeroedWriteSameDisabled
-	c.conn = connPool.Copy(cc.conn)
+	c.conn = copyConn(cc.conn)
+	c.monitors = cc.monitors
+	c.clusterID = cc.clusterID
 	c.Creds = cc.Creds
 
 	return &c
 }
 
-func (cc *ClusterConnection) GetIoctx(pool string) (*rados.IOContext, error) {
+// radosConn returns the librados connection, it is not available for a
+// simulated cluster.
+func (cc *ClusterConnection) radosConn() (*rados.Conn, error) {
 	if cc.conn == nil {
 		return nil, errors.New("cluster is not connected yet")
 	}
 
-	ioctx, err := cc.conn.OpenIOContext(pool)
+	conn, ok := cc.conn.(*rados.Conn)
+	if !ok {
+		return nil, errors.New("librados is not available for a simulated cluster")
+	}
+
+	return conn, nil
+}
+
+func (cc *ClusterConnection) GetIoctx(pool string) (*rados.IOContext, error) {
+	conn, err := cc.radosConn()
+	if err != nil {
+		return nil, err
+	}
+
+	done := cc.TrackCall("open_ioctx")
+	ioctx, err := conn.OpenIOContext(pool)
+	done(err)
 	if err != nil {
 		// ErrNotFound indicates the Pool was not found
 		if errors.Is(err, rados.ErrNotFound) {
@@ -107,6 +154,16 @@ func (cc *ClusterConnection) GetIoctx(pool string) (*rados.IOContext, error) {
 	return ioctx, nil
 }
 
+// GetRadosObjects returns the RadosObjects of the pool, Destroy() needs to be
+// called once they are not used anymore.
+func (cc *ClusterConnection) GetRadosObjects(pool string) (RadosObjects, error) {
+	if cc.conn == nil {
+		return nil, errors.New("cluster is not connected yet")
+	}
+
+	return cc.openRadosObjects(pool)
+}
+
 func (cc *ClusterConnection) GetFSAdmin() (*ca.FSAdmin, error) {
 	if cc.conn == nil {
 		return nil, errors.New("cluster is not connected yet")
@@ -132,6 +189,17 @@ func (cc *ClusterConnection) GetRBDAdmin() (*ra.RBDAdmin, error) {
 	return ra.NewFromConn(cc.conn), nil
 }
 
+// GetMirrorSiteName returns the site name of the cluster for RBD mirroring,
+// Ceph uses the FSID when no site name is set.
+func (cc *ClusterConnection) GetMirrorSiteName() (string, error) {
+	conn, err := cc.radosConn()
+	if err != nil {
+		return "", err
+	}
+
+	return librbd.GetMirrorSiteName(conn)
+}
+
 // GetTaskAdmin returns TaskAdmin to add tasks on rbd images.
 func (cc *ClusterConnection) GetTaskAdmin() (*ra.TaskAdmin, error) {
 	rbdAdmin, err := cc.GetRBDAdmin()
@@ -152,6 +220,16 @@ func (cc *ClusterConnection) GetNFSAdmin() (*nfs.Admin, error) {
 	return nfs.NewFromConn(cc.conn), nil
 }
 
+// GetInstanceID returns the global ID of the RADOS session, it is the ID of
+// the watchers that the session adds to images and objects.
+func (cc *ClusterConnection) GetInstanceID() (uint64, error) {
+	if cc.conn == nil {
+		return 0, errors.New("cluster is not connected yet")
+	}
+
+	return cc.conn.GetInstanceID(), nil
+}
+
 // GetAddrs returns the addresses of the RADOS session,
 // suitable for blocklisting.
 func (cc *ClusterConnection) GetAddrs() (string, error) {
@@ -0,0 +1,55 @@
+//go:build !fakeceph
+
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/ceph/go-ceph/rados"
+)
+
+// getConn returns a connection to the Ceph cluster from the connection pool,
+// it needs to be returned with putConn().
+func getConn(clusterID, monitors string, cr *Credentials) (cephConn, error) {
+	conn, err := connPool.GetForCluster(clusterID, monitors, cr.ID, cr.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// putConn returns the connection to the connection pool.
+func putConn(conn cephConn) {
+	connPool.Put(conn.(*rados.Conn))
+}
+
+// copyConn returns a copy of the connection that needs to be returned with
+// putConn() too.
+func copyConn(conn cephConn) cephConn {
+	return connPool.Copy(conn.(*rados.Conn))
+}
+
+// openRadosObjects returns the RadosObjects of an IOContext for the pool.
+func (cc *ClusterConnection) openRadosObjects(pool string) (RadosObjects, error) {
+	ioctx, err := cc.GetIoctx(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ioctxObjects{ioctx: ioctx}, nil
+}
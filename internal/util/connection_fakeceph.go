@@ -0,0 +1,60 @@
+//go:build fakeceph
+
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util/fakeceph"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+// getConn returns the simulated cluster that is registered for the monitors,
+// cephcsi is built with the fakeceph build tag. The credentials are not
+// verified.
+func getConn(_, monitors string, _ *Credentials) (cephConn, error) {
+	return fakeceph.ClusterFor(monitors), nil
+}
+
+// putConn does nothing, the simulated clusters are not pooled.
+func putConn(cephConn) {}
+
+// copyConn returns the simulated cluster of the connection.
+func copyConn(conn cephConn) cephConn {
+	return conn
+}
+
+// openRadosObjects returns the simulated objects of the pool.
+func (cc *ClusterConnection) openRadosObjects(pool string) (RadosObjects, error) {
+	cluster, ok := cc.conn.(*fakeceph.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("connection to %q is not simulated", cc.monitors)
+	}
+
+	objects, err := cluster.OpenObjects(pool)
+	if errors.Is(err, rados.ErrNotFound) {
+		return nil, fmt.Errorf("Failed as %w (internal %w)", ErrPoolNotFound, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
@@ -121,6 +121,25 @@ func NewAdminCredentials(secrets map[string]string) (*Credentials, error) {
 	return newCredentialsFromSecret(credAdminID, credAdminKey, secrets)
 }
 
+// ReplaceCredentials returns a copy of secrets where the Ceph user is
+// replaced by the one in credentials. Other entries of secrets, like the
+// ones a KMS may use, are kept.
+func ReplaceCredentials(secrets, credentials map[string]string) map[string]string {
+	replaced := make(map[string]string, len(secrets)+len(credentials))
+	for k, v := range secrets {
+		switch k {
+		case credUserID, credUserKey, credAdminID, credAdminKey, migUserID, migUserKey:
+			continue
+		}
+		replaced[k] = v
+	}
+	for k, v := range credentials {
+		replaced[k] = v
+	}
+
+	return replaced
+}
+
 // GetMonValFromSecret returns monitors from secret.
 func GetMonValFromSecret(secrets map[string]string) (string, error) {
 	if mons, ok := secrets[credMonitors]; ok {
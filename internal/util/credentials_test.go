@@ -96,3 +96,32 @@ func TestParseAndSetSecretMapFromMigSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestReplaceCredentials(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{
+		"adminId":              "admin",
+		"key":                  "migration-key",
+		"userID":               "shared",
+		"userKey":              "shared-key",
+		"encryptionPassphrase": "passphrase",
+	}
+	credentials := map[string]string{
+		"userID":  "tenant-a",
+		"userKey": "tenant-a-key",
+	}
+	want := map[string]string{
+		"userID":               "tenant-a",
+		"userKey":              "tenant-a-key",
+		"encryptionPassphrase": "passphrase",
+	}
+
+	got := ReplaceCredentials(secrets, credentials)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReplaceCredentials() = %v, want %v", got, want)
+	}
+	if secrets["userID"] != "shared" {
+		t.Errorf("ReplaceCredentials() modified the secrets")
+	}
+}
@@ -25,9 +25,11 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ceph/ceph-csi/internal/kms"
 	"github.com/ceph/ceph-csi/internal/util/cryptsetup"
+	"github.com/ceph/ceph-csi/internal/util/keycache"
 	"github.com/ceph/ceph-csi/internal/util/log"
 )
 
@@ -52,6 +54,10 @@ var (
 		"VolumeEncryption.SetDEKStore()")
 
 	luks = cryptsetup.NewLUKSWrapper(context.Background())
+
+	// passphraseCache keeps the passphrases that were fetched from the KMS,
+	// it is nil unless EnablePassphraseCache() was called.
+	passphraseCache *keycache.Cache
 )
 
 type VolumeEncryption struct {
@@ -197,6 +203,8 @@ func (ve *VolumeEncryption) RemoveDEK(ctx context.Context, volumeID string) erro
 		return ErrDEKStoreNotFound
 	}
 
+	InvalidatePassphrase(volumeID)
+
 	return ve.dekStore.RemoveDEK(ctx, volumeID)
 }
 
@@ -212,6 +220,7 @@ func (ve *VolumeEncryption) StoreCryptoPassphrase(ctx context.Context, volumeID,
 		return fmt.Errorf("failed encrypt the passphrase for %s: %w", volumeID, err)
 	}
 
+	InvalidatePassphrase(volumeID)
 	err = ve.dekStore.StoreDEK(ctx, volumeID, encryptedPassphrase)
 	if err != nil {
 		return fmt.Errorf("failed to save the passphrase for %s: %w", volumeID, err)
@@ -232,12 +241,69 @@ func (ve *VolumeEncryption) StoreNewCryptoPassphrase(ctx context.Context, volume
 
 // GetCryptoPassphrase Retrieves passphrase to encrypt volume.
 func (ve *VolumeEncryption) GetCryptoPassphrase(ctx context.Context, volumeID string) (string, error) {
-	passphrase, err := ve.dekStore.FetchDEK(ctx, volumeID)
+	if passphraseCache != nil {
+		if cached, ok := passphraseCache.Get(volumeID); ok {
+			log.TraceLog(ctx, "using cached passphrase for %s", volumeID)
+			passphrase := string(cached)
+			clear(cached)
+
+			return passphrase, nil
+		}
+	}
+
+	encryptedPassphrase, err := ve.dekStore.FetchDEK(ctx, volumeID)
+	if err != nil {
+		return "", err
+	}
+
+	passphrase, err := ve.KMS.DecryptDEK(ctx, volumeID, encryptedPassphrase)
 	if err != nil {
 		return "", err
 	}
 
-	return ve.KMS.DecryptDEK(ctx, volumeID, passphrase)
+	if passphraseCache != nil {
+		cached := []byte(passphrase)
+		err = passphraseCache.Put(volumeID, cached)
+		clear(cached)
+		if err != nil {
+			log.WarningLog(ctx, "not caching the passphrase: %v", err)
+		}
+	}
+
+	return passphrase, nil
+}
+
+// EnablePassphraseCache keeps the passphrases that GetCryptoPassphrase()
+// fetched from the KMS in memory for ttl, the memory of the passphrases is
+// locked so that they are never swapped. Expired passphrases are cleared
+// periodically. It is meant for the node plugin, where every NodeStageVolume
+// of an encrypted volume needs the passphrase, and should be called once
+// before the gRPC server is started. The TTL needs to be positive.
+func EnablePassphraseCache(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid TTL %v of the passphrase cache, it needs to be positive", ttl)
+	}
+
+	passphraseCache = keycache.New(ttl)
+
+	go func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+		for range ticker.C {
+			passphraseCache.Expire()
+		}
+	}()
+
+	return nil
+}
+
+// InvalidatePassphrase drops the cached passphrase of the volume, when the
+// passphrase cache is enabled. It is called when the volume is unstaged, and
+// when the passphrase is replaced or removed.
+func InvalidatePassphrase(volumeID string) {
+	if passphraseCache != nil {
+		passphraseCache.Remove(volumeID)
+	}
 }
 
 // GetNewCryptoPassphrase returns a random passphrase of given length.
@@ -245,6 +311,12 @@ func (ve *VolumeEncryption) GetNewCryptoPassphrase(length int) (string, error) {
 	return generateNewEncryptionPassphrase(length)
 }
 
+// NewEncryptionPassphrase returns a random passphrase of given length, for
+// volumes that are encrypted without a KMS.
+func NewEncryptionPassphrase(length int) (string, error) {
+	return generateNewEncryptionPassphrase(length)
+}
+
 // generateNewEncryptionPassphrase generates a random passphrase for encryption.
 func generateNewEncryptionPassphrase(length int) (string, error) {
 	bytesPassphrase := make([]byte, length)
@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/base64"
 	"testing"
+	"time"
 
 	"github.com/ceph/ceph-csi/internal/kms"
 
@@ -95,3 +96,11 @@ func TestFetchEncryptionType(t *testing.T) {
 	volOpts["encryptionType"] = "INVALID"
 	require.EqualValues(t, EncryptionTypeInvalid, FetchEncryptionType(volOpts, EncryptionTypeNone))
 }
+
+func TestEnablePassphraseCacheInvalidTTL(t *testing.T) {
+	t.Parallel()
+
+	require.Error(t, EnablePassphraseCache(0))
+	require.Error(t, EnablePassphraseCache(-time.Second))
+	require.Nil(t, passphraseCache)
+}
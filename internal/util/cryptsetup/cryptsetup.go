@@ -38,6 +38,14 @@ const (
 
 	// Limit memory used by Argon2i PBKDF to 32 MiB.
 	pkdbfMemoryLimit = 32 << 10 // 32768 KiB
+
+	// LUKS2HeaderSize is the space at the start of a device that Format
+	// reserves for the LUKS2 header and keyslots, the encrypted data starts
+	// after it.
+	LUKS2HeaderSize = 16 << 20 // 16 MiB
+
+	// sectorSize is the unit of the --offset option of cryptsetup.
+	sectorSize = 512
 )
 
 // LuksWrapper is a struct that provides a context-aware wrapper around cryptsetup commands.
@@ -64,6 +72,23 @@ func NewLUKSWrapper(ctx context.Context) LUKSWrapper {
 	return &luksWrapper{ctx: ctx}
 }
 
+// Version returns the version of the installed cryptsetup executable.
+func Version(ctx context.Context) (string, error) {
+	l := &luksWrapper{ctx: ctx}
+	stdout, _, err := l.execCryptsetupCommand(nil, "--version")
+	if err != nil {
+		return "", err
+	}
+
+	// the output looks like "cryptsetup 2.6.1 flags: UDEV BLKID ..."
+	fields := strings.Fields(stdout)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("failed to parse cryptsetup version from %q", stdout)
+	}
+
+	return fields[1], nil
+}
+
 // LuksFormat sets up volume as an encrypted LUKS partition.
 func (l *luksWrapper) Format(devicePath, passphrase string) (string, string, error) {
 	return l.execCryptsetupCommand(
@@ -76,6 +101,8 @@ func (l *luksWrapper) Format(devicePath, passphrase string) (string, string, err
 		"sha256",
 		"--pbkdf-memory",
 		strconv.Itoa(pkdbfMemoryLimit),
+		"--offset",
+		strconv.Itoa(LUKS2HeaderSize/sectorSize),
 		devicePath,
 		"-d",
 		"/dev/stdin")
@@ -19,11 +19,16 @@ package util
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/ceph/ceph-csi/api/deploy/kubernetes"
+	"github.com/ceph/ceph-csi/internal/util/clusterconfig"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
@@ -61,13 +66,76 @@ const (
 }]
 */
 func readClusterInfo(pathToConfig, clusterID string) (*kubernetes.ClusterInfo, error) {
+	config, err := readClusterConfig(pathToConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching configuration for cluster ID %q: %w", clusterID, err)
+	}
+
+	for i := range config {
+		if config[i].ClusterID == clusterID {
+			return &config[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w for cluster ID %q", ErrMissingClusterConfig, clusterID)
+}
+
+// readClusterConfig returns all entries of the csi config. When ClusterConfig
+// objects are watched, their entries replace the entries of the csi config
+// with the same clusterID, and the csi config file is optional.
+func readClusterConfig(pathToConfig string) ([]kubernetes.ClusterInfo, error) {
+	clusters, watching := clusterconfig.List()
+	if !watching {
+		return readClusterConfigFile(pathToConfig)
+	}
+
+	config, err := readClusterConfigFile(pathToConfig)
+	if errors.Is(err, os.ErrNotExist) {
+		return clusters, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return mergeClusterConfig(clusters, config), nil
+}
+
+// ListClusterIDs returns the clusterIDs of the csi config.
+func ListClusterIDs() ([]string, error) {
+	config, err := readClusterConfig(CsiConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterIDs := make([]string, 0, len(config))
+	for i := range config {
+		clusterIDs = append(clusterIDs, config[i].ClusterID)
+	}
+
+	return clusterIDs, nil
+}
+
+// mergeClusterConfig returns the clusters, and the entries of config with a
+// clusterID that is not part of the clusters.
+func mergeClusterConfig(clusters, config []kubernetes.ClusterInfo) []kubernetes.ClusterInfo {
+	merged := slices.Clone(clusters)
+	for i := range config {
+		if !slices.ContainsFunc(clusters, func(c kubernetes.ClusterInfo) bool {
+			return c.ClusterID == config[i].ClusterID
+		}) {
+			merged = append(merged, config[i])
+		}
+	}
+
+	return merged
+}
+
+// readClusterConfigFile returns all entries of the csi config file.
+func readClusterConfigFile(pathToConfig string) ([]kubernetes.ClusterInfo, error) {
 	var config []kubernetes.ClusterInfo
 
 	// #nosec
 	content, err := os.ReadFile(pathToConfig)
 	if err != nil {
-		err = fmt.Errorf("error fetching configuration for cluster ID %q: %w", clusterID, err)
-
 		return nil, err
 	}
 
@@ -77,27 +145,43 @@ func readClusterInfo(pathToConfig, clusterID string) (*kubernetes.ClusterInfo, e
 			err, string(content))
 	}
 
-	for i := range config {
-		if config[i].ClusterID == clusterID {
-			return &config[i], nil
-		}
-	}
-
-	return nil, fmt.Errorf("missing configuration for cluster ID %q", clusterID)
+	return config, nil
 }
 
 // Mons returns a comma separated MON list from the csi config for the given clusterID.
+// Without monitors in the config, they are discovered with the DNS SRV
+// records of the monDNSSRVName.
 func Mons(pathToConfig, clusterID string) (string, error) {
 	cluster, err := readClusterInfo(pathToConfig, clusterID)
 	if err != nil {
 		return "", err
 	}
 
-	if len(cluster.Monitors) == 0 {
+	var monitors string
+	switch {
+	case len(cluster.Monitors) != 0:
+		monitors = strings.Join(cluster.Monitors, ",")
+	case cluster.MonDNSSRVName != "":
+		monitors, err = monsFromDNSSRV(cluster.MonDNSSRVName)
+		if err != nil {
+			return "", fmt.Errorf("failed to discover monitors for cluster ID (%s): %w", clusterID, err)
+		}
+	default:
 		return "", fmt.Errorf("empty monitor list for cluster ID (%s) in config", clusterID)
 	}
+	rememberClusterID(monitors, clusterID)
+
+	return monitors, nil
+}
+
+// GetCephOptions returns the Ceph client options for the given clusterID.
+func GetCephOptions(pathToConfig, clusterID string) (map[string]string, error) {
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return nil, err
+	}
 
-	return strings.Join(cluster.Monitors, ","), nil
+	return cluster.CephOptions, nil
 }
 
 // GetRBDRadosNamespace returns the namespace for the given clusterID.
@@ -125,6 +209,28 @@ func GetCephFSRadosNamespace(pathToConfig, clusterID string) (string, error) {
 	return cluster.CephFS.RadosNamespace, nil
 }
 
+// GetRBDRadosNamespaceOptions returns whether the RADOS namespace of the
+// given clusterID should be created when it does not exist, and the capacity
+// quota in bytes of the namespace in a pool. A quota of 0 is unlimited.
+func GetRBDRadosNamespaceOptions(pathToConfig, clusterID string) (bool, int64, error) {
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if cluster.RBD.RadosNamespaceQuota == "" {
+		return cluster.RBD.AutoCreateRadosNamespace, 0, nil
+	}
+
+	quota, err := resource.ParseQuantity(cluster.RBD.RadosNamespaceQuota)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid radosNamespaceQuota %q for cluster ID (%s): %w",
+			cluster.RBD.RadosNamespaceQuota, clusterID, err)
+	}
+
+	return cluster.RBD.AutoCreateRadosNamespace, quota.Value(), nil
+}
+
 // GetRBDMirrorDaemonCount returns the number of mirror daemon count for the
 // given clusterID.
 func GetRBDMirrorDaemonCount(pathToConfig, clusterID string) (int, error) {
@@ -155,6 +261,21 @@ func CephFSSubvolumeGroup(pathToConfig, clusterID string) (string, error) {
 	return cluster.CephFS.SubvolumeGroup, nil
 }
 
+// CephFSSubvolumeGroupCount returns the number of subvolumegroups that new
+// CephFS volumes are spread over. If not set, it returns 1.
+func CephFSSubvolumeGroupCount(pathToConfig, clusterID string) (int, error) {
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return 0, err
+	}
+
+	if cluster.CephFS.SubvolumeGroupCount < 1 {
+		return 1, nil
+	}
+
+	return cluster.CephFS.SubvolumeGroupCount, nil
+}
+
 // GetMonsAndClusterID returns monitors and clusterID information read from
 // configfile.
 func GetMonsAndClusterID(ctx context.Context, clusterID string, checkClusterIDMapping bool) (string, string, error) {
@@ -241,3 +362,55 @@ func GetCephFSMountOptions(pathToConfig, clusterID string) (string, string, erro
 
 	return cluster.CephFS.KernelMountOptions, cluster.CephFS.FuseMountOptions, nil
 }
+
+// GetRBDTenant returns the tenant that the given Kubernetes namespace is
+// mapped to in the configuration of the clusterID. nil is returned when the
+// namespace is not mapped.
+func GetRBDTenant(pathToConfig, clusterID, namespace string) (*kubernetes.Tenant, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range cluster.RBD.Tenants {
+		if slices.Contains(cluster.RBD.Tenants[i].Namespaces, namespace) {
+			return validateTenant(&cluster.RBD.Tenants[i], clusterID)
+		}
+	}
+
+	return nil, nil
+}
+
+// GetRBDTenantByClusterID returns the tenant that uses the given clusterID
+// for its volumes, so that operations on existing volumes use the Ceph user
+// of the tenant as well. nil is returned when the clusterID is not used by
+// a tenant.
+func GetRBDTenantByClusterID(pathToConfig, clusterID string) (*kubernetes.Tenant, error) {
+	config, err := readClusterConfig(pathToConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching configuration for cluster ID %q: %w", clusterID, err)
+	}
+
+	for i := range config {
+		for j := range config[i].RBD.Tenants {
+			if config[i].RBD.Tenants[j].ClusterID == clusterID {
+				return validateTenant(&config[i].RBD.Tenants[j], config[i].ClusterID)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func validateTenant(tenant *kubernetes.Tenant, clusterID string) (*kubernetes.Tenant, error) {
+	if tenant.ClusterID == "" || tenant.SecretName == "" || tenant.SecretNamespace == "" {
+		return nil, fmt.Errorf("tenant of namespaces %v for cluster ID (%s) needs a clusterID, "+
+			"secretName and secretNamespace", tenant.Namespaces, clusterID)
+	}
+
+	return tenant, nil
+}
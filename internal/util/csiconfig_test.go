@@ -612,3 +612,199 @@ func TestGetRBDMirrorDaemonCount(t *testing.T) {
 	_, err = GetRBDMirrorDaemonCount(tmpCSIConfPath, "test")
 	require.Error(t, err)
 }
+
+func TestCephFSSubvolumeGroupCount(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		clusterID string
+		want      int
+	}{
+		{
+			name:      "get subvolumegroup count for cluster-1",
+			clusterID: "cluster-1",
+			want:      16,
+		},
+		{
+			name:      "when subvolumegroup count is empty",
+			clusterID: "cluster-2",
+			want:      1,
+		},
+		{
+			name:      "when subvolumegroup count is negative",
+			clusterID: "cluster-3",
+			want:      1,
+		},
+	}
+
+	csiConfig := []cephcsi.ClusterInfo{
+		{
+			ClusterID: "cluster-1",
+			Monitors:  []string{"ip-1", "ip-2"},
+			CephFS: cephcsi.CephFS{
+				SubvolumeGroupCount: 16,
+			},
+		},
+		{
+			ClusterID: "cluster-2",
+			Monitors:  []string{"ip-3", "ip-4"},
+		},
+		{
+			ClusterID: "cluster-3",
+			Monitors:  []string{"ip-5", "ip-6"},
+			CephFS: cephcsi.CephFS{
+				SubvolumeGroupCount: -1,
+			},
+		},
+	}
+	csiConfigFileContent, err := json.Marshal(csiConfig)
+	if err != nil {
+		t.Errorf("failed to marshal csi config info %v", err)
+	}
+	tmpConfPath := t.TempDir() + "/ceph-csi.json"
+	err = os.WriteFile(tmpConfPath, csiConfigFileContent, 0o600)
+	if err != nil {
+		t.Errorf("failed to write %s file content: %v", CsiConfigFile, err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := CephFSSubvolumeGroupCount(tmpConfPath, tt.clusterID)
+			if err != nil {
+				t.Errorf("CephFSSubvolumeGroupCount() error = %v", err)
+
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CephFSSubvolumeGroupCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRBDRadosNamespaceOptions(t *testing.T) {
+	t.Parallel()
+
+	csiConfig := []cephcsi.ClusterInfo{
+		{
+			ClusterID: "cluster-1",
+			Monitors:  []string{"ip-1", "ip-2"},
+			RBD: cephcsi.RBD{
+				RadosNamespace:           "tenant-a",
+				AutoCreateRadosNamespace: true,
+				RadosNamespaceQuota:      "10Gi",
+			},
+		},
+		{
+			ClusterID: "cluster-2",
+			Monitors:  []string{"ip-3", "ip-4"},
+		},
+		{
+			ClusterID: "cluster-3",
+			Monitors:  []string{"ip-5", "ip-6"},
+			RBD: cephcsi.RBD{
+				RadosNamespaceQuota: "ten gigabytes",
+			},
+		},
+	}
+	csiConfigFileContent, err := json.Marshal(csiConfig)
+	require.NoError(t, err)
+	tmpConfPath := t.TempDir() + "/ceph-csi.json"
+	err = os.WriteFile(tmpConfPath, csiConfigFileContent, 0o600)
+	require.NoError(t, err)
+
+	autoCreate, quota, err := GetRBDRadosNamespaceOptions(tmpConfPath, "cluster-1")
+	require.NoError(t, err)
+	require.True(t, autoCreate)
+	require.Equal(t, int64(10*1024*1024*1024), quota)
+
+	autoCreate, quota, err = GetRBDRadosNamespaceOptions(tmpConfPath, "cluster-2")
+	require.NoError(t, err)
+	require.False(t, autoCreate)
+	require.Zero(t, quota)
+
+	_, _, err = GetRBDRadosNamespaceOptions(tmpConfPath, "cluster-3")
+	require.Error(t, err)
+}
+
+func TestGetRBDTenant(t *testing.T) {
+	t.Parallel()
+
+	csiConfig := []cephcsi.ClusterInfo{
+		{
+			ClusterID: "cluster-1",
+			Monitors:  []string{"ip-1", "ip-2"},
+			RBD: cephcsi.RBD{
+				Tenants: []cephcsi.Tenant{
+					{
+						Namespaces:      []string{"team-a", "team-a-dev"},
+						ClusterID:       "cluster-1-team-a",
+						SecretName:      "csi-rbd-team-a",
+						SecretNamespace: "ceph-csi",
+					},
+					{
+						Namespaces: []string{"team-b"},
+						ClusterID:  "cluster-1-team-b",
+					},
+				},
+			},
+		},
+		{
+			ClusterID: "cluster-1-team-a",
+			Monitors:  []string{"ip-1", "ip-2"},
+			RBD: cephcsi.RBD{
+				RadosNamespace: "team-a",
+			},
+		},
+	}
+	csiConfigFileContent, err := json.Marshal(csiConfig)
+	require.NoError(t, err)
+	tmpConfPath := t.TempDir() + "/ceph-csi.json"
+	err = os.WriteFile(tmpConfPath, csiConfigFileContent, 0o600)
+	require.NoError(t, err)
+
+	tenant, err := GetRBDTenant(tmpConfPath, "cluster-1", "team-a-dev")
+	require.NoError(t, err)
+	require.NotNil(t, tenant)
+	require.Equal(t, "cluster-1-team-a", tenant.ClusterID)
+	require.Equal(t, "csi-rbd-team-a", tenant.SecretName)
+
+	tenant, err = GetRBDTenant(tmpConfPath, "cluster-1", "team-c")
+	require.NoError(t, err)
+	require.Nil(t, tenant)
+
+	tenant, err = GetRBDTenant(tmpConfPath, "cluster-1", "")
+	require.NoError(t, err)
+	require.Nil(t, tenant)
+
+	// the tenant of team-b has no secret
+	_, err = GetRBDTenant(tmpConfPath, "cluster-1", "team-b")
+	require.Error(t, err)
+
+	tenant, err = GetRBDTenantByClusterID(tmpConfPath, "cluster-1-team-a")
+	require.NoError(t, err)
+	require.NotNil(t, tenant)
+	require.Equal(t, "ceph-csi", tenant.SecretNamespace)
+
+	tenant, err = GetRBDTenantByClusterID(tmpConfPath, "cluster-1")
+	require.NoError(t, err)
+	require.Nil(t, tenant)
+}
+
+func TestMergeClusterConfig(t *testing.T) {
+	t.Parallel()
+
+	clusters := []cephcsi.ClusterInfo{
+		{ClusterID: "cluster-1", Monitors: []string{"crd-mon:6789"}},
+	}
+	config := []cephcsi.ClusterInfo{
+		{ClusterID: "cluster-1", Monitors: []string{"file-mon:6789"}},
+		{ClusterID: "cluster-2", Monitors: []string{"file-mon:6789"}},
+	}
+
+	merged := mergeClusterConfig(clusters, config)
+	require.Len(t, merged, 2)
+	require.Equal(t, "cluster-1", merged[0].ClusterID)
+	require.Equal(t, []string{"crd-mon:6789"}, merged[0].Monitors)
+	require.Equal(t, "cluster-2", merged[1].ClusterID)
+}
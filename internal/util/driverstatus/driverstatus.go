@@ -0,0 +1,196 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package driverstatus writes the status of a driver to a ConfigMap at an
+// interval, so that operators that deploy the driver can report its health
+// without scraping the metrics endpoint.
+package driverstatus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/readiness"
+
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// statusKey is the key in the data of the ConfigMap with the Status.
+const statusKey = "status.json"
+
+// VolumeCount is the number of volumes in the journal of a pool (or a
+// filesystem) of a cluster.
+type VolumeCount struct {
+	ClusterID string `json:"clusterID"`
+	Pool      string `json:"pool"`
+	Volumes   int    `json:"volumes"`
+	// Error is set when the volumes could not be counted.
+	Error string `json:"error,omitempty"`
+}
+
+// VolumeCounter counts the volumes of a driver per cluster and pool.
+type VolumeCounter func(ctx context.Context) []VolumeCount
+
+// Status is the state of a driver as written to the ConfigMap.
+type Status struct {
+	DriverName string `json:"driverName"`
+	DriverType string `json:"driverType"`
+	Version    string `json:"version"`
+	GitCommit  string `json:"gitCommit,omitempty"`
+	// Features are the enabled feature gates.
+	Features []string `json:"features"`
+	// Ready is false when a cluster is not ready, it is not set when the
+	// readiness checks are not enabled.
+	Ready    *bool              `json:"ready,omitempty"`
+	Clusters []readiness.Status `json:"clusters,omitempty"`
+	// TotalVolumes is the sum of the Volumes of all pools.
+	TotalVolumes int           `json:"totalVolumes"`
+	Volumes      []VolumeCount `json:"volumes"`
+	LastUpdated  time.Time     `json:"lastUpdated"`
+}
+
+// Options configure the contents and the ConfigMap of a Reporter.
+type Options struct {
+	DriverName string
+	DriverType string
+	Version    string
+	GitCommit  string
+	// Interval is the time between the updates of the ConfigMap.
+	Interval time.Duration
+	// Namespace and ConfigMap are the ConfigMap to write the Status to.
+	Namespace string
+	ConfigMap string
+	// Features returns the enabled features.
+	Features func() []string
+	// Checker is the readiness Checker of the driver, the clusters are
+	// not reported when it is nil.
+	Checker *readiness.Checker
+	// CountVolumes counts the volumes of the driver.
+	CountVolumes VolumeCounter
+}
+
+// Reporter writes the Status of a driver to a ConfigMap at an interval.
+type Reporter struct {
+	opts   Options
+	client *k8s.Clientset
+}
+
+// NewReporter returns a Reporter that writes the ConfigMap with client.
+func NewReporter(client *k8s.Clientset, opts Options) *Reporter {
+	return &Reporter{
+		opts:   opts,
+		client: client,
+	}
+}
+
+// Run writes the Status until the context is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.report(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reporter) report(ctx context.Context) {
+	status := r.collect(ctx)
+
+	err := r.writeConfigMap(ctx, status)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to write status of driver %q to ConfigMap %s/%s: %v",
+			r.opts.DriverName, r.opts.Namespace, r.opts.ConfigMap, err)
+
+		return
+	}
+	log.DebugLog(ctx, "status of driver %q written to ConfigMap %s/%s", r.opts.DriverName,
+		r.opts.Namespace, r.opts.ConfigMap)
+}
+
+// collect returns the current Status of the driver.
+func (r *Reporter) collect(ctx context.Context) *Status {
+	status := &Status{
+		DriverName:  r.opts.DriverName,
+		DriverType:  r.opts.DriverType,
+		Version:     r.opts.Version,
+		GitCommit:   r.opts.GitCommit,
+		Features:    []string{},
+		Volumes:     []VolumeCount{},
+		LastUpdated: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if r.opts.Features != nil {
+		status.Features = r.opts.Features()
+	}
+
+	if r.opts.Checker != nil {
+		ready := r.opts.Checker.Ready()
+		status.Ready = &ready
+		status.Clusters = r.opts.Checker.Statuses()
+	}
+
+	if r.opts.CountVolumes != nil {
+		status.Volumes = r.opts.CountVolumes(ctx)
+	}
+	slices.SortFunc(status.Volumes, func(a, b VolumeCount) int {
+		return strings.Compare(a.ClusterID+"/"+a.Pool, b.ClusterID+"/"+b.Pool)
+	})
+	for _, vc := range status.Volumes {
+		status.TotalVolumes += vc.Volumes
+	}
+
+	return status
+}
+
+func (r *Reporter) writeConfigMap(ctx context.Context, status *Status) error {
+	value, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+	data := map[string]string{statusKey: string(value)}
+
+	return kubeclient.WriteConfigMap(ctx, r.client, r.opts.Namespace, r.opts.ConfigMap, data)
+}
+
+// Start runs a Reporter in the background, in the replica of the provisioner
+// that holds the status reporting lease of the driver, so that the ConfigMap
+// is written by a single replica.
+func Start(opts Options) error {
+	if opts.Namespace == "" {
+		return fmt.Errorf("the namespace of the driver is required to write the status to ConfigMap %q",
+			opts.ConfigMap)
+	}
+
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	return kubeclient.StartElected(client, opts.Namespace, opts.DriverName+"-status-report",
+		NewReporter(client, opts).Run)
+}
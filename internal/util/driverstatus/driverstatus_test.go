@@ -0,0 +1,60 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driverstatus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	r := NewReporter(nil, Options{
+		DriverName: "rbd.csi.ceph.com",
+		DriverType: "rbd",
+		Version:    "canary",
+		Features: func() []string {
+			return []string{"GroupSnapshot"}
+		},
+		CountVolumes: func(context.Context) []VolumeCount {
+			return []VolumeCount{
+				{ClusterID: "cluster-b", Pool: "replicapool", Volumes: 3},
+				{ClusterID: "cluster-a", Pool: "replicapool", Volumes: 2},
+				{ClusterID: "cluster-a", Pool: "ecpool", Error: "permission denied"},
+			}
+		},
+	})
+
+	status := r.collect(context.TODO())
+	require.Equal(t, "rbd.csi.ceph.com", status.DriverName)
+	require.Equal(t, []string{"GroupSnapshot"}, status.Features)
+	require.Nil(t, status.Ready)
+	require.Equal(t, 5, status.TotalVolumes)
+	require.Equal(t, []VolumeCount{
+		{ClusterID: "cluster-a", Pool: "ecpool", Error: "permission denied"},
+		{ClusterID: "cluster-a", Pool: "replicapool", Volumes: 2},
+		{ClusterID: "cluster-b", Pool: "replicapool", Volumes: 3},
+	}, status.Volumes)
+
+	status = NewReporter(nil, Options{DriverName: "cephfs.csi.ceph.com"}).collect(context.TODO())
+	require.Empty(t, status.Features)
+	require.Empty(t, status.Volumes)
+	require.Zero(t, status.TotalVolumes)
+}
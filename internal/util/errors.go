@@ -34,6 +34,15 @@ var (
 	ErrPoolNotFound = errors.New("pool not found")
 	// ErrClusterIDNotSet is returned when cluster id is not set.
 	ErrClusterIDNotSet = errors.New("clusterID must be set")
+	// ErrMissingClusterConfig is returned when the clusterID is not in the
+	// csi config.
+	ErrMissingClusterConfig = errors.New("missing configuration")
 	// ErrMissingConfigForMonitor is returned when clusterID is not found for the mon.
 	ErrMissingConfigForMonitor = errors.New("missing configuration of cluster ID for monitor")
+	// ErrNodeCapabilityUnsupported is returned when a volume requires a
+	// feature that was not detected on the node.
+	ErrNodeCapabilityUnsupported = errors.New("capability not supported by node")
+	// ErrPoolUsageExceeded is returned when an operation would push the
+	// usage of a pool over the configured threshold.
+	ErrPoolUsageExceeded = errors.New("pool usage exceeds threshold")
 )
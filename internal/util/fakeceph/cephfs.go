@@ -0,0 +1,681 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeceph
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// noGroup is the subvolumegroup of the subvolumes that are created
+	// without a group, it is not listed.
+	noGroup = "_nogroup"
+
+	// infinite is the quota of a subvolume without a size.
+	infinite = "infinite"
+
+	// timeLayout is the format of the timestamps of the mgr/volumes module.
+	timeLayout = "2006-01-02 15:04:05"
+)
+
+// subVolumeFeatures are the features of the subvolumes, like for a
+// subvolume of version 2.
+var subVolumeFeatures = []string{"snapshot-clone", "snapshot-autoprotect", "snapshot-retention"}
+
+type fileSystem struct {
+	id           int64
+	metadataPool string
+	dataPools    []string
+	groups       map[string]*subVolumeGroup
+}
+
+type subVolumeGroup struct {
+	subVolumes map[string]*subVolume
+}
+
+type subVolume struct {
+	path      string
+	dataPool  string
+	createdAt time.Time
+	// size is the quota of the subvolume, 0 is infinite
+	size int64
+	used int64
+	// retained is set when the subvolume was removed while its snapshots
+	// were retained
+	retained  bool
+	metadata  map[string]string
+	snapshots map[string]*subVolumeSnapshot
+}
+
+type subVolumeSnapshot struct {
+	createdAt time.Time
+	size      int64
+	metadata  map[string]string
+}
+
+// AddFileSystem adds a CephFS filesystem with its pools to the cluster. The
+// first data pool is the default data pool of the subvolumes.
+func (cl *Cluster) AddFileSystem(name, metadataPool string, dataPools ...string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	cl.lastFSID++
+	cl.poolID(metadataPool)
+	for _, pool := range dataPools {
+		cl.poolID(pool)
+	}
+	cl.fileSystems[name] = &fileSystem{
+		id:           cl.lastFSID,
+		metadataPool: metadataPool,
+		dataPools:    dataPools,
+		groups: map[string]*subVolumeGroup{
+			noGroup: {subVolumes: map[string]*subVolume{}},
+		},
+	}
+}
+
+// SetSubVolumeUsage sets the number of bytes that are used by the data in a
+// subvolume, the subvolumes are empty when they are created.
+func (cl *Cluster) SetSubVolumeUsage(fsName, group, name string, used int64) error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	sv, err := cl.getSubVolume(command{"vol_name": fsName, "group_name": group, "sub_name": name})
+	if err != nil {
+		return err
+	}
+	sv.used = used
+
+	return nil
+}
+
+func (cl *Cluster) registerFSHandlers() {
+	cl.monHandlers["fs ls"] = (*Cluster).listFileSystems
+	cl.monHandlers["fs dump"] = (*Cluster).dumpFileSystems
+
+	cl.mgrHandlers["fs volume ls"] = (*Cluster).listVolumes
+	cl.mgrHandlers["fs subvolumegroup create"] = (*Cluster).createSubVolumeGroup
+	cl.mgrHandlers["fs subvolumegroup ls"] = (*Cluster).listSubVolumeGroups
+	cl.mgrHandlers["fs subvolumegroup rm"] = (*Cluster).removeSubVolumeGroup
+	cl.mgrHandlers["fs subvolumegroup getpath"] = (*Cluster).getSubVolumeGroupPath
+	cl.mgrHandlers["fs subvolume create"] = (*Cluster).createSubVolume
+	cl.mgrHandlers["fs subvolume ls"] = (*Cluster).listSubVolumes
+	cl.mgrHandlers["fs subvolume info"] = (*Cluster).getSubVolumeInfo
+	cl.mgrHandlers["fs subvolume getpath"] = (*Cluster).getSubVolumePath
+	cl.mgrHandlers["fs subvolume resize"] = (*Cluster).resizeSubVolume
+	cl.mgrHandlers["fs subvolume rm"] = (*Cluster).removeSubVolume
+	cl.mgrHandlers["fs subvolume metadata set"] = (*Cluster).setSubVolumeMetadata
+	cl.mgrHandlers["fs subvolume metadata get"] = (*Cluster).getSubVolumeMetadata
+	cl.mgrHandlers["fs subvolume metadata rm"] = (*Cluster).removeSubVolumeMetadata
+	cl.mgrHandlers["fs subvolume metadata ls"] = (*Cluster).listSubVolumeMetadata
+	cl.mgrHandlers["fs subvolume snapshot create"] = (*Cluster).createSnapshot
+	cl.mgrHandlers["fs subvolume snapshot rm"] = (*Cluster).removeSnapshot
+	cl.mgrHandlers["fs subvolume snapshot ls"] = (*Cluster).listSnapshots
+	cl.mgrHandlers["fs subvolume snapshot info"] = (*Cluster).getSnapshotInfo
+	cl.mgrHandlers["fs subvolume snapshot metadata set"] = (*Cluster).setSnapshotMetadata
+	cl.mgrHandlers["fs subvolume snapshot metadata get"] = (*Cluster).getSnapshotMetadata
+	cl.mgrHandlers["fs subvolume snapshot metadata rm"] = (*Cluster).removeSnapshotMetadata
+	cl.mgrHandlers["fs subvolume snapshot metadata ls"] = (*Cluster).listSnapshotMetadata
+}
+
+func (cl *Cluster) getFileSystem(cmd command) (*fileSystem, error) {
+	fs, ok := cl.fileSystems[cmd.str("vol_name")]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return fs, nil
+}
+
+// getGroup returns the subvolumegroup of the command, or the default group
+// when the command has no group_name.
+func (cl *Cluster) getGroup(cmd command) (*subVolumeGroup, error) {
+	fs, err := cl.getFileSystem(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	name := cmd.str("group_name")
+	if name == "" {
+		name = noGroup
+	}
+	group, ok := fs.groups[name]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return group, nil
+}
+
+// getSubVolume returns the subvolume of the command, a subvolume of which
+// only the snapshots are retained is returned too.
+func (cl *Cluster) getSubVolume(cmd command) (*subVolume, error) {
+	group, err := cl.getGroup(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	sv, ok := group.subVolumes[cmd.str("sub_name")]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return sv, nil
+}
+
+func (cl *Cluster) getSnapshot(cmd command) (*subVolumeSnapshot, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, ok := sv.snapshots[cmd.str("snap_name")]
+	if !ok {
+		return nil, errNotFound
+	}
+
+	return snap, nil
+}
+
+func (cl *Cluster) listFileSystems(command) ([]byte, string, error) {
+	type fsInfo struct {
+		Name           string   `json:"name"`
+		MetadataPool   string   `json:"metadata_pool"`
+		MetadataPoolID int      `json:"metadata_pool_id"`
+		DataPools      []string `json:"data_pools"`
+		DataPoolIDs    []int    `json:"data_pool_ids"`
+	}
+
+	list := []fsInfo{}
+	for name, fs := range cl.fileSystems {
+		info := fsInfo{
+			Name:           name,
+			MetadataPool:   fs.metadataPool,
+			MetadataPoolID: cl.poolID(fs.metadataPool),
+			DataPools:      fs.dataPools,
+			DataPoolIDs:    []int{},
+		}
+		for _, pool := range fs.dataPools {
+			info.DataPoolIDs = append(info.DataPoolIDs, cl.poolID(pool))
+		}
+		list = append(list, info)
+	}
+
+	return marshal(list)
+}
+
+func (cl *Cluster) dumpFileSystems(command) ([]byte, string, error) {
+	type mdsMap struct {
+		FSName string `json:"fs_name"`
+	}
+	type fsEntry struct {
+		ID     int64  `json:"id"`
+		MDSMap mdsMap `json:"mdsmap"`
+	}
+
+	dump := struct {
+		FileSystems []fsEntry `json:"filesystems"`
+	}{FileSystems: []fsEntry{}}
+	for name, fs := range cl.fileSystems {
+		dump.FileSystems = append(dump.FileSystems, fsEntry{ID: fs.id, MDSMap: mdsMap{FSName: name}})
+	}
+
+	data, _, err := marshal(dump)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, "dumped fsmap epoch 1", nil
+}
+
+func (cl *Cluster) listVolumes(command) ([]byte, string, error) {
+	return listNames(cl.fileSystems)
+}
+
+func (cl *Cluster) createSubVolumeGroup(cmd command) ([]byte, string, error) {
+	fs, err := cl.getFileSystem(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := cmd.str("group_name")
+	if _, ok := fs.groups[name]; !ok {
+		fs.groups[name] = &subVolumeGroup{subVolumes: map[string]*subVolume{}}
+	}
+
+	return nil, "", nil
+}
+
+func (cl *Cluster) listSubVolumeGroups(cmd command) ([]byte, string, error) {
+	fs, err := cl.getFileSystem(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listNames(fs.groups, noGroup)
+}
+
+func (cl *Cluster) removeSubVolumeGroup(cmd command) ([]byte, string, error) {
+	fs, err := cl.getFileSystem(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := cmd.str("group_name")
+	group, ok := fs.groups[name]
+	if !ok {
+		if cmd.flag("force") {
+			return nil, "", nil
+		}
+
+		return nil, fmt.Sprintf("subvolume group '%s' does not exist", name), errNotFound
+	}
+	if len(group.subVolumes) != 0 {
+		return nil, fmt.Sprintf("error in rmdir /volumes/%s", name), errNotEmpty
+	}
+	delete(fs.groups, name)
+
+	return nil, "", nil
+}
+
+func (cl *Cluster) getSubVolumeGroupPath(cmd command) ([]byte, string, error) {
+	_, err := cl.getGroup(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return []byte(path.Join("/volumes", cmd.str("group_name"))), "", nil
+}
+
+// number returns the numeric argument of the command, and false when it is
+// not set or infinite.
+func (c command) number(key string) (int64, bool, error) {
+	switch v := c[key].(type) {
+	case nil:
+		return 0, false, nil
+	case float64:
+		return int64(v), v != 0, nil
+	case string:
+		if v == infinite || v == "" {
+			return 0, false, nil
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false, errInvalid
+		}
+
+		return n, true, nil
+	}
+
+	return 0, false, errInvalid
+}
+
+func (cl *Cluster) createSubVolume(cmd command) ([]byte, string, error) {
+	fs, err := cl.getFileSystem(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	group, err := cl.getGroup(cmd)
+	if err != nil {
+		return nil, fmt.Sprintf("subvolume group '%s' does not exist", cmd.str("group_name")), err
+	}
+
+	size, _, err := cmd.number("size")
+	if err != nil {
+		return nil, "", err
+	}
+
+	dataPool := cmd.str("pool_layout")
+	if dataPool == "" && len(fs.dataPools) != 0 {
+		dataPool = fs.dataPools[0]
+	}
+	if _, ok := cl.pools[dataPool]; !ok {
+		return nil, fmt.Sprintf("invalid pool layout '%s'", dataPool), errInvalid
+	}
+
+	name := cmd.str("sub_name")
+	if sv, ok := group.subVolumes[name]; ok {
+		// creating an existing subvolume updates its size
+		if sv.retained {
+			return nil, fmt.Sprintf("subvolume '%s' exists, but is in snapshot-retained state", name), errExists
+		}
+		if size != 0 {
+			sv.size = size
+		}
+
+		return nil, "", nil
+	}
+
+	groupName := cmd.str("group_name")
+	if groupName == "" {
+		groupName = noGroup
+	}
+	group.subVolumes[name] = &subVolume{
+		path:      path.Join("/volumes", groupName, name, uuid.NewString()),
+		dataPool:  dataPool,
+		createdAt: time.Now().UTC().Truncate(time.Second),
+		size:      size,
+		metadata:  map[string]string{},
+		snapshots: map[string]*subVolumeSnapshot{},
+	}
+
+	return nil, "", nil
+}
+
+func (cl *Cluster) listSubVolumes(cmd command) ([]byte, string, error) {
+	group, err := cl.getGroup(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listNames(group.subVolumes)
+}
+
+func (cl *Cluster) getSubVolumeInfo(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	created := sv.createdAt.Format(timeLayout)
+	info := map[string]interface{}{
+		"type":           "subvolume",
+		"path":           sv.path,
+		"state":          "complete",
+		"uid":            0,
+		"gid":            0,
+		"mode":           0o40755,
+		"bytes_used":     sv.used,
+		"bytes_pcent":    "undefined",
+		"bytes_quota":    infinite,
+		"data_pool":      sv.dataPool,
+		"pool_namespace": "",
+		"atime":          created,
+		"mtime":          created,
+		"ctime":          created,
+		"created_at":     created,
+		"features":       subVolumeFeatures,
+	}
+	if sv.size != 0 {
+		info["bytes_quota"] = sv.size
+		info["bytes_pcent"] = fmt.Sprintf("%.2f", float64(sv.used)*100/float64(sv.size))
+	}
+	if sv.retained {
+		// a retained subvolume has no data, and reports only its state
+		info = map[string]interface{}{
+			"type":     "subvolume",
+			"state":    "snapshot-retained",
+			"features": subVolumeFeatures,
+		}
+	}
+
+	return marshal(info)
+}
+
+func (cl *Cluster) getSubVolumePath(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if sv.retained {
+		return nil, fmt.Sprintf("subvolume '%s' is removed and has only snapshots retained",
+			cmd.str("sub_name")), errNotFound
+	}
+
+	return []byte(sv.path), "", nil
+}
+
+func (cl *Cluster) resizeSubVolume(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	size, limited, err := cmd.number("new_size")
+	if err != nil {
+		return nil, fmt.Sprintf("Invalid size '%v'", cmd["new_size"]), err
+	}
+	if limited && cmd.flag("no_shrink") && size < sv.used {
+		return nil, fmt.Sprintf("Can't resize the subvolume. The new size '%d' would be lesser than "+
+			"the current used size '%d'", size, sv.used), errInvalid
+	}
+	sv.size = size
+
+	result := map[string]interface{}{
+		"bytes_used":  sv.used,
+		"bytes_quota": infinite,
+		"bytes_pcent": "undefined",
+	}
+	if limited {
+		result["bytes_quota"] = size
+		result["bytes_pcent"] = fmt.Sprintf("%.2f", float64(sv.used)*100/float64(size))
+	}
+
+	return marshal([]interface{}{result})
+}
+
+func (cl *Cluster) removeSubVolume(cmd command) ([]byte, string, error) {
+	group, err := cl.getGroup(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := cmd.str("sub_name")
+	sv, ok := group.subVolumes[name]
+	if !ok {
+		if cmd.flag("force") {
+			return nil, "", nil
+		}
+
+		return nil, fmt.Sprintf("subvolume '%s' does not exist", name), errNotFound
+	}
+
+	if len(sv.snapshots) != 0 {
+		if !cmd.flag("retain_snapshots") {
+			return nil, fmt.Sprintf("subvolume '%s' has snapshots", name), errNotEmpty
+		}
+		sv.retained = true
+
+		return nil, "", nil
+	}
+	delete(group.subVolumes, name)
+
+	return nil, "", nil
+}
+
+func (cl *Cluster) setSubVolumeMetadata(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return setMetadata(sv.metadata, cmd)
+}
+
+func (cl *Cluster) getSubVolumeMetadata(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return getMetadata(sv.metadata, cmd)
+}
+
+func (cl *Cluster) removeSubVolumeMetadata(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return removeMetadata(sv.metadata, cmd)
+}
+
+func (cl *Cluster) listSubVolumeMetadata(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return marshal(sv.metadata)
+}
+
+func (cl *Cluster) createSnapshot(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if sv.retained {
+		return nil, fmt.Sprintf("subvolume '%s' is removed and has only snapshots retained",
+			cmd.str("sub_name")), errNotFound
+	}
+
+	name := cmd.str("snap_name")
+	if _, ok := sv.snapshots[name]; ok {
+		return nil, fmt.Sprintf("snapshot '%s' already exists", name), errExists
+	}
+	sv.snapshots[name] = &subVolumeSnapshot{
+		createdAt: time.Now().UTC().Truncate(time.Second),
+		size:      sv.used,
+		metadata:  map[string]string{},
+	}
+
+	return nil, "", nil
+}
+
+func (cl *Cluster) removeSnapshot(cmd command) ([]byte, string, error) {
+	group, err := cl.getGroup(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	svName := cmd.str("sub_name")
+	name := cmd.str("snap_name")
+	sv, ok := group.subVolumes[svName]
+	if ok {
+		_, ok = sv.snapshots[name]
+	}
+	if !ok {
+		if cmd.flag("force") {
+			return nil, "", nil
+		}
+
+		return nil, fmt.Sprintf("snapshot '%s' does not exist", name), errNotFound
+	}
+
+	delete(sv.snapshots, name)
+	if sv.retained && len(sv.snapshots) == 0 {
+		// the last retained snapshot removes the subvolume
+		delete(group.subVolumes, svName)
+	}
+
+	return nil, "", nil
+}
+
+func (cl *Cluster) listSnapshots(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listNames(sv.snapshots)
+}
+
+func (cl *Cluster) getSnapshotInfo(cmd command) ([]byte, string, error) {
+	sv, err := cl.getSubVolume(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	snap, err := cl.getSnapshot(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return marshal(map[string]interface{}{
+		"created_at":         snap.createdAt.Format(timeLayout),
+		"data_pool":          sv.dataPool,
+		"has_pending_clones": "no",
+		"protected":          "yes",
+		"size":               snap.size,
+	})
+}
+
+func (cl *Cluster) setSnapshotMetadata(cmd command) ([]byte, string, error) {
+	snap, err := cl.getSnapshot(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return setMetadata(snap.metadata, cmd)
+}
+
+func (cl *Cluster) getSnapshotMetadata(cmd command) ([]byte, string, error) {
+	snap, err := cl.getSnapshot(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return getMetadata(snap.metadata, cmd)
+}
+
+func (cl *Cluster) removeSnapshotMetadata(cmd command) ([]byte, string, error) {
+	snap, err := cl.getSnapshot(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return removeMetadata(snap.metadata, cmd)
+}
+
+func (cl *Cluster) listSnapshotMetadata(cmd command) ([]byte, string, error) {
+	snap, err := cl.getSnapshot(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return marshal(snap.metadata)
+}
+
+func setMetadata(metadata map[string]string, cmd command) ([]byte, string, error) {
+	metadata[cmd.str("key_name")] = cmd.str("value")
+
+	return nil, "", nil
+}
+
+func getMetadata(metadata map[string]string, cmd command) ([]byte, string, error) {
+	key := cmd.str("key_name")
+	value, ok := metadata[key]
+	if !ok {
+		return nil, fmt.Sprintf("key '%s' does not exist", key), errNotFound
+	}
+
+	return []byte(value), "", nil
+}
+
+func removeMetadata(metadata map[string]string, cmd command) ([]byte, string, error) {
+	key := cmd.str("key_name")
+	if _, ok := metadata[key]; !ok && !cmd.flag("force") {
+		return nil, fmt.Sprintf("key '%s' does not exist", key), errNotFound
+	}
+	delete(metadata, key)
+
+	return nil, "", nil
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeceph simulates a Ceph cluster in memory, for unit tests and for
+// running cephcsi without a Ceph cluster. The Cluster implements the
+// RadosCommander of go-ceph, so that the admin APIs of go-ceph (like the
+// FSAdmin and RBDAdmin) can be used with it, like for CephFS subvolumes and
+// the RBD tasks and mirror snapshot schedules. The omaps of RADOS objects are
+// simulated by the Objects of a pool, they are used for the journal.
+//
+// The commands that are not simulated fail like on a Ceph cluster without a
+// handler for the command. The data of RADOS objects and RBD images (librbd)
+// are not simulated, the code that opens an image or an IOContext still
+// needs a Ceph cluster.
+//
+// When cephcsi is built with the fakeceph build tag, the connections of the
+// util package connect to the Cluster that is registered for the monitors,
+// see ClusterFor.
+package fakeceph
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"syscall"
+
+	ccom "github.com/ceph/go-ceph/common/commands"
+	"github.com/google/uuid"
+)
+
+var _ ccom.RadosCommander = &Cluster{}
+
+// cephError is an errno that is returned by a command, like the errors of the
+// rados package of go-ceph.
+type cephError int
+
+const (
+	errNotFound = cephError(-int(syscall.ENOENT))
+	errExists   = cephError(-int(syscall.EEXIST))
+	errInvalid  = cephError(-int(syscall.EINVAL))
+	errNotEmpty = cephError(-int(syscall.ENOTEMPTY))
+)
+
+// strerror contains the messages of the errnos as reported by Ceph.
+var strerror = map[cephError]string{
+	errNotFound: "No such file or directory",
+	errExists:   "File exists",
+	errInvalid:  "Invalid argument",
+	errNotEmpty: "Directory not empty",
+}
+
+func (e cephError) Error() string {
+	msg, ok := strerror[e]
+	if !ok {
+		msg = syscall.Errno(-e).Error()
+	}
+
+	return fmt.Sprintf("rados: ret=%d, %s", int(e), msg)
+}
+
+// ErrorCode returns the errno of the error.
+func (e cephError) ErrorCode() int {
+	return int(e)
+}
+
+// Is returns true for the errors of go-ceph with the same errno, so that
+// errors.Is(err, rados.ErrNotFound) matches a simulated ENOENT.
+func (e cephError) Is(target error) bool {
+	var ce interface{ ErrorCode() int }
+
+	return errors.As(target, &ce) && ce.ErrorCode() == int(e)
+}
+
+// command is a decoded JSON command.
+type command map[string]interface{}
+
+// str returns the string argument of the command, or an empty string.
+func (c command) str(key string) string {
+	s, _ := c[key].(string)
+
+	return s
+}
+
+// flag returns true when the boolean argument of the command is set.
+func (c command) flag(key string) bool {
+	switch v := c[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+
+	return false
+}
+
+// handler executes a command on the cluster, it is called with the mutex of
+// the cluster held. It returns the body and the status of the response.
+type handler func(cl *Cluster, cmd command) ([]byte, string, error)
+
+// Cluster is a simulated Ceph cluster.
+type Cluster struct {
+	mutex sync.Mutex
+
+	fsid string
+
+	mgrHandlers map[string]handler
+	monHandlers map[string]handler
+
+	// pools are the pools with their objects, by name.
+	pools map[string]*pool
+	// lastPoolID is the ID of the last pool that was added.
+	lastPoolID int
+	// createPools is set when the pools are created when they are first
+	// used, instead of failing with ENOENT.
+	createPools bool
+
+	fileSystems map[string]*fileSystem
+	// lastFSID is the ID of the last filesystem that was added.
+	lastFSID int64
+
+	// schedules are the mirror snapshot schedules, by level spec.
+	schedules map[string][]schedule
+
+	// tasks are the pending RBD tasks of the MGR.
+	tasks []task
+	// lastTask is the sequence number of the last task that was added.
+	lastTask int
+}
+
+// NewCluster returns an empty simulated cluster.
+func NewCluster() *Cluster {
+	cl := &Cluster{
+		fsid:        uuid.NewString(),
+		mgrHandlers: map[string]handler{},
+		monHandlers: map[string]handler{},
+		pools:       map[string]*pool{},
+		fileSystems: map[string]*fileSystem{},
+		schedules:   map[string][]schedule{},
+	}
+	cl.registerFSHandlers()
+	cl.registerRBDHandlers()
+
+	return cl
+}
+
+var (
+	clustersMutex sync.Mutex
+	// clusters are the registered clusters, by monitors.
+	clusters = map[string]*Cluster{}
+)
+
+// Register registers the cluster for the monitors. A cephcsi that is built
+// with the fakeceph build tag connects to it for the monitors.
+func Register(monitors string, cl *Cluster) {
+	clustersMutex.Lock()
+	defer clustersMutex.Unlock()
+
+	clusters[monitors] = cl
+}
+
+// ClusterFor returns the cluster that is registered for the monitors. When
+// no cluster is registered, a new cluster is registered that creates its
+// pools when they are first used, so that cephcsi can be started without a
+// cluster that was prepared by a test.
+func ClusterFor(monitors string) *Cluster {
+	clustersMutex.Lock()
+	defer clustersMutex.Unlock()
+
+	cl, ok := clusters[monitors]
+	if !ok {
+		cl = NewCluster()
+		cl.createPools = true
+		clusters[monitors] = cl
+	}
+
+	return cl
+}
+
+// poolID returns the ID of the pool, the pool is created when it does not
+// exist. The mutex of the cluster must be held.
+func (cl *Cluster) poolID(name string) int {
+	p, ok := cl.pools[name]
+	if !ok {
+		cl.lastPoolID++
+		p = &pool{id: cl.lastPoolID, objects: map[objectKey]*object{}}
+		cl.pools[name] = p
+	}
+
+	return p.id
+}
+
+// GetFSID returns the FSID of the cluster.
+func (cl *Cluster) GetFSID() (string, error) {
+	return cl.fsid, nil
+}
+
+// GetInstanceID returns the global ID of the session, all sessions of the
+// simulated cluster share the same ID.
+func (cl *Cluster) GetInstanceID() uint64 {
+	return 1
+}
+
+// GetAddrs returns the address of the session.
+func (cl *Cluster) GetAddrs() (string, error) {
+	return "127.0.0.1:0/1", nil
+}
+
+// MgrCommand executes a JSON command of the MGR.
+func (cl *Cluster) MgrCommand(buf [][]byte) ([]byte, string, error) {
+	if len(buf) == 0 {
+		return nil, "", errInvalid
+	}
+
+	return cl.execute(cl.mgrHandlers, buf[0])
+}
+
+// MonCommand executes a JSON command of the MONs.
+func (cl *Cluster) MonCommand(buf []byte) ([]byte, string, error) {
+	return cl.execute(cl.monHandlers, buf)
+}
+
+func (cl *Cluster) execute(handlers map[string]handler, buf []byte) ([]byte, string, error) {
+	cmd := command{}
+	err := json.Unmarshal(buf, &cmd)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid command %q: %v", buf, err), errInvalid
+	}
+
+	prefix := cmd.str("prefix")
+	h, ok := handlers[prefix]
+	if !ok {
+		// go-ceph reports these as NotImplementedError
+		return nil, fmt.Sprintf("No handler found for '%s'", prefix), errInvalid
+	}
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return h(cl, cmd)
+}
+
+// listNames returns the JSON list of names, as returned by the "ls" commands.
+func listNames[T any](items map[string]T, skip ...string) ([]byte, string, error) {
+	type namedItem struct {
+		Name string `json:"name"`
+	}
+
+	names := []namedItem{}
+	for name := range items {
+		if !slices.Contains(skip, name) {
+			names = append(names, namedItem{Name: name})
+		}
+	}
+	slices.SortFunc(names, func(a, b namedItem) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return marshal(names)
+}
+
+// marshal returns the JSON body of a response.
+func marshal(v interface{}) ([]byte, string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err.Error(), errInvalid
+	}
+
+	return data, "", nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeceph
+
+import (
+	"encoding/json"
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/ceph/go-ceph/rbd/admin"
+	"github.com/stretchr/testify/require"
+)
+
+// mgrCommand sends the command as JSON to the MGR of the cluster.
+func mgrCommand(t *testing.T, cl *Cluster, cmd map[string]interface{}) ([]byte, string, error) {
+	t.Helper()
+
+	buf, err := json.Marshal(cmd)
+	require.NoError(t, err)
+
+	return cl.MgrCommand([][]byte{buf})
+}
+
+// errnoError is like the errors of the rados package of go-ceph.
+type errnoError int
+
+func (e errnoError) Error() string  { return syscall.Errno(-e).Error() }
+func (e errnoError) ErrorCode() int { return int(e) }
+
+func TestCephError(t *testing.T) {
+	t.Parallel()
+
+	require.ErrorIs(t, errNotFound, errnoError(-int(syscall.ENOENT)))
+	require.NotErrorIs(t, errNotFound, errnoError(-int(syscall.EEXIST)))
+	require.Contains(t, errNotEmpty.Error(), "Directory not empty")
+	require.False(t, errors.Is(errInvalid, errors.New("invalid")))
+}
+
+func TestSubVolumes(t *testing.T) {
+	t.Parallel()
+
+	cl := NewCluster()
+	cl.AddFileSystem("myfs", "myfs-metadata", "myfs-data0")
+
+	// the subvolumegroup has to exist
+	_, _, err := mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume create", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+	})
+	require.ErrorIs(t, err, errNotFound)
+
+	_, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolumegroup create", "vol_name": "myfs", "group_name": "csi",
+	})
+	require.NoError(t, err)
+
+	_, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume create", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+		"size": 1024,
+	})
+	require.NoError(t, err)
+
+	body, _, err := mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume info", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+	})
+	require.NoError(t, err)
+	info := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(body, &info))
+	require.InDelta(t, 1024, info["bytes_quota"], 0)
+	require.Equal(t, "myfs-data0", info["data_pool"])
+
+	// shrinking below the used size fails with no_shrink
+	require.NoError(t, cl.SetSubVolumeUsage("myfs", "csi", "csi-vol-1", 512))
+	_, status, err := mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume resize", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+		"new_size": "256", "no_shrink": true,
+	})
+	require.ErrorIs(t, err, errInvalid)
+	require.Contains(t, status, "lesser than the current used size")
+
+	// a subvolume with snapshots is only removed with retain_snapshots
+	_, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume snapshot create", "vol_name": "myfs", "group_name": "csi",
+		"sub_name": "csi-vol-1", "snap_name": "csi-snap-1",
+	})
+	require.NoError(t, err)
+	_, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume rm", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+	})
+	require.ErrorIs(t, err, errNotEmpty)
+	_, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume rm", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+		"retain_snapshots": true,
+	})
+	require.NoError(t, err)
+
+	body, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume info", "vol_name": "myfs", "group_name": "csi", "sub_name": "csi-vol-1",
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(body), "snapshot-retained")
+
+	// removing the last snapshot removes the subvolume
+	_, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume snapshot rm", "vol_name": "myfs", "group_name": "csi",
+		"sub_name": "csi-vol-1", "snap_name": "csi-snap-1",
+	})
+	require.NoError(t, err)
+	body, _, err = mgrCommand(t, cl, map[string]interface{}{
+		"prefix": "fs subvolume ls", "vol_name": "myfs", "group_name": "csi",
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, "[]", string(body))
+}
+
+func TestUnknownCommand(t *testing.T) {
+	t.Parallel()
+
+	_, status, err := mgrCommand(t, NewCluster(), map[string]interface{}{"prefix": "fs subvolume pin"})
+	require.ErrorIs(t, err, errInvalid)
+	require.Contains(t, status, "No handler found")
+}
+
+func TestMirrorSnapshotSchedules(t *testing.T) {
+	t.Parallel()
+
+	cl := NewCluster()
+	mss := admin.NewFromConn(cl).MirrorSnashotSchedule()
+	image := admin.NewLevelSpec("replicapool", "", "csi-vol-1")
+
+	require.NoError(t, mss.Add(image, admin.Interval("1h"), admin.NoStartTime))
+	require.NoError(t, mss.Add(image, admin.Interval("1h"), admin.NoStartTime))
+	require.NoError(t, mss.Add(admin.NewLevelSpec("otherpool", "", "csi-vol-2"), admin.Interval("1d"),
+		admin.NoStartTime))
+
+	schedules, err := mss.List(admin.NewLevelSpec("replicapool", "", ""))
+	require.NoError(t, err)
+	require.Len(t, schedules, 1)
+	require.Equal(t, []admin.ScheduleTerm{{Interval: "1h"}}, schedules[0].Schedule)
+
+	cl.RemoveSchedules("replicapool/csi-vol-1")
+	schedules, err = mss.List(image)
+	require.NoError(t, err)
+	require.Empty(t, schedules)
+
+	require.Error(t, mss.Remove(image, admin.Interval("1h"), admin.NoStartTime))
+}
+
+func TestObjects(t *testing.T) {
+	t.Parallel()
+
+	cl := NewCluster()
+	_, err := cl.OpenObjects("replicapool")
+	require.ErrorIs(t, err, errNotFound)
+
+	cl.AddPool("replicapool")
+	objs, err := cl.OpenObjects("replicapool")
+	require.NoError(t, err)
+	objs.SetNamespace("ns")
+
+	require.NoError(t, objs.Create("csi.volumes.default"))
+	require.ErrorIs(t, objs.Create("csi.volumes.default"), errExists)
+
+	require.NoError(t, objs.SetOmap("csi.volumes.default", map[string][]byte{
+		"csi.volume.pvc-1": []byte("uuid-1"),
+		"csi.volume.pvc-2": []byte("uuid-2"),
+		"other":            []byte("value"),
+	}))
+	values, err := objs.GetOmapValuesByKeys("csi.volumes.default", []string{"csi.volume.pvc-1", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"csi.volume.pvc-1": []byte("uuid-1")}, values)
+
+	keys := []string{}
+	require.NoError(t, objs.ListOmapValues("csi.volumes.default", "csi.volume.pvc-1", "csi.volume.", 10,
+		func(key string, _ []byte) {
+			keys = append(keys, key)
+		}))
+	require.Equal(t, []string{"csi.volume.pvc-2"}, keys)
+
+	// the update is rejected when the object was modified
+	version, err := objs.GetVersion("csi.volumes.default")
+	require.NoError(t, err)
+	require.NoError(t, objs.RmOmapKeys("csi.volumes.default", []string{"other"}))
+	_, err = objs.UpdateOmap("csi.volumes.default", version, nil, []string{"csi.volume.pvc-2"})
+	require.ErrorIs(t, err, errVersionNewer)
+	newVersion, err := objs.UpdateOmap("csi.volumes.default", version+1, nil, []string{"csi.volume.pvc-2"})
+	require.NoError(t, err)
+	require.Equal(t, version+2, newVersion)
+
+	// the objects of other namespaces are separate
+	other, err := cl.OpenObjects("replicapool")
+	require.NoError(t, err)
+	_, err = other.GetOmapValuesByKeys("csi.volumes.default", nil)
+	require.ErrorIs(t, err, errNotFound)
+
+	require.NoError(t, objs.Delete("csi.volumes.default"))
+	require.ErrorIs(t, objs.Delete("csi.volumes.default"), errNotFound)
+
+	cl.RemovePool("replicapool")
+	require.ErrorIs(t, objs.Create("csi.volumes.default"), errNotFound)
+}
+
+func TestClusterFor(t *testing.T) {
+	t.Parallel()
+
+	cl := NewCluster()
+	Register(t.Name(), cl)
+	require.Same(t, cl, ClusterFor(t.Name()))
+
+	// an unregistered cluster creates its pools when they are used
+	created := ClusterFor(t.Name() + "-created")
+	require.Same(t, created, ClusterFor(t.Name()+"-created"))
+	id, err := created.GetPoolByName("replicapool")
+	require.NoError(t, err)
+	name, err := created.GetPoolByID(id)
+	require.NoError(t, err)
+	require.Equal(t, "replicapool", name)
+}
+
+func TestTasks(t *testing.T) {
+	t.Parallel()
+
+	cl := NewCluster()
+	ta := admin.NewFromConn(cl).Task()
+	image := admin.NewImageSpec("replicapool", "", "csi-vol-1")
+
+	task, err := ta.AddFlatten(image)
+	require.NoError(t, err)
+	require.Equal(t, "flatten", task.Refs.Action)
+	require.Equal(t, "csi-vol-1", task.Refs.ImageName)
+
+	// the task of the image is returned again
+	again, err := ta.AddFlatten(image)
+	require.NoError(t, err)
+	require.Equal(t, task.ID, again.ID)
+
+	_, err = ta.AddTrashRemove(admin.NewImageSpec("replicapool", "ns", "1234"))
+	require.NoError(t, err)
+	tasks, err := ta.List()
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+
+	_, err = ta.Cancel(task.ID)
+	require.NoError(t, err)
+	_, err = ta.GetTaskByID(task.ID)
+	require.Error(t, err)
+
+	require.Equal(t, 1, cl.CompleteTasks())
+	tasks, err = ta.List()
+	require.NoError(t, err)
+	require.Empty(t, tasks)
+}
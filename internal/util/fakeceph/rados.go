@@ -0,0 +1,312 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeceph
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+const (
+	// errVersionNewer is returned when an asserted version is older than
+	// the version of the object.
+	errVersionNewer = cephError(-int(syscall.ERANGE))
+	// errVersionOlder is returned when an asserted version is newer than
+	// the version of the object.
+	errVersionOlder = cephError(-int(syscall.EOVERFLOW))
+)
+
+// objectKey identifies an object in a pool.
+type objectKey struct {
+	namespace string
+	oid       string
+}
+
+// object is a RADOS object, only its omap is simulated. The version is
+// increased by every write, like the version of a RADOS object.
+type object struct {
+	version uint64
+	omap    map[string][]byte
+}
+
+// pool is a RADOS pool with its objects.
+type pool struct {
+	id      int
+	objects map[objectKey]*object
+}
+
+// AddPool adds an empty pool to the cluster.
+func (cl *Cluster) AddPool(name string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	cl.poolID(name)
+}
+
+// RemovePool removes the pool and its objects from the cluster.
+func (cl *Cluster) RemovePool(name string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	delete(cl.pools, name)
+}
+
+// getPool returns the pool with the name, it fails with ENOENT when the pool
+// does not exist, unless the cluster creates its pools when they are used.
+// The mutex of the cluster must be held.
+func (cl *Cluster) getPool(name string) (*pool, error) {
+	if _, ok := cl.pools[name]; !ok && !cl.createPools {
+		return nil, errNotFound
+	}
+	cl.poolID(name)
+
+	return cl.pools[name], nil
+}
+
+// GetPoolByName returns the ID of the pool with the name.
+func (cl *Cluster) GetPoolByName(name string) (int64, error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	p, err := cl.getPool(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(p.id), nil
+}
+
+// GetPoolByID returns the name of the pool with the ID.
+func (cl *Cluster) GetPoolByID(id int64) (string, error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	for name, p := range cl.pools {
+		if int64(p.id) == id {
+			return name, nil
+		}
+	}
+
+	return "", errNotFound
+}
+
+// Objects are the objects of a pool, in a RADOS namespace, like an IOContext
+// of go-ceph. The objects are shared by all Objects of the pool.
+type Objects struct {
+	cluster   *Cluster
+	pool      string
+	namespace string
+}
+
+// OpenObjects returns the Objects of the pool.
+func (cl *Cluster) OpenObjects(poolName string) (*Objects, error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	_, err := cl.getPool(poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Objects{cluster: cl, pool: poolName}, nil
+}
+
+// SetNamespace sets the RADOS namespace of the objects.
+func (o *Objects) SetNamespace(namespace string) {
+	o.namespace = namespace
+}
+
+// Destroy does nothing, the objects are kept in the cluster.
+func (o *Objects) Destroy() {}
+
+// withPool calls fn with the objects of the pool, with the mutex of the
+// cluster held. It fails with ENOENT when the pool was removed.
+func (o *Objects) withPool(fn func(objects map[objectKey]*object) error) error {
+	o.cluster.mutex.Lock()
+	defer o.cluster.mutex.Unlock()
+
+	p, ok := o.cluster.pools[o.pool]
+	if !ok {
+		return errNotFound
+	}
+
+	return fn(p.objects)
+}
+
+// withObject calls fn with the object, it fails with ENOENT when the object
+// does not exist.
+func (o *Objects) withObject(oid string, fn func(obj *object) error) error {
+	return o.withPool(func(objects map[objectKey]*object) error {
+		obj, ok := objects[objectKey{namespace: o.namespace, oid: oid}]
+		if !ok {
+			return errNotFound
+		}
+
+		return fn(obj)
+	})
+}
+
+// Create creates the object, it fails with EEXIST when the object exists.
+func (o *Objects) Create(oid string) error {
+	return o.withPool(func(objects map[objectKey]*object) error {
+		key := objectKey{namespace: o.namespace, oid: oid}
+		if _, ok := objects[key]; ok {
+			return errExists
+		}
+		objects[key] = &object{version: 1, omap: map[string][]byte{}}
+
+		return nil
+	})
+}
+
+// Delete removes the object.
+func (o *Objects) Delete(oid string) error {
+	return o.withPool(func(objects map[objectKey]*object) error {
+		key := objectKey{namespace: o.namespace, oid: oid}
+		if _, ok := objects[key]; !ok {
+			return errNotFound
+		}
+		delete(objects, key)
+
+		return nil
+	})
+}
+
+// GetOmapValuesByKeys returns the values of the keys that are set in the
+// omap of the object.
+func (o *Objects) GetOmapValuesByKeys(oid string, keys []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	err := o.withObject(oid, func(obj *object) error {
+		for _, key := range keys {
+			if value, ok := obj.omap[key]; ok {
+				values[key] = slices.Clone(value)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// ListOmapValues calls listFn for up to maxReturn omap values of the object
+// that start with filterPrefix, in the order of their keys after startAfter.
+func (o *Objects) ListOmapValues(
+	oid, startAfter, filterPrefix string,
+	maxReturn int64,
+	listFn func(key string, value []byte),
+) error {
+	values := map[string][]byte{}
+	err := o.withObject(oid, func(obj *object) error {
+		for key, value := range obj.omap {
+			if key > startAfter && strings.HasPrefix(key, filterPrefix) {
+				values[key] = slices.Clone(value)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// listFn is called without the mutex of the cluster held
+	for i, key := range slices.Sorted(maps.Keys(values)) {
+		if int64(i) == maxReturn {
+			break
+		}
+		listFn(key, values[key])
+	}
+
+	return nil
+}
+
+// SetOmap sets the omap keys of the object, the object is created when it
+// does not exist.
+func (o *Objects) SetOmap(oid string, pairs map[string][]byte) error {
+	_, err := o.UpdateOmap(oid, 0, pairs, nil)
+
+	return err
+}
+
+// RmOmapKeys removes the keys from the omap of the object.
+func (o *Objects) RmOmapKeys(oid string, keys []string) error {
+	return o.withObject(oid, func(obj *object) error {
+		for _, key := range keys {
+			delete(obj.omap, key)
+		}
+		obj.version++
+
+		return nil
+	})
+}
+
+// UpdateOmap sets and removes omap keys of the object in one operation, and
+// returns the new version of the object. When version is not 0, the object
+// needs to be at that version, the update fails with ERANGE when the object
+// is newer and with EOVERFLOW when it is older, like an asserted version.
+func (o *Objects) UpdateOmap(oid string, version uint64, pairs map[string][]byte, keys []string) (uint64, error) {
+	var newVersion uint64
+	err := o.withPool(func(objects map[objectKey]*object) error {
+		key := objectKey{namespace: o.namespace, oid: oid}
+		obj, ok := objects[key]
+		switch {
+		case version != 0 && !ok:
+			return errNotFound
+		case version != 0 && obj.version > version:
+			return errVersionNewer
+		case version != 0 && obj.version < version:
+			return errVersionOlder
+		case !ok:
+			obj = &object{omap: map[string][]byte{}}
+			objects[key] = obj
+		}
+
+		for k, v := range pairs {
+			obj.omap[k] = slices.Clone(v)
+		}
+		for _, k := range keys {
+			delete(obj.omap, k)
+		}
+		obj.version++
+		newVersion = obj.version
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newVersion, nil
+}
+
+// GetVersion returns the version of the object.
+func (o *Objects) GetVersion(oid string) (uint64, error) {
+	var version uint64
+	err := o.withObject(oid, func(obj *object) error {
+		version = obj.version
+
+		return nil
+	})
+
+	return version, err
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeceph
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// schedule is a mirror snapshot schedule of the rbd_support module.
+type schedule struct {
+	Interval  string `json:"interval"`
+	StartTime string `json:"start_time"`
+}
+
+// taskRefs are the references of an RBD task to its image.
+type taskRefs struct {
+	Action        string `json:"action"`
+	PoolName      string `json:"pool_name"`
+	PoolNamespace string `json:"pool_namespace"`
+	ImageName     string `json:"image_name,omitempty"`
+	ImageID       string `json:"image_id,omitempty"`
+}
+
+// task is an RBD task of the rbd_support module.
+type task struct {
+	Sequence   int      `json:"sequence"`
+	ID         string   `json:"id"`
+	Message    string   `json:"message"`
+	Refs       taskRefs `json:"refs"`
+	InProgress bool     `json:"in_progress"`
+	Progress   float64  `json:"progress"`
+}
+
+func (cl *Cluster) registerRBDHandlers() {
+	cl.mgrHandlers["rbd mirror snapshot schedule add"] = (*Cluster).addSchedule
+	cl.mgrHandlers["rbd mirror snapshot schedule list"] = (*Cluster).listSchedules
+	cl.mgrHandlers["rbd mirror snapshot schedule remove"] = (*Cluster).removeSchedule
+
+	cl.mgrHandlers["rbd task add flatten"] = (*Cluster).addFlattenTask
+	cl.mgrHandlers["rbd task add remove"] = (*Cluster).addRemoveTask
+	cl.mgrHandlers["rbd task add trash remove"] = (*Cluster).addTrashRemoveTask
+	cl.mgrHandlers["rbd task list"] = (*Cluster).listTasks
+	cl.mgrHandlers["rbd task cancel"] = (*Cluster).cancelTask
+}
+
+// CompleteTasks completes the pending RBD tasks, and returns the number of
+// tasks that were completed. The tasks stay pending until they are completed
+// or canceled, the images are not modified by the tasks.
+func (cl *Cluster) CompleteTasks() int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	completed := len(cl.tasks)
+	cl.tasks = nil
+
+	return completed
+}
+
+// parseSpec returns the refs of an image spec, "<pool>/[<namespace>/]<image>".
+func parseSpec(action, spec string) (taskRefs, error) {
+	parts := strings.Split(spec, "/")
+	refs := taskRefs{Action: action, PoolName: parts[0]}
+	switch len(parts) {
+	case 2:
+		refs.ImageName = parts[1]
+	case 3:
+		refs.PoolNamespace = parts[1]
+		refs.ImageName = parts[2]
+	default:
+		return refs, errInvalid
+	}
+
+	return refs, nil
+}
+
+// addTask adds a task for the image spec of the command, an existing task of
+// the same action for the image is returned instead, like Ceph does.
+func (cl *Cluster) addTask(action, specKey, message string, cmd command) ([]byte, string, error) {
+	refs, err := parseSpec(action, cmd.str(specKey))
+	if err != nil {
+		return nil, fmt.Sprintf("invalid %s %q", specKey, cmd.str(specKey)), err
+	}
+	if action == "trash remove" {
+		refs.ImageID, refs.ImageName = refs.ImageName, ""
+	}
+
+	for _, t := range cl.tasks {
+		if t.Refs == refs {
+			return marshal(t)
+		}
+	}
+
+	cl.lastTask++
+	t := task{
+		Sequence: cl.lastTask,
+		ID:       uuid.NewString(),
+		Message:  fmt.Sprintf("%s %s", message, cmd.str(specKey)),
+		Refs:     refs,
+	}
+	cl.tasks = append(cl.tasks, t)
+
+	return marshal(t)
+}
+
+func (cl *Cluster) addFlattenTask(cmd command) ([]byte, string, error) {
+	return cl.addTask("flatten", "image_spec", "Flattening image", cmd)
+}
+
+func (cl *Cluster) addRemoveTask(cmd command) ([]byte, string, error) {
+	return cl.addTask("remove", "image_spec", "Removing image", cmd)
+}
+
+func (cl *Cluster) addTrashRemoveTask(cmd command) ([]byte, string, error) {
+	return cl.addTask("trash remove", "image_id_spec", "Removing image from trash", cmd)
+}
+
+// listTasks returns the pending tasks, or the task with the task_id of the
+// command.
+func (cl *Cluster) listTasks(cmd command) ([]byte, string, error) {
+	id := cmd.str("task_id")
+	if id == "" {
+		return marshal(append([]task{}, cl.tasks...))
+	}
+
+	i := slices.IndexFunc(cl.tasks, func(t task) bool { return t.ID == id })
+	if i == -1 {
+		return nil, fmt.Sprintf("Task %s does not exist", id), errNotFound
+	}
+
+	return marshal(cl.tasks[i])
+}
+
+func (cl *Cluster) cancelTask(cmd command) ([]byte, string, error) {
+	id := cmd.str("task_id")
+	i := slices.IndexFunc(cl.tasks, func(t task) bool { return t.ID == id })
+	if i == -1 {
+		return nil, fmt.Sprintf("Task %s does not exist", id), errNotFound
+	}
+	t := cl.tasks[i]
+	cl.tasks = slices.Delete(cl.tasks, i, i+1)
+
+	return marshal(t)
+}
+
+// RemoveSchedules removes the mirror snapshot schedules of the level spec,
+// like Ceph does after some operations on an image.
+func (cl *Cluster) RemoveSchedules(levelSpec string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	delete(cl.schedules, levelSpec)
+}
+
+func (cl *Cluster) addSchedule(cmd command) ([]byte, string, error) {
+	levelSpec := cmd.str("level_spec")
+	s := schedule{Interval: cmd.str("interval"), StartTime: cmd.str("start_time")}
+	if s.Interval == "" {
+		return nil, "interval is required", errInvalid
+	}
+
+	if !slices.Contains(cl.schedules[levelSpec], s) {
+		cl.schedules[levelSpec] = append(cl.schedules[levelSpec], s)
+	}
+
+	return nil, "", nil
+}
+
+// listSchedules returns the schedules of the level spec and of the levels
+// below it, like the schedules of the images in a pool.
+func (cl *Cluster) listSchedules(cmd command) ([]byte, string, error) {
+	type scheduleLevel struct {
+		Name     string     `json:"name"`
+		Schedule []schedule `json:"schedule"`
+	}
+
+	levelSpec := strings.TrimSuffix(cmd.str("level_spec"), "/")
+	list := map[string]scheduleLevel{}
+	for spec, schedules := range cl.schedules {
+		if levelSpec == "" || spec == levelSpec || strings.HasPrefix(spec, levelSpec+"/") {
+			list[spec] = scheduleLevel{Name: spec, Schedule: schedules}
+		}
+	}
+
+	return marshal(list)
+}
+
+func (cl *Cluster) removeSchedule(cmd command) ([]byte, string, error) {
+	levelSpec := cmd.str("level_spec")
+	interval := cmd.str("interval")
+	startTime := cmd.str("start_time")
+
+	schedules := slices.DeleteFunc(slices.Clone(cl.schedules[levelSpec]), func(s schedule) bool {
+		return (interval == "" || s.Interval == interval) && (startTime == "" || s.StartTime == startTime)
+	})
+	if len(schedules) == len(cl.schedules[levelSpec]) {
+		return nil, fmt.Sprintf("No schedule for %s", levelSpec), errNotFound
+	}
+
+	if len(schedules) == 0 {
+		delete(cl.schedules, levelSpec)
+	} else {
+		cl.schedules[levelSpec] = schedules
+	}
+
+	return nil, "", nil
+}
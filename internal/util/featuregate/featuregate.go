@@ -0,0 +1,219 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package featuregate enables experimental behaviour of the drivers with the
+// --feature-gates command line option, like "GroupSnapshot=false,ListVolumes=true".
+package featuregate
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature is the name of a feature that can be enabled or disabled.
+type Feature string
+
+// Stage is the maturity of a feature.
+type Stage string
+
+const (
+	// Alpha features are disabled by default, and may change or be removed.
+	Alpha Stage = "ALPHA"
+	// Beta features are enabled by default.
+	Beta Stage = "BETA"
+)
+
+const (
+	// GroupSnapshot advertises the VolumeGroupSnapshot capability of the
+	// GroupController service.
+	GroupSnapshot Feature = "GroupSnapshot"
+	// IDMappedMounts applies the fsGroup of a volume with an ID-mapped bind
+	// mount, if the node supports it.
+	IDMappedMounts Feature = "IDMappedMounts"
+	// ForceUnstage escalates to a forced and a lazy umount, the eviction of
+	// the CephFS client and a forced unmap when NodeUnstageVolume can not
+	// release a volume.
+	ForceUnstage Feature = "ForceUnstage"
+	// SystemdMounts executes the mount and map commands of the nodeplugin in
+	// transient scopes of the systemd of the host.
+	SystemdMounts Feature = "SystemdMounts"
+	// ListVolumes advertises the ListVolumes capability, including the nodes
+	// that have the volumes published.
+	ListVolumes Feature = "ListVolumes"
+	// NodeCapabilityLabels adds the detected node capabilities to the
+	// topology returned by NodeGetInfo.
+	NodeCapabilityLabels Feature = "NodeCapabilityLabels"
+	// ClusterConfigCRD reads the configuration of the clusters from
+	// ClusterConfig objects in the namespace of the driver, next to the
+	// csi config.
+	ClusterConfigCRD Feature = "ClusterConfigCRD"
+	// EphemeralVolumes allows inline ephemeral RBD volumes that are created
+	// by NodePublishVolume and encrypted with a random key.
+	EphemeralVolumes Feature = "EphemeralVolumes"
+	// AttachTracking implements ControllerPublishVolume for RBD, the nodes
+	// of a volume are recorded in the metadata of its image.
+	AttachTracking Feature = "AttachTracking"
+)
+
+// Spec describes the default and the maturity of a feature.
+type Spec struct {
+	Default bool
+	Stage   Stage
+}
+
+// defaultFeatures are the features known by the drivers.
+var defaultFeatures = map[Feature]Spec{
+	GroupSnapshot:        {Default: true, Stage: Beta},
+	IDMappedMounts:       {Default: false, Stage: Alpha},
+	ForceUnstage:         {Default: false, Stage: Alpha},
+	SystemdMounts:        {Default: false, Stage: Alpha},
+	ListVolumes:          {Default: false, Stage: Alpha},
+	NodeCapabilityLabels: {Default: false, Stage: Alpha},
+	ClusterConfigCRD:     {Default: false, Stage: Alpha},
+	EphemeralVolumes:     {Default: false, Stage: Alpha},
+	AttachTracking:       {Default: false, Stage: Alpha},
+}
+
+// Gate keeps the state of the known features. It implements flag.Value, so
+// that it can be set with a command line option.
+type Gate struct {
+	mu      sync.RWMutex
+	known   map[Feature]Spec
+	enabled map[Feature]bool
+}
+
+// DefaultGate is the Gate that is set with --feature-gates.
+var DefaultGate = NewGate(defaultFeatures)
+
+// NewGate returns a Gate for the known features, with all features at their
+// default.
+func NewGate(known map[Feature]Spec) *Gate {
+	return &Gate{
+		known:   known,
+		enabled: map[Feature]bool{},
+	}
+}
+
+// Enabled returns true when the feature is enabled in the DefaultGate.
+func Enabled(f Feature) bool {
+	return DefaultGate.Enabled(f)
+}
+
+// Enabled returns true when the feature is enabled. Unknown features are
+// never enabled.
+func (g *Gate) Enabled(f Feature) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if enabled, ok := g.enabled[f]; ok {
+		return enabled
+	}
+
+	return g.known[f].Default
+}
+
+// Set parses a comma separated list of <feature>=<bool> pairs, and enables
+// or disables the features. An unknown feature or an invalid value is
+// rejected without changing any feature.
+func (g *Gate) Set(value string) error {
+	values := map[Feature]bool{}
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("missing bool value for feature %q", k)
+		}
+		f := Feature(strings.TrimSpace(k))
+		if _, known := g.known[f]; !known {
+			return fmt.Errorf("unknown feature %q, known features are %s", f, strings.Join(g.KnownFeatures(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid value %q for feature %q: %w", v, f, err)
+		}
+		values[f] = enabled
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for f, enabled := range values {
+		g.enabled[f] = enabled
+	}
+
+	return nil
+}
+
+// IsSet returns true when the feature was enabled or disabled explicitly.
+func (g *Gate) IsSet(f Feature) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.enabled[f]
+
+	return ok
+}
+
+// String returns the explicitly set features as <feature>=<bool> pairs.
+func (g *Gate) String() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	pairs := make([]string, 0, len(g.enabled))
+	for f, enabled := range g.enabled {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", f, enabled))
+	}
+	slices.Sort(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// KnownFeatures returns the known features with their default and stage,
+// for the help text of the command line option.
+func (g *Gate) KnownFeatures() []string {
+	features := make([]string, 0, len(g.known))
+	for f, spec := range g.known {
+		features = append(features, fmt.Sprintf("%s=true|false (%s - default=%t)", f, spec.Stage, spec.Default))
+	}
+	slices.Sort(features)
+
+	return features
+}
+
+// EnabledFeatures returns the names of the features that are enabled, either
+// by default or explicitly.
+func (g *Gate) EnabledFeatures() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	features := []string{}
+	for f, spec := range g.known {
+		enabled, ok := g.enabled[f]
+		if !ok {
+			enabled = spec.Default
+		}
+		if enabled {
+			features = append(features, string(f))
+		}
+	}
+	slices.Sort(features)
+
+	return features
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featuregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateSet(t *testing.T) {
+	t.Parallel()
+	known := map[Feature]Spec{
+		"Alpha": {Default: false, Stage: Alpha},
+		"Beta":  {Default: true, Stage: Beta},
+	}
+
+	g := NewGate(known)
+	require.False(t, g.Enabled("Alpha"))
+	require.True(t, g.Enabled("Beta"))
+	require.False(t, g.Enabled("Unknown"))
+	require.Empty(t, g.String())
+
+	require.NoError(t, g.Set("Alpha=true, Beta=false"))
+	require.True(t, g.Enabled("Alpha"))
+	require.False(t, g.Enabled("Beta"))
+	require.True(t, g.IsSet("Beta"))
+	require.Equal(t, "Alpha=true,Beta=false", g.String())
+
+	// invalid input does not change the features
+	require.Error(t, g.Set("Alpha=false,Unknown=true"))
+	require.Error(t, g.Set("Alpha=false,Beta"))
+	require.Error(t, g.Set("Alpha=maybe"))
+	require.True(t, g.Enabled("Alpha"))
+}
+
+func TestKnownFeatures(t *testing.T) {
+	t.Parallel()
+	g := NewGate(map[Feature]Spec{
+		"Beta":  {Default: true, Stage: Beta},
+		"Alpha": {Default: false, Stage: Alpha},
+	})
+
+	require.Equal(t, []string{
+		"Alpha=true|false (ALPHA - default=false)",
+		"Beta=true|false (BETA - default=true)",
+	}, g.KnownFeatures())
+}
+
+func TestEnabledFeatures(t *testing.T) {
+	t.Parallel()
+	g := NewGate(map[Feature]Spec{
+		"Alpha": {Default: false, Stage: Alpha},
+		"Beta":  {Default: true, Stage: Beta},
+		"Gamma": {Default: true, Stage: Beta},
+	})
+	require.Equal(t, []string{"Beta", "Gamma"}, g.EnabledFeatures())
+
+	require.NoError(t, g.Set("Alpha=true,Gamma=false"))
+	require.Equal(t, []string{"Alpha", "Beta"}, g.EnabledFeatures())
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// CleanupStage is a step in the escalation of NodeUnstageVolume when a volume
+// can not be released in the normal way.
+type CleanupStage string
+
+const (
+	// CleanupStageUnmount is the normal umount of the staging path.
+	CleanupStageUnmount CleanupStage = "umount"
+	// CleanupStageForceUnmount requests the Ceph client of the mount to
+	// abort its outstanding requests, so that a hung mount can be unmounted.
+	CleanupStageForceUnmount CleanupStage = "force-umount"
+	// CleanupStageLazyUnmount detaches the staging path from the mount
+	// namespace, the filesystem is cleaned up once it is not busy anymore.
+	CleanupStageLazyUnmount CleanupStage = "lazy-umount"
+	// CleanupStageClientEviction evicts the session of the CephFS client of
+	// a detached mount through the MDS, so that the caps it holds are
+	// released for the other clients.
+	CleanupStageClientEviction CleanupStage = "client-eviction"
+	// CleanupStageForceUnmap unmaps an RBD device while it is still in use.
+	CleanupStageForceUnmap CleanupStage = "force-unmap"
+
+	// cleanupStageTimeout bounds the time every stage may take, a stuck
+	// umount would otherwise block NodeUnstageVolume forever.
+	cleanupStageTimeout = 30 * time.Second
+)
+
+// CleanupStageResult is the outcome of a single CleanupStage.
+type CleanupStageResult struct {
+	Stage CleanupStage
+	Err   error
+}
+
+// CleanupReport records the stages that were tried to release a volume.
+type CleanupReport struct {
+	Results []CleanupStageResult
+}
+
+// Add appends the outcome of a stage to the report.
+func (cr *CleanupReport) Add(stage CleanupStage, err error) {
+	cr.Results = append(cr.Results, CleanupStageResult{Stage: stage, Err: err})
+}
+
+// Escalated returns true when more than the normal umount was needed.
+func (cr *CleanupReport) Escalated() bool {
+	for _, r := range cr.Results {
+		if r.Stage != CleanupStageUnmount {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reached returns true when the stage was tried.
+func (cr *CleanupReport) Reached(stage CleanupStage) bool {
+	for _, r := range cr.Results {
+		if r.Stage == stage {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the stages and their outcome in the order they were tried,
+// like "umount: failed (...), lazy-umount: succeeded".
+func (cr *CleanupReport) String() string {
+	stages := make([]string, 0, len(cr.Results))
+	for _, r := range cr.Results {
+		if r.Err != nil {
+			stages = append(stages, fmt.Sprintf("%s: failed (%v)", r.Stage, r.Err))
+		} else {
+			stages = append(stages, fmt.Sprintf("%s: succeeded", r.Stage))
+		}
+	}
+
+	return strings.Join(stages, ", ")
+}
+
+// ForceUnmount unmounts mountPoint, and escalates to a forced umount that
+// aborts the outstanding requests, and a lazy umount that detaches a mount
+// which is still busy, when the previous stage failed or did not finish in
+// time. umountArgs are passed to every umount command. The stages that were
+// tried are added to the report.
+func ForceUnmount(ctx context.Context, mountPoint string, report *CleanupReport, umountArgs ...string) error {
+	stages := []struct {
+		stage CleanupStage
+		args  []string
+	}{
+		{stage: CleanupStageUnmount},
+		{stage: CleanupStageForceUnmount, args: []string{"--force"}},
+		{stage: CleanupStageLazyUnmount, args: []string{"--lazy"}},
+	}
+
+	var (
+		stderr string
+		err    error
+	)
+	for _, s := range stages {
+		args := append([]string{mountPoint}, umountArgs...)
+		args = append(args, s.args...)
+		_, stderr, err = ExecCommandWithTimeout(ctx, cleanupStageTimeout, "umount", args...)
+		if err == nil || strings.Contains(stderr, "not mounted") {
+			report.Add(s.stage, nil)
+
+			return nil
+		}
+
+		report.Add(s.stage, err)
+		log.WarningLog(ctx, "%s of %s failed, escalating: %v", s.stage, mountPoint, err)
+	}
+
+	return fmt.Errorf("failed to unmount %s: %w", mountPoint, err)
+}
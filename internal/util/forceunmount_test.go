@@ -0,0 +1,49 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupReport(t *testing.T) {
+	t.Parallel()
+
+	report := &CleanupReport{}
+	require.False(t, report.Escalated())
+	require.Empty(t, report.String())
+
+	report.Add(CleanupStageUnmount, nil)
+	require.False(t, report.Escalated())
+	require.Equal(t, "umount: succeeded", report.String())
+
+	report = &CleanupReport{}
+	report.Add(CleanupStageUnmount, errors.New("target is busy"))
+	report.Add(CleanupStageForceUnmount, errors.New("target is busy"))
+	report.Add(CleanupStageLazyUnmount, nil)
+	report.Add(CleanupStageForceUnmap, nil)
+	require.True(t, report.Escalated())
+	require.True(t, report.Reached(CleanupStageLazyUnmount))
+	require.False(t, report.Reached(CleanupStageClientEviction))
+	require.Equal(t,
+		"umount: failed (target is busy), force-umount: failed (target is busy), lazy-umount: succeeded, "+
+			"force-unmap: succeeded",
+		report.String())
+}
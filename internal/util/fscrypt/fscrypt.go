@@ -45,6 +45,11 @@ const (
 	FscryptProtectorPrefix   = "ceph-csi"
 	FscryptSubdir            = "ceph-csi-encrypted"
 	encryptionPassphraseSize = 64
+
+	// rotatedProtectorName is the name of the protector that is added when
+	// the key of a volume is rotated. Rotations alternate between this name
+	// and FscryptProtectorPrefix, the KMS has the key of one of them.
+	rotatedProtectorName = FscryptProtectorPrefix + "-rotated"
 )
 
 var policyV2Support = []util.KernelVersion{
@@ -61,6 +66,9 @@ var policyV2Support = []util.KernelVersion{
 // error values
 var (
 	ErrBadAuth = errors.New("key authentication check failed")
+	// ErrKeyRotationUnsupported is returned when the key of a volume can not
+	// be rotated, because the KMS does not store the passphrases of volumes.
+	ErrKeyRotationUnsupported = errors.New("key rotation is only supported with a KMS that stores the passphrase")
 )
 
 func AppendEncyptedSubdirectory(dir string) string {
@@ -124,6 +132,33 @@ func createKeyFuncFromVolumeEncryption(
 	return keyFunc, nil
 }
 
+// createKeyFuncFromPassphrase returns an fscrypt key function returning the
+// passphrase as encryption key.
+func createKeyFuncFromPassphrase(
+	passphrase string,
+) func(fscryptactions.ProtectorInfo, bool) (*fscryptcrypto.Key, error) {
+	return func(info fscryptactions.ProtectorInfo, retry bool) (*fscryptcrypto.Key, error) {
+		if retry {
+			return nil, ErrBadAuth
+		}
+
+		key, err := fscryptcrypto.NewBlankKey(len(passphrase))
+		copy(key.Data(), passphrase)
+
+		return key, err
+	}
+}
+
+// otherProtectorName returns the name of the protector that replaces the
+// protector with name when the key is rotated.
+func otherProtectorName(name string) string {
+	if name == rotatedProtectorName {
+		return FscryptProtectorPrefix
+	}
+
+	return rotatedProtectorName
+}
+
 // fsyncEncryptedDirectory calls sync on dirPath. It is intended to
 // work around the fscrypt library not syncing the directory it sets a
 // policy on.
@@ -156,6 +191,44 @@ func unlockExisting(
 		return err
 	}
 
+	// the key may have been rotated, in which case the volume is protected
+	// by the protector with the other name
+	err = unlockPolicy(ctx, policy, protectorName, volEncryption, volID, keyFn)
+	if err != nil {
+		if otherErr := unlockPolicy(ctx, policy, otherProtectorName(protectorName),
+			volEncryption, volID, keyFn); otherErr != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		err = policy.Lock()
+		if err != nil {
+			log.ErrorLog(ctx, "fscrypt: failed to lock policy after use: %v", err)
+		}
+	}()
+
+	if err = policy.Provision(); err != nil {
+		log.ErrorLog(ctx, "fscrypt: provision fail %v", err)
+
+		return err
+	}
+
+	log.DebugLog(ctx, "fscrypt protector unlock: %s %+v", protectorName, policy)
+
+	return nil
+}
+
+// unlockPolicy unlocks the policy with the protector with protectorName,
+// using the key from keyFn, or the null padded passphrase of older releases.
+func unlockPolicy(
+	ctx context.Context,
+	policy *fscryptactions.Policy,
+	protectorName string,
+	volEncryption *util.VolumeEncryption,
+	volID string,
+	keyFn func(fscryptactions.ProtectorInfo, bool) (*fscryptcrypto.Key, error),
+) error {
 	optionFn := func(policyDescriptor string, options []*fscryptactions.ProtectorOption) (int, error) {
 		for idx, option := range options {
 			if option.Name() == protectorName {
@@ -166,40 +239,33 @@ func unlockExisting(
 		return 0, &fscryptactions.ErrNotProtected{PolicyDescriptor: policyDescriptor, ProtectorDescriptor: protectorName}
 	}
 
-	if err = policy.Unlock(optionFn, keyFn); err != nil {
-		// try backward compat using the old style null padded passphrase
-		errMsg := fmt.Sprintf("fscrypt: unlock with protector error: %v", err)
-		log.ErrorLog(ctx, "%s, retry using a null padded passphrase", errMsg)
+	err := policy.Unlock(optionFn, keyFn)
+	if err == nil {
+		return nil
+	}
 
-		keyFn, err = createKeyFuncFromVolumeEncryption(ctx, *volEncryption, volID, encryptionPassphraseSize/2)
-		if err != nil {
-			log.ErrorLog(ctx, "fscrypt: could not create key function: %v", err)
+	var notProtected *fscryptactions.ErrNotProtected
+	if errors.As(err, &notProtected) {
+		return err
+	}
 
-			return err
-		}
+	// try backward compat using the old style null padded passphrase
+	errMsg := fmt.Sprintf("fscrypt: unlock with protector %q error: %v", protectorName, err)
+	log.ErrorLog(ctx, "%s, retry using a null padded passphrase", errMsg)
 
-		if err = policy.Unlock(optionFn, keyFn); err != nil {
-			log.ErrorLog(ctx, errMsg)
+	keyFn, err = createKeyFuncFromVolumeEncryption(ctx, *volEncryption, volID, encryptionPassphraseSize/2)
+	if err != nil {
+		log.ErrorLog(ctx, "fscrypt: could not create key function: %v", err)
 
-			return err
-		}
+		return err
 	}
 
-	defer func() {
-		err = policy.Lock()
-		if err != nil {
-			log.ErrorLog(ctx, "fscrypt: failed to lock policy after use: %v", err)
-		}
-	}()
-
-	if err = policy.Provision(); err != nil {
-		log.ErrorLog(ctx, "fscrypt: provision fail %v", err)
+	if err = policy.Unlock(optionFn, keyFn); err != nil {
+		log.ErrorLog(ctx, errMsg)
 
 		return err
 	}
 
-	log.DebugLog(ctx, "fscrypt protector unlock: %s %+v", protectorName, policy)
-
 	return nil
 }
 
@@ -365,6 +431,34 @@ func InitializeNode(ctx context.Context) error {
 	return nil
 }
 
+// newContext returns the fscrypt context of the filesystem that is mounted
+// on stagingTargetPath.
+func newContext(ctx context.Context, stagingTargetPath string) (*fscryptactions.Context, error) {
+	err := fscryptfilesystem.UpdateMountInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	fscryptContext, err := fscryptactions.NewContextFromMountpoint(stagingTargetPath, nil)
+	if err != nil {
+		log.ErrorLog(ctx, "fscrypt: failed to create context from mountpoint %v: %w", stagingTargetPath, err)
+
+		return nil, err
+	}
+
+	fscryptContext.Config.UseFsKeyringForV1Policies = true
+
+	log.DebugLog(ctx, "fscrypt context: %+v", fscryptContext)
+
+	if err = fscryptContext.Mount.CheckSupport(); err != nil {
+		log.ErrorLog(ctx, "fscrypt: filesystem mount %s does not support fscrypt", fscryptContext.Mount)
+
+		return nil, err
+	}
+
+	return fscryptContext, nil
+}
+
 // FscryptUnlock unlocks possibly creating fresh fscrypt metadata
 // iff a volume is encrypted. Otherwise return immediately Calling
 // this function requires that InitializeFscrypt ran once on this node.
@@ -381,28 +475,11 @@ func Unlock(
 		return err
 	}
 
-	err = fscryptfilesystem.UpdateMountInfo()
+	fscryptContext, err := newContext(ctx, stagingTargetPath)
 	if err != nil {
 		return err
 	}
 
-	fscryptContext, err := fscryptactions.NewContextFromMountpoint(stagingTargetPath, nil)
-	if err != nil {
-		log.ErrorLog(ctx, "fscrypt: failed to create context from mountpoint %v: %w", stagingTargetPath, err)
-
-		return err
-	}
-
-	fscryptContext.Config.UseFsKeyringForV1Policies = true
-
-	log.DebugLog(ctx, "fscrypt context: %+v", fscryptContext)
-
-	if err = fscryptContext.Mount.CheckSupport(); err != nil {
-		log.ErrorLog(ctx, "fscrypt: filesystem mount %s does not support fscrypt", fscryptContext.Mount)
-
-		return err
-	}
-
 	// A proper set up fscrypt directory requires metadata and a kernel policy:
 
 	// 1. Do we have a metadata directory (.fscrypt) set up?
@@ -447,6 +524,20 @@ func Unlock(
 	if kernelPolicyExists && metadataDirExists {
 		log.DebugLog(ctx, "fscrypt: Encrypted directory already set up, policy exists")
 
+		err = unlockExisting(ctx, fscryptContext, encryptedPath, protectorName, volEncryption, volID, keyFn)
+		if err == nil || volEncryption.KMS.RequiresDEKStore() != kms.DEKStoreIntegrated {
+			return err
+		}
+
+		// a volume that was created from a snapshot or another volume is
+		// protected by the passphrase of its source, until it is replaced
+		// when the volume is staged the first time
+		if aErr := adoptInheritedKey(ctx, volEncryption, stagingTargetPath, volID); aErr != nil {
+			log.DebugLog(ctx, "fscrypt: volume %q has no inherited key to adopt: %v", volID, aErr)
+
+			return err
+		}
+
 		return unlockExisting(ctx, fscryptContext, encryptedPath, protectorName, volEncryption, volID, keyFn)
 	}
 
@@ -465,3 +556,269 @@ func Unlock(
 
 	return errors.New("unsupported")
 }
+
+// RotateKey replaces the protector of the fscrypt policy of the volume that is
+// staged at stagingTargetPath with a protector for a new passphrase. The new
+// protector is added to the policy before its passphrase is stored in the
+// KMS, and the old protector is removed after that, so that the volume can be
+// unlocked with the passphrase in the KMS at every step. A protector that was
+// left behind by an interrupted rotation is removed first. Calling this
+// function requires that InitializeNode ran once on this node.
+func RotateKey(
+	ctx context.Context,
+	volEncryption *util.VolumeEncryption,
+	stagingTargetPath, volID string,
+) error {
+	if volEncryption.KMS.RequiresDEKStore() != kms.DEKStoreIntegrated {
+		return ErrKeyRotationUnsupported
+	}
+
+	passphrase, err := volEncryption.GetNewCryptoPassphrase(encryptionPassphraseSize)
+	if err != nil {
+		return fmt.Errorf("fscrypt: failed to generate a new passphrase: %w", err)
+	}
+
+	return replaceProtector(ctx, volEncryption, stagingTargetPath, volID, volID, passphrase,
+		func() error {
+			return volEncryption.StoreCryptoPassphrase(ctx, volID, passphrase)
+		})
+}
+
+// InheritedKeyID returns the ID under which the passphrase of the source of a
+// volume is stored in the KMS, until the nodeplugin protected the volume with
+// its own passphrase.
+func InheritedKeyID(volID string) string {
+	return volID + "-inherited"
+}
+
+// StoreInheritedKey stores a new passphrase for the volume targetID, that is
+// created from the snapshot or volume sourceID. The data of the volume is
+// protected by the passphrase of the source, which is stored for the volume
+// under InheritedKeyID(targetID) until Unlock replaced the protector of the
+// volume with a protector for the new passphrase. Both KMS need to store the
+// passphrases of the volumes.
+func StoreInheritedKey(
+	ctx context.Context,
+	source *util.VolumeEncryption,
+	sourceID string,
+	target *util.VolumeEncryption,
+	targetID string,
+) error {
+	passphrase, err := source.GetCryptoPassphrase(ctx, sourceID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch passphrase for %q: %w", sourceID, err)
+	}
+
+	err = target.StoreCryptoPassphrase(ctx, InheritedKeyID(targetID), passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to store inherited passphrase for %q: %w", targetID, err)
+	}
+
+	err = target.StoreNewCryptoPassphrase(ctx, targetID, encryptionPassphraseSize)
+	if err != nil {
+		return fmt.Errorf("failed to store passphrase for %q: %w", targetID, err)
+	}
+
+	return nil
+}
+
+// adoptInheritedKey replaces the protector of the fscrypt policy of a volume
+// that is still protected by the passphrase of its source, see
+// StoreInheritedKey, with a protector for the passphrase of the volume. The
+// inherited passphrase is removed from the KMS once the volume can not be
+// unlocked with it anymore.
+func adoptInheritedKey(
+	ctx context.Context,
+	volEncryption *util.VolumeEncryption,
+	stagingTargetPath, volID string,
+) error {
+	inheritedID := InheritedKeyID(volID)
+	passphrase, err := getPassphrase(ctx, *volEncryption, volID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceProtector(ctx, volEncryption, stagingTargetPath, volID, inheritedID, passphrase, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = volEncryption.RemoveDEK(ctx, inheritedID); err != nil {
+		log.WarningLog(ctx, "fscrypt: failed to remove inherited passphrase of volume %q: %v", volID, err)
+	}
+
+	return nil
+}
+
+// replaceProtector replaces the protector of the fscrypt policy of the volume
+// that is staged at stagingTargetPath, which is unlocked by the passphrase of
+// keyID in the KMS, with a protector for passphrase. commit is called, when
+// it is not nil, after the new protector was added to the policy and before
+// the old protector is removed. A protector that was left behind by an
+// interrupted replacement is removed first.
+func replaceProtector(
+	ctx context.Context,
+	volEncryption *util.VolumeEncryption,
+	stagingTargetPath, volID, keyID, passphrase string,
+	commit func() error,
+) error {
+	fscryptContext, err := newContext(ctx, stagingTargetPath)
+	if err != nil {
+		return err
+	}
+	fscryptContext.Config.Source = fscryptmetadata.SourceType_raw_key
+
+	encryptedPath := path.Join(stagingTargetPath, FscryptSubdir)
+	policy, err := fscryptactions.GetPolicyFromPath(fscryptContext, encryptedPath)
+	if err != nil {
+		return fmt.Errorf("fscrypt: failed to get policy of %q: %w", encryptedPath, err)
+	}
+
+	current, currentName, stale, err := getProtectors(ctx, fscryptContext, policy, volEncryption, keyID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lErr := current.Lock(); lErr != nil {
+			log.ErrorLog(ctx, "fscrypt: failed to lock protector after replacing it: %v", lErr)
+		}
+	}()
+
+	if stale != nil {
+		log.DebugLog(ctx, "fscrypt: removing protector %s of an interrupted key rotation", stale.Descriptor())
+		if err = removeProtector(policy, stale); err != nil {
+			return fmt.Errorf("fscrypt: failed to remove protector %s: %w", stale.Descriptor(), err)
+		}
+	}
+
+	if err = policy.UnlockWithProtector(current); err != nil {
+		return fmt.Errorf("fscrypt: failed to unlock policy: %w", err)
+	}
+	defer func() {
+		if lErr := policy.Lock(); lErr != nil {
+			log.ErrorLog(ctx, "fscrypt: failed to lock policy after replacing the protector: %v", lErr)
+		}
+	}()
+
+	// Step 1: protect the policy with the new passphrase as well
+	keyFn := createKeyFuncFromPassphrase(passphrase)
+	protector, err := fscryptactions.CreateProtector(fscryptContext, otherProtectorName(currentName), keyFn, nil)
+	if err != nil {
+		return fmt.Errorf("fscrypt: failed to create protector: %w", err)
+	}
+	defer func() {
+		if lErr := protector.Lock(); lErr != nil {
+			log.ErrorLog(ctx, "fscrypt: failed to lock new protector after replacing the protector: %v", lErr)
+		}
+	}()
+
+	if err = policy.AddProtector(protector); err != nil {
+		if dErr := protector.Destroy(); dErr != nil {
+			log.ErrorLog(ctx, "fscrypt: failed to destroy protector %s: %v", protector.Descriptor(), dErr)
+		}
+
+		return fmt.Errorf("fscrypt: failed to add protector %s: %w", protector.Descriptor(), err)
+	}
+
+	// Step 2: replace the passphrase in the KMS
+	if commit != nil {
+		if err = commit(); err != nil {
+			if rErr := removeProtector(policy, protector); rErr != nil {
+				log.ErrorLog(ctx, "fscrypt: failed to remove protector %s: %v", protector.Descriptor(), rErr)
+			}
+
+			return fmt.Errorf("fscrypt: failed to store the new passphrase in the KMS: %w", err)
+		}
+	}
+
+	// Step 3: the old passphrase should not unlock the volume anymore
+	if err = removeProtector(policy, current); err != nil {
+		return fmt.Errorf("fscrypt: failed to remove old protector %s: %w", current.Descriptor(), err)
+	}
+
+	log.DebugLog(ctx, "fscrypt: replaced key of volume %q, protector %s replaced by %s",
+		volID, current.Descriptor(), protector.Descriptor())
+
+	return nil
+}
+
+// getProtectors returns the unlocked protector of the policy that matches the
+// passphrase in the KMS, with its name. When the policy has another protector
+// of Ceph-CSI, it is returned as stale protector.
+func getProtectors(
+	ctx context.Context,
+	fscryptContext *fscryptactions.Context,
+	policy *fscryptactions.Policy,
+	volEncryption *util.VolumeEncryption,
+	volID string,
+) (*fscryptactions.Protector, string, *fscryptactions.Protector, error) {
+	var (
+		current, stale *fscryptactions.Protector
+		currentName    string
+	)
+
+	for _, option := range policy.ProtectorOptions() {
+		if option.Name() != FscryptProtectorPrefix && option.Name() != rotatedProtectorName {
+			continue
+		}
+
+		protector, err := fscryptactions.GetProtectorFromOption(fscryptContext, option)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("fscrypt: failed to get protector %q: %w", option.Name(), err)
+		}
+
+		if current == nil && unlockProtector(ctx, protector, volEncryption, volID) == nil {
+			current = protector
+			currentName = option.Name()
+
+			continue
+		}
+
+		stale = protector
+	}
+
+	if current == nil {
+		return nil, "", nil, fmt.Errorf("fscrypt: no protector of policy %s matches the passphrase of %q",
+			policy.Descriptor(), volID)
+	}
+
+	return current, currentName, stale, nil
+}
+
+// unlockProtector unlocks the protector with the passphrase of the volume, or
+// the null padded passphrase of older releases.
+func unlockProtector(
+	ctx context.Context,
+	protector *fscryptactions.Protector,
+	volEncryption *util.VolumeEncryption,
+	volID string,
+) error {
+	for _, keySize := range []int{-1, encryptionPassphraseSize / 2} {
+		keyFn, err := createKeyFuncFromVolumeEncryption(ctx, *volEncryption, volID, keySize)
+		if err != nil {
+			return err
+		}
+
+		err = protector.Unlock(keyFn)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrBadAuth) {
+			return err
+		}
+	}
+
+	return ErrBadAuth
+}
+
+// removeProtector removes the protector from the policy, and deletes its
+// metadata.
+func removeProtector(policy *fscryptactions.Policy, protector *fscryptactions.Protector) error {
+	err := policy.RemoveProtector(protector.Descriptor())
+	var notProtected *fscryptactions.ErrNotProtected
+	if err != nil && !errors.As(err, &notProtected) {
+		return err
+	}
+
+	return protector.Destroy()
+}
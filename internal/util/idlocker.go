@@ -16,10 +16,9 @@ package util
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ceph/ceph-csi/internal/util/log"
-
-	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
@@ -34,16 +33,22 @@ const (
 )
 
 // VolumeLocks implements a map with atomic operations. It stores a set of all volume IDs
-// with an ongoing operation.
+// with an ongoing operation, together with the time the operation started.
 type VolumeLocks struct {
-	locks sets.Set[string]
+	level LockLevel
+	locks map[string]time.Time
 	mux   sync.Mutex
 }
 
 // NewVolumeLocks returns new VolumeLocks.
 func NewVolumeLocks() *VolumeLocks {
+	return newVolumeLocks(VolumeLock)
+}
+
+func newVolumeLocks(level LockLevel) *VolumeLocks {
 	return &VolumeLocks{
-		locks: sets.New[string](),
+		level: level,
+		locks: make(map[string]time.Time),
 	}
 }
 
@@ -52,10 +57,12 @@ func NewVolumeLocks() *VolumeLocks {
 func (vl *VolumeLocks) TryAcquire(volumeID string) bool {
 	vl.mux.Lock()
 	defer vl.mux.Unlock()
-	if vl.locks.Has(volumeID) {
+	if _, ok := vl.locks[volumeID]; ok {
+		lockContentionTotal.WithLabelValues(vl.level.String()).Inc()
+
 		return false
 	}
-	vl.locks.Insert(volumeID)
+	vl.locks[volumeID] = time.Now()
 
 	return true
 }
@@ -64,7 +71,25 @@ func (vl *VolumeLocks) TryAcquire(volumeID string) bool {
 func (vl *VolumeLocks) Release(volumeID string) {
 	vl.mux.Lock()
 	defer vl.mux.Unlock()
-	vl.locks.Delete(volumeID)
+	if acquired, ok := vl.locks[volumeID]; ok {
+		lockHoldSeconds.WithLabelValues(vl.level.String()).Observe(time.Since(acquired).Seconds())
+		delete(vl.locks, volumeID)
+	}
+}
+
+// heldLongerThan returns the IDs that are locked for longer than d, with the
+// time they are locked for.
+func (vl *VolumeLocks) heldLongerThan(d time.Duration) map[string]time.Duration {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+	held := map[string]time.Duration{}
+	for id, acquired := range vl.locks {
+		if since := time.Since(acquired); since > d {
+			held[id] = since
+		}
+	}
+
+	return held
 }
 
 type operation string
@@ -0,0 +1,218 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// maxIDRange is the number of valid user and group IDs, (uint32)-1 is
+	// reserved as invalid ID.
+	maxIDRange = uint64(1<<32 - 1)
+
+	// idMapMountOption is the util-linux mount(8) option to create an
+	// ID-mapped mount.
+	idMapMountOption = "X-mount.idmap="
+
+	// util-linux added X-mount.idmap in version 2.39.
+	utilLinuxIDMapMajor = 2
+	utilLinuxIDMapMinor = 39
+
+	// idMapGroupFileMode are the permissions that the group of an ID-mapped
+	// volume needs on files, the directories also need idMapGroupDirMode.
+	idMapGroupFileMode = fs.FileMode(0o060)
+	idMapGroupDirMode  = fs.ModeSetgid | 0o070
+)
+
+var (
+	// idMappedMountSupport lists the kernels that can create ID-mapped
+	// mounts of ext4 and xfs filesystems.
+	//
+	//nolint:mnd // numbers specify Kernel versions.
+	idMappedMountSupport = []KernelVersion{
+		{
+			Version:      5,
+			PatchLevel:   12,
+			SubLevel:     0,
+			ExtraVersion: 0,
+			Distribution: "",
+			Backport:     false,
+		}, // standard 5.12+ versions
+	}
+)
+
+// IDMappedMountsSupported checks if the kernel and the mount executable can
+// create ID-mapped bind mounts.
+func IDMappedMountsSupported(ctx context.Context) (bool, error) {
+	release, err := GetKernelVersion()
+	if err != nil {
+		return false, fmt.Errorf("failed to get kernel version: %w", err)
+	}
+
+	if !CheckKernelSupport(release, idMappedMountSupport) {
+		return false, nil
+	}
+
+	stdout, _, err := ExecCommand(ctx, "mount", "--version")
+	if err != nil {
+		return false, fmt.Errorf("failed to get version of mount: %w", err)
+	}
+
+	return utilLinuxSupportsIDMap(stdout), nil
+}
+
+// utilLinuxSupportsIDMap parses the output of "mount --version", which looks
+// like "mount from util-linux 2.39.3 (libmount 2.39.3: selinux, ...)".
+func utilLinuxSupportsIDMap(version string) bool {
+	var major, minor int
+
+	idx := strings.Index(version, "util-linux ")
+	if idx == -1 {
+		return false
+	}
+
+	_, err := fmt.Sscanf(version[idx:], "util-linux %d.%d", &major, &minor)
+	if err != nil {
+		return false
+	}
+
+	return major > utilLinuxIDMapMajor || (major == utilLinuxIDMapMajor && minor >= utilLinuxIDMapMinor)
+}
+
+// GroupIDMapMountOption returns the mount option for an ID-mapped bind mount
+// where the files that are owned by group 0 on the filesystem are presented
+// as owned by gid, and the other way around. All other users and groups are
+// mapped to themselves. A gid of 0 does not need an ID-mapped mount.
+func GroupIDMapMountOption(gid uint32) string {
+	g := uint64(gid)
+	mappings := []string{
+		fmt.Sprintf("u:0:0:%d", maxIDRange),
+		fmt.Sprintf("g:%d:0:1", g),
+		fmt.Sprintf("g:0:%d:1", g),
+	}
+
+	if g > 1 {
+		mappings = append(mappings, fmt.Sprintf("g:1:1:%d", g-1))
+	}
+
+	if g+1 < maxIDRange {
+		mappings = append(mappings, fmt.Sprintf("g:%d:%d:%d", g+1, g+1, maxIDRange-g-1))
+	}
+
+	return idMapMountOption + strings.Join(mappings, " ")
+}
+
+// SetGroupIDMapPermissions prepares the filesystem at root for ID-mapped
+// mounts with GroupIDMapMountOption. The CO does not change the ownership of
+// a volume when the driver applies the VolumeMountGroup, so the files and
+// directories are changed to group 0 (presented as the VolumeMountGroup) with
+// read-write permissions for the group, and the directories get the setgid
+// bit so that new files inherit the group. Like the OnRootMismatch
+// fsGroupChangePolicy, nothing is changed when the root directory already has
+// the ownership and permissions. The root directory is changed last, an
+// interrupted walk is repeated on the next call.
+func SetGroupIDMapPermissions(root string) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", root, err)
+	}
+	if hasGroupIDMapPermissions(info) {
+		return nil
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			// removed in the meantime
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		return setGroupIDMapPermissions(path, fi)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the group permissions in %s: %w", root, err)
+	}
+
+	return setGroupIDMapPermissions(root, info)
+}
+
+// hasGroupIDMapPermissions returns true when the file is owned by group 0 and
+// has the permissions of SetGroupIDMapPermissions.
+func hasGroupIDMapPermissions(info fs.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Gid != 0 {
+		return false
+	}
+
+	want := idMapGroupFileMode
+	if info.IsDir() {
+		want = idMapGroupDirMode
+	}
+
+	return info.Mode()&want == want
+}
+
+// setGroupIDMapPermissions changes the group of the file to 0 and adds the
+// permissions of the group. Symbolic links are skipped, as chmod follows them.
+func setGroupIDMapPermissions(path string, info fs.FileInfo) error {
+	if info.Mode()&fs.ModeSymlink != 0 || hasGroupIDMapPermissions(info) {
+		return nil
+	}
+
+	err := os.Lchown(path, -1, 0)
+	if err != nil {
+		return fmt.Errorf("failed to change group of %s: %w", path, err)
+	}
+
+	mode := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	switch {
+	case info.IsDir():
+		mode |= idMapGroupDirMode
+	case info.Mode().IsRegular():
+		mode |= idMapGroupFileMode
+	default:
+		// devices, sockets and pipes only change their group
+		return nil
+	}
+
+	// chown clears the setuid and setgid bits of files, chmod restores them
+	err = os.Chmod(path, mode)
+	if err != nil {
+		return fmt.Errorf("failed to change mode of %s: %w", path, err)
+	}
+
+	return nil
+}
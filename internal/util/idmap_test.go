@@ -0,0 +1,93 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUtilLinuxSupportsIDMap(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		version  string
+		expected bool
+	}{
+		{"mount from util-linux 2.39.3 (libmount 2.39.3: selinux, smack, btrfs, verity, namespaces, idmapping)", true},
+		{"mount from util-linux 2.40 (libmount 2.40: selinux, btrfs, namespaces, idmapping)", true},
+		{"mount from util-linux 2.37.4 (libmount 2.37.4: selinux, smack, btrfs, namespaces, assert, debug)", false},
+		{"mount from util-linux 3.0", true},
+		{"mount: unrecognized option '--version'", false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, utilLinuxSupportsIDMap(tt.version), tt.version)
+	}
+}
+
+func TestGroupIDMapMountOption(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t,
+		"X-mount.idmap=u:0:0:4294967295 g:1000:0:1 g:0:1000:1 g:1:1:999 g:1001:1001:4294966294",
+		GroupIDMapMountOption(1000))
+	require.Equal(t,
+		"X-mount.idmap=u:0:0:4294967295 g:1:0:1 g:0:1:1 g:2:2:4294967293",
+		GroupIDMapMountOption(1))
+}
+
+func TestSetGroupIDMapPermissions(t *testing.T) {
+	t.Parallel()
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing the group of files needs root")
+	}
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "dir")
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	require.NoError(t, os.Lchown(file, -1, 1000))
+	require.NoError(t, os.Chmod(root, 0o755))
+
+	require.NoError(t, SetGroupIDMapPermissions(root))
+
+	for path, want := range map[string]fs.FileMode{
+		root: fs.ModeDir | fs.ModeSetgid | 0o775,
+		dir:  fs.ModeDir | fs.ModeSetgid | 0o770,
+		file: 0o660,
+	} {
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		require.Equal(t, want, info.Mode(), path)
+		require.Equal(t, uint32(0), info.Sys().(*syscall.Stat_t).Gid, path)
+	}
+
+	// the root has the permissions, the other files are not changed again
+	require.NoError(t, os.Chmod(file, 0o600))
+	require.NoError(t, SetGroupIDMapPermissions(root))
+	info, err := os.Lstat(file)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o600), info.Mode())
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DriverInstance is an additional instance of the driver that is served by
+// the process, with its own driver name, journals and endpoints. The other
+// options of the command line are shared by all instances.
+type DriverInstance struct {
+	DriverName        string `json:"driverName"`
+	InstanceID        string `json:"instanceID"`
+	Endpoint          string `json:"endpoint"`
+	CSIAddonsEndpoint string `json:"csiAddonsEndpoint"`
+}
+
+// ReadDriverInstances reads the additional instances of the driver from the
+// JSON file at path. The driver names, instance IDs and endpoints of the
+// instances must differ from each other and from the ones of conf.
+func ReadDriverInstances(path string, conf *Config) ([]DriverInstance, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read driver instances from %q: %w", path, err)
+	}
+
+	var instances []DriverInstance
+	err = json.Unmarshal(content, &instances)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse driver instances in %q: %w", path, err)
+	}
+
+	err = validateDriverInstances(conf, instances)
+	if err != nil {
+		return nil, fmt.Errorf("invalid driver instances in %q: %w", path, err)
+	}
+
+	return instances, nil
+}
+
+// validateDriverInstances checks that the instances have all fields set, and
+// that the values are not used by another instance.
+func validateDriverInstances(conf *Config, instances []DriverInstance) error {
+	if len(instances) == 0 {
+		return errors.New("no instances")
+	}
+
+	// the values that are in use, by field
+	seen := map[string]map[string]bool{
+		"driver name":         {conf.DriverName: true},
+		"instance ID":         {conf.InstanceID: true},
+		"endpoint":            {conf.Endpoint: true},
+		"CSI-Addons endpoint": {conf.CSIAddonsEndpoint: true},
+	}
+	for i := range instances {
+		inst := &instances[i]
+		if err := ValidateDriverName(inst.DriverName); err != nil {
+			return fmt.Errorf("instance %d: %w", i, err)
+		}
+
+		for field, value := range map[string]string{
+			"driver name":         inst.DriverName,
+			"instance ID":         inst.InstanceID,
+			"endpoint":            inst.Endpoint,
+			"CSI-Addons endpoint": inst.CSIAddonsEndpoint,
+		} {
+			if value == "" {
+				return fmt.Errorf("instance %d: %s is empty", i, field)
+			}
+			if seen[field][value] {
+				return fmt.Errorf("instance %d: %s %q is used by another instance", i, field, value)
+			}
+			seen[field][value] = true
+		}
+	}
+
+	return nil
+}
+
+// ForInstance returns a copy of conf for the additional instance of the
+// driver. The CSI-Addons TCP endpoint and the admin endpoint are only served
+// for the instance of conf.
+func (conf *Config) ForInstance(inst DriverInstance) *Config {
+	c := *conf
+	c.DriverName = inst.DriverName
+	c.InstanceID = inst.InstanceID
+	c.Endpoint = inst.Endpoint
+	c.CSIAddonsEndpoint = inst.CSIAddonsEndpoint
+	c.CSIAddonsTCPEndpoint = ""
+	c.AdminEndpoint = ""
+	c.Instances = nil
+
+	return &c
+}
+
+// instanceIDKey is the key of the instance ID in the context of a request.
+type instanceIDKey struct{}
+
+// WithInstanceID returns a context that carries the instance ID of the driver
+// that handles the request, for processes that serve multiple instances.
+func WithInstanceID(ctx context.Context, instanceID string) context.Context {
+	return context.WithValue(ctx, instanceIDKey{}, instanceID)
+}
+
+// InstanceIDFromContext returns the instance ID of WithInstanceID, or an empty
+// string when the context does not carry one.
+func InstanceIDFromContext(ctx context.Context) string {
+	instanceID, _ := ctx.Value(instanceIDKey{}).(string)
+
+	return instanceID
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDriverInstances(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{
+		DriverName:        "rbd.csi.ceph.com",
+		InstanceID:        "default",
+		Endpoint:          "unix:///csi/csi.sock",
+		CSIAddonsEndpoint: "unix:///csi/csi-addons.sock",
+	}
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "instances.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		return path
+	}
+
+	instances, err := ReadDriverInstances(write(`[{
+		"driverName": "rbd.cluster-b.csi.ceph.com",
+		"instanceID": "cluster-b",
+		"endpoint": "unix:///csi/cluster-b/csi.sock",
+		"csiAddonsEndpoint": "unix:///csi/cluster-b/csi-addons.sock"
+	}]`), conf)
+	require.NoError(t, err)
+	require.Equal(t, []DriverInstance{{
+		DriverName:        "rbd.cluster-b.csi.ceph.com",
+		InstanceID:        "cluster-b",
+		Endpoint:          "unix:///csi/cluster-b/csi.sock",
+		CSIAddonsEndpoint: "unix:///csi/cluster-b/csi-addons.sock",
+	}}, instances)
+
+	for name, content := range map[string]string{
+		"not JSON":     `driverName: rbd.cluster-b.csi.ceph.com`,
+		"no instances": `[]`,
+		"missing endpoint": `[{"driverName": "rbd.cluster-b.csi.ceph.com", "instanceID": "cluster-b",
+			"csiAddonsEndpoint": "unix:///csi/cluster-b/csi-addons.sock"}]`,
+		"invalid driver name": `[{"driverName": "rbd_cluster_b", "instanceID": "cluster-b",
+			"endpoint": "unix:///csi/cluster-b/csi.sock",
+			"csiAddonsEndpoint": "unix:///csi/cluster-b/csi-addons.sock"}]`,
+		"driver name of the command line": `[{"driverName": "rbd.csi.ceph.com", "instanceID": "cluster-b",
+			"endpoint": "unix:///csi/cluster-b/csi.sock",
+			"csiAddonsEndpoint": "unix:///csi/cluster-b/csi-addons.sock"}]`,
+		"duplicate instance ID": `[
+			{"driverName": "rbd.cluster-b.csi.ceph.com", "instanceID": "cluster-b",
+				"endpoint": "unix:///csi/cluster-b/csi.sock",
+				"csiAddonsEndpoint": "unix:///csi/cluster-b/csi-addons.sock"},
+			{"driverName": "rbd.cluster-c.csi.ceph.com", "instanceID": "cluster-b",
+				"endpoint": "unix:///csi/cluster-c/csi.sock",
+				"csiAddonsEndpoint": "unix:///csi/cluster-c/csi-addons.sock"}]`,
+	} {
+		_, err = ReadDriverInstances(write(content), conf)
+		require.Error(t, err, name)
+	}
+
+	_, err = ReadDriverInstances(filepath.Join(t.TempDir(), "missing.json"), conf)
+	require.Error(t, err)
+}
+
+func TestConfigForInstance(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{
+		DriverName:           "rbd.csi.ceph.com",
+		InstanceID:           "default",
+		Endpoint:             "unix:///csi/csi.sock",
+		CSIAddonsEndpoint:    "unix:///csi/csi-addons.sock",
+		CSIAddonsTCPEndpoint: "0.0.0.0:9070",
+		AdminEndpoint:        "unix:///csi/admin.sock",
+		NodeID:               "node-1",
+	}
+	inst := DriverInstance{
+		DriverName:        "rbd.cluster-b.csi.ceph.com",
+		InstanceID:        "cluster-b",
+		Endpoint:          "unix:///csi/cluster-b/csi.sock",
+		CSIAddonsEndpoint: "unix:///csi/cluster-b/csi-addons.sock",
+	}
+	conf.Instances = []DriverInstance{inst}
+
+	got := conf.ForInstance(inst)
+	require.Equal(t, inst.DriverName, got.DriverName)
+	require.Equal(t, inst.InstanceID, got.InstanceID)
+	require.Equal(t, inst.Endpoint, got.Endpoint)
+	require.Equal(t, inst.CSIAddonsEndpoint, got.CSIAddonsEndpoint)
+	require.Empty(t, got.CSIAddonsTCPEndpoint)
+	require.Empty(t, got.AdminEndpoint)
+	require.Empty(t, got.Instances)
+	require.Equal(t, "node-1", got.NodeID)
+
+	// the configuration of the first instance is not modified
+	require.Equal(t, "rbd.csi.ceph.com", conf.DriverName)
+	require.Len(t, conf.Instances, 1)
+}
+
+func TestInstanceIDFromContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	require.Empty(t, InstanceIDFromContext(ctx))
+	require.Equal(t, "cluster-b", InstanceIDFromContext(WithInstanceID(ctx, "cluster-b")))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ImageIOStats are the IO rates of an RBD image, as reported by the
+// rbd_support manager module for `rbd perf image iostat`.
+type ImageIOStats struct {
+	Image            string
+	ReadOpsPerSec    float64
+	WriteOpsPerSec   float64
+	ReadBytesPerSec  float64
+	WriteBytesPerSec float64
+	ReadLatency      time.Duration
+	WriteLatency     time.Duration
+}
+
+// imageStatsOutput is the JSON output of the `rbd perf image stats` manager
+// command. The stats of every image are listed in the order of the stat
+// descriptors, keyed by the pool spec and the name of the image.
+type imageStatsOutput struct {
+	StatDescriptors []string                        `json:"stat_descriptors"`
+	Stats           map[string]map[string][]float64 `json:"stats"`
+}
+
+// GetImageIOStats returns the IO rates of the images in the pool and RADOS
+// namespace. The manager module only reports images that had IO since the
+// collection of the stats of the pool started, the first request for a pool
+// may not return any images.
+func (cc *ClusterConnection) GetImageIOStats(pool, namespace string) ([]ImageIOStats, error) {
+	if cc.conn == nil {
+		return nil, errors.New("cluster is not connected yet")
+	}
+
+	poolSpec := pool
+	if namespace != "" {
+		poolSpec += "/" + namespace
+	}
+
+	cmd, err := json.Marshal(map[string]string{
+		"prefix":    "rbd perf image stats",
+		"pool_spec": poolSpec,
+		"sort_by":   "write_ops",
+		"format":    "json",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rbd perf image stats command: %w", err)
+	}
+
+	done := cc.TrackCall("mgr_command")
+	out, status, err := cc.conn.MgrCommand([][]byte{cmd})
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image stats of pool %q (%s): %w", poolSpec, status, err)
+	}
+
+	return parseImageIOStats(out)
+}
+
+// parseImageIOStats converts the output of `rbd perf image stats` to
+// ImageIOStats. Latencies are reported in nanoseconds.
+func parseImageIOStats(data []byte) ([]ImageIOStats, error) {
+	var output imageStatsOutput
+	err := json.Unmarshal(data, &output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rbd perf image stats output: %w", err)
+	}
+
+	stat := func(values []float64, name string) float64 {
+		i := slices.Index(output.StatDescriptors, name)
+		if i == -1 || i >= len(values) {
+			return 0
+		}
+
+		return values[i]
+	}
+
+	stats := []ImageIOStats{}
+	for _, images := range output.Stats {
+		for image, values := range images {
+			stats = append(stats, ImageIOStats{
+				Image:            image,
+				ReadOpsPerSec:    stat(values, "read_ops"),
+				WriteOpsPerSec:   stat(values, "write_ops"),
+				ReadBytesPerSec:  stat(values, "read_bytes"),
+				WriteBytesPerSec: stat(values, "write_bytes"),
+				ReadLatency:      time.Duration(stat(values, "read_latency")),
+				WriteLatency:     time.Duration(stat(values, "write_latency")),
+			})
+		}
+	}
+
+	slices.SortFunc(stats, func(a, b ImageIOStats) int {
+		if a.Image < b.Image {
+			return -1
+		} else if a.Image > b.Image {
+			return 1
+		}
+
+		return 0
+	})
+
+	return stats, nil
+}
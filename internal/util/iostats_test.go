@@ -0,0 +1,61 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageIOStats(t *testing.T) {
+	t.Parallel()
+
+	out := []byte(`{
+		"timestamp": "1700000000.0",
+		"stat_descriptors": ["write_ops", "read_ops", "write_bytes", "read_bytes", "write_latency", "read_latency"],
+		"stats": {
+			"replicapool": {
+				"csi-vol-2": [10, 20, 4096, 8192, 2000000, 500000],
+				"csi-vol-1": [1, 0, 512]
+			}
+		}
+	}`)
+
+	stats, err := parseImageIOStats(out)
+	require.NoError(t, err)
+	require.Equal(t, []ImageIOStats{
+		{Image: "csi-vol-1", WriteOpsPerSec: 1, WriteBytesPerSec: 512},
+		{
+			Image:            "csi-vol-2",
+			ReadOpsPerSec:    20,
+			WriteOpsPerSec:   10,
+			ReadBytesPerSec:  8192,
+			WriteBytesPerSec: 4096,
+			ReadLatency:      500 * time.Microsecond,
+			WriteLatency:     2 * time.Millisecond,
+		},
+	}, stats)
+
+	stats, err = parseImageIOStats([]byte(`{"stat_descriptors": [], "stats": {}}`))
+	require.NoError(t, err)
+	require.Empty(t, stats)
+
+	_, err = parseImageIOStats([]byte(`[`))
+	require.Error(t, err)
+}
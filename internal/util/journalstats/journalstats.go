@@ -0,0 +1,189 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package journalstats counts the entries in the journals of the pools of a
+// driver, and publishes them as metrics and on an HTTP endpoint, so that the
+// growth of the metadata can be monitored and leaked entries detected.
+package journalstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Path is the HTTP path that lists the journal entries of every pool.
+const Path = "/journal"
+
+// PoolEntries is the number of entries in the journal of a pool (or a
+// filesystem) of a cluster.
+type PoolEntries struct {
+	ClusterID string `json:"clusterID"`
+	Pool      string `json:"pool"`
+	Volumes   int    `json:"volumes"`
+	Snapshots int    `json:"snapshots"`
+	Groups    int    `json:"groups"`
+	// Error is set when the entries could not be counted.
+	Error string `json:"error,omitempty"`
+}
+
+// Counter counts the journal entries of a driver per cluster and pool.
+type Counter func(ctx context.Context) []PoolEntries
+
+// Reporter counts the journal entries at an interval, and publishes them.
+type Reporter struct {
+	driverName string
+	interval   time.Duration
+	count      Counter
+
+	entries *prometheus.GaugeVec
+	errors  *prometheus.GaugeVec
+
+	// mutex protects pools, the result of the last count
+	mutex sync.RWMutex
+	pools []PoolEntries
+}
+
+// NewReporter returns a Reporter that registers its metrics with prometheus.
+func NewReporter(driverName string, interval time.Duration, count Counter) (*Reporter, error) {
+	r := &Reporter{
+		driverName: driverName,
+		interval:   interval,
+		count:      count,
+		entries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "csi",
+			Subsystem: "journal",
+			Name:      "entries",
+			Help:      "Number of entries of the type in the journal of the pool",
+		}, []string{"driver", "cluster_id", "pool", "type"}),
+		errors: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "csi",
+			Subsystem: "journal",
+			Name:      "count_failed",
+			Help:      "1 when the entries in the journal of the pool could not be counted",
+		}, []string{"driver", "cluster_id", "pool"}),
+		pools: []PoolEntries{},
+	}
+
+	for _, c := range []prometheus.Collector{r.entries, r.errors} {
+		err := prometheus.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register journal metrics: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// Run counts and publishes the journal entries until the context is
+// cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.report(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reporter) report(ctx context.Context) {
+	pools := r.count(ctx)
+	slices.SortFunc(pools, func(a, b PoolEntries) int {
+		return strings.Compare(a.ClusterID+"/"+a.Pool, b.ClusterID+"/"+b.Pool)
+	})
+
+	r.publishMetrics(pools)
+
+	r.mutex.Lock()
+	r.pools = pools
+	r.mutex.Unlock()
+
+	log.DebugLog(ctx, "journal entries counted for %d pools", len(pools))
+}
+
+func (r *Reporter) publishMetrics(pools []PoolEntries) {
+	// pools that are not used anymore should not be reported
+	r.entries.Reset()
+	r.errors.Reset()
+
+	for _, pe := range pools {
+		if pe.Error != "" {
+			r.errors.WithLabelValues(r.driverName, pe.ClusterID, pe.Pool).Set(1)
+
+			continue
+		}
+
+		r.errors.WithLabelValues(r.driverName, pe.ClusterID, pe.Pool).Set(0)
+		r.entries.WithLabelValues(r.driverName, pe.ClusterID, pe.Pool, "volume").Set(float64(pe.Volumes))
+		r.entries.WithLabelValues(r.driverName, pe.ClusterID, pe.Pool, "snapshot").Set(float64(pe.Snapshots))
+		r.entries.WithLabelValues(r.driverName, pe.ClusterID, pe.Pool, "group").Set(float64(pe.Groups))
+	}
+}
+
+// Pools returns the journal entries of every pool from the last count.
+func (r *Reporter) Pools() []PoolEntries {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return slices.Clone(r.pools)
+}
+
+// ServeHTTP lists the journal entries of every pool from the last count as
+// JSON. The pools can be limited with the "clusterID" query parameter.
+func (r *Reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	pools := r.Pools()
+	if clusterID := req.URL.Query().Get("clusterID"); clusterID != "" {
+		pools = slices.DeleteFunc(pools, func(pe PoolEntries) bool {
+			return pe.ClusterID != clusterID
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(pools)
+	if err != nil {
+		log.ErrorLogMsg("failed to write journal entries: %v", err)
+	}
+}
+
+// Start runs a Reporter in the background, and registers the listing
+// endpoint on the default HTTP mux. The HTTP server needs to be started
+// separately.
+func Start(driverName string, interval time.Duration, count Counter) error {
+	r, err := NewReporter(driverName, interval, count)
+	if err != nil {
+		return err
+	}
+
+	http.Handle(Path, r)
+	go r.Run(context.Background())
+
+	return nil
+}
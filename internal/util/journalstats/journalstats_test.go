@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journalstats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporter(t *testing.T) {
+	t.Parallel()
+
+	r, err := NewReporter("rbd.csi.ceph.com", time.Minute, func(context.Context) []PoolEntries {
+		return []PoolEntries{
+			{ClusterID: "cluster-b", Pool: "replicapool", Volumes: 3, Snapshots: 1},
+			{ClusterID: "cluster-a", Pool: "replicapool", Volumes: 2, Groups: 1},
+			{ClusterID: "cluster-a", Pool: "ecpool", Error: "permission denied"},
+		}
+	})
+	require.NoError(t, err)
+
+	r.report(context.TODO())
+	pools := r.Pools()
+	require.Len(t, pools, 3)
+	require.Equal(t, "ecpool", pools[0].Pool)
+	require.Equal(t, "cluster-b", pools[2].ClusterID)
+
+	require.InDelta(t, 2, testutil.ToFloat64(
+		r.entries.WithLabelValues("rbd.csi.ceph.com", "cluster-a", "replicapool", "volume")), 0)
+	require.InDelta(t, 1, testutil.ToFloat64(
+		r.errors.WithLabelValues("rbd.csi.ceph.com", "cluster-a", "ecpool")), 0)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path+"?clusterID=cluster-b", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t,
+		`[{"clusterID":"cluster-b","pool":"replicapool","volumes":3,"snapshots":1,"groups":0}]`,
+		rec.Body.String())
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsongrpc serves the gRPC services of the driver that have no
+// protobuf definitions. The messages of these services are Go structs that
+// are encoded as JSON. The codec is registered for the "json"
+// content-subtype, so that the services can be registered on the same gRPC
+// server as the protobuf services, like the CSI-Addons server.
+package jsongrpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	// Name is the name of the codec, and the content-subtype of the calls.
+	Name = "json"
+
+	// SecretsField is the field of a request that contains its secrets,
+	// it is stripped when the request is logged.
+	SecretsField = "secrets"
+)
+
+// codec encodes the messages as JSON.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return Name
+}
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// Codec returns the JSON codec, for servers and clients that only use JSON
+// messages.
+func Codec() encoding.Codec {
+	return codec{}
+}
+
+// strippedRequest is a request as the interceptors of the server see it. The
+// secrets of the request are stripped when it is logged, as JSON or with %v.
+type strippedRequest struct {
+	req any
+}
+
+// MarshalJSON returns the request with the value of the SecretsField
+// replaced, the same way as the protosanitizer does for CSI requests.
+func (sr strippedRequest) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(sr.req)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		// not an object, there is nothing to strip
+		return data, nil
+	}
+	if _, ok := fields[SecretsField]; ok {
+		fields[SecretsField] = json.RawMessage(`"***stripped***"`)
+	}
+
+	return json.Marshal(fields)
+}
+
+func (sr strippedRequest) String() string {
+	data, err := sr.MarshalJSON()
+	if err != nil {
+		return "<<" + err.Error() + ">>"
+	}
+
+	return string(data)
+}
+
+// UnaryMethod returns the description of the method of the service, that
+// decodes the request and calls the server with it.
+func UnaryMethod[S, Req, Resp any](
+	service, name string,
+	call func(srv S, ctx context.Context, req *Req) (*Resp, error),
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(
+			srv any,
+			ctx context.Context,
+			dec func(any) error,
+			interceptor grpc.UnaryServerInterceptor,
+		) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			//nolint:forcetypeassert // the server is registered with its ServiceDesc
+			server := srv.(S)
+			if interceptor == nil {
+				return call(server, ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + service + "/" + name,
+			}
+			handler := func(ctx context.Context, _ any) (any, error) {
+				return call(server, ctx, req)
+			}
+
+			return interceptor(ctx, strippedRequest{req: req}, info, handler)
+		},
+	}
+}
+
+// Invoke calls the method of the service with the JSON encoded request, and
+// decodes the response into resp.
+func Invoke(
+	ctx context.Context,
+	conn grpc.ClientConnInterface,
+	service, method string,
+	req, resp any,
+) error {
+	return conn.Invoke(ctx, "/"+service+"/"+method, req, resp, grpc.CallContentSubtype(Name))
+}
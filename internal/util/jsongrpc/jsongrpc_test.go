@@ -0,0 +1,103 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsongrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const testService = "cephcsi.test.v1.Echo"
+
+type echoRequest struct {
+	Message string            `json:"message"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+type echoResponse struct {
+	Message string `json:"message"`
+	Secret  string `json:"secret"`
+}
+
+type echoServer interface {
+	Echo(ctx context.Context, req *echoRequest) (*echoResponse, error)
+}
+
+type echo struct{}
+
+func (echo) Echo(_ context.Context, req *echoRequest) (*echoResponse, error) {
+	return &echoResponse{Message: req.Message, Secret: req.Secrets["key"]}, nil
+}
+
+func TestUnaryMethod(t *testing.T) {
+	t.Parallel()
+
+	logged := make(chan string, 1)
+	// the server uses the protobuf codec by default, the json codec is
+	// selected by the content-subtype of the call
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		logged <- fmt.Sprintf("%s %v", info.FullMethod, req)
+
+		return handler(ctx, req)
+	}))
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: testService,
+		HandlerType: (*echoServer)(nil),
+		Methods: []grpc.MethodDesc{
+			UnaryMethod(testService, "Echo", echoServer.Echo),
+		},
+	}, echo{})
+
+	socket := filepath.Join(t.TempDir(), "echo.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	go server.Serve(listener) //nolint:errcheck // stopped by the test
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := &echoRequest{Message: "hello", Secrets: map[string]string{"key": "secret-value"}}
+	resp := &echoResponse{}
+	require.NoError(t, Invoke(context.TODO(), conn, testService, "Echo", req, resp))
+	require.Equal(t, &echoResponse{Message: "hello", Secret: "secret-value"}, resp)
+	require.Equal(t, "/"+testService+`/Echo {"message":"hello","secrets":"***stripped***"}`, <-logged)
+
+	err = Invoke(context.TODO(), conn, testService, "Unknown", req, resp)
+	require.Error(t, err)
+}
+
+func TestStrippedRequest(t *testing.T) {
+	t.Parallel()
+
+	// requests without secrets are not modified
+	require.JSONEq(t, `{"message":"hello","secret":""}`, strippedRequest{&echoResponse{Message: "hello"}}.String())
+	require.Equal(t, `"text"`, strippedRequest{"text"}.String())
+}
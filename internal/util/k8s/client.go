@@ -34,19 +34,9 @@ func NewK8sClient() (*kubernetes.Clientset, error) {
 		return kubeclient, nil
 	}
 
-	var cfg *rest.Config
-	var err error
-	cPath := os.Getenv("KUBERNETES_CONFIG_PATH")
-	if cPath != "" {
-		cfg, err = clientcmd.BuildConfigFromFlags("", cPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get cluster config from %q: %w", cPath, err)
-		}
-	} else {
-		cfg, err = rest.InClusterConfig()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get cluster config: %w", err)
-		}
+	cfg, err := RestConfig()
+	if err != nil {
+		return nil, err
 	}
 	cfg.ContentType = runtime.ContentTypeProtobuf
 	client, err := kubernetes.NewForConfig(cfg)
@@ -59,6 +49,28 @@ func NewK8sClient() (*kubernetes.Clientset, error) {
 	return client, nil
 }
 
+// RestConfig returns the configuration to connect to the Kubernetes API, from
+// the file in the KUBERNETES_CONFIG_PATH environment variable, or the
+// in-cluster configuration.
+func RestConfig() (*rest.Config, error) {
+	cPath := os.Getenv("KUBERNETES_CONFIG_PATH")
+	if cPath != "" {
+		cfg, err := clientcmd.BuildConfigFromFlags("", cPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cluster config from %q: %w", cPath, err)
+		}
+
+		return cfg, nil
+	}
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster config: %w", err)
+	}
+
+	return cfg, nil
+}
+
 // RunsOnKubernetes checks if the application is running within a Kubernetes cluster
 // by inspecting the presence of the KUBERNETES_SERVICE_HOST environment variable.
 func RunsOnKubernetes() bool {
@@ -0,0 +1,45 @@
+/*
+Copyright 2026 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWriteConfigMap(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	client := fake.NewClientset()
+
+	// the ConfigMap is created
+	require.NoError(t, WriteConfigMap(ctx, client, "ceph-csi", "status", map[string]string{"a": "1"}))
+	cm, err := client.CoreV1().ConfigMaps("ceph-csi").Get(ctx, "status", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "1"}, cm.Data)
+
+	// the data of an existing ConfigMap is replaced
+	require.NoError(t, WriteConfigMap(ctx, client, "ceph-csi", "status", map[string]string{"b": "2"}))
+	cm, err = client.CoreV1().ConfigMaps("ceph-csi").Get(ctx, "status", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"b": "2"}, cm.Data)
+}
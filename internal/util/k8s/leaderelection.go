@@ -0,0 +1,117 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// The durations of the leases that elect the replica of the provisioner that
+// runs a background task, the defaults of the Kubernetes components.
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// NewLeaseLock returns the lock of the lease name in namespace, with the name
+// of the pod as identity.
+func NewLeaseLock(client kubernetes.Interface, namespace, name string) (resourcelock.Interface, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the identity for lease %s/%s: %w", namespace, name, err)
+	}
+
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, name,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lease %s/%s: %w", namespace, name, err)
+	}
+
+	return lock, nil
+}
+
+// RunElected calls run while the lock is held, so that only one of the
+// replicas of the provisioner runs it. The context that is passed to run is
+// cancelled when the lease is lost, stopped is called then when it is not
+// nil. RunElected waits to acquire the lease again, until ctx is cancelled.
+func RunElected(
+	ctx context.Context,
+	lock resourcelock.Interface,
+	run func(ctx context.Context),
+	stopped func(),
+) error {
+	lec := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lock.Describe(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.DebugLog(ctx, "acquired lease %s", lock.Describe())
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.DebugLog(ctx, "lost lease %s", lock.Describe())
+				if stopped != nil {
+					stopped()
+				}
+			},
+		},
+	}
+
+	for ctx.Err() == nil {
+		// a LeaderElector returns once the lease is lost, a new one is
+		// needed to acquire the lease again
+		le, err := leaderelection.NewLeaderElector(lec)
+		if err != nil {
+			return fmt.Errorf("failed to create leader election for lease %s: %w", lock.Describe(), err)
+		}
+		le.Run(ctx)
+	}
+
+	return nil
+}
+
+// StartElected calls run in the background, in the replica of the
+// provisioner that holds the lease name in namespace.
+func StartElected(client kubernetes.Interface, namespace, name string, run func(ctx context.Context)) error {
+	lock, err := NewLeaseLock(client, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ctx := context.Background()
+		if rErr := RunElected(ctx, lock, run, nil); rErr != nil {
+			log.ErrorLog(ctx, "leader election for lease %s/%s stopped: %v", namespace, name, rErr)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunElected(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	lock, err := NewLeaseLock(client, "ceph-csi", "rbd.csi.ceph.com-test")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	running := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- RunElected(ctx, lock, func(ctx context.Context) {
+			close(running)
+			<-ctx.Done()
+		}, nil)
+	}()
+	<-running
+
+	// run is called while the pod holds the lease
+	lease, err := client.CoordinationV1().Leases("ceph-csi").Get(ctx, "rbd.csi.ceph.com-test", metav1.GetOptions{})
+	require.NoError(t, err)
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	require.Equal(t, hostname, *lease.Spec.HolderIdentity)
+
+	cancel()
+	require.NoError(t, <-done)
+}
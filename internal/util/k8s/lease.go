@@ -0,0 +1,82 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// invalidLeaseNameChars are replaced in the name of the lease of the
+// external-provisioner, like csi-lib-utils does.
+var invalidLeaseNameChars = regexp.MustCompile("[^a-zA-Z0-9-]")
+
+// ProvisionerLeaseName returns the name of the lease that the
+// external-provisioner of the driver uses for its leader election. Only the
+// replica of the provisioner that holds the lease receives the CSI requests.
+func ProvisionerLeaseName(driverName string) string {
+	name := invalidLeaseNameChars.ReplaceAllString(strings.ReplaceAll(driverName, "/", "-"), "-")
+	if strings.HasSuffix(name, "-") {
+		name += "X"
+	}
+
+	return name
+}
+
+// GetLeaseHolder returns the identity of the holder of the lease, which is
+// the name of the pod for the sidecars of the driver. An empty string is
+// returned when the lease is not held.
+func GetLeaseHolder(ctx context.Context, client kubernetes.Interface, namespace, name string) (string, error) {
+	lease, err := client.CoordinationV1().Leases(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get lease %s/%s: %w", namespace, name, err)
+	}
+
+	if lease.Spec.HolderIdentity == nil {
+		return "", nil
+	}
+
+	return *lease.Spec.HolderIdentity, nil
+}
+
+// HoldsProvisionerLease returns true when the pod of this process holds the
+// lease of the external-provisioner of the driver, in the namespace of the
+// pod. The holder is returned as well, so that callers can point to it.
+func HoldsProvisionerLease(ctx context.Context, client kubernetes.Interface, driverName string) (bool, string, error) {
+	namespace := os.Getenv(podNamespaceEnvVar)
+	if namespace == "" {
+		return false, "", fmt.Errorf("environment variable %s is not set", podNamespaceEnvVar)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false, "", fmt.Errorf("failed to get the name of the pod: %w", err)
+	}
+
+	holder, err := GetLeaseHolder(ctx, client, namespace, ProvisionerLeaseName(driverName))
+	if err != nil {
+		return false, "", err
+	}
+
+	return holder == hostname, holder, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProvisionerLeaseName(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "rbd-csi-ceph-com", ProvisionerLeaseName("rbd.csi.ceph.com"))
+	require.Equal(t, "example-com-rbd", ProvisionerLeaseName("example.com/rbd"))
+	require.Equal(t, "rbd-X", ProvisionerLeaseName("rbd."))
+}
+
+func TestGetLeaseHolder(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	holder := "csi-rbdplugin-provisioner-0"
+	client := fake.NewClientset(
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "rbd-csi-ceph-com", Namespace: "ceph-csi"},
+			Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "released", Namespace: "ceph-csi"},
+		},
+	)
+
+	got, err := GetLeaseHolder(ctx, client, "ceph-csi", "rbd-csi-ceph-com")
+	require.NoError(t, err)
+	require.Equal(t, holder, got)
+
+	got, err = GetLeaseHolder(ctx, client, "ceph-csi", "released")
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	_, err = GetLeaseHolder(ctx, client, "ceph-csi", "missing")
+	require.Error(t, err)
+}
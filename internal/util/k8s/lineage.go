@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Lineage metadata keys, set on images and subvolumes next to the PV/PVC
+// metadata, so that the origin of a volume can be audited from the Ceph side.
+const (
+	pvcUIDKey          = "csi.ceph.com/pvc/uid"
+	provisionerPodKey  = "csi.ceph.com/provisioner/pod"
+	volumeSourceKey    = "csi.ceph.com/source/type"
+	volumeSourceIDKey  = "csi.ceph.com/source/id"
+	podNamespaceEnvVar = "POD_NAMESPACE"
+)
+
+// Values of the volumeSourceKey metadata.
+const (
+	// VolumeSourceNew is an empty volume.
+	VolumeSourceNew = "new"
+	// VolumeSourceSnapshot is a volume restored from a snapshot.
+	VolumeSourceSnapshot = "snapshot"
+	// VolumeSourceClone is a clone of another volume.
+	VolumeSourceClone = "clone"
+)
+
+// GetVolumeLineageMetadataKeys returns the lineage metadata keys.
+func GetVolumeLineageMetadataKeys() []string {
+	return []string{
+		pvcUIDKey,
+		provisionerPodKey,
+		volumeSourceKey,
+		volumeSourceIDKey,
+	}
+}
+
+// GetVolumeLineageMetadata returns the lineage metadata for a volume that is
+// created with the parameters and content source of a CreateVolume request.
+// The UID of the PVC is looked up in Kubernetes, it is left out when the PVC
+// can not be found.
+func GetVolumeLineageMetadata(
+	ctx context.Context,
+	parameters map[string]string,
+	source *csi.VolumeContentSource,
+) map[string]string {
+	metadata := map[string]string{
+		provisionerPodKey: getProvisionerPod(),
+	}
+
+	sourceType, sourceID := getVolumeSource(source)
+	metadata[volumeSourceKey] = sourceType
+	if sourceID != "" {
+		metadata[volumeSourceIDKey] = sourceID
+	}
+
+	pvcName, pvcNamespace := parameters[pvcNameKey], parameters[pvcNamespaceKey]
+	if pvcName != "" && pvcNamespace != "" && RunsOnKubernetes() {
+		uid, err := getPVCUID(ctx, pvcName, pvcNamespace)
+		if err != nil {
+			log.WarningLog(ctx, "not recording the UID of PVC %s/%s: %v", pvcNamespace, pvcName, err)
+		} else {
+			metadata[pvcUIDKey] = uid
+		}
+	}
+
+	return metadata
+}
+
+// getVolumeSource returns the type and the ID of the volume content source.
+func getVolumeSource(source *csi.VolumeContentSource) (string, string) {
+	switch {
+	case source.GetSnapshot() != nil:
+		return VolumeSourceSnapshot, source.GetSnapshot().GetSnapshotId()
+	case source.GetVolume() != nil:
+		return VolumeSourceClone, source.GetVolume().GetVolumeId()
+	}
+
+	return VolumeSourceNew, ""
+}
+
+// getProvisionerPod returns the identity of the provisioner in the form
+// "namespace/pod". The name of the pod is the hostname of the container.
+func getProvisionerPod() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	if ns := os.Getenv(podNamespaceEnvVar); ns != "" {
+		return ns + "/" + hostname
+	}
+
+	return hostname
+}
+
+func getPVCUID(ctx context.Context, name, namespace string) (string, error) {
+	client, err := NewK8sClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get PVC: %w", err)
+	}
+
+	return string(pvc.GetUID()), nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetVolumeSource(t *testing.T) {
+	t.Parallel()
+
+	sourceType, sourceID := getVolumeSource(nil)
+	require.Equal(t, VolumeSourceNew, sourceType)
+	require.Empty(t, sourceID)
+
+	sourceType, sourceID = getVolumeSource(&csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1"},
+		},
+	})
+	require.Equal(t, VolumeSourceSnapshot, sourceType)
+	require.Equal(t, "snap-1", sourceID)
+
+	sourceType, sourceID = getVolumeSource(&csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "vol-1"},
+		},
+	})
+	require.Equal(t, VolumeSourceClone, sourceType)
+	require.Equal(t, "vol-1", sourceID)
+}
+
+func TestGetVolumeLineageMetadata(t *testing.T) {
+	t.Parallel()
+
+	// not running on Kubernetes, the PVC UID is not looked up
+	metadata := GetVolumeLineageMetadata(context.TODO(), map[string]string{
+		pvcNameKey:      "claim",
+		pvcNamespaceKey: "default",
+	}, nil)
+	require.Equal(t, VolumeSourceNew, metadata[volumeSourceKey])
+	require.NotEmpty(t, metadata[provisionerPodKey])
+	require.NotContains(t, metadata, volumeSourceIDKey)
+	require.NotContains(t, metadata, pvcUIDKey)
+}
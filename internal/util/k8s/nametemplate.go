@@ -0,0 +1,136 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VolumeNameTemplateParam is the StorageClass parameter with the template of
+// the prefix of the image or subvolume names.
+const VolumeNameTemplateParam = "volumeNameTemplate"
+
+// SnapshotNameTemplateParam is the VolumeGroupSnapshotClass parameter with the
+// template of the prefix of the image names of the snapshots in the group.
+const SnapshotNameTemplateParam = "snapshotNameTemplate"
+
+// maxVolumeNamePrefix is the maximum length of a rendered prefix, a UUID is
+// appended to the prefix to get the name of the image or subvolume.
+const maxVolumeNamePrefix = 64
+
+var (
+	templateVariable = regexp.MustCompile(`\$\{([a-z.]+)\}`)
+	invalidNameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+	errUnknownVariable    = errors.New("unknown variable")
+	errMissingPVCMetadata = errors.New("the PVC name and namespace are not in the request, " +
+		"the external-provisioner needs to run with --extra-create-metadata")
+)
+
+// RenderVolumeNamePrefix returns the prefix for the image or subvolume name of
+// a volume from the template and the parameters of the CreateVolume request.
+// The template can contain the variables ${pvc.namespace}, ${pvc.name},
+// ${pv.name} and ${pvc.hash}, a short hash of the namespace and name of the
+// PVC. Characters that are not allowed in names are replaced by "-", and the
+// prefix is truncated to 64 characters. The name stays unique, as the UUID
+// that the journal reserves for the volume is appended.
+func RenderVolumeNamePrefix(template string, parameters map[string]string) (string, error) {
+	pvcName, pvcNamespace := parameters[pvcNameKey], parameters[pvcNamespaceKey]
+
+	return renderNamePrefix(VolumeNameTemplateParam, template, func(name string) (string, error) {
+		var value string
+		switch name {
+		case "pvc.name":
+			value = pvcName
+		case "pvc.namespace":
+			value = pvcNamespace
+		case "pv.name":
+			value = parameters[pvNameKey]
+		case "pvc.hash":
+			if pvcName != "" && pvcNamespace != "" {
+				sum := sha256.Sum256([]byte(pvcNamespace + "/" + pvcName))
+				value = hex.EncodeToString(sum[:4])
+			}
+		default:
+			return "", errUnknownVariable
+		}
+		if value == "" {
+			return "", errMissingPVCMetadata
+		}
+
+		return value, nil
+	})
+}
+
+// RenderSnapshotNamePrefix returns the prefix for the image name of the
+// snapshot of an image in a volume group snapshot. The template can contain
+// the variables ${groupsnapshot.name}, the name of the CreateVolumeGroupSnapshot
+// request, ${image.name}, the name of the source image, and ${index}, the
+// position of the image in the group snapshot. The prefix is sanitized and
+// truncated like the prefix of RenderVolumeNamePrefix.
+func RenderSnapshotNamePrefix(template, groupSnapshotName, imageName string, index int) (string, error) {
+	return renderNamePrefix(SnapshotNameTemplateParam, template, func(name string) (string, error) {
+		switch name {
+		case "groupsnapshot.name":
+			return groupSnapshotName, nil
+		case "image.name":
+			return imageName, nil
+		case "index":
+			return strconv.Itoa(index), nil
+		}
+
+		return "", errUnknownVariable
+	})
+}
+
+// renderNamePrefix replaces the variables in the template of the parameter
+// by the value that lookup returns for their name. Characters that are not
+// allowed in names are replaced by "-", and the prefix is truncated to
+// maxVolumeNamePrefix characters.
+func renderNamePrefix(param, template string, lookup func(name string) (string, error)) (string, error) {
+	var err error
+	prefix := templateVariable.ReplaceAllStringFunc(template, func(v string) string {
+		value, lookupErr := lookup(templateVariable.FindStringSubmatch(v)[1])
+		if lookupErr != nil && err == nil {
+			err = fmt.Errorf("%s %q uses %s: %w", param, template, v, lookupErr)
+		}
+
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(prefix, "${") {
+		return "", fmt.Errorf("invalid variable in %s %q", param, template)
+	}
+
+	prefix = invalidNameChars.ReplaceAllString(strings.ToLower(prefix), "-")
+	if len(prefix) > maxVolumeNamePrefix {
+		prefix = prefix[:maxVolumeNamePrefix]
+	}
+	if strings.Trim(prefix, "-") == "" {
+		return "", fmt.Errorf("%s %q results in an empty prefix", param, template)
+	}
+
+	return prefix, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderVolumeNamePrefix(t *testing.T) {
+	t.Parallel()
+
+	params := map[string]string{
+		pvcNameKey:      "Data_01",
+		pvcNamespaceKey: "team-a",
+		pvNameKey:       "pvc-1234",
+	}
+
+	prefix, err := RenderVolumeNamePrefix("${pvc.namespace}-${pvc.name}-", params)
+	require.NoError(t, err)
+	require.Equal(t, "team-a-data_01-", prefix)
+
+	prefix, err = RenderVolumeNamePrefix("k8s/${pvc.hash}-", params)
+	require.NoError(t, err)
+	require.Regexp(t, `^k8s-[0-9a-f]{8}-$`, prefix)
+
+	prefix, err = RenderVolumeNamePrefix(strings.Repeat("x", 100)+"${pv.name}", params)
+	require.NoError(t, err)
+	require.Len(t, prefix, maxVolumeNamePrefix)
+
+	_, err = RenderVolumeNamePrefix("${pvc.uid}-", params)
+	require.Error(t, err)
+
+	_, err = RenderVolumeNamePrefix("${pvc.name}-", map[string]string{})
+	require.ErrorIs(t, err, errMissingPVCMetadata)
+
+	_, err = RenderVolumeNamePrefix("${PVC.name}-", params)
+	require.Error(t, err)
+
+	_, err = RenderVolumeNamePrefix("@@", params)
+	require.Error(t, err)
+}
+
+func TestRenderSnapshotNamePrefix(t *testing.T) {
+	t.Parallel()
+
+	prefix, err := RenderSnapshotNamePrefix("${groupsnapshot.name}-${image.name}-${index}-",
+		"groupsnapshot-1234", "csi-vol-abcd", 3)
+	require.NoError(t, err)
+	require.Equal(t, "groupsnapshot-1234-csi-vol-abcd-3-", prefix)
+
+	prefix, err = RenderSnapshotNamePrefix("Backup ${image.name}-", "groupsnapshot-1234", "csi-vol-abcd", 0)
+	require.NoError(t, err)
+	require.Equal(t, "backup-csi-vol-abcd-", prefix)
+
+	_, err = RenderSnapshotNamePrefix("${pvc.name}-", "groupsnapshot-1234", "csi-vol-abcd", 0)
+	require.ErrorIs(t, err, errUnknownVariable)
+
+	_, err = RenderSnapshotNamePrefix("@@", "groupsnapshot-1234", "csi-vol-abcd", 0)
+	require.Error(t, err)
+}
@@ -18,9 +18,13 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 )
 
 func GetNodeLabels(nodeName string) (map[string]string, error) {
@@ -37,3 +41,46 @@ func GetNodeLabels(nodeName string) (map[string]string, error) {
 
 	return node.GetLabels(), nil
 }
+
+// ReplaceNodeLabels sets the labels on the Node, and removes its other labels
+// that start with prefix, with a single merge patch. The "patch" verb for
+// nodes is required.
+func ReplaceNodeLabels(
+	ctx context.Context,
+	client kubernetes.Interface,
+	nodeName, prefix string,
+	labels map[string]string,
+) error {
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %q information: %w", nodeName, err)
+	}
+
+	// a label with a null value is removed by the merge patch
+	patchLabels := map[string]any{}
+	for key := range node.GetLabels() {
+		if strings.HasPrefix(key, prefix) {
+			patchLabels[key] = nil
+		}
+	}
+	for key, value := range labels {
+		patchLabels[key] = value
+	}
+	if len(patchLabels) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"labels": patchLabels},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal labels of node %q: %w", nodeName, err)
+	}
+
+	_, err = client.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to patch labels of node %q: %w", nodeName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReplaceNodeLabels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	client := fake.NewClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "worker-1",
+			Labels: map[string]string{
+				"kubernetes.io/hostname":                 "worker-1",
+				"capability.rbd.csi.ceph.com/nbd":        "true",
+				"capability.rbd.csi.ceph.com/cryptsetup": "2.6.1",
+			},
+		},
+	})
+
+	// labels with the prefix that are not set anymore are removed, the
+	// other labels are kept
+	err := ReplaceNodeLabels(ctx, client, "worker-1", "capability.rbd.csi.ceph.com/", map[string]string{
+		"capability.rbd.csi.ceph.com/nbd": "false",
+	})
+	require.NoError(t, err)
+
+	node, err := client.CoreV1().Nodes().Get(ctx, "worker-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"kubernetes.io/hostname":          "worker-1",
+		"capability.rbd.csi.ceph.com/nbd": "false",
+	}, node.GetLabels())
+
+	err = ReplaceNodeLabels(ctx, client, "worker-2", "capability.rbd.csi.ceph.com/", nil)
+	require.Error(t, err)
+}
@@ -54,6 +54,12 @@ func GetOwner(param map[string]string) string {
 	return param[pvcNamespaceKey]
 }
 
+// GetPVName returns the PersistentVolume name from the parameters or the
+// metadata of a volume.
+func GetPVName(param map[string]string) string {
+	return param[pvNameKey]
+}
+
 // GetVolumeMetadata filter parameters, only return PV/PVC/PVCNamespace metadata.
 func GetVolumeMetadata(parameters map[string]string) map[string]string {
 	keys := []string{pvcNameKey, pvcNamespaceKey, pvNameKey}
@@ -69,13 +75,14 @@ func GetVolumeMetadata(parameters map[string]string) map[string]string {
 	return newParam
 }
 
-// GetVolumeMetadataKeys return volume metadata keys.
+// GetVolumeMetadataKeys return volume metadata keys, including the lineage
+// metadata keys.
 func GetVolumeMetadataKeys() []string {
-	return []string{
+	return append([]string{
 		pvcNameKey,
 		pvcNamespaceKey,
 		pvNameKey,
-	}
+	}, GetVolumeLineageMetadataKeys()...)
 }
 
 // PrepareVolumeMetadata return PV/PVC/PVCNamespace metadata based on inputs.
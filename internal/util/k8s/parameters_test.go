@@ -91,3 +91,35 @@ func TestGetOwner(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPVName(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		args map[string]string
+		want string
+	}{
+		{
+			name: "pv name is not present in the metadata",
+			args: map[string]string{
+				"csi.storage.k8s.io/pvc/name": "foo",
+			},
+			want: "",
+		},
+		{
+			name: "pv name is present in the metadata",
+			args: map[string]string{
+				"csi.storage.k8s.io/pv/name": "pvc-4a8b7cde",
+			},
+			want: "pvc-4a8b7cde",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := GetPVName(tt.args); got != tt.want {
+				t.Errorf("GetPVName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package keycache keeps passphrases of encrypted volumes in memory for a
+// limited time, so that they do not need to be fetched from the KMS for
+// every operation on the volume.
+package keycache
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Cache is a cache of passphrases, indexed by the ID of the volume. Every
+// passphrase is stored in a page of its own, that is locked in memory so that
+// it is never written to swap, and cleared when the passphrase is removed.
+type Cache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mtx     sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is a passphrase in a locked page.
+type entry struct {
+	page    []byte
+	length  int
+	expires time.Time
+}
+
+// New returns a Cache that keeps the passphrases for ttl.
+func New(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+// Get returns a copy of the passphrase of the volume, when it was added less
+// than the TTL of the cache ago. The copy is not locked in memory, callers
+// should clear it once it is not needed anymore.
+func (c *Cache) Get(volumeID string) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.entries[volumeID]
+	if !ok {
+		return nil, false
+	}
+
+	if c.now().After(e.expires) {
+		c.remove(volumeID, e)
+
+		return nil, false
+	}
+
+	passphrase := make([]byte, e.length)
+	copy(passphrase, e.page)
+
+	return passphrase, true
+}
+
+// Put adds a copy of the passphrase of the volume to the cache, replacing the
+// one that was cached before. The passphrase is not cached when it can not be
+// locked in memory.
+func (c *Cache) Put(volumeID string, passphrase []byte) error {
+	pageSize := os.Getpagesize()
+	if len(passphrase) > pageSize {
+		return fmt.Errorf("passphrase of volume %q is larger than a page", volumeID)
+	}
+
+	page, err := unix.Mmap(-1, 0, pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return fmt.Errorf("failed to allocate memory for the passphrase of volume %q: %w", volumeID, err)
+	}
+	err = unix.Mlock(page)
+	if err != nil {
+		_ = unix.Munmap(page)
+
+		return fmt.Errorf("failed to lock the passphrase of volume %q in memory: %w", volumeID, err)
+	}
+	copy(page, passphrase)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if old, ok := c.entries[volumeID]; ok {
+		c.remove(volumeID, old)
+	}
+	c.entries[volumeID] = &entry{
+		page:    page,
+		length:  len(passphrase),
+		expires: c.now().Add(c.ttl),
+	}
+
+	return nil
+}
+
+// Remove drops the passphrase of the volume from the cache.
+func (c *Cache) Remove(volumeID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.entries[volumeID]; ok {
+		c.remove(volumeID, e)
+	}
+}
+
+// Expire drops the passphrases that were cached longer than the TTL.
+func (c *Cache) Expire() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	for volumeID, e := range c.entries {
+		if now.After(e.expires) {
+			c.remove(volumeID, e)
+		}
+	}
+}
+
+// Len returns the number of cached passphrases.
+func (c *Cache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return len(c.entries)
+}
+
+// remove clears and releases the page of the entry, c.mtx must be held.
+func (c *Cache) remove(volumeID string, e *entry) {
+	clear(e.page)
+	_ = unix.Munmap(e.page)
+	delete(c.entries, volumeID)
+}
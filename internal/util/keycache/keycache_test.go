@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keycache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	c := New(time.Minute)
+	c.now = func() time.Time { return now }
+
+	err := c.Put("vol-1", []byte("secret"))
+	if err != nil {
+		// RLIMIT_MEMLOCK may not allow locking memory
+		t.Skipf("can not lock memory: %v", err)
+	}
+
+	passphrase, ok := c.Get("vol-1")
+	require.True(t, ok)
+	require.Equal(t, []byte("secret"), passphrase)
+
+	require.NoError(t, c.Put("vol-1", []byte("rotated")))
+	passphrase, ok = c.Get("vol-1")
+	require.True(t, ok)
+	require.Equal(t, []byte("rotated"), passphrase)
+	require.Equal(t, 1, c.Len())
+
+	_, ok = c.Get("vol-2")
+	require.False(t, ok)
+
+	require.NoError(t, c.Put("vol-2", []byte("other")))
+	c.Remove("vol-2")
+	_, ok = c.Get("vol-2")
+	require.False(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = c.Get("vol-1")
+	require.False(t, ok)
+	require.Equal(t, 0, c.Len())
+
+	require.NoError(t, c.Put("vol-3", []byte("expiring")))
+	now = now.Add(2 * time.Minute)
+	c.Expire()
+	require.Equal(t, 0, c.Len())
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LockLevel is the kind of object that a lock of the LockManager protects.
+// Locks of multiple levels are always acquired in the order of the levels,
+// so that concurrent operations can not wait on each other in a cycle.
+type LockLevel int
+
+const (
+	// VolumeGroupLock protects a volume group or a group snapshot.
+	VolumeGroupLock LockLevel = iota
+	// VolumeLock protects a volume.
+	VolumeLock
+	// SnapshotLock protects a snapshot.
+	SnapshotLock
+)
+
+// ErrLockHeld is returned when a lock is held by another operation.
+var ErrLockHeld = errors.New("lock is held by another operation")
+
+var (
+	lockContentionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "csi",
+		Subsystem: "lock",
+		Name:      "contention_total",
+		Help:      "Number of operations that were rejected because the lock of the object was held",
+	}, []string{"level"})
+	lockHoldSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "csi",
+		Subsystem: "lock",
+		Name:      "hold_seconds",
+		Help:      "Time that operations held the lock of an object",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"level"})
+	lockStuckGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "csi",
+		Subsystem: "lock",
+		Name:      "stuck",
+		Help:      "Number of locks that are held for longer than the stuck lock threshold",
+	}, []string{"level"})
+)
+
+// String returns the name of the level, as used in logs and metrics.
+func (l LockLevel) String() string {
+	switch l {
+	case VolumeGroupLock:
+		return "volumegroup"
+	case VolumeLock:
+		return "volume"
+	case SnapshotLock:
+		return "snapshot"
+	}
+
+	return fmt.Sprintf("level-%d", int(l))
+}
+
+// RegisterLockMetrics registers the metrics of the locks with prometheus.
+func RegisterLockMetrics() error {
+	for _, c := range []prometheus.Collector{lockContentionTotal, lockHoldSeconds, lockStuckGauge} {
+		err := prometheus.Register(c)
+		if err != nil {
+			return fmt.Errorf("failed to register lock metrics: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// LockRequest is a lock of the LockManager to acquire.
+type LockRequest struct {
+	Level LockLevel
+	ID    string
+}
+
+// LockManager keeps the VolumeLocks of the volume groups, volumes and
+// snapshots of a server, and acquires locks of multiple levels in a fixed
+// order.
+type LockManager struct {
+	locks map[LockLevel]*VolumeLocks
+}
+
+// NewLockManager returns a LockManager with empty VolumeLocks for every
+// level.
+func NewLockManager() *LockManager {
+	lm := &LockManager{
+		locks: make(map[LockLevel]*VolumeLocks),
+	}
+	for _, level := range []LockLevel{VolumeGroupLock, VolumeLock, SnapshotLock} {
+		lm.locks[level] = newVolumeLocks(level)
+	}
+
+	return lm
+}
+
+// Locks returns the VolumeLocks of the level.
+func (lm *LockManager) Locks(level LockLevel) *VolumeLocks {
+	return lm.locks[level]
+}
+
+// TryAcquireAll acquires all requested locks, ordered by level and ID. When
+// one of the locks is held, the locks that were acquired already are
+// released, and an error wrapping ErrLockHeld is returned. The returned
+// function releases all locks, in the reverse order.
+func (lm *LockManager) TryAcquireAll(reqs ...LockRequest) (func(), error) {
+	ordered := slices.Clone(reqs)
+	slices.SortFunc(ordered, func(a, b LockRequest) int {
+		if a.Level != b.Level {
+			return cmp.Compare(a.Level, b.Level)
+		}
+
+		return cmp.Compare(a.ID, b.ID)
+	})
+	ordered = slices.Compact(ordered)
+
+	acquired := make([]LockRequest, 0, len(ordered))
+	release := func() {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			lm.locks[acquired[i].Level].Release(acquired[i].ID)
+		}
+	}
+
+	for _, req := range ordered {
+		vl, ok := lm.locks[req.Level]
+		if !ok {
+			release()
+
+			return nil, fmt.Errorf("unknown lock level %d for %q", req.Level, req.ID)
+		}
+		if !vl.TryAcquire(req.ID) {
+			release()
+
+			return nil, fmt.Errorf("%w: %s %q", ErrLockHeld, req.Level, req.ID)
+		}
+		acquired = append(acquired, req)
+	}
+
+	return release, nil
+}
+
+// DetectStuckLocks logs a warning every interval for the locks that are held
+// for longer than threshold, and reports their number in a metric, until ctx
+// is cancelled. Operations holding a lock for that long are likely stuck on
+// the Ceph cluster, and block all subsequent operations on the object.
+func (lm *LockManager) DetectStuckLocks(ctx context.Context, threshold, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for level, vl := range lm.locks {
+				held := vl.heldLongerThan(threshold)
+				lockStuckGauge.WithLabelValues(level.String()).Set(float64(len(held)))
+				for id, since := range held {
+					log.WarningLog(ctx, "%s lock of %q is held for %s, the operation may be stuck",
+						level, id, since.Round(time.Second))
+				}
+			}
+		}
+	}
+}
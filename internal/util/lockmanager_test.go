@@ -0,0 +1,65 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockManagerTryAcquireAll(t *testing.T) {
+	t.Parallel()
+
+	lm := NewLockManager()
+	release, err := lm.TryAcquireAll(
+		LockRequest{Level: SnapshotLock, ID: "snap"},
+		LockRequest{Level: VolumeLock, ID: "vol"},
+		LockRequest{Level: VolumeLock, ID: "vol"})
+	require.NoError(t, err)
+	require.False(t, lm.Locks(VolumeLock).TryAcquire("vol"))
+	require.False(t, lm.Locks(SnapshotLock).TryAcquire("snap"))
+
+	// the volume lock is acquired first, so the group lock is released again
+	_, err = lm.TryAcquireAll(
+		LockRequest{Level: VolumeLock, ID: "vol"},
+		LockRequest{Level: VolumeGroupLock, ID: "group"})
+	require.ErrorIs(t, err, ErrLockHeld)
+	require.True(t, lm.Locks(VolumeGroupLock).TryAcquire("group"))
+	lm.Locks(VolumeGroupLock).Release("group")
+
+	release()
+	require.True(t, lm.Locks(VolumeLock).TryAcquire("vol"))
+	require.True(t, lm.Locks(SnapshotLock).TryAcquire("snap"))
+}
+
+func TestVolumeLocksHeldLongerThan(t *testing.T) {
+	t.Parallel()
+
+	vl := newVolumeLocks(VolumeLock)
+	require.True(t, vl.TryAcquire("new"))
+	require.True(t, vl.TryAcquire("old"))
+	vl.locks["old"] = time.Now().Add(-time.Hour)
+
+	held := vl.heldLongerThan(time.Minute)
+	require.Len(t, held, 1)
+	require.Contains(t, held, "old")
+
+	vl.Release("old")
+	require.Empty(t, vl.heldLongerThan(time.Minute))
+}
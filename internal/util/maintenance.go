@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// MaintenanceModeFile is the location of the "maintenance-mode" key of the
+// ceph-csi-config ConfigMap. Setting it to "true" enables maintenance mode for
+// all clusters, a comma separated list of cluster IDs enables it for these
+// clusters only.
+const MaintenanceModeFile = "/etc/ceph-csi-config/maintenance-mode"
+
+// MaintenanceMode is the scope of the maintenance mode.
+type MaintenanceMode struct {
+	all      bool
+	clusters map[string]bool
+}
+
+// Enabled returns true when maintenance mode is enabled for any cluster.
+func (mm MaintenanceMode) Enabled() bool {
+	return mm.all || len(mm.clusters) != 0
+}
+
+// EnabledFor returns true when maintenance mode is enabled for the cluster.
+func (mm MaintenanceMode) EnabledFor(clusterID string) bool {
+	return mm.all || mm.clusters[clusterID]
+}
+
+// GetMaintenanceMode reads the maintenance mode from the file at
+// pathToConfig. The file is read on every call, so that changes to the
+// ConfigMap are picked up without restarting the driver.
+func GetMaintenanceMode(pathToConfig string) MaintenanceMode {
+	// #nosec:G304, file path is not user controlled.
+	content, err := os.ReadFile(pathToConfig)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.WarningLogMsg("failed to read maintenance mode from %q: %v", pathToConfig, err)
+		}
+
+		return MaintenanceMode{}
+	}
+
+	return parseMaintenanceMode(strings.TrimSpace(string(content)))
+}
+
+// parseMaintenanceMode parses a boolean, or a comma separated list of
+// cluster IDs.
+func parseMaintenanceMode(value string) MaintenanceMode {
+	if value == "" {
+		return MaintenanceMode{}
+	}
+
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		return MaintenanceMode{all: enabled}
+	}
+
+	mm := MaintenanceMode{clusters: map[string]bool{}}
+	for _, clusterID := range strings.Split(value, ",") {
+		if clusterID = strings.TrimSpace(clusterID); clusterID != "" {
+			mm.clusters[clusterID] = true
+		}
+	}
+
+	return mm
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMaintenanceMode(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.False(t, GetMaintenanceMode(filepath.Join(dir, "missing")).Enabled())
+
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{content: "true", want: true},
+		{content: "True\n", want: true},
+		{content: "false", want: false},
+		{content: "", want: false},
+		{content: ",", want: false},
+		{content: "cluster-1, cluster-2", want: true},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("maintenance-mode-%d", i))
+		require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+		require.Equal(t, tt.want, GetMaintenanceMode(path).Enabled(), "content %q", tt.content)
+	}
+}
+
+func TestMaintenanceModeEnabledFor(t *testing.T) {
+	t.Parallel()
+
+	mm := parseMaintenanceMode("true")
+	require.True(t, mm.EnabledFor("cluster-1"))
+
+	mm = parseMaintenanceMode("cluster-1, cluster-2")
+	require.True(t, mm.EnabledFor("cluster-1"))
+	require.True(t, mm.EnabledFor("cluster-2"))
+	require.False(t, mm.EnabledFor("cluster-3"))
+	require.False(t, mm.EnabledFor(""))
+
+	mm = parseMaintenanceMode("false")
+	require.False(t, mm.Enabled())
+	require.False(t, mm.EnabledFor("cluster-1"))
+}
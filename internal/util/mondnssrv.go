@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// monDNSSRVRefreshInterval is the time after which the monitors of a DNS SRV
+// name are looked up again. cephadm moves monitors to other hosts, the
+// addresses in the records change with them.
+const monDNSSRVRefreshInterval = time.Minute
+
+// srvMonitors are the monitors that were resolved from the DNS SRV records
+// of a name.
+type srvMonitors struct {
+	monitors string
+	resolved time.Time
+	// checked is the time of the last lookup, that may have failed
+	checked time.Time
+}
+
+var (
+	// lookupSRV resolves DNS SRV records, it is replaced by the unit tests
+	lookupSRV = net.LookupSRV
+
+	srvMonitorsMutex sync.Mutex
+	srvMonitorsCache = map[string]srvMonitors{}
+)
+
+// splitMonDNSSRVName splits the service and the optional domain of the name,
+// the same way as Ceph does for the mon_dns_srv_name option:
+// "ceph-mon_example.com" looks up "_ceph-mon._tcp.example.com", and
+// "ceph-mon" looks up "_ceph-mon._tcp" in the search domains of the resolver.
+func splitMonDNSSRVName(name string) (string, string) {
+	service, domain, _ := strings.Cut(name, "_")
+
+	return service, domain
+}
+
+// resolveMonDNSSRV returns the comma separated, sorted addresses of the
+// targets of the DNS SRV records of the name.
+func resolveMonDNSSRV(name string) (string, error) {
+	service, domain := splitMonDNSSRVName(name)
+	if service == "" {
+		return "", fmt.Errorf("invalid monitor DNS SRV name %q", name)
+	}
+
+	_, records, err := lookupSRV(service, "tcp", domain)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up monitor DNS SRV records of %q: %w", name, err)
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("no monitor DNS SRV records for %q", name)
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	// the resolver shuffles records of the same priority, sorting keeps the
+	// connections that are pooled by their monitors
+	slices.Sort(addrs)
+
+	return strings.Join(slices.Compact(addrs), ","), nil
+}
+
+// monsFromDNSSRV returns the monitors of the DNS SRV name. The resolved
+// monitors are cached for monDNSSRVRefreshInterval, and kept when looking
+// them up again fails, so that a DNS outage does not stop the provisioning.
+func monsFromDNSSRV(name string) (string, error) {
+	srvMonitorsMutex.Lock()
+	defer srvMonitorsMutex.Unlock()
+
+	cached, ok := srvMonitorsCache[name]
+	if ok && time.Since(cached.checked) < monDNSSRVRefreshInterval {
+		return cached.monitors, nil
+	}
+
+	monitors, err := resolveMonDNSSRV(name)
+	if err != nil {
+		if !ok {
+			return "", err
+		}
+		log.WarningLogMsg("%v, using monitors %q that were resolved %s ago",
+			err, cached.monitors, time.Since(cached.resolved).Round(time.Second))
+		cached.checked = time.Now()
+		srvMonitorsCache[name] = cached
+
+		return cached.monitors, nil
+	}
+
+	if ok && cached.monitors != monitors {
+		log.DefaultLog("monitors of DNS SRV name %q changed from %q to %q", name, cached.monitors, monitors)
+	}
+	now := time.Now()
+	srvMonitorsCache[name] = srvMonitors{monitors: monitors, resolved: now, checked: now}
+
+	return monitors, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMonDNSSRVName(t *testing.T) {
+	t.Parallel()
+
+	service, domain := splitMonDNSSRVName("ceph-mon")
+	require.Equal(t, "ceph-mon", service)
+	require.Empty(t, domain)
+
+	service, domain = splitMonDNSSRVName("ceph-mon_example.com")
+	require.Equal(t, "ceph-mon", service)
+	require.Equal(t, "example.com", domain)
+}
+
+//nolint:paralleltest // replaces lookupSRV
+func TestMonsFromDNSSRV(t *testing.T) {
+	var lookups []string
+	records := []*net.SRV{
+		{Target: "mon-b.example.com.", Port: 3300},
+		{Target: "mon-a.example.com.", Port: 3300},
+	}
+	var lookupErr error
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		lookups = append(lookups, "_"+service+"._"+proto+"."+name)
+
+		return "", records, lookupErr
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	const name = "ceph-mon_example.com"
+	monitors, err := monsFromDNSSRV(name)
+	require.NoError(t, err)
+	require.Equal(t, "mon-a.example.com:3300,mon-b.example.com:3300", monitors)
+	require.Equal(t, []string{"_ceph-mon._tcp.example.com"}, lookups)
+
+	// the monitors are cached
+	records = records[:1]
+	monitors, err = monsFromDNSSRV(name)
+	require.NoError(t, err)
+	require.Equal(t, "mon-a.example.com:3300,mon-b.example.com:3300", monitors)
+	require.Len(t, lookups, 1)
+
+	// and looked up again after the refresh interval
+	expire := func() {
+		srvMonitorsMutex.Lock()
+		cached := srvMonitorsCache[name]
+		cached.checked = cached.checked.Add(-monDNSSRVRefreshInterval)
+		srvMonitorsCache[name] = cached
+		srvMonitorsMutex.Unlock()
+	}
+	expire()
+	monitors, err = monsFromDNSSRV(name)
+	require.NoError(t, err)
+	require.Equal(t, "mon-b.example.com:3300", monitors)
+	require.Len(t, lookups, 2)
+
+	// a failed lookup keeps the previous monitors
+	lookupErr = errors.New("no such host")
+	expire()
+	monitors, err = monsFromDNSSRV(name)
+	require.NoError(t, err)
+	require.Equal(t, "mon-b.example.com:3300", monitors)
+	require.Len(t, lookups, 3)
+
+	_, err = monsFromDNSSRV("ceph-mon_unknown.example.com")
+	require.Error(t, err)
+
+	lookupErr = nil
+	records = nil
+	_, err = monsFromDNSSRV("ceph-mon_empty.example.com")
+	require.Error(t, err)
+
+	_, err = monsFromDNSSRV("_example.com")
+	require.Error(t, err)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NodeCapability is the name of a feature of the node, detected once when
+// the nodeplugin starts.
+type NodeCapability string
+
+const (
+	// KrbdFeaturesCapability contains the features supported by krbd, in
+	// the hex format of /sys/bus/rbd/supported_features.
+	KrbdFeaturesCapability NodeCapability = "krbd-features"
+	// NBDCapability is supported when the nbd module and rbd-nbd are
+	// available.
+	NBDCapability NodeCapability = "nbd"
+	// CephFSKernelCapability is supported when the CephFS kernel client
+	// can be used for mounting.
+	CephFSKernelCapability NodeCapability = "cephfs-kernel"
+	// CephFSQuotaCapability is supported when the kernel client enforces
+	// CephFS quotas.
+	CephFSQuotaCapability NodeCapability = "cephfs-quota"
+	// CephFSFuseCapability contains the version of ceph-fuse.
+	CephFSFuseCapability NodeCapability = "cephfs-fuse"
+	// CryptsetupCapability contains the version of cryptsetup.
+	CryptsetupCapability NodeCapability = "cryptsetup"
+	// IDMappedMountCapability is supported when the kernel and mount(8)
+	// can create ID-mapped bind mounts.
+	IDMappedMountCapability NodeCapability = "idmapped-mounts"
+
+	// nodeCapabilityLabelPrefix is prepended to the driver name to build
+	// the key of the capability labels.
+	nodeCapabilityLabelPrefix = "capability."
+)
+
+type nodeCapability struct {
+	supported bool
+	value     string
+}
+
+// NodeCapabilities is a registry of the features that were detected on the
+// node, so that the driver does not need to probe the kernel and tools again
+// for every request.
+type NodeCapabilities struct {
+	mtx  sync.RWMutex
+	caps map[NodeCapability]nodeCapability
+}
+
+// nodeCapabilities is the registry for this process.
+var nodeCapabilities = NewNodeCapabilities()
+
+// NewNodeCapabilities returns an empty NodeCapabilities registry.
+func NewNodeCapabilities() *NodeCapabilities {
+	return &NodeCapabilities{
+		caps: make(map[NodeCapability]nodeCapability),
+	}
+}
+
+// GetNodeCapabilities returns the NodeCapabilities registry that is populated
+// by the drivers on startup.
+func GetNodeCapabilities() *NodeCapabilities {
+	return nodeCapabilities
+}
+
+// Set records the result of a probe. The value is optional, and is used to
+// expose details like the version of a tool.
+func (nc *NodeCapabilities) Set(c NodeCapability, supported bool, value string) {
+	nc.mtx.Lock()
+	defer nc.mtx.Unlock()
+
+	nc.caps[c] = nodeCapability{supported: supported, value: value}
+	log.DefaultLog("node capability %q: supported=%t value=%q", c, supported, value)
+}
+
+// Get returns the detected value of the capability, whether it is supported,
+// and whether it has been probed at all.
+func (nc *NodeCapabilities) Get(c NodeCapability) (string, bool, bool) {
+	nc.mtx.RLock()
+	defer nc.mtx.RUnlock()
+
+	nCap, ok := nc.caps[c]
+
+	return nCap.value, nCap.supported, ok
+}
+
+// CheckSupported returns ErrNodeCapabilityUnsupported when the capability was
+// probed and found to be missing. Capabilities that have not been probed are
+// considered supported, the operation that needs them reports the failure.
+func (nc *NodeCapabilities) CheckSupported(c NodeCapability) error {
+	_, supported, probed := nc.Get(c)
+	if probed && !supported {
+		return fmt.Errorf("%w: %s", ErrNodeCapabilityUnsupported, c)
+	}
+
+	return nil
+}
+
+// labelPrefix returns the prefix of the keys of the capability labels of the
+// driver, "capability.<driverName>/".
+func labelPrefix(driverName string) string {
+	return strings.ToLower(nodeCapabilityLabelPrefix+driverName) + string(keySeparator)
+}
+
+// Labels returns the capabilities in the form of labels of the Node, keyed by
+// "capability.<driverName>/<capability>". When no detailed value was recorded
+// for a capability, or the value is not valid for a label, "true" or "false"
+// is used.
+func (nc *NodeCapabilities) Labels(driverName string) map[string]string {
+	nc.mtx.RLock()
+	defer nc.mtx.RUnlock()
+
+	prefix := labelPrefix(driverName)
+	labels := make(map[string]string, len(nc.caps))
+	for c, nCap := range nc.caps {
+		value := nCap.value
+		if value == "" || !nCap.supported || len(validation.IsValidLabelValue(value)) != 0 {
+			value = strconv.FormatBool(nCap.supported)
+		}
+		labels[prefix+string(c)] = value
+	}
+
+	return labels
+}
+
+// ApplyNodeCapabilityLabels sets the labels of the detected node capabilities
+// on the Node, so that workloads can select the nodes with a nodeAffinity. The
+// capability labels of the driver that were not detected are removed. The
+// capabilities are not reported in the topology of NodeGetInfo, volumes must
+// not be constrained to nodes with the same capabilities.
+func ApplyNodeCapabilityLabels(ctx context.Context, nodeName, driverName string) error {
+	client, err := k8s.NewK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+
+	return k8s.ReplaceNodeLabels(ctx, client, nodeName, labelPrefix(driverName), nodeCapabilities.Labels(driverName))
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeCapabilities(t *testing.T) {
+	t.Parallel()
+
+	nc := NewNodeCapabilities()
+
+	// capabilities that were not probed are not reported as unsupported
+	require.NoError(t, nc.CheckSupported(NBDCapability))
+
+	nc.Set(NBDCapability, false, "")
+	nc.Set(CryptsetupCapability, true, "2.6.1")
+	nc.Set(CephFSQuotaCapability, true, "")
+	// not a valid label value
+	nc.Set(CephFSFuseCapability, true, "17.2.6 (quincy)")
+
+	require.ErrorIs(t, nc.CheckSupported(NBDCapability), ErrNodeCapabilityUnsupported)
+	require.NoError(t, nc.CheckSupported(CryptsetupCapability))
+
+	value, supported, probed := nc.Get(CryptsetupCapability)
+	require.True(t, probed)
+	require.True(t, supported)
+	require.Equal(t, "2.6.1", value)
+
+	require.Equal(t, map[string]string{
+		"capability.rbd.csi.ceph.com/nbd":          "false",
+		"capability.rbd.csi.ceph.com/cryptsetup":   "2.6.1",
+		"capability.rbd.csi.ceph.com/cephfs-quota": "true",
+		"capability.rbd.csi.ceph.com/cephfs-fuse":  "true",
+	}, nc.Labels("rbd.csi.ceph.com"))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PoolUsage is the capacity of a pool as reported by `ceph df`. The values
+// are in logical bytes, replication or erasure coding is already accounted
+// for in MaxAvailBytes.
+type PoolUsage struct {
+	Name          string
+	StoredBytes   uint64
+	MaxAvailBytes uint64
+}
+
+// dfOutput is the part of the JSON output of `ceph df` that is used.
+type dfOutput struct {
+	Pools []struct {
+		Name  string `json:"name"`
+		Stats struct {
+			Stored   uint64 `json:"stored"`
+			MaxAvail uint64 `json:"max_avail"`
+		} `json:"stats"`
+	} `json:"pools"`
+}
+
+// GetPoolUsage returns the usage of the pool with the given name.
+func (cc *ClusterConnection) GetPoolUsage(poolName string) (*PoolUsage, error) {
+	if cc.conn == nil {
+		return nil, errors.New("cluster is not connected yet")
+	}
+
+	cmd, err := json.Marshal(map[string]string{
+		"prefix": "df",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal df command: %w", err)
+	}
+
+	done := cc.TrackCall("mon_command")
+	out, status, err := cc.conn.MonCommand(cmd)
+	done(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage of pool %q (%s): %w", poolName, status, err)
+	}
+
+	return parsePoolUsage(out, poolName)
+}
+
+// parsePoolUsage finds the pool in the JSON output of `ceph df`.
+func parsePoolUsage(data []byte, poolName string) (*PoolUsage, error) {
+	var df dfOutput
+	err := json.Unmarshal(data, &df)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse df output: %w", err)
+	}
+
+	for _, p := range df.Pools {
+		if p.Name == poolName {
+			return &PoolUsage{
+				Name:          p.Name,
+				StoredBytes:   p.Stats.Stored,
+				MaxAvailBytes: p.Stats.MaxAvail,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, poolName)
+}
+
+// ProjectedRatio returns the fraction of the pool capacity that is in use
+// once additionalBytes are written.
+func (pu *PoolUsage) ProjectedRatio(additionalBytes uint64) float64 {
+	capacity := pu.StoredBytes + pu.MaxAvailBytes
+	if capacity == 0 {
+		return 1
+	}
+
+	return float64(pu.StoredBytes+additionalBytes) / float64(capacity)
+}
+
+// CheckThreshold returns ErrPoolUsageExceeded when writing additionalBytes
+// to the pool would raise its usage above threshold. A threshold of 0
+// disables the check.
+func (pu *PoolUsage) CheckThreshold(additionalBytes uint64, threshold float64) error {
+	if threshold <= 0 {
+		return nil
+	}
+
+	ratio := pu.ProjectedRatio(additionalBytes)
+	if ratio > threshold {
+		return fmt.Errorf("%w: pool %q would be %.1f%% full, threshold is %.1f%%",
+			ErrPoolUsageExceeded, pu.Name, ratio*100, threshold*100)
+	}
+
+	return nil
+}
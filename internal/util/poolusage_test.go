@@ -0,0 +1,61 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePoolUsage(t *testing.T) {
+	t.Parallel()
+
+	df := []byte(`{
+		"stats": {"total_bytes": 300},
+		"pools": [
+			{"name": "replicapool", "id": 1, "stats": {"stored": 60, "max_avail": 40, "percent_used": 0.6}},
+			{"name": "other", "id": 2, "stats": {"stored": 1, "max_avail": 99}}
+		]
+	}`)
+
+	pu, err := parsePoolUsage(df, "replicapool")
+	require.NoError(t, err)
+	require.Equal(t, &PoolUsage{Name: "replicapool", StoredBytes: 60, MaxAvailBytes: 40}, pu)
+
+	_, err = parsePoolUsage(df, "missing")
+	require.ErrorIs(t, err, ErrPoolNotFound)
+
+	_, err = parsePoolUsage([]byte("not json"), "replicapool")
+	require.Error(t, err)
+}
+
+func TestPoolUsageCheckThreshold(t *testing.T) {
+	t.Parallel()
+
+	pu := &PoolUsage{Name: "replicapool", StoredBytes: 60, MaxAvailBytes: 40}
+	require.InDelta(t, 0.6, pu.ProjectedRatio(0), 0.001)
+	require.InDelta(t, 0.8, pu.ProjectedRatio(20), 0.001)
+
+	require.NoError(t, pu.CheckThreshold(20, 0.8))
+	require.ErrorIs(t, pu.CheckThreshold(21, 0.8), ErrPoolUsageExceeded)
+	// a threshold of 0 disables the check
+	require.NoError(t, pu.CheckThreshold(1000, 0))
+
+	empty := &PoolUsage{Name: "empty"}
+	require.ErrorIs(t, empty.CheckThreshold(0, 0.9), ErrPoolUsageExceeded)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/ceph/go-ceph/rados"
+	"golang.org/x/sys/unix"
+)
+
+// omapChunkSize is the number of omap keys that are requested by one step
+// of a read operation.
+const omapChunkSize = 512
+
+// RadosObjects reads and writes the objects of a pool and their omaps, like
+// the objects of the journal. The errors of the operations are the errnos of
+// go-ceph, like rados.ErrNotFound.
+type RadosObjects interface {
+	// SetNamespace sets the RADOS namespace of the objects.
+	SetNamespace(namespace string)
+	// Destroy releases the resources, it needs to be called once the
+	// objects are not used anymore.
+	Destroy()
+
+	// Create creates the object, it fails with rados.ErrObjectExists when
+	// the object exists.
+	Create(oid string) error
+	// Delete removes the object.
+	Delete(oid string) error
+
+	// GetOmapValuesByKeys returns the values of the keys that are set in
+	// the omap of the object, with a single round trip. It fails with
+	// rados.ErrNotFound when the object does not exist.
+	GetOmapValuesByKeys(oid string, keys []string) (map[string][]byte, error)
+	// ListOmapValues calls listFn for up to maxReturn omap values of the
+	// object that start with filterPrefix, in the order of their keys after
+	// startAfter.
+	ListOmapValues(oid, startAfter, filterPrefix string, maxReturn int64,
+		listFn func(key string, value []byte)) error
+	// SetOmap sets the omap keys of the object.
+	SetOmap(oid string, pairs map[string][]byte) error
+	// RmOmapKeys removes the keys from the omap of the object.
+	RmOmapKeys(oid string, keys []string) error
+	// UpdateOmap sets and removes omap keys of the object in a single
+	// operation, and returns the new version of the object. When version is
+	// not 0, the update is only applied when the object is at that version,
+	// see IsVersionMismatch.
+	UpdateOmap(oid string, version uint64, pairs map[string][]byte, keys []string) (uint64, error)
+	// GetVersion returns the version of the object, every write to the
+	// object increases it.
+	GetVersion(oid string) (uint64, error)
+}
+
+// IsVersionMismatch returns true when the error was caused by a version that
+// was passed to RadosObjects.UpdateOmap, the object is newer (ERANGE) or
+// older (EOVERFLOW) than the version.
+func IsVersionMismatch(err error) bool {
+	var errnoErr interface{ ErrorCode() int }
+	if !errors.As(err, &errnoErr) {
+		return false
+	}
+
+	errno := errnoErr.ErrorCode()
+
+	return errno == -int(unix.ERANGE) || errno == -int(unix.EOVERFLOW)
+}
+
+// ioctxObjects are the RadosObjects of a rados.IOContext.
+type ioctxObjects struct {
+	ioctx *rados.IOContext
+}
+
+var _ RadosObjects = &ioctxObjects{}
+
+func (o *ioctxObjects) SetNamespace(namespace string) {
+	o.ioctx.SetNamespace(namespace)
+}
+
+func (o *ioctxObjects) Destroy() {
+	o.ioctx.Destroy()
+}
+
+func (o *ioctxObjects) Create(oid string) error {
+	return o.ioctx.Create(oid, rados.CreateExclusive)
+}
+
+func (o *ioctxObjects) Delete(oid string) error {
+	return o.ioctx.Delete(oid)
+}
+
+func (o *ioctxObjects) GetOmapValuesByKeys(oid string, keys []string) (map[string][]byte, error) {
+	op := rados.CreateReadOp()
+	defer op.Release()
+
+	// the existence is checked even when no keys are requested
+	op.AssertExists()
+	steps := []*rados.ReadOpOmapGetValsByKeysStep{}
+	for chunk := range slices.Chunk(keys, omapChunkSize) {
+		steps = append(steps, op.GetOmapValuesByKeys(chunk))
+	}
+
+	err := operationError(op.Operate(o.ioctx, oid, rados.OperationNoFlag))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for _, step := range steps {
+		for {
+			kv, nErr := step.Next()
+			if nErr != nil {
+				return nil, nErr
+			}
+			if kv == nil {
+				break
+			}
+			values[kv.Key] = kv.Value
+		}
+	}
+
+	return values, nil
+}
+
+func (o *ioctxObjects) ListOmapValues(
+	oid, startAfter, filterPrefix string,
+	maxReturn int64,
+	listFn func(key string, value []byte),
+) error {
+	return o.ioctx.ListOmapValues(oid, startAfter, filterPrefix, maxReturn, listFn)
+}
+
+func (o *ioctxObjects) SetOmap(oid string, pairs map[string][]byte) error {
+	return o.ioctx.SetOmap(oid, pairs)
+}
+
+func (o *ioctxObjects) RmOmapKeys(oid string, keys []string) error {
+	return o.ioctx.RmOmapKeys(oid, keys)
+}
+
+func (o *ioctxObjects) UpdateOmap(oid string, version uint64, pairs map[string][]byte, keys []string) (uint64, error) {
+	op := rados.CreateWriteOp()
+	defer op.Release()
+
+	if version != 0 {
+		op.AssertVersion(version)
+	}
+	if len(pairs) != 0 {
+		op.SetOmap(pairs)
+	}
+	if len(keys) != 0 {
+		op.RmOmapKeys(keys)
+	}
+
+	err := operationError(op.Operate(o.ioctx, oid, rados.OperationNoFlag))
+	if err != nil {
+		return 0, err
+	}
+
+	return o.ioctx.GetLastVersion()
+}
+
+func (o *ioctxObjects) GetVersion(oid string) (uint64, error) {
+	op := rados.CreateReadOp()
+	defer op.Release()
+
+	op.AssertExists()
+	err := operationError(op.Operate(o.ioctx, oid, rados.OperationNoFlag))
+	if err != nil {
+		return 0, err
+	}
+
+	return o.ioctx.GetLastVersion()
+}
+
+// operationError returns the error of the operation itself when err is a
+// rados.OperationError, it does not support errors.Is() otherwise.
+func operationError(err error) error {
+	var radosOpErr rados.OperationError
+	if errors.As(err, &radosOpErr) && radosOpErr.OpError != nil {
+		return radosOpErr.OpError
+	}
+
+	return err
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ReadAheadKBKey is the parameter of a StorageClass that sets the readahead
+// of the volumes in KiB, it overrides the default of the driver.
+const ReadAheadKBKey = "readAheadKB"
+
+// sysBlockPath is the directory with the queue settings of the block devices,
+// tests point it to a temporary directory.
+var sysBlockPath = "/sys/block"
+
+// GetReadAheadKB returns the readahead in KiB from the ReadAheadKBKey in the
+// volume context, or defaultKB when the key is not set. 0 means that the
+// readahead of the kernel is kept.
+func GetReadAheadKB(volContext map[string]string, defaultKB uint) (uint, error) {
+	value, ok := volContext[ReadAheadKBKey]
+	if !ok || value == "" {
+		return defaultKB, nil
+	}
+
+	kb, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", ReadAheadKBKey, value, err)
+	}
+
+	return uint(kb), nil
+}
+
+// SetDeviceReadAheadKB sets the readahead of the block device at devicePath,
+// symlinks like the /dev/mapper entries of dm-crypt devices are resolved.
+func SetDeviceReadAheadKB(devicePath string, kb uint) error {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve device %q: %w", devicePath, err)
+	}
+
+	queueFile := filepath.Join(sysBlockPath, filepath.Base(device), "queue", "read_ahead_kb")
+	err = os.WriteFile(queueFile, []byte(strconv.FormatUint(uint64(kb), 10)), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to set readahead of device %q: %w", devicePath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetReadAheadKB(t *testing.T) {
+	t.Parallel()
+
+	kb, err := GetReadAheadKB(nil, 128)
+	require.NoError(t, err)
+	require.Equal(t, uint(128), kb)
+
+	kb, err = GetReadAheadKB(map[string]string{ReadAheadKBKey: "4096"}, 128)
+	require.NoError(t, err)
+	require.Equal(t, uint(4096), kb)
+
+	// 0 keeps the readahead of the kernel, overriding the default
+	kb, err = GetReadAheadKB(map[string]string{ReadAheadKBKey: "0"}, 128)
+	require.NoError(t, err)
+	require.Equal(t, uint(0), kb)
+
+	_, err = GetReadAheadKB(map[string]string{ReadAheadKBKey: "-1"}, 128)
+	require.Error(t, err)
+}
+
+//nolint:paralleltest // sysBlockPath is replaced
+func TestSetDeviceReadAheadKB(t *testing.T) {
+	dev := t.TempDir()
+	sysBlock := t.TempDir()
+	orig := sysBlockPath
+	sysBlockPath = sysBlock
+	t.Cleanup(func() { sysBlockPath = orig })
+
+	// /dev/mapper/luks-vol -> /dev/dm-0
+	require.NoError(t, os.WriteFile(filepath.Join(dev, "dm-0"), nil, 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(dev, "dm-0"), filepath.Join(dev, "luks-vol")))
+	queue := filepath.Join(sysBlock, "dm-0", "queue")
+	require.NoError(t, os.MkdirAll(queue, 0o750))
+
+	require.NoError(t, SetDeviceReadAheadKB(filepath.Join(dev, "luks-vol"), 4096))
+	content, err := os.ReadFile(filepath.Join(queue, "read_ahead_kb"))
+	require.NoError(t, err)
+	require.Equal(t, "4096", string(content))
+
+	require.Error(t, SetDeviceReadAheadKB(filepath.Join(dev, "missing"), 4096))
+}
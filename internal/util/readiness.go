@@ -0,0 +1,132 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// monDialTimeout limits the time to check if a monitor accepts connections.
+const monDialTimeout = 5 * time.Second
+
+// ProbeCluster verifies that the Ceph cluster with the clusterID can be used
+// with the credentials in secrets. At least one of the monitors needs to
+// accept connections, and the credentials need to be accepted by the cluster.
+func ProbeCluster(ctx context.Context, clusterID string, secrets map[string]string) error {
+	monitors, err := Mons(CsiConfigFile, clusterID)
+	if err != nil {
+		return err
+	}
+
+	err = dialMonitors(ctx, monitors)
+	if err != nil {
+		return err
+	}
+
+	cr, err := NewAdminCredentials(secrets)
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+
+	cc := &ClusterConnection{}
+	err = cc.Connect(monitors, cr)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate as %q: %w", cr.ID, err)
+	}
+	defer cc.Destroy()
+
+	// a cached connection from the pool is not authenticated again, a
+	// request to the monitors makes sure it is still usable
+	conn, err := cc.radosConn()
+	if err != nil {
+		return err
+	}
+	_, err = conn.GetClusterStats()
+	if err != nil {
+		return fmt.Errorf("failed to get the stats of the cluster: %w", err)
+	}
+
+	return nil
+}
+
+// ProbeMonitors verifies that at least one of the monitors of the Ceph
+// cluster with the clusterID accepts connections.
+func ProbeMonitors(ctx context.Context, clusterID string) error {
+	monitors, err := Mons(CsiConfigFile, clusterID)
+	if err != nil {
+		return err
+	}
+
+	return dialMonitors(ctx, monitors)
+}
+
+// dialMonitors returns an error when none of the comma separated monitors
+// accepts a TCP connection.
+func dialMonitors(ctx context.Context, monitors string) error {
+	dialer := &net.Dialer{Timeout: monDialTimeout}
+
+	var errs []error
+	for _, mon := range strings.Split(monitors, ",") {
+		addr := monitorAddress(mon)
+		if addr == "" {
+			continue
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.Close()
+
+			return nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return fmt.Errorf("none of the monitors %q is reachable: %w", monitors, errors.Join(errs...))
+}
+
+// monitorAddress returns the host:port of a monitor address like
+// "10.0.0.1:6789", "v2:10.0.0.1:3300" or "mon.example.com". Addresses
+// without a port use the default msgr v1 port.
+func monitorAddress(mon string) string {
+	mon = strings.TrimSpace(mon)
+	// the address vector of a monitor, "[v2:...,v1:...]", is split by the
+	// comma already
+	if strings.HasPrefix(mon, "[v") {
+		mon = mon[1:]
+	}
+	if strings.HasPrefix(mon, "v1:") || strings.HasPrefix(mon, "v2:") {
+		mon = strings.TrimSuffix(mon[3:], "]")
+	}
+	// the nonce of an entity address is not part of the host:port
+	mon, _, _ = strings.Cut(mon, "/")
+	if mon == "" {
+		return ""
+	}
+
+	if _, _, err := net.SplitHostPort(mon); err != nil {
+		return net.JoinHostPort(strings.Trim(mon, "[]"), "6789")
+	}
+
+	return mon
+}
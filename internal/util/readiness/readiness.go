@@ -0,0 +1,480 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package readiness checks the connectivity to the Ceph clusters that are
+// used by the StorageClasses of a driver, and reports it on an HTTP endpoint
+// that can be used as readinessProbe. The checks can run once at the start
+// of the driver, to catch misconfigured clusters and pools early.
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Path is the HTTP path of the readiness endpoint.
+	Path = "/readyz"
+
+	// probeTimeout limits the time a single cluster may take to respond.
+	probeTimeout = 30 * time.Second
+
+	secretNameKey      = "csi.storage.k8s.io/provisioner-secret-name"
+	secretNamespaceKey = "csi.storage.k8s.io/provisioner-secret-namespace"
+)
+
+// Prober verifies that the cluster of the Target is reachable and accepts the
+// credentials in secrets, and that the pool of the Target can be used. A
+// Target without a secret is only checked for reachable monitors, secrets is
+// nil in that case.
+type Prober func(ctx context.Context, t Target, secrets map[string]string) error
+
+// SecretGetter returns the contents of a Secret.
+type SecretGetter func(ctx context.Context, namespace, name string) (map[string]string, error)
+
+// Dependency is a service other than the Ceph clusters that the driver needs,
+// like a KMS, which is checked separately.
+type Dependency interface {
+	// Name is the key of the Report in the readiness endpoint.
+	Name() string
+	// Ready returns true when the service can be used.
+	Ready() bool
+	// Report returns the details of the last check, it is encoded as JSON.
+	Report() any
+}
+
+// Target is a cluster with the credentials and the pool that are used to
+// provision volumes on it.
+type Target struct {
+	ClusterID       string `json:"clusterID"`
+	SecretName      string `json:"secretName,omitempty"`
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+	Pool            string `json:"pool,omitempty"`
+	JournalPool     string `json:"journalPool,omitempty"`
+}
+
+func (t Target) String() string {
+	s := t.ClusterID
+	if t.SecretName != "" {
+		s += fmt.Sprintf(" (%s/%s)", t.SecretNamespace, t.SecretName)
+	}
+	if t.Pool != "" {
+		s += " pool " + t.Pool
+	}
+
+	return s
+}
+
+// Options configure the checks of a Checker.
+type Options struct {
+	// DriverName is the provisioner of the StorageClasses to check.
+	DriverName string
+	// Interval is the time between the checks, the clusters are checked
+	// once when it is 0.
+	Interval time.Duration
+	// Selector is a label selector for the StorageClasses to check, all
+	// StorageClasses of the driver are checked when it is empty.
+	Selector string
+	// PoolParameter is the StorageClass parameter with the pool (or the
+	// filesystem) of the Target.
+	PoolParameter string
+	// ClusterIDs returns the configured clusterIDs, the monitors of the
+	// ones that are not used by a StorageClass are checked too.
+	ClusterIDs func() ([]string, error)
+	// Probe checks a Target.
+	Probe Prober
+	// Dependencies are reported by the readiness endpoint next to the
+	// clusters, the Checker is only ready when all of them are ready.
+	Dependencies []Dependency
+}
+
+// Status is the result of the last check of a Target.
+type Status struct {
+	Target
+	Ready       bool      `json:"ready"`
+	Error       string    `json:"error,omitempty"`
+	LastChecked time.Time `json:"lastChecked"`
+}
+
+// Checker probes all Targets at an interval, and keeps the results.
+type Checker struct {
+	opts Options
+
+	listTargets func(ctx context.Context) ([]Target, error)
+	getSecret   SecretGetter
+
+	mu       sync.RWMutex
+	checked  bool
+	statuses []Status
+
+	ready *prometheus.GaugeVec
+}
+
+// NewChecker returns a Checker that uses the Prober of the Options to check
+// the clusters, the readiness of every cluster is published as metric too.
+func NewChecker(opts Options) (*Checker, error) {
+	c := newChecker(opts)
+
+	err := prometheus.Register(c.ready)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register readiness metrics: %w", err)
+	}
+
+	return c, nil
+}
+
+func newChecker(opts Options) *Checker {
+	return &Checker{
+		opts: opts,
+		ready: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "csi",
+			Name:        "cluster_ready",
+			Help:        "Whether the Ceph cluster can be used with the credentials of the provisioner (1) or not (0).",
+			ConstLabels: prometheus.Labels{"driver_name": opts.DriverName},
+		}, []string{"cluster_id", "secret", "pool"}),
+	}
+}
+
+// WithClient uses the StorageClasses of the driver in Kubernetes to find the
+// Targets, and reads their credentials from the Secrets.
+func (c *Checker) WithClient(client *k8s.Clientset) *Checker {
+	c.listTargets = func(ctx context.Context) ([]Target, error) {
+		return listStorageClassTargets(ctx, client, c.opts)
+	}
+	c.getSecret = func(ctx context.Context, namespace, name string) (map[string]string, error) {
+		secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		secrets := make(map[string]string, len(secret.Data))
+		for k, v := range secret.Data {
+			secrets[k] = string(v)
+		}
+
+		return secrets, nil
+	}
+
+	return c
+}
+
+// Run checks the Targets until the context is cancelled. Without an interval
+// the Targets are checked once.
+func (c *Checker) Run(ctx context.Context) {
+	if c.opts.Interval == 0 {
+		c.check(ctx)
+
+		return
+	}
+
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) check(ctx context.Context) {
+	targets, err := c.listTargets(ctx)
+	if err != nil {
+		// keep the previous results, Kubernetes may be unavailable for a
+		// moment only
+		log.ErrorLog(ctx, "failed to list clusters to check readiness: %v", err)
+
+		return
+	}
+	targets = c.addUnusedClusters(ctx, targets)
+
+	statuses := make([]Status, 0, len(targets))
+	failed := 0
+	for _, t := range targets {
+		s := c.checkTarget(ctx, t)
+		if !s.Ready {
+			failed++
+		}
+		statuses = append(statuses, s)
+	}
+
+	c.ready.Reset()
+	for _, s := range statuses {
+		value := 0.0
+		if s.Ready {
+			value = 1
+		}
+		secret := ""
+		if s.SecretName != "" {
+			secret = s.SecretNamespace + "/" + s.SecretName
+		}
+		c.ready.WithLabelValues(s.ClusterID, secret, s.Pool).Set(value)
+	}
+
+	c.mu.Lock()
+	first := !c.checked
+	c.checked = true
+	c.statuses = statuses
+	c.mu.Unlock()
+
+	if first {
+		log.DefaultLog("checked %d clusters and pools for driver %s, %d are not ready",
+			len(statuses), c.opts.DriverName, failed)
+	}
+}
+
+// addUnusedClusters adds a Target without secret for every configured
+// clusterID that is not used by any of the targets.
+func (c *Checker) addUnusedClusters(ctx context.Context, targets []Target) []Target {
+	if c.opts.ClusterIDs == nil {
+		return targets
+	}
+
+	clusterIDs, err := c.opts.ClusterIDs()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to read the configured clusters to check readiness: %v", err)
+
+		return targets
+	}
+
+	for _, clusterID := range clusterIDs {
+		used := slices.ContainsFunc(targets, func(t Target) bool {
+			return t.ClusterID == clusterID
+		})
+		if !used {
+			targets = append(targets, Target{ClusterID: clusterID})
+		}
+	}
+
+	return targets
+}
+
+func (c *Checker) checkTarget(ctx context.Context, t Target) Status {
+	s := Status{Target: t, LastChecked: time.Now().UTC()}
+
+	var secrets map[string]string
+	if t.SecretName != "" {
+		var err error
+		secrets, err = c.getSecret(ctx, t.SecretNamespace, t.SecretName)
+		if err != nil {
+			s.Error = fmt.Sprintf("failed to get secret: %v", err)
+			log.WarningLog(ctx, "cluster %s is not ready: %s", t, s.Error)
+
+			return s
+		}
+	}
+
+	err := c.probeWithTimeout(ctx, t, secrets)
+	if err != nil {
+		s.Error = err.Error()
+		log.WarningLog(ctx, "cluster %s is not ready: %s", t, s.Error)
+
+		return s
+	}
+
+	s.Ready = true
+
+	return s
+}
+
+// probeWithTimeout returns an error when the probe does not finish in time.
+// Connecting to a cluster can not always be cancelled, the probe continues in
+// the background in that case.
+func (c *Checker) probeWithTimeout(ctx context.Context, t Target, secrets map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.opts.Probe(ctx, t, secrets)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("cluster did not respond within %s", probeTimeout)
+	}
+}
+
+// Ready returns true when all Targets passed the last check, and all
+// Dependencies are ready. The Checker is not ready before the first check
+// completed.
+func (c *Checker) Ready() bool {
+	return c.clustersReady() && !slices.ContainsFunc(c.opts.Dependencies, func(d Dependency) bool {
+		return !d.Ready()
+	})
+}
+
+func (c *Checker) clustersReady() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.checked {
+		return false
+	}
+
+	return !slices.ContainsFunc(c.statuses, func(s Status) bool {
+		return !s.Ready
+	})
+}
+
+// Statuses returns the Status of all Targets of the last check.
+func (c *Checker) Statuses() []Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.statuses)
+}
+
+// ServeHTTP responds with the Status of all Targets and the Reports of the
+// Dependencies, the response code is 503 when any of them is not ready.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deps := append([]Dependency{clusters{c}}, c.opts.Dependencies...)
+	Handler(deps...).ServeHTTP(w, r)
+}
+
+// clusters reports the Targets of a Checker as Dependency.
+type clusters struct {
+	c *Checker
+}
+
+func (cl clusters) Name() string {
+	return "clusters"
+}
+
+func (cl clusters) Ready() bool {
+	return cl.c.clustersReady()
+}
+
+func (cl clusters) Report() any {
+	return cl.c.Statuses()
+}
+
+// handler serves the Reports of Dependencies.
+type handler []Dependency
+
+// Handler returns a readiness endpoint for the Dependencies, without checks
+// of the clusters. It responds with the Reports keyed by the Names of the
+// Dependencies, the response code is 503 when any of them is not ready.
+func Handler(deps ...Dependency) http.Handler {
+	return handler(deps)
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	code := http.StatusOK
+	reports := make(map[string]any, len(h))
+	for _, d := range h {
+		if !d.Ready() {
+			code = http.StatusServiceUnavailable
+		}
+		reports[d.Name()] = d.Report()
+	}
+
+	body, err := json.Marshal(reports)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	//nolint:errcheck // the client went away, nothing can be done about it
+	w.Write(body)
+}
+
+// listStorageClassTargets returns the clusters, provisioner secrets and
+// pools of the selected StorageClasses of the driver, sorted and without
+// duplicates.
+func listStorageClassTargets(ctx context.Context, client *k8s.Clientset, opts Options) ([]Target, error) {
+	scs, err := client.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{LabelSelector: opts.Selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StorageClasses: %w", err)
+	}
+
+	targets := []Target{}
+	for i := range scs.Items {
+		sc := &scs.Items[i]
+		if sc.Provisioner != opts.DriverName {
+			continue
+		}
+
+		t := Target{
+			ClusterID:       sc.Parameters["clusterID"],
+			SecretName:      sc.Parameters[secretNameKey],
+			SecretNamespace: sc.Parameters[secretNamespaceKey],
+			JournalPool:     sc.Parameters["journalPool"],
+		}
+		if opts.PoolParameter != "" {
+			t.Pool = sc.Parameters[opts.PoolParameter]
+		}
+		if t.ClusterID == "" || t.SecretName == "" {
+			log.WarningLog(ctx, "skipping StorageClass %q for readiness checks, it has no clusterID or secret",
+				sc.Name)
+
+			continue
+		}
+
+		if !slices.Contains(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+
+	slices.SortFunc(targets, func(a, b Target) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	return targets, nil
+}
+
+// Start checks the clusters of the driver in the background, and registers
+// the readiness endpoint on the default HTTP mux. The HTTP server needs to be
+// started separately.
+func Start(opts Options) (*Checker, error) {
+	c, err := NewChecker(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+	c.WithClient(client)
+
+	http.Handle(Path, c)
+	go c.Run(context.Background())
+
+	return c, nil
+}
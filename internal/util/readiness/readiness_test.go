@@ -0,0 +1,183 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	t.Parallel()
+
+	targets := []Target{
+		{ClusterID: "cluster-1", SecretName: "csi-provisioner", SecretNamespace: "ceph-csi"},
+		{ClusterID: "cluster-2", SecretName: "wrong-key", SecretNamespace: "ceph-csi"},
+	}
+	c := newChecker(Options{
+		DriverName: "rbd.csi.ceph.com",
+		Interval:   time.Minute,
+		Probe: func(_ context.Context, _ Target, secrets map[string]string) error {
+			if secrets["userKey"] != "valid" {
+				return errors.New("permission denied")
+			}
+
+			return nil
+		},
+	})
+	c.listTargets = func(context.Context) ([]Target, error) {
+		return targets, nil
+	}
+	keys := map[string]string{"csi-provisioner": "valid", "wrong-key": "invalid"}
+	c.getSecret = func(_ context.Context, _, name string) (map[string]string, error) {
+		return map[string]string{"userID": "csi", "userKey": keys[name]}, nil
+	}
+
+	// not ready before the first check
+	require.False(t, c.Ready())
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	c.check(context.Background())
+	require.False(t, c.Ready())
+
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var body map[string][]Status
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body["clusters"], 2)
+	require.True(t, body["clusters"][0].Ready)
+	require.False(t, body["clusters"][1].Ready)
+	require.Equal(t, "permission denied", body["clusters"][1].Error)
+
+	keys["wrong-key"] = "valid"
+	c.check(context.Background())
+	require.True(t, c.Ready())
+
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// a failure to list the targets keeps the previous results
+	c.listTargets = func(context.Context) ([]Target, error) {
+		return nil, errors.New("apiserver unavailable")
+	}
+	c.check(context.Background())
+	require.True(t, c.Ready())
+}
+
+func TestCheckerUnusedClusters(t *testing.T) {
+	t.Parallel()
+
+	probed := []Target{}
+	c := newChecker(Options{
+		DriverName: "rbd.csi.ceph.com",
+		ClusterIDs: func() ([]string, error) {
+			return []string{"cluster-1", "cluster-2"}, nil
+		},
+		Probe: func(_ context.Context, target Target, secrets map[string]string) error {
+			probed = append(probed, target)
+			if target.SecretName == "" && secrets != nil {
+				return errors.New("secrets for a target without secret")
+			}
+			if target.ClusterID == "cluster-2" {
+				return errors.New("no monitor is reachable")
+			}
+
+			return nil
+		},
+	})
+	target := Target{ClusterID: "cluster-1", SecretName: "csi", SecretNamespace: "ceph-csi", Pool: "replicapool"}
+	c.listTargets = func(context.Context) ([]Target, error) {
+		return []Target{target}, nil
+	}
+	c.getSecret = func(context.Context, string, string) (map[string]string, error) {
+		return map[string]string{"userID": "csi", "userKey": "key"}, nil
+	}
+
+	// without an interval the targets are checked once
+	c.Run(context.Background())
+	require.Equal(t, []Target{target, {ClusterID: "cluster-2"}}, probed)
+	require.False(t, c.Ready())
+	require.Equal(t, "cluster-1 (ceph-csi/csi) pool replicapool", target.String())
+}
+
+type fakeDependency struct {
+	ready bool
+}
+
+func (d *fakeDependency) Name() string {
+	return "kms"
+}
+
+func (d *fakeDependency) Ready() bool {
+	return d.ready
+}
+
+func (d *fakeDependency) Report() any {
+	return map[string]bool{"ready": d.ready}
+}
+
+func TestCheckerDependencies(t *testing.T) {
+	t.Parallel()
+
+	dep := &fakeDependency{}
+	c := newChecker(Options{
+		DriverName:   "rbd.csi.ceph.com",
+		Interval:     time.Minute,
+		Probe:        func(context.Context, Target, map[string]string) error { return nil },
+		Dependencies: []Dependency{dep},
+	})
+	c.listTargets = func(context.Context) ([]Target, error) {
+		return []Target{{ClusterID: "cluster-1"}}, nil
+	}
+
+	c.check(context.Background())
+	require.False(t, c.Ready())
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var body struct {
+		Clusters []Status       `json:"clusters"`
+		KMS      map[string]any `json:"kms"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Clusters, 1)
+	require.True(t, body.Clusters[0].Ready)
+	require.Equal(t, map[string]any{"ready": false}, body.KMS)
+
+	dep.ready = true
+	require.True(t, c.Ready())
+
+	// the dependencies can be served without checking the clusters
+	rec = httptest.NewRecorder()
+	Handler(dep).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"kms": {"ready": true}}`, rec.Body.String())
+}
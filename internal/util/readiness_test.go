@@ -0,0 +1,41 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitorAddress(t *testing.T) {
+	t.Parallel()
+
+	for mon, expected := range map[string]string{
+		"10.0.0.1:6789":      "10.0.0.1:6789",
+		" 10.0.0.1:6789 ":    "10.0.0.1:6789",
+		"[v2:10.0.0.1:3300":  "10.0.0.1:3300",
+		"v1:10.0.0.1:6789]":  "10.0.0.1:6789",
+		"v2:10.0.0.1:3300/0": "10.0.0.1:3300",
+		"mon.example.com":    "mon.example.com:6789",
+		"[fd00::1]:3300":     "[fd00::1]:3300",
+		"fd00::1":            "[fd00::1]:6789",
+		"":                   "",
+	} {
+		require.Equal(t, expected, monitorAddress(mon), mon)
+	}
+}
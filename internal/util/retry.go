@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy describes how often, and how long, Retry calls a function that
+// fails with a retryable error. The delay between two attempts starts at
+// InitialInterval and is multiplied by Multiplier after every attempt.
+type RetryPolicy struct {
+	// InitialInterval is the delay after the first attempt.
+	InitialInterval time.Duration
+	// Multiplier is applied to the delay after every attempt, values
+	// below 1 keep the delay constant.
+	Multiplier float64
+	// Jitter adds a random delay of up to Jitter times the delay, so that
+	// concurrent callers do not retry at the same moment.
+	Jitter float64
+	// MaxInterval caps the delay between two attempts, 0 for no limit.
+	MaxInterval time.Duration
+	// MaxElapsedTime stops the retries when the next attempt would start
+	// after this time since the first attempt, 0 for no limit.
+	MaxElapsedTime time.Duration
+	// MaxAttempts is the number of times the function is called at most,
+	// including the first attempt, 0 for no limit.
+	MaxAttempts int
+	// Retryable returns true when the function should be called again
+	// after it returned the error. When unset, all errors are retried.
+	Retryable func(err error) bool
+}
+
+// RetryOn returns a Retryable function for a RetryPolicy that retries when
+// the error matches one of the targets.
+func RetryOn(targets ...error) func(error) bool {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Retry calls fn until it succeeds, returns an error that is not retryable,
+// or the limits of the policy are reached. The last error of fn is returned
+// as is when the attempts or elapsed time are exhausted, so that the caller
+// can check it like the error of a single attempt. When the context is
+// cancelled while waiting for the next attempt, the returned error wraps
+// both the context error and the last error of fn.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		delay := policy.delay(attempt, rand.Float64())
+		if policy.MaxElapsedTime > 0 && time.Since(start)+delay > policy.MaxElapsedTime {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("%w after %d attempts: %w", ctx.Err(), attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the time to wait after the attempt, jitter is a random
+// value in [0, 1).
+func (policy *RetryPolicy) delay(attempt int, jitter float64) time.Duration {
+	delay := float64(policy.InitialInterval)
+	if policy.Multiplier > 1 {
+		delay *= math.Pow(policy.Multiplier, float64(attempt-1))
+	}
+	if policy.MaxInterval > 0 && delay > float64(policy.MaxInterval) {
+		delay = float64(policy.MaxInterval)
+	}
+	if policy.Jitter > 0 {
+		delay += delay * policy.Jitter * jitter
+	}
+
+	// the multiplier can overflow the delay after many attempts
+	if delay >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(delay)
+}
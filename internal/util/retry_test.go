@@ -0,0 +1,144 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errTestBusy   = errors.New("busy")
+	errTestBroken = errors.New("broken")
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	t.Parallel()
+
+	policy := RetryPolicy{
+		InitialInterval: time.Second,
+		Multiplier:      2,
+		MaxInterval:     5 * time.Second,
+	}
+	require.Equal(t, time.Second, policy.delay(1, 0))
+	require.Equal(t, 2*time.Second, policy.delay(2, 0))
+	require.Equal(t, 4*time.Second, policy.delay(3, 0))
+	require.Equal(t, 5*time.Second, policy.delay(4, 0))
+	require.Equal(t, 5*time.Second, policy.delay(1000, 0))
+
+	// a multiplier below 1 keeps the delay constant
+	policy = RetryPolicy{InitialInterval: time.Second}
+	require.Equal(t, time.Second, policy.delay(10, 0))
+
+	policy = RetryPolicy{InitialInterval: time.Second, Jitter: 0.5}
+	require.Equal(t, time.Second, policy.delay(1, 0))
+	require.Equal(t, 1250*time.Millisecond, policy.delay(1, 0.5))
+
+	// without a limit the delay does not overflow
+	policy = RetryPolicy{InitialInterval: time.Second, Multiplier: 10}
+	require.Equal(t, time.Duration(1<<63-1), policy.delay(100, 0))
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	policy := RetryPolicy{
+		InitialInterval: time.Millisecond,
+		Multiplier:      2,
+		MaxAttempts:     5,
+		Retryable:       RetryOn(errTestBusy),
+	}
+
+	t.Run("succeeds after retries", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+		err := Retry(ctx, policy, func(context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return errTestBusy
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+	})
+
+	t.Run("returns the last error when exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+		err := Retry(ctx, policy, func(context.Context) error {
+			attempts++
+
+			return errTestBusy
+		})
+		require.ErrorIs(t, err, errTestBusy)
+		require.Equal(t, 5, attempts)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		t.Parallel()
+
+		attempts := 0
+		err := Retry(ctx, policy, func(context.Context) error {
+			attempts++
+
+			return errTestBroken
+		})
+		require.ErrorIs(t, err, errTestBroken)
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops after the max elapsed time", func(t *testing.T) {
+		t.Parallel()
+
+		p := RetryPolicy{
+			InitialInterval: 10 * time.Millisecond,
+			MaxElapsedTime:  35 * time.Millisecond,
+		}
+		attempts := 0
+		err := Retry(ctx, p, func(context.Context) error {
+			attempts++
+
+			return errTestBusy
+		})
+		require.ErrorIs(t, err, errTestBusy)
+		require.GreaterOrEqual(t, attempts, 2)
+		require.LessOrEqual(t, attempts, 4)
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		cctx, cancel := context.WithCancel(ctx)
+		p := RetryPolicy{InitialInterval: time.Hour}
+		err := Retry(cctx, p, func(context.Context) error {
+			cancel()
+
+			return errTestBusy
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.ErrorIs(t, err, errTestBusy)
+	})
+}
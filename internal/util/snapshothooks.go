@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ceph/ceph-csi/api/deploy/kubernetes"
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+const (
+	// SnapshotHookPre is the event of the call before the snapshot is taken.
+	SnapshotHookPre = "pre-snapshot"
+	// SnapshotHookPost is the event of the call after the snapshot is taken,
+	// or failed to be taken.
+	SnapshotHookPost = "post-snapshot"
+
+	snapshotHookFailurePolicyFail   = "Fail"
+	snapshotHookFailurePolicyIgnore = "Ignore"
+
+	defaultSnapshotHookTimeout = 30 * time.Second
+)
+
+// ErrSnapshotHookFailed is returned when the webhook failed before the
+// snapshot was taken, and the failure policy aborts the snapshot.
+var ErrSnapshotHookFailed = errors.New("snapshot hook failed")
+
+// SnapshotHookRequest is the JSON body that is posted to the webhook.
+type SnapshotHookRequest struct {
+	// Event is SnapshotHookPre or SnapshotHookPost.
+	Event string `json:"event"`
+	// ClusterID of the volumes.
+	ClusterID string `json:"clusterID"`
+	// Name is the request name of the snapshot or the group snapshot.
+	Name string `json:"name"`
+	// SourceVolumeIDs are the volumes that are snapshotted together.
+	SourceVolumeIDs []string `json:"sourceVolumeIDs"`
+	// Parameters of the request, they contain the name and namespace of
+	// the VolumeSnapshot when the provisioner adds them.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Error is set on the SnapshotHookPost event when the snapshot failed.
+	Error string `json:"error,omitempty"`
+}
+
+// SnapshotHook calls the webhook of a cluster before and after snapshots are
+// taken, so that the applications can be quiesced.
+type SnapshotHook struct {
+	url           string
+	timeout       time.Duration
+	ignoreFailure bool
+	client        *http.Client
+}
+
+// NewSnapshotHook returns the SnapshotHook of the cluster from the csi config,
+// or nil when no webhook is configured.
+func NewSnapshotHook(pathToConfig, clusterID string) (*SnapshotHook, error) {
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSnapshotHook(&cluster.SnapshotHooks)
+}
+
+func newSnapshotHook(cfg *kubernetes.SnapshotHooks) (*SnapshotHook, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+
+	hook := &SnapshotHook{
+		url:     cfg.URL,
+		timeout: defaultSnapshotHookTimeout,
+		client:  &http.Client{},
+	}
+
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q of snapshot hook: %w", cfg.Timeout, err)
+		}
+		hook.timeout = timeout
+	}
+
+	switch cfg.FailurePolicy {
+	case "", snapshotHookFailurePolicyFail:
+	case snapshotHookFailurePolicyIgnore:
+		hook.ignoreFailure = true
+	default:
+		return nil, fmt.Errorf("invalid failure policy %q of snapshot hook, expected %q or %q",
+			cfg.FailurePolicy, snapshotHookFailurePolicyFail, snapshotHookFailurePolicyIgnore)
+	}
+
+	return hook, nil
+}
+
+// Run calls the webhook with the SnapshotHookPre event, runs snapshot, and
+// calls the webhook with the SnapshotHookPost event. A failure of the
+// SnapshotHookPre call returns ErrSnapshotHookFailed without running snapshot,
+// unless the failure policy ignores it. Once the SnapshotHookPre call was made,
+// the SnapshotHookPost call is always made, also when snapshot failed, so that
+// the applications resume. Its failure is logged only, the snapshot is taken
+// already. A nil SnapshotHook runs snapshot only.
+func (h *SnapshotHook) Run(ctx context.Context, req *SnapshotHookRequest, snapshot func() error) error {
+	if h == nil {
+		return snapshot()
+	}
+
+	pre := *req
+	pre.Event = SnapshotHookPre
+	err := h.call(ctx, &pre)
+	if err != nil {
+		if !h.ignoreFailure {
+			return fmt.Errorf("%w: %w", ErrSnapshotHookFailed, err)
+		}
+		log.WarningLog(ctx, "ignoring failure of %s hook for %q: %v", SnapshotHookPre, req.Name, err)
+	}
+
+	snapErr := snapshot()
+
+	post := *req
+	post.Event = SnapshotHookPost
+	if snapErr != nil {
+		post.Error = snapErr.Error()
+	}
+	err = h.call(ctx, &post)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to call %s hook for %q: %v", SnapshotHookPost, req.Name, err)
+	}
+
+	return snapErr
+}
+
+// call posts the request to the webhook, any response status other than 2xx
+// is a failure.
+func (h *SnapshotHook) call(ctx context.Context, req *SnapshotHookRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s hook request: %w", req.Event, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s hook request: %w", req.Event, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to call %s hook: %w", req.Event, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return fmt.Errorf("%s hook returned %s: %s", req.Event, resp.Status, bytes.TrimSpace(msg))
+	}
+	log.DebugLog(ctx, "%s hook for %q succeeded", req.Event, req.Name)
+
+	return nil
+}
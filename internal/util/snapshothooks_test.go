@@ -0,0 +1,143 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ceph/ceph-csi/api/deploy/kubernetes"
+)
+
+// hookServer records the events it receives, and fails the events in fail.
+type hookServer struct {
+	mu     sync.Mutex
+	events []string
+	fail   map[string]bool
+}
+
+func (hs *hookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := SnapshotHookRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.events = append(hs.events, req.Event+":"+req.Error)
+	if hs.fail[req.Event] {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func TestNewSnapshotHook(t *testing.T) {
+	t.Parallel()
+
+	hook, err := newSnapshotHook(&kubernetes.SnapshotHooks{})
+	require.NoError(t, err)
+	require.Nil(t, hook)
+
+	hook, err = newSnapshotHook(&kubernetes.SnapshotHooks{URL: "http://hook", Timeout: "5s", FailurePolicy: "Ignore"})
+	require.NoError(t, err)
+	require.True(t, hook.ignoreFailure)
+
+	_, err = newSnapshotHook(&kubernetes.SnapshotHooks{URL: "http://hook", Timeout: "soon"})
+	require.Error(t, err)
+
+	_, err = newSnapshotHook(&kubernetes.SnapshotHooks{URL: "http://hook", FailurePolicy: "Retry"})
+	require.Error(t, err)
+}
+
+func TestSnapshotHookRun(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	req := &SnapshotHookRequest{ClusterID: "cluster", Name: "snap", SourceVolumeIDs: []string{"vol"}}
+
+	t.Run("nil hook", func(t *testing.T) {
+		t.Parallel()
+
+		var hook *SnapshotHook
+		taken := false
+		err := hook.Run(ctx, req, func() error {
+			taken = true
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, taken)
+	})
+
+	t.Run("snapshot failed", func(t *testing.T) {
+		t.Parallel()
+
+		hs := &hookServer{}
+		srv := httptest.NewServer(hs)
+		defer srv.Close()
+
+		hook, err := newSnapshotHook(&kubernetes.SnapshotHooks{URL: srv.URL})
+		require.NoError(t, err)
+
+		snapErr := errors.New("no space")
+		err = hook.Run(ctx, req, func() error {
+			return snapErr
+		})
+		require.ErrorIs(t, err, snapErr)
+		require.Equal(t, []string{SnapshotHookPre + ":", SnapshotHookPost + ":no space"}, hs.events)
+	})
+
+	t.Run("pre-snapshot hook failed", func(t *testing.T) {
+		t.Parallel()
+
+		hs := &hookServer{fail: map[string]bool{SnapshotHookPre: true}}
+		srv := httptest.NewServer(hs)
+		defer srv.Close()
+
+		hook, err := newSnapshotHook(&kubernetes.SnapshotHooks{URL: srv.URL})
+		require.NoError(t, err)
+
+		taken := false
+		err = hook.Run(ctx, req, func() error {
+			taken = true
+
+			return nil
+		})
+		require.ErrorIs(t, err, ErrSnapshotHookFailed)
+		require.False(t, taken)
+		require.Equal(t, []string{SnapshotHookPre + ":"}, hs.events)
+
+		// the snapshot is taken anyway with the Ignore policy
+		hook.ignoreFailure = true
+		err = hook.Run(ctx, req, func() error {
+			taken = true
+
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, taken)
+	})
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// maxSnapshotsPerVolumeKey is the VolumeSnapshotClass parameter that limits
+// the number of snapshots of a single volume.
+const maxSnapshotsPerVolumeKey = "maxSnapshotsPerVolume"
+
+// GetMaxSnapshotsPerVolume returns the maximum number of snapshots a volume
+// may have. The parameter of the VolumeSnapshotClass takes precedence over
+// defaultLimit, which is the value of the driver flag. A limit of 0 means
+// unlimited.
+func GetMaxSnapshotsPerVolume(parameters map[string]string, defaultLimit uint) (uint, error) {
+	value, ok := parameters[maxSnapshotsPerVolumeKey]
+	if !ok || value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s %q: %w", maxSnapshotsPerVolumeKey, value, err)
+	}
+
+	return uint(limit), nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMaxSnapshotsPerVolume(t *testing.T) {
+	t.Parallel()
+
+	limit, err := GetMaxSnapshotsPerVolume(nil, 10)
+	require.NoError(t, err)
+	require.Equal(t, uint(10), limit)
+
+	limit, err = GetMaxSnapshotsPerVolume(map[string]string{maxSnapshotsPerVolumeKey: "3"}, 10)
+	require.NoError(t, err)
+	require.Equal(t, uint(3), limit)
+
+	// the VolumeSnapshotClass can lift the limit of the driver
+	limit, err = GetMaxSnapshotsPerVolume(map[string]string{maxSnapshotsPerVolumeKey: "0"}, 10)
+	require.NoError(t, err)
+	require.Equal(t, uint(0), limit)
+
+	_, err = GetMaxSnapshotsPerVolume(map[string]string{maxSnapshotsPerVolumeKey: "-1"}, 10)
+	require.Error(t, err)
+}
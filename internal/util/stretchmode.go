@@ -0,0 +1,203 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// osdDumpOutput is the part of the JSON output of `ceph osd dump` that is
+// used.
+type osdDumpOutput struct {
+	StretchMode struct {
+		Enabled  bool `json:"stretch_mode_enabled"`
+		Degraded int  `json:"degraded_stretch_mode"`
+	} `json:"stretch_mode"`
+	Pools []osdDumpPool `json:"pools"`
+}
+
+// osdDumpPool is a pool in the output of `ceph osd dump`.
+type osdDumpPool struct {
+	Name      string `json:"pool_name"`
+	CrushRule int    `json:"crush_rule"`
+}
+
+// crushRuleDumpOutput is the part of the JSON output of
+// `ceph osd crush rule dump` that is used.
+type crushRuleDumpOutput []struct {
+	RuleID int `json:"rule_id"`
+	Steps  []struct {
+		Op       string `json:"op"`
+		ItemName string `json:"item_name"`
+	} `json:"steps"`
+}
+
+// osdTreeOutput is the part of the JSON output of `ceph osd tree` that is
+// used.
+type osdTreeOutput struct {
+	Nodes []struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		Status   string `json:"status"`
+		Children []int  `json:"children"`
+	} `json:"nodes"`
+}
+
+// GetStretchPrimarySite returns the CRUSH bucket of the site that holds the
+// primary OSDs of the pool, when the cluster runs in stretch mode. The CRUSH
+// rule of a stretch pool takes the sites in order, the first site holds the
+// primary OSDs. In degraded stretch mode, the first site that still has OSDs
+// up is returned. An empty site is returned when the cluster does not run in
+// stretch mode, or the rule of the pool does not take the sites in a fixed
+// order.
+func (cc *ClusterConnection) GetStretchPrimarySite(poolName string) (string, error) {
+	if cc.conn == nil {
+		return "", errors.New("cluster is not connected yet")
+	}
+
+	var osdDump osdDumpOutput
+	err := cc.monCommandJSON("osd dump", &osdDump)
+	if err != nil {
+		return "", err
+	}
+	if !osdDump.StretchMode.Enabled {
+		return "", nil
+	}
+
+	var rules crushRuleDumpOutput
+	err = cc.monCommandJSON("osd crush rule dump", &rules)
+	if err != nil {
+		return "", err
+	}
+
+	sites, err := stretchSites(&osdDump, rules, poolName)
+	if err != nil || len(sites) == 0 {
+		return "", err
+	}
+
+	if osdDump.StretchMode.Degraded != 0 {
+		var tree osdTreeOutput
+		err = cc.monCommandJSON("osd tree", &tree)
+		if err != nil {
+			return "", err
+		}
+		sites = slices.DeleteFunc(sites, func(site string) bool {
+			return !tree.hasOSDUp(site)
+		})
+	}
+
+	if len(sites) == 0 {
+		return "", fmt.Errorf("no site with OSDs up for pool %q in stretch mode", poolName)
+	}
+
+	return sites[0], nil
+}
+
+// monCommandJSON runs the mon command with the given prefix, and parses its
+// JSON output into v.
+func (cc *ClusterConnection) monCommandJSON(prefix string, v any) error {
+	cmd, err := json.Marshal(map[string]string{
+		"prefix": prefix,
+		"format": "json",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s command: %w", prefix, err)
+	}
+
+	done := cc.TrackCall("mon_command")
+	out, status, err := cc.conn.MonCommand(cmd)
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to run %s (%s): %w", prefix, status, err)
+	}
+
+	err = json.Unmarshal(out, v)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s output: %w", prefix, err)
+	}
+
+	return nil
+}
+
+// stretchSites returns the CRUSH buckets that the rule of the pool takes, in
+// the order of the rule. No sites are returned for a rule that takes a single
+// bucket, CRUSH places the primary OSDs of its placement groups in any site.
+func stretchSites(osdDump *osdDumpOutput, rules crushRuleDumpOutput, poolName string) ([]string, error) {
+	i := slices.IndexFunc(osdDump.Pools, func(p osdDumpPool) bool {
+		return p.Name == poolName
+	})
+	if i == -1 {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, poolName)
+	}
+	ruleID := osdDump.Pools[i].CrushRule
+
+	for _, rule := range rules {
+		if rule.RuleID != ruleID {
+			continue
+		}
+
+		sites := []string{}
+		for _, step := range rule.Steps {
+			if step.Op == "take" && !slices.Contains(sites, step.ItemName) {
+				sites = append(sites, step.ItemName)
+			}
+		}
+
+		if len(sites) < 2 {
+			return nil, nil
+		}
+
+		return sites, nil
+	}
+
+	return nil, fmt.Errorf("crush rule %d of pool %q not found", ruleID, poolName)
+}
+
+// hasOSDUp returns true when an OSD below the CRUSH bucket is up.
+func (t *osdTreeOutput) hasOSDUp(bucket string) bool {
+	nodes := make(map[int]int, len(t.Nodes))
+	root := -1
+	for i, n := range t.Nodes {
+		nodes[n.ID] = i
+		if n.Name == bucket {
+			root = i
+		}
+	}
+	if root == -1 {
+		return false
+	}
+
+	pending := []int{root}
+	for len(pending) != 0 {
+		n := t.Nodes[pending[0]]
+		pending = pending[1:]
+		if n.Type == "osd" && n.Status == "up" {
+			return true
+		}
+		for _, child := range n.Children {
+			if i, ok := nodes[child]; ok {
+				pending = append(pending, i)
+			}
+		}
+	}
+
+	return false
+}
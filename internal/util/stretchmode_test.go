@@ -0,0 +1,88 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStretchSites(t *testing.T) {
+	t.Parallel()
+
+	var osdDump osdDumpOutput
+	err := json.Unmarshal([]byte(`{
+		"stretch_mode": {"stretch_mode_enabled": true, "degraded_stretch_mode": 0},
+		"pools": [
+			{"pool_name": "replicapool", "crush_rule": 1},
+			{"pool_name": "other", "crush_rule": 5},
+			{"pool_name": "unordered", "crush_rule": 0}
+		]
+	}`), &osdDump)
+	require.NoError(t, err)
+
+	var rules crushRuleDumpOutput
+	err = json.Unmarshal([]byte(`[
+		{"rule_id": 0, "steps": [{"op": "take", "item_name": "default"}]},
+		{"rule_id": 1, "steps": [
+			{"op": "take", "item_name": "site2"},
+			{"op": "chooseleaf_firstn", "type": "host"},
+			{"op": "emit"},
+			{"op": "take", "item_name": "site1"},
+			{"op": "chooseleaf_firstn", "type": "host"},
+			{"op": "emit"}
+		]}
+	]`), &rules)
+	require.NoError(t, err)
+
+	sites, err := stretchSites(&osdDump, rules, "replicapool")
+	require.NoError(t, err)
+	require.Equal(t, []string{"site2", "site1"}, sites)
+
+	_, err = stretchSites(&osdDump, rules, "missing")
+	require.ErrorIs(t, err, ErrPoolNotFound)
+
+	_, err = stretchSites(&osdDump, rules, "other")
+	require.Error(t, err)
+
+	sites, err = stretchSites(&osdDump, rules, "unordered")
+	require.NoError(t, err)
+	require.Empty(t, sites)
+}
+
+func TestOSDTreeHasOSDUp(t *testing.T) {
+	t.Parallel()
+
+	var tree osdTreeOutput
+	err := json.Unmarshal([]byte(`{"nodes": [
+		{"id": -1, "name": "default", "type": "root", "children": [-2, -3]},
+		{"id": -2, "name": "site1", "type": "datacenter", "children": [-4]},
+		{"id": -3, "name": "site2", "type": "datacenter", "children": [-5]},
+		{"id": -4, "name": "host-a", "type": "host", "children": [0]},
+		{"id": -5, "name": "host-b", "type": "host", "children": [1]},
+		{"id": 0, "name": "osd.0", "type": "osd", "status": "down"},
+		{"id": 1, "name": "osd.1", "type": "osd", "status": "up"}
+	]}`), &tree)
+	require.NoError(t, err)
+
+	require.False(t, tree.hasOSDUp("site1"))
+	require.True(t, tree.hasOSDUp("site2"))
+	require.True(t, tree.hasOSDUp("default"))
+	require.False(t, tree.hasOSDUp("missing"))
+}
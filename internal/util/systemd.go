@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// systemdRun is the command that starts transient units of systemd.
+const systemdRun = "systemd-run"
+
+// mountsViaSystemd is set when mount and map commands are executed in
+// transient scopes of the systemd of the host.
+var mountsViaSystemd atomic.Bool
+
+// EnableMountsViaSystemd makes ExecMountCommand execute the commands in
+// transient scopes of the systemd of the host. An error is returned when
+// systemd-run can not reach systemd, the container needs access to the
+// systemd socket and the cgroup hierarchy of the host for that.
+func EnableMountsViaSystemd(ctx context.Context) error {
+	_, stderr, err := ExecCommand(ctx, systemdRun, systemdScopeArgs("check", "true")...)
+	if err != nil {
+		return fmt.Errorf("systemd of the host is not available: %w (%s)", err, stderr)
+	}
+	mountsViaSystemd.Store(true)
+
+	return nil
+}
+
+// MountsViaSystemd returns true when mount and map commands are executed in
+// transient scopes of the systemd of the host.
+func MountsViaSystemd() bool {
+	return mountsViaSystemd.Load()
+}
+
+// systemdScopeArgs returns the arguments of systemd-run to execute program
+// in a transient scope. The scope is removed by systemd once the program and
+// the processes it started, like a FUSE daemon, exited.
+func systemdScopeArgs(description, program string, args ...string) []string {
+	scopeArgs := []string{
+		"--scope",
+		"--collect",
+		"--quiet",
+		"--description=Ceph-CSI " + description,
+		"--",
+		program,
+	}
+
+	return append(scopeArgs, args...)
+}
+
+// ExecMountCommand executes a command that mounts a filesystem or maps a
+// device, and returns separate stdout and stderr streams. When the network
+// namespace netPath is set, the command runs in it. When MountsViaSystemd is
+// enabled, the command runs in a transient scope of the systemd of the host,
+// so that the daemons it starts (ceph-fuse, rbd-nbd) are not stopped together
+// with the container when it is restarted.
+func ExecMountCommand(ctx context.Context, netPath, program string, args ...string) (string, string, error) {
+	if !MountsViaSystemd() {
+		if netPath != "" {
+			return ExecuteCommandWithNSEnter(ctx, netPath, program, args...)
+		}
+
+		return ExecCommand(ctx, program, args...)
+	}
+
+	description := program
+	if netPath != "" {
+		args = append([]string{"--net=" + netPath, "--", program}, args...)
+		program = "nsenter"
+	}
+
+	return ExecCommand(ctx, systemdRun, systemdScopeArgs(description, program, args...)...)
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemdScopeArgs(t *testing.T) {
+	t.Parallel()
+
+	args := systemdScopeArgs("ceph-fuse", "ceph-fuse", "/mnt", "-m", "mon:6789")
+	require.Equal(t, []string{
+		"--scope",
+		"--collect",
+		"--quiet",
+		"--description=Ceph-CSI ceph-fuse",
+		"--",
+		"ceph-fuse", "/mnt", "-m", "mon:6789",
+	}, args)
+}
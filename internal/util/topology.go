@@ -35,6 +35,115 @@ const (
 	topologyPoolsParam = "topologyConstrainedPools"
 )
 
+// topologyAliases maps the renamed domains to their new domain, it is set
+// with SetTopologyAliases.
+var topologyAliases = map[string]string{}
+
+// labelDomain returns the domain of a node label of the form [prefix/]<name>.
+func labelDomain(label string) string {
+	return label[strings.IndexRune(label, keySeparator)+1:]
+}
+
+// SetTopologyAliases configures the domain labels that were renamed on the
+// nodes. The aliases are in the format "<old-label>=<new-label>,...", where
+// the labels are in the format of the domain labels, "[prefix/]<name>".
+// Existing volumes keep the topology of the old domain, which nodes report
+// together with the new domain until the volumes are migrated.
+func SetTopologyAliases(aliases string) error {
+	topologyAliases = map[string]string{}
+	if aliases == "" {
+		return nil
+	}
+
+	for _, alias := range strings.Split(aliases, labelSeparator) {
+		oldLabel, newLabel, ok := strings.Cut(alias, "=")
+		if !ok || oldLabel == "" || newLabel == "" {
+			return fmt.Errorf("invalid domain label alias %q, expected <old-label>=<new-label>", alias)
+		}
+
+		oldDomain := labelDomain(oldLabel)
+		newDomain := labelDomain(newLabel)
+		if oldDomain == newDomain {
+			// the topology keys only contain the domain, renaming the
+			// prefix of a label does not change them
+			continue
+		}
+		if _, ok = topologyAliases[oldDomain]; ok {
+			return fmt.Errorf("duplicate domain label alias for %q", oldLabel)
+		}
+
+		topologyAliases[oldDomain] = newDomain
+	}
+
+	for oldDomain, newDomain := range topologyAliases {
+		if _, ok := topologyAliases[newDomain]; ok {
+			return fmt.Errorf("domain %q of alias for %q is renamed as well", newDomain, oldDomain)
+		}
+	}
+
+	log.DefaultLog("domain label aliases: %+v", topologyAliases)
+
+	return nil
+}
+
+// resolveDomain returns the new domain when the domain was renamed.
+func resolveDomain(domain string) string {
+	if newDomain, ok := topologyAliases[domain]; ok {
+		return newDomain
+	}
+
+	return domain
+}
+
+// AddTopologyAliases adds the old domains of the renamed domain labels to the
+// topology that is returned with NodeGetInfo, so that volumes that were
+// created with the old domain can still be used on the node.
+func AddTopologyAliases(topology map[string]string, driverName string) map[string]string {
+	for oldDomain, newDomain := range topologyAliases {
+		value, ok := topology[TopologyKey(driverName, newDomain)]
+		if !ok {
+			continue
+		}
+
+		topology[TopologyKey(driverName, oldDomain)] = value
+	}
+
+	return topology
+}
+
+// resolveTopologyAliases returns a copy of the topology segments of a
+// CreateVolume request, with the keys of renamed domains replaced by the key
+// of their new domain. New volumes get the topology of the new domain only.
+func resolveTopologyAliases(segments map[string]string) map[string]string {
+	if len(topologyAliases) == 0 {
+		return segments
+	}
+
+	resolved := make(map[string]string, len(segments))
+	for key, value := range segments {
+		prefix, domain, ok := strings.Cut(key, string(keySeparator))
+		if !ok {
+			resolved[key] = value
+
+			continue
+		}
+
+		newDomain := resolveDomain(domain)
+		if newDomain == domain {
+			resolved[key] = value
+
+			continue
+		}
+
+		newKey := prefix + string(keySeparator) + newDomain
+		if _, exists := segments[newKey]; !exists {
+			resolved[newKey] = value
+		}
+	}
+
+	return resolved
+}
+
 // GetTopologyFromDomainLabels returns the CSI topology map, determined from
 // the domain labels and their values from the CO system
 // Expects domainLabels in arg to be in the format "[prefix/]<name>,[prefix/]<name>,...",.
@@ -197,7 +306,7 @@ func FindPoolAndTopology(topologyPools *[]TopologyConstrainedPool,
 	for _, topology := range accessibilityRequirements.GetPreferred() {
 		topologyPool := matchPoolToTopology(topologyPools, topology)
 		if topologyPool.PoolName != "" {
-			return topologyPool.PoolName, topologyPool.DataPoolName, topology.GetSegments(), nil
+			return topologyPool.PoolName, topologyPool.DataPoolName, resolveTopologyAliases(topology.GetSegments()), nil
 		}
 	}
 
@@ -205,7 +314,7 @@ func FindPoolAndTopology(topologyPools *[]TopologyConstrainedPool,
 	for _, topology := range accessibilityRequirements.GetRequisite() {
 		topologyPool := matchPoolToTopology(topologyPools, topology)
 		if topologyPool.PoolName != "" {
-			return topologyPool.PoolName, topologyPool.DataPoolName, topology.GetSegments(), nil
+			return topologyPool.PoolName, topologyPool.DataPoolName, resolveTopologyAliases(topology.GetSegments()), nil
 		}
 	}
 
@@ -225,7 +334,8 @@ func matchPoolToTopology(topologyPools *[]TopologyConstrainedPool, topology *csi
 		mismatch := false
 		// match all pool topology labels to requested topology
 		for _, segment := range topologyPool.DomainSegments {
-			if domainValue, ok := domainMap[segment.DomainLabel]; !ok || domainValue != segment.DomainValue {
+			domainValue, ok := domainMap[resolveDomain(segment.DomainLabel)]
+			if !ok || domainValue != segment.DomainValue {
 				mismatch = true
 
 				break
@@ -243,14 +353,26 @@ func matchPoolToTopology(topologyPools *[]TopologyConstrainedPool, topology *csi
 }
 
 // extractDomainsFromlabels returns the domain name map, from passed in domain segments,
-// which is of the form [prefix/]<name>.
+// which is of the form [prefix/]<name>. Renamed domains are stored as their
+// new domain.
 func extractDomainsFromlabels(topology *csi.Topology) map[string]string {
 	domainMap := make(map[string]string)
 	for domainKey, value := range topology.GetSegments() {
-		domainIdx := strings.IndexRune(domainKey, keySeparator)
-		domain := domainKey[domainIdx+1:]
-		domainMap[domain] = value
+		domain := labelDomain(domainKey)
+		newDomain := resolveDomain(domain)
+		if _, ok := domainMap[newDomain]; ok && newDomain != domain {
+			// the value of the new domain is used when both exist
+			continue
+		}
+
+		domainMap[newDomain] = value
 	}
 
 	return domainMap
 }
+
+// TopologyKey returns the key of a topology segment for the domain, like the
+// ones that GetTopologyFromDomainLabels returns for the labels of a node.
+func TopologyKey(driverName, domain string) string {
+	return strings.ToLower("topology."+driverName) + "/" + domain
+}
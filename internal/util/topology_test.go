@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
 )
 
 func checkError(t *testing.T, msg string, err error) {
@@ -332,6 +333,71 @@ func TestFindPoolAndTopology(t *testing.T) {
 	checkAndReportError(t, "expected success got:", err)
 }
 
+//nolint:paralleltest // topologyAliases is replaced
+func TestTopologyAliases(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, SetTopologyAliases(""))
+	})
+
+	require.Error(t, SetTopologyAliases("prefix/rack"))
+	require.Error(t, SetTopologyAliases("=prefix/zone"))
+	require.Error(t, SetTopologyAliases("prefix/rack=prefix/zone,other/rack=prefix/room"))
+	require.Error(t, SetTopologyAliases("prefix/rack=prefix/zone,prefix/zone=prefix/room"))
+
+	// renaming the prefix does not change the topology keys
+	require.NoError(t, SetTopologyAliases("failure-domain.beta.kubernetes.io/zone=topology.kubernetes.io/zone"))
+	require.Empty(t, topologyAliases)
+
+	require.NoError(t, SetTopologyAliases("prefix/rack=prefix/zone"))
+
+	// nodes report the old domain together with the new one
+	topology := AddTopologyAliases(map[string]string{
+		TopologyKey("rbd.csi.ceph.com", "zone"):   "Z1",
+		TopologyKey("rbd.csi.ceph.com", "region"): "R1",
+	}, "rbd.csi.ceph.com")
+	require.Equal(t, map[string]string{
+		"topology.rbd.csi.ceph.com/zone":   "Z1",
+		"topology.rbd.csi.ceph.com/rack":   "Z1",
+		"topology.rbd.csi.ceph.com/region": "R1",
+	}, topology)
+	require.Nil(t, AddTopologyAliases(nil, "rbd.csi.ceph.com"))
+
+	// pools that are configured with the old domain match the new domain,
+	// new volumes only get the new domain
+	pools := []TopologyConstrainedPool{
+		{
+			PoolName:       "PoolA",
+			DomainSegments: []topologySegment{{DomainLabel: "rack", DomainValue: "Z2"}},
+		},
+		{
+			PoolName:       "PoolB",
+			DomainSegments: []topologySegment{{DomainLabel: "zone", DomainValue: "Z1"}},
+		},
+	}
+	for _, segments := range []map[string]string{
+		{"prefix/zone": "Z1"},
+		{"prefix/rack": "Z1"},
+		{"prefix/rack": "Z1", "prefix/zone": "Z1"},
+	} {
+		requirement := &csi.TopologyRequirement{
+			Preferred: []*csi.Topology{{Segments: segments}},
+		}
+		poolName, _, topology, err := FindPoolAndTopology(&pools, requirement)
+		require.NoError(t, err)
+		require.Equal(t, "PoolB", poolName)
+		require.Equal(t, map[string]string{"prefix/zone": "Z1"}, topology)
+	}
+
+	requirement := &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{{Segments: map[string]string{"prefix/rack": "Z2"}}},
+	}
+	poolName, _, topology, err := FindPoolAndTopology(&pools, requirement)
+	require.NoError(t, err)
+	require.Equal(t, "PoolA", poolName)
+	require.Equal(t, map[string]string{"prefix/zone": "Z2"}, topology)
+	require.Equal(t, map[string]string{"prefix/rack": "Z2"}, requirement.GetPreferred()[0].GetSegments())
+}
+
 /*
 // TODO: To test GetTopologyFromDomainLabels we need it to accept a k8s client interface, to mock k8sGetNdeLabels output
 func TestGetTopologyFromDomainLabels(t *testing.T) {
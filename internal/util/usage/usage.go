@@ -0,0 +1,255 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package usage aggregates the provisioned and used capacity of the volumes
+// per Kubernetes namespace, and publishes it as metrics and in a ConfigMap.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	kubeclient "github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// UnknownNamespace is used for volumes that were created without the PVC
+// namespace in the parameters of the CreateVolume request.
+const UnknownNamespace = "_unknown"
+
+// NamespaceUsage is the capacity of the volumes of a namespace.
+type NamespaceUsage struct {
+	Volumes          int   `json:"volumes"`
+	ProvisionedBytes int64 `json:"provisionedBytes"`
+	UsedBytes        int64 `json:"usedBytes"`
+}
+
+// Report contains the usage of all namespaces.
+type Report struct {
+	Namespaces map[string]*NamespaceUsage
+}
+
+// NewReport returns an empty Report.
+func NewReport() *Report {
+	return &Report{
+		Namespaces: make(map[string]*NamespaceUsage),
+	}
+}
+
+// Add accounts a volume to the namespace.
+func (r *Report) Add(namespace string, provisioned, used int64) {
+	if namespace == "" {
+		namespace = UnknownNamespace
+	}
+
+	nu, ok := r.Namespaces[namespace]
+	if !ok {
+		nu = &NamespaceUsage{}
+		r.Namespaces[namespace] = nu
+	}
+
+	nu.Volumes++
+	nu.ProvisionedBytes += provisioned
+	nu.UsedBytes += used
+}
+
+// configMapData returns the usage of every namespace as JSON, keyed by the
+// name of the namespace.
+func (r *Report) configMapData() (map[string]string, error) {
+	data := make(map[string]string, len(r.Namespaces))
+	for ns, nu := range r.Namespaces {
+		value, err := json.Marshal(nu)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal usage of namespace %q: %w", ns, err)
+		}
+		data[ns] = string(value)
+	}
+
+	return data, nil
+}
+
+// Collector gathers the usage of all volumes of a driver.
+type Collector func(ctx context.Context) (*Report, error)
+
+// Reporter collects the usage at an interval, and publishes it.
+type Reporter struct {
+	driverName string
+	interval   time.Duration
+	collect    Collector
+
+	// client, configMapNamespace and configMapName are set when the
+	// report is written to a ConfigMap
+	client             *k8s.Clientset
+	configMapNamespace string
+	configMapName      string
+
+	volumes     *prometheus.GaugeVec
+	provisioned *prometheus.GaugeVec
+	used        *prometheus.GaugeVec
+}
+
+// NewReporter returns a Reporter that registers its metrics with prometheus.
+func NewReporter(driverName string, interval time.Duration, collect Collector) (*Reporter, error) {
+	newGaugeVec := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "csi",
+			Subsystem: "namespace",
+			Name:      name,
+			Help:      help,
+		}, []string{"driver", "namespace"})
+	}
+
+	r := &Reporter{
+		driverName:  driverName,
+		interval:    interval,
+		collect:     collect,
+		volumes:     newGaugeVec("volumes", "Number of volumes in the namespace"),
+		provisioned: newGaugeVec("provisioned_bytes", "Provisioned capacity of the volumes in the namespace"),
+		used:        newGaugeVec("used_bytes", "Used capacity of the volumes in the namespace"),
+	}
+
+	for _, c := range []prometheus.Collector{r.volumes, r.provisioned, r.used} {
+		err := prometheus.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register usage metrics: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// WithConfigMap writes every report to the ConfigMap, which is created when
+// it does not exist.
+func (r *Reporter) WithConfigMap(client *k8s.Clientset, namespace, name string) *Reporter {
+	r.client = client
+	r.configMapNamespace = namespace
+	r.configMapName = name
+
+	return r
+}
+
+// Run collects and publishes the usage until the context is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.report(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reporter) report(ctx context.Context) {
+	report, err := r.collect(ctx)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to collect usage of volumes: %v", err)
+
+		return
+	}
+
+	r.publishMetrics(report)
+
+	if r.client != nil {
+		err = r.writeConfigMap(ctx, report)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to write usage of volumes to ConfigMap %s/%s: %v",
+				r.configMapNamespace, r.configMapName, err)
+		}
+	}
+
+	namespaces := make([]string, 0, len(report.Namespaces))
+	for ns := range report.Namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	log.DebugLog(ctx, "usage of volumes collected for namespaces %v", namespaces)
+}
+
+// resetMetrics removes the usage of all namespaces from the metrics.
+func (r *Reporter) resetMetrics() {
+	r.volumes.Reset()
+	r.provisioned.Reset()
+	r.used.Reset()
+}
+
+func (r *Reporter) publishMetrics(report *Report) {
+	// namespaces without volumes should not be reported anymore
+	r.resetMetrics()
+
+	for ns, nu := range report.Namespaces {
+		r.volumes.WithLabelValues(r.driverName, ns).Set(float64(nu.Volumes))
+		r.provisioned.WithLabelValues(r.driverName, ns).Set(float64(nu.ProvisionedBytes))
+		r.used.WithLabelValues(r.driverName, ns).Set(float64(nu.UsedBytes))
+	}
+}
+
+func (r *Reporter) writeConfigMap(ctx context.Context, report *Report) error {
+	data, err := report.configMapData()
+	if err != nil {
+		return err
+	}
+
+	return kubeclient.WriteConfigMap(ctx, r.client, r.configMapNamespace, r.configMapName, data)
+}
+
+// Start runs a Reporter in the background, in the replica of the provisioner
+// that holds the lease of the driver in namespace. When configMapName is set,
+// the reports are written to the ConfigMap in namespace as well.
+func Start(
+	driverName string,
+	interval time.Duration,
+	namespace, configMapName string,
+	collect Collector,
+) error {
+	r, err := NewReporter(driverName, interval, collect)
+	if err != nil {
+		return err
+	}
+
+	client, err := kubeclient.NewK8sClient()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Kubernetes: %w", err)
+	}
+	if configMapName != "" {
+		r.WithConfigMap(client, namespace, configMapName)
+	}
+
+	lock, err := kubeclient.NewLeaseLock(client, namespace, driverName+"-usage-report")
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ctx := context.Background()
+		// the metrics are reset when the lease is lost
+		if rErr := kubeclient.RunElected(ctx, lock, r.Run, r.resetMetrics); rErr != nil {
+			log.ErrorLog(ctx, "usage reporting stopped: %v", rErr)
+		}
+	}()
+
+	return nil
+}
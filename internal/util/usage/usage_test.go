@@ -0,0 +1,40 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package usage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	t.Parallel()
+
+	r := NewReport()
+	r.Add("tenant-a", 1024, 512)
+	r.Add("tenant-a", 2048, 0)
+	r.Add("", 100, 10)
+
+	require.Len(t, r.Namespaces, 2)
+	require.Equal(t, &NamespaceUsage{Volumes: 2, ProvisionedBytes: 3072, UsedBytes: 512}, r.Namespaces["tenant-a"])
+	require.Equal(t, &NamespaceUsage{Volumes: 1, ProvisionedBytes: 100, UsedBytes: 10}, r.Namespaces[UnknownNamespace])
+
+	data, err := r.configMapData()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"volumes":2,"provisionedBytes":3072,"usedBytes":512}`, data["tenant-a"])
+}
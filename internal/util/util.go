@@ -86,7 +86,7 @@ var (
 
 // Config holds the parameters list which can be configured.
 type Config struct {
-	Vtype           string // driver type [rbd|cephfs|liveness|controller]
+	Vtype           string // driver type [rbd|cephfs|liveness|controller|webhook]
 	Endpoint        string // CSI endpoint
 	DriverName      string // name of the driver
 	DriverNamespace string // namespace in which driver is deployed
@@ -95,12 +95,44 @@ type Config struct {
 	PluginPath      string // location of cephcsi plugin
 	StagingPath     string // location of cephcsi staging path
 	DomainLabels    string // list of domain labels to read from the node
+	// DomainLabelAliases maps renamed domain labels to their new label,
+	// "<old-label>=<new-label>,..."
+	DomainLabelAliases string
 	// metrics related flags
 	MetricsPath string // path of prometheus endpoint where metrics will be available
 	MetricsIP   string // TCP port for liveness/ metrics requests
 
 	// CSI-Addons endpoint
 	CSIAddonsEndpoint string
+	// CSIAddonsTCPEndpoint is an optional TCP endpoint for the CSI-Addons
+	// services, the clients are authenticated with mutual TLS.
+	CSIAddonsTCPEndpoint string
+	// CSIAddonsTLSCertFile, CSIAddonsTLSKeyFile and CSIAddonsTLSCAFile are
+	// the certificate and key of the TCP endpoint, and the CA of the
+	// client certificates.
+	CSIAddonsTLSCertFile string
+	CSIAddonsTLSKeyFile  string
+	CSIAddonsTLSCAFile   string
+
+	// AdminEndpoint is the UNIX domain socket of the admin service of the
+	// provisioner and the PauseIO service of the nodeplugin, an empty
+	// endpoint disables the services.
+	AdminEndpoint string
+	// AdminCall and AdminRequest are the method and the JSON request that
+	// the admin driver type sends to the AdminEndpoint.
+	AdminCall    string
+	AdminRequest string
+
+	// InstancesFile is a JSON file with additional instances of the driver
+	// that are served by the process, see ReadDriverInstances.
+	InstancesFile string
+	// Instances are the additional instances of the driver that are read
+	// from the InstancesFile.
+	Instances []DriverInstance
+
+	// admission webhook related flags
+	WebhookPort    int    // TCP port for the admission webhook server
+	WebhookCertDir string // directory containing the TLS certificate and key for the webhook
 
 	// Cluster name
 	ClusterName string
@@ -134,6 +166,17 @@ type Config struct {
 	// Log interval for slow GRPC calls. Calls that outlive their context deadline
 	// are considered slow.
 	LogSlowOpInterval time.Duration
+	// SlowOpThresholds are the thresholds per gRPC method after which
+	// completed calls are logged as slow, in the format
+	// "<method>=<duration>" separated by ",".
+	SlowOpThresholds string
+
+	// NodeStageConcurrency and NodePublishConcurrency are the number of
+	// stage (NodeStageVolume, NodeUnstageVolume and NodeExpandVolume) and
+	// publish (NodePublishVolume and NodeUnpublishVolume) calls that are
+	// processed at a time, 0 does not limit them.
+	NodeStageConcurrency   uint
+	NodePublishConcurrency uint
 
 	EnableProfiling    bool // flag to enable profiling
 	IsControllerServer bool // if set to true start provisioner server
@@ -149,6 +192,124 @@ type Config struct {
 	RadosNamespaceCephFS string // RadosNamespace used to store CSI specific objects and keys
 	SetMetadata          bool   // set metadata on the volume
 
+	// UsageReportInterval is the interval at which the usage of the volumes
+	// is aggregated per namespace, 0 disables the usage reporting.
+	UsageReportInterval time.Duration
+	// UsageReportConfigMap is the name of the ConfigMap in the namespace
+	// of the driver where the usage report is written to.
+	UsageReportConfigMap string
+	// JournalStatsInterval is the interval at which the entries in the
+	// journals of the pools are counted, 0 disables the counting.
+	JournalStatsInterval time.Duration
+
+	// StatusReportInterval is the interval at which the status of the
+	// driver is written to the StatusReportConfigMap, 0 disables it.
+	StatusReportInterval time.Duration
+	// StatusReportConfigMap is the name of the ConfigMap in the namespace
+	// of the driver that receives the status.
+	StatusReportConfigMap string
+
+	// SnapshotPoolUsageThreshold rejects CreateSnapshot when the projected
+	// usage of the pool exceeds this fraction of its capacity, 0 disables
+	// the check.
+	SnapshotPoolUsageThreshold float64
+
+	// MaxSnapshotsPerVolume limits the number of snapshots of a single
+	// volume, 0 means unlimited.
+	MaxSnapshotsPerVolume uint
+
+	// GRPCMaxMessageSize is the maximum size of the gRPC messages of the
+	// CSI endpoint, the List responses are split into pages that fit in it.
+	// 0 keeps the default of gRPC.
+	GRPCMaxMessageSize int
+	// ListMaxEntries limits the number of entries in a List response, also
+	// when the request does not set max_entries. 0 means unlimited.
+	ListMaxEntries int64
+
+	// ClusterReadinessInterval is the interval at which the connectivity to
+	// the clusters of the StorageClasses is checked for the readiness
+	// endpoint, 0 disables the checks.
+	ClusterReadinessInterval time.Duration
+
+	// ValidateClusters checks the clusters, pools and journals of the
+	// StorageClasses once at the start, when ClusterReadinessInterval is 0.
+	ValidateClusters bool
+
+	// ClusterReadinessSelector is a label selector for the StorageClasses
+	// that are checked for the readiness endpoint.
+	ClusterReadinessSelector string
+
+	// KMSHealthInterval is the interval at which the connectivity to the
+	// configured KMS is checked, 0 disables the checks.
+	KMSHealthInterval time.Duration
+
+	// CephFSClientMetrics publishes the counters of the CephFS kernel
+	// client of every staged volume on the metrics endpoint.
+	CephFSClientMetrics bool
+
+	// RBDIOStatsInterval is the interval at which the IO rates of the RBD
+	// images of the driver are sampled from the manager, 0 disables it.
+	RBDIOStatsInterval time.Duration
+
+	// RBDImageReconcileInterval is the interval at which the desired
+	// configuration of the volumes in the journal is re-applied to their
+	// images, 0 disables it.
+	RBDImageReconcileInterval time.Duration
+
+	// RBDTempCloneReapInterval is the interval at which orphaned temporary
+	// clones of RBD volumes are deleted, 0 disables it.
+	RBDTempCloneReapInterval time.Duration
+	// RBDTempCloneTTL is the age of a temporary clone of a volume without a
+	// (recently refreshed) reservation after which it is deleted.
+	RBDTempCloneTTL time.Duration
+
+	// NFSVerifyExports enables ControllerPublishVolume for NFS, which
+	// creates the NFS-export of a volume again when it is missing.
+	NFSVerifyExports bool
+	// NFSMigrateExports enables ControllerPublishVolume for NFS, which adds
+	// an NFS-export with the current path for volumes of older releases.
+	NFSMigrateExports bool
+
+	// ReclaimSpaceMinInterval is the time after a ReclaimSpace operation on
+	// a volume during which the operation is skipped for the volume, 0
+	// disables the check.
+	ReclaimSpaceMinInterval time.Duration
+
+	// StuckLockThreshold is the time after which locks of volumes,
+	// snapshots and volume groups that are still held are reported as
+	// stuck, 0 disables the detection.
+	StuckLockThreshold time.Duration
+	// ReclaimSpaceBatchConcurrency enables the CSI-Addons service that
+	// reclaims the space of all staged volumes on the node, with the number
+	// of volumes that are trimmed at a time. 0 disables the service.
+	ReclaimSpaceBatchConcurrency uint
+
+	// PauseIOMaxTTL enables the service of the nodeplugin that pauses the
+	// IO of staged volumes by freezing their filesystem, for at most this
+	// long. 0 disables the service.
+	PauseIOMaxTTL time.Duration
+
+	// EnableFailoverDrill enables the endpoint of the provisioner that
+	// clones secondary images for failover tests, without promoting them.
+	EnableFailoverDrill bool
+
+	// ReadAheadKB is the readahead of the volumes that are staged by the
+	// nodeplugin, 0 keeps the default of the kernel or client.
+	ReadAheadKB uint
+
+	// VolumeStatsCacheMaxAge is the time the nodeplugin returns the cached
+	// stats of a volume in NodeGetVolumeStats before refreshing them, 0
+	// disables the cache.
+	VolumeStatsCacheMaxAge time.Duration
+
+	// PassphraseCacheTTL is the time the nodeplugin keeps the passphrases
+	// of encrypted volumes in memory, 0 disables the cache.
+	PassphraseCacheTTL time.Duration
+
+	// MountProbeTimeout is the time a probe of a freshly staged CephFS
+	// volume may take, 0 disables the probe.
+	MountProbeTimeout time.Duration
+
 	// Read affinity related options
 	EnableReadAffinity  bool   // enable OSD read affinity.
 	CrushLocationLabels string // list of CRUSH location labels to read from the node.
@@ -0,0 +1,210 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/kms"
+	"github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/k8s"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	snapapi "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	poolKey            = "pool"
+	mounterKey         = "mounter"
+	imageFeaturesKey   = "imageFeatures"
+	encryptedKey       = "encrypted"
+	encryptionKMSIDKey = "encryptionKMSID"
+
+	provisionerSecretNameKey      = "csi.storage.k8s.io/provisioner-secret-name"
+	provisionerSecretNamespaceKey = "csi.storage.k8s.io/provisioner-secret-namespace"
+	snapshotterSecretNameKey      = "csi.storage.k8s.io/snapshotter-secret-name"
+	snapshotterSecretNamespaceKey = "csi.storage.k8s.io/snapshotter-secret-namespace"
+)
+
+// getSecretFunc returns the contents of the Secret name in namespace.
+type getSecretFunc func(ctx context.Context, name, namespace string) (map[string]string, error)
+
+// validator checks the parameters of StorageClass and VolumeSnapshotClass
+// objects that are handled by driverName.
+type validator struct {
+	driverName string
+	getSecret  getSecretFunc
+}
+
+func newValidator(driverName string) *validator {
+	return &validator{
+		driverName: driverName,
+		getSecret:  getK8sSecret,
+	}
+}
+
+func getK8sSecret(ctx context.Context, name, namespace string) (map[string]string, error) {
+	c, err := k8s.NewK8sClient()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := c.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+
+	secrets := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		secrets[key] = string(value)
+	}
+
+	return secrets, nil
+}
+
+func (v *validator) validateStorageClass(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		return admission.Allowed("")
+	}
+
+	sc := &storagev1.StorageClass{}
+	err := json.Unmarshal(req.Object.Raw, sc)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if sc.Provisioner != v.driverName {
+		return admission.Allowed("")
+	}
+
+	err = v.validateParameters(ctx, sc.Parameters, provisionerSecretNameKey, provisionerSecretNamespaceKey)
+	if err != nil {
+		log.ErrorLog(ctx, "denied StorageClass %q: %v", sc.Name, err)
+
+		return admission.Denied(fmt.Sprintf("invalid parameters for %s: %v", v.driverName, err))
+	}
+
+	return admission.Allowed("")
+}
+
+func (v *validator) validateVolumeSnapshotClass(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		return admission.Allowed("")
+	}
+
+	vsc := &snapapi.VolumeSnapshotClass{}
+	err := json.Unmarshal(req.Object.Raw, vsc)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if vsc.Driver != v.driverName {
+		return admission.Allowed("")
+	}
+
+	err = v.validateParameters(ctx, vsc.Parameters, snapshotterSecretNameKey, snapshotterSecretNamespaceKey)
+	if err != nil {
+		log.ErrorLog(ctx, "denied VolumeSnapshotClass %q: %v", vsc.Name, err)
+
+		return admission.Denied(fmt.Sprintf("invalid parameters for %s: %v", v.driverName, err))
+	}
+
+	return admission.Allowed("")
+}
+
+// validateParameters runs the checks that would otherwise only fail once the
+// first volume or snapshot is created with the parameters.
+func (v *validator) validateParameters(
+	ctx context.Context,
+	params map[string]string,
+	secretNameKey,
+	secretNamespaceKey string,
+) error {
+	clusterID, err := util.GetClusterID(params)
+	if err != nil {
+		return err
+	}
+
+	if features, ok := params[imageFeaturesKey]; ok {
+		err = rbd.ValidateImageFeatures(features, params[mounterKey])
+		if err != nil {
+			return err
+		}
+	}
+
+	monitors, err := util.Mons(util.CsiConfigFile, clusterID)
+	if err != nil {
+		return fmt.Errorf("unknown clusterID %q: %w", clusterID, err)
+	}
+
+	if val, ok := params[encryptedKey]; ok {
+		encrypted, pErr := strconv.ParseBool(val)
+		if pErr != nil {
+			return fmt.Errorf("invalid value %q for parameter %s: %w", val, encryptedKey, pErr)
+		}
+
+		if encrypted {
+			err = kms.ValidateKMSID(params[encryptionKMSIDKey])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if pool := params[poolKey]; pool != "" {
+		return v.validatePool(ctx, monitors, pool, params[secretNameKey], params[secretNamespaceKey])
+	}
+
+	return nil
+}
+
+// validatePool verifies that the pool exists in the Ceph cluster. The check
+// is skipped when the credentials can not be resolved at admission time.
+func (v *validator) validatePool(ctx context.Context, monitors, pool, secretName, secretNamespace string) error {
+	// templated secret names are only resolved by the provisioner
+	if secretName == "" || secretNamespace == "" ||
+		strings.Contains(secretName, "${") || strings.Contains(secretNamespace, "${") {
+		log.WarningLog(ctx, "no static secret configured, not verifying existence of pool %q", pool)
+
+		return nil
+	}
+
+	secrets, err := v.getSecret(ctx, secretName, secretNamespace)
+	if err != nil {
+		return err
+	}
+
+	cr, err := util.NewAdminCredentials(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to get credentials from secret %s/%s: %w", secretNamespace, secretName, err)
+	}
+	defer cr.DeleteCredentials()
+
+	_, err = util.GetPoolID(monitors, cr, pool)
+
+	return err
+}
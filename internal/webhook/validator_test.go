@@ -0,0 +1,91 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateStorageClass(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		provisioner string
+		params      map[string]string
+		allowed     bool
+	}{
+		{
+			name:        "other provisioner",
+			provisioner: "example.com",
+			params:      map[string]string{},
+			allowed:     true,
+		},
+		{
+			name:        "missing clusterID",
+			provisioner: "rbd.csi.ceph.com",
+			params:      map[string]string{"pool": "replicapool"},
+			allowed:     false,
+		},
+		{
+			name:        "invalid imageFeatures",
+			provisioner: "rbd.csi.ceph.com",
+			params: map[string]string{
+				"clusterID":     "cluster-1",
+				"imageFeatures": "layering,invalid",
+			},
+			allowed: false,
+		},
+		{
+			name:        "imageFeatures with missing dependency",
+			provisioner: "rbd.csi.ceph.com",
+			params: map[string]string{
+				"clusterID":     "cluster-1",
+				"imageFeatures": "layering,fast-diff",
+			},
+			allowed: false,
+		},
+	}
+
+	v := newValidator("rbd.csi.ceph.com")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw, err := json.Marshal(&storagev1.StorageClass{
+				Provisioner: tt.provisioner,
+				Parameters:  tt.params,
+			})
+			require.NoError(t, err)
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}}
+			resp := v.validateStorageClass(context.TODO(), req)
+			require.Equal(t, tt.allowed, resp.Allowed)
+		})
+	}
+}
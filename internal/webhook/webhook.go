@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// StorageClassPath is the path where StorageClass admission requests
+	// are served.
+	StorageClassPath = "/validate-storageclass"
+	// VolumeSnapshotClassPath is the path where VolumeSnapshotClass
+	// admission requests are served.
+	VolumeSnapshotClassPath = "/validate-volumesnapshotclass"
+)
+
+// Run starts the validating admission webhook server. Only classes that have
+// conf.DriverName as provisioner (or driver) are validated, all others are
+// admitted without inspection.
+func Run(conf *util.Config) {
+	v := newValidator(conf.DriverName)
+
+	srv := webhook.NewServer(webhook.Options{
+		Port:    conf.WebhookPort,
+		CertDir: conf.WebhookCertDir,
+	})
+	srv.Register(StorageClassPath, &admission.Webhook{
+		Handler: admission.HandlerFunc(v.validateStorageClass),
+	})
+	srv.Register(VolumeSnapshotClassPath, &admission.Webhook{
+		Handler: admission.HandlerFunc(v.validateVolumeSnapshotClass),
+	})
+
+	log.DefaultLog("Starting admission webhook for driver %q on port %d", conf.DriverName, conf.WebhookPort)
+	err := srv.Start(signals.SetupSignalHandler())
+	if err != nil {
+		log.FatalLogMsg("failed to run admission webhook server: %v", err)
+	}
+}